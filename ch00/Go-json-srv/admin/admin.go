@@ -0,0 +1,269 @@
+// Package admin — админский HTTP API сервера и клиент к нему (srvctl).
+//
+// API слушает отдельный адрес, только на loopback, и требует
+// Authorization: Bearer <токен из окружения>. Пустой токен — API выключен.
+//
+//	GET    /admin/users              пользователи
+//	POST   /admin/users              {"name","password"}
+//	GET    /admin/keys               API-ключи
+//	POST   /admin/keys               {"name"} → ключ (значение показывается один раз)
+//	DELETE /admin/keys/{id}          отзыв ключа
+//	GET    /admin/sessions           активные сессии
+//	DELETE /admin/sessions[?user=u]  сброс сессий (всех или пользователя)
+//	GET    /admin/maintenance        режим обслуживания
+//	PUT    /admin/maintenance        {"on": true|false}
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"jsonsrv/store"
+)
+
+// TokenEnv — переменная окружения с токеном и для сервера, и для srvctl
+const TokenEnv = "SRV_ADMIN_TOKEN"
+
+// ==== Режим обслуживания ====
+
+// Maintenance — флаг режима обслуживания: пока он включён, публичный API
+// отвечает 503 (см. middleware в main.go), админский работает.
+type Maintenance struct {
+	mu    sync.RWMutex
+	on    bool
+	since time.Time
+}
+
+func (m *Maintenance) On() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.on
+}
+
+// Set включает или выключает режим; since меняется только при переключении.
+func (m *Maintenance) Set(on bool) MaintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.on != on {
+		m.on, m.since = on, time.Now().UTC()
+	}
+	return MaintenanceState{On: m.on, Since: m.since}
+}
+
+func (m *Maintenance) State() MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return MaintenanceState{On: m.on, Since: m.since}
+}
+
+// ==== Типы ответов ====
+
+type UserInfo struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+type KeyInfo struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Created time.Time  `json:"created"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+// MintedKey — только что выпущенный ключ вместе со значением
+type MintedKey struct {
+	KeyInfo
+	Key string `json:"key"`
+}
+
+// SessionInfo — сессия без секретов: вместо хэша токена его начало
+type SessionInfo struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+type MaintenanceState struct {
+	On    bool      `json:"on"`
+	Since time.Time `json:"since,omitzero"`
+}
+
+type Purged struct {
+	Purged int `json:"purged"`
+}
+
+// response — тот же конверт, что у публичного API
+type response struct {
+	Status string          `json:"status"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+func keyInfo(k store.APIKey) KeyInfo {
+	return KeyInfo{ID: k.ID, Name: k.Name, Created: k.Created, Revoked: k.Revoked}
+}
+
+// ==== Сервер ====
+
+type Server struct {
+	Users       *store.Users
+	Keys        *store.APIKeys
+	Sessions    *store.Sessions
+	Maintenance *Maintenance
+	Token       string
+}
+
+// Handler — маршруты /admin/*, все за проверкой токена.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/users", s.listUsers)
+	mux.HandleFunc("POST /admin/users", s.addUser)
+	mux.HandleFunc("GET /admin/keys", s.listKeys)
+	mux.HandleFunc("POST /admin/keys", s.mintKey)
+	mux.HandleFunc("DELETE /admin/keys/{id}", s.revokeKey)
+	mux.HandleFunc("GET /admin/sessions", s.listSessions)
+	mux.HandleFunc("DELETE /admin/sessions", s.purgeSessions)
+	mux.HandleFunc("GET /admin/maintenance", s.getMaintenance)
+	mux.HandleFunc("PUT /admin/maintenance", s.setMaintenance)
+	return s.auth(mux)
+}
+
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Token == "" {
+			writeJSON(w, http.StatusNotFound, "not found")
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.Token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	resp := response{Status: "ok"}
+	if status >= 400 {
+		resp.Status, resp.Error = "error", data.(string)
+	} else if raw, err := json.Marshal(data); err == nil {
+		resp.Data = raw
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, "invalid JSON")
+		return false
+	}
+	return true
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	users := s.Users.List()
+	out := make([]UserInfo, 0, len(users))
+	for _, u := range users {
+		out = append(out, UserInfo{Name: u.Name, Created: u.Created})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) addUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	u, err := s.Users.Add(req.Name, req.Password)
+	switch {
+	case errors.Is(err, store.ErrUserExists):
+		writeJSON(w, http.StatusConflict, err.Error())
+	case errors.Is(err, store.ErrInvalidName), errors.Is(err, store.ErrWeakPassword):
+		writeJSON(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, "storage unavailable")
+	default:
+		writeJSON(w, http.StatusCreated, UserInfo{Name: u.Name, Created: u.Created})
+	}
+}
+
+func (s *Server) listKeys(w http.ResponseWriter, r *http.Request) {
+	keys := s.Keys.List()
+	out := make([]KeyInfo, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, keyInfo(k))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) mintKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	k, value, err := s.Keys.Mint(req.Name)
+	switch {
+	case errors.Is(err, store.ErrInvalidName):
+		writeJSON(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, "storage unavailable")
+	default:
+		writeJSON(w, http.StatusCreated, MintedKey{KeyInfo: keyInfo(k), Key: value})
+	}
+}
+
+func (s *Server) revokeKey(w http.ResponseWriter, r *http.Request) {
+	k, err := s.Keys.Revoke(r.PathValue("id"))
+	switch {
+	case errors.Is(err, store.ErrKeyNotFound):
+		writeJSON(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, "storage unavailable")
+	default:
+		writeJSON(w, http.StatusOK, keyInfo(k))
+	}
+}
+
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := s.Sessions.List()
+	out := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		out = append(out, SessionInfo{ID: sess.TokenHash[:12], User: sess.User, Expires: sess.Expires})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) purgeSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Purged{Purged: s.Sessions.Purge(r.URL.Query().Get("user"))})
+}
+
+func (s *Server) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Maintenance.State())
+}
+
+func (s *Server) setMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		On *bool `json:"on"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.On == nil {
+		writeJSON(w, http.StatusBadRequest, `"on" required`)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Maintenance.Set(*req.On))
+}
@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client — клиент админского API, им пользуется srvctl.
+type Client struct {
+	BaseURL string // напр. http://127.0.0.1:8081
+	Token   string
+	HTTP    *http.Client // nil = http.DefaultClient
+}
+
+// APIError — ответ сервера с кодом ошибки
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Status, e.Message)
+}
+
+// do отправляет запрос и раскладывает data из конверта в out (если не nil).
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var env response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&env); err != nil {
+		return &APIError{Status: resp.StatusCode, Message: "unexpected response: " + err.Error()}
+	}
+	if resp.StatusCode >= 400 {
+		return &APIError{Status: resp.StatusCode, Message: env.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(env.Data, out)
+}
+
+func (c *Client) Users(ctx context.Context) ([]UserInfo, error) {
+	var out []UserInfo
+	return out, c.do(ctx, http.MethodGet, "/admin/users", nil, &out)
+}
+
+func (c *Client) AddUser(ctx context.Context, name, password string) (UserInfo, error) {
+	var out UserInfo
+	body := map[string]string{"name": name, "password": password}
+	return out, c.do(ctx, http.MethodPost, "/admin/users", body, &out)
+}
+
+func (c *Client) Keys(ctx context.Context) ([]KeyInfo, error) {
+	var out []KeyInfo
+	return out, c.do(ctx, http.MethodGet, "/admin/keys", nil, &out)
+}
+
+func (c *Client) MintKey(ctx context.Context, name string) (MintedKey, error) {
+	var out MintedKey
+	return out, c.do(ctx, http.MethodPost, "/admin/keys", map[string]string{"name": name}, &out)
+}
+
+func (c *Client) RevokeKey(ctx context.Context, id string) (KeyInfo, error) {
+	var out KeyInfo
+	return out, c.do(ctx, http.MethodDelete, "/admin/keys/"+url.PathEscape(id), nil, &out)
+}
+
+func (c *Client) Sessions(ctx context.Context) ([]SessionInfo, error) {
+	var out []SessionInfo
+	return out, c.do(ctx, http.MethodGet, "/admin/sessions", nil, &out)
+}
+
+// PurgeSessions сбрасывает сессии user (пустой — все).
+func (c *Client) PurgeSessions(ctx context.Context, user string) (Purged, error) {
+	path := "/admin/sessions"
+	if user != "" {
+		path += "?user=" + url.QueryEscape(user)
+	}
+	var out Purged
+	return out, c.do(ctx, http.MethodDelete, path, nil, &out)
+}
+
+func (c *Client) Maintenance(ctx context.Context) (MaintenanceState, error) {
+	var out MaintenanceState
+	return out, c.do(ctx, http.MethodGet, "/admin/maintenance", nil, &out)
+}
+
+func (c *Client) SetMaintenance(ctx context.Context, on bool) (MaintenanceState, error) {
+	var out MaintenanceState
+	return out, c.do(ctx, http.MethodPut, "/admin/maintenance", map[string]bool{"on": on}, &out)
+}
@@ -0,0 +1,252 @@
+// srvctl — администрирование запущенного JSON API сервера через его
+// админский API (пакет admin) вместо curl.
+//
+//	srvctl [--addr URL] [--json] user add <name> [--password P]   (иначе пароль — первая строка stdin)
+//	srvctl user list
+//	srvctl key mint <name> | key revoke <id> | key list
+//	srvctl session list | session purge [--user U]
+//	srvctl maintenance on|off|status
+//
+// Токен берётся из SRV_ADMIN_TOKEN, адрес — из --addr или SRVCTL_ADDR.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"jsonsrv/admin"
+)
+
+const (
+	defaultAddr = "http://127.0.0.1:8081"
+	addrEnv     = "SRVCTL_ADDR"
+	timeout     = 10 * time.Second
+)
+
+var errUsage = errors.New("usage")
+
+const usage = `usage: srvctl [--addr URL] [--json] <command>
+
+  user add <name> [--password P]   пароль иначе читается из stdin
+  user list
+  key mint <name>
+  key revoke <id>
+  key list
+  session list
+  session purge [--user U]
+  maintenance on|off|status
+
+Токен: $` + admin.TokenEnv + `, адрес: --addr или $` + addrEnv + ` (по умолчанию ` + defaultAddr + `)
+`
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Getenv, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli — разобранные общие флаги и куда писать
+type cli struct {
+	client *admin.Client
+	json   bool
+	stdin  io.Reader
+	out    io.Writer
+}
+
+// run выполняет одну команду и возвращает код выхода.
+func run(ctx context.Context, args []string, getenv func(string) string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("srvctl", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addr := getenv(addrEnv)
+	if addr == "" {
+		addr = defaultAddr
+	}
+	fs.StringVar(&addr, "addr", addr, "admin API address")
+	jsonOut := fs.Bool("json", false, "print JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	token := getenv(admin.TokenEnv)
+	if token == "" {
+		fmt.Fprintf(stderr, "srvctl: %s is not set\n", admin.TokenEnv)
+		return 2
+	}
+	c := &cli{
+		client: &admin.Client{BaseURL: addr, Token: token},
+		json:   *jsonOut,
+		stdin:  stdin,
+		out:    stdout,
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := c.dispatch(ctx, fs.Args())
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprint(stderr, usage)
+		return 2
+	case err != nil:
+		fmt.Fprintf(stderr, "srvctl: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (c *cli) dispatch(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	switch args[0] + " " + args[1] {
+	case "user add":
+		return c.userAdd(ctx, args[2:])
+	case "user list":
+		users, err := c.client.Users(ctx)
+		if err != nil {
+			return err
+		}
+		return c.print(users, func(tw io.Writer) {
+			fmt.Fprintln(tw, "NAME\tCREATED")
+			for _, u := range users {
+				fmt.Fprintf(tw, "%s\t%s\n", u.Name, stamp(u.Created))
+			}
+		})
+	case "key mint":
+		if len(args) != 3 {
+			return errUsage
+		}
+		k, err := c.client.MintKey(ctx, args[2])
+		if err != nil {
+			return err
+		}
+		return c.print(k, func(tw io.Writer) {
+			fmt.Fprintf(tw, "ID\t%s\nNAME\t%s\nKEY\t%s\n", k.ID, k.Name, k.Key)
+			fmt.Fprintln(tw, "Сохраните ключ: повторно он не показывается.")
+		})
+	case "key revoke":
+		if len(args) != 3 {
+			return errUsage
+		}
+		k, err := c.client.RevokeKey(ctx, args[2])
+		if err != nil {
+			return err
+		}
+		return c.print(k, func(tw io.Writer) {
+			fmt.Fprintf(tw, "revoked %s (%s)\n", k.ID, k.Name)
+		})
+	case "key list":
+		keys, err := c.client.Keys(ctx)
+		if err != nil {
+			return err
+		}
+		return c.print(keys, func(tw io.Writer) {
+			fmt.Fprintln(tw, "ID\tNAME\tCREATED\tREVOKED")
+			for _, k := range keys {
+				revoked := "-"
+				if k.Revoked != nil {
+					revoked = stamp(*k.Revoked)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", k.ID, k.Name, stamp(k.Created), revoked)
+			}
+		})
+	case "session list":
+		sessions, err := c.client.Sessions(ctx)
+		if err != nil {
+			return err
+		}
+		return c.print(sessions, func(tw io.Writer) {
+			fmt.Fprintln(tw, "ID\tUSER\tEXPIRES")
+			for _, s := range sessions {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", s.ID, s.User, stamp(s.Expires))
+			}
+		})
+	case "session purge":
+		fs := flag.NewFlagSet("session purge", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		user := fs.String("user", "", "only this user's sessions")
+		if err := fs.Parse(args[2:]); err != nil || fs.NArg() > 0 {
+			return errUsage
+		}
+		p, err := c.client.PurgeSessions(ctx, *user)
+		if err != nil {
+			return err
+		}
+		return c.print(p, func(tw io.Writer) {
+			fmt.Fprintf(tw, "purged %d sessions\n", p.Purged)
+		})
+	case "maintenance on", "maintenance off", "maintenance status":
+		var st admin.MaintenanceState
+		var err error
+		if args[1] == "status" {
+			st, err = c.client.Maintenance(ctx)
+		} else {
+			st, err = c.client.SetMaintenance(ctx, args[1] == "on")
+		}
+		if err != nil {
+			return err
+		}
+		return c.print(st, func(tw io.Writer) {
+			state := "off"
+			if st.On {
+				state = "on"
+			}
+			if st.Since.IsZero() {
+				fmt.Fprintf(tw, "maintenance %s\n", state)
+			} else {
+				fmt.Fprintf(tw, "maintenance %s since %s\n", state, stamp(st.Since))
+			}
+		})
+	}
+	return errUsage
+}
+
+func (c *cli) userAdd(ctx context.Context, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errUsage
+	}
+	name := args[0]
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	password := fs.String("password", "", "password (default: first line of stdin)")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+	if *password == "" {
+		line, err := bufio.NewReader(c.stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	u, err := c.client.AddUser(ctx, name, *password)
+	if err != nil {
+		return err
+	}
+	return c.print(u, func(tw io.Writer) {
+		fmt.Fprintf(tw, "added user %s\n", u.Name)
+	})
+}
+
+// print выводит v как JSON (--json) или таблицей через table.
+func (c *cli) print(v any, table func(io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+func stamp(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05")
+}
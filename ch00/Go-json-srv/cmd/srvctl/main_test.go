@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jsonsrv/admin"
+	"jsonsrv/store"
+)
+
+const testToken = "test-admin-token"
+
+// testServer — админский API поверх хранилищ в памяти
+func testServer(t *testing.T) (*httptest.Server, *admin.Server) {
+	t.Helper()
+	users, _ := store.OpenUsers("")
+	keys, _ := store.OpenAPIKeys("")
+	srv := &admin.Server{
+		Users:       users,
+		Keys:        keys,
+		Sessions:    store.NewSessions(time.Hour),
+		Maintenance: &admin.Maintenance{},
+		Token:       testToken,
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts, srv
+}
+
+// srvctl запускает команду и возвращает код выхода, stdout и stderr.
+func srvctl(t *testing.T, ts *httptest.Server, token, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	env := map[string]string{admin.TokenEnv: token, addrEnv: ts.URL}
+	var out, errOut bytes.Buffer
+	code := run(context.Background(), args, func(k string) string { return env[k] }, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestUserCommands(t *testing.T) {
+	ts, srv := testServer(t)
+	if code, out, errOut := srvctl(t, ts, testToken, "s3cret-pass\n", "user", "add", "alice"); code != 0 || !strings.Contains(out, "added user alice") {
+		t.Fatalf("user add: %d %q %q", code, out, errOut)
+	}
+	if err := srv.Users.Verify("alice", "s3cret-pass"); err != nil {
+		t.Fatalf("password from stdin not used: %v", err)
+	}
+	if code, _, _ := srvctl(t, ts, testToken, "", "user", "add", "bob", "--password", "bobspassword"); code != 0 {
+		t.Fatal("user add --password failed")
+	}
+	if code, _, errOut := srvctl(t, ts, testToken, "", "user", "add", "alice", "--password", "whatever123"); code != 1 || !strings.Contains(errOut, "409") {
+		t.Fatalf("duplicate user: %d %q", code, errOut)
+	}
+
+	code, out, _ := srvctl(t, ts, testToken, "", "user", "list")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if code != 0 || len(lines) != 3 || !strings.HasPrefix(lines[0], "NAME") || !strings.HasPrefix(lines[1], "alice") {
+		t.Fatalf("user list table: %q", out)
+	}
+
+	code, out, _ = srvctl(t, ts, testToken, "", "--json", "user", "list")
+	var users []admin.UserInfo
+	if code != 0 || json.Unmarshal([]byte(out), &users) != nil || len(users) != 2 || users[1].Name != "bob" {
+		t.Fatalf("user list --json: %q", out)
+	}
+	if strings.Contains(out, "hash") || strings.Contains(out, "salt") {
+		t.Fatal("password hashes leaked")
+	}
+}
+
+func TestKeyCommands(t *testing.T) {
+	ts, srv := testServer(t)
+	code, out, _ := srvctl(t, ts, testToken, "", "--json", "key", "mint", "ci")
+	var minted admin.MintedKey
+	if code != 0 || json.Unmarshal([]byte(out), &minted) != nil || minted.Key == "" {
+		t.Fatalf("key mint: %q", out)
+	}
+	if _, ok := srv.Keys.Verify(minted.Key); !ok {
+		t.Fatal("minted key does not verify")
+	}
+
+	if code, out, _ := srvctl(t, ts, testToken, "", "key", "revoke", minted.ID); code != 0 || !strings.Contains(out, "revoked "+minted.ID) {
+		t.Fatalf("key revoke: %d %q", code, out)
+	}
+	if _, ok := srv.Keys.Verify(minted.Key); ok {
+		t.Fatal("revoked key still verifies")
+	}
+	if code, _, errOut := srvctl(t, ts, testToken, "", "key", "revoke", "nope"); code != 1 || !strings.Contains(errOut, "404") {
+		t.Fatalf("unknown key: %d %q", code, errOut)
+	}
+
+	_, out, _ = srvctl(t, ts, testToken, "", "key", "list")
+	if !strings.Contains(out, minted.ID) || !strings.Contains(out, "ci") || strings.Contains(out, minted.Key) {
+		t.Fatalf("key list: %q", out)
+	}
+}
+
+func TestSessionCommands(t *testing.T) {
+	ts, srv := testServer(t)
+	for _, user := range []string{"alice", "alice", "bob"} {
+		if _, _, err := srv.Sessions.Create(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code, out, _ := srvctl(t, ts, testToken, "", "--json", "session", "list")
+	var sessions []admin.SessionInfo
+	if code != 0 || json.Unmarshal([]byte(out), &sessions) != nil || len(sessions) != 3 {
+		t.Fatalf("session list: %q", out)
+	}
+	if code, out, _ := srvctl(t, ts, testToken, "", "session", "purge", "--user", "alice"); code != 0 || !strings.Contains(out, "purged 2") {
+		t.Fatalf("purge alice: %d %q", code, out)
+	}
+	if code, out, _ := srvctl(t, ts, testToken, "", "session", "purge"); code != 0 || !strings.Contains(out, "purged 1") {
+		t.Fatalf("purge all: %d %q", code, out)
+	}
+	if n := len(srv.Sessions.List()); n != 0 {
+		t.Fatalf("%d sessions left", n)
+	}
+}
+
+func TestMaintenanceCommands(t *testing.T) {
+	ts, srv := testServer(t)
+	if code, out, _ := srvctl(t, ts, testToken, "", "maintenance", "on"); code != 0 || !strings.Contains(out, "maintenance on since") {
+		t.Fatalf("maintenance on: %d %q", code, out)
+	}
+	if !srv.Maintenance.On() {
+		t.Fatal("maintenance not switched on")
+	}
+	code, out, _ := srvctl(t, ts, testToken, "", "--json", "maintenance", "off")
+	var st admin.MaintenanceState
+	if code != 0 || json.Unmarshal([]byte(out), &st) != nil || st.On || srv.Maintenance.On() {
+		t.Fatalf("maintenance off: %q", out)
+	}
+}
+
+func TestAuthAndUsage(t *testing.T) {
+	ts, _ := testServer(t)
+	if code, _, errOut := srvctl(t, ts, "wrong", "", "user", "list"); code != 1 || !strings.Contains(errOut, "401") {
+		t.Fatalf("wrong token: %d %q", code, errOut)
+	}
+	if code, _, errOut := srvctl(t, ts, "", "", "user", "list"); code != 2 || !strings.Contains(errOut, admin.TokenEnv) {
+		t.Fatalf("no token: %d %q", code, errOut)
+	}
+	for _, args := range [][]string{{}, {"user"}, {"key", "mint"}, {"session", "purge", "extra"}, {"maintenance", "maybe"}} {
+		if code, _, errOut := srvctl(t, ts, testToken, "", args...); code != 2 || !strings.Contains(errOut, "usage:") {
+			t.Fatalf("%v: %d %q", args, code, errOut)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ==== Склейка одинаковых GET (singleflight) ====
+//
+// Во время всплесков сотни одинаковых GET (дашборды, списки) одновременно
+// выполняют один и тот же хэндлер. На маршрутах из CoalesceRoutes запросы с
+// одинаковым ключом (путь + query + личность клиента), пришедшие, пока
+// первый («ведущий») ещё выполняется, ждут его и получают копию ответа:
+// статус, заголовки, тело.
+//
+// Ответ не делится (ждущие выполняют хэндлер сами), если:
+//   - ведущий запаниковал — паника уходит его recoverer'у, остальных она не задевает;
+//   - в ответе есть Set-Cookie — куки одного клиента не должны уйти другому;
+//   - тело больше CoalesceMaxBody.
+//
+// Запрос с заголовком NoCoalesceHeader выполняется отдельно и ни к кому не
+// присоединяется.
+
+// bufferedResponse — ответ, записанный в память, чтобы отдать его нескольким клиентам
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header)}
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// replay отдаёт записанный ответ клиенту; заголовки копируются, поэтому
+// один буфер можно отдавать многим.
+func (b *bufferedResponse) replay(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	status := b.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(b.body.Bytes())
+}
+
+// flight — одно выполнение хэндлера, которого ждут остальные
+type flight struct {
+	done    chan struct{}
+	resp    *bufferedResponse // nil = ответ не делится
+	waiters int
+}
+
+// CoalesceStats — счётчики для /api/_coalesce
+type CoalesceStats struct {
+	Leaders   int64 `json:"leaders"`   // Выполнений хэндлера, к которым могли присоединиться
+	Coalesced int64 `json:"coalesced"` // Запросов, получивших чужой ответ
+	Fallbacks int64 `json:"fallbacks"` // Ждали, но ответ не делился — выполнили сами
+	OptOuts   int64 `json:"opt_outs"`  // Запросов с NoCoalesceHeader
+}
+
+// Coalescer склеивает одинаковые GET на выбранных маршрутах
+type Coalescer struct {
+	routes  map[string]bool
+	maxBody int
+
+	mu      sync.Mutex
+	flights map[string]*flight
+
+	leaders, coalesced, fallbacks, optOuts atomic.Int64
+}
+
+func newCoalescer(routes []string, maxBody int) *Coalescer {
+	c := &Coalescer{
+		routes:  make(map[string]bool, len(routes)),
+		maxBody: maxBody,
+		flights: make(map[string]*flight),
+	}
+	for _, route := range routes {
+		c.routes[route] = true
+	}
+	return c
+}
+
+// key — путь, query и личность клиента: API-ключ, иначе cookie сессии,
+// иначе IP. Сессия не разбирается: две сессии одного пользователя просто
+// не склеиваются между собой.
+func (c *Coalescer) key(r *http.Request) string {
+	id := "ip:" + clientIP(r)
+	if k := r.Header.Get(APIKeyHeader); k != "" {
+		id = "key:" + k
+	} else if cookie, err := r.Cookie("session"); err == nil {
+		id = "session:" + cookie.Value
+	}
+	return r.URL.Path + "?" + r.URL.RawQuery + "\x00" + id
+}
+
+// shareable — можно ли отдать ответ ведущего остальным
+func (c *Coalescer) shareable(b *bufferedResponse) bool {
+	return len(b.header.Values("Set-Cookie")) == 0 && b.body.Len() <= c.maxBody
+}
+
+func (c *Coalescer) Stats() CoalesceStats {
+	return CoalesceStats{
+		Leaders:   c.leaders.Load(),
+		Coalesced: c.coalesced.Load(),
+		Fallbacks: c.fallbacks.Load(),
+		OptOuts:   c.optOuts.Load(),
+	}
+}
+
+// Middleware подключается последним в цепочке, прямо перед роутером
+func (c *Coalescer) Middleware() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !c.routes[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.Header.Get(NoCoalesceHeader) != "" {
+				c.optOuts.Add(1)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := c.key(r)
+			c.mu.Lock()
+			if f, ok := c.flights[key]; ok {
+				f.waiters++
+				c.mu.Unlock()
+				c.follow(f, next, w, r)
+				return
+			}
+			f := &flight{done: make(chan struct{})}
+			c.flights[key] = f
+			c.mu.Unlock()
+			c.leaders.Add(1)
+			c.lead(key, f, next, w, r)
+		})
+	}
+}
+
+// lead выполняет хэндлер в буфер. Флайт закрывается в defer — и при
+// панике, которая затем идёт дальше, к recoverer'у ведущего. Свой ответ
+// ведущий отдаёт уже после этого: медленный клиент не держит остальных.
+func (c *Coalescer) lead(key string, f *flight, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	buf := c.run(key, f, next, r)
+	buf.replay(w)
+}
+
+func (c *Coalescer) run(key string, f *flight, next http.Handler, r *http.Request) *bufferedResponse {
+	buf := newBufferedResponse()
+	completed := false
+	defer func() {
+		if completed && c.shareable(buf) {
+			f.resp = buf
+		}
+		c.mu.Lock()
+		delete(c.flights, key)
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	next.ServeHTTP(buf, r)
+	completed = true
+	return buf
+}
+
+// follow ждёт ведущего; если его ответ не делится — выполняет хэндлер сам
+func (c *Coalescer) follow(f *flight, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-f.done:
+	case <-r.Context().Done():
+		return // Клиент ушёл, не дождавшись
+	}
+	if f.resp == nil {
+		c.fallbacks.Add(1)
+		next.ServeHTTP(w, r)
+		return
+	}
+	c.coalesced.Add(1)
+	f.resp.replay(w)
+}
+
+// coalesceHandler — GET /api/_coalesce, счётчики склейки; доступ как у /api/_flags
+func coalesceHandler(c *Coalescer, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminRequest(w, r, token) {
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Stats())
+	}
+}
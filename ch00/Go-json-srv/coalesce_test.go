@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFollowers ждёт, пока к выполняющемуся запросу присоединятся n ждущих
+func waitFollowers(t *testing.T, c *Coalescer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		got := 0
+		for _, f := range c.flights {
+			got += f.waiters
+		}
+		c.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("followers did not join, want %d", n)
+}
+
+// coalesceRun запускает n одинаковых GET: первый входит в хэндлер, остальные
+// присоединяются к нему, затем хэндлер отпускается. Возвращает ответы.
+func coalesceRun(t *testing.T, h http.Handler, c *Coalescer, entered, release chan struct{}, n int) []*httptest.ResponseRecorder {
+	t.Helper()
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	get := func(i int) {
+		defer wg.Done()
+		recs[i] = httptest.NewRecorder()
+		h.ServeHTTP(recs[i], httptest.NewRequest(http.MethodGet, "/api/list?page=1", nil))
+	}
+	wg.Add(n)
+	go get(0)
+	<-entered
+	for i := 1; i < n; i++ {
+		go get(i)
+	}
+	waitFollowers(t, c, n-1)
+	close(release)
+	wg.Wait()
+	return recs
+}
+
+func TestCoalesceSharesOneExecution(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	c := newCoalescer([]string{"/api/list"}, CoalesceMaxBody)
+	h := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		w.Header().Set("X-Page", r.URL.Query().Get("page"))
+		writeJSON(w, http.StatusAccepted, "list")
+	}))
+
+	recs := coalesceRun(t, h, c, entered, release, 10)
+	if calls.Load() != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls.Load())
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusAccepted || rec.Header().Get("X-Page") != "1" || rec.Body.String() != recs[0].Body.String() {
+			t.Fatalf("response %d: %d %v %q", i, rec.Code, rec.Header(), rec.Body)
+		}
+	}
+	if st := c.Stats(); st.Leaders != 1 || st.Coalesced != 9 || st.Fallbacks != 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestCoalesceLeaderPanicDoesNotPoisonFollowers(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	c := newCoalescer([]string{"/api/list"}, CoalesceMaxBody)
+	h := recoverer(c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(entered)
+			<-release
+			panic("leader broke")
+		}
+		writeJSON(w, http.StatusOK, "list")
+	})))
+
+	recs := coalesceRun(t, h, c, entered, release, 4)
+	ok := 0
+	for _, rec := range recs {
+		switch rec.Code {
+		case http.StatusOK:
+			ok++
+		case http.StatusInternalServerError:
+		default:
+			t.Fatalf("unexpected status %d", rec.Code)
+		}
+	}
+	if ok != 3 || recs[0].Code != http.StatusInternalServerError {
+		t.Fatalf("leader %d, %d followers ok; want 500 and 3", recs[0].Code, ok)
+	}
+	if st := c.Stats(); st.Coalesced != 0 || st.Fallbacks != 3 {
+		t.Fatalf("stats: %+v", st)
+	}
+	if len(c.flights) != 0 {
+		t.Fatal("panicked flight left behind")
+	}
+}
+
+func TestCoalesceNeverSharesSetCookie(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	c := newCoalescer([]string{"/api/list"}, CoalesceMaxBody)
+	h := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n == 1 {
+			close(entered)
+			<-release
+		}
+		http.SetCookie(w, &http.Cookie{Name: "seen", Value: string(rune('0' + n))})
+		writeJSON(w, http.StatusOK, "list")
+	}))
+
+	recs := coalesceRun(t, h, c, entered, release, 5)
+	if calls.Load() != 5 {
+		t.Fatalf("handler ran %d times, want 5", calls.Load())
+	}
+	seen := map[string]bool{}
+	for _, rec := range recs {
+		seen[rec.Header().Get("Set-Cookie")] = true
+	}
+	if len(seen) != 5 {
+		t.Fatalf("cookies shared between requests: %v", seen)
+	}
+}
+
+func TestCoalesceLargeBodyNotShared(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	c := newCoalescer([]string{"/api/list"}, 16)
+	h := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		w.Write(make([]byte, 17))
+	}))
+	recs := coalesceRun(t, h, c, entered, release, 3)
+	if calls.Load() != 3 || recs[2].Body.Len() != 17 {
+		t.Fatalf("handler ran %d times, body %d", calls.Load(), recs[2].Body.Len())
+	}
+}
+
+func TestCoalesceKeyAndOptOut(t *testing.T) {
+	c := newCoalescer([]string{"/api/list"}, CoalesceMaxBody)
+	req := func(target string, hdr ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i+1 < len(hdr); i += 2 {
+			r.Header.Set(hdr[i], hdr[i+1])
+		}
+		return r
+	}
+	base := c.key(req("/api/list?page=1"))
+	for name, r := range map[string]*http.Request{
+		"query":   req("/api/list?page=2"),
+		"ip":      req("/api/list?page=1", "X-Real-IP", "10.0.0.9"),
+		"api key": req("/api/list?page=1", APIKeyHeader, "ci.secret"),
+		"session": req("/api/list?page=1", "Cookie", "session=abc"),
+	} {
+		if c.key(r) == base {
+			t.Errorf("%s: same key as the base request", name)
+		}
+	}
+
+	var calls atomic.Int32
+	h := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), req("/api/list", NoCoalesceHeader, "1"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/list", nil))
+	h.ServeHTTP(httptest.NewRecorder(), req("/api/other"))
+	if st := c.Stats(); calls.Load() != 3 || st.OptOuts != 1 || st.Leaders != 0 {
+		t.Fatalf("calls %d, stats %+v", calls.Load(), st)
+	}
+}
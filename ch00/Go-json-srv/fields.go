@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ==== Выборка полей ответа (?fields=) и ETag ====
+//
+// GET с ?fields=data.items.name,data.total получает только эти поля
+// конверта. Хэндлер ничего об этом не знает: он пишет полный ответ, а
+// sparseFields разбирает записанный JSON, оставляет запрошенные пути и
+// кодирует заново. Путь — ключи через точку от корня конверта:
+//   - массив на пути — выборка применяется к каждому элементу;
+//   - путь, заканчивающийся на объекте или массиве, берёт его целиком;
+//   - "status" конверта остаётся всегда.
+//
+// Путь, которого нет в ответе (ключа нет ни в одном объекте на этом месте
+// или под ним строка/число), — 400 с этим путём. Если проверить нечем
+// (пустой массив, null), путь считается верным.
+//
+// Заодно здесь считается ETag успешного GET — по телу, которое уходит
+// клиенту, то есть уже после выборки: у каждого набора полей свой ETag.
+// Совпавший If-None-Match — 304 без тела.
+
+// FieldsParam — query-параметр выборки полей
+const FieldsParam = "fields"
+
+// errFieldNotFound — запрошенного пути нет в ответе
+var errFieldNotFound = errors.New("unknown field")
+
+// fieldTree — разобранные пути: ключ → вложенная выборка; nil — поле целиком
+type fieldTree map[string]fieldTree
+
+// parseFields разбирает "a.b,a.c,d"; пустые элементы пропускаются
+func parseFields(param string) (fieldTree, error) {
+	tree := fieldTree{}
+	for _, path := range strings.Split(param, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		node := tree
+		keys := strings.Split(path, ".")
+		for i, key := range keys {
+			if key == "" {
+				return nil, fmt.Errorf("invalid field path %q", path)
+			}
+			sub, seen := node[key]
+			if seen && sub == nil {
+				break // Поле уже выбрано целиком
+			}
+			if i == len(keys)-1 {
+				node[key] = nil
+				break
+			}
+			if sub == nil {
+				sub = fieldTree{}
+				node[key] = sub
+			}
+			node = sub
+		}
+	}
+	return tree, nil
+}
+
+// fieldFilter — один проход выборки; found отмечает пути, которые нашлись
+// хотя бы раз, checked — пути, под которыми был объект, где их можно было искать
+type fieldFilter struct {
+	found, checked map[string]bool
+}
+
+// filterFields оставляет в v только пути tree. v — результат json.Unmarshal
+// (map[string]interface{}, []interface{}, скаляры, nil).
+func filterFields(v interface{}, tree fieldTree) (interface{}, error) {
+	f := fieldFilter{found: map[string]bool{}, checked: map[string]bool{}}
+	out := f.walk(v, tree, "")
+	if missing := f.missing(tree, ""); missing != "" {
+		return nil, fmt.Errorf("%w: %s", errFieldNotFound, missing)
+	}
+	return out, nil
+}
+
+func (f *fieldFilter) walk(v interface{}, tree fieldTree, prefix string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		f.checked[prefix] = true
+		out := make(map[string]interface{}, len(tree))
+		for key, sub := range tree {
+			val, ok := v[key]
+			if !ok {
+				continue
+			}
+			path := joinPath(prefix, key)
+			f.found[path] = true
+			if sub == nil {
+				out[key] = val
+			} else {
+				out[key] = f.walk(val, sub, path)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, el := range v {
+			out[i] = f.walk(el, tree, prefix)
+		}
+		return out
+	case nil:
+		return nil
+	}
+	// Строка, число, bool: вложенных полей нет, ни один путь не найдётся
+	f.checked[prefix] = true
+	return v
+}
+
+// missing — первый (по алфавиту) путь, который искали, но не нашли
+func (f *fieldFilter) missing(tree fieldTree, prefix string) string {
+	keys := make([]string, 0, len(tree))
+	for key := range tree {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		path := joinPath(prefix, key)
+		if !f.found[path] {
+			if f.checked[prefix] {
+				return path
+			}
+			continue
+		}
+		if m := f.missing(tree[key], path); m != "" {
+			return m
+		}
+	}
+	return ""
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// applyFields — тело ответа body с выборкой tree; "status" конверта остаётся
+func applyFields(body []byte, tree fieldTree) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // Числа уходят клиенту как пришли, без float64
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, ok := tree["status"]; !ok {
+		if env, ok := v.(map[string]interface{}); ok {
+			if _, ok := env["status"]; ok {
+				tree["status"] = nil
+			}
+		}
+	}
+	out, err := filterFields(v, tree)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if JSONIndent {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(out); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// etag — сильный ETag тела
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches — If-None-Match содержит tag (или *)
+func etagMatches(ifNoneMatch, tag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// sparseFields — выборка полей и ETag для GET. Стоит снаружи Coalescer'а:
+// 304 зависит от If-None-Match конкретного клиента и не должен достаться
+// склеенным с ним запросам.
+func sparseFields() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tree, err := parseFields(r.URL.Query().Get(FieldsParam))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			buf := newBufferedResponse()
+			next.ServeHTTP(buf, r)
+			if buf.status != 0 && buf.status != http.StatusOK {
+				buf.replay(w)
+				return
+			}
+			body := buf.body.Bytes()
+			if len(tree) > 0 && strings.HasPrefix(buf.header.Get("Content-Type"), "application/json") {
+				if body, err = applyFields(body, tree); err != nil {
+					if errors.Is(err, errFieldNotFound) {
+						writeJSON(w, http.StatusBadRequest, err.Error())
+					} else {
+						writeJSON(w, http.StatusInternalServerError, "response is not JSON")
+					}
+					return
+				}
+				buf.header.Del("Content-Length")
+			}
+
+			tag := etag(body)
+			for k, v := range buf.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("ETag", tag)
+			if etagMatches(r.Header.Get("If-None-Match"), tag) {
+				w.Header().Del("Content-Type")
+				w.Header().Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(body)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ordersFixture — конверт списка с массивом, вложенными объектами и null
+const ordersFixture = `{"status":"ok","data":{
+	"total":2,
+	"page":{"number":1,"size":20},
+	"items":[
+		{"id":1,"name":"book","price":{"amount":1250,"currency":"EUR"},"tags":["a","b"],"note":null},
+		{"id":2,"name":"pen","price":{"amount":90,"currency":"EUR"},"tags":[]}
+	],
+	"empty":[],
+	"missing":null
+}}`
+
+func sparse(t *testing.T, body, fields string) (string, error) {
+	t.Helper()
+	tree, err := parseFields(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := applyFields([]byte(body), tree)
+	return strings.TrimSpace(string(out)), err
+}
+
+func TestParseFields(t *testing.T) {
+	tree, err := parseFields(" data.items.name, data.items ,,data.total,a.b.c,a.b.d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(tree)
+	if want := `{"a":{"b":{"c":null,"d":null}},"data":{"items":null,"total":null}}`; string(got) != want {
+		t.Fatalf("tree %s, want %s", got, want)
+	}
+	for _, bad := range []string{"data..items", ".data", "data."} {
+		if _, err := parseFields(bad); err == nil {
+			t.Fatalf("%q accepted", bad)
+		}
+	}
+}
+
+func TestFieldsFilterNested(t *testing.T) {
+	for fields, want := range map[string]string{
+		"data.total":                     `{"data":{"total":2},"status":"ok"}`,
+		"data.items.name,data.total":     `{"data":{"items":[{"name":"book"},{"name":"pen"}],"total":2},"status":"ok"}`,
+		"data.items.price.amount":        `{"data":{"items":[{"price":{"amount":1250}},{"price":{"amount":90}}]},"status":"ok"}`,
+		"data.page":                      `{"data":{"page":{"number":1,"size":20}},"status":"ok"}`,
+		"data.items.tags,data.items.id":  `{"data":{"items":[{"id":1,"tags":["a","b"]},{"id":2,"tags":[]}]},"status":"ok"}`,
+		"data.items.note":                `{"data":{"items":[{"note":null},{}]},"status":"ok"}`,
+		"data.empty.anything":            `{"data":{"empty":[]},"status":"ok"}`,
+		"data.missing.anything":          `{"data":{"missing":null},"status":"ok"}`,
+		"status":                         `{"status":"ok"}`,
+		"data.items.name,data.items":     `{"data":{"items":[{"id":1,"name":"book","note":null,"price":{"amount":1250,"currency":"EUR"},"tags":["a","b"]},{"id":2,"name":"pen","price":{"amount":90,"currency":"EUR"},"tags":[]}]},"status":"ok"}`,
+		"data.items.price.currency,data": `{"data":{"empty":[],"items":[{"id":1,"name":"book","note":null,"price":{"amount":1250,"currency":"EUR"},"tags":["a","b"]},{"id":2,"name":"pen","price":{"amount":90,"currency":"EUR"},"tags":[]}],"missing":null,"page":{"number":1,"size":20},"total":2},"status":"ok"}`,
+	} {
+		got, err := sparse(t, ordersFixture, fields)
+		if err != nil || got != want {
+			t.Errorf("fields=%s:\n got %s (%v)\nwant %s", fields, got, err, want)
+		}
+	}
+}
+
+func TestFieldsUnknownPath(t *testing.T) {
+	for fields, path := range map[string]string{
+		"data.nope":                  "data.nope",
+		"data.items.nme":             "data.items.nme",
+		"data.items.price.cents":     "data.items.price.cents",
+		"data.total.value":           "data.total.value",
+		"data.items.tags.x":          "data.items.tags.x",
+		"data.total,data.zzz,data.a": "data.a",
+	} {
+		_, err := sparse(t, ordersFixture, fields)
+		if !errors.Is(err, errFieldNotFound) || !strings.HasSuffix(err.Error(), ": "+path) {
+			t.Errorf("fields=%s: %v, want unknown %s", fields, err, path)
+		}
+	}
+}
+
+func TestSparseFieldsMiddlewareAndETag(t *testing.T) {
+	h, _, _ := testServer(t, LoadConfig())
+	get := func(query, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/healthz"+query, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	full := get("", "")
+	sparse := get("?fields=data.version", "")
+	if sparse.Code != http.StatusOK || strings.TrimSpace(sparse.Body.String()) != `{"data":{"version":"1.0"},"status":"ok"}` {
+		t.Fatalf("sparse: %d %s", sparse.Code, sparse.Body)
+	}
+	fullTag, sparseTag := full.Header().Get("ETag"), sparse.Header().Get("ETag")
+	if fullTag == "" || sparseTag == "" || fullTag == sparseTag {
+		t.Fatalf("ETag must vary with fields: %q %q", fullTag, sparseTag)
+	}
+	if again := get("?fields=data.version", ""); again.Header().Get("ETag") != sparseTag {
+		t.Fatalf("ETag not stable: %q", again.Header().Get("ETag"))
+	}
+
+	if rec := get("?fields=data.version", sparseTag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("If-None-Match: %d %q", rec.Code, rec.Body)
+	}
+	if rec := get("", sparseTag); rec.Code != http.StatusOK {
+		t.Fatalf("sparse ETag matched the full response: %d", rec.Code)
+	}
+
+	rec := get("?fields=data.verison", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "data.verison") {
+		t.Fatalf("unknown field: %d %s", rec.Code, rec.Body)
+	}
+	if rec := get("?fields=data..version", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad path: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// randomJSON — случайное дерево JSON глубиной до depth с ключами из keys
+func randomJSON(rng *rand.Rand, depth int, keys []string) interface{} {
+	n := rng.Intn(7)
+	if depth == 0 {
+		n = rng.Intn(4)
+	}
+	switch n {
+	case 0:
+		return nil
+	case 1:
+		return rng.Intn(2) == 0
+	case 2:
+		return json.Number(fmt.Sprint(rng.Intn(1000) - 500))
+	case 3:
+		return keys[rng.Intn(len(keys))]
+	case 4, 5:
+		m := map[string]interface{}{}
+		for i := rng.Intn(4); i > 0; i-- {
+			m[keys[rng.Intn(len(keys))]] = randomJSON(rng, depth-1, keys)
+		}
+		return m
+	}
+	a := make([]interface{}, rng.Intn(4))
+	for i := range a {
+		a[i] = randomJSON(rng, depth-1, keys)
+	}
+	return a
+}
+
+// Выборка не паникует ни на каком JSON и ни на каких путях: результат —
+// либо ошибка, либо JSON
+func TestFieldsNeverPanic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	keys := []string{"a", "b", "c", "data", "status", "items"}
+	for i := 0; i < 5000; i++ {
+		var body []byte
+		if i%10 == 0 {
+			body = make([]byte, rng.Intn(16))
+			rng.Read(body) // Не JSON вовсе
+		} else {
+			body, _ = json.Marshal(randomJSON(rng, 4, keys))
+		}
+		var paths []string
+		for j := rng.Intn(4) + 1; j > 0; j-- {
+			var parts []string
+			for k := rng.Intn(3) + 1; k > 0; k-- {
+				parts = append(parts, keys[rng.Intn(len(keys))])
+			}
+			paths = append(paths, strings.Join(parts, "."))
+		}
+		fields := strings.Join(paths, ",")
+
+		func() {
+			defer func() {
+				if rec := recover(); rec != nil {
+					t.Fatalf("panic on %s with fields=%s: %v", body, fields, rec)
+				}
+			}()
+			tree, err := parseFields(fields)
+			if err != nil {
+				t.Fatalf("fields=%s: %v", fields, err)
+			}
+			out, err := applyFields(body, tree)
+			if err == nil && !json.Valid(out) {
+				t.Fatalf("invalid output %q for %s with fields=%s", out, body, fields)
+			}
+		}()
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"jsonsrv/store"
+)
+
+// ==== Feature flags (канареечный выкат) ====
+//
+// Флаги лежат в JSON-файле FlagsFile и перечитываются на лету (по mtime
+// и по SIGHUP), так что процент выката меняется без рестарта:
+//
+//	{"new_upload": {"percent": 10, "allow": ["alice"]}}
+//
+// Клиент попадает в корзину 0..99 по хэшу имени флага и своей личности
+// (пользователь сессии, иначе IP). Корзина не зависит от процента, поэтому
+// при увеличении выката уже попавшие в новую ветку в ней и остаются.
+
+const (
+	VariantNew = "new"
+	VariantOld = "old"
+)
+
+// FlagRule — правило одного флага
+type FlagRule struct {
+	Percent int      `json:"percent"`         // 0..100
+	Allow   []string `json:"allow,omitempty"` // Всегда в новой ветке
+}
+
+// FeatureFlags — текущие правила и счётчики вычислений
+type FeatureFlags struct {
+	path  string
+	store *store.Sessions // Для личности клиента; nil = только IP
+
+	mu     sync.RWMutex
+	rules  map[string]FlagRule
+	mtime  time.Time
+	counts map[string]map[string]int64 // флаг → вариант → число
+}
+
+func newFeatureFlags(path string, sessions *store.Sessions) *FeatureFlags {
+	return &FeatureFlags{
+		path:   path,
+		store:  sessions,
+		rules:  make(map[string]FlagRule),
+		counts: make(map[string]map[string]int64),
+	}
+}
+
+// Load читает файл флагов. Отсутствующий файл = все флаги выключены.
+// Кривой файл не применяется: остаются прежние правила.
+func (f *FeatureFlags) Load() error {
+	st, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		f.Replace(map[string]FlagRule{}, time.Time{})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	rules := make(map[string]FlagRule)
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	for name, rule := range rules {
+		if rule.Percent < 0 || rule.Percent > 100 {
+			return fmt.Errorf("%s: flag %q: percent %d out of 0..100", f.path, name, rule.Percent)
+		}
+	}
+	f.Replace(rules, st.ModTime())
+	return nil
+}
+
+// Replace атомарно подменяет правила
+func (f *FeatureFlags) Replace(rules map[string]FlagRule, mtime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+	f.mtime = mtime
+}
+
+// Reload перечитывает файл, если он изменился с прошлой загрузки
+func (f *FeatureFlags) Reload() (bool, error) {
+	f.mu.RLock()
+	last := f.mtime
+	f.mu.RUnlock()
+
+	st, err := os.Stat(f.path)
+	switch {
+	case os.IsNotExist(err):
+		if last.IsZero() {
+			return false, nil
+		}
+	case err != nil:
+		return false, err
+	case st.ModTime().Equal(last):
+		return false, nil
+	}
+	return true, f.Load()
+}
+
+// Watch раз в interval проверяет файл, пока не закрыт stop
+func (f *FeatureFlags) Watch(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if changed, err := f.Reload(); err != nil {
+				log.Printf("flags reload: %v", err)
+			} else if changed {
+				log.Printf("flags reloaded from %s", f.path)
+			}
+		}
+	}
+}
+
+// bucket — детерминированная корзина 0..99 для пары флаг/личность
+func bucket(flag, identity string) int {
+	h := fnv.New32a()
+	h.Write([]byte(flag))
+	h.Write([]byte{0})
+	h.Write([]byte(identity))
+	return int(h.Sum32() % 100)
+}
+
+// Enabled решает, получает ли личность новую ветку флага
+func (f *FeatureFlags) Enabled(flag, identity string) bool {
+	f.mu.RLock()
+	rule, ok := f.rules[flag]
+	f.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	for _, id := range rule.Allow {
+		if id == identity {
+			return true
+		}
+	}
+	return bucket(flag, identity) < rule.Percent
+}
+
+// identity — пользователь сессии или "ip:<адрес>" клиента; в allow-листе
+// пишется так же: "alice" или "ip:1.2.3.4".
+func (f *FeatureFlags) identity(r *http.Request) string {
+	if f.store != nil {
+		if c, err := r.Cookie("session"); err == nil {
+			if sess, ok := f.store.Lookup(c.Value); ok {
+				return sess.User
+			}
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+func (f *FeatureFlags) count(flag, variant string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.counts[flag] == nil {
+		f.counts[flag] = make(map[string]int64)
+	}
+	f.counts[flag][variant]++
+}
+
+// WhenFlag отдаёт запрос newHandler или oldHandler по флагу name.
+// Выбранный вариант виден клиенту в заголовке FeatureVariantHeader
+// ("name=new"), чтобы ошибки канарейки было легко отличить.
+func (f *FeatureFlags) WhenFlag(name string, newHandler, oldHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		variant, h := VariantOld, oldHandler
+		if f.Enabled(name, f.identity(r)) {
+			variant, h = VariantNew, newHandler
+		}
+		f.count(name, variant)
+		w.Header().Add(FeatureVariantHeader, name+"="+variant)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// flagStatus — один флаг в ответе /api/_flags
+type flagStatus struct {
+	Name   string           `json:"name"`
+	Rule   *FlagRule        `json:"rule,omitempty"` // nil = флага больше нет в файле
+	Counts map[string]int64 `json:"counts"`
+}
+
+// Snapshot — правила и счётчики, отсортированные по имени
+func (f *FeatureFlags) Snapshot() []flagStatus {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	names := make(map[string]struct{})
+	for name := range f.rules {
+		names[name] = struct{}{}
+	}
+	for name := range f.counts {
+		names[name] = struct{}{}
+	}
+	out := make([]flagStatus, 0, len(names))
+	for name := range names {
+		st := flagStatus{Name: name, Counts: map[string]int64{VariantNew: 0, VariantOld: 0}}
+		if rule, ok := f.rules[name]; ok {
+			st.Rule = &rule
+		}
+		for v, n := range f.counts[name] {
+			st.Counts[v] = n
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// flagsHandler — GET /api/_flags, только с Authorization: Bearer <token>.
+// Пустой токен = эндпоинт выключен.
+func flagsHandler(f *FeatureFlags, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminRequest(w, r, token) {
+			return
+		}
+		writeJSON(w, http.StatusOK, f.Snapshot())
+	}
+}
+
+// adminRequest пропускает только GET с Authorization: Bearer <token>,
+// иначе сам отвечает ошибкой. Пустой токен = эндпоинт выключен (404).
+func adminRequest(w http.ResponseWriter, r *http.Request, token string) bool {
+	if token == "" {
+		writeJSON(w, http.StatusNotFound, "not found")
+		return false
+	}
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"jsonsrv/store"
+)
+
+func writeFlags(t *testing.T, path, body string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Явное mtime: две записи подряд могут попасть в одну метку времени ФС
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlagBucketingIsDeterministic(t *testing.T) {
+	flags := newFeatureFlags(filepath.Join(t.TempDir(), "flags.json"), nil)
+	flags.Replace(map[string]FlagRule{"beta": {Percent: 50}}, time.Time{})
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("user-%d", i)
+		first := flags.Enabled("beta", id)
+		for j := 0; j < 5; j++ {
+			if flags.Enabled("beta", id) != first {
+				t.Fatalf("%s flipped between evaluations", id)
+			}
+		}
+	}
+
+	// Тот же клиент через HTTP получает тот же вариант и видит его в заголовке
+	h := flags.WhenFlag("beta",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("new")) }),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("old")) }))
+	var seen string
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-IP", "10.0.0.7")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		want := "beta=" + rec.Body.String()
+		if got := rec.Header().Get(FeatureVariantHeader); got != want {
+			t.Fatalf("header %q, body says %q", got, want)
+		}
+		if seen != "" && want != seen {
+			t.Fatalf("variant changed: %s then %s", seen, want)
+		}
+		seen = want
+	}
+	if snap := flags.Snapshot(); len(snap) != 1 || snap[0].Counts[VariantNew]+snap[0].Counts[VariantOld] != 3 {
+		t.Fatalf("counts: %+v", snap)
+	}
+}
+
+func TestFlagPercentageAccuracy(t *testing.T) {
+	flags := newFeatureFlags(filepath.Join(t.TempDir(), "flags.json"), nil)
+	const n = 10000
+	for _, pct := range []int{0, 1, 10, 25, 50, 90, 100} {
+		flags.Replace(map[string]FlagRule{"ramp": {Percent: pct}}, time.Time{})
+		on := 0
+		for i := 0; i < n; i++ {
+			if flags.Enabled("ramp", fmt.Sprintf("ip:10.%d.%d.%d", i>>16&255, i>>8&255, i&255)) {
+				on++
+			}
+		}
+		got := float64(on) * 100 / n
+		if diff := got - float64(pct); diff > 1.5 || diff < -1.5 {
+			t.Errorf("percent %d: %.2f%% enabled", pct, got)
+		}
+	}
+}
+
+func TestFlagAllowList(t *testing.T) {
+	sessions := store.NewSessions(time.Hour)
+	token, _, err := sessions.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := newFeatureFlags(filepath.Join(t.TempDir(), "flags.json"), sessions)
+	flags.Replace(map[string]FlagRule{"beta": {Percent: 0, Allow: []string{"alice"}}}, time.Time{})
+
+	h := flags.WhenFlag("beta", http.NotFoundHandler(), http.NotFoundHandler())
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: token})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get(FeatureVariantHeader); got != "beta=new" {
+		t.Fatalf("alice: %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get(FeatureVariantHeader); got != "beta=old" {
+		t.Fatalf("anonymous: %q", got)
+	}
+}
+
+func TestFlagLiveReloadChangesRamp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.json")
+	start := time.Now().Add(-time.Hour)
+	writeFlags(t, path, `{"ramp": {"percent": 10}}`, start)
+	flags := newFeatureFlags(path, nil)
+	if err := flags.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 2000)
+	before := map[string]bool{}
+	for i := range ids {
+		ids[i] = fmt.Sprintf("user-%d", i)
+		before[ids[i]] = flags.Enabled("ramp", ids[i])
+	}
+
+	if changed, err := flags.Reload(); err != nil || changed {
+		t.Fatalf("unchanged file reloaded: %v %v", changed, err)
+	}
+	writeFlags(t, path, `{"ramp": {"percent": 60}}`, start.Add(time.Minute))
+	if changed, err := flags.Reload(); err != nil || !changed {
+		t.Fatalf("reload: %v %v", changed, err)
+	}
+
+	onBefore, onAfter := 0, 0
+	for _, id := range ids {
+		after := flags.Enabled("ramp", id)
+		if before[id] {
+			onBefore++
+			if !after {
+				t.Fatalf("%s lost the new variant when the ramp went up", id)
+			}
+		}
+		if after {
+			onAfter++
+		}
+	}
+	if onAfter <= onBefore*3 {
+		t.Fatalf("ramp did not grow: %d -> %d", onBefore, onAfter)
+	}
+
+	// Битый файл не применяется — остаётся последний рабочий ramp
+	writeFlags(t, path, `{"ramp": {"percent": 250}}`, start.Add(2*time.Minute))
+	if _, err := flags.Reload(); err == nil {
+		t.Fatal("invalid percent accepted")
+	}
+	if snap := flags.Snapshot(); snap[0].Rule == nil || snap[0].Rule.Percent != 60 {
+		t.Fatalf("rules after bad reload: %+v", snap)
+	}
+}
+
+func TestFlagsHandlerRequiresToken(t *testing.T) {
+	flags := newFeatureFlags(filepath.Join(t.TempDir(), "flags.json"), nil)
+	flags.Replace(map[string]FlagRule{"beta": {Percent: 5}}, time.Time{})
+	h := flagsHandler(flags, "secret")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/_flags", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("no token: %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/_flags", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("with token: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	flagsHandler(flags, "").ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("disabled endpoint: %d", rec.Code)
+	}
+}
@@ -0,0 +1,3 @@
+module jsonsrv
+
+go 1.25.1
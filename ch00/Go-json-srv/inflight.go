@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"jsonsrv/store"
+)
+
+// ==== Лимит одновременных запросов (in-flight) ====
+//
+// rateLimit считает запросы за окно, но не мешает одному клиенту открыть
+// сотню медленных загрузок разом: каждая держит горутину, буферы и файл.
+// InFlightLimiter ограничивает, сколько запросов одной личности выполняется
+// одновременно, отдельно по классам маршрутов (загрузки, изменяющие,
+// читающие). Сверх лимита — сразу 429 с кодом InFlightErrorCode, без
+// ожидания очереди.
+//
+// Личность — проверенный API-ключ, иначе пользователь сессии, иначе IP.
+// Непроверенный ключ или чужая cookie считаются по IP: иначе случайные
+// значения заголовка давали бы по новому лимиту на каждый запрос.
+//
+// Счётчик личности удаляется, как только у неё не остаётся запросов, —
+// простаивающие семафоры не копятся. Место освобождается в defer, в том
+// числе при панике хэндлера (её дальше ловит recoverer).
+
+// Классы маршрутов
+const (
+	InFlightRead   = "read"   // GET, HEAD, OPTIONS
+	InFlightWrite  = "write"  // POST, PUT, PATCH, DELETE
+	InFlightUpload = "upload" // маршруты загрузки, любой метод
+)
+
+// InFlightErrorCode — data.code в ответе 429 при превышении лимита
+const InFlightErrorCode = "too_many_in_flight"
+
+// InFlightClassStats — счётчики класса для /api/_inflight
+type InFlightClassStats struct {
+	Limit      int   `json:"limit"`      // 0 — без лимита
+	InFlight   int64 `json:"in_flight"`  // Выполняется сейчас, по всем личностям
+	Identities int   `json:"identities"` // Личностей с запросами в работе
+	Rejected   int64 `json:"rejected"`   // Отказов 429 с запуска
+}
+
+// InFlightLimiter — семафор на личность и класс маршрута
+type InFlightLimiter struct {
+	caps    map[string]int  // класс → лимит на личность; 0 — без лимита
+	uploads map[string]bool // пути загрузок
+	keys    *store.APIKeys
+	store   *store.Sessions
+
+	mu      sync.Mutex
+	running map[string]int // класс + "\x00" + личность → запросов в работе
+
+	inFlight map[string]*atomic.Int64
+	rejected map[string]*atomic.Int64
+}
+
+func newInFlightLimiter(caps map[string]int, uploadRoutes []string, keys *store.APIKeys, sessions *store.Sessions) *InFlightLimiter {
+	l := &InFlightLimiter{
+		caps:     caps,
+		uploads:  make(map[string]bool, len(uploadRoutes)),
+		keys:     keys,
+		store:    sessions,
+		running:  make(map[string]int),
+		inFlight: make(map[string]*atomic.Int64),
+		rejected: make(map[string]*atomic.Int64),
+	}
+	for _, route := range uploadRoutes {
+		l.uploads[route] = true
+	}
+	for _, class := range []string{InFlightRead, InFlightWrite, InFlightUpload} {
+		l.inFlight[class] = new(atomic.Int64)
+		l.rejected[class] = new(atomic.Int64)
+	}
+	return l
+}
+
+// class — класс маршрута запроса
+func (l *InFlightLimiter) class(r *http.Request) string {
+	switch {
+	case l.uploads[r.URL.Path]:
+		return InFlightUpload
+	case isStateChanging(r.Method):
+		return InFlightWrite
+	}
+	return InFlightRead
+}
+
+// identity — "key:<id>", "user:<имя>" или "ip:<адрес>"
+func (l *InFlightLimiter) identity(r *http.Request) string {
+	if v := r.Header.Get(APIKeyHeader); v != "" && l.keys != nil {
+		if k, ok := l.keys.Verify(v); ok {
+			return "key:" + k.ID
+		}
+	} else if c, err := r.Cookie("session"); err == nil && l.store != nil {
+		if sess, ok := l.store.Lookup(c.Value); ok {
+			return "user:" + sess.User
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// acquire занимает место; false — лимит класса уже выбран
+func (l *InFlightLimiter) acquire(class, key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if max := l.caps[class]; max > 0 && l.running[key] >= max {
+		return false
+	}
+	l.running[key]++
+	l.inFlight[class].Add(1)
+	return true
+}
+
+// release освобождает место и удаляет счётчик простаивающей личности
+func (l *InFlightLimiter) release(class, key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running[key]--; l.running[key] <= 0 {
+		delete(l.running, key)
+	}
+	l.inFlight[class].Add(-1)
+}
+
+func (l *InFlightLimiter) Stats() map[string]InFlightClassStats {
+	l.mu.Lock()
+	identities := make(map[string]int, len(l.inFlight))
+	for key := range l.running {
+		class, _, _ := strings.Cut(key, "\x00")
+		identities[class]++
+	}
+	l.mu.Unlock()
+
+	out := make(map[string]InFlightClassStats, len(l.inFlight))
+	for class, n := range l.inFlight {
+		out[class] = InFlightClassStats{
+			Limit:      l.caps[class],
+			InFlight:   n.Load(),
+			Identities: identities[class],
+			Rejected:   l.rejected[class].Load(),
+		}
+	}
+	return out
+}
+
+// Middleware ставится после rateLimit: отвергнутые им запросы мест не занимают
+func (l *InFlightLimiter) Middleware() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class := l.class(r)
+			key := class + "\x00" + l.identity(r)
+			if !l.acquire(class, key) {
+				l.rejected[class].Add(1)
+				w.Header().Set("Retry-After", "1")
+				writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+					"code":  InFlightErrorCode,
+					"class": class,
+					"limit": l.caps[class],
+				})
+				return
+			}
+			defer l.release(class, key)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// inflightHandler — GET /api/_inflight, счётчики по классам; доступ как у /api/_flags
+func inflightHandler(l *InFlightLimiter, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminRequest(w, r, token) {
+			return
+		}
+		writeJSON(w, http.StatusOK, l.Stats())
+	}
+}
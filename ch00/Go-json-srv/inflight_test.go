@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"jsonsrv/store"
+)
+
+// stallingHandler держит каждый запрос, пока не закрыт release; о входе
+// сообщает в entered
+func stallingHandler(entered chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		writeJSON(w, http.StatusOK, "done")
+	})
+}
+
+// stall запускает n запросов req() и ждёт, пока все войдут в хэндлер
+func stall(t *testing.T, h http.Handler, entered <-chan struct{}, n int, req func() *http.Request) *sync.WaitGroup {
+	t.Helper()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), req())
+		}()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-entered:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of %d requests reached the handler", i, n)
+		}
+	}
+	return &wg
+}
+
+func fromIP(method, path, ip string) func() *http.Request {
+	return func() *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("X-Real-IP", ip)
+		return r
+	}
+}
+
+func TestInFlightRejectsOverCapImmediately(t *testing.T) {
+	const n = 3
+	l := newInFlightLimiter(map[string]int{InFlightRead: n, InFlightUpload: 1}, []string{"/api/upload"}, nil, nil)
+	entered, release := make(chan struct{}, 2*n), make(chan struct{})
+	h := l.Middleware()(stallingHandler(entered, release))
+
+	wg := stall(t, h, entered, n, fromIP(http.MethodGet, "/api/list", "10.0.0.1"))
+
+	// N+1-й не ждёт освобождения: ответ приходит, пока первые N висят
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, fromIP(http.MethodGet, "/api/list", "10.0.0.1")())
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusTooManyRequests || resp.Data["code"] != InFlightErrorCode || resp.Data["class"] != InFlightRead {
+		t.Fatalf("N+1th: %d %s", rec.Code, rec.Body)
+	}
+
+	// Другая личность и другой класс считаются отдельно
+	wg2 := stall(t, h, entered, 1, fromIP(http.MethodGet, "/api/list", "10.0.0.2"))
+	wg3 := stall(t, h, entered, 1, fromIP(http.MethodPost, "/api/upload", "10.0.0.1"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, fromIP(http.MethodPost, "/api/upload", "10.0.0.1")())
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second upload: %d", rec.Code)
+	}
+
+	st := l.Stats()
+	if st[InFlightRead].InFlight != n+1 || st[InFlightRead].Identities != 2 || st[InFlightRead].Rejected != 1 ||
+		st[InFlightUpload].InFlight != 1 || st[InFlightUpload].Rejected != 1 || st[InFlightWrite].InFlight != 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+
+	close(release)
+	wg.Wait()
+	wg2.Wait()
+	wg3.Wait()
+	if st := l.Stats(); st[InFlightRead].InFlight != 0 || st[InFlightUpload].InFlight != 0 || len(l.running) != 0 {
+		t.Fatalf("after release: %+v, %d idle counters left", st, len(l.running))
+	}
+}
+
+func TestInFlightReleasedOnPanic(t *testing.T) {
+	l := newInFlightLimiter(map[string]int{InFlightRead: 1}, nil, nil, nil)
+	h := recoverer(l.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, fromIP(http.MethodGet, "/api/x", "10.0.0.1")())
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("request %d: %d, the slot was not released", i, rec.Code)
+		}
+	}
+	if len(l.running) != 0 {
+		t.Fatalf("idle counters left: %v", l.running)
+	}
+}
+
+// Личность — проверенный ключ или пользователь сессии, а не IP; поддельный
+// ключ считается по IP
+func TestInFlightIdentity(t *testing.T) {
+	keys, _ := store.OpenAPIKeys("")
+	k, value, _ := keys.Mint("ci")
+	sessions := store.NewSessions(time.Hour)
+	token, _, _ := sessions.Create("alice")
+	l := newInFlightLimiter(nil, nil, keys, sessions)
+
+	req := func(header, cookie string) *http.Request {
+		r := fromIP(http.MethodGet, "/", "10.0.0.9")()
+		if header != "" {
+			r.Header.Set(APIKeyHeader, header)
+		}
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		}
+		return r
+	}
+	for _, c := range []struct{ header, cookie, want string }{
+		{value, "", "key:" + k.ID},
+		{k.ID + ".forged", token, "ip:10.0.0.9"},
+		{"", token, "user:alice"},
+		{"", "stale", "ip:10.0.0.9"},
+		{"", "", "ip:10.0.0.9"},
+	} {
+		if got := l.identity(req(c.header, c.cookie)); got != c.want {
+			t.Errorf("key %q cookie %q: %q, want %q", c.header, c.cookie, got, c.want)
+		}
+	}
+}
+
+func TestInFlightMetricsEndpoint(t *testing.T) {
+	cfg := LoadConfig()
+	h := NewServer(cfg, Deps{FlagsToken: "admin"})
+	req := httptest.NewRequest(http.MethodGet, "/api/_inflight", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp struct {
+		Data map[string]InFlightClassStats `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	// Сам запрос к /api/_inflight выполняется — он и есть единственный читающий
+	if got := resp.Data[InFlightRead]; got.InFlight != 1 || got.Limit != InFlightReadMax {
+		t.Fatalf("read: %+v", got)
+	}
+	if got := resp.Data[InFlightUpload]; got.Limit != InFlightUploadMax || !strings.Contains(rec.Body.String(), `"write"`) {
+		t.Fatalf("upload: %+v in %s", got, rec.Body)
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
@@ -18,6 +20,9 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"jsonsrv/admin"
+	"jsonsrv/store"
 )
 
 // ==== Константы (JSON API + Nginx) ====
@@ -36,13 +41,34 @@ const (
 	AllowedOrigins       = "https://example.com,https://app.example.com" // Ваши фронтенды
 	RateLimitMaxRequests = 200                                           // Больше для API
 	RateLimitWindow      = 1 * time.Minute
-	SessionTokenLength   = 32
-	SessionMaxAge        = 24 * 3600 // 24 часа
+	InFlightReadMax      = 32 // Одновременных запросов одной личности (inflight.go)
+	InFlightWriteMax     = 8
+	InFlightUploadMax    = 2
+	SessionMaxAge        = 24 * 3600      // 24 часа
+	SessionsFile         = "sessions.dat" // Зашифрованные сессии между рестартами
+	SessionKeyEnv        = "SESSIONS_KEY" // hex AES-256 ключ; пусто = без персистентности
 	MaxUploadFileMB      = 10
+	MaxArchiveUnpackedMB = 100              // Лимит распакованного размера zip
+	UploadDir            = "uploads"        // Куда попадают проверенные файлы
+	UploadScannerEnv     = "UPLOAD_SCANNER" // Внешний сканер, напр. "clamdscan --no-summary"
+	UploadScanTimeout    = 30 * time.Second
+	FlagsFile            = "flags.json"        // Feature flags, перечитываются на лету
+	FlagsReloadInterval  = 5 * time.Second     // Как часто проверять mtime файла флагов
+	FlagsAdminTokenEnv   = "FLAGS_ADMIN_TOKEN" // Bearer для GET /api/_flags; пусто = выключен
+	UsersFile            = "users.json"        // Пользователи (PBKDF2-хэши паролей)
+	APIKeysFile          = "apikeys.json"      // API-ключи (только хэши секретов)
+	APIKeyHeader         = "X-API-Key"         // Ключ "<id>.<secret>" вместо сессии
+	AdminAddr            = "127.0.0.1:8081"    // Админский API для srvctl, только loopback
 
 	// JSON API настройки
-	JSONIndent     = false          // false = компактный JSON
-	CSRFHeaderName = "X-CSRF-Token" // Для API клиентов
+	JSONIndent           = false               // false = компактный JSON
+	CSRFHeaderName       = "X-CSRF-Token"      // Для API клиентов
+	FeatureVariantHeader = "X-Feature-Variant" // Вариант, выбранный WhenFlag
+
+	// Склейка одинаковых одновременных GET (coalesce.go)
+	CoalesceRoutes   = "/healthz"      // Маршруты через запятую; пусто = выключено
+	CoalesceMaxBody  = 1 << 20         // Ответ больше 1MB не делится
+	NoCoalesceHeader = "X-No-Coalesce" // Клиент просит выполнить запрос отдельно
 )
 
 // ==== Конфигурация ====
@@ -58,6 +84,7 @@ type Config struct {
 	IdleTimeout       time.Duration
 	RateLimitMax      int
 	RateLimitWindow   time.Duration
+	InFlightCaps      map[string]int // Класс маршрута → лимит на личность; 0 — без лимита
 }
 
 func LoadConfig() Config {
@@ -72,6 +99,11 @@ func LoadConfig() Config {
 		IdleTimeout:       IdleTimeout,
 		RateLimitMax:      RateLimitMaxRequests,
 		RateLimitWindow:   RateLimitWindow,
+		InFlightCaps: map[string]int{
+			InFlightRead:   InFlightReadMax,
+			InFlightWrite:  InFlightWriteMax,
+			InFlightUpload: InFlightUploadMax,
+		},
 	}
 }
 
@@ -93,13 +125,15 @@ type rateLimiter struct {
 	mu       sync.RWMutex
 	max      int
 	window   time.Duration
+	clock    store.Clock
 }
 
-func newRateLimiter(max int, window time.Duration) *rateLimiter {
+func newRateLimiter(max int, window time.Duration, clock store.Clock) *rateLimiter {
 	return &rateLimiter{
 		requests: make(map[string][]time.Time),
 		max:      max,
 		window:   window,
+		clock:    clock,
 	}
 }
 
@@ -107,7 +141,7 @@ func (rl *rateLimiter) allow(ip string) bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	now := time.Now()
+	now := rl.clock.Now()
 	reqs := rl.requests[ip]
 
 	// Очистка старых записей
@@ -182,12 +216,31 @@ func clientIP(r *http.Request) string {
 	return host
 }
 
-func randomToken(n int) (string, error) {
-	b := make([]byte, n)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+// sessionKeyFromEnv читает 32-байтный ключ (hex) из окружения.
+// Пустая переменная — персистентность выключена.
+func sessionKeyFromEnv() ([]byte, error) {
+	v := os.Getenv(SessionKeyEnv)
+	if v == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(v)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must be 64 hex chars (32 bytes)", SessionKeyEnv)
+	}
+	return key, nil
+}
+
+// isLoopback — адрес вида host:port слушает только localhost
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
 	}
-	return hex.EncodeToString(b), nil
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
 func validateOrigin(allowed []string, originStr string) bool {
@@ -274,7 +327,10 @@ func corsStrict(allowedOrigins []string) middleware {
 	}
 }
 
-func csrfGuard(allowedOrigins []string) middleware {
+// csrfGuard — Origin + CSRF токен для изменяющих запросов. Запрос с
+// API-ключом (заголовок APIKeyHeader) идёт без cookie, CSRF ему не грозит:
+// проверяется только сам ключ.
+func csrfGuard(allowedOrigins []string, sessions *store.Sessions, keys *store.APIKeys) middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if !isStateChanging(r.Method) {
@@ -282,6 +338,15 @@ func csrfGuard(allowedOrigins []string) middleware {
 				return
 			}
 
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				if _, ok := keys.Verify(key); !ok {
+					writeJSON(w, http.StatusUnauthorized, "invalid API key")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// API CSRF: проверяем Origin + CSRF токен
 			origin := r.Header.Get("Origin")
 			csrfToken := r.Header.Get(CSRFHeaderName)
@@ -296,10 +361,29 @@ func csrfGuard(allowedOrigins []string) middleware {
 				return
 			}
 
-			// В реальности: валидация токена против сессии
-			// sessionToken := getSessionToken(r)
-			// if !validateCSRF(sessionToken, csrfToken) { ... }
+			// Если есть сессия — токен должен совпадать с выданным при логине
+			if c, err := r.Cookie("session"); err == nil {
+				if sess, ok := sessions.Lookup(c.Value); ok && sess.CSRFToken != csrfToken {
+					writeJSON(w, http.StatusForbidden, "invalid CSRF token")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
+// maintenanceGuard — в режиме обслуживания публичный API отвечает 503,
+// кроме /healthz (его опрашивает балансировщик).
+func maintenanceGuard(m *admin.Maintenance) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if m.On() && r.URL.Path != "/healthz" {
+				w.Header().Set("Retry-After", "60")
+				writeJSON(w, http.StatusServiceUnavailable, "maintenance")
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -359,77 +443,138 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "version": "1.0"})
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
-	// Парсим JSON credentials
-	var creds struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		writeJSON(w, http.StatusBadRequest, "invalid JSON")
-		return
-	}
+// loginHandler — сессия живёт sessions.TTL(); Expires cookie считается по
+// тем же часам clock, что и срок сессии.
+func loginHandler(sessions *store.Sessions, users *store.Users, clock store.Clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Парсим JSON credentials
+		var creds struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			writeJSON(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
 
-	// В реальности: валидация credentials
-	token, err := randomToken(SessionTokenLength / 2)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, "token generation failed")
-		return
-	}
+		// Пользователи заводятся через srvctl user add
+		if err := users.Verify(creds.Username, creds.Password); err != nil {
+			writeJSON(w, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
 
-	// CSRF токен для клиента
-	csrfToken, _ := randomToken(16)
+		// Сессия + CSRF токен для клиента
+		token, csrfToken, err := sessions.Create(creds.Username)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, "token generation failed")
+			return
+		}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    token,
-		Path:     "/",
-		MaxAge:   SessionMaxAge,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
+		http.SetCookie(w, &http.Cookie{
+			Name:     "session",
+			Value:    token,
+			Path:     "/",
+			MaxAge:   int(sessions.TTL() / time.Second),
+			Expires:  clock.Now().Add(sessions.TTL()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"status":     "ok",
-		"csrf_token": csrfToken,
-		// В реальности: JWT или session ID
-	})
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":     "ok",
+			"csrf_token": csrfToken,
+		})
+	}
 }
 
-func uploadHandler(maxMB int64) http.HandlerFunc {
+// uploadHandler потоково сохраняет поле "file" во временный файл в dir,
+// проверяет его политикой маршрута и только затем переименовывает в итоговый.
+func uploadHandler(maxMB int64, dir string, policy ScanPolicy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// CSRF уже проверен middleware'ом
+		reqID := requestID(r)
 
-		if err := r.ParseMultipartForm(maxMB << 20); err != nil {
+		mr, err := r.MultipartReader()
+		if err != nil {
 			writeJSON(w, http.StatusBadRequest, "invalid multipart form")
 			return
 		}
-
-		file, header, err := r.FormFile("file")
-		if err != nil {
-			writeJSON(w, http.StatusBadRequest, "file required")
-			return
+		var part *multipart.Part
+		for {
+			part, err = mr.NextPart()
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, "file required")
+				return
+			}
+			if part.FormName() == "file" {
+				break
+			}
+			part.Close()
 		}
-		defer file.Close()
+		defer part.Close()
 
 		// Безопасная валидация
-		name := filepath.Base(header.Filename)
+		name := filepath.Base(part.FileName())
 		if name == "." || strings.Contains(name, "..") || strings.ContainsAny(name, "/\\") {
 			writeJSON(w, http.StatusBadRequest, "invalid filename")
 			return
 		}
 
-		ext := strings.ToLower(filepath.Ext(name))
-		allowedExts := map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true}
-		if !allowedExts[ext] {
-			writeJSON(w, http.StatusUnsupportedMediaType, "unsupported file type")
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			writeJSON(w, http.StatusInternalServerError, "storage unavailable")
+			return
+		}
+		tmp, err := os.CreateTemp(dir, ".upload-*")
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, "storage unavailable")
+			return
+		}
+		tmpPath := tmp.Name()
+		keep := false
+		defer func() {
+			if !keep {
+				os.Remove(tmpPath)
+			}
+		}()
+
+		limit := maxMB << 20
+		size, err := io.Copy(tmp, io.LimitReader(part, limit+1))
+		tmp.Close()
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, "upload interrupted")
+			return
+		}
+		if size > limit {
+			writeJSON(w, http.StatusRequestEntityTooLarge, "file too large")
+			return
+		}
+
+		f := ScanFile{Path: tmpPath, Name: name, Size: size, DeclaredType: part.Header.Get("Content-Type")}
+		failed := policy.Run(r.Context(), f)
+		logScan(reqID, f, failed)
+		if len(failed) > 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"request_id":   reqID,
+				"failed_rules": failed,
+			})
+			return
+		}
+
+		final := filepath.Join(dir, name)
+		if _, err := os.Stat(final); err == nil {
+			writeJSON(w, http.StatusConflict, "file already exists")
+			return
+		}
+		if err := os.Rename(tmpPath, final); err != nil {
+			writeJSON(w, http.StatusInternalServerError, "storage unavailable")
 			return
 		}
+		keep = true
 
 		writeJSON(w, http.StatusOK, map[string]interface{}{
 			"status":   "uploaded",
 			"filename": name,
-			"size":     header.Size,
+			"size":     size,
 		})
 	}
 }
@@ -443,28 +588,153 @@ func chain(h http.Handler, m ...middleware) http.Handler {
 	return h
 }
 
-// ==== Main ====
+// Deps — зависимости сервера. Нулевые поля заполняет NewServer: настоящие
+// часы и crypto/rand, хранилища в памяти, флаги без файла. Тесты передают
+// store.FakeClock и store.SeqTokens.
+type Deps struct {
+	Clock       store.Clock
+	Tokens      store.TokenSource
+	Sessions    *store.Sessions // по умолчанию — с Clock и Tokens
+	Users       *store.Users
+	Keys        *store.APIKeys
+	Flags       *FeatureFlags
+	Maintenance *admin.Maintenance
+	Coalescer   *Coalescer
+	FlagsToken  string // Bearer для /api/_flags, /api/_coalesce и /api/_inflight; пусто = выключены
+}
 
-func main() {
-	cfg := LoadConfig()
-	rl := newRateLimiter(cfg.RateLimitMax, cfg.RateLimitWindow)
+// withDefaults заполняет нулевые поля
+func (d Deps) withDefaults() Deps {
+	if d.Clock == nil {
+		d.Clock = store.SystemClock{}
+	}
+	if d.Tokens == nil {
+		d.Tokens = store.CryptoTokens{}
+	}
+	if d.Sessions == nil {
+		d.Sessions = store.NewSessionsWith(SessionMaxAge*time.Second, d.Clock, d.Tokens)
+	}
+	if d.Users == nil {
+		d.Users, _ = store.OpenUsers("") // без файла ошибок не бывает
+	}
+	if d.Keys == nil {
+		d.Keys, _ = store.OpenAPIKeys("")
+	}
+	if d.Flags == nil {
+		d.Flags = newFeatureFlags("", d.Sessions)
+	}
+	if d.Maintenance == nil {
+		d.Maintenance = &admin.Maintenance{}
+	}
+	if d.Coalescer == nil {
+		d.Coalescer = newCoalescer(splitCSV(CoalesceRoutes), CoalesceMaxBody)
+	}
+	return d
+}
+
+// NewServer собирает публичный API: маршруты и цепочку middleware. main
+// обслуживает его через http.Server, тесты — через httptest.
+func NewServer(cfg Config, deps Deps) http.Handler {
+	deps = deps.withDefaults()
+	rl := newRateLimiter(cfg.RateLimitMax, cfg.RateLimitWindow, deps.Clock)
+	policies := uploadPolicies()
+	uploadRoutes := make([]string, 0, len(policies))
+	for route := range policies {
+		uploadRoutes = append(uploadRoutes, route)
+	}
+	inflight := newInFlightLimiter(cfg.InFlightCaps, uploadRoutes, deps.Keys, deps.Sessions)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", healthHandler)
-	mux.HandleFunc("/api/login", loginHandler)
-	mux.Handle("/api/upload", uploadHandler(MaxUploadFileMB))
+	mux.HandleFunc("/api/login", loginHandler(deps.Sessions, deps.Users, deps.Clock))
+	mux.HandleFunc("/api/_flags", flagsHandler(deps.Flags, deps.FlagsToken))
+	mux.HandleFunc("/api/_coalesce", coalesceHandler(deps.Coalescer, deps.FlagsToken))
+	mux.HandleFunc("/api/_inflight", inflightHandler(inflight, deps.FlagsToken))
+	for route, policy := range policies {
+		mux.Handle(route, uploadHandler(MaxUploadFileMB, UploadDir, policy))
+	}
 
 	// API-only middleware stack
-	handler := chain(
+	return chain(
 		mux,
 		requestLogger,
 		recoverer,
 		rateLimit(rl),
+		inflight.Middleware(),
 		secureHeaders(),
-		csrfGuard(cfg.AllowedOrigins),
+		maintenanceGuard(deps.Maintenance),
+		csrfGuard(cfg.AllowedOrigins, deps.Sessions, deps.Keys),
 		corsStrict(cfg.AllowedOrigins),
 		limitBody(cfg.MaxBodyBytes),
+		sparseFields(),
+		deps.Coalescer.Middleware(),
 	)
+}
+
+// ==== Main ====
+
+func main() {
+	discardSessions := flag.Bool("discard-sessions", false, "start with no sessions if "+SessionsFile+" is unreadable")
+	flag.Parse()
+
+	cfg := LoadConfig()
+
+	// Сессии: восстанавливаем из зашифрованного файла, если задан ключ
+	sessions := store.NewSessions(SessionMaxAge * time.Second)
+	sessionKey, err := sessionKeyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sessionKey != nil {
+		n, err := sessions.Load(SessionsFile, sessionKey)
+		switch {
+		case err == nil:
+			log.Printf("restored %d sessions from %s", n, SessionsFile)
+		case *discardSessions:
+			log.Printf("discarding sessions: %v", err)
+		default:
+			log.Fatalf("load %s: %v (use --discard-sessions to start anyway)", SessionsFile, err)
+		}
+	}
+
+	users, err := store.OpenUsers(UsersFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := store.OpenAPIKeys(APIKeysFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maintenance := &admin.Maintenance{}
+
+	// Feature flags: новый эндпоинт подключается через
+	// flags.WhenFlag("name", newHandler, oldHandler)
+	flags := newFeatureFlags(FlagsFile, sessions)
+	if err := flags.Load(); err != nil {
+		log.Fatal(err)
+	}
+	stopFlags := make(chan struct{})
+	go flags.Watch(FlagsReloadInterval, stopFlags)
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := flags.Load(); err != nil {
+				log.Printf("flags reload: %v", err)
+				continue
+			}
+			log.Printf("flags reloaded from %s (SIGHUP)", FlagsFile)
+		}
+	}()
+
+	handler := NewServer(cfg, Deps{
+		Sessions:    sessions,
+		Users:       users,
+		Keys:        keys,
+		Flags:       flags,
+		Maintenance: maintenance,
+		FlagsToken:  os.Getenv(FlagsAdminTokenEnv),
+	})
 
 	srv := &http.Server{
 		Addr:              cfg.Addr,
@@ -475,6 +745,26 @@ func main() {
 		MaxHeaderBytes:    cfg.MaxHeaderBytes,
 	}
 
+	// Админский API (srvctl): отдельный listener, только loopback
+	var adminSrv *http.Server
+	if token := os.Getenv(admin.TokenEnv); token != "" {
+		if !isLoopback(AdminAddr) {
+			log.Fatalf("admin API must listen on loopback, got %s", AdminAddr)
+		}
+		adminAPI := &admin.Server{Users: users, Keys: keys, Sessions: sessions, Maintenance: maintenance, Token: token}
+		adminSrv = &http.Server{
+			Addr:              AdminAddr,
+			Handler:           chain(adminAPI.Handler(), requestLogger, recoverer),
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		}
+		go func() {
+			log.Printf("admin API on %s", AdminAddr)
+			if err := adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	idleConnsClosed := make(chan struct{})
 	go func() {
@@ -483,12 +773,21 @@ func main() {
 		<-sigint
 
 		log.Println("shutting down...")
+		close(stopFlags)
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		if err := srv.Shutdown(ctx); err != nil {
 			log.Printf("shutdown error: %v", err)
 		}
+		if adminSrv != nil {
+			adminSrv.Shutdown(ctx)
+		}
+		if sessionKey != nil {
+			if err := sessions.Save(SessionsFile, sessionKey); err != nil {
+				log.Printf("save sessions: %v", err)
+			}
+		}
 		close(idleConnsClosed)
 	}()
 
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jsonsrv/admin"
+	"jsonsrv/store"
+)
+
+func TestLoginChecksPassword(t *testing.T) {
+	users, _ := store.OpenUsers("")
+	if _, err := users.Add("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	h := loginHandler(store.NewSessions(time.Hour), users, store.SystemClock{})
+	for body, want := range map[string]int{
+		`{"username":"alice","password":"correct horse"}`: http.StatusOK,
+		`{"username":"alice","password":"wrong horse"}`:   http.StatusUnauthorized,
+		`{"username":"mallory","password":"anything"}`:    http.StatusUnauthorized,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Fatalf("%s: %d, want %d", body, rec.Code, want)
+		}
+	}
+}
+
+func TestAPIKeyInsteadOfCSRF(t *testing.T) {
+	keys, _ := store.OpenAPIKeys("")
+	k, value, err := keys.Mint("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := csrfGuard([]string{"https://example.com"}, store.NewSessions(time.Hour), keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	try := func(key string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", nil)
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if got := try(""); got != http.StatusForbidden {
+		t.Fatalf("no key, no CSRF: %d", got)
+	}
+	if got := try(value); got != http.StatusNoContent {
+		t.Fatalf("valid key: %d", got)
+	}
+	if got := try(k.ID + ".forged"); got != http.StatusUnauthorized {
+		t.Fatalf("forged key: %d", got)
+	}
+	keys.Revoke(k.ID)
+	if got := try(value); got != http.StatusUnauthorized {
+		t.Fatalf("revoked key: %d", got)
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	m := &admin.Maintenance{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/api/x", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, "x") })
+	h := maintenanceGuard(m)(mux)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if rec := get("/api/x"); rec.Code != http.StatusOK {
+		t.Fatalf("off: %d", rec.Code)
+	}
+	m.Set(true)
+	if rec := get("/api/x"); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("on: %d", rec.Code)
+	}
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Fatalf("healthz during maintenance: %d", rec.Code)
+	}
+}
+
+func TestAdminAddrIsLoopback(t *testing.T) {
+	if !isLoopback(AdminAddr) {
+		t.Fatalf("AdminAddr %s must be loopback", AdminAddr)
+	}
+	for addr, want := range map[string]bool{"127.0.0.1:1": true, "[::1]:1": true, "localhost:1": true, "0.0.0.0:1": false, ":8081": false} {
+		if isLoopback(addr) != want {
+			t.Fatalf("isLoopback(%q) != %v", addr, want)
+		}
+	}
+}
+
+// testServer — полный API с поддельными часами и токенами
+func testServer(t *testing.T, cfg Config) (http.Handler, *store.FakeClock, Deps) {
+	t.Helper()
+	clock := store.NewFakeClock(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	deps := Deps{Clock: clock, Tokens: &store.SeqTokens{}}
+	deps.Sessions = store.NewSessionsWith(time.Hour, clock, deps.Tokens)
+	deps.Users, _ = store.OpenUsers("")
+	if _, err := deps.Users.Add("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(cfg, deps), clock, deps
+}
+
+func TestSessionExpiresOnFakeClock(t *testing.T) {
+	cfg := LoadConfig()
+	h, clock, deps := testServer(t, cfg)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username":"alice","password":"correct horse"}`))
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set(CSRFHeaderName, "login")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session" {
+			cookie = c
+		}
+	}
+	// SeqTokens: первый токен — сессия, второй — CSRF
+	if cookie == nil || cookie.Value != "00000000000000000000000000000001" {
+		t.Fatalf("session cookie %v", cookie)
+	}
+	if !strings.Contains(rec.Body.String(), `"csrf_token":"00000000000000000000000000000002"`) {
+		t.Fatalf("csrf token: %s", rec.Body)
+	}
+	if want := clock.Now().Add(time.Hour); !cookie.Expires.Equal(want) || cookie.MaxAge != 3600 {
+		t.Fatalf("cookie expires %v max-age %d, want %v", cookie.Expires, cookie.MaxAge, want)
+	}
+
+	clock.Advance(time.Hour - time.Second)
+	if _, ok := deps.Sessions.Lookup(cookie.Value); !ok {
+		t.Fatal("session gone before its TTL")
+	}
+	clock.Advance(2 * time.Second)
+	if _, ok := deps.Sessions.Lookup(cookie.Value); ok {
+		t.Fatal("session alive after its TTL")
+	}
+}
+
+func TestRateLimitWindowRollsOverOnFakeClock(t *testing.T) {
+	cfg := LoadConfig()
+	cfg.RateLimitMax, cfg.RateLimitWindow = 2, time.Minute
+	h, clock, _ := testServer(t, cfg)
+	get := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.Header.Set("X-Real-IP", "203.0.113.7")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if a, b, c := get(), get(), get(); a != http.StatusOK || b != http.StatusOK || c != http.StatusTooManyRequests {
+		t.Fatalf("first window: %d %d %d", a, b, c)
+	}
+	clock.Advance(time.Minute - time.Second)
+	if got := get(); got != http.StatusTooManyRequests {
+		t.Fatalf("still inside the window: %d", got)
+	}
+	clock.Advance(time.Second)
+	if a, b, c := get(), get(), get(); a != http.StatusOK || b != http.StatusOK || c != http.StatusTooManyRequests {
+		t.Fatalf("after rollover: %d %d %d", a, b, c)
+	}
+}
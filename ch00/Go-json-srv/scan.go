@@ -0,0 +1,291 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"jsonsrv/store"
+)
+
+// ==== Проверка загрузок (ScanPolicy) ====
+//
+// Файл сначала потоково сохраняется во временный, затем проходит правила
+// политики маршрута и только после этого переименовывается в итоговый.
+// Любое проваленное правило — временный файл удаляется, ответ 422.
+
+// ScanFile — то, что видят правила: временный файл и заявленные клиентом данные
+type ScanFile struct {
+	Path         string // Временный файл на диске
+	Name         string // Имя от клиента (уже очищенное)
+	Size         int64
+	DeclaredType string // Content-Type части multipart
+}
+
+// ScanRule — одно правило проверки. nil = файл прошёл.
+type ScanRule interface {
+	Name() string
+	Check(ctx context.Context, f ScanFile) error
+}
+
+// ScanPolicy — набор правил для маршрута
+type ScanPolicy struct {
+	Rules []ScanRule
+}
+
+// ScanFailure — проваленное правило и причина
+type ScanFailure struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// Run прогоняет все правила (не останавливаясь на первом), чтобы клиент
+// увидел полный список причин.
+func (p ScanPolicy) Run(ctx context.Context, f ScanFile) []ScanFailure {
+	var failed []ScanFailure
+	for _, rule := range p.Rules {
+		if err := rule.Check(ctx, f); err != nil {
+			failed = append(failed, ScanFailure{Rule: rule.Name(), Reason: err.Error()})
+		}
+	}
+	return failed
+}
+
+// readHead читает первые n байт файла
+func readHead(path string, n int) ([]byte, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	buf := make([]byte, n)
+	read, err := io.ReadFull(fh, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:read], nil
+}
+
+// ---- extension / MIME ----
+
+// ExtensionRule — расширение из белого списка, а реальное содержимое
+// (http.DetectContentType) соответствует расширению.
+type ExtensionRule struct {
+	Allowed map[string][]string // ".png" → допустимые MIME-типы содержимого
+}
+
+func (ExtensionRule) Name() string { return "extension_mime" }
+
+func (r ExtensionRule) Check(ctx context.Context, f ScanFile) error {
+	ext := strings.ToLower(filepath.Ext(f.Name))
+	types, ok := r.Allowed[ext]
+	if !ok {
+		return fmt.Errorf("extension %q not allowed", ext)
+	}
+	head, err := readHead(f.Path, 512)
+	if err != nil {
+		return err
+	}
+	sniffed := http.DetectContentType(head)
+	for _, t := range types {
+		if strings.HasPrefix(sniffed, t) {
+			return nil
+		}
+	}
+	return fmt.Errorf("content %s does not match extension %s", sniffed, ext)
+}
+
+// ---- archives ----
+
+// ArchiveSizeRule — ограничение распакованного размера zip-архивов.
+// Считаются реально распакованные байты, а не размеры из заголовков.
+type ArchiveSizeRule struct {
+	MaxBytes int64
+}
+
+func (ArchiveSizeRule) Name() string { return "archive_size" }
+
+func (r ArchiveSizeRule) Check(ctx context.Context, f ScanFile) error {
+	head, err := readHead(f.Path, 4)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(head, []byte("PK\x03\x04")) {
+		return nil // Не zip — правило неприменимо
+	}
+	zr, err := zip.OpenReader(f.Path)
+	if err != nil {
+		return fmt.Errorf("corrupt archive: %w", err)
+	}
+	defer zr.Close()
+
+	var total int64
+	for _, zf := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("corrupt archive entry %s: %w", zf.Name, err)
+		}
+		n, err := io.Copy(io.Discard, io.LimitReader(rc, r.MaxBytes-total+1))
+		rc.Close()
+		total += n
+		if total > r.MaxBytes {
+			return fmt.Errorf("decompressed size exceeds %d bytes", r.MaxBytes)
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt archive entry %s: %w", zf.Name, err)
+		}
+	}
+	return nil
+}
+
+// ---- executables ----
+
+// execScanWindow — сколько байт от начала файла просматривается
+const execScanWindow = 4 << 10
+
+// ExecutableRule ищет сигнатуры исполняемых файлов в первых 4KB: ELF
+// (\x7fELF) в любом месте и MZ. Два байта "MZ" часто встречаются в сжатых
+// данных картинок, поэтому MZ не в начале файла засчитывается только вместе
+// с PE-заголовком, на который указывает e_lfanew.
+type ExecutableRule struct{}
+
+func (ExecutableRule) Name() string { return "embedded_executable" }
+
+func (ExecutableRule) Check(ctx context.Context, f ScanFile) error {
+	head, err := readHead(f.Path, execScanWindow)
+	if err != nil {
+		return err
+	}
+	if i := bytes.Index(head, []byte("\x7fELF")); i >= 0 {
+		return fmt.Errorf("ELF signature at offset %d", i)
+	}
+	if bytes.HasPrefix(head, []byte("MZ")) {
+		return errors.New("MZ signature at offset 0")
+	}
+	for off := 0; ; off++ {
+		i := bytes.Index(head[off:], []byte("MZ"))
+		if i < 0 {
+			return nil
+		}
+		off += i
+		if hasPEHeader(head, off) {
+			return fmt.Errorf("PE executable at offset %d", off)
+		}
+	}
+}
+
+// hasPEHeader проверяет "PE\0\0" по смещению e_lfanew от DOS-заголовка в mz
+func hasPEHeader(buf []byte, mz int) bool {
+	if mz+0x40 > len(buf) {
+		return false
+	}
+	lfanew := int(binary.LittleEndian.Uint32(buf[mz+0x3c:]))
+	pe := mz + lfanew
+	return lfanew > 0 && pe+4 <= len(buf) && bytes.Equal(buf[pe:pe+4], []byte("PE\x00\x00"))
+}
+
+// ---- external scanner ----
+
+// ExternalScanner — внешний антивирус (например clamdscan): команда
+// вызывается с путём к временному файлу последним аргументом.
+// Код выхода 0 — чисто, иначе файл отклоняется; превышение Timeout — тоже отказ.
+type ExternalScanner struct {
+	Command string
+	Args    []string
+	Timeout time.Duration
+}
+
+func (s ExternalScanner) Name() string { return "external:" + filepath.Base(s.Command) }
+
+func (s ExternalScanner) Check(ctx context.Context, f ScanFile) error {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	args := append(append([]string{}, s.Args...), f.Path)
+	cmd := exec.CommandContext(ctx, s.Command, args...)
+	cmd.WaitDelay = time.Second // не ждать вечно дочерние процессы сканера
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("scanner timed out after %v", s.Timeout)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if len(msg) > 200 {
+			msg = msg[:200]
+		}
+		return fmt.Errorf("scanner rejected file: %v %s", err, msg)
+	}
+	return nil
+}
+
+// ---- политики маршрутов ----
+
+// imageExtensions — бывший жёсткий белый список uploadHandler
+var imageExtensions = map[string][]string{
+	".png":  {"image/png"},
+	".jpg":  {"image/jpeg"},
+	".jpeg": {"image/jpeg"},
+	".gif":  {"image/gif"},
+}
+
+// uploadPolicies — политика для каждого маршрута загрузки. Внешний сканер
+// добавляется ко всем, если задана переменная UploadScannerEnv.
+func uploadPolicies() map[string]ScanPolicy {
+	policies := map[string]ScanPolicy{
+		"/api/upload": {Rules: []ScanRule{
+			ExtensionRule{Allowed: imageExtensions},
+			ExecutableRule{},
+		}},
+		"/api/upload/archive": {Rules: []ScanRule{
+			ExtensionRule{Allowed: map[string][]string{".zip": {"application/zip"}}},
+			ArchiveSizeRule{MaxBytes: MaxArchiveUnpackedMB << 20},
+			ExecutableRule{},
+		}},
+	}
+	if cmdline := strings.Fields(os.Getenv(UploadScannerEnv)); len(cmdline) > 0 {
+		ext := ExternalScanner{Command: cmdline[0], Args: cmdline[1:], Timeout: UploadScanTimeout}
+		for route, p := range policies {
+			p.Rules = append(p.Rules, ext)
+			policies[route] = p
+		}
+	}
+	return policies
+}
+
+// requestID — X-Request-ID от Nginx ($request_id) или случайный
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	id, err := store.RandomToken(8)
+	if err != nil {
+		return "-"
+	}
+	return id
+}
+
+// logScan пишет итог проверки с ID запроса
+func logScan(reqID string, f ScanFile, failed []ScanFailure) {
+	if len(failed) == 0 {
+		log.Printf("scan req=%s file=%q size=%d result=clean", reqID, f.Name, f.Size)
+		return
+	}
+	rules := make([]string, len(failed))
+	for i, fl := range failed {
+		rules[i] = fl.Rule + "(" + fl.Reason + ")"
+	}
+	log.Printf("scan req=%s file=%q size=%d result=rejected rules=%s", reqID, f.Name, f.Size, strings.Join(rules, ","))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pngHeader — минимальное начало PNG, достаточное для DetectContentType
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func writeTemp(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "upload.tmp")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtensionRule(t *testing.T) {
+	rule := ExtensionRule{Allowed: imageExtensions}
+	png := writeTemp(t, pngHeader)
+	if err := rule.Check(context.Background(), ScanFile{Path: png, Name: "cat.png"}); err != nil {
+		t.Fatalf("real png rejected: %v", err)
+	}
+	if err := rule.Check(context.Background(), ScanFile{Path: png, Name: "cat.jpg"}); err == nil {
+		t.Fatal("png content with .jpg extension should fail")
+	}
+	if err := rule.Check(context.Background(), ScanFile{Path: png, Name: "cat.exe"}); err == nil {
+		t.Fatal(".exe should not be allowed")
+	}
+}
+
+func zipWith(t *testing.T, size int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("big.txt")
+	w.Write(bytes.Repeat([]byte("a"), size))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestArchiveSizeRule(t *testing.T) {
+	rule := ArchiveSizeRule{MaxBytes: 1000}
+	ctx := context.Background()
+	if err := rule.Check(ctx, ScanFile{Path: writeTemp(t, zipWith(t, 1000))}); err != nil {
+		t.Fatalf("archive at the limit rejected: %v", err)
+	}
+	if err := rule.Check(ctx, ScanFile{Path: writeTemp(t, zipWith(t, 100000))}); err == nil {
+		t.Fatal("archive over the limit should fail")
+	}
+	if err := rule.Check(ctx, ScanFile{Path: writeTemp(t, pngHeader)}); err != nil {
+		t.Fatalf("non-archives are not this rule's business: %v", err)
+	}
+}
+
+func TestExecutableRule(t *testing.T) {
+	rule := ExecutableRule{}
+	ctx := context.Background()
+
+	elf := append(append([]byte{}, pngHeader...), []byte("....\x7fELF\x02\x01")...)
+	if err := rule.Check(ctx, ScanFile{Path: writeTemp(t, elf)}); err == nil {
+		t.Fatal("embedded ELF should fail")
+	}
+
+	// PE спрятан после картинки: MZ + e_lfanew → "PE\0\0"
+	pe := make([]byte, 1024)
+	copy(pe, pngHeader)
+	mz := 200
+	copy(pe[mz:], "MZ")
+	binary.LittleEndian.PutUint32(pe[mz+0x3c:], 0x80)
+	copy(pe[mz+0x80:], "PE\x00\x00")
+	if err := rule.Check(ctx, ScanFile{Path: writeTemp(t, pe)}); err == nil {
+		t.Fatal("embedded PE should fail")
+	}
+
+	// Случайные "MZ" в данных картинки — не исполняемый файл
+	noise := make([]byte, 1024)
+	copy(noise, pngHeader)
+	copy(noise[300:], "MZ")
+	if err := rule.Check(ctx, ScanFile{Path: writeTemp(t, noise)}); err != nil {
+		t.Fatalf("stray MZ bytes should not fail: %v", err)
+	}
+
+	// Исполняемый файл за пределами 4KB не ищется
+	late := make([]byte, execScanWindow+100)
+	copy(late[execScanWindow+10:], "\x7fELF")
+	if err := rule.Check(ctx, ScanFile{Path: writeTemp(t, late)}); err != nil {
+		t.Fatalf("signature past the scan window: %v", err)
+	}
+}
+
+func TestExternalScanner(t *testing.T) {
+	path := writeTemp(t, pngHeader)
+	ctx := context.Background()
+	if err := (ExternalScanner{Command: "true", Timeout: time.Second}).Check(ctx, ScanFile{Path: path}); err != nil {
+		t.Fatalf("clean scan: %v", err)
+	}
+	if err := (ExternalScanner{Command: "false", Timeout: time.Second}).Check(ctx, ScanFile{Path: path}); err == nil {
+		t.Fatal("non-zero exit should reject")
+	}
+
+	slow := ExternalScanner{Command: "sh", Args: []string{"-c", "exec sleep 5"}, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err := slow.Check(ctx, ScanFile{Path: path})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("timeout not enforced: took %v", time.Since(start))
+	}
+}
+
+func TestUploadRejectedWith422(t *testing.T) {
+	dir := t.TempDir()
+	h := uploadHandler(1, dir, ScanPolicy{Rules: []ScanRule{ExtensionRule{Allowed: imageExtensions}, ExecutableRule{}}})
+
+	upload := func(name string, data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", name)
+		fw.Write(data)
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("X-Request-ID", "req-1")
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	rec := upload("evil.png", append(append([]byte{}, pngHeader...), "\x7fELF"...))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "embedded_executable") {
+		t.Fatalf("expected 422 naming the rule, got %d %s", rec.Code, rec.Body.String())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("rejected upload left files behind: %v", entries)
+	}
+
+	if rec := upload("cat.png", pngHeader); rec.Code != http.StatusOK {
+		t.Fatalf("clean upload: %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cat.png")); err != nil {
+		t.Fatalf("clean upload not stored: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==== API-ключи ====
+
+// Ключ выглядит как "<id>.<secret>"; значение показывается один раз при
+// выпуске, в apikeys.json лежат только id и SHA-256 секрета. Отозванные
+// ключи остаются в списке с отметкой времени.
+
+var ErrKeyNotFound = errors.New("api key not found")
+
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"` // для кого ключ
+	SecretHash string     `json:"secret_hash"`
+	Created    time.Time  `json:"created"`
+	Revoked    *time.Time `json:"revoked,omitempty"`
+}
+
+type APIKeys struct {
+	mu   sync.RWMutex
+	path string
+	keys map[string]APIKey // ключ — ID
+}
+
+// OpenAPIKeys загружает ключи из path (пустой path — только память).
+func OpenAPIKeys(path string) (*APIKeys, error) {
+	k := &APIKeys{path: path, keys: make(map[string]APIKey)}
+	var list []APIKey
+	if err := loadJSON(path, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, key := range list {
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+// Mint выпускает ключ для name и возвращает его запись и полное значение.
+func (k *APIKeys) Mint(name string) (APIKey, string, error) {
+	if !validName(name) {
+		return APIKey{}, "", ErrInvalidName
+	}
+	id, err := RandomToken(6)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := RandomToken(24)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKey{ID: id, Name: name, SecretHash: HashToken(secret), Created: time.Now().UTC()}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = key
+	if err := k.saveLocked(); err != nil {
+		delete(k.keys, id)
+		return APIKey{}, "", err
+	}
+	return key, id + "." + secret, nil
+}
+
+// Revoke отзывает ключ; повторный отзыв не ошибка.
+func (k *APIKeys) Revoke(id string) (APIKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return APIKey{}, ErrKeyNotFound
+	}
+	if key.Revoked == nil {
+		now := time.Now().UTC()
+		key.Revoked = &now
+		k.keys[id] = key
+		if err := k.saveLocked(); err != nil {
+			return APIKey{}, err
+		}
+	}
+	return key, nil
+}
+
+// Verify проверяет полное значение ключа; отозванные не проходят.
+func (k *APIKeys) Verify(value string) (APIKey, bool) {
+	id, secret, ok := strings.Cut(value, ".")
+	if !ok {
+		return APIKey{}, false
+	}
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok || key.Revoked != nil {
+		return APIKey{}, false
+	}
+	if subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(key.SecretHash)) != 1 {
+		return APIKey{}, false
+	}
+	return key, true
+}
+
+// List — все ключи, старые первыми.
+func (k *APIKeys) List() []APIKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.sortedLocked()
+}
+
+func (k *APIKeys) sortedLocked() []APIKey {
+	out := make([]APIKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		out = append(out, key)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Created.Equal(out[j].Created) {
+			return out[i].Created.Before(out[j].Created)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+func (k *APIKeys) saveLocked() error {
+	return saveJSON(k.path, k.sortedLocked())
+}
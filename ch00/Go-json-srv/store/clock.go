@@ -0,0 +1,77 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ==== Часы и токены ====
+
+// Сессии, rate limiter и логин берут время и случайные токены не напрямую,
+// а через Clock и TokenSource. В работе это SystemClock и CryptoTokens;
+// тесты подставляют FakeClock и SeqTokens и проверяют истечение сессий и
+// окна лимитов без sleep.
+
+// Clock — источник текущего времени
+type Clock interface {
+	Now() time.Time
+}
+
+// TokenSource выдаёт токены из n случайных байт в hex
+type TokenSource interface {
+	Token(n int) (string, error)
+}
+
+// SystemClock — настоящие часы
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// CryptoTokens — токены из crypto/rand (RandomToken)
+type CryptoTokens struct{}
+
+func (CryptoTokens) Token(n int) (string, error) { return RandomToken(n) }
+
+// FakeClock — часы, которые идут только по Advance/Set. Для тестов.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance переводит часы вперёд на d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// SeqTokens — предсказуемые токены: номер вызова в hex нужной длины
+// (…0001, …0002, …). Для тестов.
+type SeqTokens struct {
+	mu sync.Mutex
+	n  uint64
+}
+
+func (s *SeqTokens) Token(n int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	return fmt.Sprintf("%0*x", 2*n, s.n), nil
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ==== Сессии ====
+
+// Токены хранятся только в виде SHA-256: ни память процесса, ни файл
+// sessions.dat не позволяют восстановить значение cookie.
+
+// SessionTokenBytes — длина токена сессии до hex-кодирования
+const SessionTokenBytes = 16
+
+type Session struct {
+	TokenHash string    `json:"token_hash"`
+	User      string    `json:"user"`
+	CSRFToken string    `json:"csrf_token"`
+	Expires   time.Time `json:"expires"`
+}
+
+type Sessions struct {
+	mu       sync.RWMutex
+	sessions map[string]Session // ключ — HashToken(token)
+	ttl      time.Duration
+	clock    Clock
+	tokens   TokenSource
+}
+
+func NewSessions(ttl time.Duration) *Sessions {
+	return NewSessionsWith(ttl, SystemClock{}, CryptoTokens{})
+}
+
+// NewSessionsWith — хранилище со своими часами и источником токенов (тесты)
+func NewSessionsWith(ttl time.Duration, clock Clock, tokens TokenSource) *Sessions {
+	return &Sessions{
+		sessions: make(map[string]Session),
+		ttl:      ttl,
+		clock:    clock,
+		tokens:   tokens,
+	}
+}
+
+// TTL — время жизни новой сессии
+func (s *Sessions) TTL() time.Duration {
+	return s.ttl
+}
+
+// Create выдаёт новую сессию и возвращает токен для cookie и CSRF токен.
+func (s *Sessions) Create(user string) (token, csrf string, err error) {
+	token, err = s.tokens.Token(SessionTokenBytes)
+	if err != nil {
+		return "", "", err
+	}
+	csrf, err = s.tokens.Token(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := HashToken(token)
+	s.sessions[h] = Session{
+		TokenHash: h,
+		User:      user,
+		CSRFToken: csrf,
+		Expires:   s.clock.Now().Add(s.ttl),
+	}
+	return token, csrf, nil
+}
+
+// Lookup ищет сессию по значению cookie (хэшируя его).
+func (s *Sessions) Lookup(token string) (Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess, ok := s.sessions[HashToken(token)]
+	if !ok || s.clock.Now().After(sess.Expires) {
+		return Session{}, false
+	}
+	return sess, true
+}
+
+// List — активные сессии, ближайшие к истечению первыми.
+func (s *Sessions) List() []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.clock.Now()
+	out := make([]Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		if now.Before(sess.Expires) {
+			out = append(out, sess)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Expires.Before(out[j].Expires) })
+	return out
+}
+
+// Purge удаляет сессии пользователя user (пустой user — все) и заодно
+// истёкшие. Возвращает число удалённых активных сессий.
+func (s *Sessions) Purge(user string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	n := 0
+	for h, sess := range s.sessions {
+		expired := !now.Before(sess.Expires)
+		if expired || user == "" || sess.User == user {
+			delete(s.sessions, h)
+			if !expired {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// ==== Персистентность сессий (AES-GCM) ====
+
+var ErrSessionsCorrupt = errors.New("sessions file is corrupt or the key is wrong")
+
+// Save шифрует активные сессии и пишет их в path: nonce || ciphertext.
+func (s *Sessions) Save(path string, key []byte) error {
+	plain, err := json.Marshal(s.List())
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, gcm.Seal(nonce, nonce, plain, nil))
+}
+
+// Load читает path, отбрасывая истёкшие сессии. Отсутствие файла — не ошибка.
+func (s *Sessions) Load(path string, key []byte) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return 0, ErrSessionsCorrupt
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return 0, ErrSessionsCorrupt
+	}
+	var list []Session
+	if err := json.Unmarshal(plain, &list); err != nil {
+		return 0, ErrSessionsCorrupt
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	loaded := 0
+	for _, sess := range list {
+		if now.Before(sess.Expires) {
+			s.sessions[sess.TokenHash] = sess
+			loaded++
+		}
+	}
+	return loaded, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
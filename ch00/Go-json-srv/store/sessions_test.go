@@ -0,0 +1,107 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testKey() []byte {
+	return bytes.Repeat([]byte{7}, 32)
+}
+
+func TestSessionsRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.dat")
+	store := NewSessions(time.Hour)
+	token, csrf, err := store.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Истёкшая сессия не должна пережить рестарт
+	store.sessions["stale"] = Session{TokenHash: "stale", User: "bob", Expires: time.Now().Add(-time.Minute)}
+
+	if err := store.Save(path, testKey()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte(token)) || bytes.Contains(data, []byte("alice")) {
+		t.Fatal("sessions file must be encrypted")
+	}
+
+	restored := NewSessions(time.Hour)
+	n, err := restored.Load(path, testKey())
+	if err != nil || n != 1 {
+		t.Fatalf("load: n=%d err=%v", n, err)
+	}
+	sess, ok := restored.Lookup(token)
+	if !ok || sess.User != "alice" || sess.CSRFToken != csrf {
+		t.Fatalf("session not restored: %+v ok=%v", sess, ok)
+	}
+	if _, ok := restored.Lookup(HashToken(token)); ok {
+		t.Fatal("the stored hash must not work as a cookie value")
+	}
+}
+
+func TestSessionsTamperedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.dat")
+	store := NewSessions(time.Hour)
+	if _, _, err := store.Create("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(path, testKey()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewSessions(time.Hour)
+	if _, err := restored.Load(path, testKey()); !errors.Is(err, ErrSessionsCorrupt) {
+		t.Fatalf("expected ErrSessionsCorrupt, got %v", err)
+	}
+	if len(restored.sessions) != 0 {
+		t.Fatal("nothing should be loaded from a tampered file")
+	}
+
+	wrongKey := bytes.Repeat([]byte{8}, 32)
+	if err := store.Save(path, testKey()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Load(path, wrongKey); !errors.Is(err, ErrSessionsCorrupt) {
+		t.Fatalf("wrong key: expected ErrSessionsCorrupt, got %v", err)
+	}
+}
+
+func TestSessionsMissingFile(t *testing.T) {
+	store := NewSessions(time.Hour)
+	if n, err := store.Load(filepath.Join(t.TempDir(), "none.dat"), testKey()); err != nil || n != 0 {
+		t.Fatalf("missing file should be fine: n=%d err=%v", n, err)
+	}
+}
+
+func TestSessionsListAndPurge(t *testing.T) {
+	store := NewSessions(time.Hour)
+	for _, user := range []string{"alice", "alice", "bob"} {
+		if _, _, err := store.Create(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.sessions["stale"] = Session{TokenHash: "stale", User: "bob", Expires: time.Now().Add(-time.Minute)}
+	if n := len(store.List()); n != 3 {
+		t.Fatalf("list: %d active sessions, want 3", n)
+	}
+	if n := store.Purge("alice"); n != 2 {
+		t.Fatalf("purged %d of alice's sessions, want 2", n)
+	}
+	if _, ok := store.sessions["stale"]; ok {
+		t.Fatal("purge should drop expired sessions too")
+	}
+	if n := store.Purge(""); n != 1 || len(store.sessions) != 0 {
+		t.Fatalf("purge all: %d, left %d", n, len(store.sessions))
+	}
+}
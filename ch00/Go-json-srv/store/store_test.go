@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUsersAddVerifyReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	users, err := OpenUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Add("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Add("alice", "another one"); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("duplicate: %v", err)
+	}
+	if _, err := users.Add("bob", "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("weak password: %v", err)
+	}
+	if _, err := users.Add("../etc", "long enough"); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("bad name: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "correct horse") {
+		t.Fatal("password stored in clear")
+	}
+
+	reloaded, err := OpenUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Verify("alice", "correct horse"); err != nil {
+		t.Fatalf("verify after reload: %v", err)
+	}
+	if reloaded.Verify("alice", "wrong horse") == nil || reloaded.Verify("nobody", "correct horse") == nil {
+		t.Fatal("bad credentials accepted")
+	}
+	if list := reloaded.List(); len(list) != 1 || list[0].Name != "alice" {
+		t.Fatalf("list: %+v", list)
+	}
+}
+
+func TestAPIKeysMintVerifyRevoke(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	keys, err := OpenAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, value, err := keys.Mint("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(value, k.ID+".") {
+		t.Fatalf("key %q should start with its id %q", value, k.ID)
+	}
+	if got, ok := keys.Verify(value); !ok || got.Name != "ci" {
+		t.Fatal("fresh key rejected")
+	}
+	if _, ok := keys.Verify(k.ID + ".deadbeef"); ok {
+		t.Fatal("wrong secret accepted")
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), strings.TrimPrefix(value, k.ID+".")) {
+		t.Fatal("secret stored in clear")
+	}
+
+	reloaded, err := OpenAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.Verify(value); !ok {
+		t.Fatal("key lost on reload")
+	}
+	if _, err := reloaded.Revoke(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.Verify(value); ok {
+		t.Fatal("revoked key accepted")
+	}
+	if _, err := reloaded.Revoke("nope"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("unknown id: %v", err)
+	}
+	again, _ := OpenAPIKeys(path)
+	if list := again.List(); len(list) != 1 || list[0].Revoked == nil {
+		t.Fatalf("revocation not persisted: %+v", list)
+	}
+}
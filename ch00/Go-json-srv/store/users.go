@@ -0,0 +1,146 @@
+package store
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==== Пользователи ====
+
+// Пароли хранятся как PBKDF2-SHA256 с солью; файл users.json пишется
+// атомарно после каждого изменения.
+
+const (
+	passwordIterations = 100_000
+	passwordKeyLen     = 32
+	MinPasswordLen     = 8
+)
+
+var (
+	ErrUserExists    = errors.New("user already exists")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrWeakPassword  = fmt.Errorf("password must be at least %d characters", MinPasswordLen)
+	ErrInvalidName   = errors.New("invalid name")
+	errBadCredential = errors.New("bad credentials")
+)
+
+type User struct {
+	Name    string    `json:"name"`
+	Salt    string    `json:"salt"`
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+type Users struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]User
+}
+
+// OpenUsers загружает пользователей из path (пустой path — только память).
+func OpenUsers(path string) (*Users, error) {
+	u := &Users{path: path, users: make(map[string]User)}
+	var list []User
+	if err := loadJSON(path, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, user := range list {
+		u.users[user.Name] = user
+	}
+	return u, nil
+}
+
+// validName — имена пользователей и ключей: латиница, цифры, . _ -
+func validName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	return strings.Trim(name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-") == ""
+}
+
+func hashPassword(password, salt string) (string, error) {
+	key, err := pbkdf2.Key(sha256.New, password, []byte(salt), passwordIterations, passwordKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// Add создаёт пользователя.
+func (u *Users) Add(name, password string) (User, error) {
+	if !validName(name) {
+		return User{}, ErrInvalidName
+	}
+	if len(password) < MinPasswordLen {
+		return User{}, ErrWeakPassword
+	}
+	salt, err := RandomToken(16)
+	if err != nil {
+		return User{}, err
+	}
+	hash, err := hashPassword(password, salt)
+	if err != nil {
+		return User{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.users[name]; ok {
+		return User{}, ErrUserExists
+	}
+	user := User{Name: name, Salt: salt, Hash: hash, Created: time.Now().UTC()}
+	u.users[name] = user
+	if err := u.saveLocked(); err != nil {
+		delete(u.users, name)
+		return User{}, err
+	}
+	return user, nil
+}
+
+// Verify проверяет пароль. Для неизвестного имени тоже считает хэш, чтобы
+// по времени ответа нельзя было перебирать имена.
+func (u *Users) Verify(name, password string) error {
+	u.mu.RLock()
+	user, ok := u.users[name]
+	u.mu.RUnlock()
+	if !ok {
+		user = User{Salt: "0000000000000000", Hash: strings.Repeat("0", passwordKeyLen*2)}
+	}
+	hash, err := hashPassword(password, user.Salt)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(user.Hash)) != 1 || !ok {
+		return errBadCredential
+	}
+	return nil
+}
+
+// List — пользователи по имени.
+func (u *Users) List() []User {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	out := make([]User, 0, len(u.users))
+	for _, user := range u.users {
+		out = append(out, user)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (u *Users) saveLocked() error {
+	list := make([]User, 0, len(u.users))
+	for _, user := range u.users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return saveJSON(u.path, list)
+}
@@ -0,0 +1,86 @@
+package main
+
+// Monster behaviour. Every monster is in one of three states, kept on the
+// Entity (and in saves):
+//
+//   - idle: stands still;
+//   - wander: a random step every other turn, staying near its home tile;
+//   - chase: heads for the player and attacks when adjacent.
+//
+// A monster notices the player when they are within its sight radius and
+// the line between them is clear (lineOfFire, targeting.go). Idle and
+// wandering monsters that notice the player chase; a chaser that has not
+// seen the player for giveUpTurns of its own turns gives up and wanders
+// around the tile where it saw them last.
+
+// AIState is a monster's behaviour state.
+type AIState string
+
+const (
+	AIIdle   AIState = "" // zero value: freshly spawned monsters wait
+	AIWander AIState = "wander"
+	AIChase  AIState = "chase"
+)
+
+const (
+	monsterSightRadius = 6 // when the entity has no SightRadius of its own
+	giveUpTurns        = 5 // turns without sight before a chase ends
+	wanderLeash        = 3 // how far a wanderer strays from home (Chebyshev)
+)
+
+// nextAIState is the transition function: the state after a turn in which
+// the monster saw the player (sees) or not. lost counts the turns a chaser
+// has gone without seeing the player.
+func nextAIState(state AIState, lost int, sees bool) (AIState, int) {
+	switch {
+	case sees:
+		return AIChase, 0
+	case state != AIChase:
+		return state, 0
+	case lost+1 >= giveUpTurns:
+		return AIWander, 0
+	}
+	return AIChase, lost + 1
+}
+
+// seesPlayer reports whether the monster notices the player this turn.
+func (w *World) seesPlayer(m *Entity) bool {
+	p := w.Player
+	if p == nil || !p.Alive {
+		return false
+	}
+	r := m.SightRadius
+	if r <= 0 {
+		r = monsterSightRadius
+	}
+	dx, dy := p.X-m.X, p.Y-m.Y
+	return dx*dx+dy*dy <= r*r && w.lineOfFire(m.X, m.Y, p.X, p.Y)
+}
+
+// perceive updates the monster's state for this turn; the player's
+// position becomes its home while it can see them.
+func (w *World) perceive(m *Entity) {
+	sees := w.seesPlayer(m)
+	m.AIState, m.LostTurns = nextAIState(m.AIState, m.LostTurns, sees)
+	if sees {
+		m.HomeX, m.HomeY = w.Player.X, w.Player.Y
+	}
+}
+
+// wander takes a random step every other turn, never further than
+// wanderLeash from home and only onto free floor.
+func (w *World) wander(m *Entity) {
+	if m.Stepped {
+		m.Stepped = false
+		return
+	}
+	m.Stepped = true
+	d := stepDeltas[w.Rand.Intn(len(stepDeltas))]
+	nx, ny := m.X+d[0], m.Y+d[1]
+	if !inBounds(w.Tiles, nx, ny) || abs(nx-m.HomeX) > wanderLeash || abs(ny-m.HomeY) > wanderLeash {
+		return
+	}
+	if t := w.Tiles[ny][nx]; t.Type == FloorTile && t.Entity == nil {
+		w.MoveEntity(m, nx, ny)
+	}
+}
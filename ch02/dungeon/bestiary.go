@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"unicode/utf8"
+)
+
+// Bestiary: the monster kinds dungeons are populated with. Each kind has its
+// own glyph, tier-1 stats (deeper tiers add the same growth as
+// monsterStats), AI, drop table (loot.go) and the first depth it shows up
+// on. A themed dungeon spawns only its theme's monster; the classic one
+// samples every kind allowed at the current depth by weight.
+//
+// The built-in table can be replaced at startup with -bestiary FILE, a JSON
+// array of the same entries.
+
+// AI types; the monster turn (monsterTurns) implements them.
+const aiBasic = "basic" // wait until the player is seen, then chase
+
+var aiTypes = map[string]bool{aiBasic: true}
+
+// MonsterType is one bestiary entry.
+type MonsterType struct {
+	Name     string    `json:"name"`
+	Glyph    string    `json:"glyph"`        // a single character
+	Stats    Stats     `json:"stats"`        // at tier 1; HP starts full
+	AIType   string    `json:"ai,omitempty"` // empty: basic
+	Drops    DropTable `json:"drops,omitempty"`
+	MinDepth int       `json:"min_depth,omitempty"`
+	Weight   int       `json:"weight"`           // relative spawn chance among the allowed kinds
+	Corpse   string    `json:"corpse,omitempty"` // name of the corpse it leaves (corpse.go)
+}
+
+type Bestiary []MonsterType
+
+var defaultBestiary = Bestiary{
+	{
+		Name: "Гоблин", Glyph: "g", Stats: Stats{HPMax: 8, Attack: 3, Defense: 0, Speed: 3},
+		AIType: aiBasic, MinDepth: 1, Weight: 10, Corpse: "Труп гоблина",
+		Drops: DropTable{
+			{Weight: 50},
+			{Weight: 35, GoldMin: 3, GoldMax: 8},
+			{Weight: 15, Item: &Item{Name: "Фляга здоровья", Heal: 8}},
+		},
+	},
+	{
+		Name: "Волк", Glyph: "w", Stats: Stats{HPMax: 6, Attack: 4, Defense: 0, Speed: 5},
+		AIType: aiBasic, MinDepth: 1, Weight: 5, Corpse: "Труп волка",
+		Drops: DropTable{
+			{Weight: 60},
+			{Weight: 40, Item: &Item{Name: "Сырое мясо", Heal: 4}},
+		},
+	},
+	{
+		Name: "Скелет", Glyph: "s", Stats: Stats{HPMax: 10, Attack: 3, Defense: 2, Speed: 2},
+		AIType: aiBasic, MinDepth: 2, Weight: 4, Corpse: "Труп скелета",
+		Drops: DropTable{
+			{Weight: 40},
+			{Weight: 40, GoldMin: 5, GoldMax: 12},
+			{Weight: 20, Item: &Item{Name: "Ржавый меч", Equip: WeaponSlot, AttackBonus: 2}},
+			{Weight: 10, Generated: true},
+		},
+	},
+	{
+		Name: "Тролль", Glyph: "T", Stats: Stats{HPMax: 16, Attack: 5, Defense: 2, Speed: 2},
+		AIType: aiBasic, MinDepth: 3, Weight: 2, Corpse: "Труп тролля",
+		Drops: DropTable{
+			{Weight: 50, GoldMin: 15, GoldMax: 30},
+			{Weight: 25, Item: &Item{Name: "Фляга здоровья", Heal: 8}},
+			{Weight: 10, Item: &Item{Name: "Кольчуга", Equip: ArmorSlot, DefenseBonus: 4}},
+			{Weight: 15, Generated: true},
+		},
+	},
+}
+
+// bestiary is the table in use: the built-in one or -bestiary FILE.
+var bestiary = defaultBestiary
+
+// lookup finds a kind by name.
+func (b Bestiary) lookup(name string) (MonsterType, bool) {
+	for _, m := range b {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return MonsterType{}, false
+}
+
+// pick samples a kind allowed at depth by weight.
+func (b Bestiary) pick(r *rand.Rand, depth int) MonsterType {
+	total := 0
+	for _, m := range b {
+		if m.MinDepth <= depth {
+			total += m.Weight
+		}
+	}
+	n := r.Intn(total)
+	for _, m := range b {
+		if m.MinDepth > depth {
+			continue
+		}
+		if n -= m.Weight; n < 0 {
+			return m
+		}
+	}
+	return b[0] // unreachable for a validated bestiary
+}
+
+// spawn makes a monster of this kind with stats for tier.
+func (m MonsterType) spawn(tier int) *Entity {
+	grow := monsterStats(tier)
+	base := monsterStats(1)
+	st := m.Stats
+	st.HPMax += grow.HPMax - base.HPMax
+	st.Attack += grow.Attack - base.Attack
+	st.Defense += grow.Defense - base.Defense
+	st.HP = st.HPMax
+	ai := m.AIType
+	if ai == "" {
+		ai = aiBasic
+	}
+	glyph, _ := utf8.DecodeRuneInString(m.Glyph)
+	return &Entity{Name: m.Name, Glyph: glyph, Stats: st, AIType: ai}
+}
+
+// monsterKind is what the next monster on this level is: the theme's own
+// kind, or a depth-weighted sample (deeper on harder difficulties).
+func (w *World) monsterKind() MonsterType {
+	if m, ok := bestiary.lookup(w.Theme.Monster); ok {
+		return m
+	}
+	return bestiary.pick(w.Rand, w.Depth+difficulty.DepthBias)
+}
+
+// reservedGlyphs are map glyphs a monster may not use.
+var reservedGlyphs = map[rune]bool{}
+
+func init() {
+	for _, set := range glyphSets {
+		for kind, ch := range set {
+			if kind != KindMonster {
+				reservedGlyphs[ch] = true
+			}
+		}
+	}
+}
+
+// validate checks every entry and that the campaign's theme monsters exist.
+func (b Bestiary) validate() error {
+	if len(b) == 0 {
+		return errors.New("bestiary is empty")
+	}
+	seen := map[string]bool{}
+	shallow := false
+	for i, m := range b {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("bestiary entry %d (%q): %w", i, m.Name, err)
+		}
+		if seen[m.Name] {
+			return fmt.Errorf("bestiary entry %d (%q): duplicate name", i, m.Name)
+		}
+		seen[m.Name] = true
+		shallow = shallow || m.MinDepth <= 1
+	}
+	if !shallow {
+		return errors.New("bestiary has no monster for depth 1")
+	}
+	for _, n := range overworldNodes {
+		if n.Theme.Monster != "" && !seen[n.Theme.Monster] {
+			return fmt.Errorf("bestiary has no %q (needed by %s)", n.Theme.Monster, n.Name)
+		}
+	}
+	return nil
+}
+
+func (m MonsterType) validate() error {
+	switch {
+	case m.Name == "":
+		return errors.New("name is empty")
+	case utf8.RuneCountInString(m.Glyph) != 1:
+		return fmt.Errorf("glyph %q must be exactly one character", m.Glyph)
+	case m.Stats.HPMax <= 0:
+		return errors.New("stats.hp_max must be positive")
+	case m.Stats.Attack < 0 || m.Stats.Defense < 0 || m.Stats.Speed < 0:
+		return errors.New("stats must not be negative")
+	case m.Weight <= 0:
+		return errors.New("weight must be positive")
+	case m.MinDepth < 0:
+		return errors.New("min_depth must not be negative")
+	case m.AIType != "" && !aiTypes[m.AIType]:
+		return fmt.Errorf("unknown ai %q", m.AIType)
+	}
+	if ch, _ := utf8.DecodeRuneInString(m.Glyph); reservedGlyphs[ch] {
+		return fmt.Errorf("glyph %q is already used on the map", m.Glyph)
+	}
+	for j, d := range m.Drops {
+		if d.Weight < 0 || d.GoldMin < 0 || (d.GoldMax > 0 && d.GoldMin > d.GoldMax) {
+			return fmt.Errorf("drop %d: bad weight or gold range", j)
+		}
+	}
+	return nil
+}
+
+// LoadBestiary reads a bestiary file. Entries are decoded one by one so
+// errors name the bad entry; unknown fields are errors too, so a typo does
+// not silently fall back to a zero value.
+func LoadBestiary(path string) (Bestiary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	b := make(Bestiary, len(raw))
+	for i, entry := range raw {
+		dec := json.NewDecoder(bytes.NewReader(entry))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&b[i]); err != nil {
+			return nil, fmt.Errorf("%s: bestiary entry %d (%q): %w", path, i, b[i].Name, err)
+		}
+	}
+	if err := b.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Campaign: a small overworld of hand-placed nodes. The town is always open;
+// every other node is a themed dungeon that opens once a node linked to it
+// is cleared (or it is linked from the town). Clearing the final node wins
+// the campaign. The player entity, gold and cleared nodes persist between
+// dungeons and are saved with the run.
+
+// Theme flavours a dungeon: who lives there, how it is laid out and how much
+// tougher than its depth the monsters are. The zero Theme is the classic
+// dungeon, with monsters sampled from the whole bestiary.
+type Theme struct {
+	Name       string
+	Monster    string       // bestiary name; empty: any kind allowed at the depth
+	Gen        MapGenerator // nil: the generator passed to buildDungeon
+	Difficulty int          // added to the depth for monster stats
+}
+
+// Node is one place on the overworld map.
+type Node struct {
+	ID     string
+	Name   string
+	Town   bool
+	Theme  Theme
+	Depth  int      // levels in the node's dungeon
+	Links  []string // nodes reachable from here
+	Final  bool     // clearing it wins the campaign
+	MapX   int      // position on the overworld screen
+	MapY   int
+	Marker rune
+}
+
+var overworldNodes = []*Node{
+	{ID: "town", Name: "Город", Town: true, Links: []string{"forest_cave"}, MapX: 2, MapY: 4, Marker: 'T'},
+	{
+		ID: "forest_cave", Name: "Лесная пещера", Depth: 2,
+		Theme: Theme{Name: "пещера", Monster: "Волк", Gen: ScatterGenerator{Density: 0.12}},
+		Links: []string{"crypt"}, MapX: 14, MapY: 1, Marker: '1',
+	},
+	{
+		ID: "crypt", Name: "Склеп", Depth: 3,
+		Theme: Theme{Name: "склеп", Monster: "Скелет", Gen: RoomsGenerator{MaxRooms: 9, MinSize: 3, MaxSize: 5}, Difficulty: 1},
+		Links: []string{"mountain_lair"}, MapX: 28, MapY: 5, Marker: '2',
+	},
+	{
+		ID: "mountain_lair", Name: "Логово в горах", Depth: 3,
+		Theme: Theme{Name: "логово", Monster: "Тролль", Gen: RoomsGenerator{MaxRooms: 5, MinSize: 4, MaxSize: 8}, Difficulty: 2},
+		Final: true, MapX: 42, MapY: 2, Marker: '3',
+	},
+}
+
+func nodeByID(id string) (*Node, bool) {
+	for _, n := range overworldNodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	errUnknownNode = errors.New("нет такого места")
+	errNodeLocked  = errors.New("путь туда ещё закрыт")
+)
+
+// Campaign is the world-level progress.
+type Campaign struct {
+	Player  *Entity         `json:"player"`
+	Pet     *Entity         `json:"pet,omitempty"` // the dog, once recruited; dead ones stay (pet.go)
+	Cleared map[string]bool `json:"cleared"`
+	Node    string          `json:"node"` // where the player is (or whose dungeon they are in)
+	Gold    int             `json:"gold"`
+	Seed    int64           `json:"seed"`             // dungeon seeds derive from it
+	Runs    int             `json:"runs"`             // dungeons entered so far
+	Banked  int             `json:"banked,omitempty"` // gold put in the profile's vault this campaign
+}
+
+// NewCampaign starts in town with a fresh player.
+func NewCampaign(seed int64) *Campaign {
+	return &Campaign{Player: newPlayer(), Cleared: map[string]bool{}, Node: "town", Seed: seed}
+}
+
+// Available reports whether the node can be travelled to: the town always,
+// other nodes when linked from the town or from a cleared node.
+func (c *Campaign) Available(id string) bool {
+	n, ok := nodeByID(id)
+	if !ok {
+		return false
+	}
+	if n.Town {
+		return true
+	}
+	for _, from := range overworldNodes {
+		if !from.Town && !c.Cleared[from.ID] {
+			continue
+		}
+		for _, to := range from.Links {
+			if to == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enterDungeon builds the node's dungeon around the campaign's player and
+// pet.
+func (c *Campaign) enterDungeon(n *Node) *World {
+	c.Runs++
+	seed := c.Seed + int64(c.Runs)*1_000_003
+	c.Node = n.ID
+	w := buildDungeon(seed, defaultGenerator(), n.Theme, n.Depth, c.Player)
+	if c.Pet != nil {
+		w.adoptPet(c.Pet)
+	}
+	return w
+}
+
+// OverworldMap draws the node graph: links as lines, nodes by their marker,
+// then a legend with each node's state.
+func (c *Campaign) OverworldMap() string {
+	const w, h = 48, 7
+	canvas := make([][]rune, h)
+	for y := range canvas {
+		canvas[y] = []rune(strings.Repeat(" ", w))
+	}
+	for _, n := range overworldNodes {
+		for _, id := range n.Links {
+			to, _ := nodeByID(id)
+			x, y := n.MapX, n.MapY
+			for ; x != to.MapX; x += sign(to.MapX - x) {
+				canvas[y][x] = '-'
+			}
+			for ; y != to.MapY; y += sign(to.MapY - y) {
+				canvas[y][x] = '|'
+			}
+			canvas[n.MapY][to.MapX] = '+'
+		}
+	}
+	for _, n := range overworldNodes {
+		canvas[n.MapY][n.MapX] = n.Marker
+	}
+
+	var b strings.Builder
+	for _, row := range canvas {
+		b.WriteString(strings.TrimRight(string(row), " "))
+		b.WriteByte('\n')
+	}
+	for _, n := range overworldNodes {
+		state := "закрыто"
+		switch {
+		case n.Town:
+			state = "город"
+		case c.Cleared[n.ID]:
+			state = "пройдено"
+		case c.Available(n.ID):
+			state = "доступно"
+		}
+		here := " "
+		if n.ID == c.Node {
+			here = "@"
+		}
+		fmt.Fprintf(&b, "%s %c %-16s [%s]", here, n.Marker, n.Name, state)
+		if !n.Town {
+			fmt.Fprintf(&b, " уровней: %d, сложность: %d", n.Depth, n.Theme.Difficulty+1)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func sign(a int) int {
+	switch {
+	case a < 0:
+		return -1
+	case a > 0:
+		return 1
+	}
+	return 0
+}
+
+// ---------- Town ----------
+
+// ShopItem is something for sale in town.
+type ShopItem struct {
+	Item  Item
+	Price int
+}
+
+var shopStock = []ShopItem{
+	{Item{Name: "Фляга здоровья", Heal: 8}, 10},
+	{swordItem, 30},
+	{leatherArmorItem, 25},
+	{Item{Name: "Кольчуга", Equip: ArmorSlot, DefenseBonus: 4}, 60},
+	{Item{Name: "Боевой топор", Equip: WeaponSlot, AttackBonus: 5}, 70},
+}
+
+// restPrice is what a night at the inn (full heal) costs.
+const restPrice = 5
+
+var errNotEnoughGold = errors.New("не хватает золота")
+
+// Buy moves shop item idx into the player's inventory.
+func (c *Campaign) Buy(idx int) (Item, error) {
+	if idx < 0 || idx >= len(shopStock) {
+		return Item{}, errors.New("нет такого товара")
+	}
+	s := shopStock[idx]
+	if c.Gold < s.Price {
+		return Item{}, errNotEnoughGold
+	}
+	c.Gold -= s.Price
+	c.Player.Inv = append(c.Player.Inv, s.Item)
+	return s.Item, nil
+}
+
+// Rest heals the player (and a living pet) to full at the inn.
+func (c *Campaign) Rest() error {
+	if c.Gold < restPrice {
+		return errNotEnoughGold
+	}
+	c.Gold -= restPrice
+	c.Player.Stats.HP = c.Player.Stats.HPMax
+	if c.Pet != nil && c.Pet.Alive {
+		c.Pet.Stats.HP = c.Pet.Stats.HPMax
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import "math/rand"
+
+// Consumables besides the heal flask. An item's Effect says what using it
+// does (PlayerUseItem dispatches on it):
+//
+//   - strength potion: +Power attack for Turns player turns (a timed buff);
+//   - teleport scroll: the player jumps to a random floor tile they could
+//     walk to from where they stand;
+//   - bomb: thrown up to Range tiles (targeting.go); Power damage to every
+//     living entity within bombRadius of where it lands, the player
+//     included; walls do not shield;
+//   - food: Power less hunger (hunger.go).
+//
+// spawnItems scatters them among heal flasks by weight.
+
+// ItemEffect is what using a consumable does beyond Heal.
+type ItemEffect string
+
+const (
+	NoEffect       ItemEffect = ""
+	EffectStrength ItemEffect = "strength"
+	EffectTeleport ItemEffect = "teleport"
+	EffectBomb     ItemEffect = "bomb"
+	EffectFood     ItemEffect = "food"
+)
+
+// bombRadius is the blast radius: tiles with dx²+dy² <= bombRadius² are hit.
+const bombRadius = 2
+
+var (
+	healFlaskItem      = Item{Name: "Фляга здоровья", Heal: 8}
+	strengthPotionItem = Item{Name: "Зелье силы", Effect: EffectStrength, Power: 3, Turns: 10}
+	teleportScrollItem = Item{Name: "Свиток телепортации", Effect: EffectTeleport}
+	bombItem           = Item{Name: "Бомба", Effect: EffectBomb, Power: 10, Range: 5}
+	rationItem         = Item{Name: "Паёк", Effect: EffectFood, Power: 400}
+)
+
+// weightedItem is one entry of the floor item mix.
+type weightedItem struct {
+	Item   Item
+	Weight int
+}
+
+type itemMix []weightedItem
+
+// consumables is what spawnItems places on the floor.
+var consumables = itemMix{
+	{healFlaskItem, 10},
+	{strengthPotionItem, 3},
+	{teleportScrollItem, 3},
+	{bombItem, 2},
+	{rationItem, 3},
+}
+
+// pick samples an item by weight.
+func (m itemMix) pick(r *rand.Rand) Item {
+	total := 0
+	for _, e := range m {
+		total += e.Weight
+	}
+	n := r.Intn(total)
+	for _, e := range m {
+		if n -= e.Weight; n < 0 {
+			return e.Item
+		}
+	}
+	return m[0].Item
+}
+
+// targeted reports whether using the item needs a target tile.
+func (it Item) targeted() bool {
+	return it.Range > 0
+}
+
+// PlayerThrow throws the targeted item at idx onto (x, y). A target that
+// fails checkTarget is refused with a message; the item stays and false
+// tells the caller no turn was spent.
+func (w *World) PlayerThrow(idx, x, y int) bool {
+	if idx < 0 || idx >= len(w.Player.Inv) || !w.Player.Inv[idx].targeted() {
+		msg("Этот предмет не бросают")
+		return false
+	}
+	item := w.Player.Inv[idx]
+	if err := w.checkTarget(x, y, item.Range); err != nil {
+		msg("%v", err)
+		return false
+	}
+	w.Player.Inv = append(w.Player.Inv[:idx], w.Player.Inv[idx+1:]...)
+	if item.Effect == EffectBomb {
+		w.explode(w.Player, x, y, bombRadius, item.Power)
+	}
+	return true
+}
+
+// Buff is a timed bonus. Turns counts the player turns it still lasts after
+// the current one.
+type Buff struct {
+	Name         string `json:"name"`
+	AttackBonus  int    `json:"attack_bonus,omitempty"`
+	DefenseBonus int    `json:"defense_bonus,omitempty"`
+	Turns        int    `json:"turns"`
+}
+
+// addBuff applies a buff; drinking the same potion again restarts it rather
+// than stacking.
+func (e *Entity) addBuff(b Buff) {
+	for i := range e.Buffs {
+		if e.Buffs[i].Name == b.Name {
+			e.Buffs[i] = b
+			return
+		}
+	}
+	e.Buffs = append(e.Buffs, b)
+}
+
+// buffAttack is the attack bonus of all active buffs.
+func (e *Entity) buffAttack() int {
+	total := 0
+	for _, b := range e.Buffs {
+		total += b.AttackBonus
+	}
+	return total
+}
+
+// buffDefense is the defense bonus of all active buffs (curses are negative).
+func (e *Entity) buffDefense() int {
+	total := 0
+	for _, b := range e.Buffs {
+		total += b.DefenseBonus
+	}
+	return total
+}
+
+// tickBuffs counts down the buffs at the end of the entity's turn and drops
+// the ones that ran out.
+func (e *Entity) tickBuffs() {
+	kept := e.Buffs[:0]
+	for _, b := range e.Buffs {
+		if b.Turns--; b.Turns < 0 {
+			if e.IsPlayer {
+				msg("Действие «%s» закончилось", b.Name)
+			}
+			continue
+		}
+		kept = append(kept, b)
+	}
+	e.Buffs = kept
+	if len(e.Buffs) == 0 {
+		e.Buffs = nil
+	}
+}
+
+// teleportPlayer moves the player to a random free floor tile reachable from
+// their position. On a level with nowhere to go the scroll fizzles.
+func (w *World) teleportPlayer() {
+	p := w.Player
+	flow := w.distanceMapFrom(p.X, p.Y)
+	type pos struct{ x, y int }
+	var free []pos
+	for y := 0; y < w.Height; y++ {
+		for x := 0; x < w.Width; x++ {
+			t := w.Tiles[y][x]
+			if flow.At(x, y) > 0 && t.Type == FloorTile && t.Entity == nil {
+				free = append(free, pos{x, y})
+			}
+		}
+	}
+	if len(free) == 0 {
+		msg("Свиток вспыхивает, но ничего не происходит")
+		return
+	}
+	to := free[w.Rand.Intn(len(free))]
+	w.Tiles[p.Y][p.X].Entity = nil
+	p.X, p.Y = to.x, to.y
+	w.Tiles[p.Y][p.X].Entity = p
+	msg("Вас окутывает свет — и вы уже в другом месте")
+}
+
+// blastArea lists the in-bounds tiles within radius of (cx, cy).
+func (w *World) blastArea(cx, cy, radius int) [][2]int {
+	var area [][2]int
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			x, y := cx+dx, cy+dy
+			if dx*dx+dy*dy > radius*radius || x < 0 || x >= w.Width || y < 0 || y >= w.Height {
+				continue
+			}
+			area = append(area, [2]int{x, y})
+		}
+	}
+	return area
+}
+
+// explode deals damage to everyone within radius of (cx, cy); kills are
+// credited to source.
+func (w *World) explode(source *Entity, cx, cy, radius, damage int) {
+	msg("Взрыв!")
+	for _, at := range w.blastArea(cx, cy, radius) {
+		e := w.Tiles[at[1]][at[0]].Entity
+		if e == nil || !e.Alive {
+			continue
+		}
+		msg("Взрыв задевает %s на %d урона", e.Name, damage)
+		w.hurt(source, e, damage)
+	}
+}
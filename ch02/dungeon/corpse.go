@@ -0,0 +1,87 @@
+package main
+
+import "fmt"
+
+// Corpses. A dead monster leaves a corpse where it fell (its loot lands
+// next to it). A corpse is food (hunger.go): picked up with 'p' and eaten
+// with 'u', though one in sickChance makes the player sick, a short attack
+// debuff. Every corpse on the current level and in the pack ages by one
+// each player turn and rots away after corpseDecay turns.
+//
+// With -nohunger there is nothing to eat, so monsters leave no corpses.
+
+const (
+	corpseDecay    = 50
+	corpseNutrient = 150
+	// Sickness: one corpse in sickChance, -sickAttack for sickTurns.
+	sickChance = 5
+	sickAttack = 2
+	sickTurns  = 10
+)
+
+// sicknessName is the debuff's name (one at a time, see addBuff).
+const sicknessName = "Тошнота"
+
+// corpseOf is the corpse item the dead monster leaves.
+func corpseOf(dead *Entity) Item {
+	kind, _ := bestiary.lookup(dead.Name)
+	name := kind.Corpse
+	if name == "" {
+		name = fmt.Sprintf("Труп (%s)", dead.Name)
+	}
+	return Item{Name: name, Effect: EffectFood, Power: corpseNutrient, Corpse: true}
+}
+
+// dropCorpse leaves the dead monster's corpse on its tile, or the nearest
+// free one.
+func (w *World) dropCorpse(dead *Entity) {
+	if !hungerOn {
+		return
+	}
+	if x, y, ok := w.freeItemTile(dead.X, dead.Y); ok {
+		w.PlaceItem(corpseOf(dead), x, y)
+	}
+}
+
+// tickCorpses ages the corpses on the level and in the player's pack and
+// removes the ones that rotted away. It runs at the end of every player
+// turn; levels the player is not on stand still.
+func (w *World) tickCorpses() {
+	for y := 0; y < w.Height; y++ {
+		for x := 0; x < w.Width; x++ {
+			t := w.Tiles[y][x]
+			if t.Item != nil && t.Item.rots() {
+				t.Item = nil
+			}
+		}
+	}
+	kept := w.Player.Inv[:0]
+	for i := range w.Player.Inv {
+		it := &w.Player.Inv[i]
+		if it.rots() {
+			msg("%s сгнил без остатка", it.Name)
+			continue
+		}
+		kept = append(kept, *it)
+	}
+	w.Player.Inv = kept
+}
+
+// rots ages a corpse by one turn and reports whether it is gone.
+func (it *Item) rots() bool {
+	if !it.Corpse {
+		return false
+	}
+	it.Age++
+	return it.Age >= corpseDecay
+}
+
+// eatCorpse is eat plus the chance of getting sick.
+func (w *World) eatCorpse(corpse Item) {
+	w.eat(corpse)
+	if w.Rand.Intn(sickChance) != 0 {
+		return
+	}
+	w.Player.addBuff(Buff{Name: sicknessName, AttackBonus: -sickAttack, Turns: sickTurns})
+	msg("Вас мутит: атака -%d на %d ходов", sickAttack, sickTurns)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Daily challenge: everyone gets the same level for a UTC date, one scored
+// attempt per day, and a short result line to share.
+
+const dailyDateLayout = "2006-01-02"
+
+var errAlreadyPlayed = errors.New("daily challenge already played today")
+
+// Score is tracked for every run; only daily runs are recorded.
+type Score struct {
+	Depth int `json:"depth"`
+	Kills int `json:"kills"`
+	Turns int `json:"turns"`
+	Gold  int `json:"gold"`
+}
+
+// Points folds the run into one number: deeper, more kills and more gold are
+// better, slower is worse.
+func (s Score) Points() int {
+	p := s.Depth*100 + s.Kills*25 + s.Gold - s.Turns/4
+	if p < 0 {
+		return 0
+	}
+	return p
+}
+
+// DailyResult is one line of daily_scores.json.
+type DailyResult struct {
+	Score
+	Won    bool   `json:"won"`
+	Points int    `json:"points"`
+	Share  string `json:"share"`
+}
+
+// dailyDate is the challenge date for t (always UTC).
+func dailyDate(t time.Time) string {
+	return t.UTC().Format(dailyDateLayout)
+}
+
+// dailySeed derives the level seed from the date, so every machine gets the
+// same dungeon on the same day.
+func dailySeed(date string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("dungeon-daily:" + date))
+	return int64(h.Sum64())
+}
+
+// newGame builds the complete dungeon (every level with walls, stairs,
+// monsters, items and gold, plus the player) from a single seed. All
+// randomness goes through the world's Rand, in a fixed order, so the same
+// seed always yields the same dungeon.
+func newGame(seed int64, gen MapGenerator) *World {
+	return buildDungeon(seed, gen, Theme{}, dungeonLevels, newPlayer())
+}
+
+// buildDungeon is newGame for a themed dungeon of the given depth, entered
+// by an existing player (the campaign's). The theme's generator, if any,
+// replaces gen.
+func buildDungeon(seed int64, gen MapGenerator, theme Theme, depth int, player *Entity) *World {
+	if theme.Gen != nil {
+		gen = theme.Gen
+	}
+	world := setupWorld(newSeededSource(seed), gen)
+	world.Theme = theme
+	placePlayer(world, player)
+	world.populate(depth)
+	return world
+}
+
+// shareString is the compact result, e.g. "Dungeon 2024-05-01 ☠ D1 K3 G12 T57 = 143".
+func shareString(date string, res DailyResult) string {
+	mark := "☠"
+	if res.Won {
+		mark = "🏆"
+	}
+	return fmt.Sprintf("Dungeon %s %s D%d K%d G%d T%d = %d",
+		date, mark, res.Depth, res.Kills, res.Gold, res.Turns, res.Points)
+}
+
+// dailyScoresPath sits next to the render profile.
+func dailyScoresPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "daily_scores.json"
+	}
+	return filepath.Join(dir, "dungeon", "daily_scores.json")
+}
+
+// LoadDailyScores reads the results keyed by date; a missing file is empty.
+func LoadDailyScores(path string) (map[string]DailyResult, error) {
+	scores := map[string]DailyResult{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return scores, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &scores); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return scores, nil
+}
+
+// RecordDailyScore stores the result for date, refusing a second one.
+func RecordDailyScore(path, date string, res DailyResult) error {
+	scores, err := LoadDailyScores(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := scores[date]; ok {
+		return errAlreadyPlayed
+	}
+	scores[date] = res
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(scores, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// finishDaily prints and (if scored) records the result of a daily run.
+func finishDaily(date string, world *World, won, scored bool) {
+	res := DailyResult{Score: world.Score, Won: won}
+	res.Points = res.Score.Points()
+	res.Share = shareString(date, res)
+	fmt.Println(res.Share)
+	if !scored {
+		fmt.Println("Тренировочный забег — результат не засчитан.")
+		return
+	}
+	if err := RecordDailyScore(dailyScoresPath(), date, res); err != nil {
+		fmt.Printf("Не удалось сохранить результат: %v\n", err)
+	}
+}
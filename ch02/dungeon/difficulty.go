@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Difficulty. -difficulty easy|normal|hard picks how many monsters and
+// items every level gets, how much stronger than their bestiary entry the
+// monsters are, how often the floor items are heal flasks, how deep the
+// bestiary sampling reaches and how much XP a level-up costs. -monsters and
+// -items override the per-level counts of the chosen preset.
+//
+// Like the bestiary, the difficulty is chosen once at startup and applies to
+// every dungeon built after that; saves keep the levels already built.
+
+// Difficulty is one preset.
+type Difficulty struct {
+	Name      string
+	Monsters  int     // per level
+	Items     int     // consumables per level
+	StatMult  float64 // monster HPMax and Attack multiplier
+	HealMult  float64 // heal flask weight in the floor item mix
+	DepthBias int     // bestiary sampled as if this many levels deeper
+	XPMult    float64 // XP needed per level-up
+}
+
+var difficulties = map[string]Difficulty{
+	"easy":   {Name: "easy", Monsters: 4, Items: 7, StatMult: 0.75, HealMult: 1.6, XPMult: 0.8},
+	"normal": {Name: "normal", Monsters: 6, Items: 5, StatMult: 1, HealMult: 1, XPMult: 1},
+	"hard":   {Name: "hard", Monsters: 9, Items: 4, StatMult: 1.3, HealMult: 0.5, DepthBias: 1, XPMult: 1.25},
+}
+
+// difficulty is the preset in use: normal, or -difficulty.
+var difficulty = difficulties["normal"]
+
+// difficultyByName looks up a preset.
+func difficultyByName(name string) (Difficulty, error) {
+	d, ok := difficulties[name]
+	if !ok {
+		names := make([]string, 0, len(difficulties))
+		for n := range difficulties {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Difficulty{}, fmt.Errorf("unknown difficulty %q (want %s)", name, strings.Join(names, ", "))
+	}
+	return d, nil
+}
+
+// difficultyFromFlags builds the effective difficulty from -difficulty,
+// -monsters and -items; set holds the names of the flags given on the
+// command line (the others keep the preset's counts).
+func difficultyFromFlags(name string, monsters, items int, set map[string]bool) (Difficulty, error) {
+	d, err := difficultyByName(name)
+	if err != nil {
+		return Difficulty{}, err
+	}
+	if set["monsters"] {
+		if monsters < 0 {
+			return Difficulty{}, fmt.Errorf("-monsters must be 0 or more, got %d", monsters)
+		}
+		d.Monsters = monsters
+	}
+	if set["items"] {
+		if items < 0 {
+			return Difficulty{}, fmt.Errorf("-items must be 0 or more, got %d", items)
+		}
+		d.Items = items
+	}
+	return d, nil
+}
+
+// String is the effective configuration printed at startup.
+func (d Difficulty) String() string {
+	return fmt.Sprintf("сложность %s: монстров %d, предметов %d на уровень; сила монстров x%.2f, фляги x%.2f, опыт x%.2f",
+		d.Name, d.Monsters, d.Items, d.StatMult, d.HealMult, d.XPMult)
+}
+
+// scale applies StatMult to a freshly spawned monster (at least 1 HP and 1
+// attack).
+func (d Difficulty) scale(m *Entity) {
+	if d.StatMult == 1 {
+		return
+	}
+	m.Stats.HPMax = max(int(math.Round(float64(m.Stats.HPMax)*d.StatMult)), 1)
+	m.Stats.Attack = max(int(math.Round(float64(m.Stats.Attack)*d.StatMult)), 1)
+	m.Stats.HP = m.Stats.HPMax
+}
+
+// itemMix is consumables with the heal flask weight scaled by HealMult, and
+// without food when hunger is off.
+func (d Difficulty) itemMix() itemMix {
+	mix := make(itemMix, 0, len(consumables))
+	for _, e := range consumables {
+		if e.Item.Effect == EffectFood && !hungerOn {
+			continue
+		}
+		if e.Item.Name == healFlaskItem.Name {
+			e.Weight = max(int(math.Round(float64(e.Weight)*d.HealMult)), 1)
+		}
+		mix = append(mix, e)
+	}
+	return mix
+}
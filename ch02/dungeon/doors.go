@@ -0,0 +1,192 @@
+package main
+
+// Doors, keys and locked treasure rooms. Walking into a closed door opens it
+// (the move is spent); a locked door opens with a key from the inventory,
+// which is used up. Monsters can't open doors, so their pathing treats
+// closed and locked doors as walls, and closed doors block sight.
+//
+// Each level gets a few closed doors where corridors meet rooms, and now and
+// then a small treasure room carved out of solid rock behind a locked door,
+// with its key lying somewhere on the open floor.
+
+const (
+	// doorsPerLevel is how many doorways are closed off on each level.
+	doorsPerLevel = 2
+	// treasureRoomOdds: one level in treasureRoomOdds gets a treasure room.
+	treasureRoomOdds = 3
+	// treasureRoomSize is the treasure room's side, walls excluded.
+	treasureRoomSize = 3
+)
+
+// closedDoor reports whether a tile is a door that is not open.
+func (t TileType) closedDoor() bool {
+	return t == DoorClosedTile || t == DoorLockedTile
+}
+
+// blocksMonsters is what monster pathing can't cross.
+func (t TileType) blocksMonsters() bool {
+	return t == WallTile || t.closedDoor()
+}
+
+func keyItem() Item {
+	return Item{Name: "Ключ", Key: true}
+}
+
+// treasureLoot is rolled for the treasure room, on top of a gold pile.
+var treasureLoot = DropTable{
+	{Weight: 40, Item: &Item{Name: "Стальной меч", Equip: WeaponSlot, AttackBonus: 4}},
+	{Weight: 30, Item: &Item{Name: "Латы", Equip: ArmorSlot, DefenseBonus: 5}},
+	{Weight: 30, Item: &Item{Name: "Большая фляга", Heal: 20}},
+}
+
+// bumpDoor handles e walking into a closed or locked door. Only the player
+// opens doors; true means the door opened.
+func (w *World) bumpDoor(e *Entity, t *Tile) bool {
+	if !e.IsPlayer {
+		return false
+	}
+	if t.Type == DoorLockedTile {
+		idx := keyIndex(e)
+		if idx < 0 {
+			msg("Дверь заперта. Нужен ключ")
+			return false
+		}
+		e.Inv = append(e.Inv[:idx], e.Inv[idx+1:]...)
+		msg("Вы отпираете дверь ключом")
+	} else {
+		msg("Вы открываете дверь")
+	}
+	t.Type = DoorOpenTile
+	return true
+}
+
+// keyIndex is the inventory index of a key, or -1.
+func keyIndex(e *Entity) int {
+	for i, it := range e.Inv {
+		if it.Key {
+			return i
+		}
+	}
+	return -1
+}
+
+// placeDoors closes up to n doorways: corridor tiles with walls on two
+// opposite sides that open into a room on one of the others.
+func (w *World) placeDoors(n int) {
+	var spots [][2]int
+	for y := 1; y < w.Height-1; y++ {
+		for x := 1; x < w.Width-1; x++ {
+			if w.doorway(x, y) {
+				spots = append(spots, [2]int{x, y})
+			}
+		}
+	}
+	for i := 0; i < n && len(spots) > 0; i++ {
+		j := w.Rand.Intn(len(spots))
+		x, y := spots[j][0], spots[j][1]
+		spots = append(spots[:j], spots[j+1:]...)
+		w.Tiles[y][x].Type = DoorClosedTile
+	}
+}
+
+func (w *World) doorway(x, y int) bool {
+	t := w.Tiles[y][x]
+	if t.Type != FloorTile || t.Item != nil || t.Entity != nil {
+		return false
+	}
+	wall := func(x, y int) bool { return w.Tiles[y][x].Type == WallTile }
+	var a, b [2]int // the two open sides
+	switch {
+	case wall(x-1, y) && wall(x+1, y) && !wall(x, y-1) && !wall(x, y+1):
+		a, b = [2]int{x, y - 1}, [2]int{x, y + 1}
+	case wall(x, y-1) && wall(x, y+1) && !wall(x-1, y) && !wall(x+1, y):
+		a, b = [2]int{x - 1, y}, [2]int{x + 1, y}
+	default:
+		return false
+	}
+	return w.roomy(a[0], a[1]) || w.roomy(b[0], b[1])
+}
+
+// roomy: a floor tile with at least three open neighbours is inside a room.
+func (w *World) roomy(x, y int) bool {
+	if w.Tiles[y][x].Type != FloorTile {
+		return false
+	}
+	open := 0
+	for _, d := range stepDeltas {
+		if w.Tiles[y+d[1]][x+d[0]].Type != WallTile {
+			open++
+		}
+	}
+	return open >= 3
+}
+
+// addTreasureRoom carves a treasureRoomSize square room out of solid rock
+// next to the floor, behind a locked door, puts loot inside and its key on
+// the floor elsewhere. False if no spot was found.
+func (w *World) addTreasureRoom() bool {
+	const s = treasureRoomSize
+	if w.Width < s+6 || w.Height < s+6 {
+		return false
+	}
+	for attempt := 0; attempt < 50; attempt++ {
+		// Interior (rx..rx+s-1, ry..ry+s-1); its wall ring stays off the border.
+		rx := 2 + w.Rand.Intn(w.Width-s-3)
+		ry := 2 + w.Rand.Intn(w.Height-s-3)
+		if !w.solidRock(rx-1, ry-1, s+2, s+2) {
+			continue
+		}
+		// Door in the middle of one side, opening onto plain floor.
+		mid := s / 2
+		sides := [4][4]int{ // door x, y, outside x, y
+			{rx + mid, ry - 1, rx + mid, ry - 2},
+			{rx + mid, ry + s, rx + mid, ry + s + 1},
+			{rx - 1, ry + mid, rx - 2, ry + mid},
+			{rx + s, ry + mid, rx + s + 1, ry + mid},
+		}
+		first := w.Rand.Intn(len(sides))
+		for i := range sides {
+			d := sides[(first+i)%len(sides)]
+			if out := w.Tiles[d[3]][d[2]]; out.Type != FloorTile {
+				continue
+			}
+			kx, ky, ok := w.keySpot()
+			if !ok {
+				return false
+			}
+			carveRect(w.Tiles, room{x: rx, y: ry, w: s, h: s})
+			w.Tiles[d[1]][d[0]].Type = DoorLockedTile
+			if it, ok := treasureLoot.roll(w); ok {
+				w.PlaceItem(it, rx+mid, ry+mid)
+			}
+			w.PlaceItem(Item{Name: "Золото", Gold: 25 + w.Rand.Intn(26)}, rx, ry)
+			w.PlaceItem(keyItem(), kx, ky)
+			return true
+		}
+	}
+	return false
+}
+
+// solidRock reports whether the w×h rectangle at (x, y) is all wall.
+func (w *World) solidRock(x, y, width, height int) bool {
+	for ty := y; ty < y+height; ty++ {
+		for tx := x; tx < x+width; tx++ {
+			if w.Tiles[ty][tx].Type != WallTile {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// keySpot picks an empty floor tile for the key. It runs before the room is
+// carved, so the key can't land inside it.
+func (w *World) keySpot() (int, int, bool) {
+	for try := 0; try < 100; try++ {
+		x, y := w.randomFloor()
+		if t := w.Tiles[y][x]; t.Type == FloorTile && t.Item == nil && t.Entity == nil {
+			return x, y, true
+		}
+	}
+	return 0, 0, false
+}
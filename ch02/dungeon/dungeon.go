@@ -2,24 +2,102 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"math"
 	"math/rand"
 	"os"
-	"strconv"
+	"runtime"
 	"strings"
 	"time"
 )
 
 // Simple ASCII Dungeon Crawler.
 //
-// Run: go run dungeon.go
+// Run: go run . [-glyphs ascii|unicode] [-palette none|standard|colorblind] [-color] [-hp] [-daily] [-single] [-sight N] [-gen rooms|scatter] [-load FILE] [-seed N] [-bestiary FILE] [-plain] [-morgue FILE] [-scores] [-difficulty easy|normal|hard] [-monsters N] [-items N] [-nohunger] [-width N] [-height N] [-simulate N] [-workers N]
+//
+// By default this is a campaign: an overworld map of a town and themed
+// dungeons (campaign.go) that open one after another; the town has a shop,
+// an inn and lets you save. -single plays one dungeon instead.
+//
+// A dungeon has several levels joined by stairs ('>' down, '<' up); each
+// level is tougher than the last. Taking the '>' on the last level clears it.
+//
+// -color paints the map (render.go): red monsters, yellow items, cyan
+// stairs, white walls, a green player. Colors are dropped when stdout isn't
+// a terminal, so redirected output stays plain.
+//
+// Only what the player can see (sight radius, walls block view) is drawn in
+// full; explored tiles stay dimmed, and monsters chase only after spotting
+// the player. Which monsters live where comes from the bestiary
+// (bestiary.go); -bestiary FILE replaces it with a JSON table. Turns go by
+// speed (schedule.go): a fast player gets several moves per goblin move.
+// Besides heal flasks the floor holds strength potions, teleport scrolls
+// and bombs (consumables.go), all used with 'u'; a bomb is thrown at a tile
+// picked with a cursor (targeting.go). 't' throws any item in a straight
+// line instead (throw.go).
+// Slain monsters leave corpses: food that may make you sick and rots away
+// (corpse.go).
+// Some levels have a shrine: pray once for a blessing or a curse (shrine.go).
+// A stray dog waits on the first level; walk into it and it fights at your
+// side, takes orders and follows you from dungeon to dungeon (pet.go).
+// Some floor items and monster drops are generated: swords, bows, armor
+// and potions with depth-scaled stats, a rarity (common, rare, epic; rare
+// and epic ones have their own colors) and affixes (itemgen.go).
+//
+// -difficulty easy|normal|hard sets the monster count and strength, how
+// often heal flasks turn up and the XP per level (difficulty.go); -monsters
+// and -items override the per-level counts. The effective setup is printed
+// at startup.
+//
+// The player grows hungry over time (hunger.go): hungry, they stop
+// regenerating; starving, they lose HP. Rations on the floor are eaten with
+// 'u'. -nohunger turns the food clock off.
+//
+// -width and -height resize the levels (at least 20x10); a map bigger than
+// 80x24 scrolls with the player (viewport.go).
+//
+// -simulate N plays N seeded single dungeons with a bot instead of a player,
+// headless and in parallel (-workers), and prints survival and death
+// statistics for balancing (simulate.go).
+//
+// -daily plays today's (UTC) shared level; the first attempt of the day is
+// scored and saved to daily_scores.json, later ones are practice (always on
+// normal).
+//
+// Every world comes from one seed, printed at startup and again when the
+// run ends; -seed N builds the same world again (for sharing runs and for
+// bug reports).
+//
+// A death is recorded in ~/.dungeon_scores.json (-morgue FILE elsewhere)
+// with the seed, turns, depth, kills and killer; -scores prints the top 10
+// (morgue.go).
+//
+// A profile (profile.go) keeps lifetime stats between runs; milestones
+// unlock classes, starting items and themes, picked on the selection screen
+// before a new game (unlocks.go). Gold banked in town starts the next
+// campaign.
 //
 // Controls:
-//   w/a/s/d - move
-//   i       - show inventory
-//   p       - pick up item on current tile
-//   u <idx> - use item by index
-//   q       - quit
+//   w/a/s/d     - move (into a door: open it; locked ones need a key)
+//   x or .      - wait a turn
+//   l <dir>     - look at the adjacent tile (w/a/s/d), takes no turn
+//   i           - show inventory
+//   p           - pick up item on current tile (monsters drop loot, see loot.go)
+//   u <idx>     - use item by index
+//   t <i> <dir> - throw item by index in a direction w/a/s/d (see throw.go)
+//   e <idx>     - equip weapon/armor by index (the old piece goes back)
+//   r           - pray at the shrine you stand on (see shrine.go)
+//   c <order>   - order the dog: stay, follow or attack <dir> (takes no turn)
+//   f <idx>     - feed the adjacent dog an item by index (see pet.go)
+//   > / <       - go down / up the stairs you stand on (g - either)
+//   set <k> <v> - render settings: glyphs, palette, hp on|off (saved)
+//   save <file> - save the run (resume with -load <file>)
+//   q           - quit
+//
+// The game runs full screen (termbox, tui.go): single keypresses, with
+// arrows for moving, u/e followed by a digit, and ':' for save and set.
+// -plain keeps the old line-by-line stdout mode for dumb terminals.
 //
 
 type TileType int
@@ -27,6 +105,13 @@ type TileType int
 const (
 	WallTile TileType = iota
 	FloorTile
+	StairsDownTile
+	StairsUpTile
+	DoorClosedTile
+	DoorOpenTile
+	DoorLockedTile // opens with a key (see doors.go)
+	ShrineTile     // prayed at once with 'r' (see shrine.go)
+	ShrineUsedTile
 )
 
 type Tile struct {
@@ -37,102 +122,179 @@ type Tile struct {
 }
 
 type Item struct {
-	Name string
+	Name string `json:"name"`
 	// Consumable: heal amount.
-	Heal int
+	Heal int `json:"heal,omitempty"`
+	// Gold goes straight to the score instead of the inventory.
+	Gold int `json:"gold,omitempty"`
+	// Equipment: worn in Equip slot, adds the bonuses (see equip.go).
+	Equip        EquipSlot `json:"equip,omitempty"`
+	AttackBonus  int       `json:"attack_bonus,omitempty"`
+	DefenseBonus int       `json:"defense_bonus,omitempty"`
+	// Key opens one locked door and is used up.
+	Key bool `json:"key,omitempty"`
+	// Effect of a consumable other than healing (see consumables.go), with
+	// its strength and duration in player turns.
+	Effect ItemEffect `json:"effect,omitempty"`
+	Power  int        `json:"power,omitempty"`
+	Turns  int        `json:"turns,omitempty"`
+	// Range > 0: the item is thrown at a tile picked in targeting mode
+	// (targeting.go).
+	Range int `json:"range,omitempty"`
+	// Generated items (itemgen.go) have a rarity and a weight.
+	Rarity Rarity `json:"rarity,omitempty"`
+	Weight int    `json:"weight,omitempty"`
+	// Corpse: a monster's remains, Age player turns old (corpse.go).
+	Corpse bool `json:"corpse,omitempty"`
+	Age    int  `json:"age,omitempty"`
 }
 
 type Stats struct {
-	HPMax   int
-	HP      int
-	Attack  int
-	Defense int
-	Speed   int
+	HPMax   int `json:"hp_max"`
+	HP      int `json:"hp"`
+	Attack  int `json:"attack"`
+	Defense int `json:"defense"`
+	Speed   int `json:"speed"`
 }
 
 type Entity struct {
-	Name     string
-	X, Y     int
-	Stats    Stats
-	Inv      []Item
-	IsPlayer bool
-	Alive    bool
-	AIType   string // e.g., "basic" for chase AI
+	Name     string `json:"name"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Stats    Stats  `json:"stats"`
+	Inv      []Item `json:"inv,omitempty"`
+	Weapon   *Item  `json:"weapon,omitempty"`
+	Armor    *Item  `json:"armor,omitempty"`
+	IsPlayer bool   `json:"is_player,omitempty"`
+	Alive    bool   `json:"alive"`
+	AIType   string `json:"ai,omitempty"` // e.g., "basic" for chase AI
+	// Glyph is the monster's map glyph from the bestiary (0: the glyph
+	// set's monster glyph).
+	Glyph rune `json:"glyph,omitempty"`
+	// Energy accrues by Speed every tick and pays for actions (schedule.go).
+	Energy int `json:"energy,omitempty"`
+	// Buffs are timed bonuses, e.g. from a strength potion (consumables.go).
+	Buffs []Buff `json:"buffs,omitempty"`
+	// SightRadius is how far the player sees (see computeFOV).
+	SightRadius int `json:"sight,omitempty"`
+	// Monster behaviour (ai.go): the state, turns since the player was last
+	// seen, the tile a wanderer stays near and whether it stepped last turn.
+	AIState   AIState `json:"ai_state,omitempty"`
+	LostTurns int     `json:"lost_turns,omitempty"`
+	HomeX     int     `json:"home_x,omitempty"`
+	HomeY     int     `json:"home_y,omitempty"`
+	Stepped   bool    `json:"stepped,omitempty"`
+	// Level and XP (see xp.go); only the player gains them.
+	Level int `json:"level,omitempty"`
+	XP    int `json:"xp,omitempty"`
+	// Hunger grows every player turn (see hunger.go).
+	Hunger int `json:"hunger,omitempty"`
+	// A dog (pet.go): Stray until recruited, then the player's pet taking
+	// orders; petTarget is who it was told to attack.
+	Pet       bool     `json:"pet,omitempty"`
+	Stray     bool     `json:"stray,omitempty"`
+	PetOrder  PetOrder `json:"pet_order,omitempty"`
+	petTarget *Entity
 }
 
+// World is the whole game. The embedded *Level is the level the player is
+// on; only it is rendered and only its monsters act.
 type World struct {
-	Width    int
-	Height   int
-	Tiles    [][]*Tile
-	Player   *Entity
-	Entities []*Entity
-	Rand     *rand.Rand
-}
-
-// NewWorld creates a new game world with random interior walls.
-func NewWorld(width, height int, r *rand.Rand) *World {
-	tiles := make([][]*Tile, height)
-	for y := 0; y < height; y++ {
-		row := make([]*Tile, width)
-		for x := 0; x < width; x++ {
-			row[x] = &Tile{X: x, Y: y, Type: FloorTile}
-		}
-		tiles[y] = row
-	}
+	*Level
+	Dungeon   *Dungeon
+	Gen       MapGenerator // Lays out every new level
+	Theme     Theme        // Monsters and difficulty (see campaign.go)
+	Width     int
+	Height    int
+	Player    *Entity
+	Pet       *Entity // the recruited dog, wherever it was left, or its body (pet.go)
+	Rand      *rand.Rand
+	RenderCfg RenderConfig
+	Score     Score
+	// DeathCause names what killed the player (for the morgue)
+	DeathCause string
+
+	src rand.Source // Rand's source; a *seededSource can be saved
+}
+
+// NewWorld creates a new game world whose first level is laid out by gen.
+// Only worlds built by newGame (seeded source) can be saved.
+func NewWorld(width, height int, r *rand.Rand, gen MapGenerator) *World {
 	world := &World{
-		Width:    width,
-		Height:   height,
-		Tiles:    tiles,
-		Rand:     r,
-		Entities: make([]*Entity, 0),
+		Dungeon:   &Dungeon{},
+		Gen:       gen,
+		Width:     width,
+		Height:    height,
+		Rand:      r,
+		RenderCfg: DefaultRenderConfig(),
+		Score:     Score{Depth: 1},
 	}
-	world.generateWalls()
+	world.addLevel()
 	return world
 }
 
-// generateWalls sets border walls and adds random interior walls.
-func (w *World) generateWalls() {
-	// Border walls.
-	for x := 0; x < w.Width; x++ {
-		w.Tiles[0][x].Type = WallTile
-		w.Tiles[w.Height-1][x].Type = WallTile
+// PlaceEntity puts an entity on (x, y), marks it alive and tracks it. It
+// fails (false) off the map, on a wall or on a tile another entity holds.
+// Placing an entity already on the level moves it instead of tracking it
+// twice.
+func (w *World) PlaceEntity(e *Entity, x, y int) bool {
+	if x < 0 || x >= w.Width || y < 0 || y >= w.Height || w.Tiles[y][x].Type == WallTile {
+		return false
 	}
-	for y := 0; y < w.Height; y++ {
-		w.Tiles[y][0].Type = WallTile
-		w.Tiles[y][w.Width-1].Type = WallTile
+	if other := w.Tiles[y][x].Entity; other != nil && other != e {
+		return false
 	}
-
-	// Random interior walls (10% density).
-	numWalls := (w.Width * w.Height) / 10
-	for i := 0; i < numWalls; i++ {
-		x := w.Rand.Intn(w.Width-2) + 1
-		y := w.Rand.Intn(w.Height-2) + 1
-		w.Tiles[y][x].Type = WallTile
+	tracked := false
+	for _, o := range w.Entities {
+		tracked = tracked || o == e
 	}
-}
-
-// PlaceEntity places an entity at (x, y) if valid, marks it alive, and tracks it.
-func (w *World) PlaceEntity(e *Entity, x, y int) {
-	if x < 0 || x >= w.Width || y < 0 || y >= w.Height || w.Tiles[y][x].Type == WallTile {
-		return // Invalid position.
+	if tracked && w.Tiles[e.Y][e.X].Entity == e {
+		w.Tiles[e.Y][e.X].Entity = nil
 	}
 	e.X = x
 	e.Y = y
 	e.Alive = true
 	w.Tiles[y][x].Entity = e
-	w.Entities = append(w.Entities, e)
+	if !tracked {
+		w.Entities = append(w.Entities, e)
+	}
 	if e.IsPlayer {
 		w.Player = e
 	}
+	if e.faction() == factionPlayer && e.Pet {
+		w.Pet = e
+	}
+	return true
 }
 
-// PlaceItem places an item at (x, y) if the tile is empty.
-func (w *World) PlaceItem(it Item, x, y int) {
-	if x < 0 || x >= w.Width || y < 0 || y >= w.Height ||
-		w.Tiles[y][x].Type == WallTile || w.Tiles[y][x].Entity != nil {
-		return // Invalid or occupied.
+// PlaceItem puts an item on (x, y). It fails (false) off the map, on a wall
+// and on a tile that already has an entity or an item.
+func (w *World) PlaceItem(it Item, x, y int) bool {
+	if x < 0 || x >= w.Width || y < 0 || y >= w.Height {
+		return false
+	}
+	if t := w.Tiles[y][x]; t.Type == WallTile || t.Entity != nil || t.Item != nil {
+		return false
 	}
 	w.Tiles[y][x].Item = &it
+	return true
+}
+
+// freeFloor returns the floor tiles with no entity (and, if forItems, no
+// item either) in random order; spawners take from the front, so they place
+// exactly as many things as there is room for.
+func (w *World) freeFloor(forItems bool) [][2]int {
+	var free [][2]int
+	for y := 0; y < w.Height; y++ {
+		for x := 0; x < w.Width; x++ {
+			t := w.Tiles[y][x]
+			if t.Type == FloorTile && t.Entity == nil && !(forItems && t.Item != nil) {
+				free = append(free, [2]int{x, y})
+			}
+		}
+	}
+	w.Rand.Shuffle(len(free), func(i, j int) { free[i], free[j] = free[j], free[i] })
+	return free
 }
 
 // MoveEntity attempts to move an entity to (nx, ny). Returns true if successful.
@@ -144,13 +306,23 @@ func (w *World) MoveEntity(e *Entity, nx, ny int) bool {
 	if dest.Type == WallTile {
 		return false
 	}
-	if dest.Entity != nil {
-		// Attack if hostile.
-		if e.IsPlayer != dest.Entity.IsPlayer {
-			w.resolveMelee(e, dest.Entity)
+	if dest.Type.closedDoor() {
+		return w.bumpDoor(e, dest)
+	}
+	if other := dest.Entity; other != nil {
+		switch {
+		case hostile(e, other):
+			w.resolveMelee(e, other)
+			return true
+		case e.IsPlayer && other.Stray:
+			return w.recruit(other)
+		case e.IsPlayer && other == w.Pet:
+			// Swap places with the pet.
+			w.Tiles[e.Y][e.X].Entity, dest.Entity = other, e
+			other.X, other.Y, e.X, e.Y = e.X, e.Y, nx, ny
 			return true
 		}
-		return false // Blocked by friendly.
+		return false // Blocked by friendly (or a stray).
 	}
 	// Valid move.
 	w.Tiles[e.Y][e.X].Entity = nil
@@ -160,19 +332,77 @@ func (w *World) MoveEntity(e *Entity, nx, ny int) bool {
 	return true
 }
 
-// resolveMelee handles a melee attack between attacker and defender.
+// Melee damage: attack minus half the defense (at least 1), rolled within
+// ±meleeVariance of that; one hit in critChance percent is a critical for
+// double damage.
+const (
+	meleeVariance = 0.20
+	critChance    = 10
+	critMult      = 2
+)
+
+// computeMeleeDamage rolls one hit of att against def. Only Attack and
+// Defense are used, so callers pass effective (gear and buffs) values.
+func computeMeleeDamage(att, def Stats, rng *rand.Rand) (dmg int, crit bool) {
+	base := max(att.Attack-def.Defense/2, 1)
+	roll := 1 - meleeVariance + 2*meleeVariance*rng.Float64()
+	dmg = max(int(math.Round(float64(base)*roll)), 1)
+	if rng.Intn(100) < critChance {
+		dmg, crit = dmg*critMult, true
+	}
+	return dmg, crit
+}
+
+// resolveMelee handles a melee attack between attacker and defender; the
+// rolls use the world RNG, so seeded runs replay the same fights.
 func (w *World) resolveMelee(attacker, defender *Entity) {
-	damage := attacker.Stats.Attack - defender.Stats.Defense/2
-	if damage < 1 {
-		damage = 1
+	damage, crit := computeMeleeDamage(
+		Stats{Attack: attacker.EffectiveAttack()},
+		Stats{Defense: defender.EffectiveDefense()},
+		w.Rand)
+	if crit {
+		msg("Критический удар! %s атакует %s на %d урона", attacker.Name, defender.Name, damage)
+	} else {
+		msg("%s атакует %s на %d урона", attacker.Name, defender.Name, damage)
 	}
-	fmt.Printf("%s атакует %s на %d урона\n", attacker.Name, defender.Name, damage)
+	w.hurt(attacker, defender, damage)
+}
+
+// hurt deals damage to defender; attacker gets the credit for a kill.
+func (w *World) hurt(attacker, defender *Entity, damage int) {
 	defender.Stats.HP -= damage
 	if defender.Stats.HP <= 0 {
 		defender.Stats.HP = 0
 		defender.Alive = false
-		fmt.Printf("%s убит(а)!\n", defender.Name)
+		msg("%s убит(а)!", defender.Name)
 		w.Tiles[defender.Y][defender.X].Entity = nil
+		if defender.IsPlayer {
+			w.DeathCause = attacker.Name
+			if attacker == defender {
+				w.DeathCause = "собственная бомба"
+			}
+		}
+		// Kills by the pet count as the player's.
+		if attacker.faction() == factionPlayer && defender.faction() == factionMonster {
+			w.Score.Kills++
+			if attacker.IsPlayer {
+				gainXP(attacker, xpReward(defender))
+			} else {
+				gainXP(w.Player, xpReward(defender))
+			}
+			w.syncPetLevel()
+		}
+		switch {
+		case defender == w.Pet:
+			msg("%s больше не пойдёт за вами. Покойся, верный друг.", defender.Name)
+		case !defender.IsPlayer && !defender.Pet:
+			w.dropCorpse(defender)
+			w.dropLoot(defender)
+		}
+		return
+	}
+	if attacker.Pet && defender.faction() == factionMonster {
+		defender.AIState = AIChase // bitten: it goes after the pet
 	}
 }
 
@@ -188,6 +418,8 @@ func (w *World) RemoveDeadEntities() {
 }
 
 // BFSStepTowards computes the next step (dx, dy) from src towards target using BFS.
+// Monsters use the shared flow map instead; this is for one-off paths.
+// Closed doors count as walls, as they do for monsters.
 // Returns (0, 0) if no path.
 func (w *World) BFSStepTowards(src, target *Entity) (int, int) {
 	type pos struct{ x, y int }
@@ -217,7 +449,7 @@ func (w *World) BFSStepTowards(src, target *Entity) (int, int) {
 			}
 
 			tile := w.Tiles[ny][nx]
-			if tile.Type == WallTile {
+			if tile.Type.blocksMonsters() {
 				continue
 			}
 
@@ -249,62 +481,111 @@ func (w *World) BFSStepTowards(src, target *Entity) (int, int) {
 	}
 }
 
-// Render prints the ASCII map and player HP.
-func (w *World) Render() {
-	fmt.Println()
-	for y := 0; y < w.Height; y++ {
-		var builder strings.Builder
-		for x := 0; x < w.Width; x++ {
-			tile := w.Tiles[y][x]
-			var ch = '.'
-			if tile.Type == WallTile {
-				ch = '#'
-			} else if tile.Entity != nil {
-				if tile.Entity.IsPlayer {
-					ch = '@'
-				} else {
-					ch = 'g' // Generic monster.
-				}
-			} else if tile.Item != nil {
-				ch = '!'
-			}
-			builder.WriteRune(ch)
+// MapString renders the current level, one line per row.
+func (w *World) MapString() string {
+	return w.mapString(w.RenderCfg)
+}
+
+// renderString renders the level plain, or with the standard palette when
+// colors is set, whatever palette the world is configured with.
+func renderString(w *World, colors bool) string {
+	cfg := w.RenderCfg
+	cfg.Palette = PaletteNone
+	if colors {
+		cfg.Palette = PaletteStandard
+	}
+	return w.mapString(cfg)
+}
+
+func (w *World) mapString(cfg RenderConfig) string {
+	var builder strings.Builder
+	x0, y0, cols, rows := w.view(cfg)
+	for y := y0; y < y0+rows; y++ {
+		for x := x0; x < x0+cols; x++ {
+			renderCell(&builder, cfg, w.Tiles[y][x], w.visibility(x, y))
 		}
-		fmt.Println(builder.String())
+		builder.WriteByte('\n')
 	}
-	fmt.Printf("HP: %d/%d\n", w.Player.Stats.HP, w.Player.Stats.HPMax)
+	return builder.String()
+}
+
+// Render prints the ASCII map and the status line.
+func (w *World) Render() {
+	fmt.Println()
+	fmt.Print(w.MapString())
+	fmt.Println(w.StatusLine())
+}
+
+// StatusLine is the player's HP, level, gold, depth and hunger.
+func (w *World) StatusLine() string {
+	p := w.Player
+	return fmt.Sprintf("HP: %d/%d  Lv %d  XP: %d/%d  Золото: %d  Уровень: %d/%d",
+		p.Stats.HP, p.Stats.HPMax, p.CharLevel(), p.XP, xpForLevel(p.CharLevel()), w.Score.Gold, w.Depth, len(w.Dungeon.Levels)) + w.hungerStatus()
 }
 
 // PlayerPickUp picks up the item on the player's current tile.
 func (w *World) PlayerPickUp() {
 	tile := w.Tiles[w.Player.Y][w.Player.X]
 	if tile.Item == nil {
-		fmt.Println("Здесь нет предметов")
+		msg("Здесь нет предметов")
 		return
 	}
 	item := *tile.Item
+	if item.Gold > 0 {
+		w.Score.Gold += item.Gold
+		msg("Подобрали %d золота", item.Gold)
+		tile.Item = nil
+		return
+	}
 	w.Player.Inv = append(w.Player.Inv, item)
-	fmt.Printf("Подобрали: %s\n", item.Name)
+	msg("Подобрали: %s", item.Name)
 	tile.Item = nil
 }
 
 // PlayerUseItem uses the item at the given index in the player's inventory.
 func (w *World) PlayerUseItem(idx int) {
 	if idx < 0 || idx >= len(w.Player.Inv) {
-		fmt.Println("Неверный индекс")
+		msg("Неверный индекс")
 		return
 	}
 	item := w.Player.Inv[idx]
+	if item.Equip != NoSlot {
+		msg("%s надевается командой e %d", item.Name, idx)
+		return
+	}
+	if item.Key {
+		msg("Ключ отпирает дверь, когда вы в неё входите")
+		return
+	}
+	if item.targeted() {
+		msg("%s нужно бросить: u %d <dx> <dy>", item.Name, idx)
+		return
+	}
+	// Remove used item first: a bomb may kill the player, a teleport moves them.
+	w.Player.Inv = append(w.Player.Inv[:idx], w.Player.Inv[idx+1:]...)
+	switch item.Effect {
+	case EffectStrength:
+		w.Player.addBuff(Buff{Name: item.Name, AttackBonus: item.Power, Turns: item.Turns})
+		msg("Использовано %s: атака +%d на %d ходов", item.Name, item.Power, item.Turns)
+	case EffectTeleport:
+		w.teleportPlayer()
+	case EffectBomb:
+		w.explode(w.Player, w.Player.X, w.Player.Y, bombRadius, item.Power)
+	case EffectFood:
+		if item.Corpse {
+			w.eatCorpse(item)
+		} else {
+			w.eat(item)
+		}
+	}
 	if item.Heal > 0 {
 		healAmt := item.Heal
 		w.Player.Stats.HP += healAmt
 		if w.Player.Stats.HP > w.Player.Stats.HPMax {
 			w.Player.Stats.HP = w.Player.Stats.HPMax
 		}
-		fmt.Printf("Использовано %s, восстановлено %d HP\n", item.Name, healAmt)
+		msg("Использовано %s, восстановлено %d HP", item.Name, healAmt)
 	}
-	// Remove used item.
-	w.Player.Inv = append(w.Player.Inv[:idx], w.Player.Inv[idx+1:]...)
 }
 
 // abs returns the absolute value of a.
@@ -315,147 +596,291 @@ func abs(a int) int {
 	return a
 }
 
-// setupWorld initializes the world with dimensions.
-func setupWorld(randSrc rand.Source) *World {
-	const (
-		width  = 25
-		height = 12
-	)
+// setupWorld initializes a mapWidth x mapHeight world (viewport.go).
+func setupWorld(randSrc rand.Source, gen MapGenerator) *World {
 	r := rand.New(randSrc)
-	return NewWorld(width, height, r)
+	world := NewWorld(mapWidth, mapHeight, r, gen)
+	world.src = randSrc
+	return world
 }
 
-// setupPlayer creates and places the player in the world.
-func setupPlayer(world *World) {
-	player := &Entity{
+// newPlayer creates a fresh player.
+func newPlayer() *Entity {
+	return &Entity{
 		Name:     "Игрок",
 		Stats:    Stats{HPMax: 30, HP: 30, Attack: 5, Defense: 2, Speed: 5},
 		IsPlayer: true,
+		Level:    1,
+
+		SightRadius: defaultSightRadius,
 	}
-	world.PlaceEntity(player, world.Width/2, world.Height/2)
 }
 
-// spawnMonsters adds a specified number of monsters to the world.
+// placePlayer puts the player on the floor tile nearest the map center.
+func placePlayer(world *World, player *Entity) {
+	x, y := world.nearestFloor(world.Width/2, world.Height/2)
+	world.PlaceEntity(player, x, y)
+}
+
+// spawnMonsters adds numMonsters monsters (see bestiary.go) to the world,
+// fewer only if the level runs out of free floor. Their stats are scaled by
+// the difficulty (difficulty.go).
 func spawnMonsters(world *World, numMonsters int) {
-	const borderOffset = 4
-	for i := 0; i < numMonsters; i++ {
-		x := world.Rand.Intn(world.Width-borderOffset) + borderOffset/2
-		y := world.Rand.Intn(world.Height-borderOffset) + borderOffset/2
-		if world.Tiles[y][x].Entity == nil && world.Tiles[y][x].Type != WallTile {
-			monster := &Entity{
-				Name:     "Гоблин",
-				Stats:    Stats{HPMax: 8, HP: 8, Attack: 3, Defense: 0, Speed: 3},
-				IsPlayer: false,
-				AIType:   "basic",
-			}
-			world.PlaceEntity(monster, x, y)
+	for _, at := range firstN(world.freeFloor(false), numMonsters) {
+		monster := world.monsterKind().spawn(world.monsterTier())
+		difficulty.scale(monster)
+		if world.Rand.Intn(2) == 0 {
+			monster.AIState = AIWander
 		}
+		monster.HomeX, monster.HomeY = at[0], at[1]
+		world.PlaceEntity(monster, at[0], at[1])
 	}
 }
 
-// spawnItems places a specified number of items in the world.
+// spawnItems places numItems items in the world: consumables (see
+// consumables.go), heal flasks as often as the difficulty allows, and one
+// in generatedEvery a generated item (itemgen.go).
 func spawnItems(world *World, numItems int) {
-	const borderOffset = 4
-	for i := 0; i < numItems; i++ {
-		x := world.Rand.Intn(world.Width-borderOffset) + borderOffset/2
-		y := world.Rand.Intn(world.Height-borderOffset) + borderOffset/2
-		if world.Tiles[y][x].Item == nil && world.Tiles[y][x].Entity == nil && world.Tiles[y][x].Type != WallTile {
-			item := Item{Name: "Фляга здоровья", Heal: 8}
-			world.PlaceItem(item, x, y)
+	mix := difficulty.itemMix()
+	for _, at := range firstN(world.freeFloor(true), numItems) {
+		it := mix.pick(world.Rand)
+		if world.Rand.Intn(generatedEvery) == 0 {
+			it = generateItem(world.Rand, world.Depth)
 		}
+		world.PlaceItem(it, at[0], at[1])
+	}
+	if world.Depth == 1 {
+		spawnGear(world)
 	}
 }
 
-// runGameLoop handles the main game loop: input, player actions, monster turns, and cleanup.
-func runGameLoop(world *World) {
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		world.Render()
-		if world.Player.Stats.HP <= 0 {
-			fmt.Println("Вы погибли. Игра окончена.")
-			return
+// spawnGold scatters gold piles worth 5-14 each.
+func spawnGold(world *World, numPiles int) {
+	for _, at := range firstN(world.freeFloor(true), numPiles) {
+		amount := world.Rand.Intn(10) + 5
+		world.PlaceItem(Item{Name: "Золото", Gold: amount}, at[0], at[1])
+	}
+}
+
+// firstN is the first n tiles, or all of them if there are fewer.
+func firstN(tiles [][2]int, n int) [][2]int {
+	return tiles[:min(n, len(tiles))]
+}
+
+// monsterTurns gives every monster on the level one action, regardless of
+// speed (the scheduler, schedule.go, decides who acts during play).
+func (w *World) monsterTurns() {
+	var flow *DistanceMap
+	for _, entity := range w.Entities {
+		if !entity.IsPlayer && !entity.Pet && entity.Alive {
+			w.monsterAct(entity, &flow)
 		}
+	}
+}
 
-		fmt.Print("<<Command (w/a/s/d, p pick up, i inv, u use <i>, q quit)>>: ")
-		line, err := reader.ReadString('\n')
+// monsterAct runs one monster action. It first updates the monster's state
+// (ai.go): idle monsters stay put, wanderers stroll, and chasers follow one
+// shared flow map (flow.go), built in *flow only if someone has to walk. A
+// chaser closer to the pet than to the player goes for the pet (pet.go).
+func (w *World) monsterAct(entity *Entity, flow **DistanceMap) {
+	w.perceive(entity)
+	switch entity.AIState {
+	case AIIdle:
+		return
+	case AIWander:
+		w.wander(entity)
+		return
+	}
+	target := w.aggroTarget(entity)
+	if stepDistance(entity, target) == 1 {
+		w.resolveMelee(entity, target)
+		return
+	}
+	if target != w.Player {
+		if stepX, stepY := w.BFSStepTowards(entity, target); stepX != 0 || stepY != 0 {
+			w.MoveEntity(entity, entity.X+stepX, entity.Y+stepY)
+		}
+		return
+	}
+	if *flow == nil {
+		*flow = w.distanceMapFrom(w.Player.X, w.Player.Y)
+	}
+	stepX, stepY := (*flow).StepFrom(entity.X, entity.Y)
+	if stepX != 0 || stepY != 0 {
+		w.MoveEntity(entity, entity.X+stepX, entity.Y+stepY)
+	}
+}
+
+func main() {
+	cfg := LoadRenderConfig(renderConfigPath())
+	flag.StringVar(&cfg.Glyphs, "glyphs", cfg.Glyphs, "glyph set: ascii or unicode")
+	flag.StringVar(&cfg.Palette, "palette", cfg.Palette, "color palette: none, standard or colorblind")
+	color := flag.Bool("color", false, "ANSI colors (the standard palette unless -palette picks another); off when stdout isn't a terminal")
+	flag.BoolVar(&cfg.ShowHP, "hp", cfg.ShowHP, "show monster HP digit next to its glyph")
+	daily := flag.Bool("daily", false, "play today's seeded challenge")
+	single := flag.Bool("single", false, "play a single dungeon instead of the campaign")
+	sight := flag.Int("sight", defaultSightRadius, "player sight radius in tiles")
+	genName := flag.String("gen", GenRooms, "map generator for -single: rooms or scatter (the daily always uses rooms)")
+	loadPath := flag.String("load", "", "resume a game saved with the save command")
+	seedFlag := flag.Int64("seed", 0, "world seed: the same seed builds the same dungeons (0 = random, printed at startup)")
+	bestiaryPath := flag.String("bestiary", "", "load monster kinds from a JSON file instead of the built-in bestiary")
+	plain := flag.Bool("plain", false, "line-by-line stdout mode instead of the full-screen UI (dumb terminals, pipes)")
+	morgue := flag.String("morgue", morguePath(), "score file: every death is recorded there")
+	scores := flag.Bool("scores", false, "print the top 10 runs from the score file and exit")
+	diffName := flag.String("difficulty", "normal", "easy, normal or hard: monster count and strength, heal flasks, XP per level")
+	monsters := flag.Int("monsters", -1, "monsters per level (default: from -difficulty)")
+	items := flag.Int("items", -1, "consumables per level (default: from -difficulty)")
+	noHunger := flag.Bool("nohunger", false, "no hunger clock: no food, no regeneration, no starvation")
+	flag.IntVar(&mapWidth, "width", mapWidth, "map width in tiles; maps wider than the screen scroll")
+	flag.IntVar(&mapHeight, "height", mapHeight, "map height in tiles; maps taller than the screen scroll")
+	simulate := flag.Int("simulate", 0, "play N seeded single dungeons with the bot, headless, and print statistics")
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "parallel games for -simulate")
+	flag.Parse()
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if *scores {
+		entries, err := LoadMorgue(*morgue)
 		if err != nil {
-			fmt.Printf("Input error: %v\n", err)
-			continue
+			fmt.Fprintln(os.Stderr, err)
+		}
+		printScores(os.Stdout, entries)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *color && cfg.Palette == PaletteNone {
+		cfg.Palette = PaletteStandard
+	}
+	if !stdoutIsTerminal() {
+		cfg.Palette = PaletteNone
+	}
+	gen, err := generatorByName(*genName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *bestiaryPath != "" {
+		if bestiary, err = LoadBestiary(*bestiaryPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if difficulty, err = difficultyFromFlags(*diffName, *monsters, *items, set); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *daily && (set["difficulty"] || set["monsters"] || set["items"] || *noHunger) {
+		fmt.Fprintln(os.Stderr, "-difficulty, -monsters, -items and -nohunger cannot be combined with -daily")
+		os.Exit(2)
+	}
+	hungerOn = !*noHunger
+	msg("%s", difficulty)
+
+	if err := validateMapSize(mapWidth, mapHeight); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if (set["width"] || set["height"]) && (*daily || *loadPath != "") {
+		fmt.Fprintln(os.Stderr, "-width and -height cannot be combined with -daily or -load")
+		os.Exit(2)
+	}
+
+	if *seedFlag != 0 && (*daily || *loadPath != "") {
+		fmt.Fprintln(os.Stderr, "-seed cannot be combined with -daily or -load")
+		os.Exit(2)
+	}
+
+	if *simulate > 0 {
+		if *daily || *loadPath != "" {
+			fmt.Fprintln(os.Stderr, "-simulate cannot be combined with -daily or -load")
+			os.Exit(2)
 		}
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+		first := *seedFlag
+		if first == 0 {
+			first = 1
 		}
+		start := time.Now()
+		results := Simulate(*simulate, first, *workers, gen)
+		summarize(results).print(os.Stdout, time.Since(start))
+		return
+	}
 
-		parts := strings.Split(line, " ")
-		cmd := parts[0]
-		switch cmd {
-		case "q":
-			fmt.Println("Выход")
-			return
-		case "w", "a", "s", "d":
-			dx, dy := 0, 0
-			switch cmd {
-			case "w":
-				dy = -1
-			case "s":
-				dy = 1
-			case "a":
-				dx = -1
-			case "d":
-				dx = 1
-			}
-			world.MoveEntity(world.Player, world.Player.X+dx, world.Player.Y+dy)
-		case "p":
-			world.PlayerPickUp()
-		case "i":
-			fmt.Println("Инвентарь:")
-			for idx, item := range world.Player.Inv {
-				fmt.Printf("[%d] %s (heal:%d)\n", idx, item.Name, item.Heal)
-			}
-		case "u":
-			if len(parts) < 2 {
-				fmt.Println("u <index>")
-				continue
-			}
-			idx, err := strconv.Atoi(parts[1])
-			if err != nil {
-				fmt.Println("Неверный индекс")
-				continue
-			}
-			world.PlayerUseItem(idx)
-		default:
-			fmt.Println("Неизвестная команда")
+	profile, err := LoadProfile(profilePath())
+	if err != nil {
+		msg("%v", err)
+	}
+
+	if *loadPath != "" {
+		if *daily {
+			fmt.Fprintln(os.Stderr, "-load cannot be combined with -daily")
+			os.Exit(2)
 		}
+		game, err := LoadGame(*loadPath, gen, cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		game.Profile, game.MorguePath = profile, *morgue
+		play(game, *plain)
+		return
+	}
 
-		// Monster turns: simple chase AI.
-		for _, entity := range world.Entities {
-			if entity.IsPlayer || !entity.Alive {
-				continue
+	if !*daily {
+		seed := *seedFlag
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		msg("Сид мира: %d", seed)
+		begin := func(g *Game, l Loadout) {
+			if *single {
+				world := buildDungeon(seed, gen, l.Theme, dungeonLevels, l.player(*sight))
+				world.RenderCfg = cfg
+				g.World, g.State = world, StateDungeon
+				return
 			}
-			dx := world.Player.X - entity.X
-			dy := world.Player.Y - entity.Y
-			if abs(dx)+abs(dy) == 1 {
-				world.resolveMelee(entity, world.Player)
-			} else {
-				stepX, stepY := world.BFSStepTowards(entity, world.Player)
-				if stepX != 0 || stepY != 0 {
-					world.MoveEntity(entity, entity.X+stepX, entity.Y+stepY)
-				}
+			c := NewCampaign(seed)
+			c.Player = l.player(*sight)
+			if gold := profile.Withdraw(); gold > 0 {
+				c.Gold += gold
+				msg("Из банка: %d зол.", gold)
+				profile.commit()
 			}
+			g.Campaign, g.State = c, StateOverworld
 		}
+		game := NewSelectGame(profile, newSelection(profile, *single, begin), cfg)
+		game.MorguePath = *morgue
+		play(game, *plain)
+		return
+	}
 
-		// Cleanup.
-		world.RemoveDeadEntities()
+	date := dailyDate(time.Now())
+	scored := true
+	if scores, err := LoadDailyScores(dailyScoresPath()); err != nil {
+		msg("Не удалось прочитать результаты: %v", err)
+	} else if prev, ok := scores[date]; ok {
+		msg("Испытание %s уже пройдено: %s\nЭто тренировочный забег.", date, prev.Share)
+		scored = false
 	}
+	world := newGame(dailySeed(date), defaultGenerator())
+	world.Player.SightRadius = *sight
+	game := NewSingleGame(world, cfg)
+	game.Profile, game.MorguePath = profile, *morgue
+	play(game, *plain)
+	finishDaily(date, world, game.Won, scored)
 }
 
-func main() {
-	var randSrc = rand.NewSource(time.Now().UnixNano())
-	world := setupWorld(randSrc)
-	setupPlayer(world)
-	spawnMonsters(world, 6)
-	spawnItems(world, 5)
-
-	runGameLoop(world)
+// play runs the game full screen, or line by line with -plain or when the
+// terminal cannot do full screen.
+func play(g *Game, plain bool) {
+	if !plain {
+		err := g.RunTermbox()
+		if err == nil {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "full-screen UI unavailable (%v), using plain mode\n", err)
+	}
+	g.Run(bufio.NewReader(os.Stdin))
 }
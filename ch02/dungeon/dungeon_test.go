@@ -0,0 +1,2943 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/nsf/termbox-go"
+)
+
+// allProfiles enumerates every glyph set / palette combination.
+func allProfiles() []RenderConfig {
+	var out []RenderConfig
+	for g := range glyphSets {
+		for p := range palettes {
+			out = append(out, RenderConfig{Glyphs: g, Palette: p})
+		}
+	}
+	return out
+}
+
+func TestProfilesCoverEveryKind(t *testing.T) {
+	for _, cfg := range allProfiles() {
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		glyphs := glyphSets[cfg.Glyphs]
+		for k := CellKind(0); k < numCellKinds; k++ {
+			if _, ok := glyphs[k]; !ok {
+				t.Errorf("%+v: no glyph for kind %d", cfg, k)
+			}
+			if cfg.Palette != PaletteNone && palettes[cfg.Palette][k] == "" {
+				t.Errorf("%+v: no color for kind %d", cfg, k)
+			}
+		}
+		// A walkable cell must never look like a blocking one.
+		for a := CellKind(0); a < numCellKinds; a++ {
+			for b := CellKind(0); b < numCellKinds; b++ {
+				if a.blocking() != b.blocking() && glyphs[a] == glyphs[b] {
+					t.Errorf("%+v: kinds %d and %d share glyph %q", cfg, a, b, glyphs[a])
+				}
+			}
+		}
+	}
+}
+
+func TestRenderCellShowHP(t *testing.T) {
+	cfg := RenderConfig{Glyphs: GlyphsASCII, Palette: PaletteNone, ShowHP: true}
+	m := &Entity{Name: "g", Stats: Stats{HPMax: 8, HP: 4}}
+	var b strings.Builder
+	renderCell(&b, cfg, &Tile{Type: FloorTile, Entity: m}, Visible)
+	renderCell(&b, cfg, &Tile{Type: FloorTile}, Visible)
+	if got := b.String(); got != "g5. " {
+		t.Fatalf("unexpected cells %q", got)
+	}
+}
+
+func TestRenderConfigPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "render.json")
+	cfg := DefaultRenderConfig()
+	if err := cfg.applySetting("palette", PaletteColorblind); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.applySetting("palette", "neon"); err == nil {
+		t.Fatal("unknown palette must be rejected")
+	}
+	if err := SaveRenderConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := LoadRenderConfig(path); got != cfg {
+		t.Fatalf("round trip: got %+v want %+v", got, cfg)
+	}
+}
+
+func TestLevelDeterministicFromSeed(t *testing.T) {
+	seed := dailySeed("2024-05-01")
+	a, b := newGame(seed, defaultGenerator()), newGame(seed, defaultGenerator())
+	if len(a.Dungeon.Levels) != dungeonLevels || len(b.Dungeon.Levels) != dungeonLevels {
+		t.Fatalf("want %d levels", dungeonLevels)
+	}
+	for i, la := range a.Dungeon.Levels {
+		lb := b.Dungeon.Levels[i]
+		for y := 0; y < a.Height; y++ {
+			for x := 0; x < a.Width; x++ {
+				ta, tb := la.Tiles[y][x], lb.Tiles[y][x]
+				if ta.Type != tb.Type {
+					t.Fatalf("level %d tile (%d,%d) type differs", i+1, x, y)
+				}
+				if (ta.Item == nil) != (tb.Item == nil) || (ta.Item != nil && *ta.Item != *tb.Item) {
+					t.Fatalf("level %d tile (%d,%d) item differs", i+1, x, y)
+				}
+				if (ta.Entity == nil) != (tb.Entity == nil) || (ta.Entity != nil && ta.Entity.Name != tb.Entity.Name) {
+					t.Fatalf("level %d tile (%d,%d) entity differs", i+1, x, y)
+				}
+			}
+		}
+		if len(la.Entities) != len(lb.Entities) {
+			t.Fatalf("level %d entity count differs: %d vs %d", i+1, len(la.Entities), len(lb.Entities))
+		}
+	}
+
+	if dailySeed("2024-05-01") == dailySeed("2024-05-02") {
+		t.Fatal("different days should get different seeds")
+	}
+}
+
+func TestDailyDateIsUTC(t *testing.T) {
+	// 23:30 in UTC-5 is already the next day in UTC
+	tz := time.FixedZone("EST", -5*3600)
+	if got := dailyDate(time.Date(2024, 5, 1, 23, 30, 0, 0, tz)); got != "2024-05-02" {
+		t.Fatalf("dailyDate = %s", got)
+	}
+}
+
+func TestDailyScoreOnlyOncePerDay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daily_scores.json")
+	res := DailyResult{Score: Score{Depth: 1, Kills: 3, Turns: 40, Gold: 12}}
+	res.Points = res.Score.Points()
+	res.Share = shareString("2024-05-01", res)
+	if !strings.HasPrefix(res.Share, "Dungeon 2024-05-01 ") || !strings.Contains(res.Share, "K3 G12 T40") {
+		t.Fatalf("unexpected share string %q", res.Share)
+	}
+
+	if err := RecordDailyScore(path, "2024-05-01", res); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordDailyScore(path, "2024-05-01", res); !errors.Is(err, errAlreadyPlayed) {
+		t.Fatalf("second attempt should be refused, got %v", err)
+	}
+	if err := RecordDailyScore(path, "2024-05-02", res); err != nil {
+		t.Fatalf("next day should be allowed: %v", err)
+	}
+	scores, err := LoadDailyScores(path)
+	if err != nil || len(scores) != 2 || scores["2024-05-01"].Points != res.Points {
+		t.Fatalf("scores not persisted: %v %+v", err, scores)
+	}
+}
+
+// parseMap builds a tile grid from a hand-drawn map: '#' is wall, '@' marks
+// the origin, anything else is floor.
+func parseMap(rows ...string) (tiles [][]*Tile, ox, oy int) {
+	for y, row := range rows {
+		var line []*Tile
+		for x, ch := range []rune(row) {
+			t := &Tile{X: x, Y: y, Type: FloorTile}
+			switch ch {
+			case '#':
+				t.Type = WallTile
+			case '+':
+				t.Type = DoorClosedTile
+			case '=':
+				t.Type = DoorLockedTile
+			case '\'':
+				t.Type = DoorOpenTile
+			case '@':
+				ox, oy = x, y
+			}
+			line = append(line, t)
+		}
+		tiles = append(tiles, line)
+	}
+	return tiles, ox, oy
+}
+
+// drawFOV renders a visibility grid as '*' (seen) and '.' (not seen).
+func drawFOV(vis [][]bool) string {
+	var b strings.Builder
+	for _, row := range vis {
+		for _, v := range row {
+			if v {
+				b.WriteByte('*')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestFOVHandDrawnMaps(t *testing.T) {
+	cases := []struct {
+		name   string
+		radius int
+		rows   []string
+		want   string
+	}{
+		{"wall blocks corridor", 10, []string{
+			"#######",
+			"#@.#..#",
+			"#######",
+		}, "****...\n****...\n****...\n"},
+		{"radius limits open room", 2, []string{
+			"       ",
+			"       ",
+			"   @   ",
+			"       ",
+			"       ",
+		}, "..***..\n.*****.\n.*****.\n.*****.\n..***..\n"},
+		{"pillar casts shadow", 6, []string{
+			"@ #   ",
+		}, "***...\n"},
+	}
+	for _, tc := range cases {
+		tiles, ox, oy := parseMap(tc.rows...)
+		if got := drawFOV(computeFOV(tiles, ox, oy, tc.radius)); got != tc.want {
+			t.Errorf("%s:\ngot\n%swant\n%s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFOVConvexRoomFullyVisible(t *testing.T) {
+	tiles, _, _ := parseMap(
+		"#########",
+		"#       #",
+		"#       #",
+		"#       #",
+		"#########",
+	)
+	vis := computeFOV(tiles, 4, 2, 20)
+	for y := range vis {
+		for x := range vis[y] {
+			if !vis[y][x] {
+				t.Fatalf("(%d,%d) should be visible from the middle of a convex room", x, y)
+			}
+		}
+	}
+}
+
+func TestFogRendering(t *testing.T) {
+	cfg := RenderConfig{Glyphs: GlyphsASCII, Palette: PaletteNone, ShowHP: true}
+	m := &Entity{Name: "g", Stats: Stats{HPMax: 8, HP: 8}}
+	tile := &Tile{Type: FloorTile, Entity: m}
+	var b strings.Builder
+	renderCell(&b, cfg, tile, Unseen)
+	renderCell(&b, cfg, tile, Explored)
+	renderCell(&b, cfg, tile, Visible)
+	if got := b.String(); got != "  . g9" {
+		t.Fatalf("unexpected cells %q", got)
+	}
+
+	b.Reset()
+	cfg = RenderConfig{Glyphs: GlyphsASCII, Palette: PaletteStandard}
+	renderCell(&b, cfg, &Tile{Type: WallTile}, Explored)
+	if got := b.String(); got != "\033[2;37m#\033[0m" {
+		t.Fatalf("explored wall should be dimmed, got %q", got)
+	}
+}
+
+func TestRenderStringPlainAndColored(t *testing.T) {
+	w := fovWorld(10,
+		"#####",
+		"#@ g#",
+		"#   #",
+		"#####",
+	)
+	w.Tiles[2][1].Item = &Item{Name: "Фляга здоровья", Heal: 8}
+	w.Tiles[2][3].Type = StairsDownTile
+	w.RenderCfg = RenderConfig{Glyphs: GlyphsASCII, Palette: PaletteColorblind}
+
+	plain := "#####\n#@.g#\n#!.>#\n#####\n"
+	if got := renderString(w, false); got != plain {
+		t.Fatalf("plain output:\n%q\nwant\n%q", got, plain)
+	}
+
+	colored := renderString(w, true)
+	for _, want := range []string{
+		"\033[37m#\033[0m",
+		"\033[1;32m@\033[0m",
+		"\033[31mg\033[0m",
+		"\033[33m!\033[0m",
+		"\033[1;36m>\033[0m",
+	} {
+		if !strings.Contains(colored, want) {
+			t.Errorf("colored output lacks %q:\n%q", want, colored)
+		}
+	}
+	stripped := regexp.MustCompile("\033\\[[0-9;]*m").ReplaceAllString(colored, "")
+	if stripped != plain {
+		t.Errorf("colored output without escapes = %q, want %q", stripped, plain)
+	}
+}
+
+// fovWorld builds a World from a hand-drawn map with the player at '@' and
+// monsters at 'g'.
+func fovWorld(radius int, rows ...string) *World {
+	tiles, ox, oy := parseMap(rows...)
+	w := &World{Width: len(tiles[0]), Height: len(tiles), Level: &Level{Depth: 1, Tiles: tiles}, Rand: rand.New(rand.NewSource(1))}
+	w.Dungeon = &Dungeon{Levels: []*Level{w.Level}}
+	w.PlaceEntity(&Entity{Name: "Игрок", IsPlayer: true, SightRadius: radius, Stats: Stats{HPMax: 30, HP: 30}}, ox, oy)
+	for y, row := range rows {
+		for x, ch := range []rune(row) {
+			if ch == 'g' {
+				w.PlaceEntity(&Entity{Name: "Гоблин", Stats: Stats{HPMax: 8, HP: 8}}, x, y)
+			}
+		}
+	}
+	return w
+}
+
+func TestMonstersChaseOnlyAfterSeeingPlayer(t *testing.T) {
+	w := fovWorld(10,
+		"##########",
+		"#@   #  g#",
+		"#    #   #",
+		"#        #",
+		"##########",
+	)
+	goblin := w.Entities[1]
+	w.monsterTurns()
+	if goblin.AIState != AIIdle || goblin.X != 8 || goblin.Y != 1 {
+		t.Fatalf("hidden goblin should stay put, at (%d,%d) state %q", goblin.X, goblin.Y, goblin.AIState)
+	}
+
+	// Step past the wall: now the goblin has a clear line to the player
+	w.MoveEntity(w.Player, 7, 3)
+	w.monsterTurns()
+	if goblin.AIState != AIChase || goblin.HomeX != 7 || goblin.HomeY != 3 {
+		t.Fatalf("goblin in view should chase, state %q home (%d,%d)", goblin.AIState, goblin.HomeX, goblin.HomeY)
+	}
+	w.MoveEntity(w.Player, 1, 1)
+	x, y := goblin.X, goblin.Y
+	w.monsterTurns()
+	if goblin.X == x && goblin.Y == y {
+		t.Fatal("goblin that saw the player should keep chasing out of view")
+	}
+}
+
+func TestAIStateTransitions(t *testing.T) {
+	cases := []struct {
+		state     AIState
+		lost      int
+		sees      bool
+		wantState AIState
+		wantLost  int
+	}{
+		{AIIdle, 0, false, AIIdle, 0},
+		{AIIdle, 0, true, AIChase, 0},
+		{AIWander, 0, false, AIWander, 0},
+		{AIWander, 0, true, AIChase, 0},
+		{AIChase, 0, true, AIChase, 0},
+		{AIChase, 3, true, AIChase, 0}, // seen again: the count restarts
+		{AIChase, 0, false, AIChase, 1},
+		{AIChase, giveUpTurns - 2, false, AIChase, giveUpTurns - 1},
+		{AIChase, giveUpTurns - 1, false, AIWander, 0},
+	}
+	for _, c := range cases {
+		state, lost := nextAIState(c.state, c.lost, c.sees)
+		if state != c.wantState || lost != c.wantLost {
+			t.Errorf("nextAIState(%q, %d, %v) = %q, %d; want %q, %d", c.state, c.lost, c.sees, state, lost, c.wantState, c.wantLost)
+		}
+	}
+}
+
+func TestMonsterSightNeedsRangeAndLine(t *testing.T) {
+	w := fovWorld(10,
+		"################",
+		"#@     g   #  g#",
+		"#          #   #",
+		"################",
+	)
+	near, walled := w.Entities[1], w.Entities[2]
+	if !w.seesPlayer(near) {
+		t.Fatal("goblin 6 tiles away in the open should see the player")
+	}
+	if w.seesPlayer(walled) {
+		t.Fatal("goblin behind a wall must not see the player")
+	}
+	near.SightRadius = 5
+	if w.seesPlayer(near) {
+		t.Fatal("player beyond the goblin's sight radius must be unseen")
+	}
+}
+
+func TestChaserGivesUpAndWandersNearLastSeen(t *testing.T) {
+	w := fovWorld(10,
+		"###############",
+		"#@            #",
+		"#######       #",
+		"#g            #",
+		"###############",
+	)
+	goblin := w.Entities[1]
+	goblin.AIState, goblin.HomeX, goblin.HomeY = AIChase, 1, 1
+	// The player slips out of sight; the goblin has no path (walled row)
+	// and loses track after giveUpTurns of its turns
+	w.Tiles[3][2].Type = WallTile
+	for i := 0; i < giveUpTurns-1; i++ {
+		w.monsterTurns()
+		if goblin.AIState != AIChase {
+			t.Fatalf("gave up after %d turns, want %d", i+1, giveUpTurns)
+		}
+	}
+	w.monsterTurns()
+	if goblin.AIState != AIWander {
+		t.Fatalf("state after %d unseen turns: %q", giveUpTurns, goblin.AIState)
+	}
+	if goblin.HomeX != 1 || goblin.HomeY != 1 {
+		t.Fatalf("wander home should be the last-seen tile, got (%d,%d)", goblin.HomeX, goblin.HomeY)
+	}
+
+	// Wandering: a step at most every other turn, never past the leash
+	w.Tiles[3][2].Type = FloorTile
+	w.MoveEntity(w.Player, 13, 1) // far and hidden
+	goblin.HomeX, goblin.HomeY, goblin.Stepped = 3, 3, false
+	prev := [2]int{goblin.X, goblin.Y}
+	for turn := 0; turn < 40; turn++ {
+		w.monsterTurns()
+		cur := [2]int{goblin.X, goblin.Y}
+		if turn%2 == 1 && cur != prev {
+			t.Fatalf("turn %d: a wanderer only steps every other turn", turn)
+		}
+		if abs(goblin.X-3) > wanderLeash || abs(goblin.Y-3) > wanderLeash {
+			t.Fatalf("wandered off to (%d,%d)", goblin.X, goblin.Y)
+		}
+		prev = cur
+	}
+}
+
+func TestDungeonStairsConnectLevels(t *testing.T) {
+	w := newGame(7, defaultGenerator())
+	if w.Level != w.Dungeon.Levels[0] || w.Player == nil {
+		t.Fatal("game should start on level 1 with the player placed")
+	}
+	for _, lvl := range w.Dungeon.Levels {
+		if lvl.Tiles[lvl.DownY][lvl.DownX].Type != StairsDownTile {
+			t.Fatalf("level %d: no down stairs at (%d,%d)", lvl.Depth, lvl.DownX, lvl.DownY)
+		}
+		if lvl.Depth > 1 && lvl.Tiles[lvl.UpY][lvl.UpX].Type != StairsUpTile {
+			t.Fatalf("level %d: no up stairs", lvl.Depth)
+		}
+	}
+	// Stairs are reachable: a path exists from the start to the down stairs
+	target := &Entity{X: w.DownX, Y: w.DownY}
+	if dx, dy := w.BFSStepTowards(w.Player, target); dx == 0 && dy == 0 {
+		t.Fatal("down stairs on level 1 must be reachable from the start")
+	}
+	if deep, top := monsterStats(3), monsterStats(1); deep.HPMax <= top.HPMax || deep.Attack <= top.Attack {
+		t.Fatalf("deeper monsters should be stronger: %+v vs %+v", deep, top)
+	}
+}
+
+// stairsWorld is a small two-level dungeon built by hand.
+func stairsWorld() *World {
+	w := fovWorld(6,
+		"#######",
+		"#@ >  #",
+		"#     #",
+		"#######",
+	)
+	w.DownX, w.DownY = 3, 1
+	w.Tiles[1][3].Type = StairsDownTile
+	w.Rand = rand.New(rand.NewSource(1))
+	w.Player.Inv = []Item{{Name: "Фляга здоровья", Heal: 8}}
+	w.Player.Stats.HP = 17
+
+	tiles, _, _ := parseMap(
+		"#######",
+		"#<  g #",
+		"#  >  #",
+		"#######",
+	)
+	lower := &Level{Depth: 2, Tiles: tiles, UpX: 1, UpY: 1, DownX: 3, DownY: 2}
+	tiles[1][1].Type, tiles[2][3].Type = StairsUpTile, StairsDownTile
+	goblin := &Entity{Name: "Гоблин", X: 4, Y: 1, Alive: true, AIState: AIChase, Stats: Stats{HPMax: 8, HP: 8}}
+	tiles[1][4].Entity = goblin
+	lower.Entities = []*Entity{goblin}
+	w.Dungeon.Levels = append(w.Dungeon.Levels, lower)
+	return w
+}
+
+func TestTakeStairsKeepsPlayerState(t *testing.T) {
+	w := stairsWorld()
+	if _, err := w.TakeStairs(">"); err == nil {
+		t.Fatal("taking stairs away from them should fail")
+	}
+	w.MoveEntity(w.Player, 2, 1)
+	w.MoveEntity(w.Player, 3, 1)
+	if won, err := w.TakeStairs("<"); err == nil || won {
+		t.Fatal("there are no up stairs here")
+	}
+	if won, err := w.TakeStairs(">"); err != nil || won {
+		t.Fatalf("descend: won=%v err=%v", won, err)
+	}
+	if w.Depth != 2 || w.Player.X != 1 || w.Player.Y != 1 || w.Score.Depth != 2 {
+		t.Fatalf("should arrive on the up stairs of level 2, at depth %d (%d,%d)", w.Depth, w.Player.X, w.Player.Y)
+	}
+	if w.Player.Stats.HP != 17 || len(w.Player.Inv) != 1 {
+		t.Fatalf("HP and inventory must carry over: %+v", w.Player)
+	}
+	if w.Dungeon.Levels[0].Tiles[1][3].Entity != nil || len(w.Dungeon.Levels[0].Entities) != 0 {
+		t.Fatal("player should be gone from level 1")
+	}
+
+	if won, err := w.TakeStairs("g"); err != nil || won || w.Depth != 1 || w.Player.X != 3 {
+		t.Fatalf("ascend: won=%v err=%v depth=%d x=%d", won, err, w.Depth, w.Player.X)
+	}
+}
+
+func TestMonstersOnlyActOnPlayersLevel(t *testing.T) {
+	w := stairsWorld()
+	lower := w.Dungeon.Levels[1]
+	goblin := lower.Entities[0]
+	for i := 0; i < 3; i++ {
+		w.updateFOV()
+		w.monsterTurns()
+	}
+	if goblin.X != 4 || goblin.Y != 1 {
+		t.Fatalf("goblin on another level moved to (%d,%d)", goblin.X, goblin.Y)
+	}
+	w.MoveEntity(w.Player, 2, 1)
+	w.MoveEntity(w.Player, 3, 1)
+	w.TakeStairs(">")
+	w.updateFOV()
+	w.monsterTurns()
+	if goblin.X == 4 && goblin.Y == 1 {
+		t.Fatal("goblin should chase once the player is on its level")
+	}
+}
+
+func TestLastLevelExitWins(t *testing.T) {
+	w := stairsWorld()
+	w.MoveEntity(w.Player, 2, 1)
+	w.MoveEntity(w.Player, 3, 1)
+	w.TakeStairs(">")
+	w.MoveEntity(w.Player, 2, 1)
+	w.MoveEntity(w.Player, 2, 2)
+	w.MoveEntity(w.Player, 3, 2)
+	if won, err := w.TakeStairs(">"); err != nil || !won {
+		t.Fatalf("last level exit: won=%v err=%v at (%d,%d)", won, err, w.Player.X, w.Player.Y)
+	}
+}
+
+func TestGeneratorsProduceConnectedMaps(t *testing.T) {
+	for _, name := range []string{GenRooms, GenScatter} {
+		gen, err := generatorByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for seed := int64(0); seed < 200; seed++ {
+			w := newGame(seed, gen)
+			for _, lvl := range w.Dungeon.Levels {
+				if n := len(floorRegions(lvl.Tiles)); n != 1 {
+					t.Fatalf("%s seed %d level %d: %d floor regions", name, seed, lvl.Depth, n)
+				}
+				for x := 0; x < w.Width; x++ {
+					if lvl.Tiles[0][x].Type != WallTile || lvl.Tiles[w.Height-1][x].Type != WallTile {
+						t.Fatalf("%s seed %d level %d: border broken at x=%d", name, seed, lvl.Depth, x)
+					}
+				}
+				for y := 0; y < w.Height; y++ {
+					if lvl.Tiles[y][0].Type != WallTile || lvl.Tiles[y][w.Width-1].Type != WallTile {
+						t.Fatalf("%s seed %d level %d: border broken at y=%d", name, seed, lvl.Depth, y)
+					}
+				}
+			}
+			if w.Player == nil || w.Tiles[w.Player.Y][w.Player.X].Type == WallTile {
+				t.Fatalf("%s seed %d: player not placed on floor", name, seed)
+			}
+		}
+	}
+	if _, err := generatorByName("caves"); err == nil {
+		t.Fatal("unknown generator must be rejected")
+	}
+}
+
+func TestConnectFloorsJoinsPockets(t *testing.T) {
+	tiles, _, _ := parseMap(
+		"##########",
+		"#  #  ## #",
+		"####  ####",
+		"#  #     #",
+		"##########",
+	)
+	if n := len(floorRegions(tiles)); n != 4 {
+		t.Fatalf("fixture should have 4 pockets, has %d", n)
+	}
+	connectFloors(tiles)
+	if n := len(floorRegions(tiles)); n != 1 {
+		t.Fatalf("after connectFloors: %d regions", n)
+	}
+}
+
+// allLevelMaps renders every level of w (restoring the current one).
+func allLevelMaps(w *World) string {
+	cur := w.Level
+	defer func() { w.Level = cur }()
+	var b strings.Builder
+	for _, lvl := range w.Dungeon.Levels {
+		w.Level = lvl
+		b.WriteString(w.MapString())
+	}
+	return b.String()
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	w := newGame(11, defaultGenerator())
+	w.RenderCfg = RenderConfig{Glyphs: GlyphsASCII, Palette: PaletteNone, ShowHP: true}
+	for _, step := range [][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}, {1, 0}} {
+		w.MoveEntity(w.Player, w.Player.X+step[0], w.Player.Y+step[1])
+		w.updateFOV()
+		w.monsterTurns()
+		w.RemoveDeadEntities()
+	}
+	w.Player.Inv = append(w.Player.Inv, Item{Name: "Фляга здоровья", Heal: 8})
+	w.Score.Turns = 5
+	w.moveToLevel(w.Dungeon.Levels[1])
+	w.updateFOV()
+
+	path := filepath.Join(t.TempDir(), "run.json")
+	if err := w.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadWorld(path, defaultGenerator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.RenderCfg = w.RenderCfg
+
+	if got.MapString() != w.MapString() {
+		t.Fatalf("current level differs:\n%s\nvs\n%s", got.MapString(), w.MapString())
+	}
+	if allLevelMaps(got) != allLevelMaps(w) {
+		t.Fatal("some level differs after load")
+	}
+	// Fog hides items and monsters in the render: compare raw tiles too
+	for i, lvl := range w.Dungeon.Levels {
+		gl := got.Dungeon.Levels[i]
+		for y := range lvl.Tiles {
+			for x, tile := range lvl.Tiles[y] {
+				gt := gl.Tiles[y][x]
+				if gt.Type != tile.Type || (gt.Item == nil) != (tile.Item == nil) || (gt.Entity == nil) != (tile.Entity == nil) {
+					t.Fatalf("level %d tile (%d,%d) differs", i+1, x, y)
+				}
+				if gt.Entity != nil && (gt.Entity.X != x || gt.Entity.Y != y) {
+					t.Fatalf("level %d: entity link at (%d,%d) points to (%d,%d)", i+1, x, y, gt.Entity.X, gt.Entity.Y)
+				}
+			}
+		}
+	}
+	if got.Tiles[got.Player.Y][got.Player.X].Entity != got.Player || got.Depth != 2 {
+		t.Fatal("player must be relinked on the current level")
+	}
+	if got.Score != w.Score || len(got.Player.Inv) != 1 || got.Player.Stats != w.Player.Stats {
+		t.Fatalf("player state lost: %+v %+v", got.Score, got.Player)
+	}
+	for i := 0; i < 5; i++ {
+		if a, b := w.Rand.Int63(), got.Rand.Int63(); a != b {
+			t.Fatalf("RNG draw %d differs after load: %d vs %d", i, a, b)
+		}
+	}
+}
+
+func TestSaveRequiresSeededRNG(t *testing.T) {
+	w := stairsWorld()
+	if err := w.Save(filepath.Join(t.TempDir(), "run.json")); !errors.Is(err, errUnsavableRNG) {
+		t.Fatalf("want errUnsavableRNG, got %v", err)
+	}
+}
+
+func TestEquipChangesMeleeDamage(t *testing.T) {
+	w := fovWorld(5,
+		"#####",
+		"#@g #",
+		"#####",
+	)
+	player, goblin := w.Player, w.Entities[1]
+	goblin.Stats = Stats{HPMax: 50, HP: 50, Attack: 6, Defense: 2}
+	player.Stats = Stats{HPMax: 30, HP: 30, Attack: 5}
+	// The same seed before every exchange gives the same variance roll, so
+	// the damage depends only on the stats fed to computeMeleeDamage.
+	hit := func(attacker, defender *Entity) int {
+		w.Rand = rand.New(rand.NewSource(1))
+		hp := defender.Stats.HP
+		w.resolveMelee(attacker, defender)
+		return hp - defender.Stats.HP
+	}
+	want := func(att, def int) int {
+		dmg, _ := computeMeleeDamage(Stats{Attack: att}, Stats{Defense: def}, rand.New(rand.NewSource(1)))
+		return dmg
+	}
+
+	if got := hit(player, goblin); got != want(5, 2) {
+		t.Fatalf("bare-handed damage %d, want %d", got, want(5, 2))
+	}
+	if got := hit(goblin, player); got != want(6, 0) {
+		t.Fatalf("damage taken unarmored %d, want %d", got, want(6, 0))
+	}
+
+	player.Inv = []Item{swordItem, leatherArmorItem, {Name: "Фляга здоровья", Heal: 8}}
+	w.PlayerEquip(0)
+	w.PlayerEquip(0) // the armor moved to index 0
+	if player.Weapon == nil || player.Armor == nil || len(player.Inv) != 1 {
+		t.Fatalf("gear should leave the inventory: weapon %v armor %v inv %v", player.Weapon, player.Armor, player.Inv)
+	}
+
+	if got, w := hit(player, goblin), want(5+swordItem.AttackBonus, 2); got != w {
+		t.Fatalf("damage with sword %d, want %d", got, w)
+	}
+	if got, w := hit(goblin, player), want(6, leatherArmorItem.DefenseBonus); got != w {
+		t.Fatalf("damage taken in armor %d, want %d", got, w)
+	}
+}
+
+func TestEquipSwapsPreviousPiece(t *testing.T) {
+	w := fovWorld(5, "###", "#@#", "###")
+	axe := Item{Name: "Топор", Equip: WeaponSlot, AttackBonus: 5}
+	w.Player.Inv = []Item{swordItem, axe}
+	w.PlayerEquip(0)
+	w.PlayerEquip(0) // axe replaces the sword
+	if w.Player.Weapon.Name != "Топор" || len(w.Player.Inv) != 1 || w.Player.Inv[0].Name != swordItem.Name {
+		t.Fatalf("swap failed: weapon %+v inv %+v", w.Player.Weapon, w.Player.Inv)
+	}
+	if w.Player.EffectiveAttack() != w.Player.Stats.Attack+5 {
+		t.Fatalf("attack %d", w.Player.EffectiveAttack())
+	}
+
+	w.Player.Inv = append(w.Player.Inv, Item{Name: "Фляга здоровья", Heal: 8})
+	w.PlayerEquip(1)
+	w.PlayerUseItem(0)
+	if len(w.Player.Inv) != 2 || w.Player.Weapon.Name != "Топор" {
+		t.Fatal("potions cannot be equipped and gear cannot be used up")
+	}
+}
+
+func TestFirstLevelHasGear(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		w := newGame(seed, defaultGenerator())
+		found := map[string]bool{}
+		for _, row := range w.Dungeon.Levels[0].Tiles {
+			for _, tile := range row {
+				if tile.Item != nil && tile.Item.Equip != NoSlot {
+					found[tile.Item.Name] = true
+				}
+			}
+		}
+		if !found[swordItem.Name] || !found[leatherArmorItem.Name] {
+			t.Fatalf("seed %d: gear missing on level 1: %v", seed, found)
+		}
+	}
+}
+
+func TestCampaignNodeUnlocks(t *testing.T) {
+	c := NewCampaign(1)
+	want := map[string]bool{"town": true, "forest_cave": true, "crypt": false, "mountain_lair": false}
+	for id, open := range want {
+		if c.Available(id) != open {
+			t.Fatalf("new campaign: %s available=%v", id, !open)
+		}
+	}
+	if c.Available("nowhere") {
+		t.Fatal("unknown node must not be available")
+	}
+	c.Cleared["forest_cave"] = true
+	if !c.Available("crypt") || c.Available("mountain_lair") {
+		t.Fatal("clearing the cave should open the crypt only")
+	}
+	c.Cleared["crypt"] = true
+	if !c.Available("mountain_lair") || !c.Available("forest_cave") {
+		t.Fatal("the lair opens after the crypt; cleared nodes stay open")
+	}
+}
+
+// winDungeon clears the current dungeon as if the last exit was taken.
+func winDungeon(g *Game) {
+	g.World.Score.Gold = 20
+	g.endDungeon(true)
+}
+
+func TestCampaignStateTransitions(t *testing.T) {
+	c := NewCampaign(1)
+	g := NewCampaignGame(c, RenderConfig{Glyphs: GlyphsASCII, Palette: PaletteNone})
+	player := c.Player
+
+	if err := g.Travel("2"); !errors.Is(err, errNodeLocked) || g.State != StateOverworld {
+		t.Fatalf("locked node: err %v state %v", err, g.State)
+	}
+	if err := g.Travel("T"); err != nil || g.State != StateTown {
+		t.Fatalf("town: err %v state %v", err, g.State)
+	}
+	g.Handle("leave")
+	if g.State != StateOverworld {
+		t.Fatalf("leave town: state %v", g.State)
+	}
+
+	if err := g.Travel("1"); err != nil || g.State != StateDungeon {
+		t.Fatalf("cave: err %v state %v", err, g.State)
+	}
+	if g.World.Player != player || len(g.World.Dungeon.Levels) != 2 {
+		t.Fatal("the dungeon must use the campaign's player and the node's depth")
+	}
+	for _, e := range g.World.Entities {
+		if !e.IsPlayer && !e.Pet && e.Name != "Волк" {
+			t.Fatalf("themed dungeon spawned %q", e.Name)
+		}
+	}
+	winDungeon(g)
+	if g.State != StateOverworld || !c.Cleared["forest_cave"] || c.Gold != 20 || g.World != nil {
+		t.Fatalf("after clearing: state %v cleared %v gold %d", g.State, c.Cleared, c.Gold)
+	}
+
+	// Shopping with the banked gold
+	g.Travel("T")
+	g.Handle("buy 0")
+	if c.Gold != 10 || len(player.Inv) == 0 || player.Inv[len(player.Inv)-1].Heal == 0 {
+		t.Fatalf("buy: gold %d inv %+v", c.Gold, player.Inv)
+	}
+	if _, err := c.Buy(4); !errors.Is(err, errNotEnoughGold) {
+		t.Fatalf("too expensive: %v", err)
+	}
+	g.Handle("leave")
+
+	g.Travel("2")
+	winDungeon(g)
+	g.Travel("3")
+	winDungeon(g)
+	if g.State != StateOver || !g.Won {
+		t.Fatalf("final node should win the campaign: state %v won %v", g.State, g.Won)
+	}
+}
+
+func TestCampaignDeathEndsGame(t *testing.T) {
+	g := NewCampaignGame(NewCampaign(1), DefaultRenderConfig())
+	g.Travel("1")
+	g.endDungeon(false)
+	if g.State != StateOver || g.Won || g.Campaign.Cleared["forest_cave"] {
+		t.Fatalf("death: state %v won %v", g.State, g.Won)
+	}
+}
+
+func TestCampaignSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCampaign(3)
+	c.Cleared["forest_cave"], c.Gold = true, 42
+	c.Player.Inv = []Item{swordItem}
+	g := NewCampaignGame(c, DefaultRenderConfig())
+	g.Travel("T")
+
+	path := filepath.Join(dir, "town.json")
+	if err := g.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadGame(path, defaultGenerator(), DefaultRenderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != StateTown || got.Campaign.Gold != 42 || !got.Campaign.Cleared["forest_cave"] || len(got.Campaign.Player.Inv) != 1 {
+		t.Fatalf("town save: state %v campaign %+v", got.State, got.Campaign)
+	}
+
+	// Saved inside a dungeon: the campaign's player is the dungeon's player
+	got.Handle("leave")
+	if err := got.Travel("2"); err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "crypt.json")
+	if err := got.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadGame(path, defaultGenerator(), DefaultRenderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.State != StateDungeon || again.Campaign.Player != again.World.Player || again.Campaign.Node != "crypt" {
+		t.Fatalf("dungeon save: state %v node %s", again.State, again.Campaign.Node)
+	}
+	if again.World.MapString() != got.World.MapString() {
+		t.Fatal("dungeon differs after load")
+	}
+}
+
+// itemsOnMap lists every item on the level as "x,y label".
+func itemsOnMap(w *World) []string {
+	var out []string
+	for y, row := range w.Tiles {
+		for x, tile := range row {
+			if tile.Item != nil {
+				out = append(out, fmt.Sprintf("%d,%d %s", x, y, itemLabel(*tile.Item)))
+			}
+		}
+	}
+	return out
+}
+
+func killAll(w *World) {
+	for _, e := range w.Entities {
+		if !e.IsPlayer {
+			w.hurt(w.Player, e, e.Stats.HP)
+		}
+	}
+}
+
+func TestMonsterDropsWithFixedSeed(t *testing.T) {
+	w := fovWorld(5,
+		"#########",
+		"#@gggggg#",
+		"#       #",
+		"#########",
+	)
+	w.Rand = rand.New(rand.NewSource(7))
+	hungerOn = false // no corpses: only the loot lands
+	defer func() { hungerOn = true }()
+	killAll(w)
+	want := []string{"2,1 Фляга здоровья (heal:8)", "3,1 Золото (6)", "4,1 Золото (5)", "6,1 Золото (7)", "7,1 Золото (3)"}
+	if got := itemsOnMap(w); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("drops %q, want %q", got, want)
+	}
+
+	// Gold drops go to the score when picked up.
+	w.MoveEntity(w.Player, 2, 1)
+	w.MoveEntity(w.Player, 3, 1)
+	w.PlayerPickUp()
+	if w.Score.Gold != 6 || w.Tiles[1][3].Item != nil {
+		t.Fatalf("gold %d after picking up the pile", w.Score.Gold)
+	}
+}
+
+func TestDropAvoidsOccupiedTile(t *testing.T) {
+	w := fovWorld(5,
+		"#####",
+		"#@g #",
+		"#   #",
+		"#####",
+	)
+	w.Rand = rand.New(rand.NewSource(7)) // the first roll is a flask
+	w.Tiles[1][2].Item = &Item{Name: "Камень"}
+	hungerOn = false
+	defer func() { hungerOn = true }()
+	killAll(w)
+	want := []string{"2,1 Камень", "3,1 Фляга здоровья (heal:8)"}
+	if got := itemsOnMap(w); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("items %q, want %q", got, want)
+	}
+}
+
+func TestXPCurve(t *testing.T) {
+	for level, want := range map[int]int{1: 20, 2: 60, 3: 120, 4: 200} {
+		if got := xpForLevel(level); got != want {
+			t.Errorf("xpForLevel(%d) = %d, want %d", level, got, want)
+		}
+	}
+	if got := xpReward(&Entity{Stats: monsterStats(1)}); got != 7 {
+		t.Errorf("depth 1 goblin worth %d XP, want 7", got)
+	}
+	if got := xpReward(&Entity{Stats: monsterStats(3)}); got != 17 {
+		t.Errorf("depth 3 goblin worth %d XP, want 17", got)
+	}
+	// Old saves have no level: they count as level 1.
+	if (&Entity{}).CharLevel() != 1 {
+		t.Error("zero level should read as 1")
+	}
+}
+
+func TestLevelUpAppliesStats(t *testing.T) {
+	p := newPlayer()
+	p.Stats.HP = 10
+	gainXP(p, 19)
+	if p.Level != 1 || p.Stats.HPMax != 30 {
+		t.Fatalf("leveled up too early: %+v", p)
+	}
+	gainXP(p, 1)
+	want := Stats{HPMax: 35, HP: 15, Attack: 6, Defense: 3, Speed: 5}
+	if p.Level != 2 || p.Stats != want {
+		t.Fatalf("after level 2: level %d stats %+v, want %+v", p.Level, p.Stats, want)
+	}
+	// One big kill can skip a level; the heal never overflows HPMax.
+	p.Stats.HP = p.Stats.HPMax
+	gainXP(p, 100)
+	want = Stats{HPMax: 45, HP: 45, Attack: 8, Defense: 5, Speed: 5}
+	if p.Level != 4 || p.XP != 120 || p.Stats != want {
+		t.Fatalf("after 120 XP: level %d xp %d stats %+v, want %+v", p.Level, p.XP, p.Stats, want)
+	}
+}
+
+func TestKillAwardsXPAndMonstersScale(t *testing.T) {
+	w := fovWorld(5,
+		"#####",
+		"#@g #",
+		"#####",
+	)
+	goblin := w.Entities[1]
+	goblin.Stats = monsterStats(1)
+	goblin.Stats.HP = 1
+	w.resolveMelee(w.Player, goblin)
+	if w.Player.XP != 7 {
+		t.Fatalf("XP after kill %d, want 7", w.Player.XP)
+	}
+
+	weak := newGame(42, defaultGenerator())
+	player := newPlayer()
+	gainXP(player, xpForLevel(4)) // level 5
+	strong := buildDungeon(42, defaultGenerator(), Theme{}, dungeonLevels, player)
+	if weak.monsterTier() != 1 || strong.monsterTier() != 3 {
+		t.Fatalf("tiers %d and %d", weak.monsterTier(), strong.monsterTier())
+	}
+	for _, e := range strong.Entities {
+		kind, _ := bestiary.lookup(e.Name)
+		if !e.IsPlayer && !e.Pet && e.Stats != kind.spawn(3).Stats {
+			t.Fatalf("level 5 player's first floor should have tier 3 monsters, got %s %+v", e.Name, e.Stats)
+		}
+	}
+}
+
+func TestKeyInputCommands(t *testing.T) {
+	type key struct {
+		ch  rune
+		key termbox.Key
+	}
+	cases := []struct {
+		state GameState
+		keys  []key
+		want  []string
+	}{
+		{StateDungeon, []key{{'w', 0}, {0, termbox.KeyArrowLeft}, {'>', 0}}, []string{"w", "a", ">"}},
+		{StateDungeon, []key{{'u', 0}, {'2', 0}}, []string{"", "u 2"}},
+		{StateDungeon, []key{{'e', 0}, {'x', 0}, {'p', 0}}, []string{"", "", "p"}},
+		{StateDungeon, []key{{':', 0}, {'s', 0}, {'e', 0}, {0, termbox.KeySpace}, {'x', 0}, {0, termbox.KeyBackspace2}, {'f', 0}, {0, termbox.KeyEnter}},
+			[]string{"", "", "", "", "", "", "", "se f"}},
+		{StateDungeon, []key{{':', 0}, {'q', 0}, {0, termbox.KeyEsc}, {'z', 0}}, []string{"", "", "", ""}},
+		{StateDungeon, []key{{'l', 0}, {0, termbox.KeyArrowUp}, {'l', 0}, {'d', 0}, {'l', 0}, {'7', 0}, {'.', 0}, {'x', 0}},
+			[]string{"", "l w", "", "l d", "", "", ".", "x"}},
+		{StateDungeon, []key{{'t', 0}, {'1', 0}, {0, termbox.KeyArrowRight}, {'t', 0}, {'1', 0}, {'x', 0}},
+			[]string{"", "", "t 1 d", "", "", ""}},
+		{StateTown, []key{{'b', 0}, {'0', 0}, {'r', 0}, {'l', 0}}, []string{"", "buy 0", "rest", "leave"}},
+		{StateOverworld, []key{{'T', 0}, {0, termbox.KeyEsc}}, []string{"T", "q"}},
+	}
+	for i, c := range cases {
+		var in keyInput
+		for j, k := range c.keys {
+			if got := in.Key(c.state, k.ch, k.key); got != c.want[j] {
+				t.Errorf("case %d key %d: got %q, want %q", i, j, got, c.want[j])
+			}
+		}
+	}
+}
+
+func TestSGRAttr(t *testing.T) {
+	cases := map[string]termbox.Attribute{
+		"":         0,
+		"33":       termbox.ColorYellow,
+		"1;31":     termbox.ColorRed | termbox.AttrBold,
+		"2;90":     termbox.Attribute(9) | termbox.AttrDim,
+		"38;5;208": termbox.Attribute(209),
+	}
+	for code, want := range cases {
+		if got := sgrAttr(code); got != want {
+			t.Errorf("sgrAttr(%q) = %v, want %v", code, got, want)
+		}
+	}
+	// Every palette color, plain or dimmed, must map to a color.
+	for name, p := range palettes {
+		for kind, color := range p {
+			for _, code := range []string{color, dimSGR + ";" + color} {
+				if sgrAttr(code)&0x1ff == 0 {
+					t.Errorf("palette %s kind %v: %q has no color", name, kind, code)
+				}
+			}
+		}
+	}
+}
+
+func TestMessageLogKeepsTail(t *testing.T) {
+	l := &MessageLog{}
+	for i := 0; i < messageLogSize+5; i++ {
+		l.Add(fmt.Sprint(i))
+	}
+	l.Add("a\nb")
+	if len(l.lines) != messageLogSize {
+		t.Fatalf("log holds %d lines", len(l.lines))
+	}
+	if got := strings.Join(l.Last(3), ","); got != fmt.Sprintf("%d,a,b", messageLogSize+4) {
+		t.Fatalf("tail %q", got)
+	}
+}
+
+// oldMonsterTurns is monsterTurns as it was, with a BFS per monster; the
+// reference the flow map has to match.
+func oldMonsterTurns(w *World) {
+	for _, entity := range w.Entities {
+		if entity.IsPlayer || !entity.Alive || entity.AIState != AIChase {
+			continue
+		}
+		if abs(w.Player.X-entity.X)+abs(w.Player.Y-entity.Y) == 1 {
+			w.resolveMelee(entity, w.Player)
+		} else if dx, dy := w.BFSStepTowards(entity, w.Player); dx != 0 || dy != 0 {
+			w.MoveEntity(entity, entity.X+dx, entity.Y+dy)
+		}
+	}
+}
+
+// The player walks to the down stairs with every monster chasing; the flow
+// map must move everyone exactly as the per-monster BFS did.
+func TestFlowMapGoldenPath(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		flow, ref := newGame(seed, defaultGenerator()), newGame(seed, defaultGenerator())
+		for _, w := range []*World{flow, ref} {
+			w.Player.Stats.HPMax, w.Player.Stats.HP = 1000, 1000
+			// Monsters only: no dog to recruit on the way.
+			for _, e := range w.Entities {
+				if e.Stray {
+					w.removeEntity(e)
+					break
+				}
+			}
+			// Closed doors would stop the chase (monsters don't open them).
+			for _, row := range w.Tiles {
+				for _, tile := range row {
+					if tile.Type == DoorClosedTile {
+						tile.Type = DoorOpenTile
+					}
+				}
+			}
+		}
+		moved := 0
+		for turn := 0; turn < 40; turn++ {
+			// Everyone chases the whole way, seen or not
+			for _, w := range []*World{flow, ref} {
+				for _, e := range w.Entities {
+					e.AIState, e.LostTurns = AIChase, 0
+				}
+			}
+			dx, dy := ref.BFSStepTowards(ref.Player, &Entity{X: ref.DownX, Y: ref.DownY})
+			flow.MoveEntity(flow.Player, flow.Player.X+dx, flow.Player.Y+dy)
+			ref.MoveEntity(ref.Player, ref.Player.X+dx, ref.Player.Y+dy)
+
+			before := make([][2]int, len(flow.Entities))
+			for i, e := range flow.Entities {
+				before[i] = [2]int{e.X, e.Y}
+			}
+			flow.monsterTurns()
+			oldMonsterTurns(ref)
+
+			if len(flow.Entities) != len(ref.Entities) {
+				t.Fatalf("seed %d turn %d: %d entities vs %d", seed, turn, len(flow.Entities), len(ref.Entities))
+			}
+			for i, e := range flow.Entities {
+				r := ref.Entities[i]
+				if e.X != r.X || e.Y != r.Y || e.Stats.HP != r.Stats.HP {
+					t.Fatalf("seed %d turn %d: %s at (%d,%d) hp %d, reference (%d,%d) hp %d",
+						seed, turn, e.Name, e.X, e.Y, e.Stats.HP, r.X, r.Y, r.Stats.HP)
+				}
+				if i < len(before) && before[i] != [2]int{e.X, e.Y} {
+					moved++
+				}
+			}
+		}
+		if moved == 0 {
+			t.Fatalf("seed %d: no monster ever moved, the test proves nothing", seed)
+		}
+	}
+}
+
+func TestDistanceMapUnreachable(t *testing.T) {
+	w := fovWorld(5,
+		"#######",
+		"#@ # g#",
+		"#######",
+	)
+	flow := w.distanceMapFrom(w.Player.X, w.Player.Y)
+	if flow.At(2, 1) != 1 || flow.At(5, 1) != unreachable || flow.At(3, 1) != unreachable {
+		t.Fatalf("distances %d %d %d", flow.At(2, 1), flow.At(5, 1), flow.At(3, 1))
+	}
+	goblin := w.Entities[1]
+	goblin.AIState = AIChase
+	w.monsterTurns()
+	if goblin.X != 5 || goblin.Y != 1 {
+		t.Fatalf("a monster with no path should wait, moved to (%d,%d)", goblin.X, goblin.Y)
+	}
+}
+
+// chaseBenchWorld is a 100x50 level with 200 monsters that have all spotted
+// the player.
+func chaseBenchWorld(b *testing.B) *World {
+	w := NewWorld(100, 50, rand.New(rand.NewSource(1)), defaultGenerator())
+	x, y := w.randomFloor()
+	w.PlaceEntity(&Entity{Name: "Игрок", IsPlayer: true}, x, y)
+	for tries := 0; len(w.Entities) < 201 && tries < 100000; tries++ {
+		if x, y := w.randomFloor(); w.Tiles[y][x].Entity == nil {
+			w.PlaceEntity(&Entity{Name: "Гоблин", AIState: AIChase}, x, y)
+		}
+	}
+	if len(w.Entities) < 201 {
+		b.Fatalf("only %d monsters fit", len(w.Entities)-1)
+	}
+	return w
+}
+
+func BenchmarkChasePerMonsterBFS(b *testing.B) {
+	w := chaseBenchWorld(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range w.Entities {
+			if !e.IsPlayer {
+				w.BFSStepTowards(e, w.Player)
+			}
+		}
+	}
+}
+
+func BenchmarkChaseFlowMap(b *testing.B) {
+	w := chaseBenchWorld(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		flow := w.distanceMapFrom(w.Player.X, w.Player.Y)
+		for _, e := range w.Entities {
+			if !e.IsPlayer {
+				flow.StepFrom(e.X, e.Y)
+			}
+		}
+	}
+}
+
+func TestSameSeedRendersIdentically(t *testing.T) {
+	cfg := RenderConfig{Glyphs: GlyphsASCII, Palette: PaletteNone}
+	render := func(w *World) string {
+		var b strings.Builder
+		for _, lvl := range w.Dungeon.Levels {
+			w.Level = lvl
+			b.WriteString(w.MapString())
+		}
+		w.Level = w.Dungeon.Levels[0]
+		b.WriteString(w.StatusLine())
+		return b.String()
+	}
+	for _, gen := range []string{GenRooms, GenScatter} {
+		g, _ := generatorByName(gen)
+		a, b := newGame(12345, g), newGame(12345, g)
+		a.RenderCfg, b.RenderCfg = cfg, cfg
+		if ra, rb := render(a), render(b); ra != rb {
+			t.Fatalf("%s: same seed rendered differently:\n%s\n---\n%s", gen, ra, rb)
+		}
+		if other := newGame(54321, g); render(other) == render(a) {
+			t.Fatalf("%s: different seeds rendered the same world", gen)
+		}
+	}
+
+	g := NewSingleGame(newGame(777, defaultGenerator()), cfg)
+	if seed, ok := g.seed(); !ok || seed != 777 {
+		t.Fatalf("single game seed %d %v", seed, ok)
+	}
+	if seed, ok := NewCampaignGame(NewCampaign(99), cfg).seed(); !ok || seed != 99 {
+		t.Fatalf("campaign seed %d %v", seed, ok)
+	}
+}
+
+func TestOpeningDoorSpendsTheMove(t *testing.T) {
+	w := fovWorld(5,
+		"#####",
+		"#@+ #",
+		"#####",
+	)
+	if !w.MoveEntity(w.Player, 2, 1) {
+		t.Fatal("bumping a closed door should open it")
+	}
+	if w.Player.X != 1 || w.Tiles[1][2].Type != DoorOpenTile {
+		t.Fatalf("player at %d, door %v", w.Player.X, w.Tiles[1][2].Type)
+	}
+	if !w.MoveEntity(w.Player, 2, 1) || w.Player.X != 2 {
+		t.Fatal("an open door should be walkable")
+	}
+}
+
+func TestLockedDoorNeedsKey(t *testing.T) {
+	w := fovWorld(5,
+		"#####",
+		"#@= #",
+		"#####",
+	)
+	if w.MoveEntity(w.Player, 2, 1) || w.Tiles[1][2].Type != DoorLockedTile {
+		t.Fatal("a locked door shouldn't open without a key")
+	}
+	w.Player.Inv = []Item{{Name: "Зелье", Heal: 5}, keyItem()}
+	if !w.MoveEntity(w.Player, 2, 1) || w.Tiles[1][2].Type != DoorOpenTile {
+		t.Fatal("the key should unlock the door")
+	}
+	if keyIndex(w.Player) >= 0 || len(w.Player.Inv) != 1 {
+		t.Fatalf("the key should be used up, inventory %v", w.Player.Inv)
+	}
+}
+
+func TestMonstersDontOpenDoors(t *testing.T) {
+	w := fovWorld(5,
+		"#######",
+		"#@ + g#",
+		"#######",
+	)
+	goblin := w.Entities[1]
+	goblin.AIState = AIChase
+	if flow := w.distanceMapFrom(w.Player.X, w.Player.Y); flow.At(5, 1) != unreachable {
+		t.Fatal("a closed door should cut the flow map")
+	}
+	w.monsterTurns()
+	if goblin.X != 5 || w.Tiles[1][3].Type != DoorClosedTile {
+		t.Fatalf("goblin at %d, door %v", goblin.X, w.Tiles[1][3].Type)
+	}
+
+	w.Tiles[1][3].Type = DoorOpenTile
+	w.monsterTurns()
+	w.monsterTurns()
+	if goblin.X != 3 {
+		t.Fatalf("goblin should walk through the open door, at %d", goblin.X)
+	}
+}
+
+func TestClosedDoorBlocksSight(t *testing.T) {
+	w := fovWorld(10,
+		"#######",
+		"#@ + g#",
+		"#######",
+	)
+	w.updateFOV()
+	if w.visibility(3, 1) != Visible || w.visibility(5, 1) != Unseen {
+		t.Fatal("the door should be seen and hide what's behind it")
+	}
+	w.Tiles[1][3].Type = DoorOpenTile
+	w.updateFOV()
+	if w.visibility(5, 1) != Visible {
+		t.Fatal("an open door shouldn't block sight")
+	}
+}
+
+// TestTreasureRoomKeyOutside checks generated treasure rooms: the key can be
+// reached without passing the locked door, the treasure gold can't.
+func TestTreasureRoomKeyOutside(t *testing.T) {
+	rooms := 0
+	for seed := int64(1); seed <= 30; seed++ {
+		w := newGame(seed, defaultGenerator())
+		for _, lvl := range w.Dungeon.Levels {
+			var doors, keys, treasure []*Tile
+			for _, row := range lvl.Tiles {
+				for _, tile := range row {
+					switch {
+					case tile.Type == DoorClosedTile:
+						tile.Type = DoorOpenTile // the player can open these
+					case tile.Type == DoorLockedTile:
+						doors = append(doors, tile)
+					case tile.Item != nil && tile.Item.Key:
+						keys = append(keys, tile)
+					case tile.Item != nil && tile.Item.Gold >= 25:
+						treasure = append(treasure, tile)
+					}
+				}
+			}
+			if len(doors) == 0 {
+				continue
+			}
+			rooms++
+			if len(doors) != 1 || len(keys) != 1 || len(treasure) != 1 {
+				t.Fatalf("seed %d depth %d: %d locked doors, %d keys, %d treasure piles",
+					seed, lvl.Depth, len(doors), len(keys), len(treasure))
+			}
+			w.Level, w.Width, w.Height = lvl, len(lvl.Tiles[0]), len(lvl.Tiles)
+			flow := w.distanceMapFrom(keys[0].X, keys[0].Y)
+			if flow.At(treasure[0].X, treasure[0].Y) != unreachable {
+				t.Fatalf("seed %d depth %d: treasure reachable without the door", seed, lvl.Depth)
+			}
+			outside := false
+			for _, d := range stepDeltas {
+				outside = outside || flow.At(doors[0].X+d[0], doors[0].Y+d[1]) != unreachable
+			}
+			if !outside {
+				t.Fatalf("seed %d depth %d: key can't reach the door", seed, lvl.Depth)
+			}
+		}
+	}
+	if rooms == 0 {
+		t.Fatal("no treasure rooms in 30 seeds")
+	}
+}
+
+func TestProfileMilestones(t *testing.T) {
+	p := newProfile(filepath.Join(t.TempDir(), "profile.json"))
+	if got := p.CheckUnlocks(); len(got) != 0 {
+		t.Fatalf("fresh profile unlocked %v", got)
+	}
+	p.RecordDungeon(Score{Depth: 3, Kills: 10})
+	p.RecordDungeon(Score{Depth: 1, Kills: 15})
+	got := p.CheckUnlocks()
+	if len(got) != 2 || got[0].ID != "class_rogue" || got[1].ID != "class_knight" {
+		t.Fatalf("unlocked %v, want the rogue and the knight", got)
+	}
+	if p.Stats.Deepest != 3 || p.Stats.Kills != 25 {
+		t.Fatalf("stats %+v", p.Stats)
+	}
+	if again := p.CheckUnlocks(); len(again) != 0 {
+		t.Fatalf("unlocked twice: %v", again)
+	}
+
+	sel := newSelection(p, false, nil)
+	if len(sel.Classes) != 3 || len(sel.Items) != 0 || len(sel.Themes) != 0 || sel.trivial() {
+		t.Fatalf("campaign selection %d classes, %d items, %d themes", len(sel.Classes), len(sel.Items), len(sel.Themes))
+	}
+}
+
+func TestBankCappedPerRun(t *testing.T) {
+	p := newProfile(filepath.Join(t.TempDir(), "profile.json"))
+	g := NewCampaignGame(NewCampaign(1), DefaultRenderConfig())
+	g.Profile, g.State, g.Campaign.Gold = p, StateTown, 150
+	g.Handle("bank 80")
+	g.Handle("bank 50")
+	if p.Vault != bankCapPerRun || g.Campaign.Gold != 150-bankCapPerRun || g.Campaign.Banked != bankCapPerRun {
+		t.Fatalf("vault %d, gold %d, banked %d", p.Vault, g.Campaign.Gold, g.Campaign.Banked)
+	}
+	if !p.Has("item_sword") {
+		t.Fatal("banking 100 gold should unlock the sword")
+	}
+	saved, err := LoadProfile(p.path)
+	if err != nil || saved.Vault != bankCapPerRun {
+		t.Fatalf("deposit not written: %v, vault %d", err, saved.Vault)
+	}
+}
+
+func TestRunsRecordedInProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json")
+	p := newProfile(path)
+	for run := 0; run < 3; run++ {
+		g := NewSingleGame(newGame(int64(run+1), defaultGenerator()), DefaultRenderConfig())
+		g.Profile = p
+		g.World.Score.Kills = 2
+		g.Handle("q")
+	}
+	saved, err := LoadProfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Stats.Runs != 3 || saved.Stats.Kills != 6 || saved.Version != profileVersion {
+		t.Fatalf("saved %+v", saved)
+	}
+	if !saved.Has("item_flask") {
+		t.Fatalf("3 runs should unlock the flask, have %v", saved.Unlocked)
+	}
+
+	var l Loadout
+	sel := newSelection(saved, true, func(g *Game, got Loadout) { l = got })
+	g := NewSelectGame(saved, sel, DefaultRenderConfig())
+	for _, cmd := range []string{"t 0", "c 5", "start"} {
+		g.Handle(cmd)
+	}
+	if l.Item == nil || l.Item.Heal == 0 || l.Class.ID != "warrior" {
+		t.Fatalf("loadout %+v", l)
+	}
+	if pl := l.player(4); len(pl.Inv) != 1 || pl.SightRadius != 4 {
+		t.Fatalf("player inv %v sight %d", pl.Inv, pl.SightRadius)
+	}
+}
+
+func TestProfileMigration(t *testing.T) {
+	// A made-up version 2 that renamed "bank" to "vault".
+	steps := map[int]profileMigration{
+		1: func(doc map[string]any) error {
+			doc["vault"] = doc["bank"]
+			delete(doc, "bank")
+			return nil
+		},
+	}
+	p, err := decodeProfile([]byte(`{"version":1,"bank":5,"stats":{"runs":2}}`), 2, steps)
+	if err != nil || p.Vault != 5 || p.Stats.Runs != 2 || p.Version != 2 {
+		t.Fatalf("migrated: %v, %+v", err, p)
+	}
+	if _, err := decodeProfile([]byte(`{"version":1}`), 3, steps); err == nil {
+		t.Fatal("a missing step should fail")
+	}
+	if _, err := decodeProfile([]byte(`{"version":3}`), 2, steps); err == nil {
+		t.Fatal("a newer profile should be refused")
+	}
+	if p, err := decodeProfile([]byte(`{"version":1,"vault":7}`), profileVersion, profileMigrations); err != nil || p.Vault != 7 {
+		t.Fatalf("current version: %v, %+v", err, p)
+	}
+}
+
+func TestCorruptProfileMovedAside(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profile.json")
+	for _, bad := range []string{`{"version":1,"stats":`, `{"stats":{"runs":3}}`, `{"version":99}`, `{"version":1,"vault":-5}`} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := LoadProfile(path)
+		if !errors.Is(err, errProfileReset) || p == nil || p.Stats.Runs != 0 || p.Version != profileVersion {
+			t.Fatalf("%s: %v, %+v", bad, err, p)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s: the bad profile should be moved aside", bad)
+		}
+		backups, _ := filepath.Glob(path + ".bad-*")
+		if len(backups) == 0 {
+			t.Fatalf("%s: no backup", bad)
+		}
+		kept, _ := os.ReadFile(backups[0])
+		if string(kept) != bad {
+			t.Fatalf("backup holds %q", kept)
+		}
+		for _, b := range backups {
+			os.Remove(b)
+		}
+		if err := p.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadProfile(path); err != nil {
+			t.Fatalf("fresh profile should load: %v", err)
+		}
+		os.Remove(path)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, ".profile-*")); len(left) != 0 {
+		t.Fatalf("temp files left behind: %v", left)
+	}
+}
+
+func TestDescribeTile(t *testing.T) {
+	goblin := &Entity{Name: "Гоблин", Stats: Stats{HPMax: 8, HP: 5}}
+	potion := &Item{Name: "Зелье", Heal: 5}
+	cases := []struct {
+		tile *Tile
+		want string
+	}{
+		{&Tile{Type: FloorTile, Entity: goblin, Item: potion}, "Гоблин (HP 5/8)"},
+		{&Tile{Type: FloorTile, Entity: &Entity{IsPlayer: true}}, "Это вы"},
+		{&Tile{Type: StairsDownTile, Item: potion}, "Зелье (heal:5)"},
+		{&Tile{Type: FloorTile, Item: &swordItem}, "Меч (атака +3)"},
+		{&Tile{Type: WallTile}, "Стена"},
+		{&Tile{Type: FloorTile}, "Пол"},
+		{&Tile{Type: StairsUpTile}, "Лестница вверх"},
+		{&Tile{Type: DoorLockedTile}, "Запертая дверь"},
+	}
+	for _, c := range cases {
+		if got := describeTile(c.tile); got != c.want {
+			t.Errorf("describeTile = %q, want %q", got, c.want)
+		}
+	}
+	for kind := CellKind(0); kind < numCellKinds; kind++ {
+		if kind != KindPlayer && kind != KindMonster && kind != KindItem && kind != KindItemRare && kind != KindItemEpic && kind != KindCorpse && kind != KindPet && terrainNames[kind] == "" {
+			t.Errorf("terrain kind %d has no name", kind)
+		}
+	}
+}
+
+func TestWaitAndLook(t *testing.T) {
+	w := fovWorld(5,
+		"######",
+		"#@  g#",
+		"######",
+	)
+	g := NewSingleGame(w, DefaultRenderConfig())
+	goblin := w.Entities[1]
+	goblin.AIState = AIChase
+
+	g.Handle("l d")
+	if got := messages.Last(1)[0]; got != "Пол" || w.Score.Turns != 0 || goblin.X != 4 {
+		t.Fatalf("look: %q, turns %d, goblin at %d", got, w.Score.Turns, goblin.X)
+	}
+	g.Handle("x")
+	g.Handle(".")
+	if w.Player.X != 1 || w.Score.Turns != 2 || goblin.X != 2 {
+		t.Fatalf("after waiting: player %d, turns %d, goblin %d", w.Player.X, w.Score.Turns, goblin.X)
+	}
+	g.Handle("l d")
+	if got := messages.Last(1)[0]; got != "Гоблин (HP 8/8)" {
+		t.Fatalf("look at goblin: %q", got)
+	}
+	g.Handle("l q")
+	if got := messages.Last(1)[0]; got != "l w|a|s|d" {
+		t.Fatalf("bad direction: %q", got)
+	}
+}
+
+func TestBestiarySpawnsByDepth(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	counts := map[int]map[string]int{1: {}, 5: {}}
+	for depth := range counts {
+		for i := 0; i < 2000; i++ {
+			counts[depth][defaultBestiary.pick(r, depth).Name]++
+		}
+	}
+	if counts[1]["Скелет"] != 0 || counts[1]["Тролль"] != 0 {
+		t.Fatalf("deep monsters on depth 1: %v", counts[1])
+	}
+	if counts[1]["Гоблин"] <= counts[1]["Волк"] {
+		t.Fatalf("goblins weigh more than wolves: %v", counts[1])
+	}
+	if counts[5]["Тролль"] == 0 || counts[5]["Скелет"] == 0 {
+		t.Fatalf("depth 5 should spawn every kind: %v", counts[5])
+	}
+
+	// A themed dungeon spawns only its monster, with its own glyph.
+	w := buildDungeon(3, defaultGenerator(), Theme{Monster: "Тролль"}, 1, newPlayer())
+	for _, e := range w.Entities {
+		if !e.IsPlayer && !e.Pet && (e.Name != "Тролль" || e.Glyph != 'T') {
+			t.Fatalf("troll lair spawned %s %q", e.Name, e.Glyph)
+		}
+	}
+}
+
+func TestMonsterGlyphRendered(t *testing.T) {
+	w := fovWorld(5, "#####", "#@g #", "#####")
+	w.Entities[1].Glyph = 'w'
+	for _, glyphs := range []string{GlyphsASCII, GlyphsUnicode} {
+		ch, _ := tileStyle(RenderConfig{Glyphs: glyphs, Palette: PaletteNone}, w.Tiles[1][2])
+		if ch != 'w' {
+			t.Fatalf("%s: monster drawn as %q", glyphs, ch)
+		}
+	}
+	w.Entities[1].Glyph = 0 // saved before the bestiary
+	if ch, _ := tileStyle(DefaultRenderConfig(), w.Tiles[1][2]); ch != 'g' {
+		t.Fatalf("fallback glyph %q", ch)
+	}
+}
+
+func TestLoadBestiaryErrors(t *testing.T) {
+	dir := t.TempDir()
+	load := func(body string) error {
+		path := filepath.Join(dir, "bestiary.json")
+		os.WriteFile(path, []byte(body), 0o644)
+		_, err := LoadBestiary(path)
+		return err
+	}
+	good := `{"name": "Гоблин", "glyph": "g", "stats": {"hp_max": 8, "attack": 3}, "weight": 1},
+		{"name": "Волк", "glyph": "w", "stats": {"hp_max": 6, "attack": 4}, "weight": 1, "min_depth": 2},
+		{"name": "Скелет", "glyph": "s", "stats": {"hp_max": 6, "attack": 4}, "weight": 1, "min_depth": 2},
+		{"name": "Тролль", "glyph": "T", "stats": {"hp_max": 6, "attack": 4}, "weight": 1, "min_depth": 2}`
+	if err := load("[" + good + "]"); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		`{"name": "Орк", "glyph": "oo", "stats": {"hp_max": 5}, "weight": 1}`:                                                        `entry 4 ("Орк"): glyph "oo"`,
+		`{"name": "Орк", "glyph": "@", "stats": {"hp_max": 5}, "weight": 1}`:                                                         `entry 4 ("Орк"): glyph "@" is already used`,
+		`{"name": "Орк", "glyph": "o", "stats": {"hp_max": 5}, "weight": 1, "ai": "smart"}`:                                          `entry 4 ("Орк"): unknown ai "smart"`,
+		`{"name": "Орк", "glyph": "o", "stats": {"hp_max": 0}, "weight": 1}`:                                                         `entry 4 ("Орк"): stats.hp_max`,
+		`{"name": "Орк", "glyph": "o", "stats": {"hp_max": 5}, "weight": 1, "speeed": 3}`:                                            `entry 4 ("Орк"): json: unknown field "speeed"`,
+		`{"name": "Гоблин", "glyph": "o", "stats": {"hp_max": 5}, "weight": 1}`:                                                      `entry 4 ("Гоблин"): duplicate name`,
+		`{"name": "Орк", "glyph": "o", "stats": {"hp_max": 5}, "weight": 1, "drops": [{"weight": 1, "gold_min": 9, "gold_max": 2}]}`: `entry 4 ("Орк"): drop 0`,
+	}
+	for entry, want := range cases {
+		if err := load("[" + good + ",\n" + entry + "]"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: error %v, want %q", entry, err, want)
+		}
+	}
+	if err := load(`[{"name": "Гоблин", "glyph": "g", "stats": {"hp_max": 8}, "weight": 1}]`); err == nil || !strings.Contains(err.Error(), `"Волк"`) {
+		t.Fatalf("campaign monster missing: %v", err)
+	}
+	if err := load(`[{"name": "Гоблин",`); err == nil {
+		t.Fatal("truncated file accepted")
+	}
+}
+
+// actionsPer100Ticks runs the clock for 100 ticks and counts actions; the
+// player acts whenever their energy allows, like at the prompt.
+func actionsPer100Ticks(playerSpeed, monsterSpeed int) (player, monster int) {
+	w := fovWorld(5, "######", "#@  g#", "######")
+	w.Player.Stats.Speed, w.Entities[1].Stats.Speed = playerSpeed, monsterSpeed
+	for i := 0; i < 100; i++ {
+		monster += len(w.tick())
+		for w.Player.Energy >= actThreshold {
+			w.Player.Energy -= actThreshold
+			player++
+		}
+	}
+	return player, monster
+}
+
+func TestSpeedActionRatio(t *testing.T) {
+	cases := []struct{ player, monster, wantPlayer, wantMonster int }{
+		{6, 3, 50, 25},
+		{3, 6, 25, 50},
+		{4, 4, 33, 33},
+		{12, 2, 100, 16},
+		{5, 3, 41, 25},
+	}
+	for _, c := range cases {
+		p, m := actionsPer100Ticks(c.player, c.monster)
+		if p != c.wantPlayer || m != c.wantMonster {
+			t.Errorf("speeds %d/%d: %d and %d actions, want %d and %d", c.player, c.monster, p, m, c.wantPlayer, c.wantMonster)
+		}
+	}
+}
+
+// A fast player outruns a slow goblin: every command is one player action,
+// and the goblin only moves on every other one.
+func TestSchedulerDrivesPlayerTurns(t *testing.T) {
+	w := fovWorld(5,
+		"##########",
+		"#@      g#",
+		"##########",
+	)
+	g := NewSingleGame(w, DefaultRenderConfig())
+	goblin := w.Entities[1]
+	goblin.AIState = AIChase
+	w.Player.Stats.Speed, goblin.Stats.Speed = 6, 3
+
+	for i := 1; i <= 6; i++ {
+		g.Handle("x")
+		if want := 8 - i/2; goblin.X != want {
+			t.Fatalf("after %d waits goblin at %d, want %d", i, goblin.X, want)
+		}
+	}
+	if w.Score.Turns != 6 || w.Player.Energy < actThreshold {
+		t.Fatalf("turns %d, player energy %d: the prompt must come on the player's action", w.Score.Turns, w.Player.Energy)
+	}
+}
+
+func TestStrengthPotionExpires(t *testing.T) {
+	w := fovWorld(5,
+		"#####",
+		"#@  #",
+		"#####",
+	)
+	p := w.Player
+	p.Stats.Attack = 4
+	p.Inv = []Item{strengthPotionItem, strengthPotionItem}
+	w.PlayerUseItem(0)
+	w.endPlayerTurn() // drinking takes the turn
+	want := 4 + strengthPotionItem.Power
+	for turn := 1; turn <= strengthPotionItem.Turns; turn++ {
+		if got := p.EffectiveAttack(); got != want {
+			t.Fatalf("turn %d after drinking: attack %d, want %d", turn, got, want)
+		}
+		w.endPlayerTurn()
+	}
+	if got := p.EffectiveAttack(); got != 4 || p.Buffs != nil {
+		t.Fatalf("buff should expire after %d turns: attack %d, buffs %v", strengthPotionItem.Turns, got, p.Buffs)
+	}
+
+	// A second potion while the first is active restarts it, not stacks
+	p.Inv = []Item{strengthPotionItem, strengthPotionItem}
+	w.PlayerUseItem(0)
+	w.endPlayerTurn()
+	w.PlayerUseItem(0)
+	if got := p.EffectiveAttack(); got != want || len(p.Buffs) != 1 || p.Buffs[0].Turns != strengthPotionItem.Turns {
+		t.Fatalf("re-drinking: attack %d, buffs %v", got, p.Buffs)
+	}
+}
+
+func TestTeleportScrollStaysReachable(t *testing.T) {
+	w := fovWorld(5,
+		"#########",
+		"#@  #   #",
+		"#   # g #",
+		"#########",
+	)
+	p := w.Player
+	for i := 0; i < 20; i++ {
+		sx, sy := p.X, p.Y
+		p.Inv = []Item{teleportScrollItem}
+		w.PlayerUseItem(0)
+		if p.X == sx && p.Y == sy {
+			t.Fatal("teleport should move the player")
+		}
+		if p.X > 3 || w.Tiles[p.Y][p.X].Entity != p || w.Tiles[sy][sx].Entity != nil {
+			t.Fatalf("teleported to (%d,%d), outside the player's room or tiles not updated", p.X, p.Y)
+		}
+	}
+
+	// Nowhere to go: the scroll is used up, the player stays
+	w = fovWorld(5,
+		"###",
+		"#@#",
+		"###",
+	)
+	w.Player.Inv = []Item{teleportScrollItem}
+	w.PlayerUseItem(0)
+	if w.Player.X != 1 || w.Player.Y != 1 || len(w.Player.Inv) != 0 {
+		t.Fatal("scroll with nowhere to go should fizzle")
+	}
+}
+
+func TestBombBlastArea(t *testing.T) {
+	w := fovWorld(5,
+		"#########",
+		"#       #",
+		"#       #",
+		"#   @   #",
+		"#       #",
+		"#       #",
+		"#########",
+	)
+	// radius 2: dx²+dy² <= 4 is 13 tiles around the centre
+	if got := len(w.blastArea(4, 3, bombRadius)); got != 13 {
+		t.Fatalf("blast area %d tiles, want 13", got)
+	}
+	if got := len(w.blastArea(0, 0, bombRadius)); got != 6 {
+		t.Fatalf("blast area at the corner %d tiles, want 6 (clipped)", got)
+	}
+
+	at := func(x, y int) *Entity {
+		e := &Entity{Name: "Гоблин", Stats: Stats{HPMax: 30, HP: 30}}
+		w.PlaceEntity(e, x, y)
+		return e
+	}
+	adjacent, edge, diagonal, far := at(5, 3), at(4, 1), at(5, 2), at(6, 1)
+	p := w.Player
+	p.Inv = []Item{bombItem}
+	w.PlayerThrow(0, p.X, p.Y) // at the player's feet
+	dmg := bombItem.Power
+	for _, e := range []*Entity{p, adjacent, edge, diagonal} {
+		if e.Stats.HP != e.Stats.HPMax-dmg {
+			t.Fatalf("%s at (%d,%d) should take %d, HP %d", e.Name, e.X, e.Y, dmg, e.Stats.HP)
+		}
+	}
+	if far.Stats.HP != far.Stats.HPMax {
+		t.Fatal("(2,2) away is outside radius 2")
+	}
+
+	// A kill by the bomb counts for the player
+	adjacent.Stats.HP = 1
+	p.Inv = []Item{bombItem}
+	w.PlayerThrow(0, 7, 3) // lands 2 tiles past the goblin, the player is out of the blast
+	if p.Stats.HP != p.Stats.HPMax-dmg {
+		t.Fatal("a bomb thrown away must not hurt the player")
+	}
+	if adjacent.Alive || w.Score.Kills != 1 || p.XP == 0 {
+		t.Fatalf("bomb kill: alive %v, kills %d, xp %d", adjacent.Alive, w.Score.Kills, p.XP)
+	}
+}
+
+func TestProjectilePath(t *testing.T) {
+	want := [][2]int{{1, 1}, {2, 1}, {3, 2}, {4, 2}}
+	if got := projectilePath(0, 0, 4, 2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("path %v, want %v", got, want)
+	}
+	if got := projectilePath(3, 3, 3, 3); len(got) != 0 {
+		t.Fatalf("path to itself %v", got)
+	}
+}
+
+func throwWorld() *World {
+	return fovWorld(10,
+		"############",
+		"#@         #",
+		"#          #",
+		"############",
+	)
+}
+
+func TestThrowOutcomes(t *testing.T) {
+	rock := Item{Name: "Камень"}
+
+	t.Run("plain item drops in front of the wall", func(t *testing.T) {
+		w := throwWorld()
+		w.Player.Inv = []Item{rock}
+		if !w.PlayerThrowDir(0, 1, 0) {
+			t.Fatal("throw refused")
+		}
+		// range 6 from x=1 would be x=7; the line stops there on open floor
+		if it := w.Tiles[1][7].Item; it == nil || it.Name != "Камень" || len(w.Player.Inv) != 0 {
+			t.Fatalf("item should lie on the far tile, inv %v", w.Player.Inv)
+		}
+		w.Player.Inv = []Item{rock}
+		w.PlayerThrowDir(0, 0, -1) // straight into the wall: lands at the feet
+		if it := w.Tiles[1][1].Item; it == nil {
+			t.Fatal("thrown into the wall: the item should drop on the player's tile")
+		}
+	})
+
+	t.Run("plain item hits and drops", func(t *testing.T) {
+		w := throwWorld()
+		g := &Entity{Name: "Гоблин", Alive: true, Stats: Stats{HPMax: 8, HP: 8}}
+		w.PlaceEntity(g, 4, 1)
+		w.Tiles[2][9].Item = &Item{Name: "далеко"}
+		w.Player.Inv = []Item{rock}
+		w.PlayerThrowDir(0, 1, 0)
+		if it := w.Tiles[1][4].Item; it == nil || it.Name != "Камень" || g.Stats.HP != 8 {
+			t.Fatalf("rock should stop on the goblin and fall there (hp %d)", g.Stats.HP)
+		}
+		if w.Tiles[1][7].Item != nil {
+			t.Fatal("rock flew past the goblin")
+		}
+		// The goblin's tile is taken now: the next one falls beside it
+		w.Player.Inv = []Item{rock}
+		w.PlayerThrowDir(0, 1, 0)
+		if n := countItems(w, "Камень"); n != 2 {
+			t.Fatalf("%d rocks on the floor, want 2", n)
+		}
+	})
+
+	t.Run("potions shatter on the target", func(t *testing.T) {
+		w := throwWorld()
+		g := &Entity{Name: "Гоблин", Alive: true, Stats: Stats{HPMax: 8, HP: 2}}
+		w.PlaceEntity(g, 3, 1)
+		w.Player.Inv = []Item{healFlaskItem, strengthPotionItem}
+		w.PlayerThrowDir(0, 1, 0)
+		if g.Stats.HP != 8 || countItems(w, healFlaskItem.Name) != 0 {
+			t.Fatalf("heal flask: goblin hp %d, flasks on floor %d", g.Stats.HP, countItems(w, healFlaskItem.Name))
+		}
+		w.PlayerThrowDir(0, 1, 0)
+		if g.buffAttack() != strengthPotionItem.Power || len(w.Player.Inv) != 0 {
+			t.Fatalf("strength potion: goblin buffs %v", g.Buffs)
+		}
+
+		// Missed potions land whole
+		w.Player.Inv = []Item{healFlaskItem}
+		w.PlayerThrowDir(0, 0, 1)
+		if it := w.Tiles[2][1].Item; it == nil || it.Name != healFlaskItem.Name {
+			t.Fatal("a potion that hits nobody should land intact")
+		}
+	})
+
+	t.Run("bomb explodes on impact", func(t *testing.T) {
+		w := throwWorld()
+		near := &Entity{Name: "Гоблин", Alive: true, Stats: Stats{HPMax: 30, HP: 30}}
+		behind := &Entity{Name: "Орк", Alive: true, Stats: Stats{HPMax: 30, HP: 30}}
+		w.PlaceEntity(near, 5, 1)
+		w.PlaceEntity(behind, 7, 1)
+		w.Player.Inv = []Item{bombItem}
+		w.PlayerThrowDir(0, 1, 0)
+		dmg := bombItem.Power
+		if near.Stats.HP != 30-dmg || behind.Stats.HP != 30-dmg || w.Player.Stats.HP != w.Player.Stats.HPMax {
+			t.Fatalf("blast at the goblin: near %d, behind %d, player %d", near.Stats.HP, behind.Stats.HP, w.Player.Stats.HP)
+		}
+		if countItems(w, bombItem.Name) != 0 {
+			t.Fatal("an exploded bomb must not stay on the floor")
+		}
+	})
+
+	t.Run("bad index spends nothing", func(t *testing.T) {
+		w := throwWorld()
+		if w.PlayerThrowDir(0, 1, 0) {
+			t.Fatal("throw with an empty inventory")
+		}
+	})
+}
+
+func countItems(w *World, name string) int {
+	n := 0
+	for _, row := range w.Tiles {
+		for _, tile := range row {
+			if tile.Item != nil && tile.Item.Name == name {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestSpawnMixesConsumables(t *testing.T) {
+	seen := map[ItemEffect]bool{}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		it := consumables.pick(r)
+		if it.Effect == NoEffect && it.Heal == 0 {
+			t.Fatalf("picked an item that does nothing: %+v", it)
+		}
+		seen[it.Effect] = true
+	}
+	for _, e := range []ItemEffect{NoEffect, EffectStrength, EffectTeleport, EffectBomb} {
+		if !seen[e] {
+			t.Fatalf("no %q item in 200 picks", e)
+		}
+	}
+}
+
+func openWorld() *World {
+	return fovWorld(5,
+		"##########",
+		"#@       #",
+		"#        #",
+		"#        #",
+		"##########",
+	)
+}
+
+func TestSpawnersMeetRequestedCounts(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		w := openWorld()
+		w.Rand = rand.New(rand.NewSource(seed))
+		w.Depth = 2 // no starting gear
+		spawnMonsters(w, 6)
+		spawnItems(w, 5)
+		spawnGold(w, 4)
+		if got := len(w.Entities) - 1; got != 6 {
+			t.Fatalf("seed %d: %d monsters, want 6", seed, got)
+		}
+		items := 0
+		for _, row := range w.Tiles {
+			for _, tile := range row {
+				if tile.Item != nil {
+					items++
+				}
+			}
+		}
+		if items != 9 {
+			t.Fatalf("seed %d: %d items, want 9", seed, items)
+		}
+	}
+
+	// More than fits: every free tile is used, nothing is stacked
+	w := openWorld()
+	spawnMonsters(w, 100)
+	if got := len(w.Entities); got != 8*3 {
+		t.Fatalf("full level: %d entities, want %d", got, 8*3)
+	}
+}
+
+func TestDifficultyFromFlags(t *testing.T) {
+	d, err := difficultyFromFlags("hard", -1, 2, map[string]bool{"items": true})
+	if err != nil || d.Name != "hard" || d.Monsters != difficulties["hard"].Monsters || d.Items != 2 {
+		t.Fatalf("hard with -items 2: %+v, %v", d, err)
+	}
+	if d, err := difficultyFromFlags("easy", 0, -1, map[string]bool{"monsters": true}); err != nil || d.Monsters != 0 {
+		t.Fatalf("-monsters 0 is allowed: %+v, %v", d, err)
+	}
+	for _, c := range []struct {
+		name           string
+		monsters, item int
+		set            map[string]bool
+		want           string
+	}{
+		{"brutal", -1, -1, nil, `unknown difficulty "brutal" (want easy, hard, normal)`},
+		{"normal", -3, -1, map[string]bool{"monsters": true}, "-monsters must be 0 or more, got -3"},
+		{"normal", -1, -1, map[string]bool{"items": true}, "-items must be 0 or more, got -1"},
+	} {
+		if _, err := difficultyFromFlags(c.name, c.monsters, c.item, c.set); err == nil || err.Error() != c.want {
+			t.Errorf("%s %d %d: got %v, want %q", c.name, c.monsters, c.item, err, c.want)
+		}
+	}
+}
+
+func TestDifficultyScalesSpawnsAndXP(t *testing.T) {
+	defer func(d Difficulty) { difficulty = d }(difficulty)
+	if xpForLevel(2) != 60 {
+		t.Fatalf("normal XP curve changed: %d", xpForLevel(2))
+	}
+
+	spawnOne := func(d Difficulty) *Entity {
+		difficulty = d
+		w := openWorld()
+		w.Depth = 2
+		spawnMonsters(w, 1)
+		return w.Entities[1]
+	}
+	normal, hard, easy := spawnOne(difficulties["normal"]), spawnOne(difficulties["hard"]), spawnOne(difficulties["easy"])
+	if hard.Stats.HPMax <= normal.Stats.HPMax || hard.Stats.Attack <= normal.Stats.Attack || hard.Stats.HP != hard.Stats.HPMax {
+		t.Fatalf("hard monster not stronger: %+v vs %+v", hard.Stats, normal.Stats)
+	}
+	if easy.Stats.HPMax >= normal.Stats.HPMax {
+		t.Fatalf("easy monster not weaker: %+v vs %+v", easy.Stats, normal.Stats)
+	}
+
+	heals := func(d Difficulty) int {
+		for _, e := range d.itemMix() {
+			if e.Item.Name == healFlaskItem.Name {
+				return e.Weight
+			}
+		}
+		return 0
+	}
+	if e, n, h := heals(difficulties["easy"]), heals(difficulties["normal"]), heals(difficulties["hard"]); !(e > n && n > h && h > 0) {
+		t.Fatalf("heal flask weights easy/normal/hard: %d/%d/%d", e, n, h)
+	}
+	if consumables[0].Weight != 10 {
+		t.Fatal("itemMix must not change the shared consumables table")
+	}
+
+	difficulty = difficulties["hard"]
+	difficulty.Monsters = 3
+	w := buildDungeon(7, defaultGenerator(), Theme{}, 2, newPlayer())
+	for _, lvl := range w.Dungeon.Levels {
+		monsters := 0
+		for _, e := range lvl.Entities {
+			if !e.IsPlayer && !e.Pet {
+				monsters++
+			}
+		}
+		if monsters != 3 {
+			t.Fatalf("depth %d: %d monsters, want the -monsters override 3", lvl.Depth, monsters)
+		}
+	}
+	if xpForLevel(2) != 75 {
+		t.Fatalf("hard XP for level 2: %d, want 75", xpForLevel(2))
+	}
+}
+
+func TestPlaceEntityAndItemReportFailure(t *testing.T) {
+	w := openWorld()
+	goblin := &Entity{Name: "Гоблин"}
+	if !w.PlaceEntity(goblin, 3, 1) {
+		t.Fatal("free floor should take an entity")
+	}
+	if w.PlaceEntity(&Entity{Name: "Волк"}, 3, 1) || w.PlaceEntity(&Entity{Name: "Волк"}, 0, 0) || w.PlaceEntity(&Entity{Name: "Волк"}, -1, 2) {
+		t.Fatal("occupied, wall and off-map tiles must be refused")
+	}
+	if !w.PlaceEntity(goblin, 5, 2) {
+		t.Fatal("re-placing an entity should move it")
+	}
+	if len(w.Entities) != 2 || w.Tiles[1][3].Entity != nil || w.Tiles[2][5].Entity != goblin {
+		t.Fatalf("re-placing must not track the entity twice: %d entities", len(w.Entities))
+	}
+
+	if !w.PlaceItem(healFlaskItem, 4, 3) {
+		t.Fatal("free floor should take an item")
+	}
+	if w.PlaceItem(bombItem, 4, 3) || w.PlaceItem(bombItem, 5, 2) || w.PlaceItem(bombItem, 0, 2) {
+		t.Fatal("tiles with an item, an entity or a wall must be refused")
+	}
+	if w.Tiles[3][4].Item.Name != healFlaskItem.Name {
+		t.Fatal("a refused item must not replace the one lying there")
+	}
+}
+
+// keys scripts a targeting session: runes are movement, '\n' is Enter and
+// '!' is Esc.
+func keys(script string) func() (rune, termbox.Key) {
+	rs := []rune(script)
+	return func() (rune, termbox.Key) {
+		r := rs[0]
+		rs = rs[1:]
+		switch r {
+		case '\n':
+			return 0, termbox.KeyEnter
+		case '!':
+			return 0, termbox.KeyEsc
+		}
+		return r, 0
+	}
+}
+
+func TestTargetingCursorClampedToRangeAndView(t *testing.T) {
+	w := fovWorld(10,
+		"############",
+		"#@         #",
+		"#          #",
+		"######     #",
+		"#g   #     #",
+		"############",
+	)
+	w.updateFOV()
+	tg := w.newTargeting(3, 0)
+	for i := 0; i < 6; i++ {
+		tg.Move(w, 1, 0)
+	}
+	if tg.X != 4 || tg.Y != 1 {
+		t.Fatalf("cursor should stop at range 3, at (%d,%d)", tg.X, tg.Y)
+	}
+	tg.Move(w, 0, 1) // (4,2) is 3.2 away
+	if tg.X != 4 || tg.Y != 1 {
+		t.Fatalf("cursor left the range: (%d,%d)", tg.X, tg.Y)
+	}
+
+	// The room behind the wall is out of view: the cursor can't go there
+	tg = w.newTargeting(5, 0)
+	tg.Move(w, 0, 1)
+	tg.Move(w, 0, 1)
+	tg.Move(w, 0, 1)
+	if w.visibility(tg.X, tg.Y) != Visible || tg.Y == 4 {
+		t.Fatalf("cursor moved onto an unseen tile (%d,%d)", tg.X, tg.Y)
+	}
+
+	// Esc cancels: nothing thrown, no item used
+	w.Player.Inv = []Item{bombItem}
+	if _, _, ok := w.selectTarget(w.newTargeting(bombItem.Range, bombRadius), keys("dd!"), func() {}); ok {
+		t.Fatal("Esc should cancel targeting")
+	}
+	x, y, ok := w.selectTarget(w.newTargeting(bombItem.Range, bombRadius), keys("ddds\n"), func() {})
+	if !ok || x != 4 || y != 2 {
+		t.Fatalf("confirmed (%d,%d) ok=%v, want (4,2)", x, y, ok)
+	}
+}
+
+func TestTargetingRejectsBlockedLineOfFire(t *testing.T) {
+	w := fovWorld(10,
+		"#######",
+		"#@ #  #",
+		"#     #",
+		"#     #",
+		"#######",
+	)
+	// No FOV computed: everything counts as visible, so only the wall stops
+	// the throw
+	if !w.lineOfFire(1, 1, 5, 3) || w.lineOfFire(1, 1, 5, 1) {
+		t.Fatal("line of fire: open diagonal should pass, straight through the wall should not")
+	}
+	if err := w.checkTarget(5, 1, 5); err != errNoLine {
+		t.Fatalf("checkTarget = %v, want errNoLine", err)
+	}
+	if err := w.checkTarget(5, 3, 3); err != errOutOfRange {
+		t.Fatalf("checkTarget = %v, want errOutOfRange", err)
+	}
+
+	// Enter on the blocked tile is refused and targeting goes on
+	prev := messages
+	messages = &MessageLog{}
+	t.Cleanup(func() { messages = prev })
+	w.Player.Inv = []Item{bombItem}
+	x, y, ok := w.selectTarget(w.newTargeting(5, bombRadius), keys("dddd\n!"), func() {})
+	if ok {
+		t.Fatalf("blocked target (%d,%d) accepted", x, y)
+	}
+	if log := messages.Last(messageLogSize); len(log) != 2 || log[0] != errNoLine.Error() {
+		t.Fatalf("want the line-of-fire message, then the cancel, got %q", log)
+	}
+	if w.PlayerThrow(0, 5, 1) || len(w.Player.Inv) != 1 {
+		t.Fatal("a refused throw must keep the item")
+	}
+}
+
+func TestDeathWritesMorgueEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.json")
+	world := newGame(42, defaultGenerator())
+	g := NewSingleGame(world, DefaultRenderConfig())
+	g.MorguePath = path
+	world.Score.Kills, world.Score.Turns = 3, 57
+	var killer *Entity
+	for _, e := range world.Entities {
+		if !e.IsPlayer {
+			killer = e
+			break
+		}
+	}
+	world.hurt(killer, world.Player, world.Player.Stats.HP)
+	if !g.checkDeath() {
+		t.Fatal("dead player should end the game")
+	}
+	entries, err := LoadMorgue(path)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("entries %v, err %v", entries, err)
+	}
+	e := entries[0]
+	if e.Seed != 42 || e.Kills != 3 || e.Turns != 57 || e.Depth != 1 || e.Cause != killer.Name || e.Date.IsZero() {
+		t.Fatalf("entry: %+v", e)
+	}
+}
+
+func TestMorgueTopSortsByDepthThenKills(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.json")
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		e := MorgueEntry{Date: day.Add(time.Duration(i) * time.Hour), Seed: int64(i), Depth: i % 4, Kills: i, Cause: "Гоблин"}
+		if err := RecordMorgue(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := LoadMorgue(path)
+	if err != nil || len(entries) != 12 {
+		t.Fatalf("%d entries, err %v", len(entries), err)
+	}
+	var seeds []int64
+	for _, e := range topMorgue(entries, topScores) {
+		seeds = append(seeds, e.Seed)
+	}
+	want := []int64{11, 7, 3, 10, 6, 2, 9, 5, 1, 8}
+	if fmt.Sprint(seeds) != fmt.Sprint(want) {
+		t.Fatalf("top seeds %v, want %v", seeds, want)
+	}
+	var out strings.Builder
+	printScores(&out, entries)
+	if lines := strings.Count(out.String(), "\n"); lines != topScores+1 {
+		t.Fatalf("table has %d lines:\n%s", lines, out.String())
+	}
+}
+
+func TestMorgueMissingOrCorruptStartsFresh(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scores.json")
+	if entries, err := LoadMorgue(path); err != nil || len(entries) != 0 {
+		t.Fatalf("missing file: %v, %v", entries, err)
+	}
+	var out strings.Builder
+	printScores(&out, nil)
+	if !strings.Contains(out.String(), "нет") {
+		t.Fatalf("empty table: %q", out.String())
+	}
+
+	os.WriteFile(path, []byte("{not json"), 0o644)
+	err := RecordMorgue(path, MorgueEntry{Seed: 7, Depth: 2, Cause: "Волк"})
+	if !errors.Is(err, errMorgueReset) {
+		t.Fatalf("corrupt file: %v", err)
+	}
+	entries, err := LoadMorgue(path)
+	if err != nil || len(entries) != 1 || entries[0].Seed != 7 {
+		t.Fatalf("fresh file: %v, %v", entries, err)
+	}
+	if bad, _ := filepath.Glob(path + ".bad-*"); len(bad) != 1 {
+		t.Fatalf("corrupt file not kept aside: %v", bad)
+	}
+}
+
+func TestHungerThresholds(t *testing.T) {
+	w := openWorld()
+	p := w.Player
+	p.Stats.HP = p.Stats.HPMax - 5
+	p.Hunger = hungryAt - regenEvery
+	for i := 0; i < regenEvery; i++ {
+		w.tickHunger()
+	}
+	if hungerState(p.Hunger) != Hungry || !strings.Contains(w.StatusLine(), "голоден") {
+		t.Fatalf("hunger %d: %s", p.Hunger, w.StatusLine())
+	}
+	// The regen tick at hungryAt is already past the threshold.
+	if p.Stats.HP != p.Stats.HPMax-5 {
+		t.Fatalf("hungry player regenerated: HP %d", p.Stats.HP)
+	}
+
+	p.Hunger = 0
+	for i := 0; i < 3*regenEvery; i++ {
+		w.tickHunger()
+	}
+	if p.Stats.HP != p.Stats.HPMax-2 {
+		t.Fatalf("sated player: HP %d, want +3", p.Stats.HP)
+	}
+
+	p.Hunger = starvingAt - 1
+	w.tickHunger()
+	if hungerState(p.Hunger) != Starving || !strings.Contains(w.StatusLine(), "истощён") {
+		t.Fatalf("hunger %d: %s", p.Hunger, w.StatusLine())
+	}
+
+	p.Inv = []Item{rationItem}
+	w.PlayerUseItem(0)
+	if p.Hunger != starvingAt-rationItem.Power || hungerState(p.Hunger) != Sated || len(p.Inv) != 0 {
+		t.Fatalf("after a ration: hunger %d, inv %v", p.Hunger, p.Inv)
+	}
+}
+
+func TestStarvationCadenceAndDeath(t *testing.T) {
+	w := openWorld()
+	p := w.Player
+	p.Hunger = starvingAt - 1
+	hp := p.Stats.HP
+	var hits []int
+	for turn := 1; turn <= 3*starveEvery; turn++ {
+		w.tickHunger()
+		if p.Stats.HP != hp {
+			hits = append(hits, turn)
+			hp = p.Stats.HP
+		}
+	}
+	if fmt.Sprint(hits) != "[10 20 30]" {
+		t.Fatalf("starvation damage on turns %v", hits)
+	}
+
+	p.Stats.HP = 1
+	for p.Alive {
+		w.tickHunger()
+	}
+	if w.DeathCause != "голод" || w.Tiles[p.Y][p.X].Entity != nil {
+		t.Fatalf("death cause %q", w.DeathCause)
+	}
+}
+
+func TestNoHungerSwitchesClockAndFoodOff(t *testing.T) {
+	hungerOn = false
+	defer func() { hungerOn = true }()
+	w := openWorld()
+	p := w.Player
+	p.Stats.HP = 1
+	p.Hunger = starvingAt + starveEvery - 1
+	for i := 0; i < 5*starveEvery; i++ {
+		w.tickHunger()
+	}
+	if p.Hunger != starvingAt+starveEvery-1 || p.Stats.HP != 1 || strings.Contains(w.StatusLine(), "Сытость") {
+		t.Fatalf("hunger off: hunger %d, HP %d, %s", p.Hunger, p.Stats.HP, w.StatusLine())
+	}
+	for _, e := range difficulty.itemMix() {
+		if e.Item.Effect == EffectFood {
+			t.Fatal("food in the item mix with hunger off")
+		}
+	}
+}
+
+func TestGeneratedRarityFrequencies(t *testing.T) {
+	const n = 20000
+	r := rand.New(rand.NewSource(7))
+	counts := map[Rarity]int{}
+	for i := 0; i < n; i++ {
+		it := generateItem(r, 1+i%5)
+		counts[it.Rarity]++
+		if it.Name == "" || it.Weight < 0 {
+			t.Fatalf("bad item %+v", it)
+		}
+	}
+	total := 0
+	for _, e := range rarityWeights {
+		total += e.Weight
+	}
+	for _, e := range rarityWeights {
+		want := float64(e.Weight) / float64(total)
+		got := float64(counts[e.Rarity]) / n
+		if math.Abs(got-want) > 0.015 {
+			t.Errorf("rarity %q: %.3f of rolls, want %.3f", e.Rarity, got, want)
+		}
+	}
+}
+
+func TestGeneratedBonusBudgetCap(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	maxPoints := map[Rarity]int{}
+	for i := 0; i < 5000; i++ {
+		for _, base := range itemBases {
+			for _, rarity := range []Rarity{Common, Rare, Epic} {
+				depth := 1 + i%6
+				plain := base.make(depth)
+				it := generateItemOf(r, depth, base, rarity)
+				points := it.AttackBonus - plain.AttackBonus + it.DefenseBonus - plain.DefenseBonus +
+					(it.Heal-plain.Heal)/2 + plain.Weight - it.Weight
+				if points > rarityBudget[rarity] || points < 0 {
+					t.Fatalf("%s %q: %d bonus points over the base, budget %d", rarity, it.Name, points, rarityBudget[rarity])
+				}
+				if rarity == Common && it.Name != composeName("", base.Noun, "") {
+					t.Fatalf("common item with affixes: %q", it.Name)
+				}
+				maxPoints[rarity] = max(maxPoints[rarity], points)
+			}
+		}
+	}
+	if maxPoints[Epic] != rarityBudget[Epic] || maxPoints[Rare] != rarityBudget[Rare] {
+		t.Fatalf("budgets never reached: %v", maxPoints)
+	}
+}
+
+func TestGeneratedNamesAndDeterminism(t *testing.T) {
+	base := itemBases[BaseArmor]
+	it := generateItemOf(rand.New(rand.NewSource(3)), 2, base, Epic)
+	if strings.Count(it.Name, " ") != 2 || !strings.Contains(it.Name, " броня ") {
+		t.Fatalf("epic armor name %q", it.Name)
+	}
+	if first := []rune(it.Name)[0]; !unicode.IsUpper(first) || !strings.HasSuffix(strings.Fields(it.Name)[0], "ая") {
+		t.Fatalf("prefix does not agree with броня: %q", it.Name)
+	}
+	a := generateItem(rand.New(rand.NewSource(99)), 3)
+	b := generateItem(rand.New(rand.NewSource(99)), 3)
+	if a != b {
+		t.Fatalf("same seed, different items: %+v vs %+v", a, b)
+	}
+	if !strings.Contains(itemLabel(it), "[редкость: эпическая]") {
+		t.Fatalf("label %q", itemLabel(it))
+	}
+	if k := cellKind(&Tile{Type: FloorTile, Item: &it}); k != KindItemEpic {
+		t.Fatalf("epic item drawn as kind %d", k)
+	}
+}
+
+func TestMeleeDamageDistribution(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	att, def := Stats{Attack: 12}, Stats{Defense: 4} // base 10
+	const n = 10000
+	crits := 0
+	seen := map[int]bool{}
+	for i := 0; i < n; i++ {
+		dmg, crit := computeMeleeDamage(att, def, rng)
+		lo, hi := 8, 12
+		if crit {
+			crits++
+			lo, hi = lo*critMult, hi*critMult
+		}
+		if dmg < lo || dmg > hi {
+			t.Fatalf("damage %d (crit %v) outside %d..%d", dmg, crit, lo, hi)
+		}
+		if !crit {
+			seen[dmg] = true
+		}
+	}
+	if len(seen) != 5 {
+		t.Fatalf("normal hits took values %v, want all of 8..12", seen)
+	}
+	if rate := float64(crits) / n; math.Abs(rate-float64(critChance)/100) > 0.01 {
+		t.Fatalf("crit rate %.3f", rate)
+	}
+
+	// Weak attacks still do at least 1, crits 2.
+	for i := 0; i < 1000; i++ {
+		dmg, crit := computeMeleeDamage(Stats{Attack: 1}, Stats{Defense: 20}, rng)
+		if dmg < 1 || (crit && dmg < critMult) {
+			t.Fatalf("minimum damage %d (crit %v)", dmg, crit)
+		}
+	}
+
+	// Same seed, same fight.
+	a := rand.New(rand.NewSource(9))
+	b := rand.New(rand.NewSource(9))
+	for i := 0; i < 100; i++ {
+		d1, c1 := computeMeleeDamage(att, def, a)
+		d2, c2 := computeMeleeDamage(att, def, b)
+		if d1 != d2 || c1 != c2 {
+			t.Fatal("seeded rolls differ")
+		}
+	}
+}
+
+func TestCorpseDecaysAfter50Turns(t *testing.T) {
+	w := fovWorld(5,
+		"######",
+		"#@g  #",
+		"######",
+	)
+	w.Rand = rand.New(rand.NewSource(1))
+	killAll(w)
+	corpse := w.Tiles[1][2].Item
+	if corpse == nil || !corpse.Corpse || corpse.Name != "Труп гоблина" {
+		t.Fatalf("no corpse where the goblin died: %q", itemsOnMap(w))
+	}
+	// A second corpse in the pack rots on the same clock.
+	w.Player.Inv = append(w.Player.Inv, *corpse, healFlaskItem)
+	for turn := 1; turn < corpseDecay; turn++ {
+		w.tickCorpses()
+	}
+	if w.Tiles[1][2].Item == nil || len(w.Player.Inv) != 2 {
+		t.Fatalf("corpse gone before %d turns", corpseDecay)
+	}
+	if got := itemLabel(*w.Tiles[1][2].Item); got != "Труп гоблина (гниёт через 1 ходов)" {
+		t.Fatalf("label %q", got)
+	}
+	w.tickCorpses()
+	if w.Tiles[1][2].Item != nil || len(w.Player.Inv) != 1 || w.Player.Inv[0].Name != healFlaskItem.Name {
+		t.Fatalf("after %d turns: floor %q, pack %v", corpseDecay, itemsOnMap(w), w.Player.Inv)
+	}
+}
+
+func TestCorpseSicknessWearsOff(t *testing.T) {
+	w := openWorld()
+	p := w.Player
+	var seed int64
+	for seed = 1; ; seed++ { // find a sickening bite
+		if rand.New(rand.NewSource(seed)).Intn(sickChance) == 0 {
+			break
+		}
+	}
+	w.Rand = rand.New(rand.NewSource(seed))
+	p.Hunger = hungryAt
+	p.Inv = []Item{corpseOf(&Entity{Name: "Гоблин"})}
+	atk := p.EffectiveAttack()
+	w.PlayerUseItem(0)
+	if p.Hunger != hungryAt-corpseNutrient || p.EffectiveAttack() != atk-sickAttack {
+		t.Fatalf("hunger %d, attack %d after a bad corpse", p.Hunger, p.EffectiveAttack())
+	}
+	for turn := 0; turn < sickTurns; turn++ {
+		p.tickBuffs()
+	}
+	if p.EffectiveAttack() != atk-sickAttack {
+		t.Fatal("sickness ended early")
+	}
+	p.tickBuffs()
+	if p.EffectiveAttack() != atk || p.Buffs != nil {
+		t.Fatalf("sickness still on: %+v", p.Buffs)
+	}
+}
+
+func TestNoCorpsesWithHungerOff(t *testing.T) {
+	hungerOn = false
+	defer func() { hungerOn = true }()
+	w := fovWorld(5,
+		"#####",
+		"#@g #",
+		"#####",
+	)
+	w.Rand = rand.New(rand.NewSource(1))
+	killAll(w)
+	for _, it := range itemsOnMap(w) {
+		if strings.Contains(it, "Труп") {
+			t.Fatalf("corpse with hunger off: %q", it)
+		}
+	}
+}
+
+// withShrineOutcomes pins the outcome table for one test.
+func withShrineOutcomes(t *testing.T, table ...ShrineOutcome) {
+	prev := shrineOutcomes
+	shrineOutcomes = table
+	t.Cleanup(func() { shrineOutcomes = prev })
+}
+
+// shrineWorld is openWorld with the player standing on a shrine.
+func shrineWorld() *World {
+	w := openWorld()
+	w.Rand = rand.New(rand.NewSource(1))
+	w.Tiles[w.Player.Y][w.Player.X].Type = ShrineTile
+	return w
+}
+
+func TestShrineOutcomes(t *testing.T) {
+	t.Run("heal", func(t *testing.T) {
+		withShrineOutcomes(t, ShrineOutcome{ShrineHeal, 1})
+		w := shrineWorld()
+		w.Player.Stats.HP = 1
+		w.PlayerPray()
+		if w.Player.Stats.HP != w.Player.Stats.HPMax {
+			t.Fatalf("HP %d/%d", w.Player.Stats.HP, w.Player.Stats.HPMax)
+		}
+	})
+	t.Run("attack", func(t *testing.T) {
+		withShrineOutcomes(t, ShrineOutcome{ShrineAttack, 1})
+		w := shrineWorld()
+		atk := w.Player.Stats.Attack
+		w.PlayerPray()
+		if w.Player.Stats.Attack != atk+1 || w.Player.Buffs != nil {
+			t.Fatalf("attack %d, buffs %+v", w.Player.Stats.Attack, w.Player.Buffs)
+		}
+	})
+	t.Run("curse", func(t *testing.T) {
+		withShrineOutcomes(t, ShrineOutcome{ShrineCurse, 1})
+		w := shrineWorld()
+		p := w.Player
+		def := p.EffectiveDefense()
+		w.PlayerPray()
+		// The prayer's own turn, then curseTurns more.
+		for turn := 0; turn <= curseTurns; turn++ {
+			if p.EffectiveDefense() != def-curseDefense {
+				t.Fatalf("turn %d: defense %d", turn, p.EffectiveDefense())
+			}
+			p.tickBuffs()
+		}
+		if p.EffectiveDefense() != def {
+			t.Fatalf("curse outlived %d turns", curseTurns)
+		}
+	})
+	t.Run("summon", func(t *testing.T) {
+		withShrineOutcomes(t, ShrineOutcome{ShrineSummon, 1})
+		w := shrineWorld()
+		w.PlayerPray()
+		var near int
+		for _, e := range w.Entities {
+			if !e.IsPlayer && abs(e.X-w.Player.X)+abs(e.Y-w.Player.Y) == 1 && e.AIState == AIChase {
+				near++
+			}
+		}
+		if near != shrineSummons {
+			t.Fatalf("%d monsters next to the shrine, want %d", near, shrineSummons)
+		}
+	})
+}
+
+func TestShrineWorksOnce(t *testing.T) {
+	withShrineOutcomes(t, ShrineOutcome{ShrineAttack, 1})
+	w := shrineWorld()
+	p := w.Player
+	atk := p.Stats.Attack
+	if !w.PlayerPray() {
+		t.Fatal("first prayer refused")
+	}
+	if w.PlayerPray() || p.Stats.Attack != atk+1 {
+		t.Fatalf("depleted shrine answered: attack %d", p.Stats.Attack)
+	}
+	if tile := w.Tiles[p.Y][p.X]; tile.Type != ShrineUsedTile || glyphSets[GlyphsASCII][terrainKind(tile)] != '-' {
+		t.Fatalf("tile %v after use", tile.Type)
+	}
+	w.Tiles[p.Y][p.X].Type = FloorTile
+	if w.PlayerPray() {
+		t.Fatal("prayed without a shrine")
+	}
+}
+
+func TestShrineOutcomeWeights(t *testing.T) {
+	withShrineOutcomes(t, ShrineOutcome{ShrineHeal, 1}, ShrineOutcome{ShrineCurse, 3})
+	w := openWorld()
+	w.Rand = rand.New(rand.NewSource(3))
+	const n = 8000
+	counts := map[ShrineEffect]int{}
+	for i := 0; i < n; i++ {
+		counts[w.rollShrine()]++
+	}
+	if got := float64(counts[ShrineCurse]) / n; math.Abs(got-0.75) > 0.02 || counts[ShrineHeal]+counts[ShrineCurse] != n {
+		t.Fatalf("outcomes %v", counts)
+	}
+}
+
+func TestViewport(t *testing.T) {
+	// 100x50 map, 80x24 window
+	for _, tc := range []struct {
+		name           string
+		px, py, x0, y0 int
+	}{
+		{"top-left", 0, 0, 0, 0},
+		{"top-right", 99, 0, 20, 0},
+		{"bottom-left", 0, 49, 0, 26},
+		{"bottom-right", 99, 49, 20, 26},
+		{"center", 50, 25, 10, 13},
+		{"near left edge", 39, 11, 0, 0},
+		{"just past left edge", 41, 13, 1, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if x0, y0 := Viewport(100, 50, 80, 24, tc.px, tc.py); x0 != tc.x0 || y0 != tc.y0 {
+				t.Fatalf("Viewport at (%d,%d) = (%d,%d), want (%d,%d)", tc.px, tc.py, x0, y0, tc.x0, tc.y0)
+			}
+		})
+	}
+	// A map that fits never scrolls.
+	if x0, y0 := Viewport(25, 12, 80, 24, 24, 11); x0 != 0 || y0 != 0 {
+		t.Fatalf("small map scrolled to (%d,%d)", x0, y0)
+	}
+}
+
+func TestLargeMapRendersViewport(t *testing.T) {
+	w := openWorld()
+	w.Width, w.Height = 120, 40
+	w.Tiles = make([][]*Tile, w.Height)
+	for y := range w.Tiles {
+		w.Tiles[y] = make([]*Tile, w.Width)
+		for x := range w.Tiles[y] {
+			w.Tiles[y][x] = &Tile{Type: FloorTile}
+		}
+	}
+	w.Visible, w.Explored = nil, nil
+	w.RenderCfg = RenderConfig{Glyphs: GlyphsASCII, Palette: PaletteNone}
+	w.Player.X, w.Player.Y = 119, 39
+	w.Tiles[39][119].Entity = w.Player
+
+	lines := strings.Split(strings.TrimSuffix(w.MapString(), "\n"), "\n")
+	if len(lines) != viewRows || len([]rune(lines[0])) != viewCols {
+		t.Fatalf("view is %dx%d, want %dx%d", len([]rune(lines[0])), len(lines), viewCols, viewRows)
+	}
+	if last := []rune(lines[viewRows-1]); last[viewCols-1] != '@' {
+		t.Fatalf("player not in the bottom-right corner: %q", string(last))
+	}
+}
+
+func TestValidateMapSize(t *testing.T) {
+	if err := validateMapSize(defaultMapWidth, defaultMapHeight); err != nil {
+		t.Fatal(err)
+	}
+	if validateMapSize(minMapWidth-1, 30) == nil || validateMapSize(40, minMapHeight-1) == nil {
+		t.Fatal("too small map accepted")
+	}
+}
+
+// petWorld is fovWorld with the dog recruited on the tile marked 'd'.
+func petWorld(t *testing.T, rows ...string) *World {
+	t.Helper()
+	w := fovWorld(10, rows...)
+	for y, row := range rows {
+		if x := strings.IndexRune(row, 'd'); x >= 0 {
+			w.PlaceEntity(newStrayDog(), x, y)
+		}
+	}
+	dog := w.Entities[len(w.Entities)-1]
+	if !w.recruit(dog) || w.Pet != dog || dog.faction() != factionPlayer {
+		t.Fatal("walking into the stray should recruit it")
+	}
+	return w
+}
+
+// walk moves the player n steps by (dx, dy), each a full turn.
+func walk(w *World, dx, dy, n int) {
+	for i := 0; i < n; i++ {
+		w.MoveEntity(w.Player, w.Player.X+dx, w.Player.Y+dy)
+		w.endPlayerTurn()
+	}
+}
+
+func TestPetRecruitAndFollowDistance(t *testing.T) {
+	w := fovWorld(10,
+		"################",
+		"#@             #",
+		"#####  #########",
+		"#              #",
+		"################",
+	)
+	w.PlaceEntity(newStrayDog(), 2, 1)
+	dog := w.Entities[1]
+	if !w.MoveEntity(w.Player, 2, 1) || !dog.Pet || dog.Stray || w.Pet != dog {
+		t.Fatal("bumping the stray should recruit it")
+	}
+	if w.Player.X != 1 {
+		t.Fatal("recruiting should not move the player")
+	}
+	route := [][3]int{{1, 0, 5}, {0, 1, 2}, {1, 0, 4}, {-1, 0, 8}}
+	for _, r := range route {
+		for i := 0; i < r[2]; i++ {
+			walk(w, r[0], r[1], 1)
+			if d := w.distanceMapFrom(w.Player.X, w.Player.Y).At(dog.X, dog.Y); d < 1 || d > petFollowDistance {
+				t.Fatalf("player at (%d,%d), dog at (%d,%d): walking distance %d", w.Player.X, w.Player.Y, dog.X, dog.Y, d)
+			}
+		}
+	}
+}
+
+func TestPetStayOrder(t *testing.T) {
+	w := petWorld(t,
+		"##############",
+		"#@d          #",
+		"##############",
+	)
+	dog := w.Pet
+	w.MoveEntity(w.Player, 2, 1) // swap places with the dog
+	if w.Player.X != 2 || dog.X != 1 {
+		t.Fatalf("player and dog should swap, got %d and %d", w.Player.X, dog.X)
+	}
+	w.PetCommand([]string{"stay"})
+	walk(w, 1, 0, 6)
+	if dog.X != 1 || dog.Y != 1 || dog.PetOrder != PetStay {
+		t.Fatalf("dog told to stay moved to (%d,%d)", dog.X, dog.Y)
+	}
+	if w.followingPet() != nil {
+		t.Fatal("a dog told to stay must not follow down the stairs")
+	}
+	w.PetCommand([]string{"follow"})
+	walk(w, 0, 0, 6)
+	if stepDistance(dog, w.Player) > petFollowDistance {
+		t.Fatalf("dog told to follow should catch up, %d tiles away", stepDistance(dog, w.Player))
+	}
+}
+
+func TestPetNoFriendlyFire(t *testing.T) {
+	w := petWorld(t,
+		"######",
+		"#@d g#",
+		"######",
+	)
+	dog := w.Pet
+	if w.MoveEntity(dog, w.Player.X, w.Player.Y) || w.Player.Stats.HP != 30 || dog.Stats.HP != dog.Stats.HPMax {
+		t.Fatal("the dog must not attack the player")
+	}
+	goblin := w.Entities[1]
+	goblin.Stats.HP = 1
+	w.MoveEntity(dog, 3, 1)
+	w.petTurn(new(*DistanceMap))
+	if goblin.Alive || w.Score.Kills != 1 || w.Player.XP == 0 {
+		t.Fatalf("the dog's kill should count for the player: alive %v kills %d xp %d", goblin.Alive, w.Score.Kills, w.Player.XP)
+	}
+}
+
+func TestMonsterAggroSplitsByProximity(t *testing.T) {
+	w := petWorld(t,
+		"###########",
+		"#g  d    @#",
+		"#        g#",
+		"###########",
+	)
+	near, far := w.Entities[1], w.Entities[2] // g at (1,1) near the dog, g at (9,2) by the player
+	if w.aggroTarget(near) != w.Pet || w.aggroTarget(far) != w.Player {
+		t.Fatal("each monster should go for whoever of player and dog is closer")
+	}
+	w.Pet.PetOrder = PetStay
+	for _, m := range []*Entity{near, far} {
+		m.AIState = AIChase
+	}
+	var flow *DistanceMap
+	w.monsterAct(near, &flow)
+	w.monsterAct(far, &flow)
+	if near.X != 2 || w.Player.Stats.HP == 30 {
+		t.Fatalf("near goblin should step to the dog (at %d), far one hit the player (HP %d)", near.X, w.Player.Stats.HP)
+	}
+	w.monsterAct(near, &flow)
+	w.monsterAct(near, &flow)
+	if w.Pet.Stats.HP == w.Pet.Stats.HPMax {
+		t.Fatal("the goblin next to the dog should bite it")
+	}
+
+	// A dead pet is gone: no corpse, no second dog.
+	w.hurt(near, w.Pet, 1000)
+	dog := w.Pet
+	if dog.Alive || w.petHere() || w.Tiles[dog.Y][dog.X].Item != nil {
+		t.Fatal("the dead dog should leave no body on the map")
+	}
+	w.RemoveDeadEntities()
+	w.PlaceEntity(newStrayDog(), 5, 2)
+	if w.recruit(w.Entities[len(w.Entities)-1]) {
+		t.Fatal("a second dog must not join after the first died")
+	}
+}
+
+func TestPetSaveLoad(t *testing.T) {
+	w := newGame(11, defaultGenerator())
+	var dog *Entity
+	for _, e := range w.Entities {
+		if e.Stray {
+			dog = e
+		}
+	}
+	if dog == nil {
+		t.Fatal("the first level should have a stray dog")
+	}
+	w.recruit(dog)
+	dog.PetOrder = PetStay
+	path := filepath.Join(t.TempDir(), "run.json")
+	if err := w.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadWorld(path, defaultGenerator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.petHere() || got.Pet.X != dog.X || got.Pet.Y != dog.Y || got.Pet.PetOrder != PetStay || got.Pet.Stray {
+		t.Fatalf("pet after load: %+v", got.Pet)
+	}
+}
+
+// scriptedPolicy plays the given commands, then runs out of input.
+type scriptedPolicy []string
+
+func (s *scriptedPolicy) Next(*Game) (string, bool) {
+	if len(*s) == 0 {
+		return "", false
+	}
+	line := (*s)[0]
+	*s = (*s)[1:]
+	return line, true
+}
+
+func TestStepRunsPolicyCommands(t *testing.T) {
+	w := openWorld()
+	g := NewSingleGame(w, DefaultRenderConfig())
+	g.Headless = true
+	script := scriptedPolicy{"d", "", "x"}
+	for i := 0; i < 3; i++ {
+		if !g.Step(&script) {
+			t.Fatalf("step %d ended the game", i)
+		}
+	}
+	if w.Player.X != 2 || w.Score.Turns != 2 {
+		t.Fatalf("player at x=%d after %d turns", w.Player.X, w.Score.Turns)
+	}
+	if g.Step(&script) || g.State != StateOver {
+		t.Fatal("end of input should quit")
+	}
+}
+
+func TestGreedyBotSurvivalRate(t *testing.T) {
+	results := Simulate(100, 1, 4, defaultGenerator())
+	if messages == nil {
+		t.Fatal("Simulate left the message log off")
+	}
+	r := summarize(results)
+	// Pinned for seeds 1-100 on normal: a change outside the range means
+	// the balance (or the bot) moved.
+	if rate := r.SurvivalRate(); rate < 0.45 || rate > 0.70 {
+		t.Fatalf("survival %.2f, depth %.2f, turns %.1f, causes %v", rate, r.AvgDepth, r.AvgTurns, r.Causes)
+	}
+	if r.Causes[causeTimeout] > 0 {
+		t.Fatalf("%d games hit the turn limit", r.Causes[causeTimeout])
+	}
+	for i, res := range Simulate(10, 1, 1, defaultGenerator()) {
+		if res != results[i] {
+			t.Fatalf("seed %d: %+v with one worker, %+v with four", res.Seed, res, results[i])
+		}
+	}
+}
+
+func BenchmarkSimulate(b *testing.B) {
+	prev := messages
+	messages = nil
+	defer func() { messages = prev }()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		simulateGame(int64(i+1), defaultGenerator(), greedyBot{})
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "games/s")
+}
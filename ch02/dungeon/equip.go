@@ -0,0 +1,119 @@
+package main
+
+import "fmt"
+
+// Equipment. Weapons and armor are ordinary inventory items with an Equip
+// slot; the worn piece moves out of the inventory into Entity.Weapon or
+// Entity.Armor and its bonus counts in every melee exchange.
+
+// EquipSlot says where an item is worn; NoSlot items are not equippable.
+type EquipSlot string
+
+const (
+	NoSlot     EquipSlot = ""
+	WeaponSlot EquipSlot = "weapon"
+	ArmorSlot  EquipSlot = "armor"
+)
+
+// Starting gear found on the first level.
+var (
+	swordItem        = Item{Name: "Меч", Equip: WeaponSlot, AttackBonus: 3}
+	leatherArmorItem = Item{Name: "Кожаная броня", Equip: ArmorSlot, DefenseBonus: 2}
+)
+
+// EffectiveAttack is base attack plus the weapon's and buffs' bonuses.
+func (e *Entity) EffectiveAttack() int {
+	atk := e.Stats.Attack + e.buffAttack()
+	if e.Weapon != nil {
+		atk += e.Weapon.AttackBonus
+	}
+	return atk
+}
+
+// EffectiveDefense is base defense plus the armor's bonus.
+func (e *Entity) EffectiveDefense() int {
+	def := e.Stats.Defense + e.buffDefense()
+	if e.Armor != nil {
+		def += e.Armor.DefenseBonus
+	}
+	return def
+}
+
+// slot returns the entity's field for s.
+func (e *Entity) slot(s EquipSlot) **Item {
+	if s == WeaponSlot {
+		return &e.Weapon
+	}
+	return &e.Armor
+}
+
+// PlayerEquip wears the inventory item at idx; a piece already in that slot
+// takes its place in the inventory.
+func (w *World) PlayerEquip(idx int) {
+	equipItem(w.Player, idx)
+}
+
+// equipItem is PlayerEquip for any entity (the town has no World).
+func equipItem(p *Entity, idx int) {
+	if idx < 0 || idx >= len(p.Inv) {
+		msg("Неверный индекс")
+		return
+	}
+	item := p.Inv[idx]
+	if item.Equip != WeaponSlot && item.Equip != ArmorSlot {
+		msg("%s нельзя надеть", item.Name)
+		return
+	}
+	slot := p.slot(item.Equip)
+	if old := *slot; old != nil {
+		p.Inv[idx] = *old
+		msg("Сняли: %s", old.Name)
+	} else {
+		p.Inv = append(p.Inv[:idx], p.Inv[idx+1:]...)
+	}
+	*slot = &item
+	msg("Надели: %s", itemLabel(item))
+}
+
+// spawnGear places one sword and one leather armor on free floor tiles.
+func spawnGear(world *World) {
+	free := world.freeFloor(true)
+	for i, it := range []Item{swordItem, leatherArmorItem} {
+		if i < len(free) {
+			world.PlaceItem(it, free[i][0], free[i][1])
+		}
+	}
+}
+
+// itemLabel describes an item for the inventory list.
+func itemLabel(it Item) string {
+	weight := ""
+	if it.Weight > 0 {
+		weight = fmt.Sprintf(", вес %d", it.Weight)
+	}
+	switch {
+	case it.Equip == WeaponSlot:
+		return fmt.Sprintf("%s (атака +%d%s)%s", it.Name, it.AttackBonus, weight, rarityLabel(it))
+	case it.Equip == ArmorSlot:
+		return fmt.Sprintf("%s (защита +%d%s)%s", it.Name, it.DefenseBonus, weight, rarityLabel(it))
+	case it.Heal > 0:
+		return fmt.Sprintf("%s (heal:%d%s)%s", it.Name, it.Heal, weight, rarityLabel(it))
+	case it.Effect == EffectStrength:
+		return fmt.Sprintf("%s (атака +%d, %d ходов)", it.Name, it.Power, it.Turns)
+	case it.Effect == EffectBomb:
+		return fmt.Sprintf("%s (урон %d, радиус %d)", it.Name, it.Power, bombRadius)
+	case it.Corpse:
+		return fmt.Sprintf("%s (гниёт через %d ходов)", it.Name, corpseDecay-it.Age)
+	case it.Gold > 0:
+		return fmt.Sprintf("%s (%d)", it.Name, it.Gold)
+	}
+	return it.Name
+}
+
+// slotLabel names a worn piece, or "нет".
+func slotLabel(it *Item) string {
+	if it == nil {
+		return "нет"
+	}
+	return it.Name
+}
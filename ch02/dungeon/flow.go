@@ -0,0 +1,69 @@
+package main
+
+// Monster pathing. Instead of a BFS from every monster to the player, one
+// distance map (flow map) is built from the player outward each turn; a
+// chasing monster steps to the neighbour one tile closer. Walls and closed
+// doors block, entities don't (as in BFSStepTowards), so the map stays valid
+// while monsters move during the turn.
+
+// unreachable marks tiles the flow map never reached.
+const unreachable = -1
+
+// stepDeltas is the neighbour order. It matches BFSStepTowards, so when
+// several shortest paths exist monsters pick the same first step as before.
+var stepDeltas = [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+
+// DistanceMap is the walking distance from one tile to every tile.
+type DistanceMap struct {
+	width, height int
+	dist          []int
+}
+
+// distanceMapFrom runs one BFS from (x, y) over the current level.
+func (w *World) distanceMapFrom(x, y int) *DistanceMap {
+	d := &DistanceMap{width: w.Width, height: w.Height, dist: make([]int, w.Width*w.Height)}
+	for i := range d.dist {
+		d.dist[i] = unreachable
+	}
+	queue := make([]int, 0, w.Width*w.Height)
+	d.dist[y*w.Width+x] = 0
+	queue = append(queue, y*w.Width+x)
+	for head := 0; head < len(queue); head++ {
+		cur := queue[head]
+		cx, cy := cur%w.Width, cur/w.Width
+		for _, delta := range stepDeltas {
+			nx, ny := cx+delta[0], cy+delta[1]
+			if nx < 0 || nx >= w.Width || ny < 0 || ny >= w.Height || w.Tiles[ny][nx].Type.blocksMonsters() {
+				continue
+			}
+			if i := ny*w.Width + nx; d.dist[i] == unreachable {
+				d.dist[i] = d.dist[cur] + 1
+				queue = append(queue, i)
+			}
+		}
+	}
+	return d
+}
+
+// At is the distance to (x, y), or unreachable.
+func (d *DistanceMap) At(x, y int) int {
+	if x < 0 || x >= d.width || y < 0 || y >= d.height {
+		return unreachable
+	}
+	return d.dist[y*d.width+x]
+}
+
+// StepFrom is the step (dx, dy) from (x, y) one tile closer to the map's
+// origin; (0, 0) when already there or when the origin can't be reached.
+func (d *DistanceMap) StepFrom(x, y int) (int, int) {
+	cur := d.At(x, y)
+	if cur <= 0 {
+		return 0, 0
+	}
+	for _, delta := range stepDeltas {
+		if d.At(x+delta[0], y+delta[1]) == cur-1 {
+			return delta[0], delta[1]
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,126 @@
+package main
+
+// Field of view: recursive shadowcasting over the tile grid. computeFOV only
+// reads tile types, so it can be tested against hand-drawn maps.
+
+// defaultSightRadius is the player's sight radius in tiles.
+const defaultSightRadius = 6
+
+// Visibility is how much of a tile the player can see when rendering.
+type Visibility int
+
+const (
+	Unseen   Visibility = iota // Never seen: drawn blank
+	Explored                   // Seen before: dim terrain, no entities
+	Visible                    // In view right now: full detail
+)
+
+// octants maps shadowcasting's (col, row) to (dx, dy) for each of the eight
+// octants: dx = col*xx + row*xy, dy = col*yx + row*yy.
+var octants = [8][4]int{
+	{1, 0, 0, 1}, {0, 1, 1, 0}, {0, -1, 1, 0}, {-1, 0, 0, 1},
+	{-1, 0, 0, -1}, {0, -1, -1, 0}, {0, 1, -1, 0}, {1, 0, 0, -1},
+}
+
+// computeFOV returns a [y][x] grid of tiles visible from (ox, oy) within
+// radius. Walls stop sight but are themselves visible. The distance check is
+// rounded (r²+r) so small radii look round rather than diamond-shaped.
+func computeFOV(tiles [][]*Tile, ox, oy, radius int) [][]bool {
+	vis := make([][]bool, len(tiles))
+	for y := range tiles {
+		vis[y] = make([]bool, len(tiles[y]))
+	}
+	if !inBounds(tiles, ox, oy) {
+		return vis
+	}
+	vis[oy][ox] = true
+	for _, o := range octants {
+		castLight(tiles, vis, ox, oy, radius, 1, 1.0, 0.0, o)
+	}
+	return vis
+}
+
+func inBounds(tiles [][]*Tile, x, y int) bool {
+	return y >= 0 && y < len(tiles) && x >= 0 && x < len(tiles[y])
+}
+
+// opaqueAt treats everything outside the grid as wall; closed doors block
+// sight too.
+func opaqueAt(tiles [][]*Tile, x, y int) bool {
+	return !inBounds(tiles, x, y) || tiles[y][x].Type == WallTile || tiles[y][x].Type.closedDoor()
+}
+
+// castLight scans one octant row by row, narrowing the [start, end] slope
+// window as walls cast shadows and recursing past each wall run.
+func castLight(tiles [][]*Tile, vis [][]bool, ox, oy, radius, row int, start, end float64, o [4]int) {
+	if start < end {
+		return
+	}
+	var newStart float64
+	for j := row; j <= radius; j++ {
+		blocked := false
+		for dx, dy := -j, -j; dx <= 0; dx++ {
+			x, y := ox+dx*o[0]+dy*o[1], oy+dx*o[2]+dy*o[3]
+			left := (float64(dx) - 0.5) / (float64(dy) + 0.5)
+			right := (float64(dx) + 0.5) / (float64(dy) - 0.5)
+			if start < right {
+				continue
+			}
+			if end > left {
+				break
+			}
+			if dx*dx+dy*dy <= radius*radius+radius && inBounds(tiles, x, y) {
+				vis[y][x] = true
+			}
+			wall := opaqueAt(tiles, x, y)
+			switch {
+			case blocked && wall:
+				newStart = right
+			case blocked:
+				blocked = false
+				start = newStart
+			case wall && j < radius:
+				blocked = true
+				castLight(tiles, vis, ox, oy, radius, j+1, start, left, o)
+				newStart = right
+			}
+		}
+		if blocked {
+			return
+		}
+	}
+}
+
+// updateFOV recomputes what the player sees, remembers it as explored and
+// marks monsters in view as having seen the player.
+func (w *World) updateFOV() {
+	if w.Player == nil {
+		return
+	}
+	w.Visible = computeFOV(w.Tiles, w.Player.X, w.Player.Y, w.Player.SightRadius)
+	if w.Explored == nil {
+		w.Explored = make([][]bool, w.Height)
+		for y := range w.Explored {
+			w.Explored[y] = make([]bool, w.Width)
+		}
+	}
+	for y := range w.Visible {
+		for x, v := range w.Visible[y] {
+			if v {
+				w.Explored[y][x] = true
+			}
+		}
+	}
+}
+
+// visibility returns the render state of (x, y). Without a computed FOV the
+// whole map is visible.
+func (w *World) visibility(x, y int) Visibility {
+	switch {
+	case w.Visible == nil || w.Visible[y][x]:
+		return Visible
+	case w.Explored[y][x]:
+		return Explored
+	}
+	return Unseen
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Game is the top-level state machine. A campaign moves between the
+// overworld map, the town and a dungeon; a single run (daily, -single, a
+// loaded plain run) only has the dungeon state. A new game may open on the
+// selection screen (unlocks.go) first. Each step shows the current screen,
+// asks a PlayerPolicy for one command and hands it to the state's handler:
+// in plain mode the policy reads stdin, in simulations (simulate.go) a bot
+// plays.
+
+// GameState is the screen the game is on.
+type GameState int
+
+const (
+	StateOverworld GameState = iota
+	StateTown
+	StateDungeon
+	StateOver
+	StateSelect // choosing class, starting item and theme
+)
+
+type Game struct {
+	State    GameState
+	Campaign *Campaign // nil for a single run
+	World    *World    // the dungeon being played, if any
+	Won      bool      // single run: exit reached; campaign: final node cleared
+	Cfg      RenderConfig
+	Profile  *Profile   // lifetime stats and unlocks; nil: not tracked
+	Select   *Selection // the selection screen, before the game starts
+	// Totals add up every dungeon of the run: kills and turns, deepest depth
+	Totals Score
+	// MorguePath is the score file a death is recorded in; "" = none
+	MorguePath string
+	// Headless skips drawing the screens (simulations)
+	Headless bool
+}
+
+// PlayerPolicy picks the player's commands: Next returns the next command
+// line, as typed at the prompt; false means there are no more (the game
+// quits).
+type PlayerPolicy interface {
+	Next(g *Game) (string, bool)
+}
+
+// NewCampaignGame starts a campaign on the overworld map.
+func NewCampaignGame(c *Campaign, cfg RenderConfig) *Game {
+	return &Game{State: StateOverworld, Campaign: c, Cfg: cfg}
+}
+
+// NewSingleGame plays one dungeon and ends.
+func NewSingleGame(w *World, cfg RenderConfig) *Game {
+	w.RenderCfg = cfg
+	return &Game{State: StateDungeon, World: w, Cfg: cfg}
+}
+
+// NewSelectGame opens on the selection screen; sel.begin starts the game.
+// With nothing unlocked yet there is nothing to choose, so it starts at once.
+func NewSelectGame(p *Profile, sel *Selection, cfg RenderConfig) *Game {
+	g := &Game{State: StateSelect, Profile: p, Select: sel, Cfg: cfg}
+	if sel.trivial() {
+		sel.begin(g, sel.Loadout)
+	}
+	return g
+}
+
+// Run loops until the game is over, reading commands from in. End of input
+// quits.
+func (g *Game) Run(in *bufio.Reader) {
+	g.Play(lineInput{in})
+}
+
+// Play loops until the game is over, taking commands from p.
+func (g *Game) Play(p PlayerPolicy) {
+	for g.Step(p) {
+	}
+}
+
+// Step shows the current screen and runs one command from p: in a dungeon,
+// the player's action followed by the monsters' turns and the cleanup
+// (dungeonCommand). It reports whether the game goes on.
+func (g *Game) Step(p PlayerPolicy) bool {
+	if g.State == StateOver {
+		return false
+	}
+	if !g.show() {
+		return g.State != StateOver
+	}
+	line, ok := p.Next(g)
+	if !ok {
+		g.quit()
+		return false
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		g.Handle(line)
+	}
+	return g.State != StateOver
+}
+
+// lineInput is the plain-mode policy: a prompt and a line from stdin.
+type lineInput struct {
+	in *bufio.Reader
+}
+
+func (l lineInput) Next(g *Game) (string, bool) {
+	fmt.Print(g.promptText())
+	line, err := l.in.ReadString('\n')
+	if errors.Is(err, io.EOF) && strings.TrimSpace(line) == "" {
+		return "", false
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		msg("Input error: %v", err)
+		return "", true
+	}
+	return line, true
+}
+
+// show draws the current screen; false if the state changed while drawing
+// (the player was found dead). A headless game draws nothing but still
+// notices the death.
+func (g *Game) show() bool {
+	if g.Headless && g.State != StateDungeon {
+		return true
+	}
+	switch g.State {
+	case StateOverworld:
+		fmt.Println()
+		fmt.Print(g.Campaign.OverworldMap())
+	case StateTown:
+		g.showTown()
+	case StateSelect:
+		fmt.Println()
+		fmt.Print(g.Select.text(g.Profile))
+	case StateDungeon:
+		g.World.updateFOV()
+		if !g.Headless {
+			g.World.Render()
+		}
+		return !g.checkDeath()
+	}
+	return true
+}
+
+// checkDeath ends the dungeon (and the game) if the player has died.
+func (g *Game) checkDeath() bool {
+	if g.State != StateDungeon || g.World.Player.Stats.HP > 0 {
+		return false
+	}
+	msg("Вы погибли. Игра окончена.")
+	g.endDungeon(false)
+	g.recordDeath()
+	return true
+}
+
+func (g *Game) promptText() string {
+	switch g.State {
+	case StateOverworld:
+		return "<<Куда (номер места или T — город), q выход>>: "
+	case StateTown:
+		return "<<Город (buy <n>, rest, bank <n>, i, e <i>, save <file>, leave, q)>>: "
+	case StateSelect:
+		return "<<Новая игра (c <n>, t <n>, m <n>, start, q)>>: "
+	}
+	return "<<Command (w/a/s/d, x wait, l <dir> look, >/< stairs, p pick up, i inv, u use <i> (throw: u <i> <dx> <dy>), t <i> <dir> throw, e equip <i>, c stay|follow|attack <dir> pet, f <i> feed, set <k> <v>, save <file>, q quit)>>: "
+}
+
+// Handle runs one command in the current state.
+func (g *Game) Handle(line string) {
+	parts := strings.Fields(line)
+	if parts[0] == "q" {
+		g.quit()
+		return
+	}
+	switch g.State {
+	case StateSelect:
+		g.Select.command(g, parts)
+	case StateOverworld:
+		g.overworldCommand(parts)
+	case StateTown:
+		g.townCommand(parts)
+	case StateDungeon:
+		g.dungeonCommand(parts)
+	}
+}
+
+// Travel goes to the node with the given marker or ID.
+func (g *Game) Travel(where string) error {
+	var node *Node
+	for _, n := range overworldNodes {
+		if string(n.Marker) == strings.ToUpper(where) || n.ID == where {
+			node = n
+		}
+	}
+	if node == nil {
+		return errUnknownNode
+	}
+	if !g.Campaign.Available(node.ID) {
+		return errNodeLocked
+	}
+	if node.Town {
+		g.Campaign.Node = node.ID
+		g.State = StateTown
+		return nil
+	}
+	g.World = g.Campaign.enterDungeon(node)
+	g.World.RenderCfg = g.Cfg
+	g.State = StateDungeon
+	msg("Вы входите: %s", node.Name)
+	return nil
+}
+
+func (g *Game) overworldCommand(parts []string) {
+	if err := g.Travel(parts[0]); err != nil {
+		msg("%v", err)
+	}
+}
+
+// quit ends the game early. An abandoned run still counts in the profile.
+func (g *Game) quit() {
+	msg("Выход")
+	if g.State == StateSelect {
+		g.State = StateOver
+		return
+	}
+	if g.State == StateDungeon {
+		g.recordDungeon()
+	}
+	g.State = StateOver
+	g.finishRun()
+}
+
+// endDungeon closes the current dungeon: a single run ends, a campaign goes
+// back to the map with the node cleared and the gold added to the campaign's
+// purse (or ends on death or after the final node).
+func (g *Game) endDungeon(won bool) {
+	c := g.Campaign
+	g.recordDungeon()
+	if c == nil || !won {
+		g.Won = won
+		g.State = StateOver
+		g.announceSeed()
+		g.finishRun()
+		return
+	}
+	c.Gold += g.World.Score.Gold
+	if g.World.Pet != nil {
+		c.Pet = g.World.Pet
+	}
+	c.Cleared[c.Node] = true
+	g.World = nil
+	if n, _ := nodeByID(c.Node); n.Final {
+		msg("Последнее логово пало. Кампания пройдена!")
+		g.Won = true
+		g.State = StateOver
+		g.announceSeed()
+		g.finishRun()
+		return
+	}
+	msg("Место пройдено. Золото: %d", c.Gold)
+	g.State = StateOverworld
+	if g.Profile != nil {
+		g.Profile.commit()
+	}
+}
+
+// recordDungeon adds the current dungeon to the run's totals and the
+// profile's lifetime stats.
+func (g *Game) recordDungeon() {
+	if g.World == nil {
+		return
+	}
+	s := g.World.Score
+	g.Totals.Kills += s.Kills
+	g.Totals.Turns += s.Turns
+	g.Totals.Gold += s.Gold
+	g.Totals.Depth = max(g.Totals.Depth, s.Depth)
+	if g.Profile != nil {
+		g.Profile.RecordDungeon(s)
+	}
+}
+
+// finishRun counts the run in the profile and writes it.
+func (g *Game) finishRun() {
+	if g.Profile == nil {
+		return
+	}
+	g.Profile.Stats.Runs++
+	g.Profile.commit()
+}
+
+// seed is what -seed needs to build the same world again: the campaign's
+// seed, or the single dungeon's.
+func (g *Game) seed() (int64, bool) {
+	if g.Campaign != nil {
+		return g.Campaign.Seed, true
+	}
+	if g.World != nil {
+		if src, ok := g.World.rngSrc(); ok {
+			return src.seed, true
+		}
+	}
+	return 0, false
+}
+
+// announceSeed tells the player how to replay (or share) this world.
+func (g *Game) announceSeed() {
+	seed, ok := g.seed()
+	if !ok {
+		return
+	}
+	flags := fmt.Sprintf("-seed %d", seed)
+	if g.Campaign == nil {
+		flags = "-single " + flags
+	}
+	msg("Сид мира: %d (сыграть его снова: go run . %s)", seed, flags)
+}
+
+// ---------- Town ----------
+
+func (g *Game) showTown() {
+	fmt.Println()
+	fmt.Print(g.townText())
+}
+
+// townText is the town screen: the player, the shop and the inn.
+func (g *Game) townText() string {
+	c := g.Campaign
+	var b strings.Builder
+	fmt.Fprintf(&b, "Город. Золото: %d, HP: %d/%d, Lv %d (XP %d/%d)\n", c.Gold, c.Player.Stats.HP, c.Player.Stats.HPMax,
+		c.Player.CharLevel(), c.Player.XP, xpForLevel(c.Player.CharLevel()))
+	b.WriteString("Лавка:\n")
+	for i, s := range shopStock {
+		fmt.Fprintf(&b, "[%d] %s — %d зол.\n", i, itemLabel(s.Item), s.Price)
+	}
+	fmt.Fprintf(&b, "rest — отдых в таверне (полное HP) — %d зол.\n", restPrice)
+	if g.Profile != nil {
+		fmt.Fprintf(&b, "bank <n> — золото в банк для следующих кампаний (в банке %d, в этот забег можно ещё %d)\n",
+			g.Profile.Vault, bankCapPerRun-c.Banked)
+	}
+	return b.String()
+}
+
+func (g *Game) townCommand(parts []string) {
+	c := g.Campaign
+	switch parts[0] {
+	case "buy":
+		idx, ok := indexArg(parts, "buy <n>")
+		if !ok {
+			return
+		}
+		it, err := c.Buy(idx)
+		if err != nil {
+			msg("%v", err)
+			return
+		}
+		msg("Куплено: %s", it.Name)
+	case "rest":
+		if err := c.Rest(); err != nil {
+			msg("%v", err)
+			return
+		}
+		msg("Вы отдохнули и полностью восстановились")
+	case "bank":
+		g.bank(parts)
+	case "i":
+		showInventory(c.Player)
+	case "e":
+		if idx, ok := indexArg(parts, "e <index>"); ok {
+			equipItem(c.Player, idx)
+		}
+	case "save":
+		g.save(parts)
+	case "leave":
+		g.State = StateOverworld
+	default:
+		msg("Неизвестная команда")
+	}
+}
+
+// bank moves campaign gold into the profile's vault, up to the per-run cap.
+func (g *Game) bank(parts []string) {
+	c := g.Campaign
+	if g.Profile == nil {
+		msg("Банк недоступен")
+		return
+	}
+	amount, ok := indexArg(parts, "bank <n>")
+	if !ok {
+		return
+	}
+	if amount <= 0 {
+		msg("bank <n>")
+		return
+	}
+	if amount > c.Gold {
+		msg("%v", errNotEnoughGold)
+		return
+	}
+	taken := g.Profile.Deposit(amount, c.Banked)
+	if taken == 0 {
+		msg("Лимит банка на этот забег исчерпан (%d зол.)", bankCapPerRun)
+		return
+	}
+	c.Gold -= taken
+	c.Banked += taken
+	msg("В банке: %d зол. (+%d)", g.Profile.Vault, taken)
+	g.Profile.commit()
+}
+
+// ---------- Dungeon ----------
+
+// dungeonCommand handles one command in a dungeon. Actions that take a turn
+// run the clock until the player's next action (schedule.go); monsters act
+// meanwhile, as often as their speed allows.
+func (g *Game) dungeonCommand(parts []string) {
+	world := g.World
+	switch cmd := parts[0]; cmd {
+	case "w", "a", "s", "d":
+		dx, dy, _ := stepDir(cmd)
+		world.MoveEntity(world.Player, world.Player.X+dx, world.Player.Y+dy)
+	case "x", ".":
+		msg("Вы ждёте")
+	case "l":
+		g.look(parts)
+		return
+	case ">", "<", "g":
+		won, err := world.TakeStairs(cmd)
+		if err != nil {
+			msg("%v", err)
+			return
+		}
+		if won {
+			msg("Вы выбрались из подземелья. Победа!")
+			g.endDungeon(true)
+			return
+		}
+		msg("Вы на уровне %d", world.Depth)
+	case "p":
+		world.PlayerPickUp()
+	case "i":
+		showInventory(world.Player)
+	case "u":
+		idx, ok := indexArg(parts, "u <index>")
+		if !ok {
+			return
+		}
+		if idx >= 0 && idx < len(world.Player.Inv) && world.Player.Inv[idx].targeted() {
+			if !g.throw(parts, idx) {
+				return // no target or refused: no turn spent
+			}
+			break
+		}
+		world.PlayerUseItem(idx)
+	case "t":
+		if !g.throwDir(parts) {
+			return
+		}
+	case "e":
+		idx, ok := indexArg(parts, "e <index>")
+		if !ok {
+			return
+		}
+		world.PlayerEquip(idx)
+	case "r":
+		if !world.PlayerPray() {
+			return
+		}
+	case "c":
+		world.PetCommand(parts[1:])
+		return
+	case "f":
+		idx, ok := indexArg(parts, "f <index>")
+		if !ok || !world.PlayerFeedPet(idx) {
+			return
+		}
+	case "set":
+		if len(parts) < 3 {
+			msg("set glyphs ascii|unicode | set palette none|standard|colorblind | set hp on|off")
+			return
+		}
+		if err := world.RenderCfg.applySetting(parts[1], parts[2]); err != nil {
+			msg("Ошибка настройки: %v", err)
+			return
+		}
+		g.Cfg = world.RenderCfg
+		if err := SaveRenderConfig(renderConfigPath(), world.RenderCfg); err != nil {
+			msg("Не удалось сохранить настройки: %v", err)
+		}
+		return
+	case "save":
+		g.save(parts)
+		return
+	default:
+		msg("Неизвестная команда")
+	}
+	world.endTurn()
+}
+
+// endTurn follows a player action that took a turn: the monsters act until
+// the player's next action, then the dead are cleared away.
+func (w *World) endTurn() {
+	w.Score.Turns++
+	w.updateFOV()
+	w.endPlayerTurn()
+
+	// Cleanup.
+	w.RemoveDeadEntities()
+}
+
+// stepDir is the step for a w/a/s/d direction.
+func stepDir(dir string) (dx, dy int, ok bool) {
+	switch dir {
+	case "w":
+		return 0, -1, true
+	case "s":
+		return 0, 1, true
+	case "a":
+		return -1, 0, true
+	case "d":
+		return 1, 0, true
+	}
+	return 0, 0, false
+}
+
+// look describes the tile next to the player; it takes no turn.
+func (g *Game) look(parts []string) {
+	w := g.World
+	if len(parts) < 2 {
+		msg("l w|a|s|d")
+		return
+	}
+	dx, dy, ok := stepDir(parts[1])
+	if !ok {
+		msg("l w|a|s|d")
+		return
+	}
+	x, y := w.Player.X+dx, w.Player.Y+dy
+	if !inBounds(w.Tiles, x, y) || w.visibility(x, y) == Unseen {
+		msg("Не видно")
+		return
+	}
+	msg("%s", describeTile(w.Tiles[y][x]))
+}
+
+// throw handles "u <n> <dx> <dy>" for a targeted item: the target is dx, dy
+// away from the player. It reports whether the item was used.
+func (g *Game) throw(parts []string, idx int) bool {
+	w := g.World
+	usage := fmt.Sprintf("u %d <dx> <dy> — куда бросить, относительно игрока", idx)
+	if len(parts) < 4 {
+		msg("%s", usage)
+		return false
+	}
+	dx, errX := strconv.Atoi(parts[2])
+	dy, errY := strconv.Atoi(parts[3])
+	if errX != nil || errY != nil {
+		msg("%s", usage)
+		return false
+	}
+	return w.PlayerThrow(idx, w.Player.X+dx, w.Player.Y+dy)
+}
+
+// throwDir is "t <idx> <dir>": throw any item w/a/s/d (throw.go).
+func (g *Game) throwDir(parts []string) bool {
+	idx, ok := indexArg(parts, "t <index> w|a|s|d")
+	if !ok {
+		return false
+	}
+	if len(parts) < 3 {
+		msg("t <index> w|a|s|d")
+		return false
+	}
+	dx, dy, ok := stepDir(parts[2])
+	if !ok {
+		msg("t <index> w|a|s|d")
+		return false
+	}
+	return g.World.PlayerThrowDir(idx, dx, dy)
+}
+
+func (g *Game) save(parts []string) {
+	if len(parts) < 2 {
+		msg("save <file>")
+		return
+	}
+	if err := g.Save(parts[1]); err != nil {
+		msg("Не удалось сохранить игру: %v", err)
+		return
+	}
+	msg("Игра сохранена в %s", parts[1])
+}
+
+// indexArg parses parts[1] as an index, printing usage or an error.
+func indexArg(parts []string, usage string) (int, bool) {
+	if len(parts) < 2 {
+		msg("%s", usage)
+		return 0, false
+	}
+	idx, err := strconv.Atoi(parts[1])
+	if err != nil {
+		msg("Неверный индекс")
+		return 0, false
+	}
+	return idx, true
+}
+
+func showInventory(p *Entity) {
+	msg("Инвентарь:")
+	for idx, item := range p.Inv {
+		msg("[%d] %s", idx, itemLabel(item))
+	}
+	msg("Оружие: %s, броня: %s (атака %d, защита %d)",
+		slotLabel(p.Weapon), slotLabel(p.Armor), p.EffectiveAttack(), p.EffectiveDefense())
+}
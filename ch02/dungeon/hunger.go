@@ -0,0 +1,108 @@
+package main
+
+import "fmt"
+
+// Hunger. The player's Hunger grows by one every player turn. While sated
+// they regain 1 HP every regenEvery turns; from hungryAt on that stops, and
+// from starvingAt on they lose 1 HP every starveEvery turns instead, which
+// can kill. Food (Effect food, Power = hunger removed) turns up among the
+// floor consumables and is eaten with 'u'.
+//
+// -nohunger switches the whole clock off: no hunger, no regeneration, no
+// starvation and no food on the floor.
+
+const (
+	hungryAt    = 400
+	starvingAt  = 700
+	regenEvery  = 10
+	starveEvery = 10
+)
+
+// hungerOn is false with -nohunger.
+var hungerOn = true
+
+// HungerState is what the status line shows.
+type HungerState int
+
+const (
+	Sated HungerState = iota
+	Hungry
+	Starving
+)
+
+func hungerState(hunger int) HungerState {
+	switch {
+	case hunger >= starvingAt:
+		return Starving
+	case hunger >= hungryAt:
+		return Hungry
+	}
+	return Sated
+}
+
+func (s HungerState) String() string {
+	switch s {
+	case Hungry:
+		return "голоден"
+	case Starving:
+		return "истощён"
+	}
+	return "сыт"
+}
+
+// hungerStatus is the status line field, empty with -nohunger.
+func (w *World) hungerStatus() string {
+	if !hungerOn {
+		return ""
+	}
+	return fmt.Sprintf("  Сытость: %s", hungerState(w.Player.Hunger))
+}
+
+// tickHunger runs at the end of every player turn.
+func (w *World) tickHunger() {
+	if !hungerOn {
+		return
+	}
+	p := w.Player
+	before := hungerState(p.Hunger)
+	p.Hunger++
+	state := hungerState(p.Hunger)
+	if state != before {
+		switch state {
+		case Hungry:
+			msg("Вы проголодались: раны больше не затягиваются")
+		case Starving:
+			msg("Вы истощены от голода!")
+		}
+	}
+	switch state {
+	case Sated:
+		if p.Hunger%regenEvery == 0 && p.Stats.HP < p.Stats.HPMax {
+			p.Stats.HP++
+		}
+	case Starving:
+		if (p.Hunger-starvingAt)%starveEvery == starveEvery-1 {
+			w.starve()
+		}
+	}
+}
+
+// starve takes 1 HP from the starving player.
+func (w *World) starve() {
+	p := w.Player
+	msg("Голод отнимает 1 HP")
+	if p.Stats.HP--; p.Stats.HP > 0 {
+		return
+	}
+	p.Stats.HP = 0
+	p.Alive = false
+	w.Tiles[p.Y][p.X].Entity = nil
+	w.DeathCause = "голод"
+	msg("%s умирает от голода!", p.Name)
+}
+
+// eat applies a food item.
+func (w *World) eat(food Item) {
+	w.Player.Hunger = max(w.Player.Hunger-food.Power, 0)
+	msg("Вы съели: %s. Сытость: %s", food.Name, hungerState(w.Player.Hunger))
+}
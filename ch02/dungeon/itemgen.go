@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Generated items. generateItem rolls a base type (sword, bow, armor,
+// potion) with stats that grow with depth, a rarity and, for rare and epic
+// items, affixes from weighted tables: a prefix adjective ("острый меч") and
+// a suffix ("меч ярости"). Every affix step is worth one point of the
+// rarity's budget (rarityBudget), so an epic can't stack more than a few
+// points of bonuses whatever it rolls. All rolls use the RNG passed in (the world's),
+// so seeded runs generate the same items.
+//
+// spawnItems mixes generated items in with the consumables and monster drop
+// tables can ask for one (Drop.Generated). Rare and epic items have their
+// own map colors (render.go).
+
+// generatedEvery: one floor item in this many is generated.
+const generatedEvery = 3
+
+// Rarity is the item's tier; the zero value is common.
+type Rarity string
+
+const (
+	Common Rarity = ""
+	Rare   Rarity = "rare"
+	Epic   Rarity = "epic"
+)
+
+// rarityWeights: how often each tier is rolled.
+var rarityWeights = []struct {
+	Rarity Rarity
+	Weight int
+}{
+	{Common, 70},
+	{Rare, 25},
+	{Epic, 5},
+}
+
+// rarityAffixes and rarityBudget: affixes per tier and the most bonus points
+// they may add up to.
+var (
+	rarityAffixes = map[Rarity]int{Common: 0, Rare: 1, Epic: 2}
+	rarityBudget  = map[Rarity]int{Common: 0, Rare: 3, Epic: 5}
+)
+
+// String is the label shown in the inventory ("редкость: ...").
+func (r Rarity) String() string {
+	switch r {
+	case Rare:
+		return "редкая"
+	case Epic:
+		return "эпическая"
+	}
+	return "обычная"
+}
+
+// Gender of a base noun; prefixes agree with it.
+type gender int
+
+const (
+	masculine gender = iota
+	feminine
+	neuter
+)
+
+// BaseKind is what a generated item is.
+type BaseKind int
+
+const (
+	BaseSword BaseKind = iota
+	BaseBow
+	BaseArmor
+	BasePotion
+)
+
+// itemBase is one base type: its noun, its stats at depth and how often it
+// is rolled.
+type itemBase struct {
+	Kind   BaseKind
+	Noun   string
+	Gender gender
+	Weight int // roll weight
+	make   func(depth int) Item
+}
+
+var itemBases = []itemBase{
+	{BaseSword, "меч", masculine, 3, func(d int) Item {
+		return Item{Equip: WeaponSlot, AttackBonus: 2 + d/2, Weight: 3}
+	}},
+	{BaseBow, "лук", masculine, 2, func(d int) Item {
+		return Item{Equip: WeaponSlot, AttackBonus: 1 + d/2, Weight: 2}
+	}},
+	{BaseArmor, "броня", feminine, 3, func(d int) Item {
+		return Item{Equip: ArmorSlot, DefenseBonus: 1 + d/2, Weight: 5}
+	}},
+	{BasePotion, "зелье", neuter, 4, func(d int) Item {
+		return Item{Heal: 6 + 2*d, Weight: 1}
+	}},
+}
+
+// Affix is one prefix or suffix. Each step adds the per-step bonuses and
+// costs one budget point; Steps is the most it can roll.
+type Affix struct {
+	Forms   [3]string // prefix: by gender; suffix: Forms[0]
+	Suffix  bool
+	Kinds   []BaseKind
+	Attack  int
+	Defense int
+	Heal    int
+	Lighter int // weight removed
+	Steps   int
+	Weight  int // roll weight
+}
+
+var (
+	gear    = []BaseKind{BaseSword, BaseBow, BaseArmor}
+	weapons = []BaseKind{BaseSword, BaseBow}
+)
+
+var affixes = []Affix{
+	{Forms: [3]string{"острый", "острая", "острое"}, Kinds: weapons, Attack: 1, Steps: 3, Weight: 4},
+	{Forms: [3]string{"прочный", "прочная", "прочное"}, Kinds: []BaseKind{BaseArmor}, Defense: 1, Steps: 3, Weight: 4},
+	{Forms: [3]string{"гоблинский", "гоблинская", "гоблинское"}, Kinds: gear, Lighter: 1, Steps: 1, Weight: 2},
+	{Forms: [3]string{"крепкий", "крепкая", "крепкое"}, Kinds: []BaseKind{BasePotion}, Heal: 2, Steps: 3, Weight: 4},
+	{Forms: [3]string{"ярости"}, Suffix: true, Kinds: weapons, Attack: 1, Steps: 2, Weight: 3},
+	{Forms: [3]string{"стража"}, Suffix: true, Kinds: gear, Defense: 1, Steps: 2, Weight: 3},
+	{Forms: [3]string{"лёгкости"}, Suffix: true, Kinds: gear, Lighter: 1, Steps: 2, Weight: 2},
+	{Forms: [3]string{"жизни"}, Suffix: true, Kinds: []BaseKind{BasePotion}, Heal: 2, Steps: 2, Weight: 3},
+}
+
+func (a Affix) fits(k BaseKind) bool {
+	for _, kk := range a.Kinds {
+		if kk == k {
+			return true
+		}
+	}
+	return false
+}
+
+// generateItem rolls one item for depth.
+func generateItem(r *rand.Rand, depth int) Item {
+	return generateItemOf(r, depth, pickBase(r), pickRarity(r))
+}
+
+func pickBase(r *rand.Rand) itemBase {
+	total := 0
+	for _, b := range itemBases {
+		total += b.Weight
+	}
+	n := r.Intn(total)
+	for _, b := range itemBases {
+		if n -= b.Weight; n < 0 {
+			return b
+		}
+	}
+	return itemBases[0]
+}
+
+func pickRarity(r *rand.Rand) Rarity {
+	total := 0
+	for _, e := range rarityWeights {
+		total += e.Weight
+	}
+	n := r.Intn(total)
+	for _, e := range rarityWeights {
+		if n -= e.Weight; n < 0 {
+			return e.Rarity
+		}
+	}
+	return Common
+}
+
+// generateItemOf rolls the affixes of a base of the given rarity: at most
+// one prefix and one suffix, each step paid from the rarity's budget.
+func generateItemOf(r *rand.Rand, depth int, base itemBase, rarity Rarity) Item {
+	it := base.make(max(depth, 1))
+	it.Rarity = rarity
+	budget := rarityBudget[rarity]
+	var prefix, suffix string
+	// An epic gets one of each; a rare item one of either.
+	var sides []bool
+	switch rarityAffixes[rarity] {
+	case 1:
+		sides = []bool{r.Intn(2) == 1}
+	case 2:
+		sides = []bool{false, true}
+	}
+	for _, isSuffix := range sides {
+		a, ok := pickAffix(r, base.Kind, isSuffix)
+		if !ok || budget == 0 {
+			continue
+		}
+		steps := 1 + r.Intn(min(a.Steps, budget))
+		budget -= steps
+		it.AttackBonus += a.Attack * steps
+		it.DefenseBonus += a.Defense * steps
+		it.Heal += a.Heal * steps
+		it.Weight = max(it.Weight-a.Lighter*steps, 0)
+		if isSuffix {
+			suffix = a.Forms[0]
+		} else {
+			prefix = a.Forms[base.Gender]
+		}
+	}
+	it.Name = composeName(prefix, base.Noun, suffix)
+	return it
+}
+
+// pickAffix rolls a prefix (or suffix) that fits the base kind.
+func pickAffix(r *rand.Rand, kind BaseKind, suffix bool) (Affix, bool) {
+	var pool []Affix
+	total := 0
+	for _, a := range affixes {
+		if a.Suffix == suffix && a.fits(kind) {
+			pool = append(pool, a)
+			total += a.Weight
+		}
+	}
+	if total == 0 {
+		return Affix{}, false
+	}
+	n := r.Intn(total)
+	for _, a := range pool {
+		if n -= a.Weight; n < 0 {
+			return a, true
+		}
+	}
+	return pool[0], true
+}
+
+// composeName joins the parts and capitalizes the first letter.
+func composeName(prefix, noun, suffix string) string {
+	name := strings.Join(strings.Fields(prefix+" "+noun+" "+suffix), " ")
+	first, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(first)) + name[size:]
+}
+
+// rarityLabel is appended to the inventory label of rare and epic items.
+func rarityLabel(it Item) string {
+	if it.Rarity == Common {
+		return ""
+	}
+	return fmt.Sprintf(" [редкость: %s]", it.Rarity)
+}
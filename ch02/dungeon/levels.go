@@ -0,0 +1,221 @@
+package main
+
+import "errors"
+
+// Multi-level dungeon. Every level has its own grid, entities and explored
+// memory; the player carries inventory and HP between them. Monsters live on
+// their level and only act while the player is there.
+
+// dungeonLevels is how many levels a game has.
+const dungeonLevels = 3
+
+// Level is one floor of the dungeon.
+type Level struct {
+	Depth    int // 1-based
+	Tiles    [][]*Tile
+	Entities []*Entity
+	Visible  [][]bool // Player's current field of view; nil = no fog
+	Explored [][]bool // Tiles the player has ever seen
+
+	// Stair positions; up stairs are absent on the first level.
+	UpX, UpY     int
+	DownX, DownY int
+}
+
+// Dungeon holds all levels, top to bottom.
+type Dungeon struct {
+	Levels []*Level
+}
+
+// monsterStats gets tougher with depth.
+func monsterStats(depth int) Stats {
+	d := depth - 1
+	hp := 8 + 4*d
+	return Stats{HPMax: hp, HP: hp, Attack: 3 + 2*d, Defense: d, Speed: 3}
+}
+
+// addLevel appends a new level laid out by w.Gen and makes it current. The
+// floor is always one connected region.
+func (w *World) addLevel() *Level {
+	tiles := make([][]*Tile, w.Height)
+	for y := 0; y < w.Height; y++ {
+		row := make([]*Tile, w.Width)
+		for x := 0; x < w.Width; x++ {
+			row[x] = &Tile{X: x, Y: y, Type: FloorTile}
+		}
+		tiles[y] = row
+	}
+	lvl := &Level{Depth: len(w.Dungeon.Levels) + 1, Tiles: tiles, Entities: make([]*Entity, 0)}
+	w.Dungeon.Levels = append(w.Dungeon.Levels, lvl)
+	w.Level = lvl
+	w.Gen.Generate(tiles, w.Rand)
+	connectFloors(tiles)
+	return lvl
+}
+
+// populate fills the dungeon up to n levels with stairs, monsters, items,
+// gold, doors (doors.go) and now and then a shrine (shrine.go), then returns
+// to the first level and puts the stray dog there (pet.go). The player must
+// already be placed: the first level's down stairs are chosen among tiles
+// reachable from them.
+func (w *World) populate(n int) {
+	for depth := 1; depth <= n; depth++ {
+		if depth > 1 {
+			lvl := w.addLevel()
+			lvl.UpX, lvl.UpY = w.randomFloor()
+			lvl.Tiles[lvl.UpY][lvl.UpX].Type = StairsUpTile
+		}
+		startX, startY := w.UpX, w.UpY
+		if depth == 1 {
+			startX, startY = w.Player.X, w.Player.Y
+		}
+		w.DownX, w.DownY = w.reachableFloor(startX, startY)
+		w.Tiles[w.DownY][w.DownX].Type = StairsDownTile
+
+		spawnMonsters(w, difficulty.Monsters)
+		spawnItems(w, difficulty.Items)
+		spawnGold(w, 4)
+		w.placeDoors(doorsPerLevel)
+		if w.Rand.Intn(treasureRoomOdds) == 0 {
+			w.addTreasureRoom()
+		}
+		if w.Rand.Intn(shrineOdds) == 0 {
+			w.placeShrine()
+		}
+	}
+	w.Level = w.Dungeon.Levels[0]
+	w.placeStray()
+}
+
+// randomFloor picks a random floor tile.
+func (w *World) randomFloor() (int, int) {
+	x := w.Rand.Intn(w.Width-2) + 1
+	y := w.Rand.Intn(w.Height-2) + 1
+	return w.nearestFloor(x, y)
+}
+
+// nearestFloor returns (x, y) if it is floor, else the closest floor tile
+// (scanning rings outward, so the result is deterministic).
+func (w *World) nearestFloor(x, y int) (int, int) {
+	for r := 0; r < max(w.Width, w.Height); r++ {
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx >= 0 && nx < w.Width && ny >= 0 && ny < w.Height && w.Tiles[ny][nx].Type == FloorTile {
+					return nx, ny
+				}
+			}
+		}
+	}
+	return x, y
+}
+
+// reachableFloor picks a random floor tile reachable from (sx, sy), other
+// than the start itself. If the start is the only floor tile, a tile next to
+// it is cleared instead.
+func (w *World) reachableFloor(sx, sy int) (int, int) {
+	type pos struct{ x, y int }
+	seen := map[pos]bool{{sx, sy}: true}
+	queue := []pos{{sx, sy}}
+	var found []pos
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, d := range []pos{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			n := pos{p.x + d.x, p.y + d.y}
+			if seen[n] || w.Tiles[n.y][n.x].Type != FloorTile {
+				continue
+			}
+			seen[n] = true
+			queue = append(queue, n)
+			found = append(found, n)
+		}
+	}
+	if len(found) == 0 {
+		x := sx + 1
+		if x == w.Width-1 {
+			x = sx - 1
+		}
+		w.Tiles[sy][x].Type = FloorTile
+		return x, sy
+	}
+	p := found[w.Rand.Intn(len(found))]
+	return p.x, p.y
+}
+
+// TakeStairs moves the player along the stairs under them: cmd is ">" (down),
+// "<" (up) or "g" (whichever is here). It reports true when the player takes
+// the last level's exit.
+func (w *World) TakeStairs(cmd string) (bool, error) {
+	here := w.Tiles[w.Player.Y][w.Player.X].Type
+	switch {
+	case here == StairsDownTile && (cmd == ">" || cmd == "g"):
+		if w.Depth == len(w.Dungeon.Levels) {
+			return true, nil
+		}
+		w.moveToLevel(w.Dungeon.Levels[w.Depth])
+		return false, nil
+	case here == StairsUpTile && (cmd == "<" || cmd == "g"):
+		w.moveToLevel(w.Dungeon.Levels[w.Depth-2])
+		return false, nil
+	case cmd == ">":
+		return false, errors.New("здесь нет лестницы вниз")
+	case cmd == "<":
+		return false, errors.New("здесь нет лестницы вверх")
+	}
+	return false, errors.New("здесь нет лестницы")
+}
+
+// moveToLevel takes the player (with inventory and HP) to lvl, arriving on
+// the stairs that lead back. A pet close by comes along (pet.go).
+func (w *World) moveToLevel(lvl *Level) {
+	pet := w.followingPet()
+	if pet != nil {
+		w.removeEntity(pet)
+	}
+	w.Tiles[w.Player.Y][w.Player.X].Entity = nil
+	w.removePlayer()
+
+	x, y := lvl.UpX, lvl.UpY
+	if lvl.Depth < w.Depth {
+		x, y = lvl.DownX, lvl.DownY
+	}
+	w.Level = lvl
+	x, y = w.freeNear(x, y)
+	w.PlaceEntity(w.Player, x, y)
+	if pet != nil {
+		x, y = w.floorNear(x, y)
+		w.PlaceEntity(pet, x, y)
+	}
+	w.Score.Depth = max(w.Score.Depth, lvl.Depth)
+}
+
+// removePlayer drops the player from the current level's entity list.
+func (w *World) removePlayer() {
+	kept := w.Entities[:0]
+	for _, e := range w.Entities {
+		if !e.IsPlayer {
+			kept = append(kept, e)
+		}
+	}
+	w.Entities = kept
+}
+
+// freeNear returns (x, y) or the nearest walkable tile around it without an
+// entity (a monster may be standing on the stairs).
+func (w *World) freeNear(x, y int) (int, int) {
+	for r := 0; r < max(w.Width, w.Height); r++ {
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || nx >= w.Width || ny < 0 || ny >= w.Height {
+					continue
+				}
+				if t := w.Tiles[ny][nx]; t.Type != WallTile && t.Entity == nil {
+					return nx, ny
+				}
+			}
+		}
+	}
+	return x, y
+}
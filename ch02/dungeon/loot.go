@@ -0,0 +1,81 @@
+package main
+
+// Monster loot. Each monster kind has a drop table of weighted entries (its
+// bestiary entry, see bestiary.go); when one dies, one entry is rolled with
+// the world RNG (so seeded runs drop the same things) and lands where it
+// died, or on the nearest free floor tile.
+// Drops are picked up with 'p' like any other item.
+
+// Drop is one entry of a drop table: an item, a generated item for the
+// current depth (itemgen.go), a gold pile of GoldMin-GoldMax, or (none of
+// them) nothing at all.
+type Drop struct {
+	Weight    int   `json:"weight"`
+	Item      *Item `json:"item,omitempty"`
+	Generated bool  `json:"generated,omitempty"`
+	GoldMin   int   `json:"gold_min,omitempty"`
+	GoldMax   int   `json:"gold_max,omitempty"`
+}
+
+type DropTable []Drop
+
+// roll picks one entry by weight; false means nothing drops.
+func (t DropTable) roll(w *World) (Item, bool) {
+	total := 0
+	for _, d := range t {
+		total += d.Weight
+	}
+	if total <= 0 {
+		return Item{}, false
+	}
+	n := w.Rand.Intn(total)
+	for _, d := range t {
+		if n -= d.Weight; n >= 0 {
+			continue
+		}
+		switch {
+		case d.Item != nil:
+			return *d.Item, true
+		case d.Generated:
+			return generateItem(w.Rand, w.Depth), true
+		case d.GoldMax > 0:
+			return Item{Name: "Золото", Gold: d.GoldMin + w.Rand.Intn(d.GoldMax-d.GoldMin+1)}, true
+		}
+		return Item{}, false
+	}
+	return Item{}, false
+}
+
+// dropLoot rolls the dead monster's table and leaves the drop on the map.
+func (w *World) dropLoot(dead *Entity) {
+	kind, _ := bestiary.lookup(dead.Name)
+	it, ok := kind.Drops.roll(w)
+	if !ok {
+		return
+	}
+	x, y, ok := w.freeItemTile(dead.X, dead.Y)
+	if !ok {
+		return // nowhere to put it
+	}
+	w.PlaceItem(it, x, y)
+	msg("%s роняет: %s", dead.Name, itemLabel(it))
+}
+
+// freeItemTile returns (x, y) or the nearest floor tile around it with no
+// item and no entity.
+func (w *World) freeItemTile(x, y int) (int, int, bool) {
+	for r := 0; r < max(w.Width, w.Height); r++ {
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || nx >= w.Width || ny < 0 || ny >= w.Height {
+					continue
+				}
+				if t := w.Tiles[ny][nx]; t.Type == FloorTile && t.Item == nil && t.Entity == nil {
+					return nx, ny, true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
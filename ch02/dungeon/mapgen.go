@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Map generation. A MapGenerator lays out walls and floor on a fresh grid;
+// addLevel then runs connectFloors, so whatever the generator produces every
+// floor tile ends up reachable from every other one.
+
+// MapGenerator fills a grid (all floor on entry) with walls. The outer border
+// must end up as wall.
+type MapGenerator interface {
+	Generate(tiles [][]*Tile, r *rand.Rand)
+}
+
+// Generator names for the -gen flag.
+const (
+	GenRooms   = "rooms"
+	GenScatter = "scatter"
+)
+
+// generatorByName returns the generator for a -gen value.
+func generatorByName(name string) (MapGenerator, error) {
+	switch name {
+	case GenRooms:
+		return RoomsGenerator{MaxRooms: 7, MinSize: 3, MaxSize: 7}, nil
+	case GenScatter:
+		return ScatterGenerator{Density: 0.1}, nil
+	}
+	return nil, fmt.Errorf("unknown generator %q", name)
+}
+
+// defaultGenerator is used when none is chosen (and always for the daily).
+func defaultGenerator() MapGenerator {
+	gen, _ := generatorByName(GenRooms)
+	return gen
+}
+
+func fillBorder(tiles [][]*Tile) {
+	h, w := len(tiles), len(tiles[0])
+	for x := 0; x < w; x++ {
+		tiles[0][x].Type = WallTile
+		tiles[h-1][x].Type = WallTile
+	}
+	for y := 0; y < h; y++ {
+		tiles[y][0].Type = WallTile
+		tiles[y][w-1].Type = WallTile
+	}
+}
+
+// ScatterGenerator is the original style: border walls plus randomly
+// sprinkled interior walls covering Density of the map.
+type ScatterGenerator struct {
+	Density float64
+}
+
+func (g ScatterGenerator) Generate(tiles [][]*Tile, r *rand.Rand) {
+	fillBorder(tiles)
+	h, w := len(tiles), len(tiles[0])
+	numWalls := int(float64(w*h) * g.Density)
+	for i := 0; i < numWalls; i++ {
+		x := r.Intn(w-2) + 1
+		y := r.Intn(h-2) + 1
+		tiles[y][x].Type = WallTile
+	}
+}
+
+// RoomsGenerator carves up to MaxRooms non-overlapping rectangular rooms
+// out of solid rock and joins each new room to the previous one with an
+// L-shaped corridor.
+type RoomsGenerator struct {
+	MaxRooms         int
+	MinSize, MaxSize int // room side length, walls excluded
+}
+
+type room struct{ x, y, w, h int }
+
+func (a room) center() (int, int) { return a.x + a.w/2, a.y + a.h/2 }
+
+// overlaps also rejects rooms that would touch, keeping a wall between them.
+func (a room) overlaps(b room) bool {
+	return a.x <= b.x+b.w && b.x <= a.x+a.w && a.y <= b.y+b.h && b.y <= a.y+a.h
+}
+
+func (g RoomsGenerator) Generate(tiles [][]*Tile, r *rand.Rand) {
+	h, w := len(tiles), len(tiles[0])
+	for y := range tiles {
+		for x := range tiles[y] {
+			tiles[y][x].Type = WallTile
+		}
+	}
+
+	var rooms []room
+	for attempt := 0; attempt < g.MaxRooms*5 && len(rooms) < g.MaxRooms; attempt++ {
+		rw := g.MinSize + r.Intn(g.MaxSize-g.MinSize+1)
+		rh := g.MinSize + r.Intn(g.MaxSize-g.MinSize+1)
+		if rw > w-2 || rh > h-2 {
+			continue
+		}
+		nr := room{x: 1 + r.Intn(w-1-rw), y: 1 + r.Intn(h-1-rh), w: rw, h: rh}
+		clash := false
+		for _, other := range rooms {
+			if nr.overlaps(other) {
+				clash = true
+				break
+			}
+		}
+		if clash {
+			continue
+		}
+		carveRect(tiles, nr)
+		if len(rooms) > 0 {
+			px, py := rooms[len(rooms)-1].center()
+			cx, cy := nr.center()
+			carveCorridor(tiles, px, py, cx, cy, r.Intn(2) == 0)
+		}
+		rooms = append(rooms, nr)
+	}
+	if len(rooms) == 0 {
+		// Map too small for the configured rooms: one room filling it
+		carveRect(tiles, room{x: 1, y: 1, w: w - 2, h: h - 2})
+	}
+}
+
+func carveRect(tiles [][]*Tile, a room) {
+	for y := a.y; y < a.y+a.h; y++ {
+		for x := a.x; x < a.x+a.w; x++ {
+			tiles[y][x].Type = FloorTile
+		}
+	}
+}
+
+// carveCorridor digs from (x1, y1) to (x2, y2), horizontal leg first if
+// horizFirst.
+func carveCorridor(tiles [][]*Tile, x1, y1, x2, y2 int, horizFirst bool) {
+	step := func(a, b int) int {
+		if a < b {
+			return 1
+		}
+		return -1
+	}
+	x, y := x1, y1
+	if horizFirst {
+		for ; x != x2; x += step(x, x2) {
+			tiles[y][x].Type = FloorTile
+		}
+	}
+	for ; y != y2; y += step(y, y2) {
+		tiles[y][x].Type = FloorTile
+	}
+	for ; x != x2; x += step(x, x2) {
+		tiles[y][x].Type = FloorTile
+	}
+	tiles[y][x].Type = FloorTile
+}
+
+// floorRegions labels 4-connected non-wall regions, largest first.
+func floorRegions(tiles [][]*Tile) [][][2]int {
+	h, w := len(tiles), len(tiles[0])
+	seen := make([][]bool, h)
+	for y := range seen {
+		seen[y] = make([]bool, w)
+	}
+	var regions [][][2]int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if seen[y][x] || tiles[y][x].Type == WallTile {
+				continue
+			}
+			seen[y][x] = true
+			region := [][2]int{{x, y}}
+			for i := 0; i < len(region); i++ {
+				p := region[i]
+				for _, d := range [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+					nx, ny := p[0]+d[0], p[1]+d[1]
+					if nx < 0 || nx >= w || ny < 0 || ny >= h || seen[ny][nx] || tiles[ny][nx].Type == WallTile {
+						continue
+					}
+					seen[ny][nx] = true
+					region = append(region, [2]int{nx, ny})
+				}
+			}
+			regions = append(regions, region)
+		}
+	}
+	// Largest region first (stable for equal sizes)
+	for i := 1; i < len(regions); i++ {
+		for j := i; j > 0 && len(regions[j]) > len(regions[j-1]); j-- {
+			regions[j], regions[j-1] = regions[j-1], regions[j]
+		}
+	}
+	return regions
+}
+
+// connectFloors joins every isolated floor pocket to the largest region with
+// a corridor to its nearest tile, until the floor is one region.
+func connectFloors(tiles [][]*Tile) {
+	for {
+		regions := floorRegions(tiles)
+		if len(regions) <= 1 {
+			return
+		}
+		main, pocket := regions[0], regions[len(regions)-1]
+		best, from, to := -1, pocket[0], main[0]
+		for _, a := range pocket {
+			for _, b := range main {
+				if d := abs(a[0]-b[0]) + abs(a[1]-b[1]); best < 0 || d < best {
+					best, from, to = d, a, b
+				}
+			}
+		}
+		carveCorridor(tiles, from[0], from[1], to[0], to[1], true)
+	}
+}
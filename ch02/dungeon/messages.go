@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Game messages (combat, pick-ups, errors) all go through msg into one log.
+// In plain mode each message is also printed as it happens; the termbox UI
+// (tui.go) shows the tail of the log in its own panel instead, so messages
+// no longer scroll the map away.
+
+// messageLogSize is how many messages are kept.
+const messageLogSize = 100
+
+type MessageLog struct {
+	lines []string
+	Echo  io.Writer // nil: only keep them
+}
+
+// messages is the game's log; nil drops every message (simulations,
+// simulate.go, where many games run at once and nobody reads them).
+var messages = &MessageLog{Echo: os.Stdout}
+
+// Add appends a message; a multi-line one becomes several entries.
+func (l *MessageLog) Add(text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		l.lines = append(l.lines, line)
+		if l.Echo != nil {
+			fmt.Fprintln(l.Echo, line)
+		}
+	}
+	if extra := len(l.lines) - messageLogSize; extra > 0 {
+		l.lines = append(l.lines[:0], l.lines[extra:]...)
+	}
+}
+
+// Last returns up to n most recent messages, oldest first.
+func (l *MessageLog) Last(n int) []string {
+	return l.lines[max(len(l.lines)-n, 0):]
+}
+
+func msg(format string, a ...any) {
+	if messages == nil {
+		return
+	}
+	messages.Add(fmt.Sprintf(format, a...))
+}
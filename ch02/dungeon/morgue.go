@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Morgue: every run that ends in death is written to the score file
+// (~/.dungeon_scores.json, -morgue overrides) with its seed, how long and how
+// deep it went, the kills and what killed the player. -scores prints the
+// best ten, deepest first and then by kills. A score file that can't be read
+// is moved aside and a fresh one started, as with the profile.
+
+const topScores = 10
+
+var errMorgueReset = errors.New("файл рекордов повреждён, начат новый")
+
+// MorgueEntry is one dead run.
+type MorgueEntry struct {
+	Date  time.Time `json:"date"`
+	Seed  int64     `json:"seed"`
+	Turns int       `json:"turns"`
+	Depth int       `json:"depth"`
+	Kills int       `json:"kills"`
+	Cause string    `json:"cause"`
+}
+
+// morguePath is ~/.dungeon_scores.json, or a file in the working directory
+// if there is no home.
+func morguePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "dungeon_scores.json"
+	}
+	return filepath.Join(home, ".dungeon_scores.json")
+}
+
+// LoadMorgue reads the score file. A missing file has no entries; a file
+// that can't be parsed is moved aside and the entries start empty (the
+// error, wrapping errMorgueReset, says where the old file went).
+func LoadMorgue(path string) ([]MorgueEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err == nil {
+		var entries []MorgueEntry
+		if err = json.Unmarshal(data, &entries); err == nil {
+			return entries, nil
+		}
+	}
+	bad := fmt.Sprintf("%s.bad-%s", path, time.Now().Format("20060102-150405"))
+	if rerr := os.Rename(path, bad); rerr != nil {
+		return nil, fmt.Errorf("%w: %v (не удалось отложить: %v)", errMorgueReset, err, rerr)
+	}
+	return nil, fmt.Errorf("%w: %v (старый сохранён в %s)", errMorgueReset, err, bad)
+}
+
+// RecordMorgue appends e to the score file. A damaged file is reported
+// through the returned error but the entry is still written to a fresh one.
+func RecordMorgue(path string, e MorgueEntry) error {
+	entries, loadErr := LoadMorgue(path)
+	if loadErr != nil && !errors.Is(loadErr, errMorgueReset) {
+		return loadErr
+	}
+	entries = append(entries, e)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	return loadErr
+}
+
+// topMorgue is the best n entries: deepest first, then most kills, then the
+// earliest.
+func topMorgue(entries []MorgueEntry, n int) []MorgueEntry {
+	sorted := append([]MorgueEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Depth != b.Depth {
+			return a.Depth > b.Depth
+		}
+		if a.Kills != b.Kills {
+			return a.Kills > b.Kills
+		}
+		return a.Date.Before(b.Date)
+	})
+	return sorted[:min(n, len(sorted))]
+}
+
+// printScores is the -scores table.
+func printScores(out io.Writer, entries []MorgueEntry) {
+	top := topMorgue(entries, topScores)
+	if len(top) == 0 {
+		fmt.Fprintln(out, "Рекордов пока нет.")
+		return
+	}
+	fmt.Fprintf(out, "%-3s %-16s %5s %6s %6s  %-20s %s\n", "#", "Дата", "Глуб", "Убито", "Ходов", "Причина", "Сид")
+	for i, e := range top {
+		fmt.Fprintf(out, "%-3d %-16s %5d %6d %6d  %-20s %d\n",
+			i+1, e.Date.Local().Format("2006-01-02 15:04"), e.Depth, e.Kills, e.Turns, e.Cause, e.Seed)
+	}
+}
+
+// recordDeath writes the finished run to the game's score file.
+func (g *Game) recordDeath() {
+	if g.MorguePath == "" || g.World == nil {
+		return
+	}
+	seed, _ := g.seed()
+	cause := g.World.DeathCause
+	if cause == "" {
+		cause = "неизвестно"
+	}
+	e := MorgueEntry{
+		Date:  time.Now(),
+		Seed:  seed,
+		Turns: g.Totals.Turns,
+		Depth: g.Totals.Depth,
+		Kills: g.Totals.Kills,
+		Cause: cause,
+	}
+	if err := RecordMorgue(g.MorguePath, e); err != nil {
+		msg("%v", err)
+	}
+}
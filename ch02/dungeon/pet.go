@@ -0,0 +1,337 @@
+package main
+
+import "fmt"
+
+// Companion. The first level of every dungeon has a stray dog; walking into
+// it recruits it. The pet is on the player's side: it acts right after every
+// player action (endPlayerTurn), biting an adjacent monster or else keeping
+// within petFollowDistance of the player along the player's flow map. It
+// takes orders ('c'): stay, follow, or attack the first monster in a
+// direction. It gains a level whenever the player does, eats what it is
+// fed ('f'), follows the player down and up the stairs when close, and goes
+// from dungeon to dungeon with the campaign. A dead pet is gone for the run:
+// World.Pet (and Campaign.Pet) keep pointing at it, so no other dog joins.
+//
+// Sides decide who fights whom (MoveEntity): the player and the pet against
+// the monsters; a stray is on nobody's side. A monster chasing the player
+// goes for the pet instead when the pet is the closer of the two.
+
+// PetOrder is what the pet was told to do.
+type PetOrder string
+
+const (
+	PetFollow PetOrder = "" // zero value: stay close to the player
+	PetStay   PetOrder = "stay"
+	PetAttack PetOrder = "attack" // the target is not saved: a loaded pet follows
+)
+
+const (
+	petFollowDistance = 2  // walking distance the pet keeps to the player
+	petFoodHeal       = 10 // HP a ration or a corpse gives the pet
+)
+
+// newStrayDog is the dog waiting on the first level.
+func newStrayDog() *Entity {
+	return &Entity{
+		Name:  "Пёс",
+		Stats: Stats{HPMax: 15, HP: 15, Attack: 3, Defense: 1, Speed: 6},
+		Level: 1,
+		Pet:   true,
+		Stray: true,
+	}
+}
+
+// faction is a side in a fight.
+type faction int
+
+const (
+	factionNone    faction = iota // strays
+	factionPlayer                 // the player and the pet
+	factionMonster                // everyone else
+)
+
+func (e *Entity) faction() faction {
+	switch {
+	case e.IsPlayer || (e.Pet && !e.Stray):
+		return factionPlayer
+	case e.Pet:
+		return factionNone
+	}
+	return factionMonster
+}
+
+// hostile reports whether a and b fight when one walks into the other.
+func hostile(a, b *Entity) bool {
+	fa, fb := a.faction(), b.faction()
+	return fa != factionNone && fb != factionNone && fa != fb
+}
+
+// placeStray puts a stray dog on a free floor tile of the current level.
+func (w *World) placeStray() {
+	if free := w.freeFloor(false); len(free) > 0 {
+		w.PlaceEntity(newStrayDog(), free[0][0], free[0][1])
+	}
+}
+
+// recruit makes the stray the player's pet.
+func (w *World) recruit(dog *Entity) bool {
+	if w.Pet != nil {
+		msg("%s уже идёт с вами, второму спутнику не место", w.Pet.Name)
+		return false
+	}
+	dog.Stray = false
+	dog.PetOrder = PetFollow
+	w.Pet = dog
+	w.syncPetLevel()
+	msg("%s виляет хвостом и идёт за вами (c — приказы, f <n> — покормить)", dog.Name)
+	return true
+}
+
+// adoptPet brings the campaign's pet into a new dungeon in place of the
+// stray, next to the player. A pet that died earlier in the run stays
+// dead, and there is no stray to replace it.
+func (w *World) adoptPet(pet *Entity) {
+	for _, e := range w.Entities {
+		if e.Stray {
+			w.removeEntity(e)
+			break
+		}
+	}
+	w.Pet = pet
+	if !pet.Alive {
+		return
+	}
+	pet.PetOrder, pet.petTarget = PetFollow, nil
+	x, y := w.floorNear(w.Player.X, w.Player.Y)
+	w.PlaceEntity(pet, x, y)
+	w.syncPetLevel()
+}
+
+// removeEntity takes e off the current level.
+func (w *World) removeEntity(e *Entity) {
+	if w.Tiles[e.Y][e.X].Entity == e {
+		w.Tiles[e.Y][e.X].Entity = nil
+	}
+	kept := w.Entities[:0]
+	for _, o := range w.Entities {
+		if o != e {
+			kept = append(kept, o)
+		}
+	}
+	w.Entities = kept
+}
+
+// floorNear is the floor tile without an entity closest to (x, y).
+func (w *World) floorNear(x, y int) (int, int) {
+	for r := 1; r < max(w.Width, w.Height); r++ {
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				nx, ny := x+dx, y+dy
+				if inBounds(w.Tiles, nx, ny) && w.Tiles[ny][nx].Type == FloorTile && w.Tiles[ny][nx].Entity == nil {
+					return nx, ny
+				}
+			}
+		}
+	}
+	return w.freeNear(x, y)
+}
+
+// petHere reports whether the pet is alive on the current level (it may
+// have been left on another one).
+func (w *World) petHere() bool {
+	if w.Pet == nil || !w.Pet.Alive {
+		return false
+	}
+	for _, e := range w.Entities {
+		if e == w.Pet {
+			return true
+		}
+	}
+	return false
+}
+
+// syncPetLevel raises the pet to the player's level, with the same gains.
+func (w *World) syncPetLevel() {
+	p := w.Pet
+	if p == nil || !p.Alive || w.Player == nil {
+		return
+	}
+	for p.CharLevel() < w.Player.CharLevel() {
+		p.Level = p.CharLevel() + 1
+		p.Stats.HPMax += levelUpHP
+		p.Stats.Attack += levelUpAttack
+		p.Stats.Defense += levelUpDefense
+		p.Stats.HP = min(p.Stats.HP+levelUpHeal, p.Stats.HPMax)
+		msg("%s тоже растёт: уровень %d, HP %d/%d", p.Name, p.Level, p.Stats.HP, p.Stats.HPMax)
+	}
+}
+
+// stepDistance is the Manhattan distance between two entities.
+func stepDistance(a, b *Entity) int {
+	return abs(a.X-b.X) + abs(a.Y-b.Y)
+}
+
+// aggroTarget is who a chasing monster goes for: the pet when it is closer
+// than the player, else the player.
+func (w *World) aggroTarget(m *Entity) *Entity {
+	if w.petHere() && stepDistance(m, w.Pet) < stepDistance(m, w.Player) {
+		return w.Pet
+	}
+	return w.Player
+}
+
+// petTurn is the pet's action after the player's: the ordered attack, else
+// a bite at an adjacent monster, else (unless told to stay) a step towards
+// the player along the shared flow map, built in *flow if needed.
+func (w *World) petTurn(flow **DistanceMap) {
+	if !w.petHere() {
+		return
+	}
+	p := w.Pet
+	if p.PetOrder == PetAttack {
+		t := p.petTarget
+		if t == nil || !t.Alive || w.Tiles[t.Y][t.X].Entity != t {
+			p.PetOrder, p.petTarget = PetFollow, nil
+			msg("%s возвращается к вам", p.Name)
+		} else if stepDistance(p, t) == 1 {
+			w.resolveMelee(p, t)
+			return
+		} else if dx, dy := w.BFSStepTowards(p, t); dx != 0 || dy != 0 {
+			w.MoveEntity(p, p.X+dx, p.Y+dy)
+			return
+		}
+	}
+	if foe := w.adjacentFoe(p); foe != nil {
+		w.resolveMelee(p, foe)
+		return
+	}
+	if p.PetOrder == PetStay {
+		return
+	}
+	if *flow == nil {
+		*flow = w.distanceMapFrom(w.Player.X, w.Player.Y)
+	}
+	if (*flow).At(p.X, p.Y) <= petFollowDistance {
+		return
+	}
+	if dx, dy := (*flow).StepFrom(p.X, p.Y); dx != 0 || dy != 0 {
+		w.MoveEntity(p, p.X+dx, p.Y+dy)
+	}
+}
+
+// adjacentFoe is the first monster next to e, in stepDeltas order, or nil.
+func (w *World) adjacentFoe(e *Entity) *Entity {
+	for _, d := range stepDeltas {
+		nx, ny := e.X+d[0], e.Y+d[1]
+		if !inBounds(w.Tiles, nx, ny) {
+			continue
+		}
+		if o := w.Tiles[ny][nx].Entity; o != nil && hostile(e, o) {
+			return o
+		}
+	}
+	return nil
+}
+
+// followingPet is the pet if it comes along when the player takes the
+// stairs: on this level, not told to stay and within petFollowDistance.
+func (w *World) followingPet() *Entity {
+	if !w.petHere() || w.Pet.PetOrder == PetStay || stepDistance(w.Pet, w.Player) > petFollowDistance {
+		return nil
+	}
+	return w.Pet
+}
+
+// PetCommand gives the pet an order: "stay", "follow" or "attack <dir>"
+// (the first monster in that direction). Orders take no turn.
+func (w *World) PetCommand(args []string) {
+	if !w.petHere() {
+		msg("Спутника рядом нет")
+		return
+	}
+	p := w.Pet
+	if len(args) == 0 {
+		msg("c stay | c follow | c attack <w/a/s/d>")
+		return
+	}
+	switch args[0] {
+	case "stay":
+		p.PetOrder, p.petTarget = PetStay, nil
+		msg("%s остаётся на месте", p.Name)
+	case "follow":
+		p.PetOrder, p.petTarget = PetFollow, nil
+		msg("%s идёт за вами", p.Name)
+	case "attack":
+		var dir string
+		if len(args) > 1 {
+			dir = args[1]
+		}
+		dx, dy, ok := stepDir(dir)
+		if !ok {
+			msg("c attack <w/a/s/d>")
+			return
+		}
+		t := w.foeInLine(dx, dy)
+		if t == nil {
+			msg("В той стороне некого атаковать")
+			return
+		}
+		p.PetOrder, p.petTarget = PetAttack, t
+		msg("%s бросается на %s", p.Name, t.Name)
+	default:
+		msg("c stay | c follow | c attack <w/a/s/d>")
+	}
+}
+
+// foeInLine is the first entity in the (dx, dy) direction from the player
+// if it is a monster; walls and closed doors stop the search.
+func (w *World) foeInLine(dx, dy int) *Entity {
+	x, y := w.Player.X+dx, w.Player.Y+dy
+	for inBounds(w.Tiles, x, y) && !w.Tiles[y][x].Type.blocksMonsters() {
+		if e := w.Tiles[y][x].Entity; e != nil {
+			if hostile(w.Player, e) {
+				return e
+			}
+			return nil
+		}
+		x, y = x+dx, y+dy
+	}
+	return nil
+}
+
+// PlayerFeedPet gives inventory item idx to the pet next to the player:
+// a heal flask heals it as it would the player, food by petFoodHeal. It
+// reports whether the pet ate (and the turn is spent).
+func (w *World) PlayerFeedPet(idx int) bool {
+	if idx < 0 || idx >= len(w.Player.Inv) {
+		msg("Неверный индекс")
+		return false
+	}
+	p := w.Pet
+	if !w.petHere() || stepDistance(p, w.Player) != 1 {
+		msg("Рядом нет вашего спутника")
+		return false
+	}
+	item := w.Player.Inv[idx]
+	heal := item.Heal
+	if item.Effect == EffectFood {
+		heal = max(heal, petFoodHeal)
+	}
+	if heal <= 0 {
+		msg("%s не станет это есть", p.Name)
+		return false
+	}
+	w.Player.Inv = append(w.Player.Inv[:idx], w.Player.Inv[idx+1:]...)
+	p.Stats.HP = min(p.Stats.HP+heal, p.Stats.HPMax)
+	msg("%s съедает %s: HP %d/%d", p.Name, item.Name, p.Stats.HP, p.Stats.HPMax)
+	return true
+}
+
+// describePet is the look text for a dog.
+func describePet(e *Entity) string {
+	if e.Stray {
+		return fmt.Sprintf("Бездомный %s (войдите в него — позвать с собой)", e.Name)
+	}
+	order := map[PetOrder]string{PetFollow: "за вами", PetStay: "ждёт", PetAttack: "в атаке"}[e.PetOrder]
+	return fmt.Sprintf("%s, ваш спутник (HP %d/%d, ур. %d, %s)", e.Name, e.Stats.HP, e.Stats.HPMax, e.CharLevel(), order)
+}
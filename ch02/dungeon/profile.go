@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Profile: what carries over between runs. Lifetime stats (runs, kills,
+// deepest depth, gold banked) unlock starting classes, starting items and
+// map themes (unlocks.go), which the selection screen offers before a new
+// game. Gold banked in town goes to the vault and is handed to the next
+// campaign.
+//
+// The profile lives next to the render profile as JSON. It is written
+// atomically (temp file + rename) after every run, on every unlock and on
+// every deposit. A profile that can't be read is moved aside and a fresh
+// one started, so a bad file never stops the game.
+
+// profileVersion is bumped whenever the profile layout changes; add a step
+// to profileMigrations for the old version at the same time.
+const profileVersion = 1
+
+// bankCapPerRun is how much gold one campaign can put in the vault.
+const bankCapPerRun = 100
+
+var errProfileReset = errors.New("профиль повреждён, начат новый")
+
+// LifetimeStats add up over every run.
+type LifetimeStats struct {
+	Runs       int `json:"runs"`
+	Kills      int `json:"kills"`
+	Deepest    int `json:"deepest"`
+	GoldBanked int `json:"gold_banked"`
+}
+
+type Profile struct {
+	Version  int           `json:"version"`
+	Stats    LifetimeStats `json:"stats"`
+	Vault    int           `json:"vault"`    // banked gold not yet handed out
+	Unlocked []string      `json:"unlocked"` // unlock IDs, in the order reached
+
+	path string
+}
+
+// profilePath sits next to the render profile.
+func profilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "dungeon_profile.json"
+	}
+	return filepath.Join(dir, "dungeon", "profile.json")
+}
+
+func newProfile(path string) *Profile {
+	return &Profile{Version: profileVersion, path: path}
+}
+
+// profileMigration upgrades a decoded profile from one version to the next.
+type profileMigration func(doc map[string]any) error
+
+// profileMigrations[v] turns a version v profile into version v+1.
+var profileMigrations = map[int]profileMigration{}
+
+// LoadProfile reads the profile at path. The result is never nil: a missing
+// file gives a fresh profile, and so does one that can't be read, which is
+// moved aside first (the error, wrapping errProfileReset, says where).
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newProfile(path), nil
+	}
+	if err == nil {
+		var p *Profile
+		if p, err = decodeProfile(data, profileVersion, profileMigrations); err == nil {
+			p.path = path
+			return p, nil
+		}
+	}
+	bad := fmt.Sprintf("%s.bad-%s", path, time.Now().Format("20060102-150405"))
+	if rerr := os.Rename(path, bad); rerr != nil {
+		return newProfile(path), fmt.Errorf("%w: %v (не удалось отложить: %v)", errProfileReset, err, rerr)
+	}
+	return newProfile(path), fmt.Errorf("%w: %v (старый сохранён в %s)", errProfileReset, err, bad)
+}
+
+// decodeProfile parses a profile of any known version, migrating it up to
+// version current step by step.
+func decodeProfile(data []byte, current int, migrations map[int]profileMigration) (*Profile, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	v, ok := doc["version"].(float64)
+	if !ok || v < 1 || v != float64(int(v)) {
+		return nil, errors.New("нет версии профиля")
+	}
+	version := int(v)
+	if version > current {
+		return nil, fmt.Errorf("профиль версии %d новее игры (%d)", version, current)
+	}
+	for ; version < current; version++ {
+		step, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("нет перехода с версии %d", version)
+		}
+		if err := step(doc); err != nil {
+			return nil, fmt.Errorf("переход с версии %d: %w", version, err)
+		}
+	}
+	doc["version"] = current
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	p := &Profile{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if p.Vault < 0 || p.Stats.Runs < 0 || p.Stats.Kills < 0 {
+		return nil, errors.New("отрицательные значения в профиле")
+	}
+	return p, nil
+}
+
+// Save writes the profile atomically: a temp file in the same directory,
+// renamed over the old one.
+func (p *Profile) Save() error {
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), ".profile-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.path)
+}
+
+// RecordDungeon adds a finished (or abandoned) dungeon's kills and depth.
+func (p *Profile) RecordDungeon(s Score) {
+	p.Stats.Kills += s.Kills
+	p.Stats.Deepest = max(p.Stats.Deepest, s.Depth)
+}
+
+// Deposit puts up to amount gold in the vault, limited by what is left of
+// the run's cap (already = gold banked this run). It returns the amount
+// taken.
+func (p *Profile) Deposit(amount, already int) int {
+	amount = min(amount, bankCapPerRun-already)
+	if amount <= 0 {
+		return 0
+	}
+	p.Vault += amount
+	p.Stats.GoldBanked += amount
+	return amount
+}
+
+// Withdraw empties the vault.
+func (p *Profile) Withdraw() int {
+	gold := p.Vault
+	p.Vault = 0
+	return gold
+}
+
+// Has reports whether the unlock has been reached.
+func (p *Profile) Has(id string) bool {
+	for _, u := range p.Unlocked {
+		if u == id {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckUnlocks marks every milestone the stats now reach and returns the
+// newly reached ones.
+func (p *Profile) CheckUnlocks() []Unlock {
+	var fresh []Unlock
+	for _, u := range unlocks {
+		if !p.Has(u.ID) && u.Reached(p.Stats) {
+			p.Unlocked = append(p.Unlocked, u.ID)
+			fresh = append(fresh, u)
+		}
+	}
+	return fresh
+}
+
+// commit announces new unlocks and writes the profile.
+func (p *Profile) commit() {
+	for _, u := range p.CheckUnlocks() {
+		msg("Открыто: %s — %s", u.Name, u.Desc)
+	}
+	if err := p.Save(); err != nil {
+		msg("Не удалось сохранить профиль: %v", err)
+	}
+}
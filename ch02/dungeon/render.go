@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rendering profile: which glyphs and colors are used for each kind of cell.
+// Every glyph/color decision goes through tileStyle, so new kinds only need
+// an entry in the glyph sets and palettes below.
+
+// CellKind classifies what is drawn in a map cell.
+type CellKind int
+
+const (
+	KindFloor CellKind = iota
+	KindWall
+	KindPlayer
+	KindMonster
+	KindItem
+	KindStairsDown
+	KindStairsUp
+	KindDoorClosed
+	KindDoorOpen
+	KindDoorLocked
+	KindItemRare
+	KindItemEpic
+	KindCorpse
+	KindShrine
+	KindShrineUsed
+	KindPet
+	numCellKinds
+)
+
+// blocking reports whether a kind cannot be walked through (closed doors
+// have to be opened first).
+func (k CellKind) blocking() bool {
+	return k == KindWall || k == KindDoorClosed || k == KindDoorLocked
+}
+
+// Glyph sets.
+const (
+	GlyphsASCII   = "ascii"
+	GlyphsUnicode = "unicode"
+)
+
+// Palettes.
+const (
+	PaletteNone       = "none"
+	PaletteStandard   = "standard"
+	PaletteColorblind = "colorblind"
+)
+
+var glyphSets = map[string]map[CellKind]rune{
+	GlyphsASCII: {
+		KindFloor:   '.',
+		KindWall:    '#',
+		KindPlayer:  '@',
+		KindMonster: 'g',
+		KindItem:    '!',
+
+		KindStairsDown: '>',
+		KindStairsUp:   '<',
+		KindDoorClosed: '+',
+		KindDoorOpen:   '\'',
+		KindDoorLocked: '=',
+		KindItemRare:   '!',
+		KindItemEpic:   '!',
+		KindCorpse:     '%',
+		KindShrine:     '_',
+		KindShrineUsed: '-',
+		KindPet:        'd',
+	},
+	GlyphsUnicode: {
+		KindFloor:   '·',
+		KindWall:    '█',
+		KindPlayer:  '@',
+		KindMonster: 'g',
+		KindItem:    '!',
+
+		KindStairsDown: '>',
+		KindStairsUp:   '<',
+		KindDoorClosed: '▯',
+		KindDoorOpen:   '▫',
+		KindDoorLocked: '▣',
+		KindItemRare:   '!',
+		KindItemEpic:   '!',
+		KindCorpse:     '%',
+		KindShrine:     '∩',
+		KindShrineUsed: '∪',
+		KindPet:        'd',
+	},
+}
+
+// palettes map kinds to ANSI SGR codes. The standard palette is the one
+// -color turns on: red monsters, yellow items, cyan stairs, white walls and
+// a green player, with doors in magenta so they don't pass for items; rare
+// items are blue and epic ones orange (itemgen.go), corpses brown, shrines
+// bright white (grey once used), dogs green (pet.go). The colorblind palette
+// avoids red/green pairs and relies on brightness and blue/orange contrast.
+var palettes = map[string]map[CellKind]string{
+	PaletteNone: {},
+	PaletteStandard: {
+		KindFloor:   "90",
+		KindWall:    "37",
+		KindPlayer:  "1;32",
+		KindMonster: "31",
+		KindItem:    "33",
+
+		KindStairsDown: "1;36",
+		KindStairsUp:   "36",
+		KindDoorClosed: "35",
+		KindDoorOpen:   "35",
+		KindDoorLocked: "1;35",
+		KindItemRare:   "1;34",
+		KindItemEpic:   "38;5;208",
+		KindCorpse:     "38;5;94",
+		KindShrine:     "1;97",
+		KindShrineUsed: "37",
+		KindPet:        "32",
+	},
+	PaletteColorblind: {
+		KindFloor:   "90",
+		KindWall:    "1;97",
+		KindPlayer:  "1;94",
+		KindMonster: "1;38;5;208",
+		KindItem:    "1;96",
+
+		KindStairsDown: "1;93",
+		KindStairsUp:   "93",
+		KindDoorClosed: "38;5;180",
+		KindDoorOpen:   "38;5;180",
+		KindDoorLocked: "1;95",
+		KindItemRare:   "1;94",
+		KindItemEpic:   "1;38;5;135",
+		KindCorpse:     "38;5;137",
+		KindShrine:     "1;97",
+		KindShrineUsed: "37",
+		KindPet:        "94",
+	},
+}
+
+// RenderConfig is the active rendering profile.
+type RenderConfig struct {
+	Glyphs  string `json:"glyphs"`
+	Palette string `json:"palette"`
+	ShowHP  bool   `json:"show_hp"`
+}
+
+func DefaultRenderConfig() RenderConfig {
+	return RenderConfig{Glyphs: GlyphsASCII, Palette: PaletteNone}
+}
+
+// Validate checks that glyph set and palette names are known.
+func (c RenderConfig) Validate() error {
+	if _, ok := glyphSets[c.Glyphs]; !ok {
+		return fmt.Errorf("unknown glyph set %q", c.Glyphs)
+	}
+	if _, ok := palettes[c.Palette]; !ok {
+		return fmt.Errorf("unknown palette %q", c.Palette)
+	}
+	return nil
+}
+
+// stdoutIsTerminal reports whether stdout is a character device; colors are
+// dropped when the output goes to a file or a pipe.
+func stdoutIsTerminal() bool {
+	st, err := os.Stdout.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// cellKind decides what is visible on a tile: entities over items over terrain.
+func cellKind(tile *Tile) CellKind {
+	switch {
+	case tile.Type == WallTile:
+		return KindWall
+	case tile.Entity != nil && tile.Entity.IsPlayer:
+		return KindPlayer
+	case tile.Entity != nil && tile.Entity.Pet:
+		return KindPet
+	case tile.Entity != nil:
+		return KindMonster
+	case tile.Item != nil && tile.Item.Rarity == Rare:
+		return KindItemRare
+	case tile.Item != nil && tile.Item.Rarity == Epic:
+		return KindItemEpic
+	case tile.Item != nil && tile.Item.Corpse:
+		return KindCorpse
+	case tile.Item != nil:
+		return KindItem
+	}
+	return terrainKind(tile)
+}
+
+// tileStyle returns the glyph and ANSI color for a tile under cfg. Monsters
+// keep their own bestiary glyph in every glyph set.
+func tileStyle(cfg RenderConfig, tile *Tile) (rune, string) {
+	kind := cellKind(tile)
+	if kind == KindMonster && tile.Entity.Glyph != 0 {
+		return tile.Entity.Glyph, palettes[cfg.Palette][kind]
+	}
+	return glyphSets[cfg.Glyphs][kind], palettes[cfg.Palette][kind]
+}
+
+// hpDigit scales an entity's HP to a single digit 0-9.
+func hpDigit(e *Entity) rune {
+	if e.Stats.HPMax <= 0 || e.Stats.HP <= 0 {
+		return '0'
+	}
+	d := (9*e.Stats.HP + e.Stats.HPMax - 1) / e.Stats.HPMax
+	return rune('0' + d)
+}
+
+// dimSGR is added to the color of explored but not visible tiles.
+const dimSGR = "2"
+
+// terrainKind is what is remembered of an explored tile: no entities or items.
+func terrainKind(tile *Tile) CellKind {
+	switch tile.Type {
+	case WallTile:
+		return KindWall
+	case StairsDownTile:
+		return KindStairsDown
+	case StairsUpTile:
+		return KindStairsUp
+	case DoorClosedTile:
+		return KindDoorClosed
+	case DoorOpenTile:
+		return KindDoorOpen
+	case DoorLockedTile:
+		return KindDoorLocked
+	case ShrineTile:
+		return KindShrine
+	case ShrineUsedTile:
+		return KindShrineUsed
+	}
+	return KindFloor
+}
+
+// terrainNames are what describeTile says about bare terrain.
+var terrainNames = map[CellKind]string{
+	KindWall:       "Стена",
+	KindFloor:      "Пол",
+	KindStairsDown: "Лестница вниз",
+	KindStairsUp:   "Лестница вверх",
+	KindDoorClosed: "Закрытая дверь",
+	KindDoorOpen:   "Открытая дверь",
+	KindDoorLocked: "Запертая дверь",
+	KindShrine:     "Алтарь (r — помолиться)",
+	KindShrineUsed: "Угасший алтарь",
+}
+
+// describeTile is the text counterpart of cellKind, for the look command:
+// a monster with its HP, else an item, else the terrain.
+func describeTile(t *Tile) string {
+	switch {
+	case t.Entity != nil && t.Entity.IsPlayer:
+		return "Это вы"
+	case t.Entity != nil && t.Entity.Pet:
+		return describePet(t.Entity)
+	case t.Entity != nil:
+		return fmt.Sprintf("%s (HP %d/%d)", t.Entity.Name, t.Entity.Stats.HP, t.Entity.Stats.HPMax)
+	case t.Item != nil:
+		return itemLabel(*t.Item)
+	}
+	return terrainNames[terrainKind(t)]
+}
+
+// cellLook decides how a cell is drawn: its glyph, the HP digit shown next
+// to a visible monster (' ' otherwise) and the ANSI color. Unseen cells are
+// blank, explored ones show dimmed terrain only.
+func cellLook(cfg RenderConfig, tile *Tile, vis Visibility) (ch, extra rune, color string) {
+	if vis == Unseen {
+		return ' ', ' ', ""
+	}
+	ch, color = tileStyle(cfg, tile)
+	extra = ' '
+	if vis == Explored {
+		kind := terrainKind(tile)
+		ch, color = glyphSets[cfg.Glyphs][kind], palettes[cfg.Palette][kind]
+		if color != "" {
+			color = dimSGR + ";" + color
+		}
+	} else if tile.Entity != nil && !tile.Entity.IsPlayer && tile.Type != WallTile {
+		extra = hpDigit(tile.Entity)
+	}
+	return ch, extra, color
+}
+
+// renderCell writes one map cell; with ShowHP every cell is two columns wide
+// so the HP digit never hides a neighbour.
+func renderCell(b *strings.Builder, cfg RenderConfig, tile *Tile, vis Visibility) {
+	ch, extra, color := cellLook(cfg, tile, vis)
+	if color != "" {
+		b.WriteString("\033[" + color + "m")
+	}
+	b.WriteRune(ch)
+	if cfg.ShowHP {
+		b.WriteRune(extra)
+	}
+	if color != "" {
+		b.WriteString("\033[0m")
+	}
+}
+
+// renderConfigPath is where the chosen profile is persisted.
+func renderConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "dungeon_render.json"
+	}
+	return filepath.Join(dir, "dungeon", "render.json")
+}
+
+// LoadRenderConfig reads the saved profile, falling back to defaults.
+func LoadRenderConfig(path string) RenderConfig {
+	cfg := DefaultRenderConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil || cfg.Validate() != nil {
+		return DefaultRenderConfig()
+	}
+	return cfg
+}
+
+// SaveRenderConfig writes the profile to path.
+func SaveRenderConfig(path string, cfg RenderConfig) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// applySetting changes one option from the in-game "set" command.
+func (c *RenderConfig) applySetting(key, value string) error {
+	next := *c
+	switch key {
+	case "glyphs":
+		next.Glyphs = value
+	case "palette":
+		next.Palette = value
+	case "hp":
+		switch value {
+		case "on":
+			next.ShowHP = true
+		case "off":
+			next.ShowHP = false
+		default:
+			return fmt.Errorf("hp: expected on/off")
+		}
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if err := next.Validate(); err != nil {
+		return err
+	}
+	*c = next
+	return nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// Save and load. The whole run — every level's tiles, items and entities,
+// fog memory, score and the RNG position — goes to one JSON file, together
+// with the campaign progress if there is one (in town or on the map there is
+// no dungeon, only the campaign). Tiles and entities point at each other in
+// memory; the file only stores coordinates and the links are rebuilt on load.
+
+// saveVersion is bumped whenever the file layout changes.
+const saveVersion = 1
+
+var errUnsavableRNG = errors.New("world RNG is not seeded, the run cannot be saved")
+
+// seededSource is the world's random source: it remembers its seed and how
+// many values were drawn, so a loaded game can replay it to the same state.
+// Both Int63 and Uint64 advance the underlying generator by one step.
+type seededSource struct {
+	seed  int64
+	draws uint64
+	src   rand.Source64
+}
+
+func newSeededSource(seed int64) *seededSource {
+	return &seededSource{seed: seed, src: rand.NewSource(seed).(rand.Source64)}
+}
+
+func (s *seededSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *seededSource) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *seededSource) Seed(seed int64) {
+	s.seed, s.draws = seed, 0
+	s.src.Seed(seed)
+}
+
+// restoreSource reseeds and skips draws values.
+func restoreSource(seed int64, draws uint64) *seededSource {
+	s := newSeededSource(seed)
+	for ; s.draws < draws; s.draws++ {
+		s.src.Uint64()
+	}
+	return s
+}
+
+// Tile types in saved rows, one character per tile.
+var tileCodes = map[TileType]byte{
+	WallTile:       '#',
+	FloorTile:      '.',
+	StairsDownTile: '>',
+	StairsUpTile:   '<',
+	DoorClosedTile: '+',
+	DoorOpenTile:   '\'',
+	DoorLockedTile: '=',
+	ShrineTile:     '_',
+	ShrineUsedTile: '-',
+}
+
+type saveFile struct {
+	Version int          `json:"version"`
+	Seed    int64        `json:"seed"`
+	Draws   uint64       `json:"draws"`
+	Width   int          `json:"width"`
+	Height  int          `json:"height"`
+	Depth   int          `json:"depth"` // level the player is on
+	Score   Score        `json:"score"`
+	Levels  []savedLevel `json:"levels,omitempty"`
+
+	Campaign *Campaign `json:"campaign,omitempty"`
+}
+
+type savedItem struct {
+	X    int  `json:"x"`
+	Y    int  `json:"y"`
+	Item Item `json:"item"`
+}
+
+type savedLevel struct {
+	Depth    int         `json:"depth"`
+	Tiles    []string    `json:"tiles"`
+	Items    []savedItem `json:"items"`
+	Entities []*Entity   `json:"entities"`
+	Visible  []string    `json:"visible,omitempty"` // rows of 0/1; absent = no fog
+	Explored []string    `json:"explored,omitempty"`
+	UpX      int         `json:"up_x"`
+	UpY      int         `json:"up_y"`
+	DownX    int         `json:"down_x"`
+	DownY    int         `json:"down_y"`
+}
+
+func encodeMask(mask [][]bool) []string {
+	if mask == nil {
+		return nil
+	}
+	rows := make([]string, len(mask))
+	for y, row := range mask {
+		var b strings.Builder
+		for _, v := range row {
+			if v {
+				b.WriteByte('1')
+			} else {
+				b.WriteByte('0')
+			}
+		}
+		rows[y] = b.String()
+	}
+	return rows
+}
+
+func decodeMask(rows []string, w, h int) ([][]bool, error) {
+	if rows == nil {
+		return nil, nil
+	}
+	if len(rows) != h {
+		return nil, fmt.Errorf("mask has %d rows, want %d", len(rows), h)
+	}
+	mask := make([][]bool, h)
+	for y, row := range rows {
+		if len(row) != w {
+			return nil, fmt.Errorf("mask row %d has %d columns, want %d", y, len(row), w)
+		}
+		mask[y] = make([]bool, w)
+		for x := range row {
+			mask[y][x] = row[x] == '1'
+		}
+	}
+	return mask, nil
+}
+
+// Save writes the run to path.
+func (w *World) Save(path string) error {
+	return writeSave(path, w, nil)
+}
+
+// Save writes the game: the campaign and/or the current dungeon.
+func (g *Game) Save(path string) error {
+	if g.Campaign != nil && g.World != nil && g.World.Pet != nil {
+		g.Campaign.Pet = g.World.Pet // recruited in this dungeon, maybe dead already
+	}
+	return writeSave(path, g.World, g.Campaign)
+}
+
+func writeSave(path string, w *World, c *Campaign) error {
+	sf := saveFile{Version: saveVersion, Campaign: c}
+	if w != nil {
+		if err := sf.putWorld(w); err != nil {
+			return err
+		}
+	} else if c == nil {
+		return errors.New("nothing to save")
+	}
+	data, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// putWorld fills the dungeon part of the file.
+func (sf *saveFile) putWorld(w *World) error {
+	src, ok := w.rngSrc()
+	if !ok {
+		return errUnsavableRNG
+	}
+	sf.Seed, sf.Draws = src.seed, src.draws
+	sf.Width, sf.Height = w.Width, w.Height
+	sf.Depth, sf.Score = w.Depth, w.Score
+	for _, lvl := range w.Dungeon.Levels {
+		sl := savedLevel{
+			Depth:    lvl.Depth,
+			Entities: lvl.Entities,
+			Visible:  encodeMask(lvl.Visible),
+			Explored: encodeMask(lvl.Explored),
+			UpX:      lvl.UpX,
+			UpY:      lvl.UpY,
+			DownX:    lvl.DownX,
+			DownY:    lvl.DownY,
+		}
+		for _, row := range lvl.Tiles {
+			line := make([]byte, len(row))
+			for x, t := range row {
+				line[x] = tileCodes[t.Type]
+				if t.Item != nil {
+					sl.Items = append(sl.Items, savedItem{X: t.X, Y: t.Y, Item: *t.Item})
+				}
+			}
+			sl.Tiles = append(sl.Tiles, string(line))
+		}
+		sf.Levels = append(sf.Levels, sl)
+	}
+	return nil
+}
+
+// rngSrc returns the world's seeded source, if it has one.
+func (w *World) rngSrc() (*seededSource, bool) {
+	src, ok := w.src.(*seededSource)
+	return src, ok
+}
+
+func readSave(path string) (saveFile, error) {
+	var sf saveFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sf, err
+	}
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return sf, fmt.Errorf("%s: %w", path, err)
+	}
+	if sf.Version != saveVersion {
+		return sf, fmt.Errorf("%s: unsupported save version %d", path, sf.Version)
+	}
+	return sf, nil
+}
+
+// LoadWorld reads a run saved by Save. New levels (none in a normal game)
+// would use gen.
+func LoadWorld(path string, gen MapGenerator) (*World, error) {
+	sf, err := readSave(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := sf.world(gen)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return w, nil
+}
+
+// LoadGame reads any save: a single run resumes in its dungeon, a campaign
+// where it was saved (dungeon, town or the map).
+func LoadGame(path string, gen MapGenerator, cfg RenderConfig) (*Game, error) {
+	sf, err := readSave(path)
+	if err != nil {
+		return nil, err
+	}
+	var w *World
+	if len(sf.Levels) > 0 {
+		if w, err = sf.world(gen); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	c := sf.Campaign
+	if c == nil {
+		if w == nil {
+			return nil, fmt.Errorf("%s: corrupt save: no dungeon and no campaign", path)
+		}
+		return NewSingleGame(w, cfg), nil
+	}
+
+	if c.Cleared == nil {
+		c.Cleared = map[string]bool{}
+	}
+	if _, ok := nodeByID(c.Node); !ok {
+		return nil, fmt.Errorf("%s: corrupt save: unknown node %q", path, c.Node)
+	}
+	g := NewCampaignGame(c, cfg)
+	switch n, _ := nodeByID(c.Node); {
+	case w != nil:
+		c.Player = w.Player // one entity, not the copy stored with the campaign
+		if w.Pet != nil {
+			c.Pet = w.Pet
+		}
+		w.RenderCfg = cfg
+		g.World, g.State = w, StateDungeon
+	case c.Player == nil:
+		return nil, fmt.Errorf("%s: corrupt save: campaign without a player", path)
+	case n.Town:
+		g.State = StateTown
+	}
+	return g, nil
+}
+
+// world rebuilds the World, relinking tiles with their items and entities.
+func (sf saveFile) world(gen MapGenerator) (*World, error) {
+	if sf.Width < 1 || sf.Height < 1 || len(sf.Levels) == 0 || sf.Depth < 1 || sf.Depth > len(sf.Levels) {
+		return nil, errors.New("corrupt save: bad dimensions or depth")
+	}
+	src := restoreSource(sf.Seed, sf.Draws)
+	w := &World{
+		Dungeon:   &Dungeon{},
+		Gen:       gen,
+		Width:     sf.Width,
+		Height:    sf.Height,
+		Rand:      rand.New(src),
+		RenderCfg: DefaultRenderConfig(),
+		Score:     sf.Score,
+		src:       src,
+	}
+	codes := map[byte]TileType{}
+	for t, c := range tileCodes {
+		codes[c] = t
+	}
+
+	for i, sl := range sf.Levels {
+		if sl.Depth != i+1 || len(sl.Tiles) != sf.Height {
+			return nil, fmt.Errorf("corrupt save: level %d", i+1)
+		}
+		lvl := &Level{Depth: sl.Depth, UpX: sl.UpX, UpY: sl.UpY, DownX: sl.DownX, DownY: sl.DownY}
+		for y, row := range sl.Tiles {
+			if len(row) != sf.Width {
+				return nil, fmt.Errorf("corrupt save: level %d row %d", lvl.Depth, y)
+			}
+			tiles := make([]*Tile, sf.Width)
+			for x := range row {
+				t, ok := codes[row[x]]
+				if !ok {
+					return nil, fmt.Errorf("corrupt save: level %d tile %q at (%d,%d)", lvl.Depth, row[x], x, y)
+				}
+				tiles[x] = &Tile{X: x, Y: y, Type: t}
+			}
+			lvl.Tiles = append(lvl.Tiles, tiles)
+		}
+		var err error
+		if lvl.Visible, err = decodeMask(sl.Visible, sf.Width, sf.Height); err != nil {
+			return nil, fmt.Errorf("corrupt save: level %d: %w", lvl.Depth, err)
+		}
+		if lvl.Explored, err = decodeMask(sl.Explored, sf.Width, sf.Height); err != nil {
+			return nil, fmt.Errorf("corrupt save: level %d: %w", lvl.Depth, err)
+		}
+
+		for _, si := range sl.Items {
+			if !inBounds(lvl.Tiles, si.X, si.Y) {
+				return nil, fmt.Errorf("corrupt save: item outside level %d", lvl.Depth)
+			}
+			it := si.Item
+			lvl.Tiles[si.Y][si.X].Item = &it
+		}
+		lvl.Entities = make([]*Entity, 0, len(sl.Entities))
+		for _, e := range sl.Entities {
+			if e == nil || !inBounds(lvl.Tiles, e.X, e.Y) {
+				return nil, fmt.Errorf("corrupt save: entity outside level %d", lvl.Depth)
+			}
+			lvl.Entities = append(lvl.Entities, e)
+			if e.Alive {
+				lvl.Tiles[e.Y][e.X].Entity = e
+			}
+			if e.IsPlayer {
+				if lvl.Depth != sf.Depth || w.Player != nil {
+					return nil, errors.New("corrupt save: player must be on the current level, once")
+				}
+				w.Player = e
+			}
+			if e.faction() == factionPlayer && e.Pet {
+				w.Pet = e
+			}
+		}
+		w.Dungeon.Levels = append(w.Dungeon.Levels, lvl)
+	}
+	if w.Player == nil {
+		return nil, errors.New("corrupt save: no player")
+	}
+	w.Level = w.Dungeon.Levels[sf.Depth-1]
+	return w, nil
+}
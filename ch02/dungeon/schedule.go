@@ -0,0 +1,68 @@
+package main
+
+// Turn scheduling by speed. Every tick each living entity on the level gains
+// energy equal to its Speed and acts whenever it has actThreshold of it,
+// spending that much. A Speed-6 player thus gets two actions for every
+// action of a Speed-3 goblin.
+//
+// A player command is one action: afterwards the clock runs, monsters acting
+// as their energy fills, until the player can act again. The game only
+// prompts then, so input is always the player's action.
+
+// actThreshold is the energy one action costs.
+const actThreshold = 12
+
+// speed is the energy gained per tick. Entities without a Speed act every
+// tick, like everyone did before the scheduler.
+func (e *Entity) speed() int {
+	if e.Stats.Speed <= 0 {
+		return actThreshold
+	}
+	return e.Stats.Speed
+}
+
+// tick advances the clock by one and returns the monsters that act now, in
+// level order, each already charged for its action (a monster faster than
+// actThreshold can appear twice).
+func (w *World) tick() []*Entity {
+	var ready []*Entity
+	for _, e := range w.Entities {
+		if !e.Alive {
+			continue
+		}
+		e.Energy += e.speed()
+		if e.IsPlayer || e.Pet {
+			continue // the pet acts with the player (petTurn)
+		}
+		for e.Energy >= actThreshold {
+			e.Energy -= actThreshold
+			ready = append(ready, e)
+		}
+	}
+	return ready
+}
+
+// endPlayerTurn charges the player for the action just taken, counts down
+// their buffs, advances their hunger (hunger.go), lets the pet act (pet.go)
+// and runs the clock until they can act again (or are dead). The first
+// action of a game is free: the player starts with the move.
+func (w *World) endPlayerTurn() {
+	w.Player.Energy = max(w.Player.Energy-actThreshold, 0)
+	w.Player.tickBuffs()
+	w.tickHunger()
+	w.tickCorpses()
+	var flow *DistanceMap // the player stands still until the next prompt
+	if w.Player.Alive {
+		w.petTurn(&flow)
+	}
+	for w.Player.Alive && w.Player.Energy < actThreshold {
+		for _, m := range w.tick() {
+			if !w.Player.Alive {
+				return
+			}
+			if m.Alive {
+				w.monsterAct(m, &flow)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import "fmt"
+
+// Shrines. Now and then a level has a shrine ('_') on an open floor tile.
+// Standing on it, 'r' prays: the world RNG rolls one outcome from
+// shrineOutcomes — a full heal, a permanent +1 attack, a curse (-1 defense
+// for curseTurns turns) or two monsters summoned next to the shrine. A
+// shrine answers once; after that it is depleted ('-') and stays silent.
+//
+// ('a' would have been the natural key, but it is the step left.)
+
+const (
+	// shrineOdds: one level in shrineOdds gets a shrine.
+	shrineOdds = 3
+	// The curse's defense penalty and how many turns it lasts.
+	curseDefense = 1
+	curseTurns   = 100
+	// shrineSummons is how many monsters a summoning calls up.
+	shrineSummons = 2
+)
+
+// curseName is the curse's buff name (one curse at a time, see addBuff).
+const curseName = "Проклятие"
+
+// ShrineEffect is what praying at a shrine does.
+type ShrineEffect string
+
+const (
+	ShrineHeal   ShrineEffect = "heal"
+	ShrineAttack ShrineEffect = "attack"
+	ShrineCurse  ShrineEffect = "curse"
+	ShrineSummon ShrineEffect = "summon"
+)
+
+// ShrineOutcome is one entry of the outcome table.
+type ShrineOutcome struct {
+	Effect ShrineEffect
+	Weight int
+}
+
+// shrineOutcomes is rolled by weight every time a shrine is used.
+var shrineOutcomes = []ShrineOutcome{
+	{ShrineHeal, 35},
+	{ShrineAttack, 20},
+	{ShrineCurse, 25},
+	{ShrineSummon, 20},
+}
+
+// rollShrine picks an outcome by weight.
+func (w *World) rollShrine() ShrineEffect {
+	total := 0
+	for _, o := range shrineOutcomes {
+		total += o.Weight
+	}
+	n := w.Rand.Intn(total)
+	for _, o := range shrineOutcomes {
+		if n -= o.Weight; n < 0 {
+			return o.Effect
+		}
+	}
+	return shrineOutcomes[0].Effect
+}
+
+// placeShrine turns a random empty floor tile into a shrine. False if the
+// level has none to spare.
+func (w *World) placeShrine() bool {
+	free := w.freeFloor(true)
+	if len(free) == 0 {
+		return false
+	}
+	w.Tiles[free[0][1]][free[0][0]].Type = ShrineTile
+	return true
+}
+
+// PlayerPray uses the shrine the player stands on. False means there was
+// nothing to pray at and no turn was spent.
+func (w *World) PlayerPray() bool {
+	p := w.Player
+	t := w.Tiles[p.Y][p.X]
+	switch t.Type {
+	case ShrineUsedTile:
+		msg("Алтарь безмолвствует")
+		return false
+	case ShrineTile:
+	default:
+		msg("Здесь нет алтаря")
+		return false
+	}
+	t.Type = ShrineUsedTile
+	switch w.rollShrine() {
+	case ShrineHeal:
+		p.Stats.HP = p.Stats.HPMax
+		msg("Тёплый свет исцеляет вас: HP %d/%d", p.Stats.HP, p.Stats.HPMax)
+	case ShrineAttack:
+		p.Stats.Attack++
+		msg("Вы чувствуете прилив сил: атака +1 навсегда")
+	case ShrineCurse:
+		p.addBuff(Buff{Name: curseName, DefenseBonus: -curseDefense, Turns: curseTurns})
+		msg("Алтарь проклинает вас: защита -%d на %d ходов", curseDefense, curseTurns)
+	case ShrineSummon:
+		n := w.summonAround(p.X, p.Y, shrineSummons)
+		msg("%s", summonMessage(n))
+	}
+	return true
+}
+
+// summonAround spawns up to n chasing monsters on the free floor tiles
+// next to (x, y) and returns how many appeared.
+func (w *World) summonAround(x, y, n int) int {
+	summoned := 0
+	for _, d := range stepDeltas {
+		if summoned == n {
+			break
+		}
+		nx, ny := x+d[0], y+d[1]
+		if nx < 0 || nx >= w.Width || ny < 0 || ny >= w.Height {
+			continue
+		}
+		if t := w.Tiles[ny][nx]; t.Type != FloorTile || t.Entity != nil {
+			continue
+		}
+		m := w.monsterKind().spawn(w.monsterTier())
+		difficulty.scale(m)
+		m.AIState = AIChase
+		m.HomeX, m.HomeY = nx, ny
+		w.PlaceEntity(m, nx, ny)
+		summoned++
+	}
+	return summoned
+}
+
+func summonMessage(n int) string {
+	if n == 0 {
+		return "Алтарь гудит, но никто не приходит"
+	}
+	return fmt.Sprintf("Из тьмы вокруг алтаря выходят чудовища: %d", n)
+}
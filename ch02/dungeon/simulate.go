@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Headless simulation for balancing: -simulate N plays N single dungeons
+// with the greedy bot, seeds -seed, -seed+1, ... (1 when -seed is unset),
+// on -workers goroutines, and prints the survival rate, the average depth
+// and turns, and what killed the bot. Nothing is drawn and no message is
+// kept: games run with Headless and a nil message log (messages.go). The
+// other world flags (-difficulty, -gen, -nohunger, -width, ...) apply as in
+// a normal game.
+
+const (
+	// botQuaffPercent: below this share of max HP the bot drinks a heal.
+	botQuaffPercent = 40
+	// maxSimTurns ends a game the bot can't finish (a stuck bot, an
+	// unreachable exit).
+	maxSimTurns = 5000
+)
+
+// causeTimeout is the death cause of a game cut off at maxSimTurns.
+const causeTimeout = "лимит ходов"
+
+// greedyBot plays by simple rules, in order: drink a heal when low on HP,
+// hit an adjacent monster, eat when hungry, pick up what it stands on, take
+// the stairs down it stands on, or else walk to the nearest item or down
+// stairs. It knows the whole level, not only what the player has seen.
+type greedyBot struct{}
+
+func (greedyBot) Next(g *Game) (string, bool) {
+	if g.State != StateDungeon {
+		return "q", true
+	}
+	w := g.World
+	p := w.Player
+	if p.Stats.HP*100 < p.Stats.HPMax*botQuaffPercent {
+		if i := invIndex(p, func(it Item) bool { return it.Heal > 0 && it.Equip == NoSlot && !it.targeted() }); i >= 0 {
+			return fmt.Sprintf("u %d", i), true
+		}
+	}
+	if foe := w.adjacentFoe(p); foe != nil {
+		return dirCommand(foe.X-p.X, foe.Y-p.Y), true
+	}
+	if hungerOn && hungerState(p.Hunger) != Sated {
+		if i := invIndex(p, func(it Item) bool { return it.Effect == EffectFood && !it.Corpse }); i >= 0 {
+			return fmt.Sprintf("u %d", i), true
+		}
+	}
+	dx, dy, ok := w.botStep()
+	switch here := w.Tiles[p.Y][p.X]; {
+	case !ok:
+		return "x", true
+	case dx != 0 || dy != 0:
+		return dirCommand(dx, dy), true
+	case here.Item != nil && !here.Item.Corpse:
+		return "p", true
+	}
+	return ">", true
+}
+
+// invIndex is the index of the first inventory item matching ok, or -1.
+func invIndex(e *Entity, ok func(Item) bool) int {
+	for i, it := range e.Inv {
+		if ok(it) {
+			return i
+		}
+	}
+	return -1
+}
+
+// dirCommand is the w/a/s/d command for a step.
+func dirCommand(dx, dy int) string {
+	switch {
+	case dx > 0:
+		return "d"
+	case dx < 0:
+		return "a"
+	case dy > 0:
+		return "s"
+	}
+	return "w"
+}
+
+// botTarget is what the bot walks to: an item other than a corpse, or the
+// stairs down.
+func botTarget(t *Tile) bool {
+	return (t.Item != nil && !t.Item.Corpse) || t.Type == StairsDownTile
+}
+
+// botStep is the bot's first step towards the nearest target: (0, 0) if it
+// stands on one, false if none can be reached. Closed doors open on the
+// way; locked ones only with a key in the pack.
+func (w *World) botStep() (int, int, bool) {
+	p := w.Player
+	hasKey := keyIndex(p) >= 0
+	first := make([]int, w.Width*w.Height) // index+1 of the first step's tile
+	seen := make([]bool, w.Width*w.Height)
+	start := p.Y*w.Width + p.X
+	seen[start] = true
+	queue := []int{start}
+	for head := 0; head < len(queue); head++ {
+		cur := queue[head]
+		cx, cy := cur%w.Width, cur/w.Width
+		if botTarget(w.Tiles[cy][cx]) {
+			if cur == start {
+				return 0, 0, true
+			}
+			step := first[cur] - 1
+			return step%w.Width - p.X, step/w.Width - p.Y, true
+		}
+		for _, d := range stepDeltas {
+			nx, ny := cx+d[0], cy+d[1]
+			if !inBounds(w.Tiles, nx, ny) {
+				continue
+			}
+			i := ny*w.Width + nx
+			t := w.Tiles[ny][nx].Type
+			if seen[i] || t == WallTile || (t == DoorLockedTile && !hasKey) {
+				continue
+			}
+			seen[i] = true
+			first[i] = first[cur]
+			if cur == start {
+				first[i] = i + 1
+			}
+			queue = append(queue, i)
+		}
+	}
+	return 0, 0, false
+}
+
+// SimResult is one simulated game.
+type SimResult struct {
+	Seed  int64
+	Won   bool
+	Depth int
+	Turns int
+	Cause string // what killed the player; "" if they won
+}
+
+// simulateGame plays the single dungeon of seed with p, headless.
+func simulateGame(seed int64, gen MapGenerator, p PlayerPolicy) SimResult {
+	g := NewSingleGame(newGame(seed, gen), DefaultRenderConfig())
+	g.Headless = true
+	for g.Step(p) {
+		if g.World.Score.Turns >= maxSimTurns {
+			return SimResult{Seed: seed, Depth: g.World.Score.Depth, Turns: g.World.Score.Turns, Cause: causeTimeout}
+		}
+	}
+	res := SimResult{Seed: seed, Won: g.Won, Depth: g.World.Score.Depth, Turns: g.World.Score.Turns}
+	if !g.Won {
+		res.Cause = g.World.DeathCause
+		if res.Cause == "" {
+			res.Cause = "неизвестно"
+		}
+	}
+	return res
+}
+
+// Simulate plays n games with the greedy bot, seeds firstSeed..firstSeed+n-1,
+// on workers goroutines. Results come back in seed order. Messages are off
+// while it runs.
+func Simulate(n int, firstSeed int64, workers int, gen MapGenerator) []SimResult {
+	prev := messages
+	messages = nil
+	defer func() { messages = prev }()
+
+	results := make([]SimResult, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = simulateGame(firstSeed+int64(i), gen, greedyBot{})
+			}
+		}()
+	}
+	for i := range n {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// SimReport sums up a batch of simulated games.
+type SimReport struct {
+	Games    int
+	Survived int
+	AvgDepth float64
+	AvgTurns float64
+	Causes   map[string]int
+}
+
+func summarize(results []SimResult) SimReport {
+	r := SimReport{Games: len(results), Causes: map[string]int{}}
+	if len(results) == 0 {
+		return r
+	}
+	depth, turns := 0, 0
+	for _, res := range results {
+		depth += res.Depth
+		turns += res.Turns
+		if res.Won {
+			r.Survived++
+		} else {
+			r.Causes[res.Cause]++
+		}
+	}
+	r.AvgDepth = float64(depth) / float64(len(results))
+	r.AvgTurns = float64(turns) / float64(len(results))
+	return r
+}
+
+// SurvivalRate is the share of games the bot got out of alive.
+func (r SimReport) SurvivalRate() float64 {
+	if r.Games == 0 {
+		return 0
+	}
+	return float64(r.Survived) / float64(r.Games)
+}
+
+// print writes the report; causes go from the most frequent.
+func (r SimReport) print(out io.Writer, elapsed time.Duration) {
+	fmt.Fprintf(out, "Игр: %d за %v (%.0f игр/с)\n", r.Games, elapsed.Round(time.Millisecond), float64(r.Games)/max(elapsed.Seconds(), 1e-9))
+	fmt.Fprintf(out, "Выжили: %d (%.1f%%)\n", r.Survived, 100*r.SurvivalRate())
+	fmt.Fprintf(out, "Средняя глубина: %.2f, среднее число ходов: %.1f\n", r.AvgDepth, r.AvgTurns)
+	if len(r.Causes) == 0 {
+		return
+	}
+	causes := make([]string, 0, len(r.Causes))
+	for c := range r.Causes {
+		causes = append(causes, c)
+	}
+	sort.Slice(causes, func(i, j int) bool {
+		if r.Causes[causes[i]] != r.Causes[causes[j]] {
+			return r.Causes[causes[i]] > r.Causes[causes[j]]
+		}
+		return causes[i] < causes[j]
+	})
+	fmt.Fprintln(out, "Причины смерти:")
+	for _, c := range causes {
+		fmt.Fprintf(out, "  %-20s %d\n", c, r.Causes[c])
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Targeting mode for ranged and area abilities (so far the thrown bomb). A
+// cursor starts on the player and moves with the movement keys, but only
+// onto visible tiles within the ability's range; the screen highlights the
+// cursor and the area it would hit. Enter checks the line of fire and
+// confirms, Esc cancels: nothing is used up and no turn passes.
+//
+// The plain mode has no cursor: "u <n> <dx> <dy>" aims at the tile dx, dy
+// away from the player and goes through the same checks.
+
+var (
+	errOutOfRange = errors.New("цель слишком далеко")
+	errNotVisible = errors.New("цель не видна")
+	errNoLine     = errors.New("на пути стена — не докинуть")
+)
+
+// Targeting is the cursor of one targeting session.
+type Targeting struct {
+	X, Y   int // cursor
+	Range  int // max distance from the player: dx²+dy² <= Range²
+	Radius int // area of effect around the cursor, 0 for a single tile
+}
+
+// newTargeting starts a cursor on the player.
+func (w *World) newTargeting(rng, radius int) *Targeting {
+	return &Targeting{X: w.Player.X, Y: w.Player.Y, Range: rng, Radius: radius}
+}
+
+// Move shifts the cursor by (dx, dy) unless that leaves the range or the
+// player's view; the cursor then stays where it was.
+func (t *Targeting) Move(w *World, dx, dy int) {
+	nx, ny := t.X+dx, t.Y+dy
+	if w.inRange(nx, ny, t.Range) && w.visibility(nx, ny) == Visible {
+		t.X, t.Y = nx, ny
+	}
+}
+
+// Area is the tiles the ability would hit with the cursor where it is.
+func (t *Targeting) Area(w *World) [][2]int {
+	return w.blastArea(t.X, t.Y, t.Radius)
+}
+
+// inRange reports whether (x, y) is on the map and within rng of the player.
+func (w *World) inRange(x, y, rng int) bool {
+	if x < 0 || x >= w.Width || y < 0 || y >= w.Height {
+		return false
+	}
+	dx, dy := x-w.Player.X, y-w.Player.Y
+	return dx*dx+dy*dy <= rng*rng
+}
+
+// checkTarget is the validation on confirm: in range, in view and with a
+// clear line of fire from the player.
+func (w *World) checkTarget(x, y, rng int) error {
+	switch {
+	case !w.inRange(x, y, rng):
+		return errOutOfRange
+	case w.visibility(x, y) != Visible:
+		return errNotVisible
+	case !w.lineOfFire(w.Player.X, w.Player.Y, x, y):
+		return errNoLine
+	}
+	return nil
+}
+
+// lineOfFire walks the straight line between the two tiles (projectilePath,
+// throw.go); any wall or closed door strictly between them blocks it. FOV
+// can see around corners a thrown object cannot pass, hence the separate
+// check.
+func (w *World) lineOfFire(x0, y0, x1, y1 int) bool {
+	path := projectilePath(x0, y0, x1, y1)
+	for _, p := range path[:max(len(path)-1, 0)] {
+		if opaqueAt(w.Tiles, p[0], p[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectTarget runs a targeting session: next returns keypresses, redraw
+// shows the cursor after each one. It returns the confirmed tile, or ok =
+// false if the player cancelled. A target that fails checkTarget is
+// reported and the session goes on.
+func (w *World) selectTarget(t *Targeting, next func() (rune, termbox.Key), redraw func()) (x, y int, ok bool) {
+	for {
+		redraw()
+		ch, key := next()
+		switch key {
+		case termbox.KeyEsc, termbox.KeyCtrlC:
+			msg("Отменено")
+			return 0, 0, false
+		case termbox.KeyEnter:
+			if err := w.checkTarget(t.X, t.Y, t.Range); err != nil {
+				msg("%v", err)
+				continue
+			}
+			return t.X, t.Y, true
+		}
+		if dir := dirKey(ch, key); dir != 0 {
+			dx, dy, _ := stepDir(string(dir))
+			t.Move(w, dx, dy)
+		}
+	}
+}
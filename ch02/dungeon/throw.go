@@ -0,0 +1,127 @@
+package main
+
+// Throwing: "t <idx> <dir>" sends any inventory item flying w/a/s/d. It
+// travels in a straight line (projectilePath, the same line lineOfFire and
+// the monsters' sight use) until the next tile is a wall or a closed door,
+// it reaches a living entity, or it runs out of range. Then:
+//
+//   - a bomb explodes where it stops, as if thrown with the cursor;
+//   - a potion that hits someone shatters and works on them instead: a heal
+//     flask heals a goblin just as well;
+//   - anything else drops where it stopped (next to the target if that tile
+//     already has an item), so a throw at nothing can be picked up again.
+//
+// A potion that hits nobody lands intact too.
+
+// throwRange is how far items without a Range of their own fly.
+const throwRange = 6
+
+// projectilePath is the straight (Bresenham) line from (x0, y0) to
+// (x1, y1): every tile after the start, the end included.
+func projectilePath(x0, y0, x1, y1 int) [][2]int {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	var path [][2]int
+	for x, y := x0, y0; x != x1 || y != y1; {
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y += sy
+		}
+		path = append(path, [2]int{x, y})
+	}
+	return path
+}
+
+// flight follows a projectile from (x0, y0) towards (x1, y1). It stops on
+// the first living entity (hit) or in front of the first opaque tile and
+// returns where it came down.
+func (w *World) flight(x0, y0, x1, y1 int) (x, y int, hit *Entity) {
+	x, y = x0, y0
+	for _, p := range projectilePath(x0, y0, x1, y1) {
+		if opaqueAt(w.Tiles, p[0], p[1]) {
+			break
+		}
+		x, y = p[0], p[1]
+		if e := w.Tiles[y][x].Entity; e != nil && e.Alive {
+			return x, y, e
+		}
+	}
+	return x, y, nil
+}
+
+// potion reports whether the item shatters on whoever it hits.
+func (it Item) potion() bool {
+	return it.Heal > 0 || it.Effect == EffectStrength
+}
+
+// PlayerThrowDir throws the inventory item at idx in the direction (dx, dy).
+// False means nothing was thrown and no turn passed.
+func (w *World) PlayerThrowDir(idx, dx, dy int) bool {
+	if idx < 0 || idx >= len(w.Player.Inv) {
+		msg("Неверный индекс")
+		return false
+	}
+	item := w.Player.Inv[idx]
+	rng := throwRange
+	if item.Range > 0 {
+		rng = item.Range
+	}
+	p := w.Player
+	w.Player.Inv = append(w.Player.Inv[:idx], w.Player.Inv[idx+1:]...)
+	x, y, hit := w.flight(p.X, p.Y, p.X+dx*rng, p.Y+dy*rng)
+
+	switch {
+	case item.Effect == EffectBomb:
+		w.explode(p, x, y, bombRadius, item.Power)
+	case hit != nil && item.potion():
+		msg("%s разбивается о %s", item.Name, hit.Name)
+		w.splash(item, hit)
+	default:
+		if hit != nil {
+			msg("%s попадает в %s", item.Name, hit.Name)
+		}
+		w.dropThrown(item, x, y)
+	}
+	return true
+}
+
+// splash applies a shattered potion to whoever it hit.
+func (w *World) splash(it Item, e *Entity) {
+	if it.Heal > 0 {
+		healed := min(it.Heal, e.Stats.HPMax-e.Stats.HP)
+		e.Stats.HP += healed
+		msg("%s восстанавливает %d HP", e.Name, healed)
+	}
+	if it.Effect == EffectStrength {
+		e.addBuff(Buff{Name: it.Name, AttackBonus: it.Power, Turns: it.Turns})
+		msg("%s: атака +%d на %d ходов", e.Name, it.Power, it.Turns)
+	}
+}
+
+// dropThrown leaves a thrown item on (x, y), or on the nearest free tile if
+// that one already holds an item.
+func (w *World) dropThrown(it Item, x, y int) {
+	if t := w.Tiles[y][x]; t.Item == nil {
+		t.Item = &it
+		msg("%s падает на пол", it.Name)
+		return
+	}
+	if nx, ny, ok := w.freeItemTile(x, y); ok {
+		w.PlaceItem(it, nx, ny)
+		msg("%s падает на пол", it.Name)
+		return
+	}
+	msg("%s теряется", it.Name)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Full-screen termbox UI: the map (or the overworld / town screen) at the
+// top, the status bar under it and a panel with the last few messages.
+// Input is single keypresses; commands that need a number (use, equip, buy)
+// wait for a digit, look waits for a direction, and ':' opens a line for the
+// rest (save <file>, set ...).
+// -plain keeps the old line-by-line stdout mode.
+
+// logPanelLines is how many messages the log panel shows.
+const logPanelLines = 6
+
+// keyInput turns keypresses into the same command lines the plain mode reads.
+type keyInput struct {
+	pending string // command waiting for its index digit (or direction, for l and "t <n>")
+	cmdLine bool   // typing after ':'
+	buf     []rune
+	target  *Targeting // targeting mode is on (targeting.go)
+}
+
+// Key handles one keypress and returns the command to run, if any.
+func (k *keyInput) Key(state GameState, ch rune, key termbox.Key) string {
+	switch {
+	case k.cmdLine:
+		switch key {
+		case termbox.KeyEnter:
+			line := strings.TrimSpace(string(k.buf))
+			k.cmdLine, k.buf = false, nil
+			return line
+		case termbox.KeyEsc:
+			k.cmdLine, k.buf = false, nil
+		case termbox.KeyBackspace, termbox.KeyBackspace2:
+			if len(k.buf) > 0 {
+				k.buf = k.buf[:len(k.buf)-1]
+			}
+		case termbox.KeySpace:
+			k.buf = append(k.buf, ' ')
+		default:
+			if ch != 0 {
+				k.buf = append(k.buf, ch)
+			}
+		}
+		return ""
+	case k.pending == "c":
+		k.pending = ""
+		switch dir := dirKey(ch, key); {
+		case dir != 0:
+			return "c attack " + string(dir)
+		case ch == 'x':
+			return "c stay"
+		case ch == 'f':
+			return "c follow"
+		}
+		return ""
+	case k.pending == "l" || strings.HasPrefix(k.pending, "t "):
+		cmd := k.pending
+		k.pending = ""
+		if dir := dirKey(ch, key); dir != 0 {
+			return cmd + " " + string(dir)
+		}
+		return ""
+	case k.pending != "":
+		cmd := k.pending
+		k.pending = ""
+		if ch >= '0' && ch <= '9' {
+			if state == StateDungeon && cmd == "t" {
+				k.pending = "t " + string(ch) // now the direction
+				return ""
+			}
+			return cmd + " " + string(ch)
+		}
+		return "" // anything else cancels
+	case ch == ':':
+		k.cmdLine = true
+		return ""
+	}
+
+	if dir := dirKey(0, key); dir != 0 {
+		ch = dir
+	}
+	switch key {
+	case termbox.KeyEsc, termbox.KeyCtrlC:
+		return "q"
+	case termbox.KeyEnter:
+		if state == StateSelect {
+			return "start"
+		}
+	}
+	if ch == 0 {
+		return ""
+	}
+
+	switch state {
+	case StateDungeon:
+		switch ch {
+		case 'u', 'e', 'l', 't', 'c', 'f':
+			k.pending = string(ch)
+			return ""
+		case 'w', 'a', 's', 'd', '>', '<', 'g', 'p', 'i', 'x', '.', 'r', 'q':
+			return string(ch)
+		}
+	case StateTown:
+		switch ch {
+		case 'b':
+			k.pending = "buy"
+		case 'e':
+			k.pending = "e"
+		case 'r':
+			return "rest"
+		case 'l':
+			return "leave"
+		case 'i', 'q':
+			return string(ch)
+		}
+		return ""
+	case StateOverworld:
+		return string(ch) // node markers and q
+	case StateSelect:
+		switch ch {
+		case 'c', 't', 'm':
+			k.pending = string(ch)
+		case 's':
+			return "start"
+		case 'q':
+			return "q"
+		}
+	}
+	return ""
+}
+
+// dirKey maps w/a/s/d and the arrows to a direction letter, 0 for others.
+func dirKey(ch rune, key termbox.Key) rune {
+	switch {
+	case key == termbox.KeyArrowUp:
+		return 'w'
+	case key == termbox.KeyArrowDown:
+		return 's'
+	case key == termbox.KeyArrowLeft:
+		return 'a'
+	case key == termbox.KeyArrowRight:
+		return 'd'
+	case strings.ContainsRune("wasd", ch) && ch != 0:
+		return ch
+	}
+	return 0
+}
+
+// prompt is the bottom line: what the game waits for.
+func (k *keyInput) prompt(state GameState) string {
+	switch {
+	case k.target != nil:
+		return "Цель: wasd/стрелки — прицел, Enter — бросить, Esc — отмена"
+	case k.cmdLine:
+		return ":" + string(k.buf)
+	case k.pending == "l":
+		return "l: куда смотреть (wasd/стрелки, другая клавиша — отмена)"
+	case k.pending == "c":
+		return "c: приказ псу — x ждать, f за мной, wasd/стрелки — атаковать (другая клавиша — отмена)"
+	case strings.HasPrefix(k.pending, "t "):
+		return k.pending + ": куда бросить (wasd/стрелки, другая клавиша — отмена)"
+	case k.pending != "":
+		return k.pending + ": номер предмета (другая клавиша — отмена)"
+	}
+	switch state {
+	case StateOverworld:
+		return "1-3 / T — куда идти, q — выход"
+	case StateTown:
+		return "b<n> купить, r отдых, :bank <n> в банк, i инвентарь, e<n> надеть, l уйти, :save <file>, q выход"
+	case StateSelect:
+		return "c<n> класс, t<n> предмет, m<n> подземелье, Enter — начать, q — выход"
+	}
+	return "wasd/стрелки, x ждать, l<dir> смотреть, > < лестницы, p взять, r молиться, i инв., u<n> исп., t<n><dir> бросить, e<n> надеть, c приказ псу, f<n> покормить, :save <file> :set <k> <v>, q выход"
+}
+
+// RunTermbox plays the game full screen until it is over. It only fails if
+// the terminal cannot be initialized.
+func (g *Game) RunTermbox() error {
+	if err := termbox.Init(); err != nil {
+		return err
+	}
+	defer termbox.Close()
+	termbox.SetOutputMode(termbox.Output256)
+	echo := messages.Echo
+	messages.Echo = nil
+	defer func() { messages.Echo = echo }()
+
+	var in keyInput
+	for g.State != StateOver {
+		if g.State == StateDungeon {
+			g.World.updateFOV()
+			if g.checkDeath() {
+				break
+			}
+		}
+		g.draw(&in)
+		ev := termbox.PollEvent()
+		switch ev.Type {
+		case termbox.EventKey:
+			line := in.Key(g.State, ev.Ch, ev.Key)
+			if line = g.aim(&in, line); line != "" {
+				g.Handle(line)
+			}
+		case termbox.EventError:
+			return ev.Err
+		}
+	}
+
+	// Let the player read how it ended.
+	in = keyInput{}
+	g.draw(&in)
+	drawText(0, screenBottom(), "Игра окончена — любая клавиша", termbox.AttrBold)
+	termbox.Flush()
+	termbox.PollEvent()
+	return nil
+}
+
+// aim runs targeting mode when line uses a targeted item without a target
+// yet and returns the line with the chosen offset ("u 2 3 -1"), or "" if
+// the player cancelled. Other lines pass through.
+func (g *Game) aim(in *keyInput, line string) string {
+	parts := strings.Fields(line)
+	if g.State != StateDungeon || len(parts) != 2 || parts[0] != "u" {
+		return line
+	}
+	w := g.World
+	idx, err := strconv.Atoi(parts[1])
+	if err != nil || idx < 0 || idx >= len(w.Player.Inv) || !w.Player.Inv[idx].targeted() {
+		return line
+	}
+	item := w.Player.Inv[idx]
+	radius := 0
+	if item.Effect == EffectBomb {
+		radius = bombRadius
+	}
+	in.target = w.newTargeting(item.Range, radius)
+	defer func() { in.target = nil }()
+	next := func() (rune, termbox.Key) {
+		for {
+			if ev := termbox.PollEvent(); ev.Type == termbox.EventKey {
+				return ev.Ch, ev.Key
+			}
+		}
+	}
+	x, y, ok := w.selectTarget(in.target, next, func() { g.draw(in) })
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("u %d %d %d", idx, x-w.Player.X, y-w.Player.Y)
+}
+
+func screenBottom() int {
+	_, h := termbox.Size()
+	return h - 1
+}
+
+// draw redraws the whole screen.
+func (g *Game) draw(in *keyInput) {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	y := 0
+	switch g.State {
+	case StateDungeon:
+		y = drawMap(g.World, in.target)
+		drawText(0, y, g.World.StatusLine(), termbox.AttrBold)
+		y++
+	case StateOverworld:
+		y = drawLines(0, g.Campaign.OverworldMap())
+	case StateTown:
+		y = drawLines(0, g.townText())
+	case StateSelect:
+		y = drawLines(0, g.Select.text(g.Profile))
+	default:
+		if g.World != nil {
+			y = drawMap(g.World, nil)
+			drawText(0, y, g.World.StatusLine(), termbox.AttrBold)
+			y++
+		}
+	}
+
+	y++
+	drawText(0, y, "── Сообщения ──", termbox.ColorDarkGray)
+	for i, line := range messages.Last(logPanelLines) {
+		drawText(0, y+1+i, line, termbox.ColorDefault)
+	}
+	drawText(0, screenBottom(), in.prompt(g.State), termbox.ColorDefault)
+	termbox.Flush()
+}
+
+// drawMap draws the current level and returns the first free row. In
+// targeting mode the area the ability would hit gets a background and the
+// cursor is drawn reversed.
+func drawMap(w *World, target *Targeting) int {
+	cfg := w.RenderCfg
+	area := map[[2]int]bool{}
+	if target != nil {
+		for _, at := range target.Area(w) {
+			area[at] = true
+		}
+	}
+	x0, y0, cols, rows := w.view(cfg)
+	for y := y0; y < y0+rows; y++ {
+		col := 0
+		for x := x0; x < x0+cols; x++ {
+			ch, extra, color := cellLook(cfg, w.Tiles[y][x], w.visibility(x, y))
+			fg, bg := sgrAttr(color), termbox.ColorDefault
+			switch {
+			case target != nil && target.X == x && target.Y == y:
+				fg |= termbox.AttrReverse
+			case area[[2]int{x, y}]:
+				bg = termbox.ColorRed
+			}
+			termbox.SetCell(col, y-y0, ch, fg, bg)
+			col++
+			if cfg.ShowHP {
+				termbox.SetCell(col, y-y0, extra, fg, bg)
+				col++
+			}
+		}
+	}
+	return rows
+}
+
+// drawLines draws a multi-line text from row y0 and returns the next row.
+func drawLines(y0 int, text string) int {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		drawText(0, y0+i, line, termbox.ColorDefault)
+	}
+	return y0 + len(lines)
+}
+
+func drawText(x, y int, text string, fg termbox.Attribute) {
+	for _, r := range text {
+		termbox.SetCell(x, y, r, fg, termbox.ColorDefault)
+		x++
+	}
+}
+
+// sgrAttr converts a palette's ANSI SGR code ("1;33", "2;90", "38;5;208")
+// to a termbox attribute for Output256 mode, where color N+1 is palette
+// entry N.
+func sgrAttr(code string) termbox.Attribute {
+	var attr termbox.Attribute
+	parts := strings.Split(code, ";")
+	for i := 0; i < len(parts); i++ {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			continue
+		}
+		switch {
+		case n == 1:
+			attr |= termbox.AttrBold
+		case n == 2:
+			attr |= termbox.AttrDim
+		case n == 38 && i+2 < len(parts) && parts[i+1] == "5":
+			if c, err := strconv.Atoi(parts[i+2]); err == nil {
+				attr = attr&^0x1ff | termbox.Attribute(c+1)
+			}
+			i += 2
+		case n >= 30 && n <= 37:
+			attr = attr&^0x1ff | termbox.Attribute(n-30+1)
+		case n >= 90 && n <= 97:
+			attr = attr&^0x1ff | termbox.Attribute(n-90+9)
+		}
+	}
+	return attr
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unlockables and the selection screen. Every milestone unlocks one thing: a
+// starting class, a starting item or a map theme for -single runs. Before a
+// new game the selection screen offers whatever the profile has unlocked;
+// the warrior and "no item" are always there. Daily runs skip the screen, so
+// everyone starts the same.
+
+// Class is a starting character.
+type Class struct {
+	ID    string
+	Name  string
+	Stats Stats
+	Sight int // added to the sight radius
+}
+
+var warriorClass = Class{ID: "warrior", Name: "Воин", Stats: newPlayer().Stats}
+
+// UnlockKind is what an unlock gives.
+type UnlockKind int
+
+const (
+	UnlockClass UnlockKind = iota
+	UnlockItem
+	UnlockTheme
+)
+
+type Unlock struct {
+	ID      string
+	Kind    UnlockKind
+	Name    string
+	Desc    string // the milestone, shown when it is reached
+	Reached func(LifetimeStats) bool
+
+	Class Class // UnlockClass
+	Item  Item  // UnlockItem
+	Theme Theme // UnlockTheme
+}
+
+var unlocks = []Unlock{
+	{
+		ID: "class_rogue", Kind: UnlockClass, Name: "класс Плут", Desc: "25 побед над монстрами",
+		Reached: func(s LifetimeStats) bool { return s.Kills >= 25 },
+		Class:   Class{ID: "rogue", Name: "Плут", Stats: Stats{HPMax: 24, HP: 24, Attack: 6, Defense: 1, Speed: 7}, Sight: 2},
+	},
+	{
+		ID: "class_knight", Kind: UnlockClass, Name: "класс Рыцарь", Desc: "спуск на 3-й уровень",
+		Reached: func(s LifetimeStats) bool { return s.Deepest >= 3 },
+		Class:   Class{ID: "knight", Name: "Рыцарь", Stats: Stats{HPMax: 36, HP: 36, Attack: 4, Defense: 4, Speed: 4}},
+	},
+	{
+		ID: "item_flask", Kind: UnlockItem, Name: "стартовая фляга", Desc: "3 забега",
+		Reached: func(s LifetimeStats) bool { return s.Runs >= 3 },
+		Item:    Item{Name: "Фляга здоровья", Heal: 8},
+	},
+	{
+		ID: "item_sword", Kind: UnlockItem, Name: "стартовый меч", Desc: "100 золота в банке за всё время",
+		Reached: func(s LifetimeStats) bool { return s.GoldBanked >= 100 },
+		Item:    swordItem,
+	},
+	{
+		ID: "theme_cave", Kind: UnlockTheme, Name: "тема «пещера»", Desc: "5 забегов",
+		Reached: func(s LifetimeStats) bool { return s.Runs >= 5 },
+		Theme:   nodeTheme("forest_cave"),
+	},
+	{
+		ID: "theme_lair", Kind: UnlockTheme, Name: "тема «логово»", Desc: "100 побед над монстрами",
+		Reached: func(s LifetimeStats) bool { return s.Kills >= 100 },
+		Theme:   nodeTheme("mountain_lair"),
+	},
+}
+
+func nodeTheme(id string) Theme {
+	n, _ := nodeByID(id)
+	return n.Theme
+}
+
+// Loadout is what the player picked on the selection screen.
+type Loadout struct {
+	Class Class
+	Item  *Item // nil: none
+	Theme Theme // -single only; the zero Theme is the classic dungeon
+}
+
+// player builds the starting player for the loadout; sight is the radius
+// before the class bonus.
+func (l Loadout) player(sight int) *Entity {
+	p := newPlayer()
+	p.SightRadius = sight + l.Class.Sight
+	if l.Class.ID != "" {
+		p.Stats = l.Class.Stats
+	}
+	if l.Item != nil {
+		p.Inv = append(p.Inv, *l.Item)
+	}
+	return p
+}
+
+// Selection is the state of the selection screen.
+type Selection struct {
+	Classes []Class
+	Items   []Item
+	Themes  []Theme // empty for a campaign
+	Loadout Loadout
+	begin   func(*Game, Loadout) // builds the world or campaign and switches state
+}
+
+// newSelection offers what p has unlocked. Themes are only offered for a
+// single run (the campaign's dungeons have their own).
+func newSelection(p *Profile, single bool, begin func(*Game, Loadout)) *Selection {
+	s := &Selection{Classes: []Class{warriorClass}, begin: begin}
+	if single {
+		s.Themes = []Theme{{}}
+	}
+	for _, u := range unlocks {
+		if !p.Has(u.ID) {
+			continue
+		}
+		switch u.Kind {
+		case UnlockClass:
+			s.Classes = append(s.Classes, u.Class)
+		case UnlockItem:
+			s.Items = append(s.Items, u.Item)
+		case UnlockTheme:
+			if single {
+				s.Themes = append(s.Themes, u.Theme)
+			}
+		}
+	}
+	s.Loadout.Class = warriorClass
+	return s
+}
+
+// trivial reports whether there is nothing to choose yet.
+func (s *Selection) trivial() bool {
+	return len(s.Classes) == 1 && len(s.Items) == 0 && len(s.Themes) <= 1
+}
+
+func themeLabel(t Theme) string {
+	if t.Name == "" {
+		return "классическое подземелье"
+	}
+	return t.Name
+}
+
+// text is the selection screen; the current choice is marked with '*'.
+func (s *Selection) text(p *Profile) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Новая игра. Забегов: %d, побед: %d, глубина: %d, в банке: %d зол.\n",
+		p.Stats.Runs, p.Stats.Kills, p.Stats.Deepest, p.Vault)
+	mark := func(on bool) string {
+		if on {
+			return "*"
+		}
+		return " "
+	}
+	b.WriteString("Класс (c <n>):\n")
+	for i, c := range s.Classes {
+		fmt.Fprintf(&b, "%s[%d] %s — HP %d, атака %d, защита %d\n", mark(c.ID == s.Loadout.Class.ID), i,
+			c.Name, c.Stats.HPMax, c.Stats.Attack, c.Stats.Defense)
+	}
+	if len(s.Items) > 0 {
+		b.WriteString("Стартовый предмет (t <n>, повторно — снять):\n")
+		for i, it := range s.Items {
+			fmt.Fprintf(&b, "%s[%d] %s\n", mark(s.Loadout.Item != nil && s.Loadout.Item.Name == it.Name), i, itemLabel(it))
+		}
+	}
+	if len(s.Themes) > 1 {
+		b.WriteString("Подземелье (m <n>):\n")
+		for i, t := range s.Themes {
+			fmt.Fprintf(&b, "%s[%d] %s\n", mark(t.Name == s.Loadout.Theme.Name), i, themeLabel(t))
+		}
+	}
+	b.WriteString("start — начать\n")
+	return b.String()
+}
+
+// command handles one selection-screen command.
+func (s *Selection) command(g *Game, parts []string) {
+	switch parts[0] {
+	case "c":
+		if idx, ok := choiceArg(parts, "c <n>", len(s.Classes)); ok {
+			s.Loadout.Class = s.Classes[idx]
+		}
+	case "t":
+		idx, ok := choiceArg(parts, "t <n>", len(s.Items))
+		switch {
+		case !ok:
+		case s.Loadout.Item != nil && s.Loadout.Item.Name == s.Items[idx].Name:
+			s.Loadout.Item = nil
+		default:
+			it := s.Items[idx]
+			s.Loadout.Item = &it
+		}
+	case "m":
+		if idx, ok := choiceArg(parts, "m <n>", len(s.Themes)); ok {
+			s.Loadout.Theme = s.Themes[idx]
+		}
+	case "start":
+		s.begin(g, s.Loadout)
+	default:
+		msg("Неизвестная команда")
+	}
+}
+
+// choiceArg is indexArg for a list of n options.
+func choiceArg(parts []string, usage string, n int) (int, bool) {
+	idx, ok := indexArg(parts, usage)
+	if ok && (idx < 0 || idx >= n) {
+		msg("Нет такого варианта")
+		return 0, false
+	}
+	return idx, ok
+}
@@ -0,0 +1,68 @@
+package main
+
+import "fmt"
+
+// Map size and the viewport. Levels are mapWidth x mapHeight tiles (the
+// -width and -height flags). A map that fits in viewCols x viewRows cells
+// is drawn whole; a bigger one is drawn through a window of that size that
+// follows the player and stops at the map edges.
+
+const (
+	defaultMapWidth  = 25
+	defaultMapHeight = 12
+	// Smallest map the generators can still carve rooms, vaults and
+	// stairs into.
+	minMapWidth  = 20
+	minMapHeight = 10
+
+	// viewCols x viewRows is the screen area for the map, in terminal
+	// columns and rows.
+	viewCols = 80
+	viewRows = 24
+)
+
+// mapWidth and mapHeight are the size of newly generated levels.
+var (
+	mapWidth  = defaultMapWidth
+	mapHeight = defaultMapHeight
+)
+
+// validateMapSize checks the -width and -height flags.
+func validateMapSize(width, height int) error {
+	if width < minMapWidth || height < minMapHeight {
+		return fmt.Errorf("map must be at least %dx%d, got %dx%d", minMapWidth, minMapHeight, width, height)
+	}
+	return nil
+}
+
+// Viewport returns the top-left tile of a viewW x viewH window on a
+// mapW x mapH map, centered on (px, py) as far as the map edges allow. A
+// map no bigger than the window along an axis starts at 0 on that axis.
+func Viewport(mapW, mapH, viewW, viewH, px, py int) (x0, y0 int) {
+	return scrollOffset(mapW, viewW, px), scrollOffset(mapH, viewH, py)
+}
+
+// scrollOffset centers p in a window of size view along one axis and
+// clamps the window to [0, size).
+func scrollOffset(size, view, p int) int {
+	if size <= view {
+		return 0
+	}
+	return min(max(p-view/2, 0), size-view)
+}
+
+// view is the part of the level the renderers draw: the tiles
+// [x0, x0+cols) x [y0, y0+rows). With ShowHP every cell takes two columns,
+// so half as many tiles fit across.
+func (w *World) view(cfg RenderConfig) (x0, y0, cols, rows int) {
+	cols = viewCols
+	if cfg.ShowHP {
+		cols /= 2
+	}
+	cols, rows = min(cols, w.Width), min(viewRows, w.Height)
+	if w.Player == nil {
+		return 0, 0, cols, rows
+	}
+	x0, y0 = Viewport(w.Width, w.Height, cols, rows, w.Player.X, w.Player.Y)
+	return x0, y0, cols, rows
+}
@@ -0,0 +1,57 @@
+package main
+
+import "math"
+
+// Experience. Every kill is worth XP based on the monster's stats; enough XP
+// raises the player's level, which makes them tougher and heals a little.
+// Dungeons built for a stronger player get stronger monsters (see
+// spawnMonsters), so later floors stay dangerous.
+
+// Level-up gains.
+const (
+	levelUpHP      = 5
+	levelUpAttack  = 1
+	levelUpDefense = 1
+	levelUpHeal    = 5
+)
+
+// xpReward is what killing m is worth.
+func xpReward(m *Entity) int {
+	return m.Stats.HPMax/2 + m.Stats.Attack + m.Stats.Defense
+}
+
+// xpForLevel is the total XP needed to go from level to level+1: 20, 60,
+// 120, 200... on normal, scaled by the difficulty's XPMult.
+func xpForLevel(level int) int {
+	return int(math.Round(float64(10*level*(level+1)) * difficulty.XPMult))
+}
+
+// CharLevel is the entity's level; entities from before levels existed are
+// level 1.
+func (e *Entity) CharLevel() int {
+	return max(e.Level, 1)
+}
+
+// gainXP adds xp and applies every level-up it earns.
+func gainXP(e *Entity, xp int) {
+	e.XP += xp
+	for e.XP >= xpForLevel(e.CharLevel()) {
+		e.Level = e.CharLevel() + 1
+		e.Stats.HPMax += levelUpHP
+		e.Stats.Attack += levelUpAttack
+		e.Stats.Defense += levelUpDefense
+		e.Stats.HP = min(e.Stats.HP+levelUpHeal, e.Stats.HPMax)
+		msg("Новый уровень: %d! HP %d/%d, атака %d, защита %d",
+			e.Level, e.Stats.HP, e.Stats.HPMax, e.Stats.Attack, e.Stats.Defense)
+	}
+}
+
+// monsterTier is the monsterStats argument for this world: the depth, the
+// theme's difficulty and one extra step for every two player levels.
+func (w *World) monsterTier() int {
+	tier := w.Depth + w.Theme.Difficulty
+	if w.Player != nil {
+		tier += (w.Player.CharLevel() - 1) / 2
+	}
+	return tier
+}
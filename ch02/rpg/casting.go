@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Casting. A skill with CastTime > 0 does not resolve when used: the caster
+// pays the MP and starts casting. Each of their following turns counts one
+// down and is spent on the cast; when it reaches zero the skill resolves at
+// the start of that turn (targets are picked again then, so a dead target
+// is replaced) and the caster acts normally. The cast lives on the
+// Character, so it carries over round boundaries.
+//
+// A caster hit for at least interruptMinFraction of HPMax rolls to be
+// interrupted, with a chance of interruptScale times the hit's share of
+// HPMax. A stun always interrupts. An interrupted cast is cancelled and
+// half its MP is refunded.
+//
+// The AI goes for casting foes first.
+
+const (
+	interruptMinFraction = 0.10
+	interruptScale       = 2.0
+)
+
+// Cast is a skill being cast.
+type Cast struct {
+	Skill     Skill
+	Target    *Character // The chosen target; re-resolved when the cast ends
+	TurnsLeft int
+}
+
+// Stunned reports whether a stun effect makes the character lose its turn.
+func (c *Character) Stunned() bool {
+	for _, e := range c.Effects {
+		if e.Stun {
+			return true
+		}
+	}
+	return false
+}
+
+// BeginCast pays for skill idx and starts casting it at target.
+func (c *Character) BeginCast(idx int, target *Character, logFunc func(string)) {
+	if idx < 0 || idx >= len(c.Skills) {
+		logFunc(tr("skill_invalid", c.Name))
+		return
+	}
+	s := c.Skills[idx]
+	if !c.UseMP(s.MPCost) {
+		logFunc(tr("skill_no_mp", c.Name, s.Name))
+		return
+	}
+	c.Casting = &Cast{Skill: s, Target: target, TurnsLeft: s.CastTime}
+	logFunc(tr("cast_begin", c.Name, s.Name, s.CastTime))
+}
+
+// advanceCast runs at the start of the caster's turn. It reports whether
+// the turn went into a cast that is still going.
+func (b *Battle) advanceCast(c *Character, logFunc func(string)) bool {
+	cast := c.Casting
+	if cast == nil {
+		return false
+	}
+	cast.TurnsLeft--
+	if cast.TurnsLeft > 0 {
+		logFunc(tr("cast_continue", c.Name, cast.Skill.Name, cast.TurnsLeft))
+		return true
+	}
+	c.Casting = nil
+	c.resolveSkill(cast.Skill, resolveTargets(c, cast.Skill, cast.Target, b), logFunc)
+	return false
+}
+
+// interruptCast cancels the cast and refunds half its MP.
+func (c *Character) interruptCast(logFunc func(string)) {
+	cast := c.Casting
+	if cast == nil {
+		return
+	}
+	c.Casting = nil
+	refund := cast.Skill.MPCost / 2
+	c.Stats.MP += refund
+	c.clampVitals()
+	logFunc(tr("cast_interrupted", c.Name, cast.Skill.Name, refund))
+}
+
+// checkInterrupt rolls for an interruption after the caster took dealt
+// damage.
+func (c *Character) checkInterrupt(dealt int, logFunc func(string)) {
+	if c.Casting == nil {
+		return
+	}
+	share := float64(dealt) / float64(c.Stats.HPMax)
+	if share < interruptMinFraction {
+		return
+	}
+	if rng.Float64() < share*interruptScale {
+		c.interruptCast(logFunc)
+	}
+}
+
+// castingFoe is the first foe of actor who is casting, or nil.
+func (b *Battle) castingFoe(actor *Character) *Character {
+	for _, c := range b.hostileFighters(actor.Team) {
+		if c.Casting != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// logCasting adds who is casting what to the round header.
+func (b *Battle) logCasting(logFunc func(string)) {
+	var casts []string
+	for _, name := range b.order {
+		for _, c := range b.Teams[name] {
+			if c.Fighting() && c.Casting != nil {
+				casts = append(casts, fmt.Sprintf("%s (%s, %d)", c.Name, c.Casting.Skill.Name, c.Casting.TurnsLeft))
+			}
+		}
+	}
+	if len(casts) > 0 {
+		logFunc(tr("round_casting", strings.Join(casts, ", ")))
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Controllers decide what a character does with its action; the battle then
+// executes the decision. Enemies are always driven by the AI. With Controls
+// set, the player team's characters are driven by a manual controller unless
+// they are on auto-battle.
+//
+// Auto-battle is toggled per character, and changes made during a round take
+// effect at the start of the next one. A controller only decides: HP, MP and
+// effects live on the Character, so swapping controllers loses no state.
+
+// basicAttack is the Action.Skill of a plain weapon attack; substitute
+// swaps the actor with Action.Target from the bench (see roster.go).
+const (
+	basicAttack = -1
+	substitute  = -2
+)
+
+// Action is one decision: a skill index (or basicAttack) and the chosen
+// target. A nil Target means there is nothing to do.
+type Action struct {
+	Skill  int
+	Target *Character
+}
+
+type Controller interface {
+	Decide(b *Battle, actor *Character) Action
+}
+
+// AIController is the built-in tactics: revive a fallen ally if possible,
+// otherwise a 50% chance of a random usable skill, otherwise attack.
+type AIController struct{}
+
+func (AIController) Decide(b *Battle, actor *Character) Action {
+	targets, allies := b.pickHostileTeam(actor.Team), b.Teams[actor.Team]
+
+	// A fallen ally comes first: revive beats any heal or attack
+	if fallen := chooseFirstDead(allies); fallen != nil {
+		if idx := actor.reviveSkillIdx(); idx >= 0 {
+			return Action{Skill: idx, Target: fallen}
+		}
+	}
+	// Improved AI: 50% chance to use random skill if possible, else basic attack
+	if len(actor.Skills) > 0 && rng.Float64() < 0.5 {
+		// Choose random skill with enough MP
+		skillIdx := rng.Intn(len(actor.Skills))
+		s := actor.Skills[skillIdx]
+		if actor.Stats.MP >= s.MPCost && !s.IsRevive() && !(s.IsBuff() && actor.HasEffect(s.Effect.ID)) {
+			chosen := b.aiTarget(actor, targets)
+			if s.Mode().TargetsAllies() {
+				chosen = actor // Heals and buffs go on self
+			}
+			return Action{Skill: skillIdx, Target: chosen}
+		}
+	}
+	return Action{Skill: basicAttack, Target: b.aiTarget(actor, targets)}
+}
+
+// aiTarget is the AI's single target: a foe in the middle of a cast, else
+// the top of the threat table if there is one, else the first living member
+// of targets.
+func (b *Battle) aiTarget(actor *Character, targets []*Character) *Character {
+	if c := b.castingFoe(actor); c != nil {
+		return c
+	}
+	if c := b.threatTarget(actor); c != nil {
+		return c
+	}
+	return chooseFirstAlive(targets)
+}
+
+// Controls hands the player team to a manual controller and tracks who is on
+// auto-battle.
+type Controls struct {
+	Team   string     // Team driven by Manual
+	Manual Controller // Used for Team members not on auto
+	AI     Controller // Used for everyone else; nil means AIController
+	// BeforeRound runs before each round's pending changes are applied;
+	// the interactive UI uses it to offer "take control".
+	BeforeRound func(b *Battle)
+
+	auto    map[string]bool // character ID → on auto this round
+	pending map[string]bool // changes queued for the next round
+}
+
+func NewControls(team string, manual Controller) *Controls {
+	return &Controls{Team: team, Manual: manual, auto: map[string]bool{}, pending: map[string]bool{}}
+}
+
+// SetAuto queues switching a character to auto (on) or manual (off) from
+// the next round.
+func (c *Controls) SetAuto(id string, on bool) {
+	c.pending[id] = on
+}
+
+// TakeControl queues switching a character back to manual.
+func (c *Controls) TakeControl(id string) {
+	c.SetAuto(id, false)
+}
+
+// ToggleAuto queues flipping a character's mode, counting changes already
+// queued.
+func (c *Controls) ToggleAuto(id string) {
+	c.SetAuto(id, !c.willBeAuto(id))
+}
+
+func (c *Controls) willBeAuto(id string) bool {
+	if on, ok := c.pending[id]; ok {
+		return on
+	}
+	return c.auto[id]
+}
+
+// IsAuto reports whether the character is on auto this round.
+func (c *Controls) IsAuto(id string) bool {
+	return c.auto[id]
+}
+
+// applyPending makes the queued changes current; called at round start.
+func (c *Controls) applyPending() {
+	for id, on := range c.pending {
+		if on {
+			c.auto[id] = true
+		} else {
+			delete(c.auto, id)
+		}
+	}
+	clear(c.pending)
+}
+
+// autoNames lists the team members on auto, in team order.
+func (c *Controls) autoNames(b *Battle) []string {
+	var names []string
+	for _, m := range b.Teams[c.Team] {
+		if c.auto[m.ID] {
+			names = append(names, m.Name)
+		}
+	}
+	return names
+}
+
+// controllerFor picks who decides the actor's action this round.
+func (b *Battle) controllerFor(actor *Character) Controller {
+	c := b.Controls
+	if c != nil && actor.Team == c.Team && !c.auto[actor.ID] && c.Manual != nil {
+		return c.Manual
+	}
+	if c != nil && c.AI != nil {
+		return c.AI
+	}
+	return AIController{}
+}
+
+// startRound applies queued control changes, decays threat and logs the
+// round header (and the threat tables in verbose mode).
+func (b *Battle) startRound(logFunc func(string)) {
+	c := b.Controls
+	if c != nil {
+		if c.BeforeRound != nil {
+			c.BeforeRound(b)
+		}
+		c.applyPending()
+	}
+	b.decayThreat()
+	logFunc(tr("round", b.Round))
+	b.logCasting(logFunc)
+	if c != nil {
+		if names := c.autoNames(b); len(names) > 0 {
+			logFunc(tr("round_auto", strings.Join(names, ", ")))
+		}
+	}
+	if b.Verbose {
+		b.logThreat(logFunc)
+	}
+}
+
+// execute carries out a decided action.
+func (b *Battle) execute(actor *Character, a Action, logFunc func(string)) {
+	if a.Target == nil {
+		return
+	}
+	if a.Skill == substitute {
+		if b.swap(actor, a.Target, logFunc) != nil {
+			logFunc(tr("sub_invalid", actor.Name))
+		}
+		return
+	}
+	if a.Skill == basicAttack {
+		actor.BasicAttack(a.Target, logFunc)
+		pause(1 * time.Second) // Delay after attack
+		return
+	}
+	if actor.Skills[a.Skill].CastTime > 0 {
+		actor.BeginCast(a.Skill, a.Target, logFunc)
+		return
+	}
+	actor.UseSkillAt(a.Skill, resolveTargets(actor, actor.Skills[a.Skill], a.Target, b), logFunc)
+	pause(1 * time.Second) // Delay after skill
+}
+
+// Console is the manual controller of the interactive mode: it prints a menu
+// of actions and targets and reads the choice. Entering "a" hands the
+// character to the AI: for this action and, from the next round, on auto;
+// "s" offers the team's substitution while it is unused.
+type Console struct {
+	in       *bufio.Reader
+	out      io.Writer
+	controls *Controls
+}
+
+func NewConsole(in *bufio.Reader, out io.Writer) *Console {
+	return &Console{in: in, out: out}
+}
+
+// ask prints prompt and reads a trimmed line; ok is false at end of input.
+func (con *Console) ask(prompt string) (string, bool) {
+	fmt.Fprint(con.out, prompt)
+	line, err := con.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+func (con *Console) Decide(b *Battle, actor *Character) Action {
+	ai := func() Action { return AIController{}.Decide(b, actor) }
+	for {
+		fmt.Fprintln(con.out, tr("manual_turn", actor.Name, actor.Stats.HP, actor.Stats.HPMax, actor.Stats.MP, actor.Stats.MPMax))
+		fmt.Fprintln(con.out, "  0) "+tr("manual_attack"))
+		for i, s := range actor.Skills {
+			fmt.Fprintf(con.out, "  %d) %s (MP %d)\n", i+1, s.Name, s.MPCost)
+		}
+		canSub := b.CanSubstitute(actor.Team)
+		if canSub {
+			fmt.Fprintln(con.out, "  s) "+tr("manual_substitute"))
+		}
+		line, ok := con.ask(tr("manual_action"))
+		if !ok {
+			return ai() // No more input: let the AI finish the battle
+		}
+		line = strings.TrimSpace(line)
+		if line == "a" {
+			if con.controls != nil {
+				con.controls.SetAuto(actor.ID, true)
+			}
+			return ai()
+		}
+		if line == "s" && canSub {
+			if in, ok := con.pickTarget(b.reserves(actor.Team)); ok {
+				return Action{Skill: substitute, Target: in}
+			}
+			continue
+		}
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 || n > len(actor.Skills) {
+			fmt.Fprintln(con.out, tr("manual_bad_choice"))
+			continue
+		}
+		skill := n - 1
+		var candidates []*Character
+		switch {
+		case skill == basicAttack:
+			candidates = b.hostileFighters(actor.Team)
+		case actor.Stats.MP < actor.Skills[skill].MPCost:
+			fmt.Fprintln(con.out, tr("skill_no_mp", actor.Name, actor.Skills[skill].Name))
+			continue
+		case actor.Skills[skill].IsRevive():
+			for _, m := range b.Teams[actor.Team] {
+				if !m.Alive {
+					candidates = append(candidates, m)
+				}
+			}
+		case actor.Skills[skill].Mode().TargetsAllies():
+			for _, m := range b.Teams[actor.Team] {
+				if m.Fighting() {
+					candidates = append(candidates, m)
+				}
+			}
+		default:
+			candidates = b.hostileFighters(actor.Team)
+		}
+		if len(candidates) == 0 {
+			fmt.Fprintln(con.out, tr("manual_no_targets"))
+			continue
+		}
+		if skill != basicAttack {
+			if mode := actor.Skills[skill].Mode(); mode == AllEnemies || mode == AllAllies || mode == Everyone {
+				return Action{Skill: skill, Target: candidates[0]} // Hits everyone anyway
+			}
+		}
+		if target, ok := con.pickTarget(candidates); ok {
+			return Action{Skill: skill, Target: target}
+		}
+	}
+}
+
+// pickTarget asks for one of candidates; ok is false to go back to the
+// action menu. A single candidate is chosen without asking.
+func (con *Console) pickTarget(candidates []*Character) (*Character, bool) {
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	for i, c := range candidates {
+		fmt.Fprintf(con.out, "  %d) %s (HP %d/%d)\n", i+1, c.Name, c.Stats.HP, c.Stats.HPMax)
+	}
+	for {
+		line, ok := con.ask(tr("manual_target"))
+		line = strings.TrimSpace(line)
+		if !ok || line == "" {
+			return nil, false
+		}
+		n, err := strconv.Atoi(line)
+		if err == nil && n >= 1 && n <= len(candidates) {
+			return candidates[n-1], true
+		}
+		fmt.Fprintln(con.out, tr("manual_bad_choice"))
+	}
+}
+
+// BeforeRound offers to take back control while someone is on auto: Enter
+// continues, a space takes control of everyone, "a N" toggles the N-th
+// party member.
+func (con *Console) BeforeRound(b *Battle) {
+	c := con.controls
+	if len(c.autoNames(b)) == 0 {
+		return
+	}
+	team := b.Teams[c.Team]
+	for {
+		line, ok := con.ask(tr("auto_prompt"))
+		if !ok || line == "" {
+			return
+		}
+		if strings.TrimSpace(line) == "" {
+			for _, m := range team {
+				c.TakeControl(m.ID)
+			}
+			return
+		}
+		var n int
+		if _, err := fmt.Sscanf(line, "a %d", &n); err == nil && n >= 1 && n <= len(team) {
+			c.ToggleAuto(team[n-1].ID)
+			continue
+		}
+		fmt.Fprintln(con.out, tr("manual_bad_choice"))
+	}
+}
+
+// hostileFighters lists every enemy of team still in the fight.
+func (b *Battle) hostileFighters(team string) []*Character {
+	var list []*Character
+	for _, name := range b.order {
+		if !b.IsHostile(team, name) {
+			continue
+		}
+		for _, m := range b.Teams[name] {
+			if m.Fighting() {
+				list = append(list, m)
+			}
+		}
+	}
+	return list
+}
+
+// interactiveControls puts the team under console control; members listed in
+// auto start on auto-battle.
+func interactiveControls(team string, in *bufio.Reader, out io.Writer, auto []string) *Controls {
+	con := NewConsole(in, out)
+	c := NewControls(team, con)
+	c.BeforeRound = con.BeforeRound
+	con.controls = c
+	for _, id := range auto {
+		c.SetAuto(id, true)
+	}
+	return c
+}
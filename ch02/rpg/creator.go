@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Character creation — name, class preset and point-buy of bonus stats.
+// The result is kept in a JSON profile and reused on the next run.
+
+// pointPool is the number of bonus points a new hero distributes.
+const pointPool = 10
+
+var (
+	errUnknownStat  = errors.New("unknown stat")
+	errUnknownClass = errors.New("unknown class")
+	errNegative     = errors.New("allocation below zero")
+	errOverCap      = errors.New("allocation over stat cap")
+	errOverPool     = errors.New("not enough points")
+)
+
+// statBuy describes one stat that can be bought with bonus points.
+type statBuy struct {
+	Key   string
+	Cap   int // Max points in this stat
+	apply func(s *Stats, n int)
+}
+
+var pointBuyStats = []statBuy{
+	{"hp", 5, func(s *Stats, n int) { s.HPMax += 5 * n }},
+	{"mp", 5, func(s *Stats, n int) { s.MPMax += 5 * n }},
+	{"atk", 4, func(s *Stats, n int) { s.Attack += n }},
+	{"def", 4, func(s *Stats, n int) { s.Defense += n }},
+	{"mag", 4, func(s *Stats, n int) { s.Magic += n }},
+	{"res", 4, func(s *Stats, n int) { s.Resist += n }},
+	{"spd", 3, func(s *Stats, n int) { s.Speed += n }},
+	{"crit", 3, func(s *Stats, n int) { s.CritRate += 0.02 * float64(n) }},
+}
+
+func findStatBuy(key string) (statBuy, bool) {
+	for _, sb := range pointBuyStats {
+		if sb.Key == key {
+			return sb, true
+		}
+	}
+	return statBuy{}, false
+}
+
+func statKeys() []string {
+	keys := make([]string, len(pointBuyStats))
+	for i, sb := range pointBuyStats {
+		keys[i] = sb.Key
+	}
+	return keys
+}
+
+// PointBuy tracks how many bonus points went into each stat.
+type PointBuy struct {
+	Pool  int
+	Alloc map[string]int
+}
+
+func NewPointBuy(pool int) *PointBuy {
+	return &PointBuy{Pool: pool, Alloc: map[string]int{}}
+}
+
+func (p *PointBuy) Spent() int {
+	total := 0
+	for _, n := range p.Alloc {
+		total += n
+	}
+	return total
+}
+
+func (p *PointBuy) Remaining() int {
+	return p.Pool - p.Spent()
+}
+
+// Set puts exactly n points into a stat. Invalid allocations leave p unchanged.
+func (p *PointBuy) Set(key string, n int) error {
+	sb, ok := findStatBuy(key)
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownStat, key)
+	}
+	if n < 0 {
+		return fmt.Errorf("%w: %s=%d", errNegative, key, n)
+	}
+	if n > sb.Cap {
+		return fmt.Errorf("%w: %s=%d, max %d", errOverCap, key, n, sb.Cap)
+	}
+	if extra := n - p.Alloc[key]; extra > p.Remaining() {
+		return fmt.Errorf("%w: need %d, have %d", errOverPool, extra, p.Remaining())
+	}
+	if n == 0 {
+		delete(p.Alloc, key)
+	} else {
+		p.Alloc[key] = n
+	}
+	return nil
+}
+
+// Adjust adds delta (possibly negative) points to a stat.
+func (p *PointBuy) Adjust(key string, delta int) error {
+	return p.Set(key, p.Alloc[key]+delta)
+}
+
+func (p *PointBuy) Reset() {
+	p.Alloc = map[string]int{}
+}
+
+// Apply returns base with the bought points added.
+func (p *PointBuy) Apply(base Stats) Stats {
+	s := base.Clone()
+	for _, sb := range pointBuyStats {
+		if n := p.Alloc[sb.Key]; n > 0 {
+			sb.apply(&s, n)
+		}
+	}
+	return s
+}
+
+// validateAlloc checks an allocation read from outside (e.g. a profile file).
+func validateAlloc(pool int, alloc map[string]int) error {
+	p := NewPointBuy(pool)
+	for key, n := range alloc {
+		if err := p.Set(key, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClassPreset is a starting template: baseline stats, skills and weapon.
+type ClassPreset struct {
+	ID     string
+	Name   string
+	Stats  Stats
+	Skills []Skill
+	Weapon *Weapon
+}
+
+var classPresets = []ClassPreset{
+	{
+		ID:   "warrior",
+		Name: "Воин",
+		Stats: Stats{HPMax: 70, MPMax: 20, Attack: 8, Defense: 4, Magic: 2, Resist: 1,
+			Speed: 6, CritRate: 0.12, CritMult: 1.8},
+		Skills: []Skill{{ID: "w1", Name: "Мощный удар", Description: "Сильный физический удар",
+			MPCost: 5, DamageMultiplier: 1.8, DamageType: Physical},
+			{ID: "w2", Name: "Вызов", Description: "Враг бьёт только вас два раунда",
+				MPCost: 4, TauntRounds: 2}},
+		Weapon: &Weapon{Name: "Меч новичка", DamageMin: 3, DamageMax: 6, DamageType: Physical, AttackBonus: 1},
+	},
+	{
+		ID:   "mage",
+		Name: "Маг",
+		Stats: Stats{HPMax: 45, MPMax: 50, Attack: 3, Defense: 2, Magic: 8, Resist: 4,
+			Speed: 7, CritRate: 0.08, CritMult: 1.6},
+		Skills: []Skill{{ID: "s1", Name: "Огненный шар", Description: "Наносит магический урон",
+			MPCost: 6, DamageMultiplier: 2.2, DamageType: Magic}},
+		Weapon: &Weapon{Name: "Посох ученика", DamageMin: 1, DamageMax: 3, DamageType: Magic, MagicBonus: 2},
+	},
+	{
+		ID:   "cleric",
+		Name: "Жрец",
+		Stats: Stats{HPMax: 55, MPMax: 45, Attack: 4, Defense: 3, Magic: 6, Resist: 3,
+			Speed: 5, CritRate: 0.05, CritMult: 1.5},
+		Skills: []Skill{{ID: "he1", Name: "Исцеление", MPCost: 8, HealHP: 18, DamageType: Magic}},
+		Weapon: &Weapon{Name: "Булава", DamageMin: 2, DamageMax: 4, DamageType: Physical},
+	},
+}
+
+func findClass(id string) (ClassPreset, bool) {
+	for _, c := range classPresets {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return ClassPreset{}, false
+}
+
+func classIDs() []string {
+	ids := make([]string, len(classPresets))
+	for i, c := range classPresets {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+// Profile is the saved result of character creation.
+type Profile struct {
+	Name  string         `json:"name"`
+	Class string         `json:"class"`
+	Alloc map[string]int `json:"alloc"`
+}
+
+func (p Profile) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("empty name")
+	}
+	class, ok := findClass(p.Class)
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownClass, p.Class)
+	}
+	if err := validateAlloc(pointPool, p.Alloc); err != nil {
+		return err
+	}
+	return (&PointBuy{Pool: pointPool, Alloc: p.Alloc}).Apply(class.Stats).Validate()
+}
+
+// Build creates the hero described by the profile.
+func (p Profile) Build() (*Character, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	class, _ := findClass(p.Class)
+	pb := &PointBuy{Pool: pointPool, Alloc: p.Alloc}
+	hero, err := NewCharacter("p1", p.Name, "player", pb.Apply(class.Stats))
+	if err != nil {
+		return nil, err
+	}
+	hero.Skills = append(hero.Skills, class.Skills...)
+	if class.Weapon != nil {
+		w := *class.Weapon
+		hero.Weapon = &w
+	}
+	return hero, nil
+}
+
+func LoadProfile(path string) (Profile, error) {
+	var p Profile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("profile %s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return p, fmt.Errorf("profile %s: %w", path, err)
+	}
+	return p, nil
+}
+
+func SaveProfile(path string, p Profile) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Wizard runs the creation dialog over any reader/writer pair.
+type Wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func NewWizard(r io.Reader, w io.Writer) *Wizard {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Wizard{in: br, out: w}
+}
+
+func (wz *Wizard) ask(prompt string) (string, error) {
+	fmt.Fprint(wz.out, prompt)
+	line, err := wz.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// LoadOrCreate offers the saved hero from path, otherwise runs Create and
+// saves the result there.
+func (wz *Wizard) LoadOrCreate(path string) (Profile, error) {
+	if p, err := LoadProfile(path); err == nil {
+		ans, err := wz.ask(tr("wiz_load", p.Name, p.Class))
+		if err != nil {
+			return p, err
+		}
+		if strings.ToLower(ans) != "n" {
+			return p, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(wz.out, tr("wiz_error", err))
+	}
+
+	p, err := wz.Create()
+	if err != nil {
+		return p, err
+	}
+	if err := SaveProfile(path, p); err != nil {
+		return p, err
+	}
+	fmt.Fprintln(wz.out, tr("wiz_saved", path))
+	return p, nil
+}
+
+// Create asks for name, class and bonus points, then for confirmation.
+func (wz *Wizard) Create() (Profile, error) {
+	var p Profile
+	for p.Name == "" {
+		name, err := wz.ask(tr("wiz_name"))
+		if err != nil {
+			return p, err
+		}
+		p.Name = name
+	}
+
+	var class ClassPreset
+	for {
+		id, err := wz.ask(tr("wiz_class", strings.Join(classIDs(), "/")))
+		if err != nil {
+			return p, err
+		}
+		var ok bool
+		if class, ok = findClass(strings.ToLower(id)); ok {
+			break
+		}
+		fmt.Fprintln(wz.out, tr("wiz_error", fmt.Errorf("%w: %q", errUnknownClass, id)))
+	}
+	p.Class = class.ID
+
+	pb := NewPointBuy(pointPool)
+	fmt.Fprintln(wz.out, tr("wiz_points_help", strings.Join(statKeys(), " ")))
+	for {
+		wz.preview(class, pb)
+		cmd, err := wz.ask("> ")
+		if err != nil {
+			return p, err
+		}
+		fields := strings.Fields(strings.ToLower(cmd))
+		switch {
+		case len(fields) == 0:
+			continue
+		case fields[0] == "reset":
+			pb.Reset()
+			continue
+		case fields[0] == "done":
+			ans, err := wz.ask(tr("wiz_confirm", p.Name, class.Name))
+			if err != nil {
+				return p, err
+			}
+			if strings.ToLower(ans) == "y" {
+				p.Alloc = pb.Alloc
+				return p, nil
+			}
+			continue
+		case len(fields) == 2:
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				fmt.Fprintln(wz.out, tr("wiz_error", err))
+				continue
+			}
+			if err := pb.Adjust(fields[0], n); err != nil {
+				fmt.Fprintln(wz.out, tr("wiz_error", err))
+			}
+		default:
+			fmt.Fprintln(wz.out, tr("wiz_points_help", strings.Join(statKeys(), " ")))
+		}
+	}
+}
+
+// preview prints the current stats and the derived attack numbers.
+func (wz *Wizard) preview(class ClassPreset, pb *PointBuy) {
+	s := pb.Apply(class.Stats)
+	fmt.Fprintln(wz.out, tr("wiz_preview", pb.Remaining(), pb.Pool,
+		s.HPMax, s.MPMax, s.Attack, s.Defense, s.Magic, s.Resist, s.Speed, s.CritRate*100))
+	lo, hi := attackRange(class, s)
+	fmt.Fprintln(wz.out, tr("wiz_derived", lo, hi,
+		int(float64(lo)*s.CritMult), int(float64(hi)*s.CritMult)))
+}
+
+// attackRange mirrors BasicAttack: weapon roll plus effective attack.
+func attackRange(class ClassPreset, s Stats) (int, int) {
+	c := &Character{Stats: s, Weapon: class.Weapon}
+	lo, hi := 1, 2
+	if class.Weapon != nil {
+		lo, hi = class.Weapon.DamageMin, class.Weapon.DamageMax
+	}
+	return lo + c.EffectiveAttack(), hi + c.EffectiveAttack()
+}
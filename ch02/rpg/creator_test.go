@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPointBuyValidation(t *testing.T) {
+	pb := NewPointBuy(pointPool)
+	if err := pb.Set("atk", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.Adjust("atk", -4); !errors.Is(err, errNegative) {
+		t.Fatalf("refund below zero: got %v", err)
+	}
+	if err := pb.Set("spd", 4); !errors.Is(err, errOverCap) {
+		t.Fatalf("over cap: got %v", err)
+	}
+	if err := pb.Set("luck", 1); !errors.Is(err, errUnknownStat) {
+		t.Fatalf("unknown stat: got %v", err)
+	}
+	if err := pb.Set("hp", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.Set("def", 3); !errors.Is(err, errOverPool) {
+		t.Fatalf("over pool: got %v", err)
+	}
+	if pb.Spent() != 8 || pb.Remaining() != 2 {
+		t.Fatalf("failed allocations must not change the pool: spent=%d", pb.Spent())
+	}
+	// Lowering a stat frees points for another one
+	if err := pb.Adjust("hp", -2); err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.Set("def", 4); err != nil {
+		t.Fatalf("refunded points should be reusable: %v", err)
+	}
+}
+
+func TestPointBuyApply(t *testing.T) {
+	base := Stats{HPMax: 50, Attack: 5, CritRate: 0.1}
+	pb := NewPointBuy(pointPool)
+	pb.Set("hp", 2)
+	pb.Set("atk", 3)
+	pb.Set("crit", 1)
+	s := pb.Apply(base)
+	if s.HPMax != 60 || s.Attack != 8 || s.CritRate < 0.119 || s.CritRate > 0.121 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if base.HPMax != 50 {
+		t.Fatal("Apply must not modify the base stats")
+	}
+}
+
+func TestProfileRejectsTamperedAlloc(t *testing.T) {
+	p := Profile{Name: "Vlad", Class: "warrior", Alloc: map[string]int{"atk": 4, "hp": 5, "def": 4}}
+	if _, err := p.Build(); !errors.Is(err, errOverPool) {
+		t.Fatalf("expected pool error, got %v", err)
+	}
+	p.Alloc = map[string]int{"atk": -1}
+	if _, err := p.Build(); !errors.Is(err, errNegative) {
+		t.Fatalf("expected negative error, got %v", err)
+	}
+}
+
+func TestWizardCreatesAndReloadsProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hero.json")
+	input := strings.Join([]string{
+		"Влад",
+		"necromancer", // unknown class is asked again
+		"mage",
+		"mag 5", // over cap, rejected
+		"mag 4",
+		"spd 3",
+		"hp -1", // negative, rejected
+		"done",
+		"n", // back to allocation
+		"mp 2",
+		"done",
+		"y",
+	}, "\n") + "\n"
+	var out bytes.Buffer
+	p, err := NewWizard(strings.NewReader(input), &out).LoadOrCreate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"mag": 4, "spd": 3, "mp": 2}
+	if p.Name != "Влад" || p.Class != "mage" || len(p.Alloc) != len(want) {
+		t.Fatalf("unexpected profile %+v", p)
+	}
+	for k, v := range want {
+		if p.Alloc[k] != v {
+			t.Fatalf("alloc[%s]=%d, want %d", k, p.Alloc[k], v)
+		}
+	}
+	if !strings.Contains(out.String(), tr("wiz_preview", 1, pointPool, 45, 60, 3, 2, 12, 4, 10, 8.0)) {
+		t.Fatalf("final preview missing from output:\n%s", out.String())
+	}
+
+	// Next run: the saved hero is offered and accepted
+	out.Reset()
+	again, err := NewWizard(strings.NewReader("y\n"), &out).LoadOrCreate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hero, err := again.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hero.Name != "Влад" || hero.Stats.Magic != 12 || hero.Stats.MP != 60 || len(hero.Skills) != 1 {
+		t.Fatalf("reloaded hero mismatch: %+v", hero.Stats)
+	}
+}
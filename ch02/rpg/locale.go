@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Localization — каталоги сообщений боевого лога.
+// Каждое сообщение задаётся ключом и fmt-шаблоном; порядок аргументов
+// можно менять в переводе через %[n]s.
+
+const (
+	LangRU = "ru"
+	LangEN = "en"
+)
+
+var catalogs = map[string]map[string]string{
+	LangRU: {
+		"round":              "=== Раунд %d ===",
+		"thinking":           "%s думает...",
+		"round_auto":         "Автобой: %s",
+		"auto_prompt":        "Enter — дальше, пробел — взять управление, a N — автобой для N-го героя: ",
+		"manual_turn":        "Ход: %s (HP %d/%d, MP %d/%d)",
+		"manual_attack":      "Атака оружием",
+		"manual_action":      "Действие (номер, a — автобой): ",
+		"manual_target":      "Цель (номер, Enter — назад): ",
+		"manual_bad_choice":  "Нет такого варианта.",
+		"manual_no_targets":  "Для этого действия нет целей.",
+		"extra_action":       "%s ускорен и действует ещё раз!",
+		"dot":                "%s получает %d урона от эффекта «%s»",
+		"regen":              "%s восстанавливает %d HP (%s)",
+		"effect_ended":       "Эффект %s на %s закончился",
+		"died":               "%s погибает!",
+		"effect_gained":      "%s получает эффект: %s (длит.=%d)",
+		"equip_weapon":       "%s берёт оружие: %s",
+		"equip_armor":        "%s надевает броню: %s",
+		"crit":               "Критический удар! (%s, шанс %.0f%%)",
+		"attack":             "%s атакует %s и наносит %d урона (%s)",
+		"skill_invalid":      "%s пытается применить несуществующее умение",
+		"skill_no_mp":        "%s не хватает маны для %s",
+		"skill_use":          "%s применяет умение %s",
+		"skill_crit":         "Критическое умение! (%s, шанс %.0f%%)",
+		"skill_damage":       "%s наносит %d урона %s умением %s",
+		"skill_heal":         "%s исцеляет %s на %d HP",
+		"skill_revive":       "%s воскрешает %s (%d HP)",
+		"skill_taunt":        "%s провоцирует %s (раундов: %d)",
+		"cast_begin":         "%s начинает читать %s (ходов: %d)",
+		"cast_continue":      "%s продолжает читать %s (осталось ходов: %d)",
+		"cast_interrupted":   "%s сбит: %s прервано, возвращено %d MP",
+		"round_casting":      "Читают заклинания: %s",
+		"stunned":            "%s оглушён и пропускает ход",
+		"threat_debug":       "[угроза] %s: %s",
+		"players_win":        "Герои победили!",
+		"enemies_win":        "Враги победили!",
+		"team_wins":          "Победила сторона «%s»!",
+		"draw":               "Никто не выжил.",
+		"battle_aborted":     "Бой прерван.",
+		"play_again":         "Сыграть ещё? (y/n): ",
+		"goodbye":            "Спасибо за игру!",
+		"intro_goblins":      "В сумерках на тропе показались гоблины, а за ними — тяжёлая поступь орка.",
+		"outro_goblins":      "Тропа снова свободна. Ветер уносит запах гари.",
+		"intro_boss":         "Из шатра выходит вождь орков и поднимает топор.",
+		"outro_boss":         "Вождь повержен, и лагерь орков погружается в тишину.",
+		"boss_enrage":        "%s впадает в ярость!",
+		"physical":           "физ.",
+		"magic":              "маг.",
+		"pure":               "чист.",
+		"loot_pickup":        "%s подбирает: %s x%d",
+		"loot_full":          "%s: инвентарь полон, %s x%d остаётся лежать",
+		"morale_broken":      "%s дрогнул и озирается в поисках пути к бегству.",
+		"surrender_offer":    "%s бросает оружие и просит пощады!",
+		"surrender_goblin_1": "%s: «Не бей! Гоблин всё отдаст, всё-всё!»",
+		"surrender_goblin_2": "%s: «Хватит! Я больше не хочу драться!»",
+		"surrender_goblin_3": "%s: «Вождь сказал, будет легко... Сдаюсь!»",
+		"surrender_orc_1":    "%s: «Ты сильнее. Орк признаёт поражение.»",
+		"surrender_orc_2":    "%s: «Забирай добычу и дай мне уйти.»",
+		"surrender_generic":  "%s: «Пощади, я сдаюсь!»",
+		"surrender_prompt":   "Принять капитуляцию %s? (y/n): ",
+		"surrender_accepted": "%s сдаётся и выходит из боя.",
+		"surrender_refused":  "Пощады не будет — %s снова берётся за оружие!",
+		"xp_gain":            "%s получает %d опыта",
+		"xp_gain_bench":      "%s (в запасе) получает %d опыта",
+		"manual_substitute":  "Замена из запаса",
+		"sub_swap":           "%s уходит в запас, в бой вступает %s",
+		"sub_arriving":       "%s занимает позицию и пропускает ход",
+		"sub_invalid":        "%s не может смениться: замены нет",
+		"sub_offer":          "Все в строю пали! Кого выпустить из запаса?",
+		"sub_prompt":         "Номер (Enter — сдаться): ",
+		"sub_declined":       "Замены не будет.",
+		"party_roster":       "Отряд:",
+		"party_active":       "в бою",
+		"party_benched":      "в запасе",
+		"party_prompt":       "Кто идёт в бой (ID через запятую, до %d; Enter — оставить): ",
+		"party_error":        "Ошибка: %v",
+		"wiz_load":           "Найден герой %s (%s). Загрузить? (y/n): ",
+		"wiz_name":           "Имя героя: ",
+		"wiz_class":          "Класс (%s): ",
+		"wiz_points_help":    "Распределите очки: <стат> <число> (можно отрицательное), reset — сбросить, done — готово. Статы: %s",
+		"wiz_preview":        "Очки: %d/%d | HP %d MP %d ATK %d DEF %d MAG %d RES %d SPD %d CRIT %.0f%%",
+		"wiz_derived":        "Урон атаки: %d-%d, крит: %d-%d",
+		"wiz_confirm":        "Создать героя %s (%s)? (y/n): ",
+		"wiz_saved":          "Профиль сохранён: %s",
+		"wiz_error":          "Ошибка: %v",
+	},
+	LangEN: {
+		"round":              "=== Round %d ===",
+		"thinking":           "%s is thinking...",
+		"round_auto":         "Auto-battle: %s",
+		"auto_prompt":        "Enter to continue, space to take control, a N to toggle auto for party member N: ",
+		"manual_turn":        "%s to act (HP %d/%d, MP %d/%d)",
+		"manual_attack":      "Weapon attack",
+		"manual_action":      "Action (number, a for auto-battle): ",
+		"manual_target":      "Target (number, Enter to go back): ",
+		"manual_bad_choice":  "No such option.",
+		"manual_no_targets":  "No targets for this action.",
+		"extra_action":       "%s is hasted and acts again!",
+		"dot":                "%s takes %d damage from %s",
+		"regen":              "%s regenerates %d HP (%s)",
+		"effect_ended":       "Effect %s on %s ended",
+		"died":               "%s died!",
+		"effect_gained":      "%s gains effect: %s (dur=%d)",
+		"equip_weapon":       "%s equips weapon: %s",
+		"equip_armor":        "%s equips armor: %s",
+		"crit":               "Critical hit! (%s, chance %.0f%%)",
+		"attack":             "%s attacks %s for %d damage (%s)",
+		"skill_invalid":      "%s tried to use invalid skill",
+		"skill_no_mp":        "%s lacks MP for %s",
+		"skill_use":          "%s uses skill %s",
+		"skill_crit":         "Skill crit! (%s, chance %.0f%%)",
+		"skill_damage":       "%s deals %d damage to %s with %s",
+		"skill_heal":         "%s heals %s for %d HP",
+		"skill_revive":       "%s revives %s with %d HP",
+		"skill_taunt":        "%s taunts %s for %d rounds",
+		"cast_begin":         "%s starts casting %s (%d turns)",
+		"cast_continue":      "%s keeps casting %s (%d turns left)",
+		"cast_interrupted":   "%s is interrupted: %s cancelled, %d MP refunded",
+		"round_casting":      "Casting: %s",
+		"stunned":            "%s is stunned and loses the turn",
+		"threat_debug":       "[threat] %s: %s",
+		"players_win":        "Players win!",
+		"enemies_win":        "Enemies win!",
+		"team_wins":          "Team %s wins!",
+		"draw":               "Nobody survived.",
+		"battle_aborted":     "Battle aborted.",
+		"play_again":         "Play again? (y/n): ",
+		"goodbye":            "Thanks for playing!",
+		"intro_goblins":      "At dusk a band of goblins blocks the trail, followed by the heavy tread of an orc.",
+		"outro_goblins":      "The trail is clear again. The wind carries away the smell of smoke.",
+		"intro_boss":         "The orc warlord steps out of his tent and raises his axe.",
+		"outro_boss":         "The warlord falls, and the orc camp goes silent.",
+		"boss_enrage":        "%s flies into a rage!",
+		"physical":           "physical",
+		"magic":              "magic",
+		"pure":               "pure",
+		"loot_pickup":        "%s picks up %s x%d",
+		"loot_full":          "%s: inventory full, %s x%d left behind",
+		"morale_broken":      "%s falters and looks for a way out.",
+		"surrender_offer":    "%s throws down their weapon and begs for mercy!",
+		"surrender_goblin_1": "%s: \"Don't hit! Goblin gives everything, everything!\"",
+		"surrender_goblin_2": "%s: \"Enough! I don't want to fight anymore!\"",
+		"surrender_goblin_3": "%s: \"Chief said it would be easy... I give up!\"",
+		"surrender_orc_1":    "%s: \"You are stronger. The orc yields.\"",
+		"surrender_orc_2":    "%s: \"Take the loot and let me go.\"",
+		"surrender_generic":  "%s: \"Mercy, I surrender!\"",
+		"surrender_prompt":   "Accept %s's surrender? (y/n): ",
+		"surrender_accepted": "%s surrenders and leaves the fight.",
+		"surrender_refused":  "No mercy — %s takes up arms again!",
+		"xp_gain":            "%s gains %d XP",
+		"xp_gain_bench":      "%s (benched) gains %d XP",
+		"manual_substitute":  "Substitute from the bench",
+		"sub_swap":           "%s steps back to the bench, %s joins the fight",
+		"sub_arriving":       "%s takes position and loses the turn",
+		"sub_invalid":        "%s cannot swap out: no substitution left",
+		"sub_offer":          "The whole line has fallen! Who comes in from the bench?",
+		"sub_prompt":         "Number (Enter to give up): ",
+		"sub_declined":       "No substitution.",
+		"party_roster":       "Roster:",
+		"party_active":       "active",
+		"party_benched":      "benched",
+		"party_prompt":       "Who fights (IDs separated by commas, up to %d; Enter keeps the party): ",
+		"party_error":        "Error: %v",
+		"wiz_load":           "Found hero %s (%s). Load? (y/n): ",
+		"wiz_name":           "Hero name: ",
+		"wiz_class":          "Class (%s): ",
+		"wiz_points_help":    "Spend points: <stat> <n> (negative to refund), reset to start over, done to finish. Stats: %s",
+		"wiz_preview":        "Points: %d/%d | HP %d MP %d ATK %d DEF %d MAG %d RES %d SPD %d CRIT %.0f%%",
+		"wiz_derived":        "Attack damage: %d-%d, crit: %d-%d",
+		"wiz_confirm":        "Create hero %s (%s)? (y/n): ",
+		"wiz_saved":          "Profile saved: %s",
+		"wiz_error":          "Error: %v",
+	},
+}
+
+type Localizer struct {
+	Lang    string
+	catalog map[string]string
+
+	mu     sync.Mutex
+	used   map[string]bool
+	warned map[string]bool
+}
+
+func NewLocalizer(lang string) *Localizer {
+	cat, ok := catalogs[lang]
+	if !ok {
+		log.Printf("localizer: unknown language %q, falling back to %q", lang, LangRU)
+		lang = LangRU
+		cat = catalogs[LangRU]
+	}
+	return &Localizer{
+		Lang:    lang,
+		catalog: cat,
+		used:    map[string]bool{},
+		warned:  map[string]bool{},
+	}
+}
+
+// T renders the message for key. Unknown keys render as the key itself and
+// are reported once, so a new event never breaks the battle.
+func (l *Localizer) T(key string, args ...any) string {
+	l.mu.Lock()
+	l.used[key] = true
+	tmpl, ok := l.catalog[key]
+	if !ok && !l.warned[key] {
+		l.warned[key] = true
+		log.Printf("localizer: missing key %q for %q", key, l.Lang)
+	}
+	l.mu.Unlock()
+	if !ok {
+		return key
+	}
+	return fmt.Sprintf(tmpl, args...)
+}
+
+// UsedKeys returns every key requested so far, sorted.
+func (l *Localizer) UsedKeys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := make([]string, 0, len(l.used))
+	for k := range l.used {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// loc is the active localizer, chosen at startup by -lang.
+var loc = NewLocalizer(LangRU)
+
+func tr(key string, args ...any) string {
+	return loc.T(key, args...)
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
 	"time"
@@ -21,32 +26,56 @@ const (
 )
 
 type Stats struct {
-	HPMax    int
-	HP       int
-	MPMax    int
-	MP       int
-	Attack   int
-	Defense  int
-	Magic    int
-	Resist   int
-	Speed    int
-	CritRate float64
-	CritMult float64
+	HPMax      int
+	HP         int
+	MPMax      int
+	MP         int
+	Attack     int
+	Defense    int
+	Magic      int
+	Resist     int
+	Speed      int
+	CritRate   float64
+	CritMult   float64
+	CritResist float64 // Subtracted from an attacker's crit chance
+}
+
+// Validate checks that the stats make a playable character.
+func (s Stats) Validate() error {
+	switch {
+	case s.HPMax <= 0:
+		return fmt.Errorf("stats: HPMax must be > 0, got %d", s.HPMax)
+	case s.MPMax < 0:
+		return fmt.Errorf("stats: MPMax must be >= 0, got %d", s.MPMax)
+	case s.Attack < 0 || s.Defense < 0 || s.Magic < 0 || s.Resist < 0:
+		return fmt.Errorf("stats: Attack/Defense/Magic/Resist must be >= 0, got %d/%d/%d/%d",
+			s.Attack, s.Defense, s.Magic, s.Resist)
+	case s.Speed <= 0:
+		return fmt.Errorf("stats: Speed must be > 0, got %d", s.Speed)
+	case s.CritRate < 0 || s.CritRate > 1:
+		return fmt.Errorf("stats: CritRate must be in [0, 1], got %g", s.CritRate)
+	case s.CritMult < 1:
+		return fmt.Errorf("stats: CritMult must be >= 1, got %g", s.CritMult)
+	case s.CritResist < 0 || s.CritResist > 1:
+		return fmt.Errorf("stats: CritResist must be in [0, 1], got %g", s.CritResist)
+	}
+	return nil
 }
 
 func (s *Stats) Clone() Stats {
 	return Stats{
-		HPMax:    s.HPMax,
-		HP:       s.HP,
-		MPMax:    s.MPMax,
-		MP:       s.MP,
-		Attack:   s.Attack,
-		Defense:  s.Defense,
-		Magic:    s.Magic,
-		Resist:   s.Resist,
-		Speed:    s.Speed,
-		CritRate: s.CritRate,
-		CritMult: s.CritMult,
+		HPMax:      s.HPMax,
+		HP:         s.HP,
+		MPMax:      s.MPMax,
+		MP:         s.MP,
+		Attack:     s.Attack,
+		Defense:    s.Defense,
+		Magic:      s.Magic,
+		Resist:     s.Resist,
+		Speed:      s.Speed,
+		CritRate:   s.CritRate,
+		CritMult:   s.CritMult,
+		CritResist: s.CritResist,
 	}
 }
 
@@ -55,6 +84,9 @@ type Item struct {
 	Name        string
 	Description string
 	Consumable  bool
+	Stackable   bool
+	Count       int // Units in this slot; 0 means 1
+	MaxStack    int // Units per slot for stackable items; 0 = defaultStackSize
 	HealHP      int
 	HealMP      int
 	EquipSlot   string
@@ -86,37 +118,133 @@ type Skill struct {
 	DamageMultiplier float64
 	DamageType       DamageType
 	HealHP           int
-	TargetAll        bool
-	Effect           *Effect // Optional effect to apply on targets
+	TargetAll        bool       // Legacy shortcut for TargetMode AllEnemies
+	TargetMode       TargetMode // Who the skill hits; see resolveTargets
+	ReviveHPPercent  float64    // >0: targets dead allies and revives them with this HP fraction
+	TauntRounds      int        // >0: tops each target's threat table for this many rounds
+	CastTime         int        // >0: turns of casting before it resolves; see casting.go
+	Effect           *Effect    // Optional effect to apply on targets
+}
+
+func (s Skill) IsRevive() bool {
+	return s.ReviveHPPercent > 0
+}
+
+// IsBuff reports whether the skill only puts a beneficial effect on its targets.
+func (s Skill) IsBuff() bool {
+	return s.Effect != nil && !s.Effect.IsDebuff() && s.DamageMultiplier == 0 && s.HealHP == 0 && !s.IsRevive()
 }
 
 type Effect struct {
-	ID       string
-	Name     string
-	Duration int
-	AtkMod   int
-	DefMod   int
-	SpeedMod int
-	DotHP    int
-	From     string
+	ID            string
+	Name          string
+	Duration      int
+	AtkMod        int
+	DefMod        int
+	SpeedMod      int
+	CritResistMod float64
+	DotHP         int        // Per turn: >0 damage, <0 regeneration
+	DotType       DamageType // Damage type of DotHP; empty means Pure
+	From          string     // ID of whoever applied it; DotHP is credited to them
+	Stun          bool       // The bearer loses its turns and any cast in progress
 }
 
 func (e *Effect) Tick() {
 	e.Duration--
 }
 
+// IsDebuff reports whether the effect hurts its bearer.
+func (e *Effect) IsDebuff() bool {
+	return e.AtkMod < 0 || e.DefMod < 0 || e.SpeedMod < 0 || e.CritResistMod < 0 || e.DotHP > 0 || e.Stun
+}
+
+// Phase is a boss behavior change that fires once when HP drops to
+// HPThreshold (a fraction of HPMax) or below.
+type Phase struct {
+	HPThreshold  float64
+	AddSkills    []Skill
+	Effects      []Effect
+	ClearDebuffs bool
+	MessageKey   string // Localized line, receives the character name
+
+	triggered bool
+}
+
+// defaultStackSize is used for stackable items without a MaxStack.
+const defaultStackSize = 10
+
+// defaultInvSlots is the inventory size of a new character.
+const defaultInvSlots = 10
+
+var errInventoryFull = errors.New("inventory full")
+
+// stackSize returns how many units of the item fit into one slot.
+func (it Item) stackSize() int {
+	if !it.Stackable {
+		return 1
+	}
+	if it.MaxStack > 0 {
+		return it.MaxStack
+	}
+	return defaultStackSize
+}
+
+func (it Item) count() int {
+	if it.Count < 1 {
+		return 1
+	}
+	return it.Count
+}
+
 type Inventory struct {
-	Items []Item
+	Items    []Item
+	MaxSlots int // 0 = unlimited
 }
 
-func (inv *Inventory) Add(item Item) {
-	inv.Items = append(inv.Items, item)
+// Add puts the item into the inventory, topping up existing stacks with the
+// same ID first and splitting the rest over free slots. If not everything
+// fits, it returns how many units were left over together with
+// errInventoryFull; the units that did fit stay in the inventory.
+func (inv *Inventory) Add(item Item) (int, error) {
+	left := item.count()
+	size := item.stackSize()
+	if item.Stackable {
+		for i := range inv.Items {
+			if left == 0 {
+				break
+			}
+			st := &inv.Items[i]
+			if st.ID != item.ID || !st.Stackable {
+				continue
+			}
+			n := min(size-st.count(), left)
+			if n > 0 {
+				st.Count = st.count() + n
+				left -= n
+			}
+		}
+	}
+	for left > 0 {
+		if inv.MaxSlots > 0 && len(inv.Items) >= inv.MaxSlots {
+			return left, errInventoryFull
+		}
+		slot := item
+		slot.Count = min(size, left)
+		inv.Items = append(inv.Items, slot)
+		left -= slot.Count
+	}
+	return 0, nil
 }
 
+// RemoveAt takes one unit from slot i; the slot disappears with its last unit.
 func (inv *Inventory) RemoveAt(i int) {
 	if i < 0 || i >= len(inv.Items) {
 		return
 	}
+	if inv.Items[i].count() > 1 {
+		inv.Items[i].Count--
+		return
+	}
 	inv.Items = append(inv.Items[:i], inv.Items[i+1:]...)
 }
 
@@ -129,11 +257,32 @@ type Character struct {
 	Inv     Inventory
 	Skills  []Skill
 	Effects []Effect
+	Phases  []Phase
 	Alive   bool
 	Team    string
+
+	// Morale and surrender (see morale.go). Only Humanoid characters track
+	// morale; Kind selects their surrender lines.
+	Humanoid         bool
+	Kind             string
+	Morale           int
+	Surrendered      bool
+	SurrenderRefused bool
+	XPReward         int // bonus XP for the players when it surrenders
+	XP               int
+
+	Casting  *Cast // Skill being cast, nil if none (see casting.go)
+	arriving bool  // Just substituted in: loses its first turn (see roster.go)
+
+	battle *Battle // Set by AddTeam; receives threat from damage and heals
 }
 
-func NewCharacter(id, name, team string, baseStats Stats) *Character {
+// NewCharacter creates a character at full HP/MP. Stats that fail Validate
+// are rejected instead of producing a broken fighter.
+func NewCharacter(id, name, team string, baseStats Stats) (*Character, error) {
+	if err := baseStats.Validate(); err != nil {
+		return nil, fmt.Errorf("character %s: %w", id, err)
+	}
 	s := baseStats.Clone()
 	s.HP = s.HPMax
 	s.MP = s.MPMax
@@ -144,11 +293,34 @@ func NewCharacter(id, name, team string, baseStats Stats) *Character {
 		Stats:   s,
 		Weapon:  nil,
 		Armor:   nil,
-		Inv:     Inventory{},
+		Inv:     Inventory{MaxSlots: defaultInvSlots},
 		Skills:  []Skill{},
 		Effects: []Effect{},
 		Alive:   true,
+		Morale:  maxMorale,
+	}, nil
+}
+
+// mustCharacter unwraps NewCharacter for the built-in rosters, whose stats
+// are constants: an error there is a programming mistake.
+func mustCharacter(c *Character, err error) *Character {
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// clampVitals keeps HP and MP inside [0, max] after HPMax/MPMax change
+// (armor bonuses, level-ups) or after healing.
+func (c *Character) clampVitals() {
+	if c.Stats.HPMax < 1 {
+		c.Stats.HPMax = 1
 	}
+	if c.Stats.MPMax < 0 {
+		c.Stats.MPMax = 0
+	}
+	c.Stats.HP = max(0, min(c.Stats.HP, c.Stats.HPMax))
+	c.Stats.MP = max(0, min(c.Stats.MP, c.Stats.MPMax))
 }
 
 func (c *Character) EffectiveAttack() int {
@@ -181,17 +353,64 @@ func (c *Character) EffectiveSpeed() int {
 	return sp
 }
 
-func (c *Character) ApplyEffectsStartTurn(logFunc func(string)) {
-	totalDot := 0
+func (c *Character) EffectiveCritResist() float64 {
+	cr := c.Stats.CritResist
 	for _, e := range c.Effects {
-		if e.DotHP != 0 {
-			totalDot += e.DotHP
+		cr += e.CritResistMod
+	}
+	return cr
+}
+
+// rollCrit is the single crit formula for attacks and skills: the attacker's
+// CritRate minus the defender's crit resist, clamped at 0.
+func rollCrit(attacker, defender *Character, r *rand.Rand) (bool, float64) {
+	chance := attacker.Stats.CritRate - defender.EffectiveCritResist()
+	if chance < 0 {
+		chance = 0
+	}
+	return r.Float64() < chance, chance
+}
+
+// ApplyEffectsStartTurn applies every effect's DotHP in order: positive
+// values are damage of the effect's DotType (Pure if unset) and go through
+// TakeDamage, negative values regenerate HP through Heal. A tick may trigger
+// a phase that sheds or adds effects, so the loop walks a copy and skips
+// effects that are gone by their turn.
+func (c *Character) ApplyEffectsStartTurn(logFunc func(string)) {
+	effects := append([]Effect(nil), c.Effects...)
+	for _, e := range effects {
+		if !c.Alive {
+			return
+		}
+		if !c.hasEffect(e) {
+			continue
+		}
+		var src *Character
+		if c.battle != nil && e.From != "" {
+			src = c.battle.byID(e.From)
+		}
+		switch {
+		case e.DotHP > 0:
+			dtype := e.DotType
+			if dtype == "" {
+				dtype = Pure
+			}
+			dealt := c.TakeDamageFrom(src, e.DotHP, dtype, logFunc)
+			logFunc(tr("dot", c.Name, dealt, e.Name))
+		case e.DotHP < 0:
+			logFunc(tr("regen", c.Name, c.HealFrom(src, -e.DotHP), e.Name))
 		}
 	}
-	if totalDot != 0 {
-		c.TakeDamage(totalDot, Pure, logFunc)
-		logFunc(fmt.Sprintf("%s takes %d DOT damage", c.Name, totalDot))
+}
+
+// hasEffect reports whether e is still among the character's effects.
+func (c *Character) hasEffect(e Effect) bool {
+	for _, cur := range c.Effects {
+		if cur == e {
+			return true
+		}
 	}
+	return false
 }
 
 func (c *Character) ApplyEffectsEndTurn(logFunc func(string)) {
@@ -201,13 +420,21 @@ func (c *Character) ApplyEffectsEndTurn(logFunc func(string)) {
 		if c.Effects[i].Duration > 0 {
 			newEffects = append(newEffects, c.Effects[i])
 		} else {
-			logFunc(fmt.Sprintf("Effect %s on %s ended", c.Effects[i].Name, c.Name))
+			logFunc(tr("effect_ended", c.Effects[i].Name, c.Name))
 		}
 	}
 	c.Effects = newEffects
 }
 
-func (c *Character) TakeDamage(amount int, dtype DamageType, logFunc func(string)) {
+// TakeDamage applies mitigation for the damage type and returns the damage
+// actually dealt.
+func (c *Character) TakeDamage(amount int, dtype DamageType, logFunc func(string)) int {
+	return c.TakeDamageFrom(nil, amount, dtype, logFunc)
+}
+
+// TakeDamageFrom is TakeDamage credited to src on the threat table; src may
+// be nil.
+func (c *Character) TakeDamageFrom(src *Character, amount int, dtype DamageType, logFunc func(string)) int {
 	actual := amount
 	if dtype == Physical {
 		def := c.EffectiveDefense()
@@ -220,18 +447,80 @@ func (c *Character) TakeDamage(amount int, dtype DamageType, logFunc func(string
 		actual = 1 // Min damage
 	}
 	c.Stats.HP -= actual
+	if c.battle != nil {
+		c.battle.addDamageThreat(src, c, actual)
+	}
 	if c.Stats.HP <= 0 {
 		c.Stats.HP = 0
 		c.Alive = false
-		logFunc(fmt.Sprintf("%s died!", c.Name))
+		c.Casting = nil
+		logFunc(tr("died", c.Name))
+		return actual
+	}
+	c.checkInterrupt(actual, logFunc)
+	c.checkPhases(logFunc)
+	return actual
+}
+
+// checkPhases fires every untriggered phase whose threshold has been crossed.
+func (c *Character) checkPhases(logFunc func(string)) {
+	for i := range c.Phases {
+		p := &c.Phases[i]
+		if p.triggered || float64(c.Stats.HP) > p.HPThreshold*float64(c.Stats.HPMax) {
+			continue
+		}
+		p.triggered = true
+		if p.MessageKey != "" {
+			logFunc(tr(p.MessageKey, c.Name))
+		}
+		if p.ClearDebuffs {
+			kept := make([]Effect, 0, len(c.Effects))
+			for _, e := range c.Effects {
+				if !e.IsDebuff() {
+					kept = append(kept, e)
+				}
+			}
+			c.Effects = kept
+		}
+		c.Skills = append(c.Skills, p.AddSkills...)
+		for _, e := range p.Effects {
+			c.AddEffect(e, logFunc)
+		}
 	}
 }
 
 func (c *Character) Heal(amount int) {
+	c.HealFrom(nil, amount)
+}
+
+// HealFrom heals and returns the HP actually restored; the healer src (may
+// be nil) draws threat from everyone hostile to it.
+func (c *Character) HealFrom(src *Character, amount int) int {
+	before := c.Stats.HP
 	c.Stats.HP += amount
-	if c.Stats.HP > c.Stats.HPMax {
-		c.Stats.HP = c.Stats.HPMax
+	c.clampVitals()
+	healed := c.Stats.HP - before
+	if c.battle != nil {
+		c.battle.addHealThreat(src, healed)
+	}
+	return healed
+}
+
+// Revive brings a dead character back with a fraction of max HP and no
+// lingering effects.
+func (c *Character) Revive(hpPercent float64) int {
+	hp := int(float64(c.Stats.HPMax) * hpPercent)
+	if hp < 1 {
+		hp = 1
+	}
+	if hp > c.Stats.HPMax {
+		hp = c.Stats.HPMax
 	}
+	c.Stats.HP = hp
+	c.Alive = true
+	c.Effects = []Effect{}
+	c.Casting = nil
+	return hp
 }
 
 func (c *Character) UseMP(amount int) bool {
@@ -242,14 +531,26 @@ func (c *Character) UseMP(amount int) bool {
 	return true
 }
 
+func (c *Character) HasEffect(id string) bool {
+	for _, e := range c.Effects {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Character) AddEffect(e Effect, logFunc func(string)) {
 	c.Effects = append(c.Effects, e)
-	logFunc(fmt.Sprintf("%s gains effect: %s (dur=%d)", c.Name, e.Name, e.Duration))
+	logFunc(tr("effect_gained", c.Name, e.Name, e.Duration))
+	if e.Stun {
+		c.interruptCast(logFunc)
+	}
 }
 
 func (c *Character) EquipWeapon(w *Weapon, logFunc func(string)) {
 	c.Weapon = w
-	logFunc(fmt.Sprintf("%s equips weapon: %s", c.Name, w.Name))
+	logFunc(tr("equip_weapon", c.Name, w.Name))
 }
 
 func (c *Character) UnequipWeapon() {
@@ -261,16 +562,15 @@ func (c *Character) EquipArmor(a *Armor, logFunc func(string)) {
 	if a.HPBonus != 0 {
 		c.Stats.HPMax += a.HPBonus
 		c.Stats.HP += a.HPBonus // Apply bonus
+		c.clampVitals()
 	}
-	logFunc(fmt.Sprintf("%s equips armor: %s", c.Name, a.Name))
+	logFunc(tr("equip_armor", c.Name, a.Name))
 }
 
 func (c *Character) UnequipArmor() {
 	if c.Armor != nil && c.Armor.HPBonus != 0 {
 		c.Stats.HPMax -= c.Armor.HPBonus
-		if c.Stats.HP > c.Stats.HPMax {
-			c.Stats.HP = c.Stats.HPMax
-		}
+		c.clampVitals()
 	}
 	c.Armor = nil
 }
@@ -285,26 +585,36 @@ func (c *Character) BasicAttack(target *Character, logFunc func(string)) {
 		max_ = c.Weapon.DamageMax
 		dtype = c.Weapon.DamageType
 	}
-	base := rand.Intn(max_-min_+1) + min_ + c.EffectiveAttack()
-	if rand.Float64() < c.Stats.CritRate {
+	base := rng.Intn(max_-min_+1) + min_ + c.EffectiveAttack()
+	if crit, chance := rollCrit(c, target, rng); crit {
 		base = int(float64(base) * c.Stats.CritMult)
-		logFunc(fmt.Sprintf("Critical hit! (%s)", c.Name))
+		logFunc(tr("crit", c.Name, chance*100))
 	}
-	logFunc(fmt.Sprintf("%s attacks %s for %d damage (%s)", c.Name, target.Name, base, dtype))
-	target.TakeDamage(base, dtype, logFunc)
+	logFunc(tr("attack", c.Name, target.Name, base, tr(string(dtype))))
+	target.TakeDamageFrom(c, base, dtype, logFunc)
 }
 
 func (c *Character) UseSkillAt(idx int, targets []*Character, logFunc func(string)) {
 	if idx < 0 || idx >= len(c.Skills) {
-		logFunc(fmt.Sprintf("%s tried to use invalid skill", c.Name))
+		logFunc(tr("skill_invalid", c.Name))
 		return
 	}
 	s := c.Skills[idx]
 	if !c.UseMP(s.MPCost) {
-		logFunc(fmt.Sprintf("%s lacks MP for %s", c.Name, s.Name))
+		logFunc(tr("skill_no_mp", c.Name, s.Name))
 		return
 	}
-	logFunc(fmt.Sprintf("%s uses skill %s", c.Name, s.Name))
+	c.resolveSkill(s, targets, logFunc)
+}
+
+// resolveSkill applies an already paid skill to its targets.
+func (c *Character) resolveSkill(s Skill, targets []*Character, logFunc func(string)) {
+	logFunc(tr("skill_use", c.Name, s.Name))
+	if s.Effect != nil {
+		e := *s.Effect
+		e.From = c.ID
+		s.Effect = &e
+	}
 	// Damage
 	if s.DamageMultiplier > 0 {
 		for _, t := range targets {
@@ -315,26 +625,53 @@ func (c *Character) UseSkillAt(idx int, targets []*Character, logFunc func(strin
 			if s.DamageType == Magic {
 				power = int(float64(c.Stats.Magic) * s.DamageMultiplier)
 			}
-			power += rand.Intn(3) - 1
-			if rand.Float64() < c.Stats.CritRate {
+			power += rng.Intn(3) - 1
+			if crit, chance := rollCrit(c, t, rng); crit {
 				power = int(float64(power) * c.Stats.CritMult)
-				logFunc(fmt.Sprintf("Skill crit! (%s)", c.Name))
+				logFunc(tr("skill_crit", c.Name, chance*100))
 			}
-			logFunc(fmt.Sprintf("%s deals %d damage to %s with %s", c.Name, power, t.Name, s.Name))
-			t.TakeDamage(power, s.DamageType, logFunc)
+			logFunc(tr("skill_damage", c.Name, power, t.Name, s.Name))
+			t.TakeDamageFrom(c, power, s.DamageType, logFunc)
 			if s.Effect != nil {
 				t.AddEffect(*s.Effect, logFunc)
 			}
 		}
 	}
+	// Taunt
+	if s.TauntRounds > 0 && c.battle != nil {
+		for _, t := range targets {
+			if t.Fighting() {
+				c.battle.tauntBy(c, t, s.TauntRounds)
+				logFunc(tr("skill_taunt", c.Name, t.Name, s.TauntRounds))
+			}
+		}
+	}
+	// Revive
+	if s.IsRevive() {
+		for _, t := range targets {
+			if t.Alive {
+				continue
+			}
+			hp := t.Revive(s.ReviveHPPercent)
+			logFunc(tr("skill_revive", c.Name, t.Name, hp))
+		}
+	}
+	// Buff
+	if s.IsBuff() {
+		for _, t := range targets {
+			if t.Alive {
+				t.AddEffect(*s.Effect, logFunc)
+			}
+		}
+	}
 	// Heal
 	if s.HealHP > 0 {
 		for _, t := range targets {
 			if !t.Alive {
 				continue
 			}
-			t.Heal(s.HealHP)
-			logFunc(fmt.Sprintf("%s heals %s for %d HP", c.Name, t.Name, s.HealHP))
+			t.HealFrom(c, s.HealHP)
+			logFunc(tr("skill_heal", c.Name, t.Name, s.HealHP))
 			if s.Effect != nil {
 				t.AddEffect(*s.Effect, logFunc)
 			}
@@ -344,126 +681,416 @@ func (c *Character) UseSkillAt(idx int, targets []*Character, logFunc func(strin
 
 func chooseFirstAlive(list []*Character) *Character {
 	for _, c := range list {
-		if c.Alive {
+		if c.Fighting() {
 			return c
 		}
 	}
 	return nil
 }
 
+func chooseFirstDead(list []*Character) *Character {
+	for _, c := range list {
+		if !c.Alive {
+			return c
+		}
+	}
+	return nil
+}
+
+// reviveSkillIdx returns the first affordable revive skill, or -1.
+func (c *Character) reviveSkillIdx() int {
+	for i, s := range c.Skills {
+		if s.IsRevive() && c.Stats.MP >= s.MPCost {
+			return i
+		}
+	}
+	return -1
+}
+
+// Encounter is the narrative frame of a battle: message keys shown
+// before the first round and after the result.
+type Encounter struct {
+	IntroKey string
+	OutroKey string
+}
+
 type Battle struct {
-	Players []*Character
-	Enemies []*Character
-	Round   int
+	Teams     map[string][]*Character
+	Hostility map[string]map[string]bool // Hostility[a][b]: a and b fight each other
+	Round     int
+	Encounter Encounter
+	// AcceptSurrender asks the players whether to accept an enemy's
+	// surrender; nil accepts every offer.
+	AcceptSurrender func(enemy *Character) bool
+	// Controls puts a team under manual control (interactive mode); nil
+	// leaves everyone to the AI.
+	Controls *Controls
+	// Threat holds the threat tables by character ID (see threat.go);
+	// Verbose logs them at the start of every round.
+	Threat  map[string]*ThreatTable
+	Verbose bool
+	// Bench holds each team's reserves (see roster.go). Substitute picks
+	// one of them when the team's active members are all down; nil brings
+	// in the first, a nil result declines.
+	Bench      map[string][]*Character
+	Substitute func(out *Character, reserves []*Character) *Character
+
+	order       []string        // team insertion order, keeps turn order deterministic
+	substituted map[string]bool // teams that used their substitution
 }
 
+// rng drives every roll in battle; tests replace it with a fixed seed.
+var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// noDelay disables the "thinking" pauses (used by tests).
+var noDelay bool
+
+func pause(d time.Duration) {
+	if !noDelay {
+		time.Sleep(d)
+	}
+}
+
+// NewBattle is the classic two-sided fight: "player" against "enemy".
 func NewBattle(players []*Character, enemies []*Character) *Battle {
-	return &Battle{
-		Players: players,
-		Enemies: enemies,
-		Round:   0,
+	b := &Battle{}
+	b.AddTeam("player", players)
+	b.AddTeam("enemy", enemies)
+	return b
+}
+
+// AddTeam registers a team; it starts out hostile to every team added before.
+func (b *Battle) AddTeam(name string, members []*Character) {
+	if b.Teams == nil {
+		b.Teams = map[string][]*Character{}
+		b.Hostility = map[string]map[string]bool{}
+	}
+	if _, ok := b.Teams[name]; !ok {
+		b.order = append(b.order, name)
+		b.Hostility[name] = map[string]bool{}
+	}
+	for _, c := range members {
+		c.Team = name
+		c.battle = b
 	}
+	b.Teams[name] = append(b.Teams[name], members...)
+	for _, other := range b.order {
+		if other != name {
+			b.SetHostile(name, other, true)
+		}
+	}
+}
+
+// SetHostile configures whether teams a and b attack each other (symmetric).
+func (b *Battle) SetHostile(a, c string, hostile bool) {
+	if b.Hostility[a] == nil {
+		b.Hostility[a] = map[string]bool{}
+	}
+	if b.Hostility[c] == nil {
+		b.Hostility[c] = map[string]bool{}
+	}
+	b.Hostility[a][c] = hostile
+	b.Hostility[c][a] = hostile
+}
+
+func (b *Battle) IsHostile(a, c string) bool {
+	return a != c && b.Hostility[a][c]
 }
 
+// AllDead reports whether no member of team is still fighting: surrendered
+// characters count as out of the battle. Benched members do not count here;
+// benchRescue brings one in before the team is taken for beaten.
 func (b *Battle) AllDead(team string) bool {
-	var chars []*Character
-	if team == "player" {
-		chars = b.Players
-	} else {
-		chars = b.Enemies
-	}
-	for _, c := range chars {
-		if c.Alive {
+	for _, c := range b.Teams[team] {
+		if c.Fighting() {
 			return false
 		}
 	}
 	return true
 }
 
+// AliveTeams lists teams with survivors in insertion order.
+func (b *Battle) AliveTeams() []string {
+	var alive []string
+	for _, name := range b.order {
+		if !b.AllDead(name) {
+			alive = append(alive, name)
+		}
+	}
+	return alive
+}
+
+// Over reports whether no two surviving teams are hostile to each other.
+func (b *Battle) Over() bool {
+	alive := b.AliveTeams()
+	for i := range alive {
+		for j := i + 1; j < len(alive); j++ {
+			if b.IsHostile(alive[i], alive[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Winner is the first surviving team, or "" if everyone is dead.
+func (b *Battle) Winner() string {
+	if alive := b.AliveTeams(); len(alive) > 0 {
+		return alive[0]
+	}
+	return ""
+}
+
+// pickHostileTeam returns the members of a random hostile team that still
+// has survivors.
+func (b *Battle) pickHostileTeam(team string) []*Character {
+	var candidates []string
+	for _, name := range b.order {
+		if b.IsHostile(team, name) && !b.AllDead(name) {
+			candidates = append(candidates, name)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return b.Teams[candidates[0]]
+	}
+	return b.Teams[candidates[rng.Intn(len(candidates))]]
+}
+
+// initiativeJitter bounds the random part of an initiative roll: every round
+// each living character rolls EffectiveSpeed + rng.Intn(initiativeJitter).
+var initiativeJitter = 3
+
+// hasteFactor: a character whose EffectiveSpeed reaches hasteFactor times its
+// base Speed (e.g. under "Ускорение") acts once more at the end of the round.
+const hasteFactor = 2
+
+// rollInitiative returns the living combatants in acting order for one round.
+// Higher roll goes first; equal rolls are ordered by team name and then by
+// character ID, so for a given rng seed the order is always the same. Rolls
+// are made team by team in b.order to keep rng consumption stable.
+func (b *Battle) rollInitiative() []*Character {
+	type entry struct {
+		c    *Character
+		roll int
+	}
+	var entries []entry
+	for _, name := range b.order {
+		for _, c := range b.Teams[name] {
+			if !c.Fighting() {
+				continue
+			}
+			roll := c.EffectiveSpeed()
+			if initiativeJitter > 1 {
+				roll += rng.Intn(initiativeJitter)
+			}
+			entries = append(entries, entry{c, roll})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, o := entries[i], entries[j]
+		if a.roll != o.roll {
+			return a.roll > o.roll
+		}
+		if a.c.Team != o.c.Team {
+			return a.c.Team < o.c.Team
+		}
+		return a.c.ID < o.c.ID
+	})
+	order := make([]*Character, len(entries))
+	for i, e := range entries {
+		order[i] = e.c
+	}
+	return order
+}
+
+// Hasted reports whether the character earns an extra action this round.
+func (c *Character) Hasted() bool {
+	return c.Stats.Speed > 0 && c.EffectiveSpeed() >= hasteFactor*c.Stats.Speed
+}
+
+// Turn plays one round. Initiative is re-rolled every round, so speed effects
+// gained mid-round change the order from the next round on. Haste is checked
+// after everyone has acted: a character hasted by then acts once more (without
+// another tick of its effects).
 func (b *Battle) Turn(logFunc func(string)) {
+	b.turn(context.Background(), logFunc)
+}
+
+// turn is Turn that checks ctx before every action and stops with ctx.Err()
+// once it is cancelled, so no further actor acts.
+func (b *Battle) turn(ctx context.Context, logFunc func(string)) error {
 	b.Round++
-	logFunc(fmt.Sprintf("=== Round %d ===", b.Round))
-	all := append([]*Character{}, b.Players...)
-	all = append(all, b.Enemies...)
-	// Sort by speed descending
-	sort.Slice(all, func(i, j int) bool {
-		return all[i].EffectiveSpeed() > all[j].EffectiveSpeed()
-	})
+	b.startRound(logFunc)
+	order := b.rollInitiative()
 
-	for _, actor := range all {
-		if !actor.Alive {
+	for _, actor := range order {
+		if !actor.Fighting() {
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		// Simulate "thinking" delay
-		logFunc(fmt.Sprintf("%s is thinking...", actor.Name))
-		time.Sleep(1 * time.Second)
+		logFunc(tr("thinking", actor.Name))
+		pause(1 * time.Second)
 
 		actor.ApplyEffectsStartTurn(logFunc)
-		time.Sleep(500 * time.Millisecond) // Short delay after DOT
+		pause(500 * time.Millisecond) // Short delay after DOT
 
-		var targets []*Character
-		if actor.Team == "player" {
-			targets = b.Enemies
-		} else {
-			targets = b.Players
-		}
-
-		usedAction := false
-		// Improved AI: 50% chance to use random skill if possible, else basic attack
-		if len(actor.Skills) > 0 && rand.Float64() < 0.5 {
-			// Choose random skill with enough MP
-			skillIdx := rand.Intn(len(actor.Skills))
-			s := actor.Skills[skillIdx]
-			if actor.Stats.MP >= s.MPCost {
-				targ := []*Character{chooseFirstAlive(targets)}
-				if s.HealHP > 0 || (actor.Stats.HP < actor.Stats.HPMax/2 && actor.Team != "player") {
-					targ = []*Character{actor} // Self-heal if low HP for enemies
-				}
-				if s.TargetAll {
-					targ = targets
-				}
-				actor.UseSkillAt(skillIdx, targ, logFunc)
-				usedAction = true
-				time.Sleep(1 * time.Second) // Delay after skill
-			}
+		switch {
+		case !actor.Fighting():
+		case actor.arriving:
+			actor.arriving = false
+			logFunc(tr("sub_arriving", actor.Name))
+		case actor.Stunned():
+			logFunc(tr("stunned", actor.Name))
+		case b.advanceCast(actor, logFunc):
+		case !(actor.wantsToSurrender() && b.offerSurrender(actor, logFunc)):
+			b.act(actor, logFunc)
 		}
 
-		if !usedAction {
-			// Basic attack
-			target := chooseFirstAlive(targets)
-			if target != nil {
-				actor.BasicAttack(target, logFunc)
-				time.Sleep(1 * time.Second) // Delay after attack
-			}
+		actor.ApplyEffectsEndTurn(logFunc)
+		pause(500 * time.Millisecond) // Short delay after effects
+		b.updateMorale(logFunc)
+		b.benchRescue(logFunc)
+
+		if b.Over() {
+			return nil
 		}
+	}
 
-		actor.ApplyEffectsEndTurn(logFunc)
-		time.Sleep(500 * time.Millisecond) // Short delay after effects
+	for _, actor := range order {
+		if !actor.Fighting() || !actor.Hasted() || actor.Stunned() || actor.Casting != nil {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		logFunc(tr("extra_action", actor.Name))
+		pause(1 * time.Second)
+		b.act(actor, logFunc)
+		b.updateMorale(logFunc)
+		b.benchRescue(logFunc)
+		if b.Over() {
+			return nil
+		}
+	}
+	return nil
+}
 
-		if b.AllDead("player") || b.AllDead("enemy") {
-			return
+// act lets the actor's controller decide one action and carries it out.
+func (b *Battle) act(actor *Character, logFunc func(string)) {
+	b.execute(actor, b.controllerFor(actor).Decide(b, actor), logFunc)
+}
+
+// Run plays the battle, writing the log to out one line per message. If ctx
+// is cancelled the battle stops before the next actor acts and Run returns
+// ctx.Err() with no winner.
+func (b *Battle) Run(ctx context.Context, out io.Writer) (string, error) {
+	return b.run(ctx, func(msg string) {
+		fmt.Fprintln(out, msg)
+	})
+}
+
+// RunWithLog plays rounds until the battle is over and returns the winner.
+func (b *Battle) RunWithLog(logFunc func(string)) string {
+	winner, _ := b.run(context.Background(), logFunc)
+	return winner
+}
+
+func (b *Battle) run(ctx context.Context, logFunc func(string)) (string, error) {
+	if b.Encounter.IntroKey != "" {
+		logFunc(tr(b.Encounter.IntroKey))
+	}
+	for !b.Over() {
+		if err := b.turn(ctx, logFunc); err != nil {
+			logFunc(tr("battle_aborted"))
+			return "", err
 		}
 	}
+	winner := b.Winner()
+	switch winner {
+	case "player":
+		logFunc(tr("players_win"))
+	case "enemy":
+		logFunc(tr("enemies_win"))
+	case "":
+		logFunc(tr("draw"))
+	default:
+		logFunc(tr("team_wins", winner))
+	}
+	if winner == "player" {
+		b.collectLoot(logFunc)
+	}
+	if b.Encounter.OutroKey != "" {
+		logFunc(tr(b.Encounter.OutroKey))
+	}
+	return winner, nil
 }
 
-func (b *Battle) Run() {
-	logFunc := func(msg string) {
-		fmt.Println(msg)
+// collectLoot moves the items of fallen enemies to the first living player.
+// Whatever does not fit into a full inventory is left behind.
+func (b *Battle) collectLoot(logFunc func(string)) {
+	picker := chooseFirstAlive(b.Teams["player"])
+	if picker == nil {
+		return
 	}
-	for !b.AllDead("player") && !b.AllDead("enemy") {
-		b.Turn(logFunc)
+	for _, name := range b.order {
+		if !b.IsHostile("player", name) {
+			continue
+		}
+		for _, e := range b.Teams[name] {
+			if e.Alive {
+				continue
+			}
+			e.Inv.Items = giveItems(picker, e.Inv.Items, logFunc)
+		}
 	}
-	if b.AllDead("enemy") {
-		logFunc("Players win!")
-	} else {
-		logFunc("Enemies win!")
+}
+
+// giveItems moves items into picker's inventory and returns what did not fit.
+func giveItems(picker *Character, items []Item, logFunc func(string)) []Item {
+	var kept []Item
+	for _, it := range items {
+		left, err := picker.Inv.Add(it)
+		if taken := it.count() - left; taken > 0 {
+			logFunc(tr("loot_pickup", picker.Name, it.Name, taken))
+		}
+		if err != nil {
+			logFunc(tr("loot_full", picker.Name, it.Name, left))
+			it.Count = left
+			kept = append(kept, it)
+		}
 	}
+	return kept
 }
 
+var (
+	goblinEncounter = Encounter{IntroKey: "intro_goblins", OutroKey: "outro_goblins"}
+	bossEncounter   = Encounter{IntroKey: "intro_boss", OutroKey: "outro_boss"}
+)
+
 func setupBattle() *Battle {
-	rand.Seed(time.Now().UnixNano()) //TODO: to correct
+	b := NewBattle(setupParty(), setupGoblins())
+	b.Encounter = goblinEncounter
+	return b
+}
 
+// setupBossBattle pits the party against the orc warlord.
+func setupBossBattle() *Battle {
+	b := NewBattle(setupParty(), []*Character{newWarlord()})
+	b.Encounter = bossEncounter
+	return b
+}
+
+// heroProfile, when set, replaces the default hero in setupParty.
+var heroProfile *Profile
+
+func setupParty() []*Character {
 	heroStats := Stats{
 		HPMax:    60,
 		MPMax:    30,
@@ -475,7 +1102,7 @@ func setupBattle() *Battle {
 		CritRate: 0.12,
 		CritMult: 1.7,
 	}
-	hero := NewCharacter("p1", "Герой", "player", heroStats)
+	hero := mustCharacter(NewCharacter("p1", "Герой", "player", heroStats))
 	skillFire := Skill{
 		ID:               "s1",
 		Name:             "Огненный шар",
@@ -484,7 +1111,14 @@ func setupBattle() *Battle {
 		DamageMultiplier: 2.2,
 		DamageType:       Magic,
 	}
-	hero.Skills = append(hero.Skills, skillFire)
+	skillHaste := Skill{
+		ID:          "hs1",
+		Name:        "Ускорение",
+		Description: "Удваивает скорость: лишнее действие в конце раунда",
+		MPCost:      8,
+		Effect:      &Effect{ID: "haste", Name: "Ускорение", Duration: 2, SpeedMod: heroStats.Speed},
+	}
+	hero.Skills = append(hero.Skills, skillFire, skillHaste)
 	hero.EquipWeapon(&Weapon{
 		Name:        "Меч новичка",
 		DamageMin:   3,
@@ -498,6 +1132,11 @@ func setupBattle() *Battle {
 		ResistBonus:  0,
 		HPBonus:      5,
 	}, func(string) {}) // Dummy
+	if heroProfile != nil {
+		if h, err := heroProfile.Build(); err == nil {
+			hero = h
+		}
+	}
 
 	// companion
 	clericStats := Stats{
@@ -511,7 +1150,7 @@ func setupBattle() *Battle {
 		CritRate: 0.05,
 		CritMult: 1.5,
 	}
-	cleric := NewCharacter("p2", "Жрец", "player", clericStats)
+	cleric := mustCharacter(NewCharacter("p2", "Жрец", "player", clericStats))
 	healSkill := Skill{
 		ID:         "he1",
 		Name:       "Исцеление",
@@ -519,7 +1158,15 @@ func setupBattle() *Battle {
 		HealHP:     18,
 		DamageType: Magic,
 	}
-	cleric.Skills = append(cleric.Skills, healSkill)
+	reviveSkill := Skill{
+		ID:              "rv1",
+		Name:            "Воскрешение",
+		Description:     "Возвращает павшего союзника с третью здоровья",
+		MPCost:          20,
+		ReviveHPPercent: 0.33,
+		DamageType:      Magic,
+	}
+	cleric.Skills = append(cleric.Skills, healSkill, reviveSkill)
 	cleric.EquipWeapon(&Weapon{
 		Name:       "Посох",
 		DamageMin:  1,
@@ -528,7 +1175,73 @@ func setupBattle() *Battle {
 		MagicBonus: 1,
 	}, func(string) {}) // Dummy
 
-	// enemies
+	return []*Character{hero, cleric}
+}
+
+// setupReserves are the stock recruits who start the campaign on the bench.
+func setupReserves() []*Character {
+	archer := mustCharacter(NewCharacter("p3", "Лучница", "player", Stats{
+		HPMax:    40,
+		MPMax:    20,
+		Attack:   7,
+		Defense:  2,
+		Magic:    2,
+		Resist:   2,
+		Speed:    8,
+		CritRate: 0.15,
+		CritMult: 1.8,
+	}))
+	archer.Skills = append(archer.Skills, Skill{
+		ID:               "as1",
+		Name:             "Прицельный выстрел",
+		Description:      "Мощный выстрел в одну цель",
+		MPCost:           6,
+		DamageMultiplier: 1.8,
+		DamageType:       Physical,
+	})
+	archer.EquipWeapon(&Weapon{Name: "Короткий лук", DamageMin: 3, DamageMax: 5, DamageType: Physical}, func(string) {})
+
+	guard := mustCharacter(NewCharacter("p4", "Страж", "player", Stats{
+		HPMax:    75,
+		MPMax:    15,
+		Attack:   5,
+		Defense:  5,
+		Magic:    1,
+		Resist:   3,
+		Speed:    4,
+		CritRate: 0.05,
+		CritMult: 1.5,
+	}))
+	guard.Skills = append(guard.Skills, Skill{
+		ID:          "gs1",
+		Name:        "Вызов",
+		Description: "Заставляет врагов бить стража",
+		MPCost:      5,
+		TargetMode:  AllEnemies,
+		TauntRounds: 2,
+	})
+	guard.EquipWeapon(&Weapon{Name: "Булава", DamageMin: 3, DamageMax: 6, DamageType: Physical}, func(string) {})
+	guard.EquipArmor(&Armor{Name: "Кольчуга", DefenseBonus: 2, HPBonus: 10}, func(string) {})
+
+	return []*Character{archer, guard}
+}
+
+// setupCampaign is the stock roster: the party of setupParty in battle,
+// the recruits of setupReserves on the bench.
+func setupCampaign() *Campaign {
+	party := setupParty()
+	ids := make([]string, len(party))
+	for i, c := range party {
+		ids[i] = c.ID
+	}
+	camp, err := NewCampaign(append(party, setupReserves()...), ids...)
+	if err != nil {
+		panic(err)
+	}
+	return camp
+}
+
+func setupGoblins() []*Character {
 	goblinStats := Stats{
 		HPMax:    20,
 		MPMax:    5,
@@ -540,10 +1253,14 @@ func setupBattle() *Battle {
 		CritRate: 0.06,
 		CritMult: 1.5,
 	}
-	gob1 := NewCharacter("e1", "Гоблин-1", "enemy", goblinStats)
+	gob1 := mustCharacter(NewCharacter("e1", "Гоблин-1", "enemy", goblinStats))
 	gob1.EquipWeapon(&Weapon{Name: "Короткий кинжал", DamageMin: 2, DamageMax: 4, DamageType: Physical}, func(string) {})
-	gob2 := NewCharacter("e2", "Гоблин-2", "enemy", goblinStats)
+	gob2 := mustCharacter(NewCharacter("e2", "Гоблин-2", "enemy", goblinStats))
 	gob2.EquipWeapon(&Weapon{Name: "Короткий кинжал", DamageMin: 2, DamageMax: 4, DamageType: Physical}, func(string) {})
+	for _, g := range []*Character{gob1, gob2} {
+		g.Humanoid, g.Kind, g.XPReward = true, "goblin", 15
+		g.Inv.Add(Item{ID: "potion", Name: "Зелье лечения", Consumable: true, HealHP: 15, Stackable: true, Count: 2})
+	}
 
 	orcStats := Stats{
 		HPMax:    35,
@@ -556,26 +1273,103 @@ func setupBattle() *Battle {
 		CritRate: 0.08,
 		CritMult: 1.4,
 	}
-	orc := NewCharacter("e3", "Орк", "enemy", orcStats)
+	orc := mustCharacter(NewCharacter("e3", "Орк", "enemy", orcStats))
 	orc.EquipWeapon(&Weapon{Name: "Клевец", DamageMin: 5, DamageMax: 8, DamageType: Physical}, func(string) {})
+	orc.Humanoid, orc.Kind, orc.XPReward = true, "orc", 25
 
-	players := []*Character{hero, cleric}
-	enemies := []*Character{gob1, gob2, orc}
+	return []*Character{gob1, gob2, orc}
+}
 
-	return NewBattle(players, enemies)
+// newWarlord is the example boss: at half HP he enrages — sheds debuffs,
+// speeds up and learns a cleaving strike.
+func newWarlord() *Character {
+	boss := mustCharacter(NewCharacter("b1", "Вождь орков", "enemy", Stats{
+		HPMax:      90,
+		MPMax:      20,
+		Attack:     9,
+		Defense:    5,
+		Magic:      2,
+		Resist:     3,
+		Speed:      5,
+		CritRate:   0.1,
+		CritMult:   1.5,
+		CritResist: 0.05,
+	}))
+	boss.EquipWeapon(&Weapon{Name: "Двуручный топор", DamageMin: 6, DamageMax: 10, DamageType: Physical}, func(string) {})
+	boss.Phases = []Phase{{
+		HPThreshold:  0.5,
+		ClearDebuffs: true,
+		AddSkills: []Skill{{
+			ID:               "bs1",
+			Name:             "Рассекающий удар",
+			Description:      "Бьёт всех противников",
+			MPCost:           5,
+			DamageMultiplier: 1.2,
+			DamageType:       Physical,
+			TargetAll:        true,
+		}},
+		Effects:    []Effect{{ID: "enrage", Name: "Ярость", Duration: 99, SpeedMod: 4, AtkMod: 2}},
+		MessageKey: "boss_enrage",
+	}}
+	return boss
 }
 
 func main() {
+	lang := flag.String("lang", LangRU, "battle log language (ru|en)")
+	boss := flag.Bool("boss", false, "fight the orc warlord instead of the goblin band")
+	profilePath := flag.String("profile", "hero.json", "saved hero profile (created by the wizard if missing)")
+	defaultHero := flag.Bool("default-hero", false, "skip character creation and play the stock hero")
+	manual := flag.Bool("manual", false, "choose the party's actions yourself instead of watching the AI")
+	auto := flag.String("auto", "", "with -manual: comma-separated party IDs (p1,p2) that start on auto-battle")
+	verbose := flag.Bool("v", false, "log every enemy's threat table at the start of each round")
+	flag.Parse()
+	loc = NewLocalizer(*lang)
+
 	reader := bufio.NewReader(os.Stdin)
+	if !*defaultHero {
+		p, err := NewWizard(reader, os.Stdout).LoadOrCreate(*profilePath)
+		if err != nil {
+			fmt.Println(tr("wiz_error", err))
+			return
+		}
+		heroProfile = &p
+	}
+	campaign := setupCampaign()
 	for {
-		battle := setupBattle()
-		battle.Run()
+		campaign.PartyMenu(reader, os.Stdout)
+		enemies, encounter := setupGoblins(), goblinEncounter
+		if *boss {
+			enemies, encounter = []*Character{newWarlord()}, bossEncounter
+		}
+		battle := campaign.Battle(enemies)
+		battle.Encounter = encounter
+		battle.Substitute = askSubstitute(reader, os.Stdout)
+		battle.Verbose = *verbose
+		battle.AcceptSurrender = func(enemy *Character) bool {
+			fmt.Print(tr("surrender_prompt", enemy.Name))
+			input, _ := reader.ReadString('\n')
+			return strings.ToLower(strings.TrimSpace(input)) == "y"
+		}
+		if *manual {
+			var ids []string
+			if *auto != "" {
+				ids = strings.Split(*auto, ",")
+			}
+			battle.Controls = interactiveControls("player", reader, os.Stdout, ids)
+		}
+		// Ctrl+C aborts the current battle cleanly
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		_, err := battle.Run(ctx, os.Stdout)
+		stop()
+		if err != nil {
+			return
+		}
 
-		fmt.Print("Play again? (y/n): ")
+		fmt.Print(tr("play_again"))
 		input, _ := reader.ReadString('\n')
 		input = strings.TrimSpace(input)
 		if strings.ToLower(input) != "y" {
-			fmt.Println("Thanks for playing!")
+			fmt.Println(tr("goodbye"))
 			return
 		}
 		fmt.Println() // Extra newline for readability
@@ -0,0 +1,1034 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func init() {
+	noDelay = true
+}
+
+// testChar builds a character from partial stats, filling the fields that
+// Validate requires but the test does not care about.
+func testChar(id, name, team string, s Stats) *Character {
+	if s.Speed == 0 {
+		s.Speed = 1
+	}
+	if s.CritMult == 0 {
+		s.CritMult = 1.5
+	}
+	return mustCharacter(NewCharacter(id, name, team, s))
+}
+
+// renderBattle plays the default encounter with a fixed seed and returns its log.
+func renderBattle(t *testing.T, lang string) ([]string, *Localizer) {
+	t.Helper()
+	prev := loc
+	loc = NewLocalizer(lang)
+	defer func() { loc = prev }()
+
+	b := setupBattle()
+	rng = rand.New(rand.NewSource(42))
+	var lines []string
+	b.RunWithLog(func(msg string) { lines = append(lines, msg) })
+	return lines, loc
+}
+
+func TestBattleLogBothLanguages(t *testing.T) {
+	ru, locRU := renderBattle(t, LangRU)
+	en, locEN := renderBattle(t, LangEN)
+
+	if len(ru) == 0 || len(ru) != len(en) {
+		t.Fatalf("same seed should give logs of equal length: ru=%d en=%d", len(ru), len(en))
+	}
+	if ru[0] != catalogs[LangRU]["intro_goblins"] || en[0] != catalogs[LangEN]["intro_goblins"] {
+		t.Fatalf("intro not shown first: %q / %q", ru[0], en[0])
+	}
+	if en[len(en)-1] != catalogs[LangEN]["outro_goblins"] {
+		t.Fatalf("outro not shown last: %q", en[len(en)-1])
+	}
+	if !strings.HasPrefix(en[1], "=== Round 1") || !strings.HasPrefix(ru[1], "=== Раунд 1") {
+		t.Fatalf("unexpected first round line: %q / %q", ru[1], en[1])
+	}
+
+	for _, l := range []*Localizer{locRU, locEN} {
+		for _, key := range l.UsedKeys() {
+			for lang, cat := range catalogs {
+				if _, ok := cat[key]; !ok {
+					t.Errorf("key %q emitted but missing from %s catalog", key, lang)
+				}
+			}
+		}
+	}
+}
+
+func TestCatalogsHaveSameKeys(t *testing.T) {
+	for key := range catalogs[LangRU] {
+		if _, ok := catalogs[LangEN][key]; !ok {
+			t.Errorf("en catalog lacks %q", key)
+		}
+	}
+	for key := range catalogs[LangEN] {
+		if _, ok := catalogs[LangRU][key]; !ok {
+			t.Errorf("ru catalog lacks %q", key)
+		}
+	}
+}
+
+func TestMissingKeyFallsBack(t *testing.T) {
+	l := NewLocalizer(LangEN)
+	if got := l.T("no_such_key", 1); got != "no_such_key" {
+		t.Fatalf("expected key fallback, got %q", got)
+	}
+}
+
+func TestReviveActsNextRound(t *testing.T) {
+	rng = rand.New(rand.NewSource(7))
+	b := setupBattle()
+	hero, cleric := b.Teams["player"][0], b.Teams["player"][1]
+	hero.TakeDamage(1000, Pure, func(string) {})
+	if hero.Alive {
+		t.Fatal("hero should be dead")
+	}
+	hero.Effects = append(hero.Effects, Effect{ID: "poison", Name: "Яд", Duration: 3, DotHP: 2})
+
+	var lines []string
+	logFunc := func(msg string) { lines = append(lines, msg) }
+	idx := cleric.reviveSkillIdx()
+	if idx < 0 {
+		t.Fatal("cleric should have an affordable revive skill")
+	}
+	cleric.UseSkillAt(idx, []*Character{hero}, logFunc)
+	if !hero.Alive || hero.Stats.HP != int(float64(hero.Stats.HPMax)*0.33) {
+		t.Fatalf("hero not revived correctly: alive=%v hp=%d", hero.Alive, hero.Stats.HP)
+	}
+	if len(hero.Effects) != 0 {
+		t.Fatalf("effects should be cleared on revive, got %v", hero.Effects)
+	}
+
+	// Enemies can't kill anyone in a single round at these HP totals.
+	lines = nil
+	b.Turn(logFunc)
+	thinking := tr("thinking", hero.Name)
+	found := false
+	for _, l := range lines {
+		if l == thinking {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("revived hero did not act in the following round:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestAIPrefersReviveOverHeal(t *testing.T) {
+	rng = rand.New(rand.NewSource(1))
+	b := setupBattle()
+	hero, cleric := b.Teams["player"][0], b.Teams["player"][1]
+	hero.TakeDamage(1000, Pure, func(string) {})
+	cleric.Stats.HP = cleric.Stats.HPMax / 3
+	// Only the cleric acts: give enemies no chance by killing them first.
+	for _, e := range b.Teams["enemy"] {
+		e.Alive = false
+	}
+	b.Turn(func(string) {})
+	if !hero.Alive {
+		t.Fatal("AI should revive the fallen hero before healing")
+	}
+}
+
+func TestRollCritResist(t *testing.T) {
+	att := testChar("a", "A", "player", Stats{HPMax: 10, CritRate: 0.3})
+	def := testChar("d", "D", "enemy", Stats{HPMax: 10, CritResist: 0.1})
+	r := rand.New(rand.NewSource(1))
+
+	if _, chance := rollCrit(att, def, r); chance < 0.199 || chance > 0.201 {
+		t.Fatalf("expected chance 0.2, got %v", chance)
+	}
+	def.Effects = append(def.Effects, Effect{Name: "Стойкость", Duration: 2, CritResistMod: 0.5})
+	for i := 0; i < 100; i++ {
+		crit, chance := rollCrit(att, def, r)
+		if chance != 0 || crit {
+			t.Fatalf("chance must clamp at 0 and never crit, got %v crit=%v", chance, crit)
+		}
+	}
+}
+
+func TestThreeWayBattle(t *testing.T) {
+	rng = rand.New(rand.NewSource(3))
+	mk := func(id, team string) *Character {
+		return testChar(id, id, team, Stats{HPMax: 20, Attack: 4, Defense: 1, Speed: 5})
+	}
+	b := &Battle{}
+	b.AddTeam("player", []*Character{mk("hero", "")})
+	b.AddTeam("bandits", []*Character{mk("bandit1", ""), mk("bandit2", "")})
+	b.AddTeam("undead", []*Character{mk("skeleton", "")})
+	if !b.IsHostile("bandits", "undead") || !b.IsHostile("player", "undead") {
+		t.Fatal("all teams should start hostile to each other")
+	}
+
+	winner := b.RunWithLog(func(string) {})
+	if alive := b.AliveTeams(); len(alive) > 1 {
+		t.Fatalf("battle ended with several teams alive: %v", alive)
+	}
+	if winner != b.Winner() || (winner != "" && b.AllDead(winner)) {
+		t.Fatalf("bad winner %q", winner)
+	}
+}
+
+func TestAlliedTeamsEndBattle(t *testing.T) {
+	rng = rand.New(rand.NewSource(4))
+	hero := testChar("hero", "hero", "", Stats{HPMax: 30, Attack: 8, Speed: 6})
+	ranger := testChar("ranger", "ranger", "", Stats{HPMax: 30, Attack: 8, Speed: 5})
+	wolf := testChar("wolf", "wolf", "", Stats{HPMax: 10, Attack: 2, Speed: 4})
+	b := &Battle{}
+	b.AddTeam("player", []*Character{hero})
+	b.AddTeam("rangers", []*Character{ranger})
+	b.AddTeam("wolves", []*Character{wolf})
+	b.SetHostile("player", "rangers", false)
+
+	b.RunWithLog(func(string) {})
+	if wolf.Alive || !hero.Alive || !ranger.Alive {
+		t.Fatalf("allies should not fight: hero=%v ranger=%v wolf=%v", hero.Alive, ranger.Alive, wolf.Alive)
+	}
+}
+
+func TestBossPhaseFiresOnce(t *testing.T) {
+	rng = rand.New(rand.NewSource(5))
+	boss := newWarlord()
+	boss.AddEffect(Effect{ID: "slow", Name: "Замедление", Duration: 5, SpeedMod: -2}, func(string) {})
+	skills := len(boss.Skills)
+
+	var enraged int
+	logFunc := func(msg string) {
+		if msg == tr("boss_enrage", boss.Name) {
+			enraged++
+		}
+	}
+	boss.TakeDamage(10, Pure, logFunc)
+	if enraged != 0 {
+		t.Fatal("phase fired above the threshold")
+	}
+
+	// A multi-target skill that lists the boss several times hits him repeatedly.
+	hero := testChar("h", "Герой", "player", Stats{HPMax: 50, MPMax: 50, Attack: 30})
+	hero.Skills = []Skill{{ID: "m", Name: "Шквал", DamageMultiplier: 1, DamageType: Pure, TargetAll: true}}
+	hero.UseSkillAt(0, []*Character{boss, boss, boss}, logFunc)
+
+	if enraged != 1 {
+		t.Fatalf("phase fired %d times, want exactly 1", enraged)
+	}
+	if len(boss.Skills) != skills+1 {
+		t.Fatalf("expected one new skill, have %d", len(boss.Skills))
+	}
+	for _, e := range boss.Effects {
+		if e.IsDebuff() {
+			t.Fatalf("debuff %s should be shed", e.Name)
+		}
+	}
+	if boss.EffectiveSpeed() <= boss.Stats.Speed {
+		t.Fatal("enrage should speed the boss up")
+	}
+}
+
+func TestInitiativeTiebreakDuplicateSpeeds(t *testing.T) {
+	prev := initiativeJitter
+	initiativeJitter = 1 // no random part: order is decided by speed and tiebreakers only
+	defer func() { initiativeJitter = prev }()
+
+	stats := Stats{HPMax: 10, Speed: 5}
+	fast := stats
+	fast.Speed = 9
+	b := NewBattle(
+		[]*Character{testChar("p2", "B", "player", stats), testChar("p1", "A", "player", stats)},
+		[]*Character{testChar("e1", "C", "enemy", stats), testChar("e2", "D", "enemy", fast)},
+	)
+	var ids []string
+	for _, c := range b.rollInitiative() {
+		ids = append(ids, c.ID)
+	}
+	// Fastest first; among the speed-5 ties "enemy" < "player", then by ID.
+	if got, want := strings.Join(ids, ","), "e2,e1,p1,p2"; got != want {
+		t.Fatalf("initiative order = %s, want %s", got, want)
+	}
+}
+
+func TestInitiativeRerolledEachRound(t *testing.T) {
+	stats := Stats{HPMax: 10, Speed: 5}
+	var roster []*Character
+	for _, id := range []string{"p1", "p2", "p3", "p4"} {
+		roster = append(roster, testChar(id, id, "player", stats))
+	}
+	b := NewBattle(roster, nil)
+
+	order := func() string {
+		var ids []string
+		for _, c := range b.rollInitiative() {
+			ids = append(ids, c.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+	rng = rand.New(rand.NewSource(1))
+	first := order()
+	changed := false
+	for i := 0; i < 20 && !changed; i++ {
+		changed = order() != first
+	}
+	if !changed {
+		t.Fatal("equal-speed characters should not keep the same order every round")
+	}
+
+	rng = rand.New(rand.NewSource(1))
+	if again := order(); again != first {
+		t.Fatalf("same seed should give same order: %s vs %s", first, again)
+	}
+}
+
+func TestHasteGrantsExtraAction(t *testing.T) {
+	rng = rand.New(rand.NewSource(3))
+	b := setupBattle()
+	hero := b.Teams["player"][0]
+	hero.Skills = nil // basic attacks only
+	hero.AddEffect(Effect{ID: "haste", Name: "Ускорение", Duration: 5, SpeedMod: hero.Stats.Speed}, func(string) {})
+	if !hero.Hasted() {
+		t.Fatal("doubling speed should haste the hero")
+	}
+
+	var lines []string
+	b.Turn(func(msg string) { lines = append(lines, msg) })
+	extra := 0
+	for _, l := range lines {
+		if l == tr("extra_action", hero.Name) {
+			extra++
+		}
+	}
+	if extra != 1 {
+		t.Fatalf("hasted hero should act once more per round, got %d extra actions", extra)
+	}
+	if hero.Effects[0].Duration != 4 {
+		t.Fatalf("extra action must not tick effects again, duration=%d", hero.Effects[0].Duration)
+	}
+}
+
+func TestInventoryStacking(t *testing.T) {
+	potion := Item{ID: "potion", Name: "Зелье", Stackable: true, MaxStack: 5}
+	inv := Inventory{MaxSlots: 2}
+
+	p := potion
+	p.Count = 3
+	if left, err := inv.Add(p); err != nil || left != 0 || len(inv.Items) != 1 {
+		t.Fatalf("first stack: left=%d err=%v slots=%d", left, err, len(inv.Items))
+	}
+	// 3 + 4: tops up the stack to 5 and opens a second slot with 2
+	p.Count = 4
+	if left, err := inv.Add(p); err != nil || left != 0 {
+		t.Fatalf("merge: left=%d err=%v", left, err)
+	}
+	if len(inv.Items) != 2 || inv.Items[0].Count != 5 || inv.Items[1].Count != 2 {
+		t.Fatalf("unexpected stacks %+v", inv.Items)
+	}
+	// Only 3 units of space remain: the rest is reported back
+	p.Count = 7
+	left, err := inv.Add(p)
+	if !errors.Is(err, errInventoryFull) || left != 4 || inv.Items[1].Count != 5 {
+		t.Fatalf("partial fit: left=%d err=%v stacks=%+v", left, err, inv.Items)
+	}
+	if _, err := inv.Add(Item{ID: "sword", Name: "Меч"}); !errors.Is(err, errInventoryFull) {
+		t.Fatalf("non-stackable item into full inventory: %v", err)
+	}
+
+	inv.RemoveAt(0)
+	if inv.Items[0].Count != 4 || len(inv.Items) != 2 {
+		t.Fatalf("RemoveAt should decrement first: %+v", inv.Items)
+	}
+	single := Inventory{}
+	single.Add(Item{ID: "sword", Name: "Меч"})
+	single.RemoveAt(0)
+	if len(single.Items) != 0 {
+		t.Fatalf("last unit should free the slot: %+v", single.Items)
+	}
+}
+
+func TestLootLeavesWhatDoesNotFit(t *testing.T) {
+	b := setupBattle()
+	hero := b.Teams["player"][0]
+	hero.Inv = Inventory{MaxSlots: 1}
+	hero.Inv.Add(Item{ID: "potion", Name: "Зелье лечения", Stackable: true, Count: 9})
+	for _, e := range b.Teams["enemy"] {
+		e.TakeDamage(1000, Pure, func(string) {})
+	}
+
+	var lines []string
+	b.collectLoot(func(msg string) { lines = append(lines, msg) })
+	if hero.Inv.Items[0].Count != defaultStackSize {
+		t.Fatalf("stack should be topped up to %d, got %d", defaultStackSize, hero.Inv.Items[0].Count)
+	}
+	goblin := b.Teams["enemy"][0]
+	if len(goblin.Inv.Items) != 1 || goblin.Inv.Items[0].Count != 1 {
+		t.Fatalf("leftover should stay with the goblin: %+v", goblin.Inv.Items)
+	}
+	if !strings.Contains(strings.Join(lines, "\n"), tr("loot_full", hero.Name, "Зелье лечения", 1)) {
+		t.Fatalf("full inventory not logged:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestDotSkippedAfterPhaseClearsDebuffs(t *testing.T) {
+	c := testChar("x", "X", "enemy", Stats{HPMax: 100})
+	c.Stats.HP = 55
+	c.Phases = []Phase{{HPThreshold: 0.5, ClearDebuffs: true, Effects: []Effect{{ID: "rage", Name: "Ярость", Duration: 3, AtkMod: 5}}}}
+	c.Effects = []Effect{
+		{ID: "poison", Name: "Яд", Duration: 2, DotHP: 10},
+		{ID: "burn", Name: "Ожог", Duration: 2, DotHP: 10},
+	}
+	var lines []string
+	c.ApplyEffectsStartTurn(func(msg string) { lines = append(lines, msg) })
+	if c.Stats.HP != 45 {
+		t.Fatalf("a DoT cleared by the phase still ticked: hp=%d\n%s", c.Stats.HP, strings.Join(lines, "\n"))
+	}
+	if len(c.Effects) != 1 || c.Effects[0].ID != "rage" {
+		t.Fatalf("effects after the phase: %+v", c.Effects)
+	}
+}
+
+func TestBurnMitigatedByResist(t *testing.T) {
+	c := testChar("x", "X", "player", Stats{HPMax: 50, Resist: 6})
+	c.Effects = []Effect{{ID: "burn", Name: "Ожог", Duration: 2, DotHP: 10, DotType: Magic}}
+	var lines []string
+	c.ApplyEffectsStartTurn(func(msg string) { lines = append(lines, msg) })
+	if c.Stats.HP != 43 {
+		t.Fatalf("burn should be reduced by resist/2: hp=%d", c.Stats.HP)
+	}
+	if len(lines) != 1 || lines[0] != tr("dot", "X", 7, "Ожог") {
+		t.Fatalf("dot log should name the effect and mitigated damage: %q", lines)
+	}
+}
+
+func TestRegenCappedAtHPMax(t *testing.T) {
+	c := testChar("x", "X", "player", Stats{HPMax: 50})
+	c.Stats.HP = 45
+	c.Effects = []Effect{{ID: "regen", Name: "Регенерация", Duration: 3, DotHP: -10}}
+	var lines []string
+	c.ApplyEffectsStartTurn(func(msg string) { lines = append(lines, msg) })
+	if c.Stats.HP != 50 {
+		t.Fatalf("regen should cap at HPMax, hp=%d", c.Stats.HP)
+	}
+	if lines[0] != tr("regen", "X", 5, "Регенерация") {
+		t.Fatalf("regen log should report the HP actually restored: %q", lines[0])
+	}
+	if c.Effects[0].IsDebuff() {
+		t.Fatal("regeneration is not a debuff")
+	}
+}
+
+func TestMixedEffectsAppliedInOrder(t *testing.T) {
+	c := testChar("x", "X", "player", Stats{HPMax: 30, Defense: 4})
+	c.Stats.HP = 10
+	c.Effects = []Effect{
+		{ID: "poison", Name: "Яд", Duration: 2, DotHP: 3},                             // pure: -3
+		{ID: "regen", Name: "Регенерация", Duration: 2, DotHP: -5},                    // +5
+		{ID: "bleed", Name: "Кровотечение", Duration: 2, DotHP: 6, DotType: Physical}, // 6 - 4/2 = -4
+	}
+	var lines []string
+	c.ApplyEffectsStartTurn(func(msg string) { lines = append(lines, msg) })
+	want := []string{tr("dot", "X", 3, "Яд"), tr("regen", "X", 5, "Регенерация"), tr("dot", "X", 4, "Кровотечение")}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Fatalf("effects out of order:\n got %q\nwant %q", lines, want)
+	}
+	if c.Stats.HP != 8 {
+		t.Fatalf("hp=%d, want 8", c.Stats.HP)
+	}
+}
+
+func TestRunWritesToWriter(t *testing.T) {
+	prev := loc
+	loc = NewLocalizer(LangEN)
+	defer func() { loc = prev }()
+	rng = rand.New(rand.NewSource(42))
+
+	goblins := setupGoblins()
+	for _, g := range goblins {
+		g.Inv.Items = nil
+	}
+	b := NewBattle(setupParty(), goblins) // no intro/outro/loot: the result is the last line
+	var out bytes.Buffer
+	winner, err := b.Run(context.Background(), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	last := lines[len(lines)-1]
+	want := map[string]string{"player": "Players win!", "enemy": "Enemies win!"}[winner]
+	if want == "" || last != want {
+		t.Fatalf("winner %q, last line %q", winner, last)
+	}
+}
+
+func TestRunCancelStopsBeforeNextActor(t *testing.T) {
+	prev := loc
+	loc = NewLocalizer(LangEN)
+	defer func() { loc = prev }()
+
+	b := setupBattle()
+	ctx, cancel := context.WithCancel(context.Background())
+	var lines []string
+	// Cancel while the first actor of round 1 is acting
+	_, err := b.run(ctx, func(msg string) {
+		lines = append(lines, msg)
+		if strings.HasSuffix(msg, " is thinking...") {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	thinking := 0
+	for _, l := range lines {
+		if strings.HasSuffix(l, " is thinking...") {
+			thinking++
+		}
+	}
+	if thinking != 1 || lines[len(lines)-1] != "Battle aborted." || b.Round != 1 {
+		t.Fatalf("battle should stop after the current actor: thinking=%d round=%d\n%s",
+			thinking, b.Round, strings.Join(lines, "\n"))
+	}
+}
+
+func TestStatsValidate(t *testing.T) {
+	good := Stats{HPMax: 10, Speed: 3, CritRate: 0.1, CritMult: 1.5}
+	if err := good.Validate(); err != nil {
+		t.Fatalf("valid stats rejected: %v", err)
+	}
+	bad := map[string]func(s *Stats){
+		"zero HPMax":       func(s *Stats) { s.HPMax = 0 },
+		"negative MPMax":   func(s *Stats) { s.MPMax = -1 },
+		"negative Attack":  func(s *Stats) { s.Attack = -2 },
+		"zero Speed":       func(s *Stats) { s.Speed = 0 },
+		"CritRate above 1": func(s *Stats) { s.CritRate = 1.2 },
+		"negative crit":    func(s *Stats) { s.CritRate = -0.1 },
+		"CritMult below 1": func(s *Stats) { s.CritMult = 0 },
+	}
+	for name, mutate := range bad {
+		s := good
+		mutate(&s)
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		if c, err := NewCharacter("x", "X", "player", s); err == nil || c != nil {
+			t.Errorf("%s: NewCharacter should refuse", name)
+		}
+	}
+}
+
+func TestClampVitals(t *testing.T) {
+	c := testChar("x", "X", "player", Stats{HPMax: 20, MPMax: 10})
+	c.EquipArmor(&Armor{Name: "Проклятая кольчуга", HPBonus: -5}, func(string) {})
+	if c.Stats.HPMax != 15 || c.Stats.HP != 15 {
+		t.Fatalf("negative HP bonus: hp=%d/%d", c.Stats.HP, c.Stats.HPMax)
+	}
+	c.UnequipArmor()
+	if c.Stats.HPMax != 20 || c.Stats.HP != 15 {
+		t.Fatalf("unequip should restore max without healing: hp=%d/%d", c.Stats.HP, c.Stats.HPMax)
+	}
+
+	c.EquipArmor(&Armor{Name: "Латы", HPBonus: 10}, func(string) {})
+	c.Heal(100)
+	c.UnequipArmor()
+	if c.Stats.HP != 20 {
+		t.Fatalf("HP above the new max should be clamped, got %d", c.Stats.HP)
+	}
+
+	c.Stats.MPMax = 4 // e.g. a level-down curse
+	c.clampVitals()
+	if c.Stats.MP != 4 {
+		t.Fatalf("MP should follow MPMax, got %d", c.Stats.MP)
+	}
+}
+
+// targetingRoster: three players and five enemies, the last of each dead.
+func targetingRoster() (*Battle, []*Character, []*Character) {
+	st := Stats{HPMax: 20}
+	players := []*Character{
+		testChar("p1", "P1", "player", st),
+		testChar("p2", "P2", "player", st),
+		testChar("p3", "P3", "player", st),
+	}
+	enemies := []*Character{
+		testChar("e1", "E1", "enemy", st),
+		testChar("e2", "E2", "enemy", st),
+		testChar("e3", "E3", "enemy", st),
+		testChar("e4", "E4", "enemy", st),
+		testChar("e5", "E5", "enemy", st),
+	}
+	players[2].Alive = false
+	enemies[4].Alive = false
+	return NewBattle(players, enemies), players, enemies
+}
+
+func targetIDs(list []*Character) string {
+	ids := make([]string, len(list))
+	for i, c := range list {
+		ids[i] = c.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestResolveTargetsModes(t *testing.T) {
+	b, players, enemies := targetingRoster()
+	actor := players[0]
+	cases := []struct {
+		name   string
+		skill  Skill
+		chosen *Character
+		want   string
+	}{
+		{"single enemy", Skill{TargetMode: SingleEnemy}, enemies[1], "e2"},
+		{"single enemy ignores ally", Skill{TargetMode: SingleEnemy}, players[1], "e1"},
+		{"single enemy ignores dead", Skill{TargetMode: SingleEnemy}, enemies[4], "e1"},
+		{"all enemies", Skill{TargetMode: AllEnemies}, nil, "e1,e2,e3,e4"},
+		{"legacy TargetAll", Skill{TargetAll: true}, enemies[2], "e1,e2,e3,e4"},
+		{"single ally", Skill{TargetMode: SingleAlly}, players[1], "p2"},
+		{"single ally falls back to self", Skill{TargetMode: SingleAlly}, enemies[0], "p1"},
+		{"dead ally only for revive", Skill{TargetMode: SingleAlly}, players[2], "p1"},
+		{"revive dead ally", Skill{ReviveHPPercent: 0.5}, players[2], "p3"},
+		{"all allies", Skill{TargetMode: AllAllies}, nil, "p1,p2"},
+		{"everyone", Skill{TargetMode: Everyone}, nil, "p2,e1,e2,e3,e4"},
+	}
+	for _, tc := range cases {
+		rng = rand.New(rand.NewSource(1))
+		if got := targetIDs(resolveTargets(actor, tc.skill, tc.chosen, b)); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestResolveTargetsSplash(t *testing.T) {
+	b, players, enemies := targetingRoster()
+	s := Skill{TargetMode: Splash}
+	for seed := int64(0); seed < 20; seed++ {
+		rng = rand.New(rand.NewSource(seed))
+		got := resolveTargets(players[0], s, enemies[2], b)
+		if len(got) != 3 || got[0] != enemies[2] {
+			t.Fatalf("seed %d: want chosen plus 2, got %s", seed, targetIDs(got))
+		}
+		seen := map[*Character]bool{}
+		for _, c := range got {
+			if seen[c] || !c.Alive || c.Team != "enemy" {
+				t.Fatalf("seed %d: bad splash target list %s", seed, targetIDs(got))
+			}
+			seen[c] = true
+		}
+	}
+
+	// Fewer living enemies than the splash size: everyone left is hit once
+	enemies[0].Alive, enemies[1].Alive = false, false
+	if got := targetIDs(resolveTargets(players[0], s, enemies[3], b)); got != "e4,e3" {
+		t.Fatalf("small team splash: got %s", got)
+	}
+}
+
+// surrenderRoster: two players against a humanoid goblin and a second enemy.
+func surrenderRoster() (*Battle, []*Character, *Character, *Character) {
+	st := Stats{HPMax: 40, Attack: 1}
+	players := []*Character{testChar("p1", "P1", "player", st), testChar("p2", "P2", "player", st)}
+	goblin := testChar("e1", "Goblin", "enemy", st)
+	goblin.Humanoid, goblin.Kind, goblin.XPReward = true, "goblin", 15
+	goblin.Inv.Add(Item{ID: "potion", Name: "Зелье лечения", Stackable: true, Count: 2})
+	other := testChar("e2", "Other", "enemy", st)
+	return NewBattle(players, []*Character{goblin, other}), players, goblin, other
+}
+
+func TestMoraleModel(t *testing.T) {
+	b, _, goblin, other := surrenderRoster()
+	if got := b.moraleOf(goblin); got != maxMorale {
+		t.Fatalf("even fight at full HP: morale %d, want %d", got, maxMorale)
+	}
+
+	goblin.Stats.HP = goblin.Stats.HPMax / 2 // -25
+	if got := b.moraleOf(goblin); got != 75 {
+		t.Fatalf("half HP: morale %d, want 75", got)
+	}
+
+	other.TakeDamage(1000, Pure, func(string) {}) // -25 fallen ally, -10 outnumbered 2:1
+	if got := b.moraleOf(goblin); got != 40 {
+		t.Fatalf("ally fallen: morale %d, want 40", got)
+	}
+
+	goblin.Stats.HP = 1 // 2% HP: -49
+	if got := b.moraleOf(goblin); got != 16 {
+		t.Fatalf("nearly dead: morale %d, want 16", got)
+	}
+	b.AddTeam("wolves", []*Character{testChar("w1", "W1", "wolves", Stats{HPMax: 10}), testChar("w2", "W2", "wolves", Stats{HPMax: 10})})
+	if got := b.moraleOf(goblin); got != 0 {
+		t.Fatalf("morale must not go below 0, got %d", got)
+	}
+
+	var lines []string
+	b.updateMorale(func(msg string) { lines = append(lines, msg) })
+	if goblin.Morale != 0 || len(lines) != 1 || lines[0] != tr("morale_broken", goblin.Name) {
+		t.Fatalf("updateMorale: morale %d, log %q", goblin.Morale, lines)
+	}
+	b.updateMorale(func(msg string) { lines = append(lines, msg) })
+	if len(lines) != 1 {
+		t.Fatalf("breaking should be logged once, got %q", lines)
+	}
+}
+
+func TestSurrenderDecision(t *testing.T) {
+	_, _, goblin, other := surrenderRoster()
+	rng = rand.New(rand.NewSource(1))
+
+	goblin.Morale = surrenderMorale
+	if goblin.wantsToSurrender() {
+		t.Fatal("morale at the threshold must not surrender")
+	}
+	goblin.Morale = 0
+	for i := 0; i < 20; i++ {
+		if !goblin.wantsToSurrender() {
+			t.Fatal("morale 0 should always surrender")
+		}
+	}
+	other.Morale = 0 // not humanoid
+	if other.wantsToSurrender() {
+		t.Fatal("non-humanoids never surrender")
+	}
+	goblin.SurrenderRefused = true
+	if goblin.wantsToSurrender() {
+		t.Fatal("a refused enemy fights on")
+	}
+
+	// Just below the threshold the chance is about 25%
+	goblin.SurrenderRefused, goblin.Morale = false, surrenderMorale-1
+	n := 0
+	for i := 0; i < 1000; i++ {
+		if goblin.wantsToSurrender() {
+			n++
+		}
+	}
+	if n < 200 || n > 340 {
+		t.Fatalf("surrendered %d/1000 times just below the threshold", n)
+	}
+}
+
+func TestAcceptedSurrenderEndsBattle(t *testing.T) {
+	b, players, goblin, other := surrenderRoster()
+	rng = rand.New(rand.NewSource(1))
+	other.TakeDamage(1000, Pure, func(string) {})
+	var asked *Character
+	b.AcceptSurrender = func(e *Character) bool { asked = e; return true }
+
+	var lines []string
+	if !b.offerSurrender(goblin, func(msg string) { lines = append(lines, msg) }) {
+		t.Fatal("accepted offer should report surrender")
+	}
+	if asked != goblin || !goblin.Surrendered || !goblin.Alive {
+		t.Fatalf("asked %v, surrendered %v, alive %v", asked, goblin.Surrendered, goblin.Alive)
+	}
+	if !b.Over() || b.Winner() != "player" {
+		t.Fatalf("no hostile enemies left: over %v, winner %q", b.Over(), b.Winner())
+	}
+	if len(players[0].Inv.Items) != 1 || len(goblin.Inv.Items) != 0 {
+		t.Fatalf("goblin items should go to the first player: %+v / %+v", players[0].Inv.Items, goblin.Inv.Items)
+	}
+	for _, p := range players {
+		if p.XP != goblin.XPReward {
+			t.Fatalf("%s XP %d, want %d", p.Name, p.XP, goblin.XPReward)
+		}
+	}
+	if got := resolveTargets(players[0], Skill{TargetMode: AllEnemies}, goblin, b); len(got) != 0 {
+		t.Fatalf("surrendered enemy must not be targeted: %s", targetIDs(got))
+	}
+	log := strings.Join(lines, "\n")
+	for _, key := range []string{"surrender_offer", "surrender_accepted"} {
+		if !strings.Contains(log, tr(key, goblin.Name)) {
+			t.Fatalf("%s missing from log:\n%s", key, log)
+		}
+	}
+}
+
+func TestRefusedSurrenderKeepsFighting(t *testing.T) {
+	b, players, goblin, other := surrenderRoster()
+	rng = rand.New(rand.NewSource(1))
+	other.TakeDamage(1000, Pure, func(string) {})
+	goblin.Morale = 0
+	b.AcceptSurrender = func(*Character) bool { return false }
+
+	b.Turn(func(string) {})
+	if goblin.Surrendered || !goblin.SurrenderRefused {
+		t.Fatalf("refused goblin: surrendered %v, refused %v", goblin.Surrendered, goblin.SurrenderRefused)
+	}
+	if b.Over() {
+		t.Fatal("battle must go on after a refusal")
+	}
+	if players[0].Stats.HP == players[0].Stats.HPMax && players[1].Stats.HP == players[1].Stats.HPMax {
+		t.Fatal("refused goblin should still have attacked")
+	}
+	if players[0].XP != 0 || len(goblin.Inv.Items) != 1 {
+		t.Fatal("no spoils without an accepted surrender")
+	}
+}
+
+// recordingController logs the rounds in which it decided for each
+// character, then defers to next.
+type recordingController struct {
+	rounds map[string][]int
+	next   func(b *Battle, actor *Character) Action
+}
+
+func (r *recordingController) Decide(b *Battle, actor *Character) Action {
+	r.rounds[actor.ID] = append(r.rounds[actor.ID], b.Round)
+	return r.next(b, actor)
+}
+
+func TestAutoBattleSwitchesAtRoundBoundaries(t *testing.T) {
+	rng = rand.New(rand.NewSource(42))
+	hero := testChar("p1", "Hero", "player", Stats{HPMax: 100, Attack: 5, Speed: 5})
+	dummy := testChar("e1", "Dummy", "enemy", Stats{HPMax: 1000, Speed: 1})
+	hero.AddEffect(Effect{ID: "regen", Name: "Регенерация", Duration: 5, DotHP: -1}, func(string) {})
+	b := NewBattle([]*Character{hero}, []*Character{dummy})
+
+	ai := &recordingController{rounds: map[string][]int{}, next: AIController{}.Decide}
+	var controls *Controls
+	manual := &recordingController{rounds: map[string][]int{}, next: func(b *Battle, actor *Character) Action {
+		controls.SetAuto(actor.ID, true) // "a" mid-round: auto from the next round
+		return Action{Skill: basicAttack, Target: dummy}
+	}}
+	controls = NewControls("player", manual)
+	controls.AI = ai
+	b.Controls = controls
+	controls.SetAuto(hero.ID, true)
+
+	var headers []string
+	logFunc := func(msg string) {
+		if strings.HasPrefix(msg, "===") || msg == tr("round_auto", hero.Name) {
+			headers = append(headers, msg)
+		}
+	}
+	b.Turn(logFunc)               // round 1: auto
+	controls.TakeControl(hero.ID) // space before round 2
+	if !controls.IsAuto(hero.ID) {
+		t.Fatal("taking control must wait for the round boundary")
+	}
+	b.Turn(logFunc) // round 2: manual, which queues auto again
+	b.Turn(logFunc) // round 3: auto
+
+	if got := fmt.Sprint(ai.rounds[hero.ID]); got != "[1 3]" {
+		t.Fatalf("AI decided for the hero in rounds %s, want [1 3]", got)
+	}
+	if got := fmt.Sprint(manual.rounds[hero.ID]); got != "[2]" {
+		t.Fatalf("manual controller decided in rounds %s, want [2]", got)
+	}
+	if got := fmt.Sprint(ai.rounds[dummy.ID]); got != "[1 2 3]" || len(manual.rounds[dummy.ID]) > 0 {
+		t.Fatalf("enemies stay on the AI: %s", got)
+	}
+	want := []string{
+		tr("round", 1), tr("round_auto", hero.Name),
+		tr("round", 2),
+		tr("round", 3), tr("round_auto", hero.Name),
+	}
+	if strings.Join(headers, "|") != strings.Join(want, "|") {
+		t.Fatalf("headers %q, want %q", headers, want)
+	}
+	if len(hero.Effects) != 1 || hero.Effects[0].Duration != 2 {
+		t.Fatalf("effects must survive controller swaps: %+v", hero.Effects)
+	}
+}
+
+// threatBattle: a tank and a healer against one goblin that cannot hurt
+// anybody much.
+func threatBattle() (b *Battle, tank, healer, gob *Character) {
+	tank = testChar("p1", "Tank", "player", Stats{HPMax: 100, MPMax: 20, Attack: 5})
+	healer = testChar("p2", "Healer", "player", Stats{HPMax: 100, MPMax: 50})
+	gob = testChar("e1", "Goblin", "enemy", Stats{HPMax: 1000})
+	tank.Skills = []Skill{{ID: "tt", Name: "Taunt", MPCost: 1, TauntRounds: 2}}
+	healer.Skills = []Skill{{ID: "hh", Name: "Heal", HealHP: 20}}
+	return NewBattle([]*Character{tank, healer}, []*Character{gob}), tank, healer, gob
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestThreatAccumulatesFromDamageAndHealing(t *testing.T) {
+	b, tank, healer, gob := threatBattle()
+	noop := func(string) {}
+	gob.TakeDamage(50, Pure, noop) // no source, no threat
+	if got := b.threatTarget(gob); got != nil {
+		t.Fatalf("unattributed damage gave %s threat", got.Name)
+	}
+
+	// Equal threat: the lower ID wins
+	gob.TakeDamageFrom(tank, 10, Pure, noop)
+	gob.TakeDamageFrom(healer, 10, Pure, noop)
+	if got := b.threatTarget(gob); got != tank {
+		t.Fatalf("tie should go to p1, got %s", got.Name)
+	}
+
+	// Healing 20 HP adds 20 * healThreatFactor = 10 for the healer
+	tank.Stats.HP = 70
+	healer.UseSkillAt(0, []*Character{tank}, noop)
+	table := b.Threat[gob.ID]
+	if !near(table.Of(tank.ID), 10) || !near(table.Of(healer.ID), 20) {
+		t.Fatalf("threat after heal: %+v", table.Threat)
+	}
+	if a := (AIController{}).Decide(b, gob); a.Skill != basicAttack || a.Target != healer {
+		t.Fatalf("goblin should go for the healer, got %+v", a)
+	}
+
+	// Overheal draws threat only for the HP restored; split over two enemies
+	gob2 := testChar("e2", "Goblin-2", "enemy", Stats{HPMax: 1000})
+	b.AddTeam("enemy", []*Character{gob2})
+	tank.Stats.HP = 96
+	tank.HealFrom(healer, 20)
+	if !near(table.Of(healer.ID), 21) || !near(b.Threat[gob2.ID].Of(healer.ID), 1) {
+		t.Fatalf("split heal threat: e1 %v, e2 %v", table.Of(healer.ID), b.Threat[gob2.ID].Of(healer.ID))
+	}
+
+	// Dead foes are never picked
+	healer.TakeDamage(1000, Pure, noop)
+	if got := b.threatTarget(gob); got != tank {
+		t.Fatalf("dead healer still targeted, got %v", got)
+	}
+}
+
+func TestThreatDecaysEachRound(t *testing.T) {
+	b, tank, _, gob := threatBattle()
+	gob.TakeDamageFrom(tank, 100, Pure, func(string) {})
+	for round, want := range []float64{90, 81, 72.9} {
+		b.startRound(func(string) {})
+		if got := b.Threat[gob.ID].Of(tank.ID); !near(got, want) {
+			t.Fatalf("round %d: threat %v, want %v", round+1, got, want)
+		}
+	}
+}
+
+func TestTauntFloorExpires(t *testing.T) {
+	b, tank, healer, gob := threatBattle()
+	b.Verbose = true
+	noop := func(string) {}
+	gob.TakeDamageFrom(healer, 50, Pure, noop)
+	gob.TakeDamageFrom(tank, 5, Pure, noop)
+
+	tank.UseSkillAt(0, []*Character{gob}, noop)
+	if got := b.Threat[gob.ID].Of(tank.ID); got != 51 {
+		t.Fatalf("taunt floor %v, want top+1 = 51", got)
+	}
+	var lines []string
+	for round := 1; round <= 3; round++ {
+		lines = nil
+		b.startRound(func(msg string) { lines = append(lines, msg) })
+		got := b.threatTarget(gob)
+		if want := map[bool]*Character{true: tank, false: healer}[round < 2]; got != want {
+			t.Fatalf("round %d: goblin targets %s, want %s", round, got.Name, want.Name)
+		}
+	}
+	// Floor gone: back to decayed threat (5 * 0.9^3)
+	if got := b.Threat[gob.ID].Of(tank.ID); !near(got, 5*0.9*0.9*0.9) {
+		t.Fatalf("threat after the taunt: %v", got)
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], tr("threat_debug", "Goblin", "Healer")) {
+		t.Fatalf("verbose threat line: %q", lines)
+	}
+}
+
+// castBattle: a mage with a two-turn nuke and a harmless goblin.
+func castBattle() (*Battle, *Character, *Character) {
+	mage := testChar("p1", "Mage", "player", Stats{HPMax: 100, MPMax: 20, Magic: 10})
+	mage.Skills = []Skill{{ID: "meteor", Name: "Meteor", MPCost: 10, DamageMultiplier: 3, DamageType: Magic, CastTime: 2}}
+	gob := testChar("e1", "Goblin", "enemy", Stats{HPMax: 1000})
+	return NewBattle([]*Character{mage}, []*Character{gob}), mage, gob
+}
+
+func TestCastResolvesAfterCastTime(t *testing.T) {
+	rng = rand.New(rand.NewSource(1))
+	b, mage, gob := castBattle()
+	noop := func(string) {}
+	b.execute(mage, Action{Skill: 0, Target: gob}, noop)
+	if mage.Casting == nil || mage.Stats.MP != 10 || gob.Stats.HP != gob.Stats.HPMax {
+		t.Fatalf("cast should start paid and unresolved: casting=%v mp=%d", mage.Casting, mage.Stats.MP)
+	}
+	mage.Skills = nil // basic attacks from here on
+
+	var lines []string
+	b.Turn(func(msg string) { lines = append(lines, msg) })
+	if mage.Casting == nil || mage.Casting.TurnsLeft != 1 {
+		t.Fatalf("cast should carry over the round: %+v", mage.Casting)
+	}
+	if !slices.Contains(lines, tr("cast_continue", "Mage", "Meteor", 1)) || slices.Contains(lines, tr("skill_use", "Mage", "Meteor")) {
+		t.Fatalf("round 1:\n%s", strings.Join(lines, "\n"))
+	}
+
+	lines = nil
+	b.Turn(func(msg string) { lines = append(lines, msg) })
+	if lines[1] != tr("round_casting", "Mage (Meteor, 1)") {
+		t.Fatalf("round header should show the cast: %q", lines[1])
+	}
+	if mage.Casting != nil || !slices.Contains(lines, tr("skill_use", "Mage", "Meteor")) || mage.Stats.MP != 10 {
+		t.Fatalf("round 2: casting=%v mp=%d\n%s", mage.Casting, mage.Stats.MP, strings.Join(lines, "\n"))
+	}
+}
+
+func TestCastInterruptedByHeavyHit(t *testing.T) {
+	rng = rand.New(rand.NewSource(1))
+	b, mage, gob := castBattle()
+	var lines []string
+	logFunc := func(msg string) { lines = append(lines, msg) }
+	b.execute(mage, Action{Skill: 0, Target: gob}, logFunc)
+
+	// Below the threshold (10% of HPMax) a hit never interrupts
+	for i := 0; i < 20; i++ {
+		mage.TakeDamage(9, Pure, logFunc)
+		mage.Stats.HP = mage.Stats.HPMax
+	}
+	if mage.Casting == nil {
+		t.Fatal("a light hit interrupted the cast")
+	}
+	// Half of HPMax: chance 0.5 * interruptScale = certain
+	mage.TakeDamage(50, Pure, logFunc)
+	if mage.Casting != nil {
+		t.Fatal("a heavy hit should interrupt")
+	}
+	if mage.Stats.MP != 15 || lines[len(lines)-1] != tr("cast_interrupted", "Mage", "Meteor", 5) {
+		t.Fatalf("refund: mp=%d, log %q", mage.Stats.MP, lines[len(lines)-1])
+	}
+	b.Turn(func(string) {})
+	if gob.Stats.HP < gob.Stats.HPMax-10 {
+		t.Fatalf("interrupted meteor still landed: goblin HP %d", gob.Stats.HP)
+	}
+}
+
+func TestStunAlwaysInterrupts(t *testing.T) {
+	rng = rand.New(rand.NewSource(1))
+	b, mage, gob := castBattle()
+	noop := func(string) {}
+	b.execute(mage, Action{Skill: 0, Target: gob}, noop)
+	mage.AddEffect(Effect{ID: "stun", Name: "Stun", Duration: 1, Stun: true}, noop)
+	if mage.Casting != nil || mage.Stats.MP != 15 {
+		t.Fatalf("stun: casting=%v mp=%d", mage.Casting, mage.Stats.MP)
+	}
+	var lines []string
+	b.Turn(func(msg string) { lines = append(lines, msg) })
+	if !slices.Contains(lines, tr("stunned", "Mage")) || mage.Stunned() {
+		t.Fatalf("stunned turn:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestAIAttacksCaster(t *testing.T) {
+	b, tank, healer, gob := threatBattle()
+	noop := func(string) {}
+	gob.TakeDamageFrom(tank, 30, Pure, noop)
+	healer.Skills = append(healer.Skills, Skill{ID: "smite", Name: "Smite", MPCost: 1, DamageMultiplier: 1, CastTime: 1})
+	b.execute(healer, Action{Skill: len(healer.Skills) - 1, Target: gob}, noop)
+	for i := 0; i < 10; i++ {
+		if a := (AIController{}).Decide(b, gob); a.Target != healer {
+			t.Fatalf("goblin should go for the caster, got %s", a.Target.Name)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+// Morale and surrender. Humanoid enemies track morale, recalculated after
+// every action from their own HP, fallen allies and how badly they are
+// outnumbered. A humanoid whose morale has broken may offer to surrender on
+// its turn instead of acting; if the players accept, it leaves the fight
+// (no longer hostile, not targeted, not counted by AllDead) and hands over
+// its items plus bonus XP.
+
+const (
+	maxMorale = 100
+	// surrenderMorale: below this a humanoid may offer to surrender.
+	surrenderMorale = 40
+	// Morale penalties: per fallen ally and per extra living foe beyond the
+	// humanoid's own side.
+	moraleAllyFallen  = 25
+	moraleOutnumbered = 10
+)
+
+// surrenderLines are the dialogue keys an enemy kind picks from when it
+// offers to surrender; unknown kinds use the generic lines.
+var surrenderLines = map[string][]string{
+	"goblin": {"surrender_goblin_1", "surrender_goblin_2", "surrender_goblin_3"},
+	"orc":    {"surrender_orc_1", "surrender_orc_2"},
+	"":       {"surrender_generic"},
+}
+
+// Fighting reports whether the character still takes part in the battle.
+func (c *Character) Fighting() bool {
+	return c.Alive && !c.Surrendered
+}
+
+// moraleOf computes the morale of c against the current battle state: HP lost
+// costs up to half of it, each fallen ally and each extra living foe cost a
+// fixed amount.
+func (b *Battle) moraleOf(c *Character) int {
+	morale := maxMorale
+	if c.Stats.HPMax > 0 {
+		hpPct := 100 * c.Stats.HP / c.Stats.HPMax
+		morale -= (100 - hpPct) / 2
+	}
+
+	side, fallen := 0, 0
+	for _, ally := range b.Teams[c.Team] {
+		switch {
+		case ally.Fighting():
+			side++
+		case !ally.Alive:
+			fallen++
+		}
+	}
+	morale -= moraleAllyFallen * fallen
+
+	foes := 0
+	for _, name := range b.order {
+		if b.IsHostile(c.Team, name) {
+			for _, f := range b.Teams[name] {
+				if f.Fighting() {
+					foes++
+				}
+			}
+		}
+	}
+	if foes > side {
+		morale -= moraleOutnumbered * (foes - side)
+	}
+	return max(0, min(maxMorale, morale))
+}
+
+// updateMorale recalculates every fighting humanoid's morale; called after
+// each action, so ally deaths and heavy hits register immediately.
+func (b *Battle) updateMorale(logFunc func(string)) {
+	for _, name := range b.order {
+		for _, c := range b.Teams[name] {
+			if !c.Humanoid || !c.Fighting() {
+				continue
+			}
+			prev := c.Morale
+			c.Morale = b.moraleOf(c)
+			if prev >= surrenderMorale && c.Morale < surrenderMorale {
+				logFunc(tr("morale_broken", c.Name))
+			}
+		}
+	}
+}
+
+// wantsToSurrender decides whether c offers to surrender this turn. The lower
+// its morale, the likelier; an enemy whose offer was refused fights on.
+func (c *Character) wantsToSurrender() bool {
+	if !c.Humanoid || c.SurrenderRefused || c.Morale >= surrenderMorale {
+		return false
+	}
+	chance := 0.25 + 0.75*float64(surrenderMorale-c.Morale)/surrenderMorale
+	return rng.Float64() < chance
+}
+
+// offerSurrender plays the surrender dialogue for c and asks the players via
+// AcceptSurrender (nil accepts). It reports whether c surrendered; on a
+// refusal c goes on to act this turn.
+func (b *Battle) offerSurrender(c *Character, logFunc func(string)) bool {
+	lines, ok := surrenderLines[c.Kind]
+	if !ok {
+		lines = surrenderLines[""]
+	}
+	logFunc(tr("surrender_offer", c.Name))
+	logFunc(tr(lines[rng.Intn(len(lines))], c.Name))
+
+	accept := b.AcceptSurrender == nil || b.AcceptSurrender(c)
+	if !accept {
+		c.SurrenderRefused = true
+		logFunc(tr("surrender_refused", c.Name))
+		return false
+	}
+	c.Surrendered = true
+	logFunc(tr("surrender_accepted", c.Name))
+	b.surrenderSpoils(c, logFunc)
+	return true
+}
+
+// surrenderSpoils gives the players the surrendered enemy's items (to the
+// first fighting player, as with loot) and its XPReward to every fighting
+// player; benched players able to fight get benchXPPercent of it.
+func (b *Battle) surrenderSpoils(c *Character, logFunc func(string)) {
+	players := b.Teams["player"]
+	if picker := chooseFirstAlive(players); picker != nil {
+		c.Inv.Items = giveItems(picker, c.Inv.Items, logFunc)
+	}
+	if c.XPReward <= 0 {
+		return
+	}
+	for _, p := range players {
+		if p.Fighting() {
+			p.XP += c.XPReward
+			logFunc(tr("xp_gain", p.Name, c.XPReward))
+		}
+	}
+	share := c.XPReward * benchXPPercent / 100
+	for _, p := range b.reserves("player") {
+		if share > 0 {
+			p.XP += share
+			logFunc(tr("xp_gain_bench", p.Name, share))
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Roster and bench. The Campaign keeps up to maxRoster characters between
+// battles and picks an active party of up to maxActive of them: only the
+// active party enters Battle.Teams["player"], the rest wait in Battle.Bench.
+//
+// Once per battle a team may substitute: a member spends its action to swap
+// places with a benched one. The outgoing character leaves its effects and
+// any cast behind but keeps its HP and MP; the incoming one loses its first
+// turn getting into position. When every active member is down and the
+// substitution is still unused, the battle offers it (Battle.Substitute)
+// before the team counts as beaten, so a team with a benched survivor loses
+// only if the player declines.
+
+const (
+	maxRoster      = 6
+	maxActive      = 4
+	benchXPPercent = 50 // Share of surrender XP that benched members get
+)
+
+// Campaign is the roster that carries over from battle to battle.
+type Campaign struct {
+	Roster []*Character
+	active []string // IDs of the active party, in party order
+}
+
+// NewCampaign takes the roster and the IDs of the active party; without IDs
+// the first maxActive characters are active.
+func NewCampaign(roster []*Character, active ...string) (*Campaign, error) {
+	if len(roster) == 0 || len(roster) > maxRoster {
+		return nil, fmt.Errorf("roster of %d characters, want 1 to %d", len(roster), maxRoster)
+	}
+	if len(active) == 0 {
+		for _, c := range roster[:min(len(roster), maxActive)] {
+			active = append(active, c.ID)
+		}
+	}
+	camp := &Campaign{Roster: roster}
+	if err := camp.SetActive(active); err != nil {
+		return nil, err
+	}
+	return camp, nil
+}
+
+// SetActive chooses the active party by ID; everyone else goes to the bench.
+func (camp *Campaign) SetActive(ids []string) error {
+	if len(ids) == 0 || len(ids) > maxActive {
+		return fmt.Errorf("party of %d characters, want 1 to %d", len(ids), maxActive)
+	}
+	for i, id := range ids {
+		if camp.member(id) == nil {
+			return fmt.Errorf("no %q in the roster", id)
+		}
+		if slices.Contains(ids[:i], id) {
+			return fmt.Errorf("%q chosen twice", id)
+		}
+	}
+	camp.active = slices.Clone(ids)
+	return nil
+}
+
+func (camp *Campaign) member(id string) *Character {
+	for _, c := range camp.Roster {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// Party is the active party in party order.
+func (camp *Campaign) Party() []*Character {
+	party := make([]*Character, len(camp.active))
+	for i, id := range camp.active {
+		party[i] = camp.member(id)
+	}
+	return party
+}
+
+// Benched is the rest of the roster in roster order.
+func (camp *Campaign) Benched() []*Character {
+	var bench []*Character
+	for _, c := range camp.Roster {
+		if !slices.Contains(camp.active, c.ID) {
+			bench = append(bench, c)
+		}
+	}
+	return bench
+}
+
+// Battle rests the whole roster and sets the active party against enemies,
+// with everyone else on the bench.
+func (camp *Campaign) Battle(enemies []*Character) *Battle {
+	for _, c := range camp.Roster {
+		c.rest()
+	}
+	b := NewBattle(camp.Party(), enemies)
+	b.SetBench("player", camp.Benched())
+	return b
+}
+
+// PartyMenu is the between-battle menu: it shows the roster and reads the
+// IDs of a new active party; Enter keeps the current one.
+func (camp *Campaign) PartyMenu(in *bufio.Reader, out io.Writer) {
+	for {
+		fmt.Fprintln(out, tr("party_roster"))
+		for _, c := range camp.Roster {
+			status := tr("party_benched")
+			if slices.Contains(camp.active, c.ID) {
+				status = tr("party_active")
+			}
+			fmt.Fprintf(out, "  %s) %s (HP %d, MP %d, XP %d) — %s\n", c.ID, c.Name, c.Stats.HPMax, c.Stats.MPMax, c.XP, status)
+		}
+		fmt.Fprint(out, tr("party_prompt", maxActive))
+		line, err := in.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return
+		}
+		var ids []string
+		for _, id := range strings.Split(line, ",") {
+			ids = append(ids, strings.TrimSpace(id))
+		}
+		serr := camp.SetActive(ids)
+		if serr == nil {
+			return
+		}
+		fmt.Fprintln(out, tr("party_error", serr))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// rest restores c between battles: back on its feet with full HP and MP and
+// no effects.
+func (c *Character) rest() {
+	c.Alive, c.Surrendered, c.SurrenderRefused = true, false, false
+	c.Stats.HP, c.Stats.MP = c.Stats.HPMax, c.Stats.MPMax
+	c.Effects = []Effect{}
+	c.Casting = nil
+	c.Morale = maxMorale
+	c.arriving = false
+}
+
+// SetBench puts members on team's bench.
+func (b *Battle) SetBench(team string, members []*Character) {
+	if b.Bench == nil {
+		b.Bench = map[string][]*Character{}
+	}
+	for _, c := range members {
+		c.Team = team
+		c.battle = b
+	}
+	b.Bench[team] = append(b.Bench[team], members...)
+}
+
+// reserves lists team's benched members able to come in.
+func (b *Battle) reserves(team string) []*Character {
+	var list []*Character
+	for _, c := range b.Bench[team] {
+		if c.Fighting() {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// CanSubstitute reports whether team still has its substitution and someone
+// on the bench to bring in.
+func (b *Battle) CanSubstitute(team string) bool {
+	return !b.substituted[team] && len(b.reserves(team)) > 0
+}
+
+var errNoSubstitution = errors.New("no substitution available")
+
+// swap puts in on the field in place of out and uses up the team's
+// substitution.
+func (b *Battle) swap(out, in *Character, logFunc func(string)) error {
+	team := out.Team
+	i, j := slices.Index(b.Teams[team], out), slices.Index(b.Bench[team], in)
+	if i < 0 || j < 0 || !in.Fighting() || !b.CanSubstitute(team) {
+		return errNoSubstitution
+	}
+	b.Teams[team][i], b.Bench[team][j] = in, out
+	b.spendSubstitution(team)
+	out.Effects = []Effect{}
+	out.Casting = nil
+	in.arriving = true
+	logFunc(tr("sub_swap", out.Name, in.Name))
+	return nil
+}
+
+func (b *Battle) spendSubstitution(team string) {
+	if b.substituted == nil {
+		b.substituted = map[string]bool{}
+	}
+	b.substituted[team] = true
+}
+
+// benchRescue runs before every check for the end of the battle: a team
+// whose active members are all down brings in a substitute if it still can
+// and the player agrees (Substitute; nil brings in the first reserve).
+// Declining gives up the substitution, and the team is beaten.
+func (b *Battle) benchRescue(logFunc func(string)) {
+	for _, team := range b.order {
+		if !b.AllDead(team) || !b.CanSubstitute(team) {
+			continue
+		}
+		out, reserves := b.Teams[team][0], b.reserves(team)
+		in := reserves[0]
+		if b.Substitute != nil {
+			in = b.Substitute(out, reserves)
+		}
+		if in == nil || b.swap(out, in, logFunc) != nil {
+			b.spendSubstitution(team)
+			logFunc(tr("sub_declined"))
+		}
+	}
+}
+
+// askSubstitute is the console Battle.Substitute: it lists the reserves and
+// reads a number; Enter declines.
+func askSubstitute(in *bufio.Reader, out io.Writer) func(*Character, []*Character) *Character {
+	return func(_ *Character, reserves []*Character) *Character {
+		fmt.Fprintln(out, tr("sub_offer"))
+		for i, c := range reserves {
+			fmt.Fprintf(out, "  %d) %s (HP %d/%d, MP %d/%d)\n", i+1, c.Name, c.Stats.HP, c.Stats.HPMax, c.Stats.MP, c.Stats.MPMax)
+		}
+		for {
+			fmt.Fprint(out, tr("sub_prompt"))
+			line, err := in.ReadString('\n')
+			line = strings.TrimSpace(line)
+			if line == "" {
+				return nil
+			}
+			if n, aerr := strconv.Atoi(line); aerr == nil && n >= 1 && n <= len(reserves) {
+				return reserves[n-1]
+			}
+			fmt.Fprintln(out, tr("manual_bad_choice"))
+			if err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// benchBattle: a hero and a reserve against a dummy that never dies.
+func benchBattle() (b *Battle, hero, reserve, dummy *Character) {
+	hero = testChar("p1", "Hero", "player", Stats{HPMax: 50, MPMax: 20, Attack: 5, Speed: 5})
+	reserve = testChar("p2", "Reserve", "player", Stats{HPMax: 40, Attack: 5, Speed: 5})
+	dummy = testChar("e1", "Dummy", "enemy", Stats{HPMax: 1000})
+	b = NewBattle([]*Character{hero}, []*Character{dummy})
+	b.SetBench("player", []*Character{reserve})
+	return b, hero, reserve, dummy
+}
+
+func TestSubstituteMidBattle(t *testing.T) {
+	rng = rand.New(rand.NewSource(1))
+	b, hero, reserve, dummy := benchBattle()
+	hero.TakeDamage(20, Pure, func(string) {})
+	hero.UseMP(5)
+	hero.AddEffect(Effect{ID: "poison", Name: "Яд", Duration: 3, DotHP: 2}, func(string) {})
+
+	b.execute(hero, Action{Skill: substitute, Target: reserve}, func(string) {})
+	if b.Teams["player"][0] != reserve || b.Bench["player"][0] != hero {
+		t.Fatalf("not swapped: active %s, bench %s", targetIDs(b.Teams["player"]), targetIDs(b.Bench["player"]))
+	}
+	if hero.Stats.HP != 30 || hero.Stats.MP != 15 || len(hero.Effects) != 0 {
+		t.Fatalf("outgoing hero: HP %d MP %d effects %+v", hero.Stats.HP, hero.Stats.MP, hero.Effects)
+	}
+	if b.CanSubstitute("player") {
+		t.Fatal("second substitution in the same battle")
+	}
+	if b.swap(reserve, hero, func(string) {}) == nil {
+		t.Fatal("swap back allowed")
+	}
+
+	var lines []string
+	b.Turn(func(msg string) { lines = append(lines, msg) })
+	if !strings.Contains(strings.Join(lines, "\n"), tr("sub_arriving", reserve.Name)) || dummy.Stats.HP != dummy.Stats.HPMax {
+		t.Fatalf("incoming reserve must lose its first turn:\n%s", strings.Join(lines, "\n"))
+	}
+	b.Turn(func(string) {})
+	if dummy.Stats.HP == dummy.Stats.HPMax {
+		t.Fatal("reserve should act from its second turn")
+	}
+	if hero.Stats.HP != 30 {
+		t.Fatalf("benched hero took %d damage", 30-hero.Stats.HP)
+	}
+}
+
+func TestDefeatWithBenchedSurvivor(t *testing.T) {
+	b, hero, reserve, _ := benchBattle()
+	var offered []*Character
+	b.Substitute = func(out *Character, reserves []*Character) *Character {
+		offered = reserves
+		return reserves[0]
+	}
+	hero.TakeDamage(1000, Pure, func(string) {})
+	b.benchRescue(func(string) {})
+	if b.Over() || len(offered) != 1 || b.Teams["player"][0] != reserve {
+		t.Fatalf("benched survivor must come in: over %v, active %s", b.Over(), targetIDs(b.Teams["player"]))
+	}
+
+	// The substitution is spent: the next wipe is a defeat
+	reserve.TakeDamage(1000, Pure, func(string) {})
+	b.benchRescue(func(string) {})
+	if !b.Over() || b.Winner() != "enemy" {
+		t.Fatalf("over %v, winner %q", b.Over(), b.Winner())
+	}
+
+	// Declining the substitution is a defeat too
+	b, hero, _, _ = benchBattle()
+	b.Substitute = func(*Character, []*Character) *Character { return nil }
+	hero.TakeDamage(1000, Pure, func(string) {})
+	b.benchRescue(func(string) {})
+	if !b.Over() || b.Winner() != "enemy" || b.CanSubstitute("player") {
+		t.Fatalf("declined: over %v, winner %q", b.Over(), b.Winner())
+	}
+}
+
+func TestCampaignParty(t *testing.T) {
+	var roster []*Character
+	for i := 1; i <= maxRoster+1; i++ {
+		roster = append(roster, testChar(fmt.Sprintf("p%d", i), fmt.Sprintf("P%d", i), "player", Stats{HPMax: 10}))
+	}
+	if _, err := NewCampaign(roster); err == nil {
+		t.Fatal("roster above maxRoster accepted")
+	}
+	camp, err := NewCampaign(roster[:maxRoster])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := targetIDs(camp.Party()); got != "p1,p2,p3,p4" {
+		t.Fatalf("default party %s", got)
+	}
+	for _, ids := range [][]string{{}, {"p1", "p2", "p3", "p4", "p5"}, {"p1", "p1"}, {"p9"}} {
+		if camp.SetActive(ids) == nil {
+			t.Fatalf("SetActive(%v) accepted", ids)
+		}
+	}
+
+	camp.PartyMenu(bufio.NewReader(strings.NewReader("p9\np6, p2\n")), io.Discard)
+	if got := targetIDs(camp.Party()); got != "p6,p2" {
+		t.Fatalf("party after menu %s", got)
+	}
+	if got := targetIDs(camp.Benched()); got != "p1,p3,p4,p5" {
+		t.Fatalf("bench %s", got)
+	}
+
+	roster[0].TakeDamage(1000, Pure, func(string) {})
+	b := camp.Battle([]*Character{testChar("e1", "Goblin", "enemy", Stats{HPMax: 10})})
+	if got := targetIDs(b.Teams["player"]); got != "p6,p2" || len(b.reserves("player")) != 4 {
+		t.Fatalf("battle: active %s, reserves %s", got, targetIDs(b.reserves("player")))
+	}
+	if !roster[0].Alive || roster[0].Stats.HP != roster[0].Stats.HPMax {
+		t.Fatal("roster not rested between battles")
+	}
+}
+
+func TestBenchedEarnReducedXP(t *testing.T) {
+	b, hero, reserve, _ := benchBattle()
+	goblin := testChar("e2", "Goblin", "enemy", Stats{HPMax: 10})
+	goblin.XPReward = 15
+	b.AddTeam("enemy", []*Character{goblin})
+	b.surrenderSpoils(goblin, func(string) {})
+	if hero.XP != 15 || reserve.XP != 15*benchXPPercent/100 {
+		t.Fatalf("XP: active %d, benched %d", hero.XP, reserve.XP)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+// Skill targeting. Every skill resolves its targets through resolveTargets,
+// so the AI and any other controller agree on who a skill hits.
+
+type TargetMode int
+
+const (
+	// TargetDefault derives the mode from the skill: revive, heal and buff
+	// skills target one ally, TargetAll hits all enemies, the rest one enemy.
+	TargetDefault TargetMode = iota
+	SingleEnemy
+	AllEnemies
+	SingleAlly
+	AllAllies
+	Everyone // Every living character except the caster, allies included
+	Splash   // The chosen enemy plus up to splashExtra other living enemies
+)
+
+// splashExtra is how many additional enemies a Splash skill hits.
+const splashExtra = 2
+
+// Mode returns the effective targeting mode of the skill.
+func (s Skill) Mode() TargetMode {
+	if s.TargetMode != TargetDefault {
+		return s.TargetMode
+	}
+	switch {
+	case s.IsRevive() || s.HealHP > 0 || s.IsBuff():
+		return SingleAlly
+	case s.TargetAll:
+		return AllEnemies
+	default:
+		return SingleEnemy
+	}
+}
+
+// TargetsAllies reports whether the skill is aimed at the caster's side.
+func (m TargetMode) TargetsAllies() bool {
+	return m == SingleAlly || m == AllAllies
+}
+
+func aliveOf(list []*Character) []*Character {
+	var out []*Character
+	for _, c := range list {
+		if c.Fighting() {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// resolveTargets turns the chosen character into the full target list for
+// the skill. chosen may be nil or invalid for the mode; then the first fitting
+// character is used (the actor itself for ally skills). Only revive skills
+// may target the dead.
+func resolveTargets(actor *Character, s Skill, chosen *Character, b *Battle) []*Character {
+	mode := s.Mode()
+	hostile := func(c *Character) bool {
+		return c != nil && c.Team != actor.Team && b.IsHostile(actor.Team, c.Team)
+	}
+
+	switch mode {
+	case SingleAlly:
+		if chosen == nil || chosen.Team != actor.Team || (!chosen.Alive && !s.IsRevive()) {
+			chosen = actor
+		}
+		return []*Character{chosen}
+
+	case AllAllies:
+		return aliveOf(b.Teams[actor.Team])
+
+	case Everyone:
+		var out []*Character
+		for _, name := range b.order {
+			for _, c := range aliveOf(b.Teams[name]) {
+				if c != actor {
+					out = append(out, c)
+				}
+			}
+		}
+		return out
+	}
+
+	// Enemy modes: the chosen target's team, or a random hostile team
+	var enemies []*Character
+	if hostile(chosen) {
+		enemies = aliveOf(b.Teams[chosen.Team])
+	} else {
+		enemies = aliveOf(b.pickHostileTeam(actor.Team))
+	}
+	if len(enemies) == 0 {
+		return nil
+	}
+	if chosen == nil || !chosen.Fighting() || !hostile(chosen) {
+		chosen = enemies[0]
+	}
+
+	switch mode {
+	case AllEnemies:
+		return enemies
+	case Splash:
+		var others []*Character
+		for _, c := range enemies {
+			if c != chosen {
+				others = append(others, c)
+			}
+		}
+		rng.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+		if len(others) > splashExtra {
+			others = others[:splashExtra]
+		}
+		return append([]*Character{chosen}, others...)
+	default:
+		return []*Character{chosen}
+	}
+}
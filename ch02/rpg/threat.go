@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Threat (aggro). Every non-player character keeps a threat table over its
+// foes: damage it takes adds the dealt amount as threat for the attacker,
+// and healing by a foe adds threat spread evenly over all living
+// threat-keepers hostile to the healer. A taunt sets a temporary floor
+// under the taunter's threat, high enough to put it on top of the table.
+// At the start of every round threat decays by threatDecay and taunts tick
+// down.
+//
+// AI single-target actions go to the living foe with the highest threat;
+// ties go to the lower ID. With nothing on the table the old choice (first
+// living member of a random hostile team) stands.
+//
+// Attribution: TakeDamageFrom and HealFrom carry the source; effects
+// remember who applied them in Effect.From, so DoTs and regeneration are
+// credited too. Plain TakeDamage and Heal have no source and add no threat.
+
+const (
+	threatDecay      = 0.10 // Fraction lost per round
+	healThreatFactor = 0.5  // Threat per healed HP, before the split
+)
+
+// taunt is a threat floor that lasts for rounds more round starts.
+type taunt struct {
+	floor  float64
+	rounds int
+}
+
+// ThreatTable is one character's threat towards its foes, by foe ID.
+type ThreatTable struct {
+	Threat map[string]float64
+	taunts map[string]taunt
+}
+
+// Of returns the effective threat of foe: the accumulated value or the
+// taunt floor, whichever is higher.
+func (t *ThreatTable) Of(foe string) float64 {
+	if t == nil {
+		return 0
+	}
+	v := t.Threat[foe]
+	if ta, ok := t.taunts[foe]; ok && ta.floor > v {
+		return ta.floor
+	}
+	return v
+}
+
+// top returns the highest effective threat on the table.
+func (t *ThreatTable) top() float64 {
+	var best float64
+	for id := range t.Threat {
+		best = max(best, t.Of(id))
+	}
+	for id := range t.taunts {
+		best = max(best, t.Of(id))
+	}
+	return best
+}
+
+// tracksThreat reports whether c keeps a threat table: everyone outside the
+// player team.
+func (c *Character) tracksThreat() bool {
+	return c.Team != "player"
+}
+
+// threatOf returns c's table, creating it on first use.
+func (b *Battle) threatOf(c *Character) *ThreatTable {
+	if b.Threat == nil {
+		b.Threat = map[string]*ThreatTable{}
+	}
+	t := b.Threat[c.ID]
+	if t == nil {
+		t = &ThreatTable{Threat: map[string]float64{}, taunts: map[string]taunt{}}
+		b.Threat[c.ID] = t
+	}
+	return t
+}
+
+// addDamageThreat credits src with the damage it dealt to c.
+func (b *Battle) addDamageThreat(src, c *Character, dealt int) {
+	if src == nil || dealt <= 0 || !c.tracksThreat() || !b.IsHostile(c.Team, src.Team) {
+		return
+	}
+	b.threatOf(c).Threat[src.ID] += float64(dealt)
+}
+
+// addHealThreat spreads the threat of healed HP over everyone hostile to src
+// that keeps a table.
+func (b *Battle) addHealThreat(src *Character, healed int) {
+	if src == nil || healed <= 0 {
+		return
+	}
+	var keepers []*Character
+	for _, c := range b.hostileFighters(src.Team) {
+		if c.tracksThreat() {
+			keepers = append(keepers, c)
+		}
+	}
+	if len(keepers) == 0 {
+		return
+	}
+	share := float64(healed) * healThreatFactor / float64(len(keepers))
+	for _, c := range keepers {
+		b.threatOf(c).Threat[src.ID] += share
+	}
+}
+
+// tauntBy puts src on top of c's table for rounds round starts.
+func (b *Battle) tauntBy(src, c *Character, rounds int) {
+	if !c.tracksThreat() || !b.IsHostile(c.Team, src.Team) {
+		return
+	}
+	t := b.threatOf(c)
+	t.taunts[src.ID] = taunt{floor: t.top() + 1, rounds: rounds}
+}
+
+// decayThreat runs at round start: threat shrinks, taunts tick and expire.
+func (b *Battle) decayThreat() {
+	for _, t := range b.Threat {
+		for id, v := range t.Threat {
+			t.Threat[id] = v * (1 - threatDecay)
+		}
+		for id, ta := range t.taunts {
+			if ta.rounds--; ta.rounds <= 0 {
+				delete(t.taunts, id)
+			} else {
+				t.taunts[id] = ta
+			}
+		}
+	}
+}
+
+// threatTarget picks the living foe with the highest threat on actor's
+// table, or nil if nobody has any.
+func (b *Battle) threatTarget(actor *Character) *Character {
+	t := b.Threat[actor.ID]
+	if t == nil {
+		return nil
+	}
+	var best *Character
+	var bestThreat float64
+	for _, c := range b.hostileFighters(actor.Team) {
+		v := t.Of(c.ID)
+		if v > bestThreat || (v == bestThreat && best != nil && c.ID < best.ID) {
+			best, bestThreat = c, v
+		}
+	}
+	return best
+}
+
+// logThreat writes one debug line per threat table (verbose mode).
+func (b *Battle) logThreat(logFunc func(string)) {
+	for _, name := range b.order {
+		for _, c := range b.Teams[name] {
+			t := b.Threat[c.ID]
+			if t == nil || !c.Fighting() {
+				continue
+			}
+			ids := make([]string, 0, len(t.Threat)+len(t.taunts))
+			for id := range t.Threat {
+				ids = append(ids, id)
+			}
+			for id := range t.taunts {
+				if _, ok := t.Threat[id]; !ok {
+					ids = append(ids, id)
+				}
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			sort.Slice(ids, func(i, j int) bool {
+				if a, o := t.Of(ids[i]), t.Of(ids[j]); a != o {
+					return a > o
+				}
+				return ids[i] < ids[j]
+			})
+			parts := make([]string, len(ids))
+			for i, id := range ids {
+				parts[i] = fmt.Sprintf("%s %.1f", b.nameOf(id), t.Of(id))
+				if _, ok := t.taunts[id]; ok {
+					parts[i] += "*"
+				}
+			}
+			logFunc(tr("threat_debug", c.Name, strings.Join(parts, ", ")))
+		}
+	}
+}
+
+// byID finds a combatant by ID.
+func (b *Battle) byID(id string) *Character {
+	for _, name := range b.order {
+		for _, c := range b.Teams[name] {
+			if c.ID == id {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Battle) nameOf(id string) string {
+	if c := b.byID(id); c != nil {
+		return c.Name
+	}
+	return id
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Журнал аудита: действия, которые сервер совершает сам, без запроса
+// пользователя (сейчас — очистка по правилам хранения). Одна запись — одна
+// строка JSON {"time": ..., "action": ..., ...}: её легко читать и grep'ать.
+// По умолчанию пишется в журнал сервера, флаг -audit — в отдельный файл
+// (дописывается).
+
+// auditMu защищает auditOut
+var (
+	auditMu  sync.Mutex
+	auditOut io.Writer = log.Writer()
+)
+
+// openAudit направляет журнал аудита в файл path
+func openAudit(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	auditMu.Lock()
+	auditOut = f
+	auditMu.Unlock()
+	return nil
+}
+
+// audit пишет запись action с полями fields
+func audit(action string, fields map[string]any) {
+	rec := map[string]any{"time": time.Now().UTC().Format(time.RFC3339), "action": action}
+	for k, v := range fields {
+		rec[k] = v
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("audit %s: %v", action, err)
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if _, err := auditOut.Write(append(line, '\n')); err != nil {
+		log.Printf("audit %s: %v", action, err)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Массовые операции: POST /bulk с JSON {"action": "delete|move|zip",
+// "paths": [...], "dest": "..."}. Каждый путь проверяется отдельно, ошибка
+// одного не прерывает остальные; в ответе — статус по каждому пути.
+
+// maxBulkEntries — максимум путей в одном запросе
+const maxBulkEntries = 500
+
+// trashDir — корзина для массового удаления (вне uploadDir, чтобы не
+// попадать в листинг)
+var trashDir = "./trash"
+
+// BulkRequest — тело запроса /bulk
+type BulkRequest struct {
+	Action string   `json:"action"`
+	Paths  []string `json:"paths"`
+	Dest   string   `json:"dest,omitempty"` // Папка назначения для move
+}
+
+// BulkEntryResult — результат для одного пути
+type BulkEntryResult struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`          // "ok" или "error"
+	Error  string `json:"error,omitempty"` // На языке запроса (localize)
+
+	err error
+}
+
+// BulkResponse — ответ для delete и move
+type BulkResponse struct {
+	Action  string            `json:"action"`
+	Trash   string            `json:"trash,omitempty"` // Папка корзины этой операции
+	Results []BulkEntryResult `json:"results"`
+}
+
+// resolveEntry — resolvePath для элемента выборки: корень нельзя, путь должен существовать
+func resolveEntry(u *User, raw string) (string, string, error) {
+	clean, full, err := resolvePath(u, raw)
+	if err != nil {
+		return "", "", err
+	}
+	if clean == "" {
+		return "", "", msgErr("bulk.root_selected")
+	}
+	if _, err := os.Lstat(full); err != nil {
+		return "", "", msgErr("bulk.not_found")
+	}
+	return clean, full, nil
+}
+
+func okResult(p string) BulkEntryResult { return BulkEntryResult{Path: p, Status: "ok"} }
+
+func errResult(p string, err error) BulkEntryResult {
+	return BulkEntryResult{Path: p, Status: "error", err: err}
+}
+
+// localize заполняет Error результатов на языке tr
+func localize(tr Translator, results []BulkEntryResult) {
+	for i := range results {
+		if results[i].err != nil {
+			results[i].Error = tr.Err(results[i].err)
+		}
+	}
+}
+
+// bulkDelete переносит выбранное в отдельную папку корзины этой операции,
+// сохраняя относительные пути — так удаление можно откатить вручную.
+func bulkDelete(u *User, paths []string) BulkResponse {
+	batch := time.Now().Format("20060102-150405.000000000")
+	resp := BulkResponse{Action: "delete", Trash: batch}
+	for _, p := range paths {
+		_, full, err := resolveEntry(u, p)
+		if err != nil {
+			resp.Results = append(resp.Results, errResult(p, err))
+			continue
+		}
+		// В корзине — путь от uploadDir: видно, чья это папка
+		key := relToUpload(full)
+		target := filepath.Join(trashDir, batch, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			resp.Results = append(resp.Results, errResult(p, err))
+			continue
+		}
+		if err := os.Rename(full, target); err != nil {
+			log.Printf("bulk delete %s: %v", full, err)
+			// Путь уже мог уйти в корзину вместе с выбранной родительской папкой
+			if _, statErr := os.Lstat(full); os.IsNotExist(statErr) {
+				resp.Results = append(resp.Results, okResult(p))
+				publishEntry(eventDeleted, full)
+				continue
+			}
+			resp.Results = append(resp.Results, errResult(p, msgErr("bulk.delete_failed")))
+			continue
+		}
+		index.RemoveTree(key)
+		publishEntry(eventDeleted, full)
+		resp.Results = append(resp.Results, okResult(p))
+	}
+	return resp
+}
+
+// bulkMove переносит выбранное в папку dest (проверяется один раз);
+// совпадение имён в dest — ошибка для этого пути.
+func bulkMove(u *User, paths []string, dest string) (BulkResponse, error) {
+	resp := BulkResponse{Action: "move"}
+	destClean, destFull, err := resolvePath(u, dest)
+	if err != nil {
+		return resp, err
+	}
+	if st, err := os.Stat(destFull); err != nil || !st.IsDir() {
+		return resp, msgErr("bulk.no_dest")
+	}
+
+	for _, p := range paths {
+		clean, full, err := resolveEntry(u, p)
+		if err != nil {
+			resp.Results = append(resp.Results, errResult(p, err))
+			continue
+		}
+		if destClean == clean || strings.HasPrefix(destClean+"/", clean+"/") {
+			resp.Results = append(resp.Results, errResult(p, msgErr("bulk.into_itself")))
+			continue
+		}
+		target := filepath.Join(destFull, filepath.Base(full))
+		if _, err := os.Lstat(target); err == nil {
+			resp.Results = append(resp.Results, errResult(p, msgErr("bulk.dest_taken", filepath.Base(full))))
+			continue
+		}
+		if err := os.Rename(full, target); err != nil {
+			log.Printf("bulk move %s -> %s: %v", full, target, err)
+			resp.Results = append(resp.Results, errResult(p, msgErr("bulk.move_failed")))
+			continue
+		}
+		index.MoveTree(relToUpload(full), relToUpload(target))
+		events.Publish(relToUpload(filepath.Dir(full)), Event{Type: eventRenamed, Name: filepath.Base(full), To: path.Join(destClean, filepath.Base(full))})
+		publishEntry(eventCreated, target)
+		resp.Results = append(resp.Results, okResult(p))
+	}
+	return resp, nil
+}
+
+// bulkZip отдаёт один архив выбранных файлов и папок (записи — пути
+// относительно корня пользователя). Пути, не прошедшие проверку,
+// перечисляются в заголовке X-Bulk-Skipped до начала потока.
+func bulkZip(w http.ResponseWriter, tr Translator, u *User, paths []string) {
+	var skipped []BulkEntryResult
+	var selected []string
+	for _, p := range paths {
+		_, full, err := resolveEntry(u, p)
+		if err != nil {
+			skipped = append(skipped, errResult(p, err))
+			continue
+		}
+		selected = append(selected, full)
+	}
+	localize(tr, skipped)
+	if len(selected) == 0 {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(BulkResponse{Action: "zip", Results: skipped})
+		return
+	}
+
+	if len(skipped) > 0 {
+		data, _ := json.Marshal(skipped)
+		w.Header().Set("X-Bulk-Skipped", string(data))
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="selection.zip"`)
+
+	zw := zip.NewWriter(w)
+	for _, full := range selected {
+		err := filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type()&fs.ModeSymlink != 0 || d.IsDir() {
+				return nil
+			}
+			return addZipFile(zw, u.Root(), p)
+		})
+		if err != nil {
+			// Заголовки уже отправлены — остаётся только журнал
+			log.Printf("bulk zip %s: %v", full, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("bulk zip close: %v", err)
+	}
+}
+
+func addZipFile(zw *zip.Writer, root, full string) error {
+	rel, err := filepath.Rel(root, full)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(full)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: filepath.ToSlash(rel), Method: zip.Deflate})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// bulkHandler — POST /bulk
+func bulkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "err.method_not_allowed")
+		return
+	}
+
+	var req BulkRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		httpError(w, r, http.StatusBadRequest, "err.bad_json")
+		return
+	}
+	if len(req.Paths) == 0 {
+		httpError(w, r, http.StatusBadRequest, "err.no_paths")
+		return
+	}
+	if len(req.Paths) > maxBulkEntries {
+		httpError(w, r, http.StatusRequestEntityTooLarge, "err.too_many_paths", len(req.Paths), maxBulkEntries)
+		return
+	}
+
+	var resp BulkResponse
+	switch req.Action {
+	case "delete":
+		resp = bulkDelete(userFrom(r), req.Paths)
+	case "move":
+		var err error
+		resp, err = bulkMove(userFrom(r), req.Paths, req.Dest)
+		if err != nil {
+			httpError(w, r, http.StatusBadRequest, "err.bulk_dest", translatorFor(r).Err(err))
+			return
+		}
+	case "zip":
+		bulkZip(w, translatorFor(r), userFrom(r), req.Paths)
+		return
+	default:
+		httpError(w, r, http.StatusBadRequest, "err.unknown_action", req.Action)
+		return
+	}
+	localize(translatorFor(r), resp.Results)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Println("bulk response:", err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withUploadDir подменяет uploadDir и trashDir временными папками
+func withUploadDir(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	prevUpload, prevTrash := uploadDir, trashDir
+	uploadDir = filepath.Join(root, "uploads")
+	trashDir = filepath.Join(root, "trash")
+	t.Cleanup(func() { uploadDir, trashDir = prevUpload, prevTrash })
+	for _, d := range []string{"docs", "dest", "dest/taken"} {
+		os.MkdirAll(filepath.Join(uploadDir, d), os.ModePerm)
+	}
+	for _, f := range []string{"a.txt", "b.txt", "docs/c.txt", "taken"} {
+		os.WriteFile(filepath.Join(uploadDir, f), []byte(f), 0o644)
+	}
+	return uploadDir
+}
+
+func postBulk(t *testing.T, req BulkRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	bulkHandler(rec, httptest.NewRequest(http.MethodPost, "/bulk", bytes.NewReader(body)))
+	return rec
+}
+
+func statuses(t *testing.T, rec *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp BulkResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]string{}
+	for _, r := range resp.Results {
+		out[r.Path] = r.Status
+	}
+	return out
+}
+
+func TestBulkDeleteMixedBatch(t *testing.T) {
+	root := withUploadDir(t)
+	got := statuses(t, postBulk(t, BulkRequest{
+		Action: "delete",
+		Paths:  []string{"a.txt", "missing.txt", "../outside", "", "docs", "b.txt"},
+	}))
+	want := map[string]string{
+		"a.txt": "ok", "missing.txt": "error", "../outside": "error", "": "error", "docs": "ok", "b.txt": "ok",
+	}
+	for p, st := range want {
+		if got[p] != st {
+			t.Errorf("%q: status %q, want %q", p, got[p], st)
+		}
+	}
+	for _, p := range []string{"a.txt", "b.txt", "docs"} {
+		if _, err := os.Stat(filepath.Join(root, p)); !os.IsNotExist(err) {
+			t.Errorf("%s should be gone from uploads", p)
+		}
+	}
+	// Удалённое лежит в корзине с сохранением путей
+	matches, _ := filepath.Glob(filepath.Join(trashDir, "*", "docs", "c.txt"))
+	if len(matches) != 1 {
+		t.Fatalf("docs/c.txt not found in trash: %v", matches)
+	}
+}
+
+func TestBulkMoveReportsCollisions(t *testing.T) {
+	root := withUploadDir(t)
+	got := statuses(t, postBulk(t, BulkRequest{
+		Action: "move",
+		Dest:   "dest",
+		Paths:  []string{"a.txt", "taken", "dest", "nope"},
+	}))
+	if got["a.txt"] != "ok" || got["taken"] != "error" || got["dest"] != "error" || got["nope"] != "error" {
+		t.Fatalf("unexpected statuses %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "dest", "a.txt")); err != nil {
+		t.Fatal("a.txt not moved")
+	}
+	if _, err := os.Stat(filepath.Join(root, "taken")); err != nil {
+		t.Fatal("colliding entry must stay in place")
+	}
+
+	if rec := postBulk(t, BulkRequest{Action: "move", Dest: "../x", Paths: []string{"b.txt"}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid destination: %d", rec.Code)
+	}
+}
+
+func TestBulkZip(t *testing.T) {
+	withUploadDir(t)
+	rec := postBulk(t, BulkRequest{Action: "zip", Paths: []string{"a.txt", "docs", "../etc/passwd"}})
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("status %d, type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("X-Bulk-Skipped") == "" {
+		t.Fatal("rejected path should be reported in X-Bulk-Skipped")
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	if len(names) != 2 || !names["a.txt"] || !names["docs/c.txt"] {
+		t.Fatalf("unexpected archive entries %v", names)
+	}
+}
+
+func TestBulkEntryCap(t *testing.T) {
+	withUploadDir(t)
+	paths := make([]string, maxBulkEntries+1)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("f%d.txt", i)
+	}
+	if rec := postBulk(t, BulkRequest{Action: "delete", Paths: paths}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 over the cap, got %d", rec.Code)
+	}
+	if rec := postBulk(t, BulkRequest{Action: "delete", Paths: paths[:maxBulkEntries]}); rec.Code != http.StatusOK {
+		t.Fatalf("exactly %d entries should be accepted, got %d", maxBulkEntries, rec.Code)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Дедупликация загрузок. Индекс содержимого хранит SHA-256 каждого файла
+// в uploadDir и все пути с этим содержимым. В режиме dedup (флаг -dedup)
+// загрузка, чей хэш уже есть в индексе, становится жёсткой ссылкой на
+// существующий файл вместо второй копии; если ссылка не создаётся (другая
+// ФС, нет поддержки) — обычная копия.
+//
+// Удаление (корзина, /delete) только отвязывает путь: inode живёт, пока на
+// него ссылается хоть один путь, поэтому остальные копии не страдают.
+// Опасна только запись поверх существующего пути — она изменила бы все
+// ссылки сразу, — поэтому загрузка пишется во временный файл и
+// переименовывается поверх, а не обрезает старый файл.
+
+// dedupEnabled — режим жёстких ссылок (флаг -dedup)
+var dedupEnabled = false
+
+// contentIndex — хэш → пути (относительно uploadDir, со слэшами) и обратно
+type contentIndex struct {
+	mu     sync.Mutex
+	paths  map[string]map[string]bool
+	hashes map[string]string
+}
+
+func newContentIndex() *contentIndex {
+	return &contentIndex{paths: map[string]map[string]bool{}, hashes: map[string]string{}}
+}
+
+// index — индекс содержимого uploadDir
+var index = newContentIndex()
+
+// Add связывает путь с хэшем (заменяя прежний хэш пути)
+func (ix *contentIndex) Add(rel, hash string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeLocked(rel)
+	if ix.paths[hash] == nil {
+		ix.paths[hash] = map[string]bool{}
+	}
+	ix.paths[hash][rel] = true
+	ix.hashes[rel] = hash
+}
+
+func (ix *contentIndex) removeLocked(rel string) {
+	hash, ok := ix.hashes[rel]
+	if !ok {
+		return
+	}
+	delete(ix.hashes, rel)
+	delete(ix.paths[hash], rel)
+	if len(ix.paths[hash]) == 0 {
+		delete(ix.paths, hash)
+	}
+}
+
+// RemoveTree забывает путь и всё, что под ним (для удалённой папки)
+func (ix *contentIndex) RemoveTree(rel string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for p := range ix.hashes {
+		if p == rel || strings.HasPrefix(p, rel+"/") {
+			ix.removeLocked(p)
+		}
+	}
+}
+
+// MoveTree переносит путь (и всё под ним) на новое место
+func (ix *contentIndex) MoveTree(from, to string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for p, hash := range ix.hashes {
+		if p != from && !strings.HasPrefix(p, from+"/") {
+			continue
+		}
+		ix.removeLocked(p)
+		np := to + strings.TrimPrefix(p, from)
+		if ix.paths[hash] == nil {
+			ix.paths[hash] = map[string]bool{}
+		}
+		ix.paths[hash][np] = true
+		ix.hashes[np] = hash
+	}
+}
+
+// Links — сколько путей сейчас хранят это содержимое
+func (ix *contentIndex) Links(hash string) int {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return len(ix.paths[hash])
+}
+
+// source ищет существующий файл с этим хэшем (кроме except); устаревшие
+// записи (файл пропал мимо сервера) по дороге выкидываются.
+func (ix *contentIndex) source(hash, except string) (string, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	candidates := make([]string, 0, len(ix.paths[hash]))
+	for p := range ix.paths[hash] {
+		candidates = append(candidates, p)
+	}
+	sort.Strings(candidates)
+	for _, p := range candidates {
+		if p == except {
+			continue
+		}
+		st, err := os.Lstat(filepath.Join(uploadDir, filepath.FromSlash(p)))
+		if err != nil || !st.Mode().IsRegular() {
+			ix.removeLocked(p)
+			continue
+		}
+		return p, true
+	}
+	return "", false
+}
+
+// Rebuild заново хэширует все файлы в root
+func (ix *contentIndex) Rebuild(root string) error {
+	fresh := newContentIndex()
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		fresh.Add(filepath.ToSlash(rel), hex.EncodeToString(h.Sum(nil)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.paths, ix.hashes = fresh.paths, fresh.hashes
+	return nil
+}
+
+// storeUpload сохраняет src в dstFull: сначала во временный файл рядом
+// (заодно считая хэш), затем — ссылка на уже хранимую копию или сам
+// временный файл переименовываются поверх dstFull. linked — получилась
+// жёсткая ссылка.
+func storeUpload(src io.Reader, dstFull string) (linked bool, err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dstFull), ".upload-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name()) // после переименования — no-op
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), src); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	rel := relToUpload(dstFull)
+
+	if dedupEnabled {
+		if srcRel, ok := index.source(hash, rel); ok {
+			linked = linkOver(filepath.Join(uploadDir, filepath.FromSlash(srcRel)), dstFull)
+		}
+	}
+	if !linked {
+		if err := os.Rename(tmp.Name(), dstFull); err != nil {
+			return false, err
+		}
+	}
+	index.Add(rel, hash)
+	return linked, nil
+}
+
+// linkOver создаёт жёсткую ссылку на existing и переименовывает её поверх
+// dst. Любая ошибка (EXDEV, файловая система без ссылок) — false, вызывающий
+// сохраняет обычную копию.
+func linkOver(existing, dst string) bool {
+	tmp := dst + ".link-tmp"
+	os.Remove(tmp)
+	if err := os.Link(existing, tmp); err != nil {
+		log.Printf("dedup: ссылка на %s не создана, сохраняем копию: %v", existing, err)
+		return false
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		log.Printf("dedup: %v", err)
+		return false
+	}
+	return true
+}
+
+// DedupStats — сводка для /stats
+type DedupStats struct {
+	Enabled      bool
+	Files        int   // Путей в индексе
+	Unique       int   // Разного содержимого
+	StoredBytes  int64 // Реально занято на диске (по inode)
+	LogicalBytes int64 // Сколько заняли бы отдельные копии
+	SavedBytes   int64
+	Shared       []SharedContent // Содержимое, которое хранится по нескольким путям
+}
+
+// SharedContent — одно содержимое с несколькими путями
+type SharedContent struct {
+	Hash  string
+	Size  int64
+	Paths []string
+	Links int // Сколько путей делят один inode
+}
+
+// Stats считает экономию по фактическим inode: пути с одним хэшем,
+// указывающие на один файл (os.SameFile), занимают место один раз.
+func (ix *contentIndex) Stats() DedupStats {
+	ix.mu.Lock()
+	groups := make(map[string][]string, len(ix.paths))
+	for hash, set := range ix.paths {
+		for p := range set {
+			groups[hash] = append(groups[hash], p)
+		}
+	}
+	ix.mu.Unlock()
+
+	st := DedupStats{Enabled: dedupEnabled}
+	for hash, paths := range groups {
+		sort.Strings(paths)
+		var inodes []os.FileInfo
+		var links []int // Путей на каждый inode
+		var size int64
+		for _, p := range paths {
+			info, err := os.Stat(filepath.Join(uploadDir, filepath.FromSlash(p)))
+			if err != nil {
+				continue
+			}
+			st.Files++
+			size = info.Size()
+			st.LogicalBytes += size
+			i := 0
+			for i < len(inodes) && !os.SameFile(inodes[i], info) {
+				i++
+			}
+			if i == len(inodes) {
+				inodes = append(inodes, info)
+				links = append(links, 0)
+				st.StoredBytes += size
+			}
+			links[i]++
+		}
+		if len(inodes) == 0 {
+			continue
+		}
+		st.Unique++
+		if len(paths) > 1 {
+			st.Shared = append(st.Shared, SharedContent{Hash: hash, Size: size, Paths: paths, Links: slices.Max(links)})
+		}
+	}
+	st.SavedBytes = st.LogicalBytes - st.StoredBytes
+	sort.Slice(st.Shared, func(i, j int) bool { return st.Shared[i].Paths[0] < st.Shared[j].Paths[0] })
+	return st
+}
+
+var statsTmpl = template.Must(template.New("stats").Funcs(template.FuncMap{"join": strings.Join}).Parse(`<!DOCTYPE html>
+<html lang="{{.Tr.Lang}}">
+<head><meta charset="UTF-8" /><title>{{.Tr.T "stats.title"}}</title></head>
+<body style="font-family: Arial, sans-serif; margin: 40px;">
+<h1>{{.Tr.T "stats.heading"}}</h1>
+<p>{{if .Enabled}}{{.Tr.T "stats.dedup_on"}}{{else}}{{.Tr.T "stats.dedup_off"}}{{end}}</p>
+<p>{{.Tr.T "stats.files" .Files .Unique}}</p>
+<p>{{.Tr.T "stats.stored" (.Tr.Size .StoredBytes) (.Tr.Size .LogicalBytes)}}</p>
+<p>{{.Tr.T "stats.saved"}} <b>{{.Tr.Size .SavedBytes}}</b></p>
+{{if .Shared}}<h2>{{.Tr.T "stats.shared"}}</h2><ul>
+{{range .Shared}}<li>{{$.Tr.T "stats.links" ($.Tr.Size .Size) .Links}} — {{join .Paths ", "}}</li>{{end}}
+</ul>{{end}}
+{{if .RetentionOn}}<h2>{{.Tr.T "stats.retention"}}</h2>
+{{with .Retention}}<p>{{$.Tr.T "stats.retention_last" ($.Tr.Date .Time)}}</p><ul>
+{{range .Dirs}}<li>{{.Dir}}: {{if eq .Skipped "busy"}}{{$.Tr.T "stats.retention_busy"}}{{else if eq .Skipped "missing"}}{{$.Tr.T "stats.retention_missing"}}{{else if .Skipped}}{{$.Tr.T "stats.retention_error"}}{{else}}{{$.Tr.T "stats.retention_moved" (len .Removed) ($.Tr.Size .Freed)}}{{if .Failed}}, {{$.Tr.T "stats.retention_failed" .Failed}}{{end}}{{end}}</li>{{end}}
+</ul>{{else}}<p>{{.Tr.T "stats.retention_never"}}</p>{{end}}{{end}}
+<p><a href="/">{{.Tr.T "page.back"}}</a></p>
+</body>
+</html>
+`))
+
+// statsPage — статистика и язык страницы
+type statsPage struct {
+	DedupStats
+	RetentionOn bool             // Заданы правила хранения
+	Retention   *RetentionReport // Последняя очистка; nil — ещё не было
+	Tr          Translator
+}
+
+// statsHandler — GET /stats. Пути в статистике — всего uploadDir, поэтому
+// при авторизации она только для администратора.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if u := userFrom(r); u != nil && !u.Admin {
+		httpError(w, r, http.StatusForbidden, "err.admin_only")
+		return
+	}
+	page := statsPage{DedupStats: index.Stats(), RetentionOn: len(retentionRules) > 0, Tr: translatorFor(r)}
+	lastRetention.Lock()
+	page.Retention = lastRetention.report
+	lastRetention.Unlock()
+	if err := statsTmpl.Execute(w, page); err != nil {
+		log.Println("stats template:", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// uploadFile отправляет content в папку dir под именем name через uploadHandler
+func uploadFile(t *testing.T, dir, name string, content []byte) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("dir", dir)
+	fw, _ := mw.CreateFormFile("file", name)
+	fw.Write(content)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	uploadHandler(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("upload %s/%s: %d %s", dir, name, rec.Code, rec.Body.String())
+	}
+}
+
+// withDedup включает dedup с чистым индексом на время теста
+func withDedup(t *testing.T, on bool) {
+	t.Helper()
+	prevOn, prevIndex := dedupEnabled, index
+	dedupEnabled, index = on, newContentIndex()
+	t.Cleanup(func() { dedupEnabled, index = prevOn, prevIndex })
+}
+
+func TestDedupHardLinksIdenticalUploads(t *testing.T) {
+	root := withUploadDir(t)
+	withDedup(t, true)
+	video := bytes.Repeat([]byte("frame"), 50000)
+
+	uploadFile(t, "", "video.mp4", video)
+	uploadFile(t, "docs", "copy.mp4", video)
+	uploadFile(t, "", "other.bin", []byte("другое содержимое"))
+
+	a, _ := os.Stat(filepath.Join(root, "video.mp4"))
+	b, _ := os.Stat(filepath.Join(root, "docs", "copy.mp4"))
+	if a == nil || b == nil || !os.SameFile(a, b) {
+		t.Fatal("identical uploads must share one inode")
+	}
+	other, _ := os.Stat(filepath.Join(root, "other.bin"))
+	if os.SameFile(a, other) {
+		t.Fatal("different content must not be linked")
+	}
+
+	st := index.Stats()
+	if st.SavedBytes != int64(len(video)) || len(st.Shared) != 1 || st.Shared[0].Links != 2 {
+		t.Fatalf("stats: %+v", st)
+	}
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if !strings.Contains(rec.Body.String(), Translator{Lang: defaultLang}.Size(int64(len(video)))) {
+		t.Fatalf("stats page should report the saved bytes:\n%s", rec.Body.String())
+	}
+
+	// Удаляем один путь — второй продолжает отдаваться целиком
+	rec = httptest.NewRecorder()
+	deleteHandler(rec, httptest.NewRequest(http.MethodPost, "/delete/video.mp4", nil))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("delete: %d", rec.Code)
+	}
+	files := http.StripPrefix("/files/", http.FileServer(http.Dir(uploadDir)))
+	rec = httptest.NewRecorder()
+	files.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/docs/copy.mp4", nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), video) {
+		t.Fatalf("remaining copy: status %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if st := index.Stats(); st.SavedBytes != 0 || len(st.Shared) != 0 {
+		t.Fatalf("stats after delete: %+v", st)
+	}
+}
+
+func TestDedupOverwriteDoesNotTouchOtherLinks(t *testing.T) {
+	root := withUploadDir(t)
+	withDedup(t, true)
+	uploadFile(t, "", "one.txt", []byte("shared"))
+	uploadFile(t, "", "two.txt", []byte("shared"))
+
+	// Новое содержимое по одному пути не должно просочиться во второй
+	uploadFile(t, "", "one.txt", []byte("changed"))
+	data, _ := os.ReadFile(filepath.Join(root, "two.txt"))
+	if string(data) != "shared" {
+		t.Fatalf("two.txt = %q", data)
+	}
+}
+
+func TestDedupTrashAndMoveKeepIndex(t *testing.T) {
+	root := withUploadDir(t)
+	withDedup(t, true)
+	uploadFile(t, "", "x.bin", []byte("payload"))
+	uploadFile(t, "docs", "y.bin", []byte("payload"))
+	hash := index.hashes["x.bin"]
+
+	statuses(t, postBulk(t, BulkRequest{Action: "move", Paths: []string{"docs"}, Dest: "dest"}))
+	if index.hashes["dest/docs/y.bin"] != hash || index.Links(hash) != 2 {
+		t.Fatalf("index after move: %v", index.hashes)
+	}
+	statuses(t, postBulk(t, BulkRequest{Action: "delete", Paths: []string{"x.bin"}}))
+	if index.Links(hash) != 1 {
+		t.Fatalf("links after trash: %d", index.Links(hash))
+	}
+
+	// Новая загрузка ссылается на оставшийся путь, а не на корзину
+	uploadFile(t, "", "z.bin", []byte("payload"))
+	y, _ := os.Stat(filepath.Join(root, "dest", "docs", "y.bin"))
+	z, _ := os.Stat(filepath.Join(root, "z.bin"))
+	if !os.SameFile(y, z) {
+		t.Fatal("z.bin should link to the remaining copy")
+	}
+	f, _ := os.Open(filepath.Join(root, "z.bin"))
+	defer f.Close()
+	if data, _ := io.ReadAll(f); string(data) != "payload" {
+		t.Fatalf("z.bin = %q", data)
+	}
+}
+
+func TestUploadWithoutDedupCopies(t *testing.T) {
+	root := withUploadDir(t)
+	withDedup(t, false)
+	uploadFile(t, "", "a1", []byte("same"))
+	uploadFile(t, "", "a2", []byte("same"))
+	a, _ := os.Stat(filepath.Join(root, "a1"))
+	b, _ := os.Stat(filepath.Join(root, "a2"))
+	if os.SameFile(a, b) {
+		t.Fatal("dedup is off: uploads must be separate files")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Уведомления об изменениях: GET /events?path=<папка> — поток Server-Sent
+// Events. Обработчики, меняющие папку (загрузка, mkdir, удаление, bulk,
+// распаковка), публикуют событие в шину; шина раздаёт его подписчикам этой
+// папки. Никакого fsnotify: изменения в обход сервера не видны.
+//
+// Ключ подписки — путь относительно uploadDir, поэтому пользователь и
+// администратор, открывший его папку, слышат одни и те же события. Папка
+// без подписчиков стоит один поиск в map. Медленному подписчику события
+// не копятся: буфер полон — событие отбрасывается (страница всё равно
+// перечитывает листинг целиком).
+
+// Типы событий
+const (
+	eventCreated  = "created"
+	eventDeleted  = "deleted"
+	eventRenamed  = "renamed"
+	eventModified = "modified"
+)
+
+// eventsHeartbeat — период комментариев-пингов, чтобы прокси не закрывали
+// молчащее соединение (переменная — тесты укорачивают)
+var eventsHeartbeat = 15 * time.Second
+
+// subscriberBuffer — сколько событий ждёт медленного подписчика
+const subscriberBuffer = 16
+
+// Event — одно изменение в папке
+type Event struct {
+	Type string `json:"type"`
+	Name string `json:"name"`         // Имя элемента в папке
+	To   string `json:"to,omitempty"` // renamed: новый путь относительно корня пользователя
+}
+
+// eventBus — подписчики по папкам
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[string]map[chan Event]struct{} // Папка (относительно uploadDir) → подписчики
+}
+
+// events — шина сервера
+var events = newEventBus()
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: map[string]map[chan Event]struct{}{}}
+}
+
+// Subscribe подписывает на папку dir (относительно uploadDir). Вызвать
+// cancel обязательно: после него канал больше не получает событий.
+func (b *eventBus) Subscribe(dir string) (ch chan Event, cancel func()) {
+	ch = make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	if b.subs[dir] == nil {
+		b.subs[dir] = map[chan Event]struct{}{}
+	}
+	b.subs[dir][ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[dir], ch)
+		if len(b.subs[dir]) == 0 {
+			delete(b.subs, dir)
+		}
+	}
+}
+
+// Publish раздаёт событие подписчикам папки dir, не блокируясь
+func (b *eventBus) Publish(dir string, ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[dir] {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribers — число подписчиков папки (для тестов и журнала)
+func (b *eventBus) Subscribers(dir string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs[dir])
+}
+
+// publishEntry — событие typ для элемента full (путь на диске) в его папке
+func publishEntry(typ, full string) {
+	events.Publish(relToUpload(filepath.Dir(full)), Event{Type: typ, Name: filepath.Base(full)})
+}
+
+// eventsHandler — GET /events?path=<папка>
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, "err.method_not_allowed")
+		return
+	}
+	_, fullPath, err := resolvePath(userFrom(r), r.URL.Query().Get("path"))
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, "err.forbidden_path")
+		return
+	}
+	if st, err := os.Stat(fullPath); err != nil || !st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, r, http.StatusInternalServerError, "err.streaming")
+		return
+	}
+
+	ch, cancel := events.Subscribe(relToUpload(fullPath))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: не буферизовать поток
+	fmt.Fprint(w, ": подписка оформлена\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case ev := <-ch:
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		flusher.Flush()
+	}
+}
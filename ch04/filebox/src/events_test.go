@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseStream — подключённый клиент GET /events
+type sseStream struct {
+	resp  *http.Response
+	lines chan string
+}
+
+// watchDir подписывается на папку dir через настоящий HTTP и ждёт
+// приветственного комментария: после него подписка уже в шине
+func watchDir(t *testing.T, srv *httptest.Server, dir string) *sseStream {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/events?path=" + url.QueryEscape(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("events: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	s := &sseStream{resp: resp, lines: make(chan string, 64)}
+	go func() {
+		defer close(s.lines)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			s.lines <- sc.Text()
+		}
+	}()
+	s.next(t, func(line string) bool { return strings.HasPrefix(line, ":") })
+	return s
+}
+
+// next читает строки, пока match не вернёт true
+func (s *sseStream) next(t *testing.T, match func(string) bool) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			if match(line) {
+				return line
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for SSE line")
+		}
+	}
+}
+
+// event читает следующее событие (строки event: и data:)
+func (s *sseStream) event(t *testing.T) (string, Event) {
+	t.Helper()
+	typ := strings.TrimPrefix(s.next(t, func(l string) bool { return strings.HasPrefix(l, "event: ") }), "event: ")
+	data := strings.TrimPrefix(s.next(t, func(l string) bool { return strings.HasPrefix(l, "data: ") }), "data: ")
+	var ev Event
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		t.Fatalf("data %q: %v", data, err)
+	}
+	return typ, ev
+}
+
+func eventsServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(newMux())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func postForm(t *testing.T, srv *httptest.Server, target string, form url.Values) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(srv.URL+target, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("%s: %d", target, resp.StatusCode)
+	}
+}
+
+func TestEventsDeliverMkdirAndDelete(t *testing.T) {
+	withUploadDir(t)
+	withDedup(t, false)
+	srv := eventsServer(t)
+	docs := watchDir(t, srv, "docs")
+
+	postForm(t, srv, "/mkdir", url.Values{"dir": {"docs"}, "name": {"new"}})
+	if typ, ev := docs.event(t); typ != eventCreated || ev.Type != eventCreated || ev.Name != "new" {
+		t.Fatalf("mkdir: %s %+v", typ, ev)
+	}
+	postForm(t, srv, "/delete/docs/c.txt", nil)
+	if _, ev := docs.event(t); ev.Type != eventDeleted || ev.Name != "c.txt" {
+		t.Fatalf("delete: %+v", ev)
+	}
+}
+
+func TestEventsUploadAndMove(t *testing.T) {
+	withUploadDir(t)
+	withDedup(t, false)
+	srv := eventsServer(t)
+	root := watchDir(t, srv, "")
+	docs := watchDir(t, srv, "docs")
+
+	// Замена существующего файла — modified, новое дерево — created папки
+	uploadFile(t, "", "a.txt", []byte("new a"))
+	if _, ev := root.event(t); ev.Type != eventModified || ev.Name != "a.txt" {
+		t.Fatalf("overwrite: %+v", ev)
+	}
+	if rec := uploadTree(t, "", nil, []treeFile{{"tree/x.txt", "x"}, {"tree/y.txt", "y"}}); rec.Code != http.StatusOK {
+		t.Fatalf("tree upload: %d", rec.Code)
+	}
+	if _, ev := root.event(t); ev.Type != eventCreated || ev.Name != "tree" {
+		t.Fatalf("tree: %+v", ev)
+	}
+
+	statuses(t, postBulk(t, BulkRequest{Action: "move", Paths: []string{"docs/c.txt"}, Dest: "dest"}))
+	if _, ev := docs.event(t); ev.Type != eventRenamed || ev.Name != "c.txt" || ev.To != "dest/c.txt" {
+		t.Fatalf("move: %+v", ev)
+	}
+}
+
+func TestEventsUnsubscribeOnDisconnect(t *testing.T) {
+	withUploadDir(t)
+	srv := eventsServer(t)
+	key := "docs"
+	docs := watchDir(t, srv, "docs")
+	if n := events.Subscribers(key); n != 1 {
+		t.Fatalf("%d subscribers", n)
+	}
+	docs.resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for events.Subscribers(key) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber not removed after disconnect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	events.mu.Lock()
+	_, kept := events.subs[key]
+	events.mu.Unlock()
+	if kept {
+		t.Fatal("empty subscriber list left in the bus")
+	}
+}
+
+func TestEventsHeartbeat(t *testing.T) {
+	withUploadDir(t)
+	prev := eventsHeartbeat
+	eventsHeartbeat = 10 * time.Millisecond
+	t.Cleanup(func() { eventsHeartbeat = prev })
+	docs := watchDir(t, eventsServer(t), "docs")
+	docs.next(t, func(line string) bool { return line == ": ping" })
+}
+
+func TestEventsRejectsBadPath(t *testing.T) {
+	withUploadDir(t)
+	srv := eventsServer(t)
+	for target, want := range map[string]int{
+		"/events?path=../outside": http.StatusForbidden,
+		"/events?path=missing":    http.StatusNotFound,
+		"/events?path=a.txt":      http.StatusNotFound,
+	} {
+		resp, err := http.Get(srv.URL + target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: %d, want %d", target, resp.StatusCode, want)
+		}
+	}
+}
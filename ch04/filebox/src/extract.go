@@ -0,0 +1,288 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Ограничения распаковки — защита от zip-бомб.
+const (
+	maxExtractBytes = 1 << 30 // Суммарный распакованный размер (1 ГБ)
+	maxExtractFiles = 10000   // Максимум записей в архиве
+)
+
+var (
+	errTooManyFiles = msgErr("extract.too_many_files")
+	errTooLarge     = msgErr("extract.too_large")
+)
+
+// ExtractLimits — лимиты для одной распаковки
+type ExtractLimits struct {
+	MaxBytes int64
+	MaxFiles int
+}
+
+var defaultExtractLimits = ExtractLimits{MaxBytes: maxExtractBytes, MaxFiles: maxExtractFiles}
+
+// SkippedEntry — запись архива, которая не была распакована, и причина
+type SkippedEntry struct {
+	Name   string
+	Reason string // На языке запроса (заполняет runExtract)
+
+	cause error
+}
+
+// ExtractResult — итог распаковки для страницы-отчёта
+type ExtractResult struct {
+	Archive   string         // Путь архива относительно корня пользователя
+	Dest      string         // Папка, куда распакованы файлы (относительно корня пользователя)
+	Extracted []string       // Распакованные файлы
+	Skipped   []SkippedEntry // Пропущенные записи
+	Error     string         // Причина прерывания, если была (на языке запроса)
+}
+
+// ExtractPageData — данные для шаблона extract.html
+type ExtractPageData struct {
+	Tr         Translator
+	ReturnPath string
+	Results    []ExtractResult
+}
+
+// isZip — проверка по расширению
+func isZip(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".zip")
+}
+
+// zipEntryPath проверяет имя записи архива (zip-slip): запрещены абсолютные
+// пути, буквы дисков и компоненты "..". Возвращает очищенный путь со слэшами.
+func zipEntryPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", msgErr("path.absolute")
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", msgErr("path.dotdot")
+		}
+	}
+	clean := path.Clean(name)
+	if clean == "." {
+		return "", msgErr("path.empty")
+	}
+	return clean, nil
+}
+
+// extractZip распаковывает archive в соседнюю папку с именем архива (без .zip).
+// Распаковка прерывается при отмене ctx или превышении лимитов; в этом случае
+// частично созданная папка удаляется.
+func extractZip(ctx context.Context, archive string, lim ExtractLimits) (res ExtractResult, err error) {
+	base := strings.TrimSuffix(filepath.Base(archive), filepath.Ext(archive))
+	dest := filepath.Join(filepath.Dir(archive), base)
+	res.Archive = archive
+	res.Dest = dest
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return res, msgErr("extract.open_failed", err)
+	}
+	defer zr.Close()
+
+	if len(zr.File) > lim.MaxFiles {
+		return res, errTooManyFiles
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return res, msgErr("extract.dest_exists", base)
+	}
+	if err := os.Mkdir(dest, os.ModePerm); err != nil {
+		return res, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dest)
+			res.Extracted = nil
+		}
+	}()
+
+	var written int64
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		rel, perr := zipEntryPath(f.Name)
+		if perr != nil {
+			res.Skipped = append(res.Skipped, SkippedEntry{Name: f.Name, cause: perr})
+			continue
+		}
+		mode := f.Mode()
+		if mode&os.ModeSymlink != 0 {
+			// Символические ссылки не распаковываются: они могут указывать за пределы uploadDir
+			res.Skipped = append(res.Skipped, SkippedEntry{Name: f.Name, cause: msgErr("extract.symlink")})
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(rel))
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return res, err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			res.Skipped = append(res.Skipped, SkippedEntry{Name: f.Name, cause: msgErr("extract.not_regular")})
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return res, err
+		}
+
+		n, err := extractFile(ctx, f, target, lim.MaxBytes-written)
+		written += n
+		if err != nil {
+			return res, err
+		}
+		res.Extracted = append(res.Extracted, rel)
+	}
+	return res, nil
+}
+
+// extractFile копирует одну запись, не доверяя заявленному в заголовке размеру:
+// считаются реально распакованные байты.
+func extractFile(ctx context.Context, f *zip.File, target string, budget int64) (int64, error) {
+	src, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	var total int64
+	buf := make([]byte, 32<<10)
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			total += int64(n)
+			if total > budget {
+				return total, errTooLarge
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return total, err
+			}
+		}
+		if rerr == io.EOF {
+			return total, nil
+		}
+		if rerr != nil {
+			return total, rerr
+		}
+	}
+}
+
+// relToUpload — путь относительно uploadDir со слэшами (ключи индекса dedup)
+func relToUpload(p string) string {
+	rel, err := filepath.Rel(uploadDir, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+// runExtract распаковывает архив и готовит результат для отчёта на языке tr
+func runExtract(ctx context.Context, tr Translator, u *User, archive string) ExtractResult {
+	defer uploadLocks.Acquire(uploadKey(filepath.Dir(archive)))()
+	lim, quota, err := quotaExtractLimits(filepath.Dir(archive))
+	if err != nil {
+		log.Printf("Ошибка подсчёта квоты для %s: %v", archive, err)
+		return ExtractResult{Archive: relToRoot(u, archive), Error: tr.T("err.stat_failed")}
+	}
+	res, err := extractZip(ctx, archive, lim)
+	if quota && errors.Is(err, errTooLarge) {
+		err = errQuota
+	}
+	if err != nil {
+		log.Printf("Ошибка распаковки %s: %v", archive, err)
+		res.Error = tr.Err(err)
+	} else {
+		publishEntry(eventCreated, res.Dest)
+	}
+	for i := range res.Skipped {
+		res.Skipped[i].Reason = tr.Err(res.Skipped[i].cause)
+	}
+	res.Archive = relToRoot(u, res.Archive)
+	res.Dest = relToRoot(u, res.Dest)
+	return res
+}
+
+// quotaExtractLimits — лимиты распаковки в dir: при -quota бюджет байт не
+// больше остатка квоты владельца dir. quota — бюджет урезан квотой, и тогда
+// его превышение — errQuota, а не errTooLarge.
+func quotaExtractLimits(dir string) (lim ExtractLimits, quota bool, err error) {
+	lim = defaultExtractLimits
+	if uploadQuota <= 0 {
+		return lim, false, nil
+	}
+	used, err := diskUsage(quotaRoot(dir))
+	if err != nil {
+		return lim, false, err
+	}
+	if remaining := max(uploadQuota-used, 0); remaining < lim.MaxBytes {
+		lim.MaxBytes = remaining
+		quota = true
+	}
+	return lim, quota, nil
+}
+
+// renderExtractSummary выводит страницу с итогами распаковки
+func renderExtractSummary(w http.ResponseWriter, tr Translator, returnPath string, results []ExtractResult) {
+	data := ExtractPageData{Tr: tr, ReturnPath: returnPath, Results: results}
+	if err := tmpl.ExecuteTemplate(w, "extract.html", data); err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, tr.T("err.template", err), http.StatusInternalServerError)
+	}
+}
+
+// extractHandler — POST /extract/<path>: распаковка уже загруженного архива
+func extractHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "err.method_not_allowed")
+		return
+	}
+
+	u := userFrom(r)
+	cleanPath, fullPath, err := resolvePath(u, strings.TrimPrefix(r.URL.Path, "/extract/"))
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, "err.forbidden_path")
+		return
+	}
+	if cleanPath == "" || !isZip(cleanPath) {
+		httpError(w, r, http.StatusBadRequest, "err.zip_only")
+		return
+	}
+	if stat, err := os.Stat(fullPath); err != nil || stat.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	parent := path.Dir(cleanPath)
+	if parent == "." {
+		parent = ""
+	}
+	tr := translatorFor(r)
+	renderExtractSummary(w, tr, parent, []ExtractResult{runExtract(r.Context(), tr, u, fullPath)})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type zipEntry struct {
+	name string
+	body []byte
+	mode os.FileMode
+}
+
+// writeZip собирает архив с произвольными (в том числе вредными) именами записей
+func writeZip(t *testing.T, dir, name string, entries []zipEntry) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.mode != 0 {
+			h.SetMode(e.mode)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e.body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExtractZipSlipAndSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeZip(t, dir, "bundle.zip", []zipEntry{
+		{name: "docs/readme.txt", body: []byte("hello")},
+		{name: "../evil.txt", body: []byte("pwned")},
+		{name: "docs/../../evil2.txt", body: []byte("pwned")},
+		{name: "/etc/passwd", body: []byte("root")},
+		{name: `C:\Windows\evil.dll`, body: []byte("MZ")},
+		{name: "link", body: []byte("/etc/passwd"), mode: os.ModeSymlink | 0o777},
+	})
+
+	res, err := extractZip(context.Background(), archive, defaultExtractLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Extracted) != 1 || res.Extracted[0] != "docs/readme.txt" {
+		t.Fatalf("extracted %v", res.Extracted)
+	}
+	if len(res.Skipped) != 5 {
+		t.Fatalf("expected 5 skipped entries, got %+v", res.Skipped)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "bundle", "docs", "readme.txt"))
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("readme not extracted: %q %v", got, err)
+	}
+	for _, p := range []string{filepath.Join(dir, "evil.txt"), filepath.Join(filepath.Dir(dir), "evil2.txt"), filepath.Join(dir, "bundle", "link")} {
+		if _, err := os.Lstat(p); err == nil {
+			t.Fatalf("%s must not exist", p)
+		}
+	}
+}
+
+func TestExtractZipBomb(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeZip(t, dir, "bomb.zip", []zipEntry{
+		{name: "zeros.bin", body: bytes.Repeat([]byte{0}, 1<<20)},
+	})
+	_, err := extractZip(context.Background(), archive, ExtractLimits{MaxBytes: 64 << 10, MaxFiles: 10})
+	if !errors.Is(err, errTooLarge) {
+		t.Fatalf("expected errTooLarge, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bomb")); err == nil {
+		t.Fatal("partial extraction must be removed")
+	}
+
+	var many []zipEntry
+	for i := 0; i < 20; i++ {
+		many = append(many, zipEntry{name: "f/" + string(rune('a'+i)), body: []byte("x")})
+	}
+	archive = writeZip(t, dir, "many.zip", many)
+	if _, err := extractZip(context.Background(), archive, ExtractLimits{MaxBytes: 1 << 20, MaxFiles: 10}); !errors.Is(err, errTooManyFiles) {
+		t.Fatalf("expected errTooManyFiles, got %v", err)
+	}
+}
+
+func TestExtractQuota(t *testing.T) {
+	root := withUploadDir(t)
+	archive := writeZip(t, root, "data.zip", []zipEntry{
+		{name: "small.txt", body: []byte("1234")},
+		{name: "big.bin", body: bytes.Repeat([]byte{0}, 1<<10)},
+	})
+	used, _ := diskUsage(root)
+	prev := uploadQuota
+	uploadQuota = used + 10
+	t.Cleanup(func() { uploadQuota = prev })
+
+	tr := Translator{Lang: "en"}
+	res := runExtract(context.Background(), tr, nil, archive)
+	if res.Error != tr.Err(errQuota) {
+		t.Fatalf("expected quota error, got %q", res.Error)
+	}
+	if _, err := os.Stat(filepath.Join(root, "data")); err == nil {
+		t.Fatal("partial extraction must be removed")
+	}
+
+	uploadQuota = used + 2<<10
+	if res := runExtract(context.Background(), tr, nil, archive); res.Error != "" {
+		t.Fatalf("extraction within quota: %q", res.Error)
+	}
+}
+
+func TestExtractZipCancelled(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeZip(t, dir, "data.zip", []zipEntry{{name: "a.txt", body: []byte("a")}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := extractZip(ctx, archive, defaultExtractLimits); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data")); err == nil {
+		t.Fatal("cancelled extraction must not leave a folder")
+	}
+}
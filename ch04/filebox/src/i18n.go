@@ -0,0 +1,484 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Локализация интерфейса. Все видимые пользователю строки — страницы,
+// сообщения обработчиков, причины в отчётах о загрузке, распаковке и
+// массовых операциях — берутся из каталога bundles по ключу; ru и en
+// содержат одни и те же ключи (это проверяет i18n_test.go).
+//
+// Язык запроса: cookie langCookie (её ставит переключатель GET /lang),
+// иначе лучший поддерживаемый из Accept-Language, иначе defaultLang.
+// Translator передаётся в шаблоны полем Tr: {{.Tr.T "ключ" аргументы...}},
+// {{.Tr.Size .Size}}, {{.Tr.Date .ModTime}}.
+//
+// Ошибки из глубины (проверка путей, распаковка, массовые операции) —
+// msgErr с ключом и аргументами: Error() даёт текст на defaultLang для
+// журнала, а Translator.Err переводит её на язык запроса.
+
+// langCookie — выбранный переключателем язык
+const langCookie = "filebox_lang"
+
+const defaultLang = "ru"
+
+// locale — форматирование чисел и дат языка
+type locale struct {
+	decimal string   // Десятичный разделитель
+	units   []string // Б, КБ, МБ, ...
+	date    string   // Раскладка time.Format для даты изменения
+}
+
+var locales = map[string]locale{
+	"ru": {decimal: ",", units: []string{"Б", "КБ", "МБ", "ГБ", "ТБ", "ПБ", "ЭБ"}, date: "02.01.2006 15:04"},
+	"en": {decimal: ".", units: []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}, date: "01/02/2006 3:04 PM"},
+}
+
+// langOption — пункт переключателя языка
+type langOption struct {
+	Code string
+	Name string
+}
+
+// langNames — подписи переключателя, в порядке показа
+var langNames = []langOption{
+	{"ru", "Русский"},
+	{"en", "English"},
+}
+
+// bundles — каталог сообщений: язык → ключ → формат для fmt.Sprintf
+var bundles = map[string]map[string]string{
+	"ru": {
+		// Ошибки обработчиков
+		"err.method_not_allowed": "Метод не разрешён",
+		"err.forbidden_path":     "Доступ запрещён: Недопустимый путь",
+		"err.bad_path":           "Недопустимый путь",
+		"err.stat_failed":        "Ошибка сервера при чтении файла/папки",
+		"err.readdir_failed":     "Не могу прочитать папку",
+		"err.template":           "Ошибка шаблона: %v",
+		"err.no_target_dir":      "Целевая папка не существует",
+		"err.no_files":           "Файлы для загрузки не найдены",
+		"err.paths_mismatch":     "Число путей не совпадает с числом файлов",
+		"err.bad_conflict":       "Неизвестная политика совпадения имён",
+		"err.upload_start":       "Не удалось начать загрузку",
+		"err.upload_finish":      "Не удалось завершить загрузку",
+		"err.bad_dir_name":       "Недопустимое имя папки (запрещены /, \\, :, ..)",
+		"err.mkdir_failed":       "Не удалось создать папку (возможно, уже существует)",
+		"err.delete_root":        "Нельзя удалить корневую папку",
+		"err.delete_failed":      "Не удалось удалить",
+		"err.bad_json":           "Некорректный JSON",
+		"err.no_paths":           "Не выбрано ни одного пути",
+		"err.too_many_paths":     "Слишком много путей: %d (максимум %d)",
+		"err.bulk_dest":          "Папка назначения: %s",
+		"err.unknown_action":     "Неизвестное действие: %s",
+		"err.admin_only":         "Только для администратора",
+		"err.streaming":          "Потоковая передача не поддерживается",
+		"err.zip_only":           "Можно распаковать только .zip",
+		"err.login_required":     "Требуется вход",
+		"err.home_failed":        "Не удалось создать домашнюю папку",
+		"err.no_such_user":       "Нет такого пользователя",
+		"err.bad_lang":           "Неизвестный язык",
+		"err.retention_off":      "Правила хранения не заданы",
+
+		// Причины в отчётах (msgErr)
+		"path.outside":           "недопустимый путь",
+		"path.absolute":          "абсолютный путь",
+		"path.dotdot":            "компонент \"..\" в пути",
+		"path.empty":             "пустое имя",
+		"upload.quota":           "превышена квота хранилища",
+		"upload.path_too_long":   "слишком длинный путь",
+		"upload.bad_char":        "недопустимый символ в пути",
+		"upload.too_deep":        "слишком глубокая вложенность",
+		"upload.name_too_long":   "слишком длинное имя в пути",
+		"upload.dir_in_place":    "на этом месте уже есть папка",
+		"upload.exists":          "уже существует",
+		"upload.mkdir_failed":    "не удалось создать папку",
+		"upload.read_failed":     "не удалось прочитать файл",
+		"upload.save_failed":     "не удалось сохранить",
+		"upload.not_dir":         "%s — не папка",
+		"upload.cancelled":       "пакет отменён целиком",
+		"extract.open_failed":    "не удалось открыть архив: %v",
+		"extract.too_many_files": "слишком много файлов в архиве",
+		"extract.too_large":      "превышен лимит распакованного размера",
+		"extract.dest_exists":    "папка %s уже существует",
+		"extract.symlink":        "символическая ссылка",
+		"extract.not_regular":    "не обычный файл",
+		"bulk.root_selected":     "нельзя выбрать корневую папку",
+		"bulk.not_found":         "не найден",
+		"bulk.delete_failed":     "не удалось удалить",
+		"bulk.no_dest":           "папка назначения не существует",
+		"bulk.into_itself":       "нельзя переместить папку в саму себя",
+		"bulk.dest_taken":        "в папке назначения уже есть %s",
+		"bulk.move_failed":       "не удалось переместить",
+
+		// index.html
+		"index.title":          "Файлообменник с папками",
+		"index.heading":        "Мой Файлообменник",
+		"index.logged_in":      "Вы вошли как",
+		"index.admin":          "(администратор)",
+		"index.stats_link":     "Статистика хранилища",
+		"index.user_folder":    "Папка пользователя:",
+		"index.all_uploads":    "— весь uploads —",
+		"index.path":           "Путь:",
+		"index.upload_file":    "Загрузить файл",
+		"index.new_folder":     "Создать папку",
+		"index.up":             "На уровень вверх",
+		"index.folder_name":    "Имя папки",
+		"index.create":         "Создать",
+		"index.cancel":         "Отмена",
+		"index.drop_here":      "Перетащите файлы сюда или нажмите",
+		"index.upload_folder":  "Загрузить папку…",
+		"index.extract_after":  "Распаковать .zip после загрузки",
+		"index.all_or_nothing": "Всё или ничего",
+		"index.on_conflict":    "При совпадении имён:",
+		"index.overwrite":      "заменить",
+		"index.skip":           "пропустить",
+		"index.rename":         "переименовать",
+		"index.contents":       "Содержимое",
+		"index.empty":          "Пусто. Загрузите файлы или создайте папку.",
+		"index.delete_sel":     "Удалить выбранное",
+		"index.move_sel":       "Переместить…",
+		"index.zip_sel":        "Скачать .zip",
+		"index.hotkeys":        "Ctrl+A — выделить всё, Delete — удалить, M — переместить, Z — архив",
+		"index.col_name":       "Имя",
+		"index.col_size":       "Размер",
+		"index.col_modified":   "Изменён",
+		"index.col_actions":    "Действия",
+		"index.download":       "Скачать",
+		"index.extract":        "Распаковать",
+		"index.delete":         "Удалить",
+		"index.confirm_delete": "Вы действительно хотите удалить %s?",
+		"index.language":       "Язык:",
+		"js.upload_error":      "Ошибка при загрузке: ",
+		"js.network_error":     "Ошибка сети: ",
+		"js.nothing_selected":  "Ничего не выбрано",
+		"js.confirm_trash":     "Переместить в корзину: %s шт.?",
+		"js.move_dest":         "Папка назначения (относительно корня):",
+		"js.error":             "Ошибка: ",
+		"js.not_done":          "Не выполнено:",
+
+		// upload.html и extract.html
+		"upload.title":       "Итоги загрузки",
+		"upload.heading":     "Загрузка",
+		"upload.rolled_back": "Режим «всё или ничего»: часть файлов не загрузилась, ничего не сохранено.",
+		"upload.col_file":    "Файл",
+		"upload.col_result":  "Итог",
+		"upload.stored":      "загружен:",
+		"extract.title":      "Распаковка архива",
+		"extract.heading":    "Распаковка",
+		"extract.aborted":    "Распаковка прервана: %s",
+		"extract.done_into":  "Распаковано в",
+		"extract.files":      "%d файл(ов)",
+		"extract.skipped":    "Пропущено: %d",
+		"page.back":          "Назад",
+
+		// /stats
+		"stats.title":             "Статистика",
+		"stats.heading":           "Статистика хранилища",
+		"stats.dedup_on":          "Дедупликация: включена",
+		"stats.dedup_off":         "Дедупликация: выключена",
+		"stats.files":             "Файлов: %d, уникального содержимого: %d",
+		"stats.stored":            "Занято на диске: %s из %s",
+		"stats.saved":             "Сэкономлено дедупликацией:",
+		"stats.shared":            "Общее содержимое",
+		"stats.links":             "%s, ссылок на один файл: %d",
+		"stats.retention":         "Очистка по правилам хранения",
+		"stats.retention_never":   "Ещё не запускалась",
+		"stats.retention_last":    "Последний проход: %s",
+		"stats.retention_moved":   "в корзину перенесено файлов: %d (%s)",
+		"stats.retention_failed":  "не удалось перенести: %d",
+		"stats.retention_busy":    "пропущена — идёт загрузка",
+		"stats.retention_missing": "пропущена — папки нет",
+		"stats.retention_error":   "пропущена — не удалось прочитать",
+	},
+	"en": {
+		"err.method_not_allowed": "Method not allowed",
+		"err.forbidden_path":     "Access denied: invalid path",
+		"err.bad_path":           "Invalid path",
+		"err.stat_failed":        "Server error while reading the file or folder",
+		"err.readdir_failed":     "Cannot read the folder",
+		"err.template":           "Template error: %v",
+		"err.no_target_dir":      "The target folder does not exist",
+		"err.no_files":           "No files to upload",
+		"err.paths_mismatch":     "The number of paths does not match the number of files",
+		"err.bad_conflict":       "Unknown name conflict policy",
+		"err.upload_start":       "Could not start the upload",
+		"err.upload_finish":      "Could not finish the upload",
+		"err.bad_dir_name":       "Invalid folder name (/, \\, :, .. are not allowed)",
+		"err.mkdir_failed":       "Could not create the folder (it may already exist)",
+		"err.delete_root":        "The root folder cannot be deleted",
+		"err.delete_failed":      "Could not delete",
+		"err.bad_json":           "Invalid JSON",
+		"err.no_paths":           "No paths selected",
+		"err.too_many_paths":     "Too many paths: %d (at most %d)",
+		"err.bulk_dest":          "Destination folder: %s",
+		"err.unknown_action":     "Unknown action: %s",
+		"err.admin_only":         "Administrators only",
+		"err.streaming":          "Streaming is not supported",
+		"err.zip_only":           "Only .zip archives can be extracted",
+		"err.login_required":     "Login required",
+		"err.home_failed":        "Could not create the home folder",
+		"err.no_such_user":       "No such user",
+		"err.bad_lang":           "Unknown language",
+		"err.retention_off":      "No retention rules configured",
+
+		"path.outside":           "invalid path",
+		"path.absolute":          "absolute path",
+		"path.dotdot":            "\"..\" component in the path",
+		"path.empty":             "empty name",
+		"upload.quota":           "storage quota exceeded",
+		"upload.path_too_long":   "path too long",
+		"upload.bad_char":        "invalid character in the path",
+		"upload.too_deep":        "nested too deep",
+		"upload.name_too_long":   "name in the path too long",
+		"upload.dir_in_place":    "a folder already exists here",
+		"upload.exists":          "already exists",
+		"upload.mkdir_failed":    "could not create the folder",
+		"upload.read_failed":     "could not read the file",
+		"upload.save_failed":     "could not save",
+		"upload.not_dir":         "%s is not a folder",
+		"upload.cancelled":       "the whole batch was cancelled",
+		"extract.open_failed":    "could not open the archive: %v",
+		"extract.too_many_files": "too many files in the archive",
+		"extract.too_large":      "unpacked size limit exceeded",
+		"extract.dest_exists":    "folder %s already exists",
+		"extract.symlink":        "symbolic link",
+		"extract.not_regular":    "not a regular file",
+		"bulk.root_selected":     "the root folder cannot be selected",
+		"bulk.not_found":         "not found",
+		"bulk.delete_failed":     "could not delete",
+		"bulk.no_dest":           "the destination folder does not exist",
+		"bulk.into_itself":       "a folder cannot be moved into itself",
+		"bulk.dest_taken":        "the destination already has %s",
+		"bulk.move_failed":       "could not move",
+
+		"index.title":          "File exchange with folders",
+		"index.heading":        "My File Exchange",
+		"index.logged_in":      "Logged in as",
+		"index.admin":          "(administrator)",
+		"index.stats_link":     "Storage statistics",
+		"index.user_folder":    "User folder:",
+		"index.all_uploads":    "— all of uploads —",
+		"index.path":           "Path:",
+		"index.upload_file":    "Upload file",
+		"index.new_folder":     "New folder",
+		"index.up":             "Up one level",
+		"index.folder_name":    "Folder name",
+		"index.create":         "Create",
+		"index.cancel":         "Cancel",
+		"index.drop_here":      "Drop files here or click",
+		"index.upload_folder":  "Upload folder…",
+		"index.extract_after":  "Extract .zip after upload",
+		"index.all_or_nothing": "All or nothing",
+		"index.on_conflict":    "On name conflict:",
+		"index.overwrite":      "replace",
+		"index.skip":           "skip",
+		"index.rename":         "rename",
+		"index.contents":       "Contents",
+		"index.empty":          "Empty. Upload files or create a folder.",
+		"index.delete_sel":     "Delete selected",
+		"index.move_sel":       "Move…",
+		"index.zip_sel":        "Download .zip",
+		"index.hotkeys":        "Ctrl+A — select all, Delete — delete, M — move, Z — archive",
+		"index.col_name":       "Name",
+		"index.col_size":       "Size",
+		"index.col_modified":   "Modified",
+		"index.col_actions":    "Actions",
+		"index.download":       "Download",
+		"index.extract":        "Extract",
+		"index.delete":         "Delete",
+		"index.confirm_delete": "Do you really want to delete %s?",
+		"index.language":       "Language:",
+		"js.upload_error":      "Upload failed: ",
+		"js.network_error":     "Network error: ",
+		"js.nothing_selected":  "Nothing selected",
+		"js.confirm_trash":     "Move %s item(s) to the trash?",
+		"js.move_dest":         "Destination folder (relative to the root):",
+		"js.error":             "Error: ",
+		"js.not_done":          "Not done:",
+
+		"upload.title":       "Upload summary",
+		"upload.heading":     "Upload",
+		"upload.rolled_back": "All-or-nothing mode: some files failed, nothing was saved.",
+		"upload.col_file":    "File",
+		"upload.col_result":  "Result",
+		"upload.stored":      "uploaded:",
+		"extract.title":      "Archive extraction",
+		"extract.heading":    "Extraction",
+		"extract.aborted":    "Extraction aborted: %s",
+		"extract.done_into":  "Extracted to",
+		"extract.files":      "%d file(s)",
+		"extract.skipped":    "Skipped: %d",
+		"page.back":          "Back",
+
+		"stats.title":             "Statistics",
+		"stats.heading":           "Storage statistics",
+		"stats.dedup_on":          "Deduplication: on",
+		"stats.dedup_off":         "Deduplication: off",
+		"stats.files":             "Files: %d, unique contents: %d",
+		"stats.stored":            "Used on disk: %s of %s",
+		"stats.saved":             "Saved by deduplication:",
+		"stats.shared":            "Shared contents",
+		"stats.links":             "%s, links to one file: %d",
+		"stats.retention":         "Retention cleanup",
+		"stats.retention_never":   "Has not run yet",
+		"stats.retention_last":    "Last run: %s",
+		"stats.retention_moved":   "files moved to trash: %d (%s)",
+		"stats.retention_failed":  "failed to move: %d",
+		"stats.retention_busy":    "skipped — an upload is in progress",
+		"stats.retention_missing": "skipped — no such folder",
+		"stats.retention_error":   "skipped — cannot read the folder",
+	},
+}
+
+// Translator — сообщения и форматы одного языка
+type Translator struct {
+	Lang string
+}
+
+// T — сообщение key с аргументами; ключа нет в языке — из defaultLang,
+// нет и там — сам ключ (заметно на странице, а тест ловит это раньше)
+func (t Translator) T(key string, args ...any) string {
+	format, ok := bundles[t.Lang][key]
+	if !ok {
+		if format, ok = bundles[defaultLang][key]; !ok {
+			return key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Err — текст ошибки на языке t: msgErr переводится, остальные как есть
+func (t Translator) Err(err error) string {
+	var m *msgError
+	if errors.As(err, &m) {
+		return t.T(m.key, m.args...)
+	}
+	return err.Error()
+}
+
+func (t Translator) locale() locale {
+	if l, ok := locales[t.Lang]; ok {
+		return l
+	}
+	return locales[defaultLang]
+}
+
+// Size — размер файла: 512 Б, 2,10 МБ / 512 B, 2.10 MB
+func (t Translator) Size(bytes int64) string {
+	l := t.locale()
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d %s", bytes, l.units[0])
+	}
+	div, exp := int64(unit), 1
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	num := strconv.FormatFloat(float64(bytes)/float64(div), 'f', 2, 64)
+	return strings.Replace(num, ".", l.decimal, 1) + " " + l.units[exp]
+}
+
+// Date — время изменения в местном порядке: 31.12.2024 18:05 / 12/31/2024 6:05 PM
+func (t Translator) Date(at time.Time) string {
+	return at.Format(t.locale().date)
+}
+
+// Langs — варианты переключателя языка
+func (t Translator) Langs() []langOption {
+	return langNames
+}
+
+// msgError — ошибка с ключом каталога; переводится Translator.Err
+type msgError struct {
+	key  string
+	args []any
+}
+
+func msgErr(key string, args ...any) error {
+	return &msgError{key: key, args: args}
+}
+
+func (e *msgError) Error() string {
+	return Translator{Lang: defaultLang}.T(e.key, e.args...)
+}
+
+// Unwrap — первая ошибка среди аргументов (причина для errors.Is)
+func (e *msgError) Unwrap() error {
+	for _, a := range e.args {
+		if err, ok := a.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// translatorFor — язык запроса: cookie, Accept-Language, defaultLang
+func translatorFor(r *http.Request) Translator {
+	if c, err := r.Cookie(langCookie); err == nil {
+		if _, ok := bundles[c.Value]; ok {
+			return Translator{Lang: c.Value}
+		}
+	}
+	if lang := acceptLanguage(r.Header.Get("Accept-Language")); lang != "" {
+		return Translator{Lang: lang}
+	}
+	return Translator{Lang: defaultLang}
+}
+
+// acceptLanguage — поддерживаемый язык с наибольшим q ("en-US,en;q=0.9,ru;q=0.8"
+// → en); при равных q — первый. Нет подходящего — "".
+func acceptLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := bundles[primary]; ok && q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
+
+// httpError — http.Error с сообщением key на языке запроса
+func httpError(w http.ResponseWriter, r *http.Request, status int, key string, args ...any) {
+	http.Error(w, translatorFor(r).T(key, args...), status)
+}
+
+// langHandler — GET /lang?set=<язык>&back=<путь>: запоминает язык в cookie
+// и возвращает на страницу back (только локальный путь)
+func langHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, "err.method_not_allowed")
+		return
+	}
+	lang := r.URL.Query().Get("set")
+	if _, ok := bundles[lang]; !ok {
+		httpError(w, r, http.StatusBadRequest, "err.bad_lang")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: langCookie, Value: lang, Path: "/", MaxAge: 365 * 24 * 3600, SameSite: http.SameSiteLaxMode})
+	back := r.URL.Query().Get("back")
+	if !strings.HasPrefix(back, "/") || strings.HasPrefix(back, "//") || strings.Contains(back, "\\") {
+		back = "/"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
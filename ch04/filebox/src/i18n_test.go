@@ -0,0 +1,212 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ключи каталога в исходниках: обработчики, ошибки и шаблоны
+var keyPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`httpError\(w, r, [^,]+, "([a-z_.]+)"`),
+	regexp.MustCompile(`msgErr\("([a-z_.]+)"`),
+	regexp.MustCompile(`\.T\("([a-z_.]+)"`),
+	regexp.MustCompile(`Tr\.T "([a-z_.]+)"`),
+}
+
+var verbPattern = regexp.MustCompile(`%[a-z]`)
+
+// usedKeys — ключи из не-тестовых .go файлов и static/*.html
+func usedKeys(t *testing.T) map[string]string {
+	t.Helper()
+	files, _ := filepath.Glob("*.go")
+	html, _ := filepath.Glob("static/*.html")
+	used := map[string]string{} // ключ → где встретился
+	for _, name := range append(files, html...) {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, re := range keyPatterns {
+			for _, m := range re.FindAllStringSubmatch(string(data), -1) {
+				used[m[1]] = name
+			}
+		}
+	}
+	if len(used) < 50 {
+		t.Fatalf("only %d keys found, the patterns are out of date", len(used))
+	}
+	return used
+}
+
+func TestCatalogComplete(t *testing.T) {
+	for key, where := range usedKeys(t) {
+		for lang, bundle := range bundles {
+			if _, ok := bundle[key]; !ok {
+				t.Errorf("%s: key %q missing from %s", where, key, lang)
+			}
+		}
+	}
+
+	// Наборы ключей совпадают, и у перевода столько же аргументов
+	for key, ru := range bundles[defaultLang] {
+		for lang, bundle := range bundles {
+			msg, ok := bundle[key]
+			if !ok {
+				t.Errorf("key %q missing from %s", key, lang)
+				continue
+			}
+			if got, want := verbPattern.FindAllString(msg, -1), verbPattern.FindAllString(ru, -1); strings.Join(got, "") != strings.Join(want, "") {
+				t.Errorf("%s %q: verbs %v, ru has %v", lang, key, got, want)
+			}
+		}
+	}
+	for lang, bundle := range bundles {
+		var extra []string
+		for key := range bundle {
+			if _, ok := bundles[defaultLang][key]; !ok {
+				extra = append(extra, key)
+			}
+		}
+		sort.Strings(extra)
+		if len(extra) > 0 {
+			t.Errorf("%s has keys missing from %s: %v", lang, defaultLang, extra)
+		}
+	}
+}
+
+func TestTranslatorFor(t *testing.T) {
+	for _, c := range []struct{ cookie, accept, want string }{
+		{"", "", "ru"},
+		{"", "en-US,en;q=0.9,ru;q=0.8", "en"},
+		{"", "ru;q=0.5, en-GB;q=0.7", "en"},
+		{"", "de-DE,fr;q=0.9", "ru"},
+		{"", "en;q=bad, ru", "ru"},
+		{"ru", "en", "ru"},
+		{"en", "ru", "en"},
+		{"xx", "en", "en"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: langCookie, Value: c.cookie})
+		}
+		if c.accept != "" {
+			r.Header.Set("Accept-Language", c.accept)
+		}
+		if got := translatorFor(r).Lang; got != c.want {
+			t.Errorf("cookie %q Accept-Language %q: %s, want %s", c.cookie, c.accept, got, c.want)
+		}
+	}
+}
+
+func TestLocaleFormats(t *testing.T) {
+	ru, en := Translator{Lang: "ru"}, Translator{Lang: "en"}
+	for _, c := range []struct {
+		bytes  int64
+		ru, en string
+	}{
+		{512, "512 Б", "512 B"},
+		{2202009, "2,10 МБ", "2.10 MB"},
+		{3 << 30, "3,00 ГБ", "3.00 GB"},
+	} {
+		if got := ru.Size(c.bytes); got != c.ru {
+			t.Errorf("ru %d: %q, want %q", c.bytes, got, c.ru)
+		}
+		if got := en.Size(c.bytes); got != c.en {
+			t.Errorf("en %d: %q, want %q", c.bytes, got, c.en)
+		}
+	}
+
+	at := time.Date(2024, 12, 31, 18, 5, 0, 0, time.UTC)
+	if got := ru.Date(at); got != "31.12.2024 18:05" {
+		t.Errorf("ru date: %q", got)
+	}
+	if got := en.Date(at); got != "12/31/2024 6:05 PM" {
+		t.Errorf("en date: %q", got)
+	}
+}
+
+func TestErrorsTranslated(t *testing.T) {
+	err := msgErr("bulk.dest_taken", "a.txt")
+	if err.Error() != "в папке назначения уже есть a.txt" {
+		t.Fatalf("ru: %q", err.Error())
+	}
+	if got := (Translator{Lang: "en"}).Err(err); got != "the destination already has a.txt" {
+		t.Fatalf("en: %q", got)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/bulk", strings.NewReader("{"))
+	r.Header.Set("Accept-Language", "en")
+	rec := httptest.NewRecorder()
+	bulkHandler(rec, r)
+	if rec.Code != http.StatusBadRequest || strings.TrimSpace(rec.Body.String()) != bundles["en"]["err.bad_json"] {
+		t.Fatalf("%d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestLangHandler(t *testing.T) {
+	for _, c := range []struct{ back, want string }{
+		{"/docs/sub", "/docs/sub"},
+		{"", "/"},
+		{"//evil.example", "/"},
+		{"https://evil.example", "/"},
+		{`/\evil.example`, "/"},
+	} {
+		rec := httptest.NewRecorder()
+		langHandler(rec, httptest.NewRequest(http.MethodGet, "/lang?set=en&back="+c.back, nil))
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != c.want {
+			t.Errorf("back %q: %d %q, want %q", c.back, rec.Code, rec.Header().Get("Location"), c.want)
+		}
+		if !strings.Contains(rec.Header().Get("Set-Cookie"), langCookie+"=en") {
+			t.Errorf("back %q: no cookie: %q", c.back, rec.Header().Get("Set-Cookie"))
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	langHandler(rec, httptest.NewRequest(http.MethodGet, "/lang?set=xx", nil))
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Set-Cookie") != "" {
+		t.Fatalf("unknown language: %d", rec.Code)
+	}
+}
+
+func TestHomePageInEnglish(t *testing.T) {
+	root := withUploadDir(t)
+	os.WriteFile(filepath.Join(root, "report.bin"), make([]byte, 2202009), 0o644)
+	at := time.Date(2024, 12, 31, 18, 5, 0, 0, time.Local)
+	os.Chtimes(filepath.Join(root, "report.bin"), at, at)
+
+	page := func(lang string) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: langCookie, Value: lang})
+		rec := httptest.NewRecorder()
+		homeHandler(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", lang, rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+	en := page("en")
+	for _, want := range []string{`lang="en"`, "Upload file", "2.10 MB", "12/31/2024 6:05 PM", `/lang?set=ru&back=%2f`} {
+		if !strings.Contains(en, want) {
+			t.Errorf("en page lacks %q", want)
+		}
+	}
+	if strings.Contains(en, "Загрузить") {
+		t.Error("en page still has Russian text")
+	}
+	ru := page("ru")
+	for _, want := range []string{`lang="ru"`, "Загрузить файл", "2,10 МБ", "31.12.2024 18:05"} {
+		if !strings.Contains(ru, want) {
+			t.Errorf("ru page lacks %q", want)
+		}
+	}
+}
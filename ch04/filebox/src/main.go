@@ -1,35 +1,21 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"flag"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Папка, в которой будут храниться все загруженные файлы и созданные папки.
 var uploadDir = "./uploads"
 
-// formatSize — вспомогательная функция для форматирования размера файла.
-// Преобразует количество байт в более понятный формат: B, KB, MB, GB и т.д.
-func formatSize(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.2f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
-
 // funcMap — набор пользовательских функций, которые можно использовать в HTML-шаблоне.
 var funcMap = template.FuncMap{
 	"split": strings.Split,                                                         // Разделить строку по разделителю
@@ -37,7 +23,6 @@ var funcMap = template.FuncMap{
 	"add":   func(a, b int) int { return a + b },                                   // Сложение чисел
 	"slice": func(arr []string, start, end int) []string { return arr[start:end] }, // Вырезать часть массива
 	//"div":        func(a int64, b float64) float64 { return float64(a) / b },            // Деление чисел
-	//"formatSize": formatSize,                                                            // Форматирование размера файла
 }
 
 // tmpl — шаблон HTML-страницы (index.gohtml)
@@ -46,42 +31,42 @@ var tmpl *template.Template
 // init выполняется при старте программы. Загружает шаблон и связывает функции из funcMap.
 func init() {
 	tmpl = template.New("index.html").Funcs(funcMap)
-	tmpl = template.Must(tmpl.ParseFiles("static/index.html"))
+	tmpl = template.Must(tmpl.ParseFiles("static/index.html", "static/extract.html", "static/upload.html"))
 }
 
 // File — структура, описывающая один элемент (файл или папку)
 type File struct {
-	Name          string // Имя файла или папки
-	IsDir         bool   // Признак, является ли это папкой
-	Size          int64  // Размер файла в байтах
-	FormattedSize string // Размер файла в читаемом виде (например, "2.1 MB")
-	URL           string // Ссылка для открытия
-	DeleteURL     string // Ссылка для удаления
+	Name          string    // Имя файла или папки
+	Path          string    // Путь относительно uploadDir (для массовых операций)
+	IsDir         bool      // Признак, является ли это папкой
+	Size          int64     // Размер файла в байтах
+	FormattedSize string    // Размер файла в читаемом виде на языке запроса ("2,10 МБ")
+	ModTime       time.Time // Время изменения
+	FormattedTime string    // Время изменения на языке запроса ("31.12.2024 18:05")
+	URL           string    // Ссылка для открытия
+	DeleteURL     string    // Ссылка для удаления
+	ExtractURL    string    // Ссылка для распаковки (только для .zip)
 }
 
 // PageData — структура данных, передаваемая в шаблон
 type PageData struct {
-	CurrentPath string // Текущая папка (для отображения пути)
-	ParentPath  string // Родительская папка (для кнопки "Назад")
-	Items       []File // Список файлов и папок
+	CurrentPath string   // Текущая папка (для отображения пути)
+	ParentPath  string   // Родительская папка (для кнопки "Назад")
+	Items       []File   // Список файлов и папок
+	User        *User    // Кто вошёл (nil — без авторизации)
+	Users       []string // Для переключателя администратора
+	Tr          Translator
+	Back        string // Текущий адрес — куда вернуть переключатель языка
 }
 
 // homeHandler — обрабатывает отображение текущей папки и списка файлов
 func homeHandler(w http.ResponseWriter, r *http.Request) {
-	// Извлекаем путь из URL, очищаем и приводим к безопасному виду
-	rawPath := strings.TrimPrefix(r.URL.Path, "/")
-	cleanPath := path.Clean(rawPath)
-	if cleanPath == "." {
-		cleanPath = ""
-	}
-
-	osPath := filepath.FromSlash(cleanPath)
-	fullPath := filepath.Join(uploadDir, osPath)
-
-	// Проверяем, что пользователь не пытается выйти за пределы uploadDir
-	rel, err := filepath.Rel(uploadDir, fullPath)
-	if err != nil || strings.Contains(rel, "..") {
-		http.Error(w, "Доступ запрещён: Недопустимый путь", http.StatusForbidden)
+	// Путь из URL — относительно корня пользователя, выйти за него нельзя
+	u := userFrom(r)
+	tr := translatorFor(r)
+	cleanPath, fullPath, err := resolvePath(u, strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, "err.forbidden_path")
 		return
 	}
 
@@ -91,7 +76,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 			http.NotFound(w, r)
 		} else {
 			log.Printf("Ошибка при os.Stat(%s): %v", fullPath, err)
-			http.Error(w, "Ошибка сервера при чтении файла/папки", http.StatusInternalServerError)
+			httpError(w, r, http.StatusInternalServerError, "err.stat_failed")
 		}
 		return
 	}
@@ -107,7 +92,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	entries, err := os.ReadDir(fullPath)
 	if err != nil {
 		log.Printf("Ошибка при os.ReadDir(%s): %v", fullPath, err)
-		http.Error(w, "Не могу прочитать папку", http.StatusInternalServerError)
+		httpError(w, r, http.StatusInternalServerError, "err.readdir_failed")
 		return
 	}
 
@@ -117,11 +102,12 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		info, err := entry.Info()
 
 		size := int64(0)
-		formattedSize := "N/A"
+		formattedSize, modTime, formattedTime := "—", time.Time{}, "—"
 
 		if err == nil {
 			size = info.Size()
-			formattedSize = formatSize(size)
+			formattedSize = tr.Size(size)
+			modTime, formattedTime = info.ModTime(), tr.Date(info.ModTime())
 		} else {
 			log.Printf("Ошибка чтения info для %s: %v", entry.Name(), err)
 		}
@@ -130,9 +116,12 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 
 		item := File{
 			Name:          name,
+			Path:          path.Join(cleanPath, name),
 			IsDir:         entry.IsDir(),
 			Size:          size,
 			FormattedSize: formattedSize,
+			ModTime:       modTime,
+			FormattedTime: formattedTime,
 		}
 
 		// Формируем ссылки
@@ -143,6 +132,9 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		item.DeleteURL = "/delete/" + path.Join(cleanPath, name)
+		if !entry.IsDir() && isZip(name) {
+			item.ExtractURL = "/extract/" + path.Join(cleanPath, name)
+		}
 
 		items = append(items, item)
 	}
@@ -157,20 +149,26 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		CurrentPath: cleanPath,
 		ParentPath:  parent,
 		Items:       items,
+		User:        u,
+		Tr:          tr,
+		Back:        r.URL.Path,
+	}
+	if u != nil && u.Admin {
+		data.Users = users.Names()
 	}
 
 	// Отправляем данные в шаблон
 	err = tmpl.ExecuteTemplate(w, "index.html", data)
 	if err != nil {
 		log.Println("Template execute error:", err)
-		http.Error(w, "Ошибка шаблона: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, tr.T("err.template", err), http.StatusInternalServerError)
 	}
 }
 
 // uploadHandler — обработчик загрузки файлов
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не разрешён", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, "err.method_not_allowed")
 		return
 	}
 
@@ -178,59 +176,97 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	// Важно: r.ParseMultipartForm должен быть вызван для доступа к r.MultipartForm.File
 	r.ParseMultipartForm(100 << 20)
 
-	dir := r.FormValue("dir") // Папка, куда загружаем
-	osDir := filepath.FromSlash(dir)
-	fullDir := filepath.Join(uploadDir, osDir)
-
-	// Проверяем безопасность пути
-	rel, err := filepath.Rel(uploadDir, fullDir)
-	if err != nil || strings.Contains(rel, "..") {
-		http.Error(w, "Недопустимый путь", http.StatusBadRequest)
+	// Папка, куда загружаем (относительно корня пользователя)
+	u := userFrom(r)
+	dir, fullDir, err := resolvePath(u, r.FormValue("dir"))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "err.bad_path")
 		return
 	}
 
 	// Проверка, что целевая папка существует
 	if _, err := os.Stat(fullDir); os.IsNotExist(err) {
-		http.Error(w, "Целевая папка не существует", http.StatusNotFound)
+		httpError(w, r, http.StatusNotFound, "err.no_target_dir")
 		return
 	}
 
+	// Пока идёт загрузка, очистка по правилам эту папку не трогает
+	defer uploadLocks.Acquire(uploadKey(fullDir))()
+
 	// Получаем массив всех загруженных файлов с именем "file"
 	files := r.MultipartForm.File["file"]
 
 	if len(files) == 0 {
-		http.Error(w, "Файлы для загрузки не найдены", http.StatusBadRequest)
+		httpError(w, r, http.StatusBadRequest, "err.no_files")
 		return
 	}
 
-	for _, header := range files {
-		file, err := header.Open()
-		if err != nil {
-			log.Printf("Ошибка открытия загруженного файла %s: %v", header.Filename, err)
-			// Продолжаем с другими файлами, но можем отправить ошибку
-			continue
-		}
-		defer file.Close() // defer внутри цикла — это нормально для локальной переменной 'file'
+	// Относительные пути при загрузке папки — по одному на каждую часть "file"
+	paths := r.MultipartForm.Value["path"]
+	if len(paths) > 0 && len(paths) != len(files) {
+		httpError(w, r, http.StatusBadRequest, "err.paths_mismatch")
+		return
+	}
+
+	conflict := r.FormValue("conflict")
+	switch conflict {
+	case "":
+		conflict = conflictOverwrite
+	case conflictOverwrite, conflictSkip, conflictRename:
+	default:
+		httpError(w, r, http.StatusBadRequest, "err.bad_conflict")
+		return
+	}
+	atomic := r.FormValue("atomic") == "1"
+	tr := translatorFor(r)
 
-		dstPath := filepath.Join(fullDir, header.Filename)
+	batch, err := newTreeUpload(u, tr, fullDir, conflict, atomic)
+	if err != nil {
+		log.Printf("Ошибка подготовки загрузки в %s: %v", fullDir, err)
+		httpError(w, r, http.StatusInternalServerError, "err.upload_start")
+		return
+	}
 
-		// Создаём новый файл и копируем содержимое
-		dst, err := os.Create(dstPath)
-		if err != nil {
-			log.Printf("Ошибка при os.Create(%s): %v", dstPath, err)
-			// Можно проигнорировать этот файл и продолжить, или выйти с ошибкой.
-			// Здесь мы продолжим.
-			continue
+	var uploads []UploadResult
+	tree, failed := false, false
+	for i, header := range files {
+		name := header.Filename
+		if len(paths) > 0 && paths[i] != "" {
+			name, tree = paths[i], true
 		}
-		defer dst.Close()
+		res := batch.store(header, name)
+		failed = failed || res.Status != "ok"
+		uploads = append(uploads, res)
+	}
 
-		_, err = io.Copy(dst, file)
-		if err != nil {
-			log.Printf("Ошибка копирования в файл %s: %v", dstPath, err)
-			continue
+	rolledBack, err := batch.finish(uploads)
+	if err != nil {
+		log.Printf("Ошибка переноса загрузки в %s: %v", fullDir, err)
+		httpError(w, r, http.StatusInternalServerError, "err.upload_finish")
+		return
+	}
+	batch.publish(uploads)
+
+	// Флажок "распаковать после загрузки" — касается только .zip
+	var results []ExtractResult
+	if r.FormValue("extract") == "1" {
+		for _, up := range uploads {
+			if up.Status == "ok" && isZip(up.Stored) {
+				results = append(results, runExtract(r.Context(), tr, u, filepath.Join(u.Root(), filepath.FromSlash(up.Stored))))
+			}
 		}
 	}
 
+	// Папки, atomic-пакеты и частичные неудачи — отчёт по каждому файлу
+	if tree || atomic || failed {
+		renderUploadSummary(w, UploadPageData{Tr: tr, ReturnPath: dir, RolledBack: rolledBack, Results: uploads, Extract: results})
+		return
+	}
+	if len(results) > 0 {
+		renderExtractSummary(w, tr, dir, results)
+		return
+	}
+
 	// После успешной загрузки всех файлов — возвращаемся на текущую директорию
 	http.Redirect(w, r, "/"+dir, http.StatusSeeOther)
 }
@@ -238,7 +274,7 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 // mkdirHandler — создаёт новую папку в текущем каталоге
 func mkdirHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не разрешён", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, "err.method_not_allowed")
 		return
 	}
 
@@ -247,67 +283,58 @@ func mkdirHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Проверка корректности имени (нельзя ../, /, \, :)
 	if name == "" || strings.ContainsAny(name, "/\\:") || strings.Contains(name, "..") {
-		http.Error(w, "Недопустимое имя папки (запрещены /, \\, :, ..)", http.StatusBadRequest)
+		httpError(w, r, http.StatusBadRequest, "err.bad_dir_name")
 		return
 	}
 
-	osDir := filepath.FromSlash(dir)
-	fullPath := filepath.Join(uploadDir, osDir, name)
-
 	// Проверка безопасности пути
-	rel, err := filepath.Rel(uploadDir, fullPath)
-	if err != nil || strings.Contains(rel, "..") {
-		http.Error(w, "Недопустимый путь", http.StatusBadRequest)
+	newPath, fullPath, err := resolvePath(userFrom(r), dir+"/"+name)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "err.bad_path")
 		return
 	}
 
 	// Пытаемся создать папку
 	if err := os.Mkdir(fullPath, os.ModePerm); err != nil {
 		log.Printf("Ошибка при os.Mkdir(%s): %v", fullPath, err)
-		http.Error(w, "Не удалось создать папку (возможно, уже существует)", http.StatusInternalServerError)
+		httpError(w, r, http.StatusInternalServerError, "err.mkdir_failed")
 		return
 	}
+	publishEntry(eventCreated, fullPath)
 
 	// После создания — переходим в новую папку
-	newPath := path.Join(dir, name)
 	http.Redirect(w, r, "/"+newPath, http.StatusSeeOther)
 }
 
 // deleteHandler — удаляет файл или папку (включая всё содержимое)
 func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Метод не разрешён", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, "err.method_not_allowed")
 		return
 	}
 
-	rawPath := strings.TrimPrefix(r.URL.Path, "/delete/")
-	cleanPath := path.Clean(rawPath)
-	if strings.HasPrefix(cleanPath, "/") {
-		cleanPath = strings.TrimPrefix(cleanPath, "/")
-	}
-
-	// Нельзя удалить корневую папку
-	if cleanPath == "" || cleanPath == "." {
-		http.Error(w, "Нельзя удалить корневую папку", http.StatusForbidden)
+	// Проверка безопасности пути
+	cleanPath, fullPath, err := resolvePath(userFrom(r), strings.TrimPrefix(r.URL.Path, "/delete/"))
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, "err.forbidden_path")
 		return
 	}
 
-	osPath := filepath.FromSlash(cleanPath)
-	fullPath := filepath.Join(uploadDir, osPath)
-
-	// Проверка безопасности пути
-	rel, err := filepath.Rel(uploadDir, fullPath)
-	if err != nil || strings.Contains(rel, "..") {
-		http.Error(w, "Доступ запрещён: Недопустимый путь", http.StatusForbidden)
+	// Нельзя удалить корневую папку
+	if cleanPath == "" {
+		httpError(w, r, http.StatusForbidden, "err.delete_root")
 		return
 	}
 
 	// Удаляем файл или папку
 	if err := os.RemoveAll(fullPath); err != nil {
 		log.Printf("Ошибка при os.RemoveAll(%s): %v", fullPath, err)
-		http.Error(w, "Не удалось удалить", http.StatusInternalServerError)
+		httpError(w, r, http.StatusInternalServerError, "err.delete_failed")
 		return
 	}
+	// Удалён только путь: другие ссылки на то же содержимое остаются целы
+	index.RemoveTree(relToUpload(fullPath))
+	publishEntry(eventDeleted, fullPath)
 
 	// После удаления — переходим в родительскую папку
 	parent := path.Dir(cleanPath)
@@ -317,18 +344,85 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/"+parent, http.StatusSeeOther)
 }
 
+// newMux — маршруты HTTP
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", homeHandler)                             // Главная страница — список файлов/папок
+	mux.HandleFunc("/upload", uploadHandler)                     // Загрузка файла
+	mux.HandleFunc("/mkdir", mkdirHandler)                       // Создание папки
+	mux.HandleFunc("/delete/", deleteHandler)                    // Удаление файла или папки
+	mux.HandleFunc("/extract/", extractHandler)                  // Распаковка .zip
+	mux.HandleFunc("/bulk", bulkHandler)                         // Массовые операции
+	mux.HandleFunc("/stats", statsHandler)                       // Статистика и экономия от dedup
+	mux.HandleFunc("/files/", filesHandler)                      // Отдача файлов
+	mux.HandleFunc("/view", viewHandler)                         // Переключатель пользователя (админ)
+	mux.HandleFunc("/events", eventsHandler)                     // Уведомления об изменениях (SSE)
+	mux.HandleFunc("/lang", langHandler)                         // Переключатель языка
+	mux.HandleFunc("/retention/dry-run", retentionDryRunHandler) // Что перенесла бы очистка
+	return mux
+}
+
 // Точка входа в программу
 func main() {
+	flag.BoolVar(&dedupEnabled, "dedup", false, "одинаковые загрузки хранить жёсткими ссылками на один файл")
+	flag.Int64Var(&uploadQuota, "quota", 0, "предел суммарного размера загрузок в байтах на пользователя (0 — без предела)")
+	usersPath := flag.String("users", "", "файл пользователей (JSON): вход по паролю, у каждого своя папка; пусто — без авторизации")
+	addName := flag.String("adduser", "", "добавить пользователя в файл -users (пароль — первой строкой stdin) и выйти")
+	addAdmin := flag.Bool("admin", false, "с -adduser: пользователь — администратор")
+	retentionPath := flag.String("retention", "", "файл правил хранения (JSON): пределы возраста, размера и числа файлов для верхних папок")
+	retentionEvery := flag.Duration("retention-every", time.Hour, "период очистки по правилам -retention")
+	auditPath := flag.String("audit", "", "файл журнала аудита (JSON-строки); пусто — журнал сервера")
+	flag.Parse()
+
+	if *addName != "" {
+		if *usersPath == "" {
+			log.Fatal("-adduser требует -users")
+		}
+		if err := addUser(*usersPath, *addName, *addAdmin, os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Пользователь %s сохранён в %s", *addName, *usersPath)
+		return
+	}
+	if *usersPath != "" {
+		var err error
+		if users, err = loadUsers(*usersPath); err != nil {
+			log.Fatal("Пользователи: ", err)
+		}
+		log.Printf("Авторизация включена: %d пользователей", len(users.Names()))
+	}
+
+	if *auditPath != "" {
+		if err := openAudit(*auditPath); err != nil {
+			log.Fatal("Журнал аудита: ", err)
+		}
+	}
+	if *retentionPath != "" {
+		var err error
+		if retentionRules, err = loadRetention(*retentionPath); err != nil {
+			log.Fatal("Правила хранения: ", err)
+		}
+		if *retentionEvery <= 0 {
+			log.Fatal("-retention-every должен быть больше нуля")
+		}
+	}
+
 	log.Println("Инициализация: Создание директории для загрузки")
 	os.MkdirAll(uploadDir, os.ModePerm) // Создаёт uploads, если её нет
 
-	// Настраиваем маршруты HTTP
-	http.HandleFunc("/", homeHandler)                                                         // Главная страница — список файлов/папок
-	http.HandleFunc("/upload", uploadHandler)                                                 // Загрузка файла
-	http.HandleFunc("/mkdir", mkdirHandler)                                                   // Создание папки
-	http.HandleFunc("/delete/", deleteHandler)                                                // Удаление файла или папки
-	http.Handle("/files/", http.StripPrefix("/files/", http.FileServer(http.Dir(uploadDir)))) // Отдача файлов
+	if dedupEnabled {
+		// Индекс содержимого: хэшируем всё, что уже лежит в uploadDir
+		if err := index.Rebuild(uploadDir); err != nil {
+			log.Fatal("Индекс содержимого: ", err)
+		}
+		log.Println("Дедупликация включена")
+	}
+
+	if len(retentionRules) > 0 {
+		go retentionLoop(context.Background(), *retentionEvery)
+		log.Printf("Очистка по правилам: %d папок, раз в %s", len(retentionRules), *retentionEvery)
+	}
 
 	log.Println("Сервер запущен на: http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil)) // Запуск HTTP-сервера
+	log.Fatal(http.ListenAndServe(":8080", requireAuth(newMux()))) // Запуск HTTP-сервера
 }
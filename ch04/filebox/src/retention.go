@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Правила хранения: для верхних папок uploadDir (флаг -retention, файл
+// JSON) задаются пределы — возраст файла, суммарный размер, число файлов:
+//
+//	{"rules": [{"dir": "inbox", "max_age": "720h", "max_total_size": 1073741824, "max_files": 500}]}
+//
+// Раз в -retention-every фоновая очистка проходит по каждой папке правила
+// (рекурсивно, только обычные файлы, без временных папок загрузки) и
+// переносит лишнее в корзину trash/retention-<время>/<путь от uploadDir> —
+// ничего не удаляется насовсем. Порядок: сначала файлы старше max_age,
+// потом сверх max_files, потом сверх max_total_size; в последних двух
+// случаях уходят самые старые (по времени изменения). Итог пишется в
+// журнал аудита и виден на /stats.
+//
+// Папку, в которую прямо сейчас идёт загрузка или распаковка, очистка
+// пропускает до следующего раза (uploadLocks), а загрузка, начавшаяся во
+// время очистки папки, ждёт её конца — очистка не перенесёт полузаписанный
+// файл и не сломает пакет загрузки.
+//
+// GET /retention/dry-run (администратор) — отчёт о том, что очистка
+// перенесла бы сейчас; план тот же, что у настоящего прохода.
+
+// Причины переноса файла
+const (
+	reasonMaxAge       = "max_age"
+	reasonMaxFiles     = "max_files"
+	reasonMaxTotalSize = "max_total_size"
+)
+
+// Почему папка пропущена
+const (
+	skipBusy    = "busy"    // Идёт загрузка
+	skipMissing = "missing" // Папки нет
+	skipError   = "error"   // Не удалось прочитать
+)
+
+// retentionTempPrefix — временные файлы и папки загрузки (.upload-staging-*)
+const retentionTempPrefix = ".upload-"
+
+// RetentionRule — пределы для одной верхней папки; 0 — без предела
+type RetentionRule struct {
+	Dir          string `json:"dir"`
+	MaxAge       string `json:"max_age,omitempty"` // time.ParseDuration: "720h"
+	MaxTotalSize int64  `json:"max_total_size,omitempty"`
+	MaxFiles     int    `json:"max_files,omitempty"`
+
+	maxAge time.Duration
+}
+
+// retentionRules — правила из -retention (пусто — очистки нет)
+var retentionRules []RetentionRule
+
+// loadRetention читает и проверяет файл правил
+func loadRetention(path string) ([]RetentionRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Rules []RetentionRule `json:"rules"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateRetention(cfg.Rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg.Rules, nil
+}
+
+// validateRetention проверяет правила и разбирает max_age
+func validateRetention(rules []RetentionRule) error {
+	if len(rules) == 0 {
+		return fmt.Errorf("нет ни одного правила")
+	}
+	seen := map[string]bool{}
+	for i := range rules {
+		r := &rules[i]
+		if r.Dir == "" || r.Dir == ".." || strings.HasPrefix(r.Dir, ".") || strings.ContainsAny(r.Dir, `/\:`) {
+			return fmt.Errorf("правило %d: %q — не имя верхней папки", i+1, r.Dir)
+		}
+		if seen[r.Dir] {
+			return fmt.Errorf("правило %d: папка %q уже описана", i+1, r.Dir)
+		}
+		seen[r.Dir] = true
+		if r.MaxAge != "" {
+			d, err := time.ParseDuration(r.MaxAge)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("правило %d (%s): недопустимый max_age %q", i+1, r.Dir, r.MaxAge)
+			}
+			r.maxAge = d
+		}
+		if r.MaxTotalSize < 0 || r.MaxFiles < 0 {
+			return fmt.Errorf("правило %d (%s): отрицательный предел", i+1, r.Dir)
+		}
+		if r.maxAge == 0 && r.MaxTotalSize == 0 && r.MaxFiles == 0 {
+			return fmt.Errorf("правило %d (%s): не задан ни один предел", i+1, r.Dir)
+		}
+	}
+	return nil
+}
+
+// ---- Блокировки путей ----
+
+// pathLocks — кто сейчас пишет в какие папки. Загрузки держат свою папку
+// назначения (их может быть сколько угодно), очистка — всю папку правила и
+// только если в ней никто не пишет. Пути — относительно uploadDir, "" —
+// сам uploadDir; пути пересекаются, если один лежит внутри другого.
+type pathLocks struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	writers  map[string]int  // Путь → сколько загрузок в нём
+	cleaning map[string]bool // Папки, которые сейчас чистятся
+}
+
+// uploadLocks — блокировки сервера
+var uploadLocks = newPathLocks()
+
+func newPathLocks() *pathLocks {
+	l := &pathLocks{writers: map[string]int{}, cleaning: map[string]bool{}}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// overlaps — один путь лежит внутри другого (или они совпадают)
+func overlaps(a, b string) bool {
+	return a == "" || b == "" || a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// Acquire отмечает запись в rel (ждёт, пока очистка пересекающейся папки
+// закончится); release снимает отметку.
+func (l *pathLocks) Acquire(rel string) (release func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.cleaningLocked(rel) {
+		l.cond.Wait()
+	}
+	l.writers[rel]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.writers[rel]--; l.writers[rel] == 0 {
+			delete(l.writers, rel)
+		}
+	}
+}
+
+func (l *pathLocks) cleaningLocked(rel string) bool {
+	for dir := range l.cleaning {
+		if overlaps(dir, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// TryClean забирает папку dir под очистку; false — в ней идёт запись.
+func (l *pathLocks) TryClean(dir string) (release func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for rel := range l.writers {
+		if overlaps(dir, rel) {
+			return nil, false
+		}
+	}
+	if l.cleaningLocked(dir) {
+		return nil, false
+	}
+	l.cleaning[dir] = true
+	return func() {
+		l.mu.Lock()
+		delete(l.cleaning, dir)
+		l.mu.Unlock()
+		l.cond.Broadcast()
+	}, true
+}
+
+// uploadKey — ключ uploadLocks для папки на диске
+func uploadKey(full string) string {
+	if rel := relToUpload(full); rel != "." {
+		return rel
+	}
+	return ""
+}
+
+// ---- Очистка ----
+
+// RetentionFile — файл, который очистка переносит (или перенесла бы)
+type RetentionFile struct {
+	Path    string    `json:"path"` // Относительно uploadDir
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Reason  string    `json:"reason"`          // reasonMaxAge, ...
+	Error   string    `json:"error,omitempty"` // Перенести не удалось
+}
+
+// RetentionDirReport — итог по папке правила
+type RetentionDirReport struct {
+	Dir     string          `json:"dir"`
+	Skipped string          `json:"skipped,omitempty"` // skipBusy, ...
+	Files   int             `json:"files"`             // Файлов до очистки
+	Bytes   int64           `json:"bytes"`             // Их суммарный размер
+	Removed []RetentionFile `json:"removed"`
+	Failed  int             `json:"failed,omitempty"` // Из Removed не перенесено
+	Freed   int64           `json:"freed"`            // Байт ушло в корзину
+}
+
+// RetentionReport — итог одного прохода
+type RetentionReport struct {
+	Time   time.Time            `json:"time"`
+	DryRun bool                 `json:"dry_run"`
+	Trash  string               `json:"trash,omitempty"` // Папка корзины прохода
+	Dirs   []RetentionDirReport `json:"dirs"`
+}
+
+// lastRetention — последний настоящий проход (для /stats)
+var lastRetention struct {
+	sync.Mutex
+	report *RetentionReport
+}
+
+// retentionFiles — обычные файлы под full, самые старые первыми
+func retentionFiles(full string) ([]RetentionFile, error) {
+	var files []RetentionFile
+	err := filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), retentionTempPrefix) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, RetentionFile{Path: relToUpload(p), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].ModTime.Equal(files[j].ModTime) {
+			return files[i].ModTime.Before(files[j].ModTime)
+		}
+		return files[i].Path < files[j].Path
+	})
+	return files, err
+}
+
+// planRetention — какие из files (самые старые первыми) нарушают rule на момент now
+func planRetention(rule RetentionRule, files []RetentionFile, now time.Time) []RetentionFile {
+	var victims, kept []RetentionFile
+	for _, f := range files {
+		if rule.maxAge > 0 && now.Sub(f.ModTime) > rule.maxAge {
+			f.Reason = reasonMaxAge
+			victims = append(victims, f)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	if rule.MaxFiles > 0 && len(kept) > rule.MaxFiles {
+		n := len(kept) - rule.MaxFiles
+		for _, f := range kept[:n] {
+			f.Reason = reasonMaxFiles
+			victims = append(victims, f)
+		}
+		kept = kept[n:]
+	}
+	if rule.MaxTotalSize > 0 {
+		var total int64
+		for _, f := range kept {
+			total += f.Size
+		}
+		for len(kept) > 0 && total > rule.MaxTotalSize {
+			f := kept[0]
+			f.Reason = reasonMaxTotalSize
+			victims = append(victims, f)
+			total -= f.Size
+			kept = kept[1:]
+		}
+	}
+	return victims
+}
+
+// runRetention применяет rules на момент now; dryRun — только отчёт.
+func runRetention(rules []RetentionRule, now time.Time, dryRun bool) RetentionReport {
+	report := RetentionReport{Time: now, DryRun: dryRun}
+	if !dryRun {
+		report.Trash = "retention-" + now.Format("20060102-150405.000000000")
+	}
+	for _, rule := range rules {
+		report.Dirs = append(report.Dirs, retainDir(rule, now, report.Trash))
+	}
+	if !dryRun {
+		auditRetention(report)
+		lastRetention.Lock()
+		lastRetention.report = &report
+		lastRetention.Unlock()
+	}
+	return report
+}
+
+// retainDir — проход по папке одного правила; trash == "" — dry-run
+func retainDir(rule RetentionRule, now time.Time, trash string) RetentionDirReport {
+	dr := RetentionDirReport{Dir: rule.Dir, Removed: []RetentionFile{}}
+	full := filepath.Join(uploadDir, rule.Dir)
+	if st, err := os.Stat(full); err != nil || !st.IsDir() {
+		dr.Skipped = skipMissing
+		return dr
+	}
+	release, ok := uploadLocks.TryClean(rule.Dir)
+	if !ok {
+		dr.Skipped = skipBusy
+		return dr
+	}
+	defer release()
+
+	files, err := retentionFiles(full)
+	if err != nil {
+		log.Printf("retention %s: %v", full, err)
+		dr.Skipped = skipError
+		return dr
+	}
+	dr.Files = len(files)
+	for _, f := range files {
+		dr.Bytes += f.Size
+	}
+	dr.Removed = planRetention(rule, files, now)
+	for i := range dr.Removed {
+		f := &dr.Removed[i]
+		if trash != "" {
+			if err := moveToTrash(trash, f.Path); err != nil {
+				log.Printf("retention %s: %v", f.Path, err)
+				f.Error = err.Error()
+				dr.Failed++
+				continue
+			}
+		}
+		dr.Freed += f.Size
+	}
+	return dr
+}
+
+// moveToTrash переносит файл rel (относительно uploadDir) в корзину batch,
+// как bulkDelete
+func moveToTrash(batch, rel string) error {
+	full := filepath.Join(uploadDir, filepath.FromSlash(rel))
+	target := filepath.Join(trashDir, batch, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(full, target); err != nil {
+		return err
+	}
+	index.RemoveTree(rel)
+	publishEntry(eventDeleted, full)
+	return nil
+}
+
+// auditRetention — сводка прохода в журнал аудита
+func auditRetention(report RetentionReport) {
+	var removed, failed int
+	var freed int64
+	dirs := make([]map[string]any, 0, len(report.Dirs))
+	for _, d := range report.Dirs {
+		entry := map[string]any{"dir": d.Dir, "removed": len(d.Removed) - d.Failed, "freed": d.Freed}
+		if d.Skipped != "" {
+			entry["skipped"] = d.Skipped
+		}
+		if d.Failed > 0 {
+			entry["failed"] = d.Failed
+		}
+		dirs = append(dirs, entry)
+		removed += len(d.Removed) - d.Failed
+		failed += d.Failed
+		freed += d.Freed
+	}
+	audit("retention", map[string]any{"trash": report.Trash, "removed": removed, "failed": failed, "freed": freed, "dirs": dirs})
+}
+
+// retentionLoop — очистка сразу и затем раз в every, пока ctx не отменён
+func retentionLoop(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		runRetention(retentionRules, time.Now(), false)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// retentionDryRunHandler — GET /retention/dry-run: что очистка перенесла бы сейчас
+func retentionDryRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, "err.method_not_allowed")
+		return
+	}
+	if u := userFrom(r); u != nil && !u.Admin {
+		httpError(w, r, http.StatusForbidden, "err.admin_only")
+		return
+	}
+	if len(retentionRules) == 0 {
+		httpError(w, r, http.StatusNotFound, "err.retention_off")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(runRetention(retentionRules, time.Now(), true)); err != nil {
+		log.Println("retention dry-run response:", err)
+	}
+}
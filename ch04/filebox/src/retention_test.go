@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// withRetentionTree — uploads/logs с файлами по 100 байт разного возраста,
+// временная папка загрузки в logs и папка other без правила
+func withRetentionTree(t *testing.T, now time.Time) {
+	t.Helper()
+	root := withUploadDir(t)
+	withDedup(t, false)
+	for name, age := range map[string]time.Duration{
+		"logs/a.log":               40 * day,
+		"logs/b.log":               20 * day,
+		"logs/sub/c.log":           10 * day,
+		"logs/d.log":               1 * day,
+		"logs/.upload-staging-1/x": 100 * day,
+		"other/keep.txt":           100 * day,
+	} {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(full), os.ModePerm)
+		if err := os.WriteFile(full, bytes.Repeat([]byte("x"), 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(full, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func rules(t *testing.T, rs ...RetentionRule) []RetentionRule {
+	t.Helper()
+	if err := validateRetention(rs); err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+// removed — "путь:причина" перенесённых файлов
+func removed(dr RetentionDirReport) []string {
+	out := []string{}
+	for _, f := range dr.Removed {
+		out = append(out, f.Path+":"+f.Reason)
+	}
+	return out
+}
+
+func exists(rel string) bool {
+	_, err := os.Stat(filepath.Join(uploadDir, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+func TestRetentionRules(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		rule RetentionRule
+		want []string
+	}{
+		{"max_age", RetentionRule{Dir: "logs", MaxAge: "360h"}, []string{"logs/a.log:max_age", "logs/b.log:max_age"}},
+		{"max_files", RetentionRule{Dir: "logs", MaxFiles: 1}, []string{"logs/a.log:max_files", "logs/b.log:max_files", "logs/sub/c.log:max_files"}},
+		{"max_total_size", RetentionRule{Dir: "logs", MaxTotalSize: 250}, []string{"logs/a.log:max_total_size", "logs/b.log:max_total_size"}},
+		{"all", RetentionRule{Dir: "logs", MaxAge: "720h", MaxFiles: 2, MaxTotalSize: 100}, []string{"logs/a.log:max_age", "logs/b.log:max_files", "logs/sub/c.log:max_total_size"}},
+		{"within limits", RetentionRule{Dir: "logs", MaxAge: "1000h", MaxFiles: 10}, []string{}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			now := time.Now()
+			withRetentionTree(t, now)
+			report := runRetention(rules(t, c.rule), now, false)
+			dr := report.Dirs[0]
+			if got := removed(dr); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("removed %v, want %v", got, c.want)
+			}
+			if dr.Files != 4 || dr.Bytes != 400 || dr.Freed != int64(100*len(c.want)) || dr.Failed != 0 {
+				t.Fatalf("report %+v", dr)
+			}
+			for _, f := range dr.Removed {
+				if exists(f.Path) {
+					t.Errorf("%s still in uploads", f.Path)
+				}
+				if _, err := os.Stat(filepath.Join(trashDir, report.Trash, filepath.FromSlash(f.Path))); err != nil {
+					t.Errorf("%s not in trash: %v", f.Path, err)
+				}
+			}
+			// Временные файлы загрузки и папки без правила не трогаются
+			for _, keep := range []string{"logs/d.log", "logs/.upload-staging-1/x", "other/keep.txt"} {
+				if !exists(keep) {
+					t.Errorf("%s removed", keep)
+				}
+			}
+		})
+	}
+}
+
+func TestRetentionDryRunMatchesRun(t *testing.T) {
+	now := time.Now()
+	withRetentionTree(t, now)
+	var auditBuf bytes.Buffer
+	prevAudit := auditOut
+	auditOut = &auditBuf
+	t.Cleanup(func() { auditOut = prevAudit })
+	rs := rules(t,
+		RetentionRule{Dir: "logs", MaxAge: "480h", MaxTotalSize: 150},
+		RetentionRule{Dir: "gone", MaxFiles: 1},
+	)
+
+	lastRetention.Lock()
+	prevLast := lastRetention.report
+	lastRetention.Unlock()
+	dry := runRetention(rs, now, true)
+	if !dry.DryRun || dry.Trash != "" {
+		t.Fatalf("dry-run report: %+v", dry)
+	}
+	for _, f := range dry.Dirs[0].Removed {
+		if !exists(f.Path) {
+			t.Fatalf("dry-run moved %s", f.Path)
+		}
+	}
+	if auditBuf.Len() != 0 {
+		t.Fatalf("dry-run audited: %s", auditBuf.String())
+	}
+	if lastRetention.report != prevLast {
+		t.Fatal("dry-run recorded as the last run")
+	}
+
+	run := runRetention(rs, now, false)
+	for i := range dry.Dirs {
+		if !reflect.DeepEqual(removed(dry.Dirs[i]), removed(run.Dirs[i])) || dry.Dirs[i].Freed != run.Dirs[i].Freed || dry.Dirs[i].Skipped != run.Dirs[i].Skipped {
+			t.Fatalf("dry-run %+v\nreal run %+v", dry.Dirs[i], run.Dirs[i])
+		}
+	}
+	if got := removed(run.Dirs[0]); len(got) != 3 || run.Dirs[1].Skipped != skipMissing {
+		t.Fatalf("real run: %v, %+v", got, run.Dirs[1])
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(auditBuf.Bytes(), &entry); err != nil {
+		t.Fatalf("audit line %q: %v", auditBuf.String(), err)
+	}
+	if entry["action"] != "retention" || entry["removed"] != float64(3) || entry["trash"] != run.Trash {
+		t.Fatalf("audit: %v", entry)
+	}
+
+	// /stats показывает последний проход
+	prevRules := retentionRules
+	retentionRules = rs
+	t.Cleanup(func() { retentionRules = prevRules })
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set("Accept-Language", "en")
+	statsHandler(rec, req)
+	for _, want := range []string{"logs: files moved to trash: 3", "gone: skipped — no such folder"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("stats page lacks %q:\n%s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestRetentionSkipsBusyDir(t *testing.T) {
+	now := time.Now()
+	withRetentionTree(t, now)
+	rs := rules(t, RetentionRule{Dir: "logs", MaxFiles: 1})
+
+	release := uploadLocks.Acquire("logs/sub")
+	report := runRetention(rs, now, false)
+	if dr := report.Dirs[0]; dr.Skipped != skipBusy || len(dr.Removed) != 0 || !exists("logs/a.log") {
+		t.Fatalf("busy dir cleaned: %+v", dr)
+	}
+	release()
+	if report := runRetention(rs, now, false); len(report.Dirs[0].Removed) != 3 {
+		t.Fatalf("after upload: %+v", report.Dirs[0])
+	}
+}
+
+func TestPathLocksUploadWaitsForCleaning(t *testing.T) {
+	l := newPathLocks()
+	done, ok := l.TryClean("logs")
+	if !ok {
+		t.Fatal("TryClean on idle locks")
+	}
+	if _, ok := l.TryClean("logs"); ok {
+		t.Fatal("second TryClean succeeded")
+	}
+	l.Acquire("other")() // Другая папка не ждёт
+
+	acquired := make(chan func())
+	go func() { acquired <- l.Acquire("logs/sub") }()
+	select {
+	case <-acquired:
+		t.Fatal("upload started during cleaning")
+	case <-time.After(50 * time.Millisecond):
+	}
+	done()
+	release := <-acquired
+	if _, ok := l.TryClean("logs"); ok {
+		t.Fatal("TryClean during upload")
+	}
+	if _, ok := l.TryClean(""); ok {
+		t.Fatal("TryClean of uploadDir during upload")
+	}
+	release()
+	if _, ok := l.TryClean("logs"); !ok {
+		t.Fatal("TryClean after upload")
+	}
+}
+
+func TestLoadRetentionValidation(t *testing.T) {
+	dir := t.TempDir()
+	for name, cfg := range map[string]string{
+		"no rules":      `{"rules": []}`,
+		"nested dir":    `{"rules": [{"dir": "a/b", "max_files": 1}]}`,
+		"parent dir":    `{"rules": [{"dir": "..", "max_files": 1}]}`,
+		"hidden dir":    `{"rules": [{"dir": ".upload-x", "max_files": 1}]}`,
+		"duplicate":     `{"rules": [{"dir": "a", "max_files": 1}, {"dir": "a", "max_age": "1h"}]}`,
+		"no limit":      `{"rules": [{"dir": "a"}]}`,
+		"bad age":       `{"rules": [{"dir": "a", "max_age": "30 days"}]}`,
+		"negative":      `{"rules": [{"dir": "a", "max_total_size": -1}]}`,
+		"unknown field": `{"rules": [{"dir": "a", "max_files": 1, "max_size": 5}]}`,
+	} {
+		path := filepath.Join(dir, "rules.json")
+		os.WriteFile(path, []byte(cfg), 0o644)
+		if _, err := loadRetention(path); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	path := filepath.Join(dir, "rules.json")
+	os.WriteFile(path, []byte(`{"rules": [{"dir": "inbox", "max_age": "720h", "max_total_size": 1024}]}`), 0o644)
+	rs, err := loadRetention(path)
+	if err != nil || len(rs) != 1 || rs[0].maxAge != 720*time.Hour {
+		t.Fatalf("%+v %v", rs, err)
+	}
+}
+
+func TestRetentionDryRunHandler(t *testing.T) {
+	now := time.Now()
+	withRetentionTree(t, now)
+	prevRules := retentionRules
+	t.Cleanup(func() { retentionRules = prevRules })
+
+	retentionRules = nil
+	rec := httptest.NewRecorder()
+	retentionDryRunHandler(rec, httptest.NewRequest(http.MethodGet, "/retention/dry-run", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("without rules: %d", rec.Code)
+	}
+
+	retentionRules = rules(t, RetentionRule{Dir: "logs", MaxAge: "360h"})
+	rec = httptest.NewRecorder()
+	retentionDryRunHandler(rec, httptest.NewRequest(http.MethodGet, "/retention/dry-run", nil))
+	var report RetentionReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("%d %s", rec.Code, rec.Body.String())
+	}
+	if !report.DryRun || len(report.Dirs) != 1 || len(report.Dirs[0].Removed) != 2 || !exists("logs/a.log") {
+		t.Fatalf("dry-run: %+v", report)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Загрузка деревьев папок. Браузер (input с webkitdirectory) отдаёт файлы
+// плоским списком, а относительный путь каждого присылается в поле "path"
+// на той же позиции, что и часть "file": multipart оставляет от имени файла
+// только базовое имя. Путь проверяется покомпонентно, промежуточные папки
+// создаются под папкой назначения. Квота и политика совпадения имён
+// применяются к каждому файлу, ошибка одного файла не прерывает остальные.
+//
+// С флажком atomic=1 пакет пишется во временную папку внутри назначения
+// (та же ФС — переименование атомарно) и переносится на место, только если
+// легли все файлы; иначе временная папка удаляется и назначение не меняется.
+
+// Ограничения на присланные относительные пути
+const (
+	maxUploadPathLen   = 1024 // Весь путь, байт
+	maxUploadNameLen   = 255  // Один компонент, байт
+	maxUploadPathDepth = 32   // Компонентов в пути
+)
+
+// uploadQuota — предел суммарного размера домашней папки пользователя (без
+// авторизации — всего uploadDir) в байтах (флаг -quota, 0 — без предела)
+var uploadQuota int64
+
+// Политики совпадения имён (поле формы "conflict")
+const (
+	conflictOverwrite = "overwrite" // По умолчанию: новый файл заменяет старый
+	conflictSkip      = "skip"      // Существующий файл остаётся, новый пропускается
+	conflictRename    = "rename"    // Новый файл получает имя "name (1).ext"
+)
+
+var errQuota = msgErr("upload.quota")
+
+// UploadResult — итог загрузки одного файла
+type UploadResult struct {
+	Path   string // Путь, как прислан (относительно папки назначения)
+	Stored string // Куда лёг файл (относительно корня пользователя)
+	Status string // "ok", "skipped", "error" или "cancelled" (откат пакета)
+	Reason string
+
+	rel      string // Путь относительно папки назначения после переименования
+	replaced bool   // Файл заменил существующий (событие modified)
+}
+
+// UploadPageData — данные для шаблона upload.html
+type UploadPageData struct {
+	Tr         Translator
+	ReturnPath string
+	RolledBack bool // atomic-пакет отменён целиком
+	Results    []UploadResult
+	Extract    []ExtractResult
+}
+
+// uploadRelPath проверяет присланный относительный путь: те же правила,
+// что для записей zip (нет абсолютных путей и ".."), плюс лимиты длины и
+// вложенности. Возвращает очищенный путь со слэшами.
+func uploadRelPath(raw string) (string, error) {
+	if len(raw) > maxUploadPathLen {
+		return "", msgErr("upload.path_too_long")
+	}
+	if strings.ContainsRune(raw, 0) {
+		return "", msgErr("upload.bad_char")
+	}
+	clean, err := zipEntryPath(raw)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(clean, "/")
+	if len(parts) > maxUploadPathDepth {
+		return "", msgErr("upload.too_deep")
+	}
+	for _, part := range parts {
+		if len(part) > maxUploadNameLen {
+			return "", msgErr("upload.name_too_long")
+		}
+	}
+	return clean, nil
+}
+
+// treeUpload — один пакет загрузки: куда пишем и что уже занято
+type treeUpload struct {
+	user     *User            // Чей корень: для путей в отчёте
+	tr       Translator       // Язык причин в отчёте
+	destFull string           // Папка назначения на диске
+	root     string           // Куда пишем сейчас: destFull или временная папка
+	conflict string           // Политика совпадения имён
+	used     int64            // Занято в quotaRoot (считается только при квоте)
+	claimed  map[string]int64 // Пути этого пакета (относительно назначения) → размер
+	newTops  map[string]bool  // Верхние папки дерева → их не было до пакета
+}
+
+// newTreeUpload готовит пакет; в atomic-режиме создаёт временную папку.
+func newTreeUpload(u *User, tr Translator, destFull, conflict string, atomic bool) (*treeUpload, error) {
+	t := &treeUpload{user: u, tr: tr, destFull: destFull, root: destFull, conflict: conflict, claimed: map[string]int64{}, newTops: map[string]bool{}}
+	if uploadQuota > 0 {
+		used, err := diskUsage(quotaRoot(destFull))
+		if err != nil {
+			return nil, err
+		}
+		t.used = used
+	}
+	if atomic {
+		staging, err := os.MkdirTemp(destFull, ".upload-staging-*")
+		if err != nil {
+			return nil, err
+		}
+		t.root = staging
+	}
+	return t, nil
+}
+
+func (t *treeUpload) staged() bool { return t.root != t.destFull }
+
+// store сохраняет одну часть формы под относительным путём raw.
+func (t *treeUpload) store(header *multipart.FileHeader, raw string) UploadResult {
+	res := UploadResult{Path: raw}
+	fail := func(err error) UploadResult {
+		res.Status, res.Reason = "error", t.tr.Err(err)
+		return res
+	}
+	rel, err := uploadRelPath(raw)
+	if err != nil {
+		return fail(err)
+	}
+	if err := t.checkParents(rel); err != nil {
+		return fail(err)
+	}
+
+	// Совпадение имён: на диске назначения или раньше в этом же пакете
+	var freed int64
+	size, claimed := t.claimed[rel]
+	st, statErr := os.Lstat(filepath.Join(t.destFull, filepath.FromSlash(rel)))
+	switch {
+	case statErr == nil && !st.Mode().IsRegular():
+		return fail(msgErr("upload.dir_in_place"))
+	case statErr != nil && !claimed:
+		// Свободно
+	case t.conflict == conflictSkip:
+		res.Status, res.Reason = "skipped", t.tr.T("upload.exists")
+		return res
+	case t.conflict == conflictRename:
+		rel = t.freeName(rel)
+	default:
+		if claimed {
+			freed = size
+		} else {
+			freed = st.Size()
+		}
+		res.replaced = true
+	}
+
+	if uploadQuota > 0 && t.used-freed+header.Size > uploadQuota {
+		return fail(errQuota)
+	}
+
+	if top, _, nested := strings.Cut(rel, "/"); nested {
+		if _, seen := t.newTops[top]; !seen {
+			_, err := os.Lstat(filepath.Join(t.destFull, top))
+			t.newTops[top] = os.IsNotExist(err)
+		}
+	}
+
+	dst := filepath.Join(t.root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		log.Printf("Ошибка создания папки для %s: %v", dst, err)
+		return fail(msgErr("upload.mkdir_failed"))
+	}
+	file, err := header.Open()
+	if err != nil {
+		log.Printf("Ошибка открытия загруженного файла %s: %v", header.Filename, err)
+		return fail(msgErr("upload.read_failed"))
+	}
+	defer file.Close()
+
+	// Временный файл + переименование; в режиме dedup — жёсткая ссылка
+	// на уже хранимую копию того же содержимого
+	linked, err := storeUpload(file, dst)
+	if err != nil {
+		log.Printf("Ошибка сохранения файла %s: %v", dst, err)
+		return fail(msgErr("upload.save_failed"))
+	}
+	if linked {
+		log.Printf("dedup: %s — ссылка на существующую копию", dst)
+	}
+	t.used += header.Size - freed
+	t.claimed[rel] = header.Size
+	res.Stored = relToRoot(t.user, filepath.Join(t.destFull, filepath.FromSlash(rel)))
+	res.rel = rel
+	res.Status = "ok"
+	return res
+}
+
+// checkParents не даёт положить файл туда, где в назначении на месте
+// промежуточной папки лежит файл или симлинк (симлинк увёл бы запись за
+// пределы uploadDir).
+func (t *treeUpload) checkParents(rel string) error {
+	dir := path.Dir(rel)
+	if dir == "." {
+		return nil
+	}
+	cur := t.destFull
+	for _, part := range strings.Split(dir, "/") {
+		cur = filepath.Join(cur, part)
+		st, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || !st.IsDir() {
+			return msgErr("upload.not_dir", relToRoot(t.user, cur))
+		}
+	}
+	return nil
+}
+
+// freeName подбирает "name (N).ext", не занятое ни на диске, ни в пакете.
+func (t *treeUpload) freeName(rel string) string {
+	dir, base := path.Split(rel)
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s%s (%d)%s", dir, stem, i, ext)
+		if _, claimed := t.claimed[candidate]; claimed {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(t.destFull, filepath.FromSlash(candidate))); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// finish завершает atomic-пакет: при ошибке хоть одного файла — откат
+// (rolledBack), иначе перенос временной папки в назначение.
+func (t *treeUpload) finish(results []UploadResult) (rolledBack bool, err error) {
+	if !t.staged() {
+		return false, nil
+	}
+	stagingRel := relToUpload(t.root)
+	defer func() {
+		os.RemoveAll(t.root)
+		index.RemoveTree(stagingRel)
+	}()
+
+	for _, r := range results {
+		if r.Status == "error" {
+			for i := range results {
+				if results[i].Status == "ok" {
+					results[i].Status, results[i].Reason = "cancelled", t.tr.T("upload.cancelled")
+					results[i].Stored = ""
+				}
+			}
+			return true, nil
+		}
+	}
+
+	if err := mergeRename(t.root, t.destFull); err != nil {
+		return false, err
+	}
+	for rel := range t.claimed {
+		index.MoveTree(path.Join(stagingRel, rel), relToUpload(filepath.Join(t.destFull, filepath.FromSlash(rel))))
+	}
+	return false, nil
+}
+
+// publish сообщает подписчикам (events.go) о легших файлах: каждом — в его
+// папке, а папке назначения — о верхних папках загруженного дерева.
+// Вызывается после finish: отменённый пакет ничего не публикует.
+func (t *treeUpload) publish(results []UploadResult) {
+	tops := map[string]bool{}
+	for _, r := range results {
+		if r.Status != "ok" {
+			continue
+		}
+		typ := eventCreated
+		if r.replaced {
+			typ = eventModified
+		}
+		publishEntry(typ, filepath.Join(t.destFull, filepath.FromSlash(r.rel)))
+		top, _, nested := strings.Cut(r.rel, "/")
+		if !nested || tops[top] {
+			continue
+		}
+		tops[top] = true
+		typ = eventModified
+		if t.newTops[top] {
+			typ = eventCreated
+		}
+		events.Publish(relToUpload(t.destFull), Event{Type: typ, Name: top})
+	}
+}
+
+// mergeRename переносит содержимое src в dst. Элемент, которого в dst ещё
+// нет, переносится одним переименованием (новая папка появляется целиком);
+// совпавшие папки сливаются рекурсивно, файлы заменяются.
+func mergeRename(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		from, to := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+		st, err := os.Lstat(to)
+		if err == nil && st.IsDir() && e.IsDir() {
+			if err := mergeRename(from, to); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diskUsage — суммарный размер обычных файлов под root
+func diskUsage(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// renderUploadSummary выводит страницу с итогами по каждому файлу
+func renderUploadSummary(w http.ResponseWriter, data UploadPageData) {
+	if err := tmpl.ExecuteTemplate(w, "upload.html", data); err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, data.Tr.T("err.template", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type treeFile struct {
+	path string
+	body string
+}
+
+// uploadTree отправляет файлы с относительными путями (поле "path") и
+// дополнительными полями формы
+func uploadTree(t *testing.T, dir string, fields map[string]string, files []treeFile) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("dir", dir)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for _, f := range files {
+		fw, _ := mw.CreateFormFile("file", path.Base(f.path))
+		fw.Write([]byte(f.body))
+		mw.WriteField("path", f.path)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	uploadHandler(rec, req)
+	return rec
+}
+
+func readUpload(t *testing.T, root, rel string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		t.Fatalf("%s: %v", rel, err)
+	}
+	return string(data)
+}
+
+func TestTreeUploadCreatesNestedDirs(t *testing.T) {
+	root := withUploadDir(t)
+	withDedup(t, false)
+	rec := uploadTree(t, "docs", nil, []treeFile{
+		{"project/README.md", "readme"},
+		{"project/src/main.go", "package main"},
+		{"project/src/util/deep.go", "package util"},
+	})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "docs/project/src/util/deep.go") {
+		t.Fatalf("summary: %d %s", rec.Code, rec.Body.String())
+	}
+	if got := readUpload(t, root, "docs/project/src/util/deep.go"); got != "package util" {
+		t.Fatalf("deep.go = %q", got)
+	}
+	if got := readUpload(t, root, "docs/project/README.md"); got != "readme" {
+		t.Fatalf("README.md = %q", got)
+	}
+}
+
+func TestTreeUploadRejectsTraversal(t *testing.T) {
+	root := withUploadDir(t)
+	withDedup(t, false)
+	long := strings.Repeat("x", maxUploadNameLen+1)
+	rec := uploadTree(t, "docs", nil, []treeFile{
+		{"../escape.txt", "evil"},
+		{"ok/../../escape.txt", "evil"},
+		{"/etc/passwd", "evil"},
+		{"C:/win.ini", "evil"},
+		{"dir/" + long, "long"},
+		{strings.Repeat("d/", maxUploadPathDepth) + "f", "deep"},
+		{"good/file.txt", "fine"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if strings.Count(rec.Body.String(), `class="error"`) != 6 {
+		t.Fatalf("want 6 rejected files:\n%s", rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape.txt")); err == nil {
+		t.Fatal("file escaped the destination")
+	}
+	if got := readUpload(t, root, "docs/good/file.txt"); got != "fine" {
+		t.Fatal("valid file in a mixed batch must still be stored")
+	}
+
+	// Симлинк на месте промежуточной папки не уводит запись наружу
+	outside := t.TempDir()
+	os.Symlink(outside, filepath.Join(root, "docs", "link"))
+	uploadTree(t, "docs", nil, []treeFile{{"link/pwned.txt", "evil"}})
+	if _, err := os.Stat(filepath.Join(outside, "pwned.txt")); err == nil {
+		t.Fatal("upload followed a symlinked directory")
+	}
+}
+
+func TestTreeUploadAtomicRollback(t *testing.T) {
+	root := withUploadDir(t)
+	withDedup(t, false)
+	rec := uploadTree(t, "dest", map[string]string{"atomic": "1"}, []treeFile{
+		{"album/one.jpg", "1"},
+		{"album/two.jpg", "2"},
+		{"album/../../bad.jpg", "3"},
+	})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "ничего не сохранено") {
+		t.Fatalf("rollback summary: %d %s", rec.Code, rec.Body.String())
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "dest"))
+	if len(entries) != 1 || entries[0].Name() != "taken" {
+		t.Fatalf("dest must be untouched, got %v", entries)
+	}
+
+	rec = uploadTree(t, "dest", map[string]string{"atomic": "1"}, []treeFile{
+		{"album/one.jpg", "1"},
+		{"album/two.jpg", "2"},
+	})
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "ничего не сохранено") {
+		t.Fatalf("commit: %d %s", rec.Code, rec.Body.String())
+	}
+	if readUpload(t, root, "dest/album/two.jpg") != "2" {
+		t.Fatal("atomic batch not committed")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "dest")); len(entries) != 2 {
+		t.Fatalf("staging dir left behind: %v", entries)
+	}
+}
+
+func TestTreeUploadConflictsAndQuota(t *testing.T) {
+	root := withUploadDir(t)
+	withDedup(t, false)
+	uploadTree(t, "", map[string]string{"conflict": "skip"}, []treeFile{{"a.txt", "new"}})
+	if readUpload(t, root, "a.txt") != "a.txt" {
+		t.Fatal("skip policy overwrote a file")
+	}
+	uploadTree(t, "", map[string]string{"conflict": "rename"}, []treeFile{{"a.txt", "new"}, {"a.txt", "newer"}})
+	if readUpload(t, root, "a (1).txt") != "new" || readUpload(t, root, "a (2).txt") != "newer" {
+		t.Fatal("rename policy")
+	}
+	if rec := uploadTree(t, "", map[string]string{"conflict": "merge"}, []treeFile{{"x", "x"}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown policy: %d", rec.Code)
+	}
+
+	used, _ := diskUsage(root)
+	prev := uploadQuota
+	uploadQuota = used + 5
+	t.Cleanup(func() { uploadQuota = prev })
+	rec := uploadTree(t, "", nil, []treeFile{{"q/small", "1234"}, {"q/big", "123456"}, {"q/tiny", "1"}})
+	if !strings.Contains(rec.Body.String(), errQuota.Error()) {
+		t.Fatalf("quota not reported: %s", rec.Body.String())
+	}
+	if readUpload(t, root, "q/small") != "1234" || readUpload(t, root, "q/tiny") != "1" {
+		t.Fatal("files within quota must be stored")
+	}
+	if _, err := os.Stat(filepath.Join(root, "q", "big")); err == nil {
+		t.Fatal("file over quota stored")
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Пользователи и их домашние папки. С флагом -users (JSON-файл) каждый
+// запрос требует HTTP Basic авторизации, а пользователь видит только
+// uploads/<имя>/ — папка создаётся при первом входе. Для него "" — это его
+// домашняя папка; пути всех обработчиков (список, загрузка, mkdir, удаление,
+// /files, распаковка, массовые операции) проходят через resolvePath и не
+// могут выйти за её пределы, в том числе в папку соседа.
+//
+// Администратор (admin: true) видит весь uploadDir, а переключатель
+// пользователя (POST /view) сужает его вид до папки выбранного пользователя.
+// Квота (-quota) считается для каждой домашней папки отдельно.
+//
+// Без -users авторизации нет и корень — весь uploadDir, как раньше.
+//
+// Файл пользователей пополняется командой
+//
+//	filebox -users users.json -adduser alice [-admin] < пароль
+
+// viewCookie — чью папку смотрит администратор
+const viewCookie = "filebox_view"
+
+// pbkdf2Iterations — стоимость хэша пароля
+const pbkdf2Iterations = 100_000
+
+var errOutsideRoot = msgErr("path.outside")
+
+// userRecord — запись в файле пользователей: хранится только хэш пароля
+type userRecord struct {
+	Salt  string `json:"salt"`
+	Hash  string `json:"hash"`
+	Admin bool   `json:"admin,omitempty"`
+}
+
+// User — пользователь запроса
+type User struct {
+	Name  string
+	Admin bool
+	View  string // Только для администратора: чья папка выбрана ("" — весь uploadDir)
+}
+
+// Root — корень пользователя на диске. nil — авторизация выключена.
+func (u *User) Root() string {
+	switch {
+	case u == nil:
+		return uploadDir
+	case u.Admin && u.View == "":
+		return uploadDir
+	case u.Admin:
+		return filepath.Join(uploadDir, u.View)
+	}
+	return filepath.Join(uploadDir, u.Name)
+}
+
+// userStore — пользователи из файла (-users); nil — авторизация выключена
+type userStore struct {
+	path string
+
+	mu       sync.Mutex
+	records  map[string]userRecord
+	verified map[string][32]byte // Имя → sha256 пароля, уже проверенного PBKDF2
+}
+
+var users *userStore
+
+// validUserName — имя годится в имя папки: латиница, цифры, '-', '_'
+func validUserName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// loadUsers читает файл пользователей; отсутствующий файл — пустой список.
+func loadUsers(path string) (*userStore, error) {
+	s := &userStore{path: path, records: map[string]userRecord{}, verified: map[string][32]byte{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.records); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name := range s.records {
+		if !validUserName(name) {
+			return nil, fmt.Errorf("%s: недопустимое имя пользователя %q", path, name)
+		}
+	}
+	return s, nil
+}
+
+func hashPassword(password string, salt []byte) string {
+	key, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		panic(err) // Только при недопустимых параметрах
+	}
+	return hex.EncodeToString(key)
+}
+
+// Add добавляет (или заменяет) пользователя и сохраняет файл
+func (s *userStore) Add(name, password string, admin bool) error {
+	if !validUserName(name) {
+		return fmt.Errorf("недопустимое имя пользователя %q (a-z, 0-9, -, _)", name)
+	}
+	if password == "" {
+		return errors.New("пустой пароль")
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[name] = userRecord{Salt: hex.EncodeToString(salt), Hash: hashPassword(password, salt), Admin: admin}
+	delete(s.verified, name)
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o600)
+}
+
+// Authenticate проверяет имя и пароль. Медленный PBKDF2 считается только
+// при первом входе: дальше пароль сверяется с запомненным sha256.
+func (s *userStore) Authenticate(name, password string) (*User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[name]
+	if !ok {
+		return nil, false
+	}
+	sum := sha256.Sum256([]byte(password))
+	if known, ok := s.verified[name]; ok {
+		if subtle.ConstantTimeCompare(known[:], sum[:]) != 1 {
+			return nil, false
+		}
+		return &User{Name: name, Admin: rec.Admin}, true
+	}
+	salt, err := hex.DecodeString(rec.Salt)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashPassword(password, salt)), []byte(rec.Hash)) != 1 {
+		return nil, false
+	}
+	s.verified[name] = sum
+	return &User{Name: name, Admin: rec.Admin}, true
+}
+
+// Names — все пользователи по алфавиту (для переключателя администратора)
+func (s *userStore) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.records))
+	for name := range s.records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *userStore) exists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.records[name]
+	return ok
+}
+
+type userKey struct{}
+
+// userFrom — пользователь запроса; nil, если авторизация выключена
+func userFrom(r *http.Request) *User {
+	u, _ := r.Context().Value(userKey{}).(*User)
+	return u
+}
+
+func withUser(r *http.Request, u *User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, u))
+}
+
+// requireAuth — Basic-авторизация всех запросов, если задан файл пользователей.
+// При входе создаётся домашняя папка; администратору подставляется выбранный вид.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if users == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, password, ok := r.BasicAuth()
+		var u *User
+		if ok {
+			u, ok = users.Authenticate(name, password)
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="filebox", charset="UTF-8"`)
+			httpError(w, r, http.StatusUnauthorized, "err.login_required")
+			return
+		}
+		if err := os.MkdirAll(filepath.Join(uploadDir, u.Name), os.ModePerm); err != nil {
+			log.Printf("Ошибка создания домашней папки %s: %v", u.Name, err)
+			httpError(w, r, http.StatusInternalServerError, "err.home_failed")
+			return
+		}
+		if u.Admin {
+			if c, err := r.Cookie(viewCookie); err == nil && users.exists(c.Value) {
+				u.View = c.Value
+			}
+		}
+		next.ServeHTTP(w, withUser(r, u))
+	})
+}
+
+// resolvePath — единственная проверка путей из запроса: requestPath
+// (со слэшами, можно с обратными) относительно корня пользователя. Любой
+// компонент ".." — отказ, даже если после очистки путь остался бы внутри:
+// так "a/../../bob" не превращается ни в чужую, ни в случайную свою папку.
+// Возвращает очищенный путь со слэшами ("" — корень) и полный путь на диске.
+func resolvePath(u *User, requestPath string) (clean, full string, err error) {
+	p := strings.ReplaceAll(requestPath, "\\", "/")
+	if strings.ContainsRune(p, 0) {
+		return "", "", errOutsideRoot
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", "", errOutsideRoot
+		}
+	}
+	clean = strings.TrimPrefix(path.Clean("/"+p), "/")
+	root := u.Root()
+	full = filepath.Join(root, filepath.FromSlash(clean))
+	rel, err := filepath.Rel(root, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", "", errOutsideRoot
+	}
+	return clean, full, nil
+}
+
+// relToRoot — путь относительно корня пользователя со слэшами (для ссылок на странице)
+func relToRoot(u *User, p string) string {
+	rel, err := filepath.Rel(u.Root(), p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+// quotaRoot — папка, чья квота расходуется при записи в dir: домашняя папка
+// владельца; без авторизации — весь uploadDir.
+func quotaRoot(dir string) string {
+	if users == nil {
+		return uploadDir
+	}
+	rel, err := filepath.Rel(uploadDir, dir)
+	if err != nil || rel == "." {
+		return uploadDir
+	}
+	return filepath.Join(uploadDir, strings.SplitN(filepath.ToSlash(rel), "/", 2)[0])
+}
+
+// filesHandler — /files/<путь>: отдача файлов из корня пользователя
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	http.StripPrefix("/files/", http.FileServer(http.Dir(userFrom(r).Root()))).ServeHTTP(w, r)
+}
+
+// viewHandler — POST /view: администратор выбирает, чью папку смотреть
+// (пустое имя — весь uploadDir)
+func viewHandler(w http.ResponseWriter, r *http.Request) {
+	u := userFrom(r)
+	if u == nil || !u.Admin {
+		httpError(w, r, http.StatusForbidden, "err.admin_only")
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "err.method_not_allowed")
+		return
+	}
+	name := r.FormValue("user")
+	if name != "" && !users.exists(name) {
+		httpError(w, r, http.StatusBadRequest, "err.no_such_user")
+		return
+	}
+	c := &http.Cookie{Name: viewCookie, Value: name, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode}
+	if name == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// addUser — режим -adduser: пароль читается первой строкой stdin
+func addUser(path, name string, admin bool, in io.Reader) error {
+	s, err := loadUsers(path)
+	if err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return errors.New("пароль не введён")
+	}
+	return s.Add(name, strings.TrimRight(line, "\r\n"), admin)
+}
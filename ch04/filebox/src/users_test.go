@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withUsers включает авторизацию: alice и bob — обычные, root — администратор.
+// У alice и bob уже есть домашние папки с secret.txt.
+func withUsers(t *testing.T) string {
+	t.Helper()
+	root := withUploadDir(t)
+	s, err := loadUsers(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob", "root"} {
+		if err := s.Add(name, name+"-pw", name == "root"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prev := users
+	users = s
+	t.Cleanup(func() { users = prev })
+	for _, name := range []string{"alice", "bob"} {
+		os.MkdirAll(filepath.Join(root, name), os.ModePerm)
+		os.WriteFile(filepath.Join(root, name, "secret.txt"), []byte(name+" secret"), 0o644)
+	}
+	return root
+}
+
+// as выполняет запрос через requireAuth и маршруты сервера
+func as(t *testing.T, user string, req *http.Request, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	if user != "" {
+		req.SetBasicAuth(user, user+"-pw")
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	requireAuth(newMux()).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestResolvePathContainment(t *testing.T) {
+	root := withUsers(t)
+	alice := &User{Name: "alice"}
+	home := filepath.Join(root, "alice")
+	for _, c := range []struct {
+		in, clean string
+	}{
+		{"", ""},
+		{"/", ""},
+		{".", ""},
+		{"./docs/", "docs"},
+		{"docs//a.txt", "docs/a.txt"},
+		{"docs\\a.txt", "docs/a.txt"},
+		{"a..b", "a..b"},
+		{"...", "..."},
+		{"%2e%2e/bob", "%2e%2e/bob"}, // Уже раскодированный путь: это просто имя
+		{"..%2fbob", "..%2fbob"},     // То же
+		{"%252e%252e/bob", "%252e%252e/bob"},
+		{"bob/secret.txt", "bob/secret.txt"}, // Папка bob внутри домашней alice
+	} {
+		clean, full, err := resolvePath(alice, c.in)
+		if err != nil || clean != c.clean || full != filepath.Join(home, filepath.FromSlash(c.clean)) {
+			t.Errorf("resolvePath(%q) = %q, %q, %v; want %q inside %s", c.in, clean, full, err, c.clean, home)
+		}
+	}
+	for _, in := range []string{
+		"..",
+		"../bob",
+		"../bob/secret.txt",
+		"/../bob",
+		"docs/../../bob",
+		"docs/../..",
+		"docs/..", // Внутри, но ".." всё равно запрещён
+		"..\\bob\\secret.txt",
+		"docs\\..\\..\\bob",
+		"./../bob",
+		"bob/../../bob",
+		"a\x00b",
+	} {
+		if clean, full, err := resolvePath(alice, in); err == nil {
+			t.Errorf("resolvePath(%q) = %q, %q; want an error", in, clean, full)
+		}
+	}
+
+	// Корни: без авторизации и у администратора — весь uploadDir
+	for _, c := range []struct {
+		u    *User
+		want string
+	}{
+		{nil, root},
+		{&User{Name: "root", Admin: true}, root},
+		{&User{Name: "root", Admin: true, View: "bob"}, filepath.Join(root, "bob")},
+		{&User{Name: "bob", View: "alice"}, filepath.Join(root, "bob")}, // View только у админа
+	} {
+		if _, full, _ := resolvePath(c.u, ""); full != c.want {
+			t.Errorf("root of %+v = %s, want %s", c.u, full, c.want)
+		}
+	}
+}
+
+func TestUserCannotReachSiblingOverHTTP(t *testing.T) {
+	root := withUsers(t)
+	bobSecret := filepath.Join(root, "bob", "secret.txt")
+
+	// Закодированные ".." в URL: net/http раскодирует их в "..", дальше
+	// resolvePath (или очистка пути в ServeMux) не пускает к bob
+	for _, target := range []string{
+		"/files/..%2fbob%2fsecret.txt",
+		"/files/%2e%2e/bob/secret.txt",
+		"/files/%2e%2e%2fbob%2fsecret.txt",
+		"/%2e%2e/bob",
+		"/..%2fbob",
+		"/..%5cbob",
+	} {
+		rec := as(t, "alice", httptest.NewRequest(http.MethodGet, target, nil))
+		if strings.Contains(rec.Body.String(), "bob secret") || rec.Code == http.StatusOK && strings.Contains(rec.Body.String(), "secret.txt") {
+			t.Errorf("GET %s as alice reached bob's files: %d %s", target, rec.Code, rec.Body.String())
+		}
+	}
+	// Удаление: секрет bob должен пережить все попытки (проверка ниже)
+	for _, target := range []string{"/delete/..%2fbob%2fsecret.txt", "/delete/%2e%2e/bob/secret.txt"} {
+		as(t, "alice", httptest.NewRequest(http.MethodPost, target, nil))
+	}
+	// Мимо ServeMux (без его редиректов) — сам обработчик
+	req := withUser(httptest.NewRequest(http.MethodPost, "/delete/x", nil), &User{Name: "alice"})
+	req.URL.Path = "/delete/../bob/secret.txt"
+	rec := httptest.NewRecorder()
+	deleteHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("delete ../bob: %d", rec.Code)
+	}
+	if _, err := os.Stat(bobSecret); err != nil {
+		t.Fatalf("bob's file was deleted: %v", err)
+	}
+
+	// Массовые операции
+	body, _ := json.Marshal(BulkRequest{Action: "delete", Paths: []string{"../bob/secret.txt", "..\\bob", "secret.txt"}})
+	rec = as(t, "alice", httptest.NewRequest(http.MethodPost, "/bulk", bytes.NewReader(body)))
+	var resp BulkResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Results) != 3 || resp.Results[0].Status != "error" || resp.Results[1].Status != "error" || resp.Results[2].Status != "ok" {
+		t.Fatalf("bulk delete: %+v", resp.Results)
+	}
+	if _, err := os.Stat(bobSecret); err != nil {
+		t.Fatalf("bob's file was deleted: %v", err)
+	}
+	body, _ = json.Marshal(BulkRequest{Action: "move", Dest: "../bob", Paths: []string{"secret.txt"}})
+	if rec := as(t, "alice", httptest.NewRequest(http.MethodPost, "/bulk", bytes.NewReader(body))); rec.Code != http.StatusBadRequest {
+		t.Fatalf("move into bob: %d", rec.Code)
+	}
+
+	// mkdir и загрузка с dir, указывающим к соседу
+	form := strings.NewReader("dir=../bob&name=x")
+	req = httptest.NewRequest(http.MethodPost, "/mkdir", form)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rec := as(t, "alice", req); rec.Code != http.StatusBadRequest {
+		t.Fatalf("mkdir in bob: %d", rec.Code)
+	}
+	if rec := as(t, "alice", uploadRequest(t, "../bob", "evil.txt", "evil")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("upload into bob: %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(root, "bob", "x")); err == nil {
+		t.Fatal("alice created a folder in bob's home")
+	}
+}
+
+// uploadRequest — multipart-запрос /upload одного файла
+func uploadRequest(t *testing.T, dir, name, content string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("dir", dir)
+	fw, _ := mw.CreateFormFile("file", name)
+	fw.Write([]byte(content))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestHomeDirectoryIsUserRoot(t *testing.T) {
+	root := withUsers(t)
+	withDedup(t, false)
+	if rec := as(t, "", httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("no credentials: %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("alice", "bob-pw")
+	if rec := as(t, "", req); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: %d", rec.Code)
+	}
+
+	// Первый вход создаёт домашнюю папку; "" — это она
+	os.RemoveAll(filepath.Join(root, "alice"))
+	rec := as(t, "alice", httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "docs") {
+		t.Fatalf("alice's empty home: %d", rec.Code)
+	}
+	if st, err := os.Stat(filepath.Join(root, "alice")); err != nil || !st.IsDir() {
+		t.Fatal("home directory not created on login")
+	}
+
+	if rec := as(t, "alice", uploadRequest(t, "", "note.txt", "hi")); rec.Code != http.StatusSeeOther {
+		t.Fatalf("upload: %d", rec.Code)
+	}
+	if readUpload(t, root, "alice/note.txt") != "hi" {
+		t.Fatal("upload must land in alice's home")
+	}
+	if rec := as(t, "alice", httptest.NewRequest(http.MethodGet, "/files/note.txt", nil)); rec.Body.String() != "hi" {
+		t.Fatalf("/files/note.txt: %d %q", rec.Code, rec.Body.String())
+	}
+
+	// Архив — пути относительно домашней папки
+	body, _ := json.Marshal(BulkRequest{Action: "zip", Paths: []string{"note.txt"}})
+	rec = as(t, "alice", httptest.NewRequest(http.MethodPost, "/bulk", bytes.NewReader(body)))
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil || len(zr.File) != 1 || zr.File[0].Name != "note.txt" {
+		t.Fatalf("zip: %v", err)
+	}
+	if rec := as(t, "alice", httptest.NewRequest(http.MethodGet, "/stats", nil)); rec.Code != http.StatusForbidden {
+		t.Fatalf("stats for a regular user: %d", rec.Code)
+	}
+}
+
+func TestAdminSwitchesUserView(t *testing.T) {
+	withUsers(t)
+	rec := as(t, "root", httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, want := range []string{"alice", "bob", "docs", `value="bob"`} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("admin root listing lacks %q", want)
+		}
+	}
+	if rec := as(t, "alice", httptest.NewRequest(http.MethodPost, "/view?user=bob", nil)); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin switch: %d", rec.Code)
+	}
+	if rec := as(t, "root", httptest.NewRequest(http.MethodPost, "/view?user=mallory", nil)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown user: %d", rec.Code)
+	}
+
+	rec = as(t, "root", httptest.NewRequest(http.MethodPost, "/view?user=bob", nil))
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusSeeOther || len(cookies) != 1 {
+		t.Fatalf("switch: %d %v", rec.Code, cookies)
+	}
+	rec = as(t, "root", httptest.NewRequest(http.MethodGet, "/files/secret.txt", nil), cookies[0])
+	if rec.Body.String() != "bob secret" {
+		t.Fatalf("admin viewing bob: %q", rec.Body.String())
+	}
+	// Чужая cookie вида у обычного пользователя ничего не меняет
+	rec = as(t, "alice", httptest.NewRequest(http.MethodGet, "/files/secret.txt", nil), cookies[0])
+	if rec.Body.String() != "alice secret" {
+		t.Fatalf("view cookie leaked to alice: %q", rec.Body.String())
+	}
+}
+
+func TestQuotaIsPerUser(t *testing.T) {
+	root := withUsers(t)
+	withDedup(t, false)
+	used, _ := diskUsage(filepath.Join(root, "alice"))
+	prev := uploadQuota
+	uploadQuota = used + 10
+	t.Cleanup(func() { uploadQuota = prev })
+
+	// Весь uploadDir уже больше квоты — это не мешает, считается только своя папка
+	if total, _ := diskUsage(root); total <= uploadQuota {
+		t.Fatalf("setup: uploads %d, quota %d", total, uploadQuota)
+	}
+	if rec := as(t, "alice", uploadRequest(t, "", "fits.txt", "0123456789")); rec.Code != http.StatusSeeOther {
+		t.Fatalf("within quota: %d", rec.Code)
+	}
+	rec := as(t, "alice", uploadRequest(t, "", "over.txt", "x"))
+	if !strings.Contains(rec.Body.String(), errQuota.Error()) {
+		t.Fatalf("alice over quota: %d", rec.Code)
+	}
+	if rec := as(t, "bob", uploadRequest(t, "", "fits.txt", "0123456789")); rec.Code != http.StatusSeeOther {
+		t.Fatalf("bob has his own quota: %d", rec.Code)
+	}
+	data, _ := io.ReadAll(as(t, "bob", httptest.NewRequest(http.MethodGet, "/files/fits.txt", nil)).Body)
+	if string(data) != "0123456789" {
+		t.Fatalf("bob's upload: %q", data)
+	}
+}
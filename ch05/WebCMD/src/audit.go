@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Журнал аудита: каждая команда (системная, встроенная, отклонённая) —
+// одна JSON-строка в файле, который только дописывается. Записи связаны
+// цепочкой HMAC: в каждой лежит HMAC предыдущей, а её собственный HMAC
+// считается по всем полям. Правка или удаление записи рвут цепочку;
+// обрезку хвоста выдаёт файл <audit>.head с номером и HMAC последней записи.
+
+// Типы записей
+const (
+	AuditExec    = "exec"    // системная команда через оболочку
+	AuditBuiltin = "builtin" // cd, pushd, :shell и т.п.
+	AuditDenied  = "denied"  // отклонённая попытка
+)
+
+const auditKeyEnv = "WEBCMD_AUDIT_KEY"
+
+// AuditRecord — одна запись журнала
+type AuditRecord struct {
+	Seq         int64     `json:"seq"`
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Session     string    `json:"session"`
+	User        string    `json:"user,omitempty"` // появится вместе с авторизацией
+	Cwd         string    `json:"cwd"`
+	Command     string    `json:"command"`
+	ExitCode    int       `json:"exit_code"`
+	DurationMS  int64     `json:"duration_ms"`
+	OutputBytes int64     `json:"output_bytes"`
+	Reason      string    `json:"reason,omitempty"` // почему отклонена
+	Prev        string    `json:"prev"`
+	HMAC        string    `json:"hmac"`
+}
+
+// auditHead — содержимое файла .head
+type auditHead struct {
+	Seq  int64  `json:"seq"`
+	HMAC string `json:"hmac"`
+	MAC  string `json:"mac"` // подпись самой головы
+}
+
+// AuditLog дописывает записи в файл и поддерживает цепочку
+type AuditLog struct {
+	path string
+	key  []byte
+	now  func() time.Time
+
+	mu   sync.Mutex
+	f    *os.File
+	seq  int64
+	last string
+}
+
+// recordMAC — HMAC записи без поля HMAC
+func recordMAC(key []byte, rec AuditRecord) string {
+	rec.HMAC = ""
+	data, _ := json.Marshal(rec)
+	m := hmac.New(sha256.New, key)
+	m.Write(data)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func headMAC(key []byte, h auditHead) string {
+	m := hmac.New(sha256.New, key)
+	fmt.Fprintf(m, "head:%d:%s", h.Seq, h.HMAC)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// loadAuditKey берёт ключ из WEBCMD_AUDIT_KEY или из файла keyPath;
+// если файла нет — создаёт случайный ключ (только для владельца).
+func loadAuditKey(keyPath string) ([]byte, error) {
+	if k := os.Getenv(auditKeyEnv); k != "" {
+		return []byte(k), nil
+	}
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		return []byte(strings.TrimSpace(string(data))), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	key := hex.EncodeToString(b)
+	if err := os.WriteFile(keyPath, []byte(key+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return []byte(key), nil
+}
+
+// OpenAuditLog открывает (или создаёт) журнал и продолжает цепочку с
+// последней записи. Сломанный журнал не открывается — сначала --verify-audit.
+func OpenAuditLog(path string, key []byte) (*AuditLog, error) {
+	n, last, err := verifyAuditFile(path, key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{path: path, key: key, now: time.Now, f: f, seq: n, last: last}, nil
+}
+
+// Append дописывает запись, проставляя номер, время, Prev и HMAC.
+// На nil-журнале ничего не делает (аудит выключен).
+func (a *AuditLog) Append(rec AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rec.Seq = a.seq + 1
+	if rec.Time.IsZero() {
+		rec.Time = a.now().UTC()
+	}
+	rec.Prev = a.last
+	rec.HMAC = recordMAC(a.key, rec)
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	a.seq, a.last = rec.Seq, rec.HMAC
+	return a.writeHead()
+}
+
+func (a *AuditLog) writeHead() error {
+	h := auditHead{Seq: a.seq, HMAC: a.last}
+	h.MAC = headMAC(a.key, h)
+	data, _ := json.Marshal(h)
+	tmp := a.path + ".head.tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path+".head")
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+// Page возвращает до limit записей с номерами больше after
+func (a *AuditLog) Page(after int64, limit int) ([]AuditRecord, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := []AuditRecord{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() && len(out) < limit {
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		if rec.Seq > after {
+			out = append(out, rec)
+		}
+	}
+	return out, sc.Err()
+}
+
+// VerifyAudit проверяет цепочку записей из r: номера идут подряд с 1,
+// Prev совпадает с HMAC предыдущей, HMAC каждой записи верен. Возвращает
+// число записей и HMAC последней.
+func VerifyAudit(r io.Reader, key []byte) (int64, string, error) {
+	var n int64
+	last := ""
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := n + 1
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return n, last, fmt.Errorf("audit line %d: %w", line, err)
+		}
+		switch {
+		case rec.Seq != line:
+			return n, last, fmt.Errorf("audit line %d: seq %d, records missing or reordered", line, rec.Seq)
+		case rec.Prev != last:
+			return n, last, fmt.Errorf("audit record %d: chain broken (prev does not match)", rec.Seq)
+		case !hmac.Equal([]byte(rec.HMAC), []byte(recordMAC(key, rec))):
+			return n, last, fmt.Errorf("audit record %d: HMAC mismatch, record modified", rec.Seq)
+		}
+		n, last = rec.Seq, rec.HMAC
+	}
+	return n, last, sc.Err()
+}
+
+// verifyAuditFile проверяет журнал и сверяет его конец с файлом .head
+func verifyAuditFile(path string, key []byte) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	n, last, err := VerifyAudit(f, key)
+	if err != nil {
+		return n, last, err
+	}
+
+	data, err := os.ReadFile(path + ".head")
+	if errors.Is(err, os.ErrNotExist) {
+		if n > 0 {
+			return n, last, errors.New("audit head file missing")
+		}
+		return n, last, nil
+	}
+	if err != nil {
+		return n, last, err
+	}
+	var h auditHead
+	if err := json.Unmarshal(data, &h); err != nil || !hmac.Equal([]byte(h.MAC), []byte(headMAC(key, h))) {
+		return n, last, errors.New("audit head file is corrupt or forged")
+	}
+	if h.Seq != n || h.HMAC != last {
+		return n, last, fmt.Errorf("audit log ends at record %d, head says %d: log truncated", n, h.Seq)
+	}
+	return n, last, nil
+}
+
+// auditRecord — запись о команде сессии; время и длительность — от start
+func auditRecord(typ string, sess *Session, cwd, command string, start time.Time) AuditRecord {
+	rec := AuditRecord{
+		Time:       start.UTC(),
+		Type:       typ,
+		Cwd:        cwd,
+		Command:    command,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if sess != nil {
+		rec.Session = sess.id
+	}
+	return rec
+}
+
+// logAudit пишет запись в глобальный журнал; ошибка записи только логируется,
+// команда уже выполнена.
+func logAudit(rec AuditRecord) {
+	if err := audit.Append(rec); err != nil {
+		log.Println("audit:", err)
+	}
+}
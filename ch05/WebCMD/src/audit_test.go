@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAuditKey = []byte("test-key")
+
+// writeAudit создаёт журнал из n записей и возвращает путь
+func writeAudit(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		typ := AuditExec
+		if i%2 == 1 {
+			typ = AuditBuiltin
+		}
+		rec := AuditRecord{Time: start.Add(time.Duration(i) * time.Second), Type: typ, Session: "s1", Cwd: `C:\work`, Command: "echo " + string(rune('a'+i))}
+		if err := a.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
+
+func writeLines(t *testing.T, path string, lines []string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditChainVerifies(t *testing.T) {
+	path := writeAudit(t, 3)
+	n, _, err := verifyAuditFile(path, testAuditKey)
+	if err != nil || n != 3 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	// Журнал продолжается после переоткрытия
+	a, err := OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Append(AuditRecord{Type: AuditDenied, Command: ":shell zsh", Reason: "unknown shell"}); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	if n, _, err := verifyAuditFile(path, testAuditKey); err != nil || n != 4 {
+		t.Fatalf("after reopen: n=%d err=%v", n, err)
+	}
+	if _, _, err := verifyAuditFile(path, []byte("other-key")); err == nil {
+		t.Fatal("wrong key must fail verification")
+	}
+}
+
+func TestAuditDetectsModifiedMiddleRecord(t *testing.T) {
+	path := writeAudit(t, 3)
+	lines := readLines(t, path)
+	lines[1] = strings.Replace(lines[1], `"echo b"`, `"echo x"`, 1)
+	writeLines(t, path, lines)
+
+	_, _, err := verifyAuditFile(path, testAuditKey)
+	if err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Fatalf("modified record 2 not detected: %v", err)
+	}
+	if _, err := OpenAuditLog(path, testAuditKey); err == nil {
+		t.Fatal("a broken log must not be reopened for appending")
+	}
+}
+
+func TestAuditDetectsRemovedAndTruncatedRecords(t *testing.T) {
+	path := writeAudit(t, 3)
+	lines := readLines(t, path)
+
+	writeLines(t, path, []string{lines[0], lines[2]})
+	if _, _, err := verifyAuditFile(path, testAuditKey); err == nil {
+		t.Fatal("removed middle record not detected")
+	}
+
+	writeLines(t, path, lines[:2])
+	if _, _, err := verifyAuditFile(path, testAuditKey); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("truncated tail not detected: %v", err)
+	}
+}
+
+func TestAuditPage(t *testing.T) {
+	path := writeAudit(t, 5)
+	a, err := OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	page, err := a.Page(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].Seq != 3 || page[1].Seq != 4 || page[1].Type != AuditBuiltin {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+}
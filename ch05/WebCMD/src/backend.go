@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Backend — оболочка, через которую выполняются системные команды сессии.
+type Backend string
+
+const (
+	BackendCmd        Backend = "cmd"
+	BackendPowerShell Backend = "powershell"
+	BackendPwsh       Backend = "pwsh"
+)
+
+// installedBackends заполняется при старте: только найденные в PATH оболочки
+var installedBackends = map[Backend]bool{}
+
+// detectBackends ищет оболочки в PATH и запоминает доступные
+func detectBackends() {
+	for _, b := range []Backend{BackendCmd, BackendPowerShell, BackendPwsh} {
+		if _, err := exec.LookPath(string(b)); err == nil {
+			installedBackends[b] = true
+		}
+	}
+}
+
+// availableBackends — отсортированный список установленных оболочек
+func availableBackends() []string {
+	names := make([]string, 0, len(installedBackends))
+	for b := range installedBackends {
+		names = append(names, string(b))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseBackend распознаёт имя оболочки из команды `:shell <name>`
+func parseBackend(name string) (Backend, bool) {
+	switch b := Backend(strings.ToLower(strings.TrimSpace(name))); b {
+	case BackendCmd, BackendPowerShell, BackendPwsh:
+		return b, true
+	}
+	return "", false
+}
+
+// backendCommand оборачивает введённую строку в вызов выбранной оболочки
+func backendCommand(b Backend, command string) *exec.Cmd {
+	switch b {
+	case BackendPowerShell, BackendPwsh:
+		return exec.Command(string(b), "-NoProfile", "-NonInteractive", "-Command", psScript(command))
+	default:
+		// cmd.exe: переключаем кодовую страницу на UTF-8
+		return exec.Command("cmd", "/c", "chcp 65001 >nul && "+command)
+	}
+}
+
+// psScript — скрипт для -Command: вывод в UTF-8, а сама команда передаётся
+// строковым литералом в Invoke-Expression, чтобы $ и кавычки не раскрывались
+// раньше времени.
+func psScript(command string) string {
+	return "$OutputEncoding = [Console]::OutputEncoding = [System.Text.Encoding]::UTF8; " +
+		"Invoke-Expression " + psQuote(command) + "; " +
+		"if ($LASTEXITCODE) { exit $LASTEXITCODE }"
+}
+
+// psQuote — одинарные кавычки PowerShell: внутри ничего не подставляется,
+// а сама кавычка удваивается. PowerShell считает кавычками и типографские ‘’‚‛.
+func psQuote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '‘', '’', '‚', '‛':
+			sb.WriteRune(r)
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
+
+// promptSuffix — метка активной оболочки в приглашении (для cmd — пусто)
+func promptSuffix(b Backend) string {
+	if b == "" || b == BackendCmd {
+		return ""
+	}
+	return " [" + string(b) + "]"
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPSQuote(t *testing.T) {
+	cases := map[string]string{
+		`echo hi`:                 `'echo hi'`,
+		`echo "a b"`:              `'echo "a b"'`,
+		`Write-Host $env:PATH`:    `'Write-Host $env:PATH'`,
+		`echo 'it''s'`:            `'echo ''it''''s'''`,
+		`Get-Item "C:\$Recycle"`:  `'Get-Item "C:\$Recycle"'`,
+		"echo \u2019smart\u2019":  "'echo \u2019\u2019smart\u2019\u2019'",
+		`$x = "$($y)"; echo $x`:   `'$x = "$($y)"; echo $x'`,
+		`echo 'a' "b" $c`:         `'echo ''a'' "b" $c'`,
+		``:                        `''`,
+		`dir | Select -First 1`:   `'dir | Select -First 1'`,
+		`cmd /c "echo %PATH%"`:    `'cmd /c "echo %PATH%"'`,
+		"multi\nline":             "'multi\nline'",
+		`a''b`:                    `'a''''b'`,
+		`'`:                       `''''`,
+		`"`:                       `'"'`,
+		`$`:                       `'$'`,
+		"`$notExpanded":           "'`$notExpanded'",
+		`@("x")`:                  `'@("x")'`,
+		`&{ "inner" }`:            `'&{ "inner" }'`,
+		`Write-Output '$literal'`: `'Write-Output ''$literal'''`,
+	}
+	for in, want := range cases {
+		if got := psQuote(in); got != want {
+			t.Errorf("psQuote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBackendCommand(t *testing.T) {
+	cmd := backendCommand(BackendPwsh, `echo "$HOME"`)
+	if cmd.Args[0] != "pwsh" || cmd.Args[1] != "-NoProfile" || cmd.Args[3] != "-Command" {
+		t.Fatalf("unexpected pwsh args: %q", cmd.Args)
+	}
+	script := cmd.Args[4]
+	if strings.Contains(script, "chcp") {
+		t.Fatalf("powershell must not get the chcp prefix: %q", script)
+	}
+	if !strings.HasPrefix(script, "$OutputEncoding = ") || !strings.Contains(script, `Invoke-Expression 'echo "$HOME"'`) {
+		t.Fatalf("unexpected script: %q", script)
+	}
+
+	cmd = backendCommand(BackendCmd, "dir")
+	if strings.Join(cmd.Args[1:], " ") != "/c chcp 65001 >nul && dir" {
+		t.Fatalf("unexpected cmd args: %q", cmd.Args)
+	}
+}
+
+func TestPromptSuffix(t *testing.T) {
+	if got := getPrompt(`C:/work`, BackendCmd); got != `C:\work> ` {
+		t.Fatalf("cmd prompt = %q", got)
+	}
+	if got := getPrompt(`C:/work`, BackendPowerShell); got != `C:\work [powershell]> ` {
+		t.Fatalf("powershell prompt = %q", got)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Настройки новой сессии: ~/.webcmd/init.json
+//
+//	{
+//	  "motd": "Добро пожаловать!",
+//	  "commands": ["cd C:\\Work", "git status"]
+//	}
+//
+// motd выводится после приветствия, затем команды выполняются по очереди
+// через обычный Run — как если бы их ввёл пользователь (с выводом и
+// записью в журнал аудита). Ошибка одной команды не прерывает остальные.
+// В ограниченном режиме (-allow) команды не из списка разрешённых
+// пропускаются.
+//
+// Файл читается при старте сервера; `:init reload` перечитывает его для
+// следующих сессий, `:init edit` показывает путь и текущее содержимое.
+
+// InitConfig — содержимое init.json
+type InitConfig struct {
+	MOTD     string   `json:"motd,omitempty"`
+	Commands []string `json:"commands,omitempty"`
+}
+
+var (
+	initMu   sync.Mutex
+	initPath = defaultInitPath()
+	initCfg  = &InitConfig{}
+)
+
+// defaultInitPath — ~/.webcmd/init.json (пусто, если домашняя папка неизвестна)
+func defaultInitPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".webcmd", "init.json")
+}
+
+// loadInitConfig читает файл; отсутствующий файл — пустые настройки.
+func loadInitConfig(path string) (*InitConfig, error) {
+	cfg := &InitConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// reloadInitConfig перечитывает initPath; при ошибке прежние настройки остаются.
+func reloadInitConfig() (*InitConfig, error) {
+	initMu.Lock()
+	defer initMu.Unlock()
+	cfg, err := loadInitConfig(initPath)
+	if err != nil {
+		return nil, err
+	}
+	initCfg = cfg
+	return cfg, nil
+}
+
+func currentInitConfig() *InitConfig {
+	initMu.Lock()
+	defer initMu.Unlock()
+	return initCfg
+}
+
+// runStartup выводит motd и первое приглашение, затем выполняет стартовые
+// команды через run (в сервере — shell.Run). Каждая команда отображается
+// после приглашения, как введённая вручную.
+func runStartup(sess *Session, cfg *InitConfig, run func(command string, sess *Session) error) {
+	if cfg.MOTD != "" {
+		_ = sess.write([]byte(strings.ReplaceAll(cfg.MOTD, "\n", "\r\n") + "\r\n"))
+	}
+	_ = sess.write([]byte(sess.promptLine(getCurrentDir())))
+	for _, command := range cfg.Commands {
+		command = strings.TrimSpace(command)
+		if command == "" {
+			continue
+		}
+		_ = sess.write([]byte(command + "\r\n"))
+		if !commandAllowed(command) {
+			_ = sess.write([]byte("Стартовая команда пропущена: не входит в список разрешённых\r\n"))
+			sendPrompt(sess)
+			continue
+		}
+		if err := run(command, sess); err != nil {
+			_ = sess.write([]byte("Стартовая команда не выполнена: " + err.Error() + "\r\n"))
+			sendPrompt(sess)
+		}
+	}
+}
+
+// handleInitCommand — `:init edit` показывает настройки, `:init reload` перечитывает их
+func handleInitCommand(command string, sess *Session) {
+	switch arg := strings.TrimSpace(command[len(":init"):]); arg {
+	case "edit":
+		data, _ := json.MarshalIndent(currentInitConfig(), "", "  ")
+		text := "Файл: " + initPath + "\r\n" + strings.ReplaceAll(string(data), "\n", "\r\n") + "\r\n"
+		_ = sess.write([]byte(text))
+	case "reload":
+		cfg, err := reloadInitConfig()
+		if err != nil {
+			_ = sess.write([]byte("Ошибка init.json: " + err.Error() + "\r\n"))
+			return
+		}
+		_ = sess.write([]byte(fmt.Sprintf("init.json перечитан: %d команд, применится в новой сессии\r\n", len(cfg.Commands))))
+	default:
+		_ = sess.write([]byte("Использование: :init edit | :init reload\r\n"))
+	}
+}
+
+// ---------- ограниченный режим ----------
+
+// allowList — разрешённые системные команды (флаг -allow); пустой — без ограничений.
+// Встроенные команды (cd, :shell, ...) разрешены всегда.
+var allowList map[string]bool
+
+// parseAllowList разбирает "git,dir,echo"
+func parseAllowList(s string) map[string]bool {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	list := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			list[name] = true
+		}
+	}
+	return list
+}
+
+// commandAllowed — можно ли выполнить команду: первое слово (без .exe)
+// должно быть в списке; встроенные команды проходят всегда.
+func commandAllowed(command string) bool {
+	if allowList == nil || isBuiltin(command) {
+		return true
+	}
+	fields := strings.Fields(strings.ToLower(command))
+	if len(fields) == 0 {
+		return true
+	}
+	return allowList[strings.TrimSuffix(fields[0], ".exe")]
+}
+
+// isBuiltin — команда обрабатывается самим сервером, а не оболочкой
+func isBuiltin(command string) bool {
+	lower := strings.ToLower(strings.TrimSpace(command))
+	if isCDCommand(lower) || strings.HasPrefix(lower, "pushd ") || lower == "popd" {
+		return true
+	}
+	for _, name := range []string{":shell", ":prompt", ":init"} {
+		if lower == name || strings.HasPrefix(lower, name+" ") {
+			return true
+		}
+	}
+	return false
+}
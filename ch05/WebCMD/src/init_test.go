@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunStartupOrderAndFailures(t *testing.T) {
+	sess := &Session{backend: BackendCmd}
+	cfg := &InitConfig{MOTD: "hi", Commands: []string{"echo one", "  ", "bad", "cd sub", "echo two"}}
+	var ran []string
+	runStartup(sess, cfg, func(command string, _ *Session) error {
+		ran = append(ran, command)
+		if command == "bad" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	if got := strings.Join(ran, "|"); got != "echo one|bad|cd sub|echo two" {
+		t.Fatalf("startup commands ran as %q", got)
+	}
+}
+
+func TestRunStartupRestrictedMode(t *testing.T) {
+	allowList = parseAllowList("git, ECHO")
+	t.Cleanup(func() { allowList = nil })
+
+	sess := &Session{backend: BackendCmd}
+	cfg := &InitConfig{Commands: []string{"git status", "del *.*", "echo.exe ok", "cd C:\\Work", ":prompt {cwd}>", "format c:"}}
+	var ran []string
+	runStartup(sess, cfg, func(command string, _ *Session) error {
+		ran = append(ran, command)
+		return nil
+	})
+	if got := strings.Join(ran, "|"); got != "git status|echo.exe ok|cd C:\\Work|:prompt {cwd}>" {
+		t.Fatalf("restricted startup ran %q", got)
+	}
+}
+
+func TestInitReload(t *testing.T) {
+	prevPath, prevCfg := initPath, currentInitConfig()
+	t.Cleanup(func() { initPath, initCfg = prevPath, prevCfg })
+	initPath = filepath.Join(t.TempDir(), "init.json")
+
+	// Нет файла — пустые настройки
+	if cfg, err := reloadInitConfig(); err != nil || cfg.MOTD != "" || len(cfg.Commands) != 0 {
+		t.Fatalf("missing file: %+v %v", cfg, err)
+	}
+
+	os.WriteFile(initPath, []byte(`{"motd":"v1","commands":["dir"]}`), 0o644)
+	reloadInitConfig()
+	os.WriteFile(initPath, []byte(`{"motd":"v2","commands":["dir","git status"]}`), 0o644)
+	if got := currentInitConfig(); got.MOTD != "v1" {
+		t.Fatalf("config must not change until reload, got %+v", got)
+	}
+	handleInitCommand(":init reload", &Session{})
+	if got := currentInitConfig(); got.MOTD != "v2" || len(got.Commands) != 2 {
+		t.Fatalf("after reload: %+v", got)
+	}
+
+	// Битый файл не затирает рабочие настройки
+	os.WriteFile(initPath, []byte(`{"motd":`), 0o644)
+	if _, err := reloadInitConfig(); err == nil {
+		t.Fatal("broken init.json should be an error")
+	}
+	if got := currentInitConfig(); got.MOTD != "v2" {
+		t.Fatalf("broken reload replaced config: %+v", got)
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ---------- ссылки на файлы в выводе ----------
+
+// Пути к существующим файлам и папкам в выводе команд (ошибки сборки,
+// листинги dir) превращаются в гиперссылки OSC 8: файл ведёт на /dl
+// (скачать), папка — на /api/ls (панель файлов в консоли). В ссылке —
+// токен сессии; без живого токена эндпоинты отвечают 403.
+//
+// Распознавание осторожное:
+//   - путь начинается с начала слова, поэтому флаги вроде -o/tmp не
+//     трогаются; URL (://) и ключи вида dir /s пропускаются;
+//   - относительный путь считается от папки команды и должен содержать
+//     разделитель или точку;
+//   - путь обязательно существует. В пути C:\... могут быть пробелы —
+//     берётся самый длинный существующий вариант. Путь в кавычках берётся
+//     целиком;
+//   - суффикс :строка:столбец (ошибки компилятора) в ссылку не входит.
+//
+// Проверки существования кэшируются (LRU на statCacheSize путей) и
+// ограничены maxStatsPerChunk на кусок вывода.
+//
+// Вывод приходит кусками по 4 КБ. Последнее слово недописанной строки
+// (а с ним и недописанный UTF-8-символ), незакрытая кавычка и путь C:\...
+// придерживаются до следующего куска или конца вывода — ссылка не зависит
+// от того, где разрезан вывод. Выключается флагом -links=false.
+
+const (
+	osc8Start = "\x1b]8;;"
+	osc8End   = "\x1b\\"
+
+	maxLinkCarry     = 1024 // придерживается не больше, остальное уходит как есть
+	maxStatsPerChunk = 64
+	maxSpaceJoins    = 8 // сколько пробелов может быть в пути C:\...
+	statCacheSize    = 512
+	statCacheTTL     = 10 * time.Second
+)
+
+// linkPaths — флаг -links
+var linkPaths = true
+
+// PathLinker — обработка вывода одного потока команды (stdout или stderr)
+type PathLinker struct {
+	dir    string // папка команды, от неё считаются относительные пути
+	token  string // токен сессии для ссылок
+	stat   func(path string) (isDir, ok bool)
+	carry  []byte // придержанный конец предыдущего куска
+	budget int    // сколько проверок существования осталось на кусок
+}
+
+// newPathLinker — обработчик для команды в папке dir; nil, если ссылки
+// выключены (методы nil-обработчика пропускают вывод как есть)
+func newPathLinker(dir, token string) *PathLinker {
+	if !linkPaths {
+		return nil
+	}
+	return &PathLinker{dir: dir, token: token, stat: pathStats.Stat}
+}
+
+// Write — кусок вывода со ссылками; его конец может остаться до
+// следующего Write или Flush
+func (l *PathLinker) Write(p []byte) []byte {
+	if l == nil {
+		return p
+	}
+	data := append(l.carry, p...)
+	l.carry = nil
+	cut := bytes.LastIndexAny(data, "\r\n") + 1
+	if hold := cut + holdFrom(data[cut:]); len(data)-hold <= maxLinkCarry {
+		cut = hold
+	} else {
+		cut = len(data) - incompleteRune(data)
+	}
+	l.carry = bytes.Clone(data[cut:])
+	l.budget = maxStatsPerChunk
+	return []byte(l.linkText(string(data[:cut])))
+}
+
+// holdFrom — с какого места недописанной строки tail придержать вывод:
+// последнее слово (может оказаться путём или недописанным символом),
+// незакрытая кавычка и путь C:\..., который может продолжиться через пробел
+func holdFrom(tail []byte) int {
+	from := bytes.LastIndexAny(tail, " \t") + 1
+	if q := bytes.LastIndexByte(tail, '"'); q >= 0 && bytes.Count(tail, []byte{'"'})%2 == 1 {
+		from = min(from, q)
+	}
+	s := string(tail)
+	for i := 0; i < from; i++ {
+		if isWordStart(s, i) && isDrivePath(s[i:min(i+3, len(s))]) {
+			return i
+		}
+	}
+	return from
+}
+
+// Flush — всё придержанное (конец вывода команды)
+func (l *PathLinker) Flush() []byte {
+	if l == nil || len(l.carry) == 0 {
+		return nil
+	}
+	l.budget = maxStatsPerChunk
+	out := []byte(l.linkText(string(l.carry)))
+	l.carry = nil
+	return out
+}
+
+// incompleteRune — сколько байт в конце b занимает недописанный
+// UTF-8-символ (0 — такого нет; не-UTF-8 вывод проходит как есть)
+func incompleteRune(b []byte) int {
+	for n := 1; n < utf8.UTFMax && n <= len(b); n++ {
+		c := b[len(b)-n]
+		if c < utf8.RuneSelf {
+			return 0
+		}
+		if utf8.RuneStart(c) {
+			if utf8.FullRune(b[len(b)-n:]) {
+				return 0
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+// linkText оборачивает найденные пути в OSC 8
+func (l *PathLinker) linkText(s string) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(s); i++ {
+		if !isWordStart(s, i) {
+			continue
+		}
+		end, abs, isDir, ok := l.match(s, i)
+		if !ok {
+			continue
+		}
+		b.WriteString(s[last:i])
+		b.WriteString(osc8Start + l.href(abs, isDir) + osc8End)
+		b.WriteString(s[i:end])
+		b.WriteString(osc8Start + osc8End)
+		last, i = end, end-1
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// match ищет путь, начинающийся в s[i]: конец в s, полный путь и папка ли это
+func (l *PathLinker) match(s string, i int) (end int, abs string, isDir, ok bool) {
+	ends := pathEnds(s, i)
+	if len(ends) == 0 {
+		return 0, "", false, false
+	}
+	raw := s[i:ends[0]]
+	if raw[0] == '-' || strings.Contains(raw, "://") || isSwitch(raw) {
+		return 0, "", false, false
+	}
+	// Самый длинный вариант — первым
+	for k := len(ends) - 1; k >= 0; k-- {
+		p := trimPath(s[i:ends[k]])
+		if !plausiblePath(p) {
+			continue
+		}
+		if abs, isDir, ok := l.exists(p); ok {
+			return i + len(p), abs, isDir, true
+		}
+	}
+	return 0, "", false, false
+}
+
+// pathEnds — возможные концы пути от s[i], по возрастанию. Путь в кавычках
+// идёт до закрывающей кавычки, путь C:\... может продолжаться через пробелы.
+func pathEnds(s string, i int) []int {
+	if i > 0 && s[i-1] == '"' {
+		if q := strings.IndexByte(s[i:], '"'); q > 0 && !strings.ContainsAny(s[i:i+q], "\x1b\t") {
+			return []int{i + q}
+		}
+	}
+	j := i
+	for j < len(s) && isPathByte(s[j]) {
+		j++
+	}
+	if j == i {
+		return nil
+	}
+	ends := []int{j}
+	if !isDrivePath(s[i:j]) {
+		return ends
+	}
+	for n := 0; n < maxSpaceJoins && j < len(s) && s[j] == ' '; n++ {
+		k := j + 1
+		for k < len(s) && isPathByte(s[k]) {
+			k++
+		}
+		if k == j+1 {
+			break
+		}
+		j = k
+		ends = append(ends, j)
+	}
+	return ends
+}
+
+// isWordStart — s[i] начинает слово (после пробела, кавычки, скобки)
+func isWordStart(s string, i int) bool {
+	return isPathByte(s[i]) && (i == 0 || strings.IndexByte(" \t\"'([<,", s[i-1]) >= 0)
+}
+
+// isPathByte — байт, который может быть в пути без кавычек
+func isPathByte(c byte) bool {
+	return c > ' ' && c != 0x7f && strings.IndexByte(`"<>|*?`, c) < 0
+}
+
+// isDrivePath — C:\... или C:/...
+func isDrivePath(p string) bool {
+	return len(p) >= 3 && p[1] == ':' && (p[2] == '\\' || p[2] == '/') &&
+		('a' <= p[0] && p[0] <= 'z' || 'A' <= p[0] && p[0] <= 'Z')
+}
+
+// isSwitch — ключ команды Windows: /s, /ad, /?
+func isSwitch(p string) bool {
+	return p[0] == '/' && len(p) <= 3 && !strings.ContainsAny(p[1:], `/\.`)
+}
+
+// trimPath отрезает знаки препинания и суффикс :строка:столбец
+func trimPath(p string) string {
+	const punct = ".,;:)]'\""
+	p = strings.TrimRight(p, punct)
+	for n := 0; n < 2; n++ {
+		c := strings.LastIndexByte(p, ':')
+		if c <= 1 || c == len(p)-1 || strings.Trim(p[c+1:], "0123456789") != "" {
+			break
+		}
+		p = p[:c]
+	}
+	return strings.TrimRight(p, punct)
+}
+
+// plausiblePath — похоже ли p на путь: абсолютный, C:\... или
+// относительный с разделителем или точкой; двоеточие — только после буквы диска
+func plausiblePath(p string) bool {
+	switch {
+	case p == "" || p == "." || p == "..":
+		return false
+	case isDrivePath(p):
+		return !strings.Contains(p[2:], ":")
+	case strings.Contains(p, ":"):
+		return false
+	case p[0] == '/':
+		return true
+	}
+	return strings.ContainsAny(p, `/\.`)
+}
+
+// exists проверяет путь (в пределах бюджета куска) и возвращает полный путь
+func (l *PathLinker) exists(p string) (abs string, isDir, ok bool) {
+	if l.budget <= 0 {
+		return "", false, false
+	}
+	l.budget--
+	abs = p
+	if !isDrivePath(p) && p[0] != '/' {
+		abs = filepath.Join(l.dir, p)
+	}
+	isDir, ok = l.stat(abs)
+	return abs, isDir, ok
+}
+
+// href — ссылка на файл (/dl) или папку (/api/ls)
+func (l *PathLinker) href(abs string, isDir bool) string {
+	q := url.Values{"path": {abs}, "token": {l.token}}.Encode()
+	if isDir {
+		return "/api/ls?" + q
+	}
+	return "/dl?" + q
+}
+
+// ---------- кэш проверок существования ----------
+
+type statEntry struct {
+	path      string
+	isDir, ok bool
+	at        time.Time
+}
+
+// pathStatCache — LRU результатов os.Stat: не больше size путей, каждый
+// помнится ttl (потом файл могли создать или удалить)
+type pathStatCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // *statEntry, свежие в начале
+	entries map[string]*list.Element
+	now     func() time.Time
+	lookup  func(path string) (isDir, ok bool)
+}
+
+func newPathStatCache(size int, ttl time.Duration) *pathStatCache {
+	return &pathStatCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+		lookup: func(path string) (bool, bool) {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return false, false
+			}
+			return fi.IsDir(), true
+		},
+	}
+}
+
+func (c *pathStatCache) Stat(path string) (isDir, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if el, found := c.entries[path]; found {
+		e := el.Value.(*statEntry)
+		if now.Sub(e.at) < c.ttl {
+			c.order.MoveToFront(el)
+			return e.isDir, e.ok
+		}
+		c.order.Remove(el)
+		delete(c.entries, path)
+	}
+	isDir, ok = c.lookup(path)
+	c.entries[path] = c.order.PushFront(&statEntry{path: path, isDir: isDir, ok: ok, at: now})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*statEntry).path)
+	}
+	return isDir, ok
+}
+
+var pathStats = newPathStatCache(statCacheSize, statCacheTTL)
+
+// ---------- /dl и /api/ls ----------
+
+// lsEntry — строка листинга /api/ls
+type lsEntry struct {
+	Name string `json:"name"`
+	Dir  bool   `json:"dir"`
+	Size int64  `json:"size"`
+}
+
+// sessionPath — путь из запроса с токеном живой сессии; иначе ответ уже отправлен
+func sessionPath(c *gin.Context, sessions *SessionRegistry) (string, bool) {
+	if !sessions.Valid(c.Query("token")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "session token required"})
+		return "", false
+	}
+	path := c.Query("path")
+	if path == "" || !(filepath.IsAbs(path) || isDrivePath(path)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "absolute path required"})
+		return "", false
+	}
+	return filepath.Clean(path), true
+}
+
+// dlHandler — GET /dl?path=&token=: файл как вложение
+func dlHandler(sessions *SessionRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path, ok := sessionPath(c, sessions)
+		if !ok {
+			return
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if fi.IsDir() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "is a directory, use /api/ls"})
+			return
+		}
+		c.FileAttachment(path, filepath.Base(path))
+	}
+}
+
+// lsHandler — GET /api/ls?path=&token=: содержимое папки, папки первыми
+func lsHandler(sessions *SessionRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path, ok := sessionPath(c, sessions)
+		if !ok {
+			return
+		}
+		dirEntries, err := os.ReadDir(path)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		entries := make([]lsEntry, 0, len(dirEntries))
+		for _, e := range dirEntries {
+			entry := lsEntry{Name: e.Name(), Dir: e.IsDir()}
+			if info, err := e.Info(); err == nil && !e.IsDir() {
+				entry.Size = info.Size()
+			}
+			entries = append(entries, entry)
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Dir && !entries[j].Dir })
+		c.JSON(http.StatusOK, gin.H{"path": path, "parent": filepath.Dir(path), "entries": entries})
+	}
+}
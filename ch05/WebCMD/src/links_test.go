@@ -0,0 +1,136 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// testLinker — обработчик с выдуманной файловой системой: путь → папка ли
+func testLinker(dir string, fs map[string]bool) *PathLinker {
+	return &PathLinker{dir: dir, token: "tok", stat: func(path string) (bool, bool) {
+		isDir, ok := fs[path]
+		return isDir, ok
+	}}
+}
+
+// linked — text со ссылкой на path (файл) вместо shown
+func linked(text, shown, path string) string {
+	l := &PathLinker{token: "tok"}
+	return strings.Replace(text, shown, osc8Start+l.href(path, false)+osc8End+shown+osc8Start+osc8End, 1)
+}
+
+func TestPathLinkDetection(t *testing.T) {
+	fs := map[string]bool{
+		`C:\Program Files\Go\bin\go.exe`: false,
+		`C:\Program Files`:               true,
+		`C:\My Docs\отчёт 2024.txt`:      false,
+		`C:\Work\main.go`:                false,
+		"/tmp/x":                         false,
+		"/s":                             true,
+	}
+	relMain := filepath.Join(`C:\Work`, "main.go") // относительный путь — от папки команды
+	fs[relMain] = false
+	l := testLinker(`C:\Work`, fs)
+	cases := []struct {
+		line, shown, path string // shown == "" — ссылок быть не должно
+	}{
+		{`found C:\Program Files\Go\bin\go.exe, ok`, `C:\Program Files\Go\bin\go.exe`, `C:\Program Files\Go\bin\go.exe`},
+		{`copy "C:\My Docs\отчёт 2024.txt" done`, `C:\My Docs\отчёт 2024.txt`, `C:\My Docs\отчёт 2024.txt`},
+		{`C:\My Docs\отчёт 2024.txt`, `C:\My Docs\отчёт 2024.txt`, `C:\My Docs\отчёт 2024.txt`},
+		{`main.go:12:5: undefined: x`, `main.go`, relMain},
+		{`see C:\Work\main.go:7.`, `C:\Work\main.go`, `C:\Work\main.go`},
+		{`(/tmp/x)`, `/tmp/x`, `/tmp/x`},
+		{`https://example.com/tmp/x`, "", ""},
+		{`file:///tmp/x`, "", ""},
+		{`go build -o/tmp/x`, "", ""},
+		{`dir /s`, "", ""},
+		{`C:\Program Filesystem\x`, "", ""},
+		{`version 1.2.3 of nothing`, "", ""},
+		{`C:\Nope\missing.txt`, "", ""},
+	}
+	for _, c := range cases {
+		got := string(l.Write([]byte(c.line + "\r\n")))
+		want := c.line + "\r\n"
+		if c.shown != "" {
+			want = linked(want, c.shown, c.path)
+		}
+		if got != want {
+			t.Errorf("%q:\n got %q\nwant %q", c.line, got, want)
+		}
+	}
+}
+
+func TestPathLinkDirectoryHref(t *testing.T) {
+	l := testLinker("/", map[string]bool{`C:\Program Files`: true})
+	got := string(l.Write([]byte("в C:\\Program Files нет\n")))
+	if !strings.Contains(got, "/api/ls?path=C%3A%5CProgram+Files&token=tok") {
+		t.Fatalf("directory should link to /api/ls: %q", got)
+	}
+}
+
+// Разрез вывода в любом месте — даже посреди многобайтового символа или
+// пути с пробелами — не меняет результат, и каждый кусок — целый UTF-8
+func TestPathLinkChunkBoundaries(t *testing.T) {
+	fs := map[string]bool{`C:\Program Files\Go\bin\go.exe`: false}
+	text := "собираю C:\\Program Files\\Go\\bin\\go.exe — готово\r\nещё строка"
+	whole := testLinker(`C:\`, fs)
+	want := string(whole.Write([]byte(text))) + string(whole.Flush())
+	if !strings.Contains(want, osc8Start) {
+		t.Fatalf("no link in %q", want)
+	}
+	for cut := 1; cut < len(text); cut++ {
+		l := testLinker(`C:\`, fs)
+		chunks := [][]byte{l.Write([]byte(text[:cut])), l.Write([]byte(text[cut:])), l.Flush()}
+		var got strings.Builder
+		for _, ch := range chunks {
+			if !utf8.Valid(ch) {
+				t.Fatalf("cut %d: chunk is not valid UTF-8: %q", cut, ch)
+			}
+			got.Write(ch)
+		}
+		if got.String() != want {
+			t.Fatalf("cut %d:\n got %q\nwant %q", cut, got.String(), want)
+		}
+	}
+}
+
+func TestPathLinkerDisabled(t *testing.T) {
+	linkPaths = false
+	t.Cleanup(func() { linkPaths = true })
+	l := newPathLinker("/", "tok")
+	chunk := []byte("/tmp \xd0")
+	if got := l.Write(chunk); string(got) != string(chunk) || l.Flush() != nil {
+		t.Fatalf("disabled linker must pass output through: %q", got)
+	}
+}
+
+func TestPathStatCacheLRU(t *testing.T) {
+	c := newPathStatCache(2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	calls := map[string]int{}
+	c.lookup = func(path string) (bool, bool) {
+		calls[path]++
+		return false, path != "/missing"
+	}
+	c.Stat("/a")
+	c.Stat("/b")
+	c.Stat("/a") // /a свежее /b
+	c.Stat("/c") // вытесняет /b
+	c.Stat("/a")
+	c.Stat("/b")
+	if calls["/a"] != 1 || calls["/b"] != 2 || calls["/c"] != 1 {
+		t.Fatalf("lookups: %v", calls)
+	}
+	if _, ok := c.Stat("/missing"); ok {
+		t.Fatal("missing path reported as existing")
+	}
+	now = now.Add(time.Minute)
+	c.Stat("/a")
+	if calls["/a"] != 2 {
+		t.Fatalf("stale entry must be rechecked: %v", calls)
+	}
+}
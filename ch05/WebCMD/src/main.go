@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"html/template"
 	"io"
@@ -9,9 +13,14 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -35,6 +44,9 @@ var (
 	dirStack []string   // стек каталогов для pushd/popd
 
 	writeMu sync.Mutex // синхронизация записи в WebSocket (нельзя писать из разных горутин)
+
+	// Журнал аудита (audit.go); nil — аудит выключен
+	audit *AuditLog
 )
 
 // Структура для управления одной активной командой
@@ -43,12 +55,95 @@ type Shell struct {
 	cmd *exec.Cmd
 }
 
+// Session — состояние одной консоли. Переживает обрыв WebSocket: conn
+// меняется при переподключении (resume.go).
+type Session struct {
+	id    string // для журнала аудита
+	token string // для переподключения
+	owner string // владелец настроек — cookie браузера (settings.go)
+
+	outMu     sync.Mutex      // защищает conn, pending и history
+	conn      *websocket.Conn // nil, пока соединения нет
+	pending   []byte          // вывод за время разрыва
+	truncated bool            // pending переполнялся
+	history   scrollback      // последние строки вывода (settings.go)
+	expiry    *time.Timer     // закрытие сессии без соединения (под SessionRegistry.mu)
+	detaches  int             // номер отключения для expiry (под SessionRegistry.mu)
+
+	mu       sync.Mutex
+	backend  Backend         // оболочка для системных команд (cmd, powershell, pwsh)
+	prompt   *PromptTemplate // шаблон приглашения (:prompt)
+	lastExit int             // код завершения последней команды
+	jobs     map[*exec.Cmd]struct{}
+}
+
+// newSession — сессия владельца owner с его длиной прокрутки
+func newSession(conn *websocket.Conn, owner string) *Session {
+	s := &Session{id: newSessionID(), owner: owner, conn: conn, backend: BackendCmd, prompt: loadPromptTemplate()}
+	s.history.max = settingsStore.Get(owner).Scrollback
+	return s
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Session) Backend() Backend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backend
+}
+
+func (s *Session) SetBackend(b Backend) {
+	s.mu.Lock()
+	s.backend = b
+	s.mu.Unlock()
+}
+
+func (s *Session) Prompt() *PromptTemplate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prompt
+}
+
+func (s *Session) SetPrompt(t *PromptTemplate) {
+	s.mu.Lock()
+	s.prompt = t
+	s.mu.Unlock()
+}
+
+func (s *Session) SetExitCode(code int) {
+	s.mu.Lock()
+	s.lastExit = code
+	s.mu.Unlock()
+}
+
+// promptLine — строка приглашения для папки dir по шаблону сессии
+func (s *Session) promptLine(dir string) string {
+	s.mu.Lock()
+	t, b, exit := s.prompt, s.backend, s.lastExit
+	s.mu.Unlock()
+	if t == nil || t.Source == "" {
+		return getPrompt(dir, b)
+	}
+	return t.Render(promptState{Dir: dir, Backend: b, ExitCode: exit, Now: time.Now()}, gitCache)
+}
+
 // Возвращает текущую рабочую директорию при старте программы
 func getInitialDir() string {
 	dir, _ := os.Getwd()
 	return dir
 }
 
+// getCurrentDir — текущая рабочая директория под блокировкой
+func getCurrentDir() string {
+	dirMu.Lock()
+	defer dirMu.Unlock()
+	return currentDir
+}
+
 // safeWrite — безопасная запись в WebSocket (с блокировкой, чтобы не было одновременной записи)
 func safeWrite(conn *websocket.Conn, data []byte) error {
 	writeMu.Lock()
@@ -60,7 +155,7 @@ func safeWrite(conn *websocket.Conn, data []byte) error {
 }
 
 // Run — основной метод, выполняющий введённую пользователем команду
-func (s *Shell) Run(command string, conn *websocket.Conn) error {
+func (s *Shell) Run(command string, sess *Session) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -70,90 +165,180 @@ func (s *Shell) Run(command string, conn *websocket.Conn) error {
 
 	cmdTrim := strings.TrimSpace(command)
 	cmdLower := strings.ToLower(cmdTrim)
+	start := time.Now()
+	builtin := func() {
+		logAudit(auditRecord(AuditBuiltin, sess, dir, cmdTrim, start))
+		sendPrompt(sess)
+	}
 
-	// Обработка встроенных команд: cd, pushd, popd
+	// Обработка встроенных команд: cd, pushd, popd, :shell, :prompt, :init — одинаково для всех оболочек
 	if isCDCommand(cmdTrim) {
 		handleCD(cmdTrim, dir)
-		sendPrompt(conn)
+		builtin()
 		return nil
 	}
 	if strings.HasPrefix(cmdLower, "pushd ") {
 		handlePushd(cmdTrim, dir)
-		sendPrompt(conn)
+		builtin()
 		return nil
 	}
 	if cmdLower == "popd" {
-		handlePopd(conn)
-		sendPrompt(conn)
+		handlePopd(sess)
+		builtin()
+		return nil
+	}
+	if cmdLower == ":shell" || strings.HasPrefix(cmdLower, ":shell ") {
+		if reason := handleShellSwitch(cmdTrim, sess); reason != "" {
+			rec := auditRecord(AuditDenied, sess, dir, cmdTrim, start)
+			rec.ExitCode, rec.Reason = 1, reason
+			logAudit(rec)
+			sendPrompt(sess)
+			return nil
+		}
+		builtin()
+		return nil
+	}
+	if cmdLower == ":prompt" || strings.HasPrefix(cmdLower, ":prompt ") {
+		handlePromptCommand(cmdTrim, sess)
+		builtin()
+		return nil
+	}
+	if cmdLower == ":init" || strings.HasPrefix(cmdLower, ":init ") {
+		handleInitCommand(cmdTrim, sess)
+		builtin()
 		return nil
 	}
 
-	// Для обычных системных команд (выполняются через cmd.exe)
-	fullCmd := fmt.Sprintf(`chcp 65001 >nul && %s`, cmdTrim)
-	cmd := exec.Command("cmd", "/c", fullCmd)
+	// Ограниченный режим: системные команды только из списка -allow
+	if !commandAllowed(cmdTrim) {
+		_ = sess.write([]byte("Команда запрещена: не входит в список разрешённых\r\n"))
+		rec := auditRecord(AuditDenied, sess, dir, cmdTrim, start)
+		rec.ExitCode, rec.Reason = 1, "not in allow-list"
+		logAudit(rec)
+		sendPrompt(sess)
+		return nil
+	}
+
+	// Обычные системные команды выполняются через оболочку сессии
+	cmd := backendCommand(sess.Backend(), cmdTrim)
 	cmd.Dir = dir
 
-	return runCmd(cmd, conn)
+	return runCmd(cmd, cmdTrim, sess)
+}
+
+// handleShellSwitch — `:shell` показывает оболочки, `:shell <name>` переключает.
+// Возвращает причину отказа (для журнала аудита) или "".
+func handleShellSwitch(command string, sess *Session) string {
+	arg := strings.TrimSpace(command[len(":shell"):])
+	available := strings.Join(availableBackends(), ", ")
+	if arg == "" {
+		_ = sess.write([]byte("Текущая оболочка: " + string(sess.Backend()) + ". Доступны: " + available + "\r\n"))
+		return ""
+	}
+	b, ok := parseBackend(arg)
+	if !ok {
+		_ = sess.write([]byte("Неизвестная оболочка: " + arg + ". Доступны: " + available + "\r\n"))
+		return "unknown shell"
+	}
+	if !installedBackends[b] {
+		_ = sess.write([]byte("Оболочка " + string(b) + " не установлена на сервере. Доступны: " + available + "\r\n"))
+		return "shell not installed"
+	}
+	sess.SetBackend(b)
+	_ = sess.write([]byte("Оболочка переключена на " + string(b) + "\r\n"))
+	return ""
 }
 
-// runCmd — выполняет команду и пересылает stdout/stderr пользователю через WebSocket
-func runCmd(cmd *exec.Cmd, conn *websocket.Conn) error {
+// runCmd — выполняет команду и пересылает stdout/stderr пользователю через WebSocket.
+// command — строка, как её ввёл пользователь (для журнала аудита).
+func runCmd(cmd *exec.Cmd, command string, sess *Session) error {
 	log.Printf("exec: %v, dir=%q\n", cmd.Args, cmd.Dir)
+	start := time.Now()
+	var outBytes atomic.Int64
+	record := func(exitCode int) {
+		rec := auditRecord(AuditExec, sess, cmd.Dir, command, start)
+		rec.ExitCode, rec.OutputBytes = exitCode, outBytes.Load()
+		logAudit(rec)
+	}
 
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
-		_ = safeWrite(conn, []byte("Ошибка: не удалось получить stdout: "+err.Error()+"\r\n"))
+		_ = sess.write([]byte("Ошибка: не удалось получить stdout: " + err.Error() + "\r\n"))
 		return err
 	}
 	stderrPipe, err := cmd.StderrPipe()
 	if err != nil {
-		_ = safeWrite(conn, []byte("Ошибка: не удалось получить stderr: "+err.Error()+"\r\n"))
+		_ = sess.write([]byte("Ошибка: не удалось получить stderr: " + err.Error() + "\r\n"))
 		return err
 	}
 
 	if err := cmd.Start(); err != nil {
-		_ = safeWrite(conn, []byte("Ошибка: "+err.Error()+"\r\n"))
+		_ = sess.write([]byte("Ошибка: " + err.Error() + "\r\n"))
+		record(-1)
 		return err
 	}
+	runningCommands.Add(1)
+	defer runningCommands.Add(-1)
+	sess.trackJob(cmd)
+	if memHintBytes > 0 {
+		stopWatch := make(chan struct{})
+		defer close(stopWatch)
+		go watchCommandMemory(cmd.Process.Pid, sess, stopWatch)
+	}
 
-	// Асинхронно пересылаем данные stdout и stderr в браузер
+	// Асинхронно пересылаем данные stdout и stderr в браузер; пути к
+	// файлам становятся ссылками (links.go)
 	sendFromPipe := func(r io.Reader) {
 		buf := make([]byte, 4096)
+		links := newPathLinker(cmd.Dir, sess.token)
 		for {
 			n, err := r.Read(buf)
 			if n > 0 {
-				_ = safeWrite(conn, buf[:n])
+				outBytes.Add(int64(n))
+				if out := links.Write(buf[:n]); len(out) > 0 {
+					_ = sess.write(out)
+				}
 			}
 			if err != nil {
+				if rest := links.Flush(); len(rest) > 0 {
+					_ = sess.write(rest)
+				}
 				if err != io.EOF {
-					_ = safeWrite(conn, []byte("pipe read error: "+err.Error()+"\r\n"))
+					_ = sess.write([]byte("pipe read error: " + err.Error() + "\r\n"))
 				}
 				break
 			}
 		}
 	}
 
-	go sendFromPipe(stdoutPipe)
-	go sendFromPipe(stderrPipe)
+	// Wait закрывает пайпы — сначала дочитываем их до конца
+	var pipes sync.WaitGroup
+	pipes.Add(2)
+	go func() { defer pipes.Done(); sendFromPipe(stdoutPipe) }()
+	go func() { defer pipes.Done(); sendFromPipe(stderrPipe) }()
+	pipes.Wait()
 
 	err = cmd.Wait()
-	sendPrompt(conn)
+	sess.untrackJob(cmd)
+	exitCode := 0
+	if err != nil && cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	sess.SetExitCode(exitCode)
+	record(exitCode)
+	sendPrompt(sess)
 	if err != nil {
-		exitCode := 0
-		if cmd.ProcessState != nil {
-			exitCode = cmd.ProcessState.ExitCode()
-		}
-		_ = safeWrite(conn, []byte("exit status "+fmt.Sprint(exitCode)+"\r\n"))
+		_ = sess.write([]byte("exit status " + fmt.Sprint(exitCode) + "\r\n"))
 	}
 	return nil
 }
 
-// sendPrompt — выводит строку приглашения (например: C:\Users\Vladimir>)
-func sendPrompt(conn *websocket.Conn) {
+// sendPrompt — выводит строку приглашения по шаблону сессии (по умолчанию: C:\Users\Vladimir>)
+func sendPrompt(sess *Session) {
 	dirMu.Lock()
-	prompt := getPrompt(currentDir)
+	dir := currentDir
 	dirMu.Unlock()
-	_ = safeWrite(conn, []byte("\r\n"+prompt))
+	_ = sess.write([]byte("\r\n" + sess.promptLine(dir)))
 }
 
 // isCDCommand — определяет, является ли команда командой `cd`
@@ -232,84 +417,209 @@ func handlePushd(command, current string) {
 }
 
 // handlePopd — возвращает предыдущую директорию из стека
-func handlePopd(conn *websocket.Conn) {
+func handlePopd(sess *Session) {
 	dirMu.Lock()
 	defer dirMu.Unlock()
 	if len(dirStack) == 0 {
-		_ = safeWrite(conn, []byte("Стек пуст.\r\n"))
+		_ = sess.write([]byte("Стек пуст.\r\n"))
 		return
 	}
 	currentDir = dirStack[len(dirStack)-1]
 	dirStack = dirStack[:len(dirStack)-1]
-	_ = safeWrite(conn, []byte("Перешёл в: "+currentDir+"\r\n"))
+	_ = sess.write([]byte("Перешёл в: " + currentDir + "\r\n"))
 }
 
 // getPrompt — возвращает строку приглашения, например: "C:\Projects>"
-func getPrompt(dir string) string {
-	return strings.ReplaceAll(dir, "/", "\\") + "> "
+// или "C:\Projects [pwsh]>" для PowerShell
+func getPrompt(dir string, b Backend) string {
+	return strings.ReplaceAll(dir, "/", "\\") + promptSuffix(b) + "> "
 }
 
-func main() {
-	// Настройка Gin (без лишних логов)
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.New()
-	r.Use(gin.Recovery())
-
-	// Загружаем встроенный HTML-шаблон консоли
-	tmpl := template.Must(template.ParseFS(embeddedFiles, "console.gohtml"))
-	r.SetHTMLTemplate(tmpl)
-
-	// Основная страница — отображает HTML с консолью
-	r.GET("/", func(c *gin.Context) {
-		dirMu.Lock()
-		prompt := getPrompt(currentDir)
-		dirMu.Unlock()
-		c.HTML(200, "console.gohtml", gin.H{"Prompt": prompt})
-	})
-
-	// WebSocket — взаимодействие с консолью
-	r.GET("/ws", func(c *gin.Context) {
+// wsHandler — WebSocket консоли. /ws?resume=<токен> возвращает к живой
+// сессии из sessions (resume.go), иначе начинается новая; с &replay=1
+// (перезагруженная страница) сессия присылает всю прокрутку.
+func wsHandler(sessions *SessionRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			log.Println("ws upgrade error:", err)
 			return
 		}
 		defer conn.Close()
-
-		dirMu.Lock()
-		prompt := getPrompt(currentDir)
-		dirMu.Unlock()
-
-		_ = safeWrite(conn, []byte("\033[36mLocalWebConsole v4 — готова к работе!\033[0m\r\n"))
-		_ = safeWrite(conn, []byte(prompt))
+		activeSessions.Add(1)
+		defer activeSessions.Add(-1)
+
+		token := c.Query("resume")
+		sess, resumed := sessions.Resume(token, conn, c.Query("replay") == "1")
+		if resumed {
+			if !sess.busy() {
+				sendPrompt(sess)
+			}
+		} else {
+			owner, _ := c.Cookie(settingsCookie)
+			sess = newSession(conn, owner)
+			sessions.Add(sess)
+			_ = sendSessionToken(conn, sess.token)
+			_ = sess.write([]byte("\033[36mLocalWebConsole v4 — готова к работе!\033[0m\r\n"))
+			if token != "" {
+				_ = sess.write([]byte("Прежняя сессия истекла — начата новая.\r\n"))
+			}
+			// motd и стартовые команды из ~/.webcmd/init.json (init.go)
+			runStartup(sess, currentInitConfig(), shell.Run)
+		}
 
 		for {
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
 				log.Println("ws read error:", err)
-				break
+				// Сессия ждёт переподключения resumeGrace
+				sessions.Detach(sess, conn)
+				return
 			}
 
 			cmd := strings.TrimSpace(string(msg))
 			if cmd == "" {
-				sendPrompt(conn)
+				sendPrompt(sess)
 				continue
 			}
 
 			switch cmd {
 			case "exit":
-				_ = safeWrite(conn, []byte("Пока!\r\n"))
+				logAudit(auditRecord(AuditBuiltin, sess, getCurrentDir(), cmd, time.Now()))
+				_ = sess.write([]byte("Пока!\r\n"))
+				sessions.Close(sess)
 				return
 			case "cls":
-				_ = safeWrite(conn, []byte("\033[H\033[2J"))
+				logAudit(auditRecord(AuditBuiltin, sess, getCurrentDir(), cmd, time.Now()))
+				_ = sess.write([]byte("\033[H\033[2J"))
 				continue
 			}
 
 			// Команды выполняются в отдельных горутинах
-			go shell.Run(cmd, conn)
+			go shell.Run(cmd, sess)
+		}
+	}
+}
+
+// auditHandler — GET /audit?after=<seq>&limit=<n>: страница журнала.
+// Только с админским токеном (Authorization: Bearer <token>); без
+// WEBCMD_ADMIN_TOKEN эндпоинт выключен.
+func auditHandler(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" || audit == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "audit endpoint disabled"})
+			return
+		}
+		if c.GetHeader("Authorization") != "Bearer "+token {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "admin token required"})
+			return
+		}
+		after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+		if err != nil || after < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad after"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit < 1 || limit > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be 1..1000"})
+			return
+		}
+		records, err := audit.Page(after, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		next := after
+		if len(records) > 0 {
+			next = records[len(records)-1].Seq
+		}
+		c.JSON(http.StatusOK, gin.H{"records": records, "next": next})
+	}
+}
+
+func main() {
+	auditPath := flag.String("audit", "audit.jsonl", "файл журнала аудита (пусто — без аудита)")
+	verifyAudit := flag.Bool("verify-audit", false, "проверить цепочку журнала аудита и выйти")
+	memHintMB := flag.Uint64("mem-hint", 0, "предупреждать в консоли, если команда занимает больше N МБ (0 — выключено)")
+	allow := flag.String("allow", "", "ограниченный режим: разрешённые команды через запятую (пусто — любые)")
+	resumeGrace := flag.Duration("resume-grace", defaultResumeGrace, "сколько ждать переподключения оборвавшейся сессии")
+	flag.BoolVar(&linkPaths, "links", true, "пути к существующим файлам в выводе — ссылками (OSC 8) на /dl и /api/ls")
+	settingsPath := flag.String("settings", defaultSettingsPath(), "файл настроек терминала по браузерам (пусто — только в памяти)")
+	flag.Parse()
+	memHintBytes = *memHintMB << 20
+	allowList = parseAllowList(*allow)
+
+	if _, err := reloadInitConfig(); err != nil {
+		log.Println("init:", err)
+	}
+	var err error
+	if settingsStore, err = OpenSettingsStore(*settingsPath); err != nil {
+		log.Fatal("settings: ", err)
+	}
+
+	if *auditPath != "" {
+		key, err := loadAuditKey(*auditPath + ".key")
+		if err != nil {
+			log.Fatal("audit key: ", err)
+		}
+		if *verifyAudit {
+			n, _, err := verifyAuditFile(*auditPath, key)
+			if err != nil {
+				log.Fatal("audit: ", err)
+			}
+			log.Printf("audit: %d записей, цепочка цела\n", n)
+			return
 		}
+		if audit, err = OpenAuditLog(*auditPath, key); err != nil {
+			log.Fatal("audit: ", err)
+		}
+		defer audit.Close()
+	}
+
+	// Какие оболочки реально установлены
+	detectBackends()
+	log.Printf("Доступные оболочки: %s\n", strings.Join(availableBackends(), ", "))
+
+	// Настройка Gin (без лишних логов)
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	// Загружаем встроенный HTML-шаблон консоли
+	tmpl := template.Must(template.ParseFS(embeddedFiles, "console.gohtml"))
+	r.SetHTMLTemplate(tmpl)
+
+	// Основная страница — отображает HTML с консолью и настройками браузера
+	r.GET("/", func(c *gin.Context) {
+		dirMu.Lock()
+		dir := currentDir
+		dirMu.Unlock()
+		prompt := (&Session{backend: BackendCmd, prompt: loadPromptTemplate()}).promptLine(dir)
+		c.HTML(200, "console.gohtml", gin.H{"Prompt": prompt, "Settings": settingsStore.Get(browserID(c))})
 	})
 
+	// WebSocket — взаимодействие с консолью
+	sessions := NewSessionRegistry(*resumeGrace)
+	r.GET("/ws", wsHandler(sessions))
+
+	// Настройки терминала (settings.go)
+	r.GET("/api/settings", settingsGetHandler)
+	r.PUT("/api/settings", settingsPutHandler(sessions))
+
+	// Файлы по ссылкам из вывода (links.go), только с токеном сессии
+	r.GET("/dl", dlHandler(sessions))
+	r.GET("/api/ls", lsHandler(sessions))
+
+	// Журнал аудита для администратора
+	r.GET("/audit", auditHandler(os.Getenv("WEBCMD_ADMIN_TOKEN")))
+
+	// Состояние сервера и история потребления ресурсов (usage.go)
+	usage := NewUsageRing(int(usageWindow / usageInterval))
+	sampler := StartUsageSampler(usage, usageInterval)
+	defer sampler.Stop()
+	r.GET("/healthz", healthzHandler)
+	r.GET("/api/usage", usageHandler(usage))
+
 	// Маршрут для автодополнения имён файлов/папок
 	r.POST("/complete", func(c *gin.Context) {
 		var req struct{ Prefix string }
@@ -341,10 +651,21 @@ func main() {
 		c.JSON(200, matches)
 	})
 
-	// Запуск сервера
+	// Запуск сервера; Ctrl+C — мягкая остановка (сэмплер и аудит закрываются)
+	srv := &http.Server{Addr: "127.0.0.1:8080", Handler: r}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
 	log.Println("LocalWebConsole v4 запущена!")
 	log.Println("Открой: http://localhost:8080")
-	if err := r.Run("127.0.0.1:8080"); err != nil {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	log.Println("Сервер остановлен")
 }
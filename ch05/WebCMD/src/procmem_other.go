@@ -0,0 +1,9 @@
+//go:build !windows && !linux
+
+package main
+
+import "errors"
+
+func processWorkingSet(pid int) (uint64, error) {
+	return 0, errors.New("working set is not supported on this OS")
+}
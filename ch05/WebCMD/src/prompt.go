@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Шаблон приглашения. Пустой шаблон — классический вид "C:\dir>" (getPrompt).
+// Токены:
+//
+//	{cwd}   — текущая папка
+//	{drive} — диск ("C:"), на Unix — "/"
+//	{shell} — оболочка сессии (cmd, powershell, pwsh)
+//	{exit}  — код завершения последней команды, ненулевой — красным
+//	{git}   — текущая ветка git (пусто вне репозитория)
+//	{time}  — время ЧЧ:ММ:СС
+//
+// "{{" выводит литеральную "{".
+
+var promptTokens = map[string]bool{
+	"cwd": true, "drive": true, "shell": true, "exit": true, "git": true, "time": true,
+}
+
+// promptPart — кусок шаблона: литерал или токен
+type promptPart struct {
+	Text  string
+	Token string
+}
+
+// PromptTemplate — разобранный шаблон приглашения
+type PromptTemplate struct {
+	Source string
+	parts  []promptPart
+}
+
+func (t *PromptTemplate) uses(token string) bool {
+	for _, p := range t.parts {
+		if p.Token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePromptTemplate разбирает шаблон; неизвестный токен или незакрытая
+// скобка — ошибка.
+func parsePromptTemplate(src string) (*PromptTemplate, error) {
+	t := &PromptTemplate{Source: src}
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			t.parts = append(t.parts, promptPart{Text: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(src); i++ {
+		if src[i] != '{' {
+			lit.WriteByte(src[i])
+			continue
+		}
+		if i+1 < len(src) && src[i+1] == '{' {
+			lit.WriteByte('{')
+			i++
+			continue
+		}
+		end := strings.IndexByte(src[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("незакрытая { в позиции %d", i)
+		}
+		name := src[i+1 : i+end]
+		if !promptTokens[name] {
+			return nil, fmt.Errorf("неизвестный токен {%s}", name)
+		}
+		flush()
+		t.parts = append(t.parts, promptPart{Token: name})
+		i += end
+	}
+	flush()
+	return t, nil
+}
+
+// promptState — данные сессии для отрисовки приглашения
+type promptState struct {
+	Dir      string
+	Backend  Backend
+	ExitCode int
+	Now      time.Time
+}
+
+// Render подставляет токены. Ветка git запрашивается, только если шаблон
+// содержит {git}.
+func (t *PromptTemplate) Render(st promptState, git *gitBranchCache) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		switch p.Token {
+		case "":
+			b.WriteString(p.Text)
+		case "cwd":
+			b.WriteString(strings.ReplaceAll(st.Dir, "/", "\\"))
+		case "drive":
+			b.WriteString(driveOf(st.Dir))
+		case "shell":
+			b.WriteString(string(st.Backend))
+		case "exit":
+			if st.ExitCode != 0 {
+				b.WriteString(fmt.Sprintf("\033[31m%d\033[0m", st.ExitCode))
+			} else {
+				b.WriteString("0")
+			}
+		case "git":
+			if git != nil {
+				b.WriteString(git.Branch(st.Dir))
+			}
+		case "time":
+			b.WriteString(st.Now.Format("15:04:05"))
+		}
+	}
+	return b.String()
+}
+
+// driveOf — "C:" для путей Windows, "/" для остальных
+func driveOf(dir string) string {
+	if len(dir) >= 2 && dir[1] == ':' {
+		return strings.ToUpper(dir[:2])
+	}
+	if v := filepath.VolumeName(dir); v != "" {
+		return v
+	}
+	return "/"
+}
+
+// hasGitAncestor ищет .git в папке и выше — простая проверка файловой системы,
+// чтобы не запускать git вне репозиториев.
+func hasGitAncestor(dir string) bool {
+	dir = filepath.Clean(dir)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// gitBranch — `git rev-parse --abbrev-ref HEAD` в папке dir
+func gitBranch(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+type gitCacheEntry struct {
+	branch string
+	at     time.Time
+}
+
+// gitBranchCache — ветка git по папке, кешируется на ttl
+type gitBranchCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]gitCacheEntry
+	now     func() time.Time
+	lookup  func(dir string) string
+}
+
+func newGitBranchCache(ttl time.Duration) *gitBranchCache {
+	return &gitBranchCache{
+		ttl:     ttl,
+		entries: make(map[string]gitCacheEntry),
+		now:     time.Now,
+		lookup: func(dir string) string {
+			if !hasGitAncestor(dir) {
+				return ""
+			}
+			return gitBranch(dir)
+		},
+	}
+}
+
+func (c *gitBranchCache) Branch(dir string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if e, ok := c.entries[dir]; ok && now.Sub(e.at) < c.ttl {
+		return e.branch
+	}
+	branch := c.lookup(dir)
+	c.entries[dir] = gitCacheEntry{branch: branch, at: now}
+	return branch
+}
+
+var gitCache = newGitBranchCache(5 * time.Second)
+
+// ---------- сохранение шаблона ----------
+
+type promptConfig struct {
+	Template string `json:"template"`
+}
+
+// promptConfigPath — <UserConfigDir>/webcmd/prompt.json
+func promptConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "webcmd", "prompt.json"), nil
+}
+
+// loadPromptTemplate читает сохранённый шаблон; при любой ошибке — классический вид
+func loadPromptTemplate() *PromptTemplate {
+	empty := &PromptTemplate{}
+	path, err := promptConfigPath()
+	if err != nil {
+		return empty
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return empty
+	}
+	var cfg promptConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return empty
+	}
+	t, err := parsePromptTemplate(cfg.Template)
+	if err != nil {
+		return empty
+	}
+	return t
+}
+
+func savePromptTemplate(t *PromptTemplate) error {
+	path, err := promptConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(promptConfig{Template: t.Source}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// handlePromptCommand — `:prompt` показывает шаблон, `:prompt reset` сбрасывает,
+// `:prompt <шаблон>` задаёт и сохраняет новый
+func handlePromptCommand(command string, sess *Session) {
+	arg := strings.TrimSpace(command[len(":prompt"):])
+	switch arg {
+	case "":
+		cur := sess.Prompt().Source
+		if cur == "" {
+			cur = "(по умолчанию)"
+		}
+		_ = sess.write([]byte("Шаблон приглашения: " + cur + "\r\nТокены: {cwd} {drive} {shell} {exit} {git} {time}\r\n"))
+		return
+	case "reset":
+		arg = ""
+	}
+	t, err := parsePromptTemplate(arg)
+	if err != nil {
+		_ = sess.write([]byte("Ошибка шаблона: " + err.Error() + "\r\n"))
+		return
+	}
+	sess.SetPrompt(t)
+	if err := savePromptTemplate(t); err != nil {
+		_ = sess.write([]byte("Не удалось сохранить шаблон: " + err.Error() + "\r\n"))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParsePromptTemplate(t *testing.T) {
+	tpl, err := parsePromptTemplate("{{{time}} {cwd} ({git}) [{shell}] {exit}> ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := newGitBranchCache(time.Minute)
+	git.lookup = func(string) string { return "main" }
+	st := promptState{
+		Dir:      `C:/Work/app`,
+		Backend:  BackendPwsh,
+		ExitCode: 2,
+		Now:      time.Date(2024, 1, 1, 9, 5, 7, 0, time.UTC),
+	}
+	want := "{09:05:07} C:\\Work\\app (main) [pwsh] \033[31m2\033[0m> "
+	if got := tpl.Render(st, git); got != want {
+		t.Fatalf("Render = %q, want %q", got, want)
+	}
+	st.ExitCode = 0
+	tpl, _ = parsePromptTemplate("{drive}{exit}")
+	if got := tpl.Render(st, nil); got != "C:0" {
+		t.Fatalf("Render = %q", got)
+	}
+
+	for _, bad := range []string{"{cwd", "{nope}", "x {} y"} {
+		if _, err := parsePromptTemplate(bad); err == nil {
+			t.Errorf("parsePromptTemplate(%q) should fail", bad)
+		}
+	}
+}
+
+func TestPromptWithoutGitTokenSkipsLookup(t *testing.T) {
+	tpl, _ := parsePromptTemplate("{cwd}> ")
+	git := newGitBranchCache(time.Minute)
+	git.lookup = func(string) string {
+		t.Fatal("git lookup should not run when {git} is not used")
+		return ""
+	}
+	tpl.Render(promptState{Dir: "/tmp"}, git)
+}
+
+func TestHasGitAncestor(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	deep := filepath.Join(repo, "a", "b")
+	outside := filepath.Join(root, "plain")
+	for _, d := range []string{filepath.Join(repo, ".git"), deep, outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !hasGitAncestor(deep) {
+		t.Error("nested folder inside a repo should be detected")
+	}
+	if hasGitAncestor(outside) {
+		t.Error("folder outside any repo should not be detected")
+	}
+
+	// Worktrees and submodules use a .git file instead of a folder
+	wt := filepath.Join(root, "worktree")
+	os.MkdirAll(wt, 0o755)
+	os.WriteFile(filepath.Join(wt, ".git"), []byte("gitdir: ../repo/.git"), 0o644)
+	if !hasGitAncestor(wt) {
+		t.Error(".git file should count as a repo")
+	}
+}
+
+func TestGitBranchCacheExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	calls := 0
+	c := newGitBranchCache(5 * time.Second)
+	c.now = func() time.Time { return now }
+	c.lookup = func(string) string { calls++; return "feature" }
+
+	c.Branch("/repo")
+	now = now.Add(4 * time.Second)
+	if got := c.Branch("/repo"); got != "feature" || calls != 1 {
+		t.Fatalf("cached lookup expected, calls=%d", calls)
+	}
+	c.Branch("/other")
+	if calls != 2 {
+		t.Fatalf("cache is per directory, calls=%d", calls)
+	}
+	now = now.Add(2 * time.Second)
+	c.Branch("/repo")
+	if calls != 3 {
+		t.Fatalf("expired entry should be refreshed, calls=%d", calls)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ---------- переподключение ----------
+
+// Сессия переживает обрыв WebSocket. При создании она получает токен,
+// который первым текстовым кадром {"type":"session","token":...} уходит в
+// браузер. Если соединение рвётся, сессия остаётся жить resumeGrace:
+// запущенные команды продолжают работать, их вывод копится в pending.
+// Браузер переподключается на /ws?resume=<токен> — сессия цепляется к новому
+// соединению, накопленный вывод отправляется одним куском, и, если команда
+// уже завершилась, выводится приглашение (оболочка, шаблон приглашения и код
+// завершения хранятся в Session). Неизвестный или просроченный токен — новая
+// сессия. Не вернувшаяся вовремя сессия закрывается, её команды
+// принудительно завершаются.
+
+const (
+	defaultResumeGrace = 2 * time.Minute
+	maxPendingOutput   = 1 << 20 // вывод без соединения, байт; старое отбрасывается
+)
+
+// SessionRegistry — сессии, к которым можно переподключиться
+type SessionRegistry struct {
+	mu      sync.Mutex
+	grace   time.Duration
+	byToken map[string]*Session
+}
+
+func NewSessionRegistry(grace time.Duration) *SessionRegistry {
+	return &SessionRegistry{grace: grace, byToken: map[string]*Session{}}
+}
+
+// Add выдаёт сессии токен и регистрирует её
+func (r *SessionRegistry) Add(s *Session) {
+	s.token = newResumeToken()
+	r.mu.Lock()
+	r.byToken[s.token] = s
+	r.mu.Unlock()
+}
+
+// Resume подключает conn к сессии с токеном token. false — токена нет или
+// сессия уже закрыта. Если сессия ещё числится за старым соединением (сервер
+// не успел заметить обрыв), старое соединение закрывается. replay — вместо
+// накопленного за разрыв прислать всю прокрутку (страницу перезагрузили).
+func (r *SessionRegistry) Resume(token string, conn *websocket.Conn, replay bool) (*Session, bool) {
+	if token == "" {
+		return nil, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.byToken[token]
+	if s == nil {
+		return nil, false
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	if old := s.attach(conn, replay); old != nil {
+		_ = old.Close()
+	}
+	return s, true
+}
+
+// Valid — есть ли живая сессия с токеном token (ссылки /dl и /api/ls)
+func (r *SessionRegistry) Valid(token string) bool {
+	if token == "" {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.byToken[token] != nil
+}
+
+// Detach отключает conn от сессии и запускает отсчёт resumeGrace. Если
+// сессия уже перешла на другое соединение, ничего не делает.
+func (r *SessionRegistry) Detach(s *Session, conn *websocket.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !s.detach(conn) || r.byToken[s.token] != s {
+		return
+	}
+	s.detaches++
+	gen := s.detaches
+	s.expiry = time.AfterFunc(r.grace, func() { r.expire(s, gen) })
+}
+
+// Close закрывает сессию сразу (команда exit)
+func (r *SessionRegistry) Close(s *Session) {
+	r.mu.Lock()
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	if r.byToken[s.token] == s {
+		delete(r.byToken, s.token)
+	}
+	r.mu.Unlock()
+	s.killJobs()
+}
+
+// expire — срабатывание таймера отключения номер gen: сессия не вернулась
+// за resumeGrace
+func (r *SessionRegistry) expire(s *Session, gen int) {
+	r.mu.Lock()
+	if s.expiry == nil || s.detaches != gen {
+		// Успели переподключиться (или отключиться заново) до срабатывания
+		r.mu.Unlock()
+		return
+	}
+	s.expiry = nil
+	delete(r.byToken, s.token)
+	r.mu.Unlock()
+	log.Printf("session %s: не переподключилась за %v, закрыта\n", s.id, r.grace)
+	s.killJobs()
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sendSessionToken — управляющий текстовый кадр с токеном сессии (вывод
+// консоли идёт бинарными кадрами)
+func sendSessionToken(conn *websocket.Conn, token string) error {
+	data, _ := json.Marshal(map[string]string{"type": "session", "token": token})
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// write — вывод в консоль сессии; он же попадает в прокрутку. Без
+// соединения (или если запись не удалась) вывод копится до переподключения.
+func (s *Session) write(data []byte) error {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.history.Write(data)
+	if s.conn != nil && safeWrite(s.conn, data) == nil {
+		return nil
+	}
+	s.pending = append(s.pending, data...)
+	if over := len(s.pending) - maxPendingOutput; over > 0 {
+		s.pending = append(s.pending[:0], s.pending[over:]...)
+		s.truncated = true
+	}
+	return nil
+}
+
+// attach делает conn соединением сессии: токен, отметка о восстановлении и
+// накопленный вывод (с replay — вся прокрутка, она включает и его).
+// Возвращает прежнее соединение, если оно было.
+func (s *Session) attach(conn *websocket.Conn, replay bool) *websocket.Conn {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	old := s.conn
+	s.conn = conn
+	_ = sendSessionToken(conn, s.token)
+	notice := "\r\n\033[36m[сессия восстановлена]\033[0m\r\n"
+	var out []byte
+	if replay {
+		out = append(s.history.Bytes(), notice...)
+	} else {
+		if s.truncated {
+			notice += "\033[33m[начало вывода за время разрыва потеряно: больше 1 МБ]\033[0m\r\n"
+		}
+		out = append([]byte(notice), s.pending...)
+	}
+	if safeWrite(conn, out) == nil {
+		s.pending, s.truncated = nil, false
+	}
+	if old == conn {
+		return nil
+	}
+	return old
+}
+
+// detach снимает conn с сессии; false — сессия уже на другом соединении
+func (s *Session) detach(conn *websocket.Conn) bool {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	if s.conn != conn {
+		return false
+	}
+	s.conn = nil
+	return true
+}
+
+// trackJob/untrackJob — запущенные процессы сессии, чтобы завершить их
+// вместе с сессией
+func (s *Session) trackJob(cmd *exec.Cmd) {
+	s.mu.Lock()
+	if s.jobs == nil {
+		s.jobs = map[*exec.Cmd]struct{}{}
+	}
+	s.jobs[cmd] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *Session) untrackJob(cmd *exec.Cmd) {
+	s.mu.Lock()
+	delete(s.jobs, cmd)
+	s.mu.Unlock()
+}
+
+// busy — выполняется ли сейчас команда сессии
+func (s *Session) busy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs) > 0
+}
+
+func (s *Session) killJobs() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for cmd := range s.jobs {
+		if cmd.Process != nil {
+			_ = cmd.Process.Kill()
+		}
+	}
+}
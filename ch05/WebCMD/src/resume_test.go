@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// resumeServer — /ws на тестовом сервере со своим реестром сессий
+func resumeServer(t *testing.T, grace time.Duration) (*httptest.Server, *SessionRegistry) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("нужен sh")
+	}
+	sessions := NewSessionRegistry(grace)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ws", wsHandler(sessions))
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv, sessions
+}
+
+type consoleClient struct {
+	conn  *websocket.Conn
+	token string
+	out   strings.Builder
+}
+
+// dialConsole подключается (с token — переподключается) и читает кадр с токеном
+func dialConsole(t *testing.T, srv *httptest.Server, token string) *consoleClient {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	if token != "" {
+		url += "?resume=" + token
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	typ, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ctl struct{ Type, Token string }
+	if typ != websocket.TextMessage || json.Unmarshal(data, &ctl) != nil || ctl.Type != "session" || ctl.Token == "" {
+		t.Fatalf("first frame: %d %q", typ, data)
+	}
+	return &consoleClient{conn: conn, token: ctl.Token}
+}
+
+// readUntil читает вывод, пока в нём не появится want
+func (c *consoleClient) readUntil(t *testing.T, want string) {
+	t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for !strings.Contains(c.out.String(), want) {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %q: %v; got %q", want, err, c.out.String())
+		}
+		c.out.Write(data)
+	}
+}
+
+func lookupSession(sessions *SessionRegistry, token string) *Session {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	return sessions.byToken[token]
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout: " + what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestResumeKeepsOutputOfRunningCommand(t *testing.T) {
+	srv, sessions := resumeServer(t, time.Minute)
+	c1 := dialConsole(t, srv, "")
+	sess := lookupSession(sessions, c1.token)
+	if sess == nil {
+		t.Fatal("session not registered")
+	}
+
+	go runCmd(exec.Command("sh", "-c", "echo one; sleep 0.2; echo two; echo three"), "test", sess)
+	c1.readUntil(t, "one")
+	c1.conn.Close()
+	waitFor(t, "detach", func() bool {
+		sess.outMu.Lock()
+		defer sess.outMu.Unlock()
+		return sess.conn == nil
+	})
+	// Команда дорабатывает без соединения
+	waitFor(t, "command finished", func() bool { return !sess.busy() })
+
+	c2 := dialConsole(t, srv, c1.token)
+	if c2.token != c1.token {
+		t.Fatalf("resume issued a new token %q", c2.token)
+	}
+	c2.readUntil(t, "three")
+	if !strings.Contains(c2.out.String(), "[сессия восстановлена]") {
+		t.Fatalf("no resume notice: %q", c2.out.String())
+	}
+	all := c1.out.String() + c2.out.String()
+	i1, i2, i3 := strings.Index(all, "one\n"), strings.Index(all, "two\n"), strings.Index(all, "three\n")
+	if i1 < 0 || i2 < i1 || i3 < i2 || strings.Count(all, "two") != 1 {
+		t.Fatalf("output lost or repeated across reconnect: %q", all)
+	}
+}
+
+func TestExpiredSessionKillsJobsAndStartsFresh(t *testing.T) {
+	srv, sessions := resumeServer(t, 50*time.Millisecond)
+	c1 := dialConsole(t, srv, "")
+	sess := lookupSession(sessions, c1.token)
+
+	done := make(chan struct{})
+	go func() {
+		_ = runCmd(exec.Command("sleep", "30"), "sleep 30", sess)
+		close(done)
+	}()
+	waitFor(t, "command started", sess.busy)
+	c1.conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job survived the expired session")
+	}
+	if lookupSession(sessions, c1.token) != nil {
+		t.Fatal("expired session still registered")
+	}
+
+	c2 := dialConsole(t, srv, c1.token)
+	if c2.token == c1.token {
+		t.Fatal("expired token resumed")
+	}
+	c2.readUntil(t, "Прежняя сессия истекла")
+}
+
+func TestResumeUnknownTokenStartsFresh(t *testing.T) {
+	srv, sessions := resumeServer(t, time.Minute)
+	c := dialConsole(t, srv, "deadbeef")
+	if c.token == "deadbeef" || lookupSession(sessions, c.token) == nil {
+		t.Fatalf("token %q", c.token)
+	}
+}
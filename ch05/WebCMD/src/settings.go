@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ---------- настройки терминала ----------
+
+// Тема, размер шрифта, длина прокрутки, звонок и подтверждение опасных
+// команд хранятся на сервере (~/.webcmd/settings.json), а не в браузере, и
+// не теряются при смене машины. Входа в консоль нет, поэтому владелец
+// настроек — браузер: cookie webcmd_id, выдаётся на первой странице.
+//
+// GET /api/settings — документ владельца (без сохранённого — по умолчанию).
+// PUT /api/settings — новые значения; поля, которых нет в теле, остаются
+// прежними. Проверяется каждое поле, ошибки приходят все сразу:
+// 400 {"error": "invalid settings", "fields": {"font_size": "..."}}.
+// Одновременные PUT не сверяются — выигрывает последний, version в ответе
+// растёт с каждым сохранением.
+//
+// Страница консоли получает настройки прямо в шаблоне. scrollback — сколько
+// строк вывода сессия держит на сервере (Session.history): их получает
+// перезагруженная страница. PUT сразу меняет этот размер у всех живых сессий
+// владельца.
+
+const (
+	settingsCookie = "webcmd_id"
+
+	minFontSize   = 10
+	maxFontSize   = 32
+	minScrollback = 100
+	maxScrollback = 10000
+
+	maxHistoryLine = 4096 // строка без \n длиннее этого режется на части
+)
+
+// themes — темы, которые знает console.gohtml
+var themes = []string{"green", "amber", "white", "solarized"}
+
+// Settings — документ настроек одного владельца
+type Settings struct {
+	Version          int64  `json:"version"`
+	Theme            string `json:"theme"`
+	FontSize         int    `json:"font_size"`
+	Scrollback       int    `json:"scrollback"`
+	Bell             bool   `json:"bell"`
+	ConfirmDangerous bool   `json:"confirm_dangerous"`
+}
+
+func defaultSettings() Settings {
+	return Settings{Theme: "green", FontSize: 16, Scrollback: 1000, Bell: true, ConfirmDangerous: true}
+}
+
+// Validate — ошибки по полям (имя поля в JSON → причина); пусто — всё верно
+func (s Settings) Validate() map[string]string {
+	errs := map[string]string{}
+	if !slices.Contains(themes, s.Theme) {
+		errs["theme"] = fmt.Sprintf("unknown theme %q, want one of %v", s.Theme, themes)
+	}
+	if s.FontSize < minFontSize || s.FontSize > maxFontSize {
+		errs["font_size"] = fmt.Sprintf("must be %d..%d", minFontSize, maxFontSize)
+	}
+	if s.Scrollback < minScrollback || s.Scrollback > maxScrollback {
+		errs["scrollback"] = fmt.Sprintf("must be %d..%d lines", minScrollback, maxScrollback)
+	}
+	return errs
+}
+
+// SettingsStore — настройки по владельцам; path "" — только в памяти
+type SettingsStore struct {
+	mu      sync.Mutex
+	path    string
+	byOwner map[string]Settings
+}
+
+// settingsStore — настройки сервера; nil — у всех настройки по умолчанию
+var settingsStore *SettingsStore
+
+// defaultSettingsPath — ~/.webcmd/settings.json (пусто, если домашняя папка неизвестна)
+func defaultSettingsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".webcmd", "settings.json")
+}
+
+// OpenSettingsStore читает path; отсутствующий файл — пустое хранилище.
+func OpenSettingsStore(path string) (*SettingsStore, error) {
+	st := &SettingsStore{path: path, byOwner: map[string]Settings{}}
+	if path == "" {
+		return st, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &st.byOwner); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return st, nil
+}
+
+// Get — настройки владельца; nil-хранилище и неизвестный владелец — по умолчанию
+func (st *SettingsStore) Get(owner string) Settings {
+	if st == nil {
+		return defaultSettings()
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if s, ok := st.byOwner[owner]; ok {
+		return s
+	}
+	return defaultSettings()
+}
+
+// Put сохраняет проверенные настройки под следующей версией
+func (st *SettingsStore) Put(owner string, s Settings) (Settings, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	prev, ok := st.byOwner[owner]
+	if !ok {
+		prev = defaultSettings()
+	}
+	s.Version = prev.Version + 1
+	st.byOwner[owner] = s
+	if err := st.saveLocked(); err != nil {
+		if ok {
+			st.byOwner[owner] = prev
+		} else {
+			delete(st.byOwner, owner)
+		}
+		return Settings{}, err
+	}
+	return s, nil
+}
+
+// saveLocked пишет файл целиком: временный файл и rename
+func (st *SettingsStore) saveLocked() error {
+	if st.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(st.byOwner, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.path), 0o700); err != nil {
+		return err
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path)
+}
+
+// browserID — владелец настроек из cookie; без cookie выдаётся новый ID
+func browserID(c *gin.Context) string {
+	if id, err := c.Cookie(settingsCookie); err == nil && len(id) == 32 {
+		return id
+	}
+	id := newResumeToken()
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(settingsCookie, id, 365*24*3600, "/", "", false, true)
+	return id
+}
+
+// settingsGetHandler — GET /api/settings
+func settingsGetHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, settingsStore.Get(browserID(c)))
+}
+
+// settingsPutHandler — PUT /api/settings; новый scrollback сразу
+// применяется к живым сессиям владельца
+func settingsPutHandler(sessions *SessionRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if settingsStore == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "settings store disabled"})
+			return
+		}
+		owner := browserID(c)
+		s := settingsStore.Get(owner)
+		body, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&s); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) && typeErr.Field != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid settings", "fields": gin.H{typeErr.Field: "must be " + typeErr.Type.String()}})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json: " + err.Error()})
+			return
+		}
+		if errs := s.Validate(); len(errs) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid settings", "fields": errs})
+			return
+		}
+		saved, err := settingsStore.Put(owner, s)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, sess := range sessions.ForOwner(owner) {
+			sess.SetScrollback(saved.Scrollback)
+		}
+		c.JSON(http.StatusOK, saved)
+	}
+}
+
+// ---------- прокрутка сессии на сервере ----------
+
+// scrollback — последние max строк вывода сессии; недописанная строка
+// копится в partial. Строки хранятся с \n на конце.
+type scrollback struct {
+	max     int
+	lines   []string
+	partial []byte
+}
+
+func (b *scrollback) Write(p []byte) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			b.partial = append(b.partial, p...)
+			for len(b.partial) >= maxHistoryLine {
+				b.push(string(b.partial[:maxHistoryLine]))
+				b.partial = append(b.partial[:0], b.partial[maxHistoryLine:]...)
+			}
+			return
+		}
+		b.partial = append(b.partial, p[:i+1]...)
+		b.push(string(b.partial))
+		b.partial = b.partial[:0]
+		p = p[i+1:]
+	}
+}
+
+// push добавляет строку; лишнее срезается раз в max строк, а не на каждой
+func (b *scrollback) push(line string) {
+	b.lines = append(b.lines, line)
+	if len(b.lines) >= 2*b.max {
+		b.lines = append(b.lines[:0], b.lines[len(b.lines)-b.max:]...)
+	}
+}
+
+// Resize меняет число хранимых строк; при уменьшении старые отбрасываются сразу
+func (b *scrollback) Resize(n int) {
+	b.max = n
+	if len(b.lines) > n {
+		b.lines = slices.Clone(b.lines[len(b.lines)-n:])
+	}
+}
+
+// Lines — хранимые строки, старые первыми (без недописанной)
+func (b *scrollback) Lines() []string {
+	return b.lines[max(0, len(b.lines)-b.max):]
+}
+
+// Bytes — весь хранимый вывод вместе с недописанной строкой
+func (b *scrollback) Bytes() []byte {
+	var out []byte
+	for _, line := range b.Lines() {
+		out = append(out, line...)
+	}
+	return append(out, b.partial...)
+}
+
+// SetScrollback — новая длина прокрутки сессии
+func (s *Session) SetScrollback(n int) {
+	s.outMu.Lock()
+	s.history.Resize(n)
+	s.outMu.Unlock()
+}
+
+// ForOwner — живые сессии владельца настроек
+func (r *SessionRegistry) ForOwner(owner string) []*Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []*Session
+	for _, s := range r.byToken {
+		if s.owner == owner {
+			list = append(list, s)
+		}
+	}
+	return list
+}
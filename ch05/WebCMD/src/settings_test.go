@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSettingsValidate(t *testing.T) {
+	if errs := defaultSettings().Validate(); len(errs) != 0 {
+		t.Fatalf("defaults invalid: %v", errs)
+	}
+	bad := Settings{Theme: "pink", FontSize: 9, Scrollback: maxScrollback + 1}
+	errs := bad.Validate()
+	for _, field := range []string{"theme", "font_size", "scrollback"} {
+		if errs[field] == "" {
+			t.Fatalf("no error for %s: %v", field, errs)
+		}
+	}
+}
+
+func TestScrollbackKeepsLastLines(t *testing.T) {
+	b := scrollback{max: 3}
+	for i := 1; i <= 10; i++ {
+		b.Write([]byte(fmt.Sprintf("line %d\n", i)))
+	}
+	b.Write([]byte("pro"))
+	b.Write([]byte("mpt"))
+	if got := strings.Join(b.Lines(), ""); got != "line 8\nline 9\nline 10\n" {
+		t.Fatalf("lines %q", got)
+	}
+	if got := string(b.Bytes()); got != "line 8\nline 9\nline 10\nprompt" {
+		t.Fatalf("bytes %q", got)
+	}
+	b.Resize(1)
+	if got := strings.Join(b.Lines(), ""); got != "line 10\n" || len(b.lines) != 1 {
+		t.Fatalf("after Resize(1): %q", b.lines)
+	}
+
+	b = scrollback{max: 5}
+	b.Write([]byte(strings.Repeat("x", maxHistoryLine+10)))
+	if len(b.lines) != 1 || len(b.partial) != 10 {
+		t.Fatalf("long line: %d lines, partial %d", len(b.lines), len(b.partial))
+	}
+}
+
+func settingsRouter(t *testing.T, sessions *SessionRegistry) *gin.Engine {
+	t.Helper()
+	st, err := OpenSettingsStore(filepath.Join(t.TempDir(), "settings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settingsStore = st
+	t.Cleanup(func() { settingsStore = nil })
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/settings", settingsGetHandler)
+	r.PUT("/api/settings", settingsPutHandler(sessions))
+	return r
+}
+
+func settingsCall(r *gin.Engine, method, owner, body string) (*httptest.ResponseRecorder, map[string]any) {
+	req := httptest.NewRequest(method, "/api/settings", strings.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: settingsCookie, Value: owner})
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	var out map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &out)
+	return rec, out
+}
+
+func TestSettingsPutAndGet(t *testing.T) {
+	sessions := NewSessionRegistry(time.Minute)
+	r := settingsRouter(t, sessions)
+	owner := strings.Repeat("a", 32)
+
+	sess := newSession(nil, owner)
+	sessions.Add(sess)
+	for i := 0; i < 500; i++ {
+		sess.history.Write([]byte("out\n"))
+	}
+
+	_, got := settingsCall(r, http.MethodGet, owner, "")
+	if got["theme"] != "green" || got["version"] != 0.0 {
+		t.Fatalf("defaults: %v", got)
+	}
+
+	rec, got := settingsCall(r, http.MethodPut, owner, `{"theme":"amber","scrollback":200}`)
+	if rec.Code != http.StatusOK || got["theme"] != "amber" || got["font_size"] != 16.0 || got["version"] != 1.0 {
+		t.Fatalf("first PUT %d: %v", rec.Code, got)
+	}
+	if n := len(sess.history.Lines()); n != 200 {
+		t.Fatalf("live session keeps %d lines after scrollback 200", n)
+	}
+
+	// Последний PUT выигрывает, version растёт
+	_, got = settingsCall(r, http.MethodPut, owner, `{"theme":"white"}`)
+	if got["theme"] != "white" || got["scrollback"] != 200.0 || got["version"] != 2.0 {
+		t.Fatalf("second PUT: %v", got)
+	}
+	_, got = settingsCall(r, http.MethodGet, owner, "")
+	if got["theme"] != "white" || got["version"] != 2.0 {
+		t.Fatalf("GET after PUT: %v", got)
+	}
+
+	// Настройки другого браузера не тронуты
+	if _, other := settingsCall(r, http.MethodGet, strings.Repeat("b", 32), ""); other["theme"] != "green" {
+		t.Fatalf("other owner: %v", other)
+	}
+
+	// Хранилище переживает перезапуск
+	reopened, err := OpenSettingsStore(settingsStore.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := reopened.Get(owner); s.Theme != "white" || s.Version != 2 {
+		t.Fatalf("reopened: %+v", s)
+	}
+}
+
+func TestSettingsPutFieldErrors(t *testing.T) {
+	r := settingsRouter(t, NewSessionRegistry(time.Minute))
+	owner := strings.Repeat("c", 32)
+	for _, tc := range []struct {
+		body   string
+		fields []string
+	}{
+		{`{"theme":"pink","font_size":99}`, []string{"theme", "font_size"}},
+		{`{"scrollback":"lots"}`, []string{"scrollback"}},
+		{`{"color":"red"}`, nil},
+	} {
+		rec, got := settingsCall(r, http.MethodPut, owner, tc.body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d", tc.body, rec.Code)
+		}
+		fields, _ := got["fields"].(map[string]any)
+		if len(fields) != len(tc.fields) {
+			t.Fatalf("%s: %v", tc.body, got)
+		}
+		for _, f := range tc.fields {
+			if fields[f] == nil {
+				t.Fatalf("%s: no error for %s: %v", tc.body, f, got)
+			}
+		}
+	}
+	if s := settingsStore.Get(owner); s.Version != 0 {
+		t.Fatalf("rejected PUT saved: %+v", s)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ---------- ЗДОРОВЬЕ И ПОТРЕБЛЕНИЕ РЕСУРСОВ ----------
+
+// GET /healthz — снимок состояния сервера: аптайм, сессии, запущенные
+// команды, горутины и память процесса (runtime.MemStats).
+// GET /api/usage — последние usageWindow таких замеров (для графика в
+// консоли). Сэмплер — один тикер раз в usageInterval: ReadMemStats стоит
+// микросекунды, в простое больше ничего не делается.
+//
+// Подсказка по памяти (-mem-hint, МБ): пока команда работает, раз в
+// memHintInterval опрашивается рабочий набор дочернего процесса (API
+// ОС — procmem_*.go) и при превышении порога в консоль один раз пишется
+// предупреждение. Опрашивается сам дочерний процесс (cmd/powershell), а не
+// его потомки.
+
+const (
+	usageInterval   = 10 * time.Second
+	usageWindow     = 15 * time.Minute
+	memHintInterval = 2 * time.Second
+)
+
+var (
+	startTime = time.Now()
+
+	activeSessions  atomic.Int64 // открытые WebSocket-подключения
+	runningCommands atomic.Int64 // системные команды, которые сейчас выполняются
+
+	// memHintBytes — порог рабочего набора команды (0 — без подсказки)
+	memHintBytes uint64
+)
+
+// MemInfo — память процесса сервера
+type MemInfo struct {
+	Alloc     uint64 `json:"alloc"`
+	HeapInuse uint64 `json:"heap_inuse"`
+	Sys       uint64 `json:"sys"`
+	NumGC     uint32 `json:"num_gc"`
+}
+
+// Health — ответ /healthz
+type Health struct {
+	Status          string  `json:"status"`
+	UptimeSec       int64   `json:"uptime_sec"`
+	Sessions        int64   `json:"sessions"`
+	RunningCommands int64   `json:"running_commands"`
+	Goroutines      int     `json:"goroutines"`
+	Memory          MemInfo `json:"memory"`
+}
+
+func readHealth(now time.Time) Health {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return Health{
+		Status:          "ok",
+		UptimeSec:       int64(now.Sub(startTime).Seconds()),
+		Sessions:        activeSessions.Load(),
+		RunningCommands: runningCommands.Load(),
+		Goroutines:      runtime.NumGoroutine(),
+		Memory:          MemInfo{Alloc: ms.Alloc, HeapInuse: ms.HeapInuse, Sys: ms.Sys, NumGC: ms.NumGC},
+	}
+}
+
+// UsageSample — один замер для графика
+type UsageSample struct {
+	Time       time.Time `json:"time"`
+	Alloc      uint64    `json:"alloc"`
+	Sys        uint64    `json:"sys"`
+	Goroutines int       `json:"goroutines"`
+	Sessions   int64     `json:"sessions"`
+	Running    int64     `json:"running"`
+}
+
+// UsageRing — кольцевой буфер последних замеров
+type UsageRing struct {
+	mu      sync.Mutex
+	samples []UsageSample
+	next    int
+	full    bool
+}
+
+func NewUsageRing(size int) *UsageRing {
+	return &UsageRing{samples: make([]UsageSample, size)}
+}
+
+// Add записывает замер поверх самого старого
+func (r *UsageRing) Add(s UsageSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Snapshot — замеры от старых к новым
+func (r *UsageRing) Snapshot() []UsageSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]UsageSample(nil), r.samples[:r.next]...)
+	}
+	out := make([]UsageSample, 0, len(r.samples))
+	out = append(out, r.samples[r.next:]...)
+	return append(out, r.samples[:r.next]...)
+}
+
+// UsageSampler раз в every пишет замер в кольцо, пока не вызван Stop
+type UsageSampler struct {
+	ring *UsageRing
+	stop chan struct{}
+	done chan struct{}
+}
+
+func StartUsageSampler(ring *UsageRing, every time.Duration) *UsageSampler {
+	s := &UsageSampler{ring: ring, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				h := readHealth(now)
+				ring.Add(UsageSample{Time: now, Alloc: h.Memory.Alloc, Sys: h.Memory.Sys,
+					Goroutines: h.Goroutines, Sessions: h.Sessions, Running: h.RunningCommands})
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Stop останавливает сэмплер и ждёт его горутину
+func (s *UsageSampler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, readHealth(time.Now()))
+}
+
+// usageHandler — GET /api/usage
+func usageHandler(ring *UsageRing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"interval_sec": int(usageInterval.Seconds()), "samples": ring.Snapshot()})
+	}
+}
+
+// watchCommandMemory опрашивает рабочий набор процесса pid, пока не закрыт
+// stop, и один раз предупреждает в консоли, если он больше memHintBytes.
+func watchCommandMemory(pid int, sess *Session, stop <-chan struct{}) {
+	t := time.NewTicker(memHintInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			ws, err := processWorkingSet(pid)
+			if err != nil {
+				log.Printf("mem hint: pid %d: %v\n", pid, err)
+				return
+			}
+			if ws > memHintBytes {
+				_ = sess.write([]byte(fmt.Sprintf("\r\n\033[33mВнимание: команда занимает %d МБ памяти (порог %d МБ)\033[0m\r\n",
+					ws>>20, memHintBytes>>20)))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUsageRingKeepsLastSamples(t *testing.T) {
+	r := NewUsageRing(3)
+	if got := r.Snapshot(); len(got) != 0 {
+		t.Fatalf("empty ring: %v", got)
+	}
+	for i := 1; i <= 5; i++ {
+		r.Add(UsageSample{Goroutines: i})
+		got := r.Snapshot()
+		want := min(i, 3)
+		if len(got) != want || got[len(got)-1].Goroutines != i || got[0].Goroutines != i-want+1 {
+			t.Fatalf("after %d adds: %+v", i, got)
+		}
+	}
+}
+
+func TestUsageSamplerFillsRingAndStops(t *testing.T) {
+	ring := NewUsageRing(10)
+	s := StartUsageSampler(ring, time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(ring.Snapshot()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	s.Stop()
+	n := len(ring.Snapshot())
+	if n < 3 {
+		t.Fatalf("sampler wrote %d samples", n)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if len(ring.Snapshot()) != n {
+		t.Fatal("sampler kept writing after Stop")
+	}
+}
+
+func TestHealthzShape(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/healthz", healthzHandler)
+	ring := NewUsageRing(2)
+	ring.Add(UsageSample{Alloc: 1})
+	r.GET("/api/usage", usageHandler(ring))
+
+	activeSessions.Add(2)
+	defer activeSessions.Add(-2)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"status", "uptime_sec", "sessions", "running_commands", "goroutines", "memory"} {
+		if _, ok := body[key]; !ok {
+			t.Fatalf("healthz has no %q: %s", key, rec.Body)
+		}
+	}
+	mem, _ := body["memory"].(map[string]any)
+	for _, key := range []string{"alloc", "heap_inuse", "sys", "num_gc"} {
+		if _, ok := mem[key]; !ok {
+			t.Fatalf("healthz memory has no %q: %s", key, rec.Body)
+		}
+	}
+	if body["status"] != "ok" || body["sessions"] != 2.0 || body["goroutines"].(float64) < 1 {
+		t.Fatalf("healthz values: %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/usage", nil))
+	var usage struct {
+		IntervalSec int           `json:"interval_sec"`
+		Samples     []UsageSample `json:"samples"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil || usage.IntervalSec != 10 || len(usage.Samples) != 1 {
+		t.Fatalf("usage: %v %s", err, rec.Body)
+	}
+}
+
+func TestProcessWorkingSetOfSelf(t *testing.T) {
+	ws, err := processWorkingSet(os.Getpid())
+	if err != nil {
+		t.Skipf("not supported here: %v", err)
+	}
+	if ws == 0 {
+		t.Fatal("own working set should not be zero")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ---------- APP (весь стек обработчиков) ----------
+
+// defaultCartID — корзина запросов без X-Cart-ID/cart_id (как раньше —
+// одна общая корзина)
+const defaultCartID = "default"
+
+// ---------- истечение корзин ----------
+
+// Корзина истекает через TTL после последнего изменения (или создания):
+// чтение срок не продлевает. Истёкшую корзину убирает janitor раз в
+// cartJanitorEvery, а если к ней обратились раньше, Get сам заменяет её
+// новой пустой — клиент просто получает пустую корзину. Резерв на
+// подарочной карте освобождается, непустая корзина попадает в брошенные
+// (export.go). Когда корзина истечёт без изменений, сообщает заголовок
+// X-Cart-Expires-At в ответах с корзиной.
+
+const (
+	defaultCartTTL    = 24 * time.Hour // -cart-ttl
+	cartJanitorEvery  = time.Minute
+	cartExpiresHeader = "X-Cart-Expires-At"
+)
+
+// CartStore — корзины по ID сессии, создаются при первом обращении
+type CartStore struct {
+	mu     sync.Mutex
+	carts  map[string]*CartService
+	now    func() time.Time
+	TTL    time.Duration // срок корзины после изменения; 0 — не истекают
+	Limits CartLimits    // пределы новых корзин (limits.go)
+
+	catalog *Catalog // передаются новым корзинам
+	prices  *PriceResolver
+
+	abandoned []AbandonedCart // истёкшие непустые корзины (export.go)
+	writer    *cartWriter     // nil — корзины только в памяти (storage.go)
+}
+
+func NewCartStore() *CartStore {
+	return &CartStore{carts: make(map[string]*CartService), now: time.Now, TTL: defaultCartTTL, Limits: DefaultCartLimits()}
+}
+
+// Get возвращает корзину сессии id, создавая пустую при необходимости;
+// истёкшая заменяется новой пустой
+func (cs *CartStore) Get(id string) *CartService {
+	cs.mu.Lock()
+	c, ok := cs.carts[id]
+	var expired *CartService
+	if ok && cs.expiredLocked(c) {
+		expired, ok = c, false
+	}
+	if !ok {
+		c = cs.newCartLocked(id)
+	}
+	cs.mu.Unlock()
+	if expired != nil {
+		cs.retire([]*CartService{expired})
+	}
+	return c
+}
+
+// newCartLocked заводит пустую корзину id
+func (cs *CartStore) newCartLocked(id string) *CartService {
+	c := NewCartService()
+	c.id = id
+	c.catalog, c.prices = cs.catalog, cs.prices
+	c.limits = cs.Limits
+	c.onChange = cs.markChanged
+	c.now = cs.now
+	c.modified = cs.now()
+	cs.carts[id] = c
+	return c
+}
+
+// expiresAt — когда корзина истечёт, если её больше не менять
+func (cs *CartStore) expiresAt(c *CartService) time.Time {
+	return c.Modified().Add(cs.TTL)
+}
+
+func (cs *CartStore) expiredLocked(c *CartService) bool {
+	return cs.TTL > 0 && !cs.now().Before(cs.expiresAt(c))
+}
+
+// Expire удаляет истёкшие корзины и освобождает их резервы на подарочных
+// картах. Возвращает число удалённых.
+func (cs *CartStore) Expire() int {
+	cs.mu.Lock()
+	var expired []*CartService
+	for id, c := range cs.carts {
+		if cs.expiredLocked(c) {
+			expired = append(expired, c)
+			delete(cs.carts, id)
+		}
+	}
+	cs.mu.Unlock()
+	cs.retire(expired)
+	return len(expired)
+}
+
+// retire закрывает корзины, уже убранные из cs.carts: хранилище узнаёт об
+// их удалении, резервы освобождаются, непустые идут в брошенные
+func (cs *CartStore) retire(expired []*CartService) {
+	var abandoned []AbandonedCart
+	for _, c := range expired {
+		modified := c.Modified()
+		cs.markChanged(c.id)
+		c.RemoveGiftCard(context.Background())
+		if items := c.Items(); len(items) > 0 {
+			abandoned = append(abandoned, AbandonedCart{ID: c.id, Items: items, LastUsed: modified})
+		}
+	}
+	if len(abandoned) == 0 {
+		return
+	}
+	cs.mu.Lock()
+	cs.abandoned = append(cs.abandoned, abandoned...)
+	if over := len(cs.abandoned) - maxAbandonedCarts; over > 0 {
+		cs.abandoned = append([]AbandonedCart(nil), cs.abandoned[over:]...)
+	}
+	cs.mu.Unlock()
+}
+
+// StartJanitor раз в every удаляет истёкшие корзины, пока не отменён ctx;
+// возвращённый канал закрывается, когда janitor остановился
+func (cs *CartStore) StartJanitor(ctx context.Context, every time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				cs.Expire()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// cartID — ID корзины из заголовка X-Cart-ID или cookie cart_id
+func cartID(r *http.Request) string {
+	if id := r.Header.Get("X-Cart-ID"); id != "" {
+		return id
+	}
+	if c, err := r.Cookie("cart_id"); err == nil && c.Value != "" {
+		return c.Value
+	}
+	return defaultCartID
+}
+
+// App собирает хранилище, маршруты и middleware. Всё состояние живёт в App,
+// поэтому каждый NewApp — чистый сервер (тесты поднимают свой на каждый сценарий).
+// Now — часы приложения; всё, что зависит от времени, берёт его отсюда.
+// AdminToken закрывает /admin/* (пустой — эндпоинты выключены).
+// Prices считает цены по тем же часам Now.
+// Logger — лог запросов и событий (trace.go), Events — подписчик на события
+// (nil — только лог).
+type App struct {
+	Carts      *CartStore
+	GiftCards  *GiftCardStore
+	Coupons    *CouponStore
+	Catalog    *Catalog
+	Prices     *PriceResolver
+	Orders     *OrderLog
+	Limiter    *RateLimiter
+	Now        func() time.Time
+	AdminToken string
+	Logger     *log.Logger
+	Events     func(Event)
+}
+
+func NewApp(rlCfg RateLimitConfig, now func() time.Time) *App {
+	limiter := NewRateLimiter(rlCfg)
+	limiter.now = now
+	catalog, prices := NewCatalog(), NewPriceResolver(now)
+	carts := NewCartStore()
+	carts.now = now
+	carts.catalog, carts.prices = catalog, prices
+	return &App{
+		Carts:     carts,
+		GiftCards: NewGiftCardStore(),
+		Coupons:   NewCouponStore(),
+		Catalog:   catalog,
+		Prices:    prices,
+		Orders:    NewOrderLog(now),
+		Limiter:   limiter,
+		Now:       now,
+		Logger:    log.New(os.Stderr, "", log.LstdFlags),
+	}
+}
+
+func (a *App) cartFor(r *http.Request) *CartService {
+	return a.Carts.Get(cartID(r))
+}
+
+// writeCart — 200 с body и сроком корзины в X-Cart-Expires-At
+func (a *App) writeCart(w http.ResponseWriter, cart *CartService, body interface{}) {
+	if a.Carts.TTL > 0 {
+		w.Header().Set(cartExpiresHeader, isoTime(a.Carts.expiresAt(cart)))
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// Handler — маршруты под rate limit middleware, снаружи — Trace
+func (a *App) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/cart", methods{http.MethodGet: a.handleGet, http.MethodDelete: a.handleClear})
+	mux.Handle("/cart/items", methods{http.MethodPost: a.handleAdd})
+	mux.HandleFunc(cartItemsPrefix, a.handleCartItem)
+	mux.Handle("/cart/items/bulk", methods{http.MethodPost: a.handleBulkAdd})
+	// Устаревшие псевдонимы (routes.go)
+	mux.Handle("/cart/add", deprecated("/cart/items", methods{http.MethodPost: a.handleAdd}))
+	mux.Handle("/cart/update", deprecated(cartItemsPrefix+"{productID}", methods{http.MethodPost: a.handleUpdate}))
+	mux.Handle("/cart/get", deprecated("/cart", methods{http.MethodGet: a.handleGet}))
+	mux.Handle("/cart/remove", deprecated(cartItemsPrefix+"{productID}", methods{http.MethodPost: a.handleRemove}))
+	mux.Handle("/cart/clear", deprecated("/cart", methods{http.MethodPost: a.handleClear}))
+	mux.HandleFunc("/cart/giftcard", a.handleApplyGiftCard)
+	mux.HandleFunc("/cart/giftcard/remove", a.handleRemoveGiftCard)
+	mux.HandleFunc("/cart/coupon", a.handleApplyCoupon)
+	mux.HandleFunc("/cart/coupon/remove", a.handleRemoveCoupon)
+	mux.HandleFunc("/cart/checkout", a.handleCheckout)
+	mux.HandleFunc("/orders", a.handleOrders)
+	mux.HandleFunc("/admin/giftcards", a.handleMintGiftCard)
+	mux.HandleFunc("/admin/coupons", a.handlePutCoupon)
+	mux.HandleFunc("/admin/products", a.handlePutProduct)
+	mux.HandleFunc("/admin/export/orders", a.handleExportOrders)
+	mux.HandleFunc("/admin/export/carts", a.handleExportCarts)
+	mux.HandleFunc("/products", a.handleProducts)
+	mux.HandleFunc("/products/get", a.handleGetProduct)
+	// Неизвестный путь — тот же JSON-конверт ошибки, а не text/plain
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, errNotFound)
+	})
+	return a.Trace(RateLimit(a.Limiter, mux))
+}
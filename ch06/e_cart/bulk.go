@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ---------- BULK ----------
+
+// POST /cart/items/bulk — положить в корзину много строк одним запросом
+// (восстановление сохранённой корзины):
+//
+//	{"items": [{"product_id": "p1", "quantity": 2}, ...], "expected_version": 3}
+//
+// Строки проверяются все сразу и применяются вместе или никак: если хоть
+// одна не подходит (нет товара, количество < 1, сверх предела корзины, не
+// хватает на складе), в корзину не попадает ничего, а ответ 422 перечисляет
+// ошибки строк с их индексами — в том же виде, что ответ на одиночный
+// POST /cart/items (code, error, подробности вроде available, limit). Повтор
+// товара в запросе складывается, остаток и предел сверяются с суммой.
+// Успех — корзина, версия растёт один раз.
+
+// maxBulkLines — предел строк в одном запросе
+const maxBulkLines = 100
+
+// errBulkRejected — часть строк не подошла; подробности — в *BulkAddError
+var errBulkRejected = errors.New("some items were rejected, nothing was added")
+
+// BulkLine — строка массового добавления
+type BulkLine struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// BulkAddRequest : строки для добавления. ExpectedVersion — как If-Match.
+type BulkAddRequest struct {
+	Items           []BulkLine `json:"items"`
+	ExpectedVersion *int64     `json:"expected_version,omitempty"`
+}
+
+// LineError — ошибка строки Index запроса
+type LineError struct {
+	Index int
+	Err   error
+}
+
+// BulkAddError — строки, из-за которых AddAll ничего не добавил
+type BulkAddError struct {
+	Lines []LineError
+}
+
+func (e *BulkAddError) Error() string {
+	return fmt.Sprintf("%d of the items rejected, nothing was added", len(e.Lines))
+}
+
+func (e *BulkAddError) Unwrap() error { return errBulkRejected }
+
+// isBulkAdd — errors.As для BulkAddError
+func isBulkAdd(err error) (*BulkAddError, bool) {
+	var be *BulkAddError
+	ok := errors.As(err, &be)
+	return be, ok
+}
+
+// AddAll добавляет строки как Add, но все под одной блокировкой: сначала
+// проверяет каждую, и если хоть одна не подходит — возвращает
+// *BulkAddError со всеми ошибками и корзину не трогает.
+func (s *CartService) AddAll(ctx context.Context, lines []BulkLine) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return err
+	}
+
+	var ids []string             // товары в порядке первого упоминания
+	want := make(map[string]int) // ID товара → штук после добавления
+	distinct := len(s.items)     // строк корзины после добавления
+	products := make(map[string]Product)
+	var rejected []LineError
+	for i, l := range lines {
+		if l.Quantity <= 0 {
+			rejected = append(rejected, LineError{Index: i, Err: errQuantityMin1})
+			continue
+		}
+		p, ok := s.catalog.Get(l.ProductID)
+		if !ok {
+			rejected = append(rejected, LineError{Index: i, Err: fmt.Errorf("%s: %w", l.ProductID, errProductNotFound)})
+			continue
+		}
+		qty, seen := want[p.ID]
+		_, inCart := s.items[p.ID]
+		if !seen {
+			qty = s.items[p.ID].Quantity
+		}
+		if !seen && !inCart {
+			if err := s.limits.checkLines(distinct + 1); err != nil {
+				rejected = append(rejected, LineError{Index: i, Err: err})
+				continue
+			}
+		}
+		if err := s.limits.checkQuantity(p.ID, qty, l.Quantity); err != nil {
+			rejected = append(rejected, LineError{Index: i, Err: err})
+			continue
+		}
+		if err := checkStock(p, qty, l.Quantity); err != nil {
+			rejected = append(rejected, LineError{Index: i, Err: err})
+			continue
+		}
+		if !seen {
+			ids = append(ids, p.ID)
+			products[p.ID] = p
+			if !inCart {
+				distinct++
+			}
+		}
+		want[p.ID] = qty + l.Quantity
+	}
+	if len(rejected) > 0 {
+		return &BulkAddError{Lines: rejected}
+	}
+
+	for _, id := range ids {
+		it, ok := s.items[id]
+		if !ok {
+			it = Item{Product: products[id]}
+		}
+		s.acceptLocked(it.withQuantity(want[id]))
+	}
+	s.repriceLocked()
+	s.bumpLocked()
+	return nil
+}
+
+// handleBulkAdd — POST /cart/items/bulk
+func (a *App) handleBulkAdd(w http.ResponseWriter, r *http.Request) {
+	cart := a.cartFor(r)
+	var req BulkAddRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, errInvalidJSON)
+		return
+	}
+	switch {
+	case len(req.Items) == 0:
+		writeError(w, fieldError(codeMissingField, "items", "items required"))
+		return
+	case len(req.Items) > maxBulkLines:
+		writeError(w, fieldError(codeInvalidParam, "items", fmt.Sprintf("at most %d items per request", maxBulkLines)))
+		return
+	}
+	r, err := expectVersion(r, req.ExpectedVersion)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := cart.AddAll(r.Context(), req.Items); err != nil {
+		writeError(w, err)
+		return
+	}
+	a.writeCart(w, cart, cart.ToCart())
+}
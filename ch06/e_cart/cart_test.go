@@ -1,41 +1,827 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
-func TestAddAndTotal(t *testing.T) {
+// stockedCart — корзина с каталогом из products
+func stockedCart(t *testing.T, products ...Product) *CartService {
+	t.Helper()
 	s := NewCartService()
-	s.Add(Product{ID: "p1", Name: "A", Price: 2.5}, 2)
-	s.Add(Product{ID: "p2", Name: "B", Price: 1.0}, 1)
-	if got := s.Total(); got != 6.0 {
-		t.Fatalf("expected total 6.0, got %v", got)
+	s.catalog = NewCatalog()
+	for _, p := range products {
+		if err := s.catalog.Put(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+var (
+	productA = Product{ID: "p1", Name: "A", Price: 250, Stock: 1000}
+	productB = Product{ID: "p2", Name: "B", Price: 100, Stock: 1000}
+)
+
+func TestAddAndTotal(t *testing.T) {
+	s := stockedCart(t, productA, productB)
+	s.Add(context.Background(), "p1", 2)
+	s.Add(context.Background(), "p2", 1)
+	if got := s.Total(); got != 600 {
+		t.Fatalf("expected total 6.00, got %v", got)
 	}
 }
 
 func TestUpdateAndRemove(t *testing.T) {
-	s := NewCartService()
-	s.Add(Product{ID: "p1", Name: "A", Price: 2.5}, 2)
-	if err := s.Update("p1", 5); err != nil {
+	s := stockedCart(t, productA)
+	s.Add(context.Background(), "p1", 2)
+	if err := s.Update(context.Background(), "p1", 5); err != nil {
 		t.Fatal(err)
 	}
-	if got := s.Total(); got != 12.5 {
-		t.Fatalf("expected 12.5, got %v", got)
+	if got := s.Total(); got != 1250 {
+		t.Fatalf("expected 12.50, got %v", got)
 	}
 	// remove
-	s.Remove("p1")
+	s.Remove(context.Background(), "p1")
 	if got := s.Total(); got != 0 {
 		t.Fatalf("expected 0 after remove, got %v", got)
 	}
 }
 
 func TestClear(t *testing.T) {
-	s := NewCartService()
-	s.Add(Product{ID: "p1", Name: "A", Price: 2.5}, 2)
-	s.Clear()
+	s := stockedCart(t, productA)
+	s.Add(context.Background(), "p1", 2)
+	s.Clear(context.Background())
 	if got := s.Total(); got != 0 {
 		t.Fatalf("expected 0 after clear, got %v", got)
 	}
 }
 
+// Строки идут в порядке добавления, и каждое изменение — новая версия
+func TestItemOrderAndVersion(t *testing.T) {
+	productC := Product{ID: "p0", Name: "C", Price: 50, Stock: 1000}
+	s := stockedCart(t, productA, productB, productC)
+	ctx := context.Background()
+	ids := func() string {
+		var out []string
+		for _, it := range s.ToCart().Items {
+			out = append(out, it.Product.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	s.Add(ctx, "p2", 1)
+	s.Add(ctx, "p0", 1)
+	s.Add(ctx, "p1", 1)
+	for i := 0; i < 20; i++ {
+		if got := ids(); got != "p2,p0,p1" {
+			t.Fatalf("call %d: order %s", i, got)
+		}
+	}
+	s.Add(ctx, "p2", 3)
+	s.Update(ctx, "p0", 5)
+	if got := ids(); got != "p2,p0,p1" {
+		t.Fatalf("after add/update: %s", got)
+	}
+	s.Remove(ctx, "p2")
+	s.Add(ctx, "p2", 1)
+	if got := ids(); got != "p0,p1,p2" {
+		t.Fatalf("re-added item goes last: %s", got)
+	}
+	if v := s.ToCart().Version; v != 7 {
+		t.Fatalf("version %d after 7 changes", v)
+	}
+
+	// Ничего не изменилось — версия та же
+	s.Update(ctx, "nope", 0)
+	if err := s.Remove(ctx, "nope"); !errors.Is(err, errNotInCart) {
+		t.Fatalf("remove missing: %v", err)
+	}
+	if v := s.ToCart().Version; v != 7 {
+		t.Fatalf("version %d after no-op changes", v)
+	}
+
+	stale := withExpectedVersion(ctx, 6)
+	if err := s.Clear(stale); err == nil {
+		t.Fatal("clear with a stale version")
+	} else if ve, ok := isVersionConflict(err); !ok || ve.Current != 7 {
+		t.Fatalf("conflict: %v", err)
+	}
+	if err := s.Clear(withExpectedVersion(ctx, 7)); err != nil || len(s.Items()) != 0 {
+		t.Fatalf("clear with the current version: %v", err)
+	}
+}
+
+// Цена строки — всегда из каталога: Update и Total видят его изменения,
+// неизвестный ID в корзину не попадает
+func TestAddUsesCatalogPrice(t *testing.T) {
+	s := stockedCart(t, productA)
+	if err := s.Add(context.Background(), "nope", 1); !errors.Is(err, errProductNotFound) {
+		t.Fatalf("unknown product: %v", err)
+	}
+	s.Add(context.Background(), "p1", 2)
+	dearer := productA
+	dearer.Price = 300
+	s.catalog.Put(dearer)
+	if err := s.Update(context.Background(), "p1", 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Total(); got != 1200 {
+		t.Fatalf("expected catalog price 3 x 4 = 12, got %v", got)
+	}
+}
+
+// Деньги считаются в центах: 3 × 0.10 — ровно 0.30, и в JSON тоже
+func TestMoneyCentsNoFloatDrift(t *testing.T) {
+	s := stockedCart(t, Product{ID: "dime", Name: "Гвоздь", Price: 10, Stock: 1000})
+	s.Add(context.Background(), "dime", 3)
+	data, err := json.Marshal(s.ToCart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Total() != 30 || !strings.Contains(string(data), `"total":0.30`) || !strings.Contains(string(data), `"unit_price":0.10`) {
+		t.Fatalf("total %d, json %s", s.Total(), data)
+	}
+}
+
+// Больше остатка в корзину не положить; checkout списывает все строки
+// разом или ничего
+func TestStockCheckedOnAddAndTakenOnCheckout(t *testing.T) {
+	a, b := productA, productB
+	a.Stock, b.Stock = 5, 1
+	first := stockedCart(t, a, b)
+	second := NewCartService()
+	second.catalog = first.catalog
+
+	first.Add(context.Background(), "p1", 2)
+	if err := first.Add(context.Background(), "p1", 4); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("add over stock: %v", err)
+	}
+	if err := first.Update(context.Background(), "p1", 6); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("update over stock: %v", err)
+	}
+	first.Add(context.Background(), "p2", 1)
+	second.Add(context.Background(), "p1", 3)
+	second.Add(context.Background(), "p2", 1) // последнюю штуку держат обе корзины
+
+	if _, err := first.Checkout(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, err := second.Checkout(context.Background())
+	se, ok := isInsufficientStock(err)
+	if !ok || se.ProductID != "p2" || se.Requested != 1 || se.Available != 0 {
+		t.Fatalf("second checkout: %v", err)
+	}
+	if p, _ := first.catalog.Get("p1"); p.Stock != 3 {
+		t.Fatalf("failed checkout must not take p1: stock %d", p.Stock)
+	}
+	if len(second.Items()) != 2 {
+		t.Fatalf("failed checkout must keep the cart: %+v", second.Items())
+	}
+	if err := NewCatalog().Put(Product{ID: "x", Price: 100, Stock: -1}); err == nil {
+		t.Fatal("negative stock accepted")
+	}
+}
+
+// Нулевое, отрицательное или переполненное количество не проходит проверку
+// остатка и не списывается: иначе checkout прибавил бы к складу
+func TestStockRejectsNonPositiveQuantity(t *testing.T) {
+	a := productA
+	a.Stock = 5
+	for _, qty := range []int{0, -1, math.MinInt} {
+		if err := checkStock(a, 0, qty); !errors.Is(err, errQuantityMin1) {
+			t.Errorf("checkStock(%d): %v", qty, err)
+		}
+	}
+
+	s := stockedCart(t, a, productB)
+	for _, lines := range []map[string]int{
+		{"p1": 0},
+		{"p1": -5},
+		{"p1": math.MinInt},
+		{"p1": 1, "p2": -1}, // Плохая строка отменяет и хорошую
+	} {
+		if err := s.catalog.Take(context.Background(), lines); !errors.Is(err, errQuantityMin1) {
+			t.Errorf("Take(%v): %v", lines, err)
+		}
+	}
+	if p, _ := s.catalog.Get("p1"); p.Stock != 5 {
+		t.Fatalf("rejected Take changed stock: %d", p.Stock)
+	}
+	if p, _ := s.catalog.Get("p2"); p.Stock != productB.Stock {
+		t.Fatalf("rejected Take changed stock: %d", p.Stock)
+	}
+}
+
+// Отменённый запрос ничего не меняет: ни корзину, ни склад
+func TestCancelledContext(t *testing.T) {
+	a := productA
+	a.Stock = 5
+	s := stockedCart(t, a)
+	s.Add(context.Background(), "p1", 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Add(ctx, "p1", 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := s.Checkout(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("checkout: %v", err)
+	}
+	if err := s.catalog.Take(ctx, map[string]int{"p1": 1}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("take: %v", err)
+	}
+	if err := s.Remove(ctx, "p1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := s.Clear(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("clear: %v", err)
+	}
+	if err := s.RemoveCoupon(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("remove coupon: %v", err)
+	}
+	if err := s.RemoveGiftCard(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("remove gift card: %v", err)
+	}
+	if p, _ := s.catalog.Get("p1"); p.Stock != 5 || len(s.Items()) != 1 || s.Items()[0].Quantity != 2 {
+		t.Fatalf("cancelled calls changed state: stock %d, items %+v", p.Stock, s.Items())
+	}
+	if v := s.ToCart().Version; v != 1 {
+		t.Fatalf("cancelled calls bumped the version: %d", v)
+	}
+}
+
+// countingStorage — Storage в памяти, считает записи
+type countingStorage struct {
+	mu    sync.Mutex
+	saves int
+	carts map[string]CartSnapshot
+}
+
+func (cs *countingStorage) Load() ([]CartSnapshot, error) { return nil, nil }
+
+func (cs *countingStorage) Save(snap CartSnapshot) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.saves++
+	cs.carts[snap.ID] = snap
+	return nil
+}
+
+func (cs *countingStorage) Delete(id string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.carts, id)
+	return nil
+}
+
+// Серия правок за время задержки — одна запись с последним состоянием
+func TestAddAllIsAllOrNothing(t *testing.T) {
+	a := productA
+	a.Stock = 5
+	s := stockedCart(t, a, productB)
+	ctx := context.Background()
+	s.Add(ctx, "p2", 1)
+
+	err := s.AddAll(ctx, []BulkLine{
+		{ProductID: "p1", Quantity: 3},
+		{ProductID: "p9", Quantity: 1},
+		{ProductID: "p2", Quantity: 0},
+		{ProductID: "p1", Quantity: 3}, // вместе с первой строкой 6 > 5
+	})
+	be, ok := isBulkAdd(err)
+	if !ok || len(be.Lines) != 3 {
+		t.Fatalf("AddAll: %v", err)
+	}
+	for i, want := range []struct {
+		index int
+		err   error
+	}{{1, errProductNotFound}, {2, errQuantityMin1}, {3, ErrInsufficientStock}} {
+		if l := be.Lines[i]; l.Index != want.index || !errors.Is(l.Err, want.err) {
+			t.Fatalf("line %d: %+v, want %d %v", i, l, want.index, want.err)
+		}
+	}
+	if items := s.Items(); len(items) != 1 || items[0].Quantity != 1 || s.ToCart().Version != 1 {
+		t.Fatalf("rejected AddAll changed the cart: %+v v%d", items, s.ToCart().Version)
+	}
+
+	if err := s.AddAll(ctx, []BulkLine{{"p1", 2}, {"p2", 2}, {"p1", 3}}); err != nil {
+		t.Fatal(err)
+	}
+	items := s.Items()
+	if len(items) != 2 || items[0].Product.ID != "p2" || items[0].Quantity != 3 || items[1].Quantity != 5 {
+		t.Fatalf("after AddAll: %+v", items)
+	}
+	if s.ToCart().Version != 2 {
+		t.Fatalf("AddAll must bump the version once: %d", s.ToCart().Version)
+	}
+}
+
+// Ровно предел можно, на единицу больше — нет; Add считает с тем, что уже
+// в корзине
+func TestCartLimitsAtBoundaries(t *testing.T) {
+	productC := Product{ID: "p3", Name: "C", Price: 50, Stock: 1000}
+	s := stockedCart(t, productA, productB, productC)
+	s.limits.MaxDistinctItems = 2
+	ctx := context.Background()
+	quantity := func(id string) int { return s.items[id].Quantity }
+
+	s.Update(ctx, "p1", 0)
+	if err := s.Add(ctx, "p1", 50); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Add(ctx, "p1", 60)
+	le, ok := isQuantityLimit(err)
+	if !ok || le.ProductID != "p1" || le.Requested != 110 || le.Limit != 99 || quantity("p1") != 50 {
+		t.Fatalf("50 + 60: %v, quantity %d", err, quantity("p1"))
+	}
+	if err := s.Add(ctx, "p1", 49); err != nil || quantity("p1") != 99 {
+		t.Fatalf("50 + 49: %v, quantity %d", err, quantity("p1"))
+	}
+	if err := s.Add(ctx, "p1", 1); !errors.Is(err, errQuantityLimit) {
+		t.Fatalf("99 + 1: %v", err)
+	}
+	if err := s.Update(ctx, "p1", 100); !errors.Is(err, errQuantityLimit) || quantity("p1") != 99 {
+		t.Fatalf("update to 100: %v", err)
+	}
+	if err := s.Update(ctx, "p1", 99); err != nil {
+		t.Fatalf("update to 99: %v", err)
+	}
+	if err := s.Add(ctx, "p2", 100); !errors.Is(err, errQuantityLimit) || len(s.Items()) != 1 {
+		t.Fatalf("new line over the limit: %v", err)
+	}
+
+	// Строк — не больше MaxDistinctItems
+	if err := s.Add(ctx, "p2", 1); err != nil {
+		t.Fatalf("second line: %v", err)
+	}
+	err = s.Add(ctx, "p3", 1)
+	if fe, ok := isCartFull(err); !ok || fe.Limit != 2 || len(s.Items()) != 2 {
+		t.Fatalf("third line: %v", err)
+	}
+	if err := s.Add(ctx, "p2", 1); err != nil {
+		t.Fatalf("existing line in a full cart: %v", err)
+	}
+
+	// AddAll: каждая строка вместе с предыдущими
+	err = s.AddAll(ctx, []BulkLine{{"p2", 48}, {"p2", 50}, {"p3", 1}, {"p2", 1}})
+	be, ok := isBulkAdd(err)
+	if !ok || len(be.Lines) != 2 || be.Lines[0].Index != 1 || !errors.Is(be.Lines[0].Err, errQuantityLimit) ||
+		be.Lines[1].Index != 2 || !errors.Is(be.Lines[1].Err, errCartFull) {
+		t.Fatalf("AddAll over the limits: %v", err)
+	}
+	if err := s.AddAll(ctx, []BulkLine{{"p2", 48}, {"p2", 49}}); err != nil || quantity("p2") != 99 {
+		t.Fatalf("AddAll up to the limit: %v, quantity %d", err, quantity("p2"))
+	}
+
+	// 0 — без предела
+	s.limits = CartLimits{}
+	if err := s.Add(ctx, "p3", 500); err != nil {
+		t.Fatalf("no limits: %v", err)
+	}
+}
+
+// Количество, которое в сумме с уже лежащим переполнило бы int, отвергается
+// и пределом, и складом; корзина и склад не меняются
+func TestQuantityOverflowRejected(t *testing.T) {
+	s := stockedCart(t, productA)
+	ctx := context.Background()
+	s.Add(ctx, "p1", 1)
+
+	err := s.Add(ctx, "p1", math.MaxInt)
+	if le, ok := isQuantityLimit(err); !ok || le.Requested != math.MaxInt {
+		t.Fatalf("Add over the limit: %v", err)
+	}
+	err = s.AddAll(ctx, []BulkLine{{"p1", 1}, {"p1", math.MaxInt}})
+	if be, ok := isBulkAdd(err); !ok || len(be.Lines) != 1 || be.Lines[0].Index != 1 || !errors.Is(be.Lines[0].Err, errQuantityLimit) {
+		t.Fatalf("AddAll over the limit: %v", err)
+	}
+
+	s.limits = CartLimits{} // Без предела остаётся склад
+	err = s.Add(ctx, "p1", math.MaxInt)
+	if se, ok := isInsufficientStock(err); !ok || se.Requested != math.MaxInt {
+		t.Fatalf("Add over stock: %v", err)
+	}
+	err = s.AddAll(ctx, []BulkLine{{"p1", 1}, {"p1", math.MaxInt}})
+	if be, ok := isBulkAdd(err); !ok || len(be.Lines) != 1 || be.Lines[0].Index != 1 || !errors.Is(be.Lines[0].Err, ErrInsufficientStock) {
+		t.Fatalf("AddAll over stock: %v", err)
+	}
+	if err := checkStock(productA, 1, math.MaxInt); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("checkStock: %v", err)
+	}
+
+	if items := s.Items(); len(items) != 1 || items[0].Quantity != 1 {
+		t.Fatalf("overflowing adds changed the cart: %+v", items)
+	}
+	o, err := s.Checkout(ctx)
+	if p, _ := s.catalog.Get("p1"); err != nil || o.Total != 250 || p.Stock != productA.Stock-1 {
+		t.Fatalf("checkout: %v total %v stock %d", err, o.Total, p.Stock)
+	}
+}
+
+func TestCartWritesDebounced(t *testing.T) {
+	st := &countingStorage{carts: map[string]CartSnapshot{}}
+	store := NewCartStore()
+	store.catalog = NewCatalog()
+	store.catalog.Put(Product{ID: "p1", Name: "Мыло", Price: 250, Stock: 1000})
+	if err := store.Persist(st, time.Hour, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+	cart := store.Get("alice")
+	for i := 0; i < 50; i++ {
+		cart.Add(context.Background(), "p1", 1)
+	}
+	store.Get("bob").Add(context.Background(), "p1", 1)
+	store.Get("bob").Clear(context.Background())
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st.saves != 1 || st.carts["alice"].Items[0].Quantity != 50 {
+		t.Fatalf("%d saves, stored %+v", st.saves, st.carts)
+	}
+	if _, ok := st.carts["bob"]; ok {
+		t.Fatal("empty cart stored")
+	}
+}
+
+func TestParseMoneyEdges(t *testing.T) {
+	good := map[string]Money{
+		"0": 0, "0.1": 10, "0.10": 10, "0.01": 1, "12": 1200, "12.5": 1250,
+		"-0.05": -5, "999999999999999.99": 99999999999999999,
+	}
+	for in, want := range good {
+		if got, err := ParseMoney(in); err != nil || got != want {
+			t.Errorf("ParseMoney(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", ".", "1.", ".5", "0.305", "0.001", "1e2", "1,5", "+1", "--1", " 1", "1000000000000000"} {
+		if got, err := ParseMoney(in); err == nil {
+			t.Errorf("ParseMoney(%q) = %d, want error", in, got)
+		}
+	}
+
+	for m, want := range map[Money]string{0: "0.00", 5: "0.05", -5: "-0.05", 1250: "12.50", -1200: "-12.00"} {
+		if data, _ := json.Marshal(m); string(data) != want {
+			t.Errorf("marshal %d = %s, want %s", int64(m), data, want)
+		}
+	}
+	var p Product
+	if err := json.Unmarshal([]byte(`{"price":"2.50","price_tiers":[{"min_qty":10,"unit_price":2.1}]}`), &p); err != nil || p.Price != 250 || p.PriceTiers[0].UnitPrice != 210 {
+		t.Fatalf("decimal string and number: %+v, %v", p, err)
+	}
+	for _, body := range []string{`{"price":0.305}`, `{"price":"0.305"}`, `{"price":1e2}`, `{"price":true}`} {
+		if err := json.Unmarshal([]byte(body), &p); err == nil {
+			t.Errorf("%s: accepted", body)
+		}
+	}
+}
+
+func TestRateLimitWritesThrottledReadsContinue(t *testing.T) {
+	cfg := DefaultRateLimitConfig()
+	cfg.Write = Budget{Burst: 3, PerSecond: 0.001}
+	rl := NewRateLimiter(cfg)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cart/get", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, "ok") })
+	mux.HandleFunc("/cart/add", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, "ok") })
+	h := RateLimit(rl, mux)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Cart-ID", "bot")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := do(http.MethodPost, "/cart/add"); rec.Code != http.StatusOK {
+			t.Fatalf("write %d within burst: got %d", i, rec.Code)
+		}
+	}
+	rec := do(http.MethodPost, "/cart/add")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over write budget, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("429 must carry Retry-After")
+	}
+	if !strings.Contains(rec.Body.String(), `"error"`) {
+		t.Fatalf("429 must use the error envelope: %s", rec.Body.String())
+	}
+	for i := 0; i < 10; i++ {
+		if rec := do(http.MethodGet, "/cart/get"); rec.Code != http.StatusOK {
+			t.Fatalf("reads must continue while writes are throttled, got %d", rec.Code)
+		}
+	}
+}
+
+// Новый X-Cart-ID на каждый запрос не даёт нового бюджета; другой IP — свой
+func TestRateLimitIgnoresRotatingCartID(t *testing.T) {
+	cfg := DefaultRateLimitConfig()
+	cfg.Write = Budget{Burst: 3, PerSecond: 0.001}
+	h := RateLimit(NewRateLimiter(cfg), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, "ok")
+	}))
+	do := func(remote string, i int) int {
+		req := httptest.NewRequest(http.MethodPost, "/cart/items", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Cart-ID", fmt.Sprintf("bot-%d", i))
+		req.AddCookie(&http.Cookie{Name: "cart_id", Value: fmt.Sprintf("cookie-%d", i)})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := do("10.0.0.1:1234", i); code != http.StatusOK {
+			t.Fatalf("write %d within burst: got %d", i, code)
+		}
+	}
+	if code := do("10.0.0.1:5678", 3); code != http.StatusTooManyRequests {
+		t.Fatalf("rotated X-Cart-ID got a fresh budget: %d", code)
+	}
+	if code := do("10.0.0.2:1234", 4); code != http.StatusOK {
+		t.Fatalf("another IP must have its own budget: %d", code)
+	}
+}
+
+func TestRateLimitJanitor(t *testing.T) {
+	rl := NewRateLimiter(DefaultRateLimitConfig())
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+	rl.Allow("write|ip:1.2.3.4", rl.cfg.Write)
+
+	now = now.Add(rl.cfg.IdleTTL + time.Second)
+	rl.cleanup()
+	if len(rl.buckets) != 0 {
+		t.Fatalf("idle buckets should be removed, have %d", len(rl.buckets))
+	}
+}
+
+// Резервы и списания одной карты из многих горутин: сумма резервов не
+// превышает баланс, списано не больше выпущенного.
+func TestGiftCardNeverOverspends(t *testing.T) {
+	const issued = Money(5000)
+	gs := NewGiftCardStore()
+	card, err := gs.Mint("", issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		spent Money
+	)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			cart := fmt.Sprintf("cart%d", g)
+			for i := 0; i < 200; i++ {
+				switch i % 4 {
+				case 0, 1:
+					card.Reserve(cart, Money(100+g*37+i))
+				case 2:
+					card.Release(cart)
+				case 3:
+					got := card.Redeem(cart, Money(50+g))
+					mu.Lock()
+					spent += got
+					mu.Unlock()
+				}
+				if balance, reserved := card.Balance(); reserved > balance || balance < 0 {
+					t.Errorf("reserved %d over balance %d", reserved, balance)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	balance, _ := card.Balance()
+	if spent+balance != issued {
+		t.Fatalf("spent %d + balance %d != issued %d", spent, balance, issued)
+	}
+}
+
+// Скидка купона: процент округляется до цента, скидка не больше суммы товаров
+func TestCouponDiscountMath(t *testing.T) {
+	for _, c := range []struct {
+		coupon   Coupon
+		subtotal Money
+		want     Money
+	}{
+		{Coupon{PercentOff: 10}, 1000, 100},
+		{Coupon{PercentOff: 15}, 333, 50}, // 49.95 цента → 50
+		{Coupon{PercentOff: 15}, 330, 50}, // ровно 49.5 → 50
+		{Coupon{PercentOff: 15}, 329, 49}, // 49.35 → 49
+		{Coupon{PercentOff: 100}, 1234, 1234},
+		{Coupon{AmountOff: 500}, 2000, 500},
+		{Coupon{AmountOff: 500}, 500, 500},
+		{Coupon{AmountOff: 500}, 300, 300}, // больше суммы — не дальше нуля
+		{Coupon{AmountOff: 500}, 0, 0},
+		{Coupon{PercentOff: 50}, 0, 0},
+	} {
+		if got := c.coupon.Discount(c.subtotal); got != c.want {
+			t.Errorf("%+v on %v: discount %v, want %v", c.coupon, c.subtotal, got, c.want)
+		}
+	}
+}
+
+func TestCouponConditions(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ends := now.Add(time.Hour)
+	c := Coupon{Code: "SPRING", PercentOff: 10, MinSubtotal: 1000, ExpiresAt: &ends}
+
+	if err := c.check(1000, now); err != nil {
+		t.Fatalf("exactly the minimum must pass: %v", err)
+	}
+	if ce, ok := isCouponError(c.check(999, now)); !ok || ce.Reason != couponMinSubtotal || ce.MinSubtotal != 1000 {
+		t.Fatalf("below the minimum: %+v", ce)
+	}
+	if ce, ok := isCouponError(c.check(5000, ends)); !ok || ce.Reason != couponExpired {
+		t.Fatalf("at expires_at: %+v", ce)
+	}
+
+	for _, bad := range []Coupon{
+		{Code: "X"},
+		{Code: "X", PercentOff: 10, AmountOff: 100},
+		{Code: "X", PercentOff: 101},
+		{Code: "X", AmountOff: -1},
+		{Code: "X", PercentOff: 5, MinSubtotal: -1},
+		{PercentOff: 5},
+	} {
+		if err := validateCoupon(bad); err == nil {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+}
+
+// Скидка съедает всю сумму — к оплате 0, карте резервировать нечего
+func TestCouponLargerThanSubtotalClamped(t *testing.T) {
+	s := stockedCart(t, productA)
+	s.id = "c1"
+	s.Add(context.Background(), "p1", 1) // 2.50
+	if _, err := s.ApplyCoupon(context.Background(), Coupon{Code: "TENOFF", AmountOff: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	card := &GiftCard{Code: "G", balance: 5000, reserved: map[string]Money{}}
+	if err := s.ApplyGiftCard(context.Background(), card); err != nil {
+		t.Fatal(err)
+	}
+	if c := s.ToCart(); c.Subtotal != 250 || c.Discount != 250 || c.Total != 0 {
+		t.Fatalf("cart: %+v", c)
+	}
+	if _, reserved := card.Balance(); reserved != 0 {
+		t.Fatalf("card reserved %v on a fully discounted cart", reserved)
+	}
+	o, err := s.Checkout(context.Background())
+	if err != nil || o.Discount != 250 || o.Coupon != "TENOFF" || o.GiftCard != 0 || o.Total != 0 {
+		t.Fatalf("order %+v, %v", o, err)
+	}
+}
+
+// Ступени цены: ровно на пороге — уже эта ступень; слияние через Add и
+// Update пересчитывают цену строки.
+func TestPriceTierBoundaries(t *testing.T) {
+	pen := Product{ID: "pen", Name: "Ручка", Price: 1000, Stock: 1000, PriceTiers: []PriceTier{
+		{MinQty: 10, UnitPrice: 800},
+		{MinQty: 50, UnitPrice: 600},
+	}}
+	cases := []struct {
+		qty       int
+		unitPrice Money
+		total     Money
+	}{
+		{1, 1000, 1000},
+		{9, 1000, 9000},
+		{10, 800, 8000},
+		{11, 800, 8800},
+		{49, 800, 39200},
+		{50, 600, 30000},
+		{120, 600, 72000},
+	}
+	for _, c := range cases {
+		s := stockedCart(t, pen)
+		s.limits = CartLimits{} // 120 — сверх предела по умолчанию
+		if err := s.Add(context.Background(), "pen", c.qty); err != nil {
+			t.Fatal(err)
+		}
+		it := s.Items()[0]
+		if it.UnitPrice != c.unitPrice || it.BasePrice != 1000 || s.Total() != c.total {
+			t.Errorf("qty %d: unit %v base %v total %v, want unit %v total %v", c.qty, it.UnitPrice, it.BasePrice, s.Total(), c.unitPrice, c.total)
+		}
+	}
+
+	// Слияние строк и Update пересекают пороги в обе стороны
+	s := stockedCart(t, pen)
+	steps := []struct {
+		op        func() error
+		unitPrice Money
+	}{
+		{func() error { return s.Add(context.Background(), "pen", 6) }, 1000},
+		{func() error { return s.Add(context.Background(), "pen", 4) }, 800},
+		{func() error { return s.Update(context.Background(), "pen", 50) }, 600},
+		{func() error { return s.Update(context.Background(), "pen", 49) }, 800},
+		{func() error { return s.Add(context.Background(), "pen", 1) }, 600},
+		{func() error { return s.Update(context.Background(), "pen", 3) }, 1000},
+	}
+	for i, step := range steps {
+		if err := step.op(); err != nil {
+			t.Fatal(err)
+		}
+		if it := s.Items()[0]; it.UnitPrice != step.unitPrice {
+			t.Fatalf("step %d: qty %d unit %v, want %v", i, it.Quantity, it.UnitPrice, step.unitPrice)
+		}
+	}
+}
+
+func TestPriceTiersValidated(t *testing.T) {
+	bad := map[string][]PriceTier{
+		"threshold 1":     {{MinQty: 1, UnitPrice: 900}},
+		"not sorted":      {{MinQty: 20, UnitPrice: 800}, {MinQty: 10, UnitPrice: 700}},
+		"same threshold":  {{MinQty: 10, UnitPrice: 800}, {MinQty: 10, UnitPrice: 700}},
+		"not below base":  {{MinQty: 10, UnitPrice: 1000}},
+		"price goes up":   {{MinQty: 10, UnitPrice: 800}, {MinQty: 20, UnitPrice: 900}},
+		"zero unit price": {{MinQty: 10, UnitPrice: 0}},
+	}
+	for name, tiers := range bad {
+		if err := NewCatalog().Put(Product{ID: "x", Price: 1000, PriceTiers: tiers}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestSaleWindowBoundariesWithTiers(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pen := Product{ID: "pen", Name: "Ручка", Price: 1000, Stock: 1000,
+		PriceTiers:  []PriceTier{{MinQty: 10, UnitPrice: 800}, {MinQty: 50, UnitPrice: 600}},
+		SaleWindows: []SaleWindow{{Start: t0, End: t0.Add(time.Hour), Price: 700}},
+	}
+	cases := []struct {
+		at         time.Time
+		qty        int
+		base, unit Money
+		onSale     bool
+	}{
+		{t0.Add(-time.Nanosecond), 1, 1000, 1000, false},
+		{t0, 1, 700, 700, true},
+		{t0, 10, 700, 700, true}, // распродажа дешевле ступени
+		{t0, 50, 700, 600, true}, // ступень дешевле распродажи
+		{t0.Add(time.Hour - time.Nanosecond), 1, 700, 700, true},
+		{t0.Add(time.Hour), 1, 1000, 1000, false},
+		{t0.Add(time.Hour), 10, 1000, 800, false},
+	}
+	for _, c := range cases {
+		at := c.at
+		q := NewPriceResolver(func() time.Time { return at }).Quote(pen, c.qty)
+		if q.Base != c.base || q.Unit != c.unit || (q.SaleEndsAt != nil) != c.onSale {
+			t.Errorf("%s qty %d: %+v", c.at.Format(time.RFC3339Nano), c.qty, q)
+		}
+		if c.onSale && (q.Original != 1000 || !q.SaleEndsAt.Equal(t0.Add(time.Hour))) {
+			t.Errorf("%s: original %v ends %v", c.at.Format(time.RFC3339Nano), q.Original, q.SaleEndsAt)
+		}
+	}
+}
+
+func TestSaleWindowsValidated(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	bad := map[string][]SaleWindow{
+		"empty window":   {{Start: t0, End: t0, Price: 500}},
+		"no start":       {{End: t0, Price: 500}},
+		"not below base": {{Start: t0, End: t0.Add(time.Hour), Price: 1000}},
+		"zero price":     {{Start: t0, End: t0.Add(time.Hour), Price: 0}},
+		"overlap":        {{Start: t0.Add(time.Hour), End: t0.Add(3 * time.Hour), Price: 500}, {Start: t0, End: t0.Add(2 * time.Hour), Price: 600}},
+	}
+	for name, windows := range bad {
+		if err := NewCatalog().Put(Product{ID: "x", Price: 1000, SaleWindows: windows}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	touching := []SaleWindow{{Start: t0.Add(time.Hour), End: t0.Add(2 * time.Hour), Price: 500}, {Start: t0, End: t0.Add(time.Hour), Price: 600}}
+	if err := validateSales(Product{Price: 1000, SaleWindows: touching}); err != nil {
+		t.Errorf("touching windows: %v", err)
+	}
+}
+
 /*
 Запуск тестов:
 
@@ -43,3 +829,126 @@ func TestClear(t *testing.T) {
 go test ./...
 ```
 */
+
+func TestCartJanitorEvictsAndStops(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	store := NewCartStore()
+	store.now, store.TTL = clock.Now, time.Hour
+	store.catalog = NewCatalog()
+	store.catalog.Put(Product{ID: "p1", Name: "Мыло", Price: 250, Stock: 10})
+	store.Get("alice").Add(context.Background(), "p1", 1)
+	clock.Advance(30 * time.Minute)
+	store.Get("bob").Add(context.Background(), "p1", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := store.StartJanitor(ctx, time.Millisecond)
+	count := func() int {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.carts)
+	}
+	clock.Advance(45 * time.Minute)
+	for deadline := time.Now().Add(5 * time.Second); count() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("janitor left %d carts", count())
+		}
+	}
+	if _, ok := store.carts["bob"]; !ok {
+		t.Fatal("janitor evicted the fresh cart")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("janitor did not stop")
+	}
+	clock.Advance(time.Hour)
+	time.Sleep(5 * time.Millisecond)
+	if count() != 1 {
+		t.Fatal("stopped janitor still evicts")
+	}
+}
+
+// sentinelMessages — тексты всех errors.New уровня пакета (без тестов)
+func sentinelMessages(t *testing.T) []string {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	var msgs []string
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, v := range spec.(*ast.ValueSpec).Values {
+					call, ok := v.(*ast.CallExpr)
+					if !ok || len(call.Args) != 1 {
+						continue
+					}
+					sel, ok := call.Fun.(*ast.SelectorExpr)
+					lit, isLit := call.Args[0].(*ast.BasicLit)
+					if !ok || !isLit || sel.Sel.Name != "New" || fmt.Sprint(sel.X) != "errors" {
+						continue
+					}
+					msg, err := strconv.Unquote(lit.Value)
+					if err != nil {
+						t.Fatal(err)
+					}
+					msgs = append(msgs, msg)
+				}
+			}
+		}
+	}
+	return msgs
+}
+
+func TestErrorTableCoversSentinels(t *testing.T) {
+	inTable := map[string]bool{}
+	for _, row := range errorTable {
+		if row.status < 400 || row.status > 599 || row.code == "" {
+			t.Errorf("%q: status %d code %q", row.err, row.status, row.code)
+		}
+		inTable[row.err.Error()] = true
+
+		// Обёрнутая ошибка находит свою строку
+		ae := toAPIError(fmt.Errorf("context: %w", row.err))
+		if ae.Status != row.status || ae.Code != row.code || ae.Field != row.field {
+			t.Errorf("%q maps to %d %s %q, want %d %s %q", row.err, ae.Status, ae.Code, ae.Field, row.status, row.code, row.field)
+		}
+	}
+	msgs := sentinelMessages(t)
+	if len(msgs) < len(errorTable) {
+		t.Fatalf("found only %d sentinels: %q", len(msgs), msgs)
+	}
+	for _, msg := range msgs {
+		if !inTable[msg] {
+			t.Errorf("sentinel %q has no row in errorTable", msg)
+		}
+	}
+
+	// Типизированные ошибки разворачиваются к своим строкам
+	for err, code := range map[error]string{
+		&InsufficientStockError{ProductID: "p1", Requested: 3, Available: 1}: codeInsufficientStock,
+		&VersionConflictError{Expected: 1, Current: 2}:                       codeVersionConflict,
+		&CouponError{Code: "X", Reason: couponExpired}:                       codeCouponRejected,
+		&PriceChangedError{}:                            codePriceChanged,
+		errors.New("percent_off must be within 1..100"): codeInvalidRequest,
+	} {
+		if got := toAPIError(err).Code; got != code {
+			t.Errorf("%T %q: code %s, want %s", err, err, got, code)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ---------- CATALOG ----------
+
+// Каталог — товары с ценами на сервере, единственный источник цен. Клиент
+// кладёт в корзину товар по ID (/cart/add {product_id, quantity}); товара
+// нет в каталоге — 404. Строки корзины всегда считаются по текущей версии
+// товара (цена, ступени, распродажи).
+//
+//	GET  /products          — каталог с действующими ценами и остатками
+//	GET  /products/get?id=  — один товар
+//	POST /products          — завести новый товар (админ; 409, если ID занят)
+//	POST /admin/products    — завести или заменить товар (админ)
+//
+// main заполняет каталог товарами seedProducts; NewApp оставляет его пустым.
+
+type Catalog struct {
+	mu       sync.RWMutex
+	products map[string]Product
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{products: make(map[string]Product)}
+}
+
+// errProductExists — Create с ID, который уже есть в каталоге
+var errProductExists = errors.New("product already exists")
+
+// seedProducts — стартовый каталог сервера (цены в центах)
+var seedProducts = []Product{
+	{ID: "p1", Name: "Мыло", Price: 250, Stock: 500, PriceTiers: []PriceTier{{MinQty: 10, UnitPrice: 200}}},
+	{ID: "p2", Name: "Шампунь", Price: 1000, Stock: 100},
+	{ID: "p3", Name: "Зубная паста", Price: 420, Stock: 200},
+	{ID: "p4", Name: "Полотенце", Price: 1500, Stock: 20},
+}
+
+// Put добавляет или заменяет товар после проверки ступеней и распродаж
+func (c *Catalog) Put(p Product) error {
+	if err := validateProduct(p); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.products[p.ID] = p
+	return nil
+}
+
+// Create добавляет новый товар; ID не должен быть занят
+func (c *Catalog) Create(p Product) error {
+	if err := validateProduct(p); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.products[p.ID]; ok {
+		return errProductExists
+	}
+	c.products[p.ID] = p
+	return nil
+}
+
+// Get — товар по ID; nil-каталог пуст
+func (c *Catalog) Get(id string) (Product, bool) {
+	if c == nil {
+		return Product{}, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.products[id]
+	return p, ok
+}
+
+// List — все товары по ID
+func (c *Catalog) List() []Product {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]Product, 0, len(c.products))
+	for _, p := range c.products {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// handlePutProduct — POST /admin/products {Product}: завести или заменить товар
+func (a *App) handlePutProduct(w http.ResponseWriter, r *http.Request) {
+	if !a.requireAdmin(w, r, http.MethodPost) {
+		return
+	}
+	var p Product
+	if err := decodeJSON(r, &p); err != nil {
+		writeError(w, errInvalidJSON)
+		return
+	}
+	if p.ID == "" {
+		writeError(w, fieldError(codeMissingField, "id", "product id required"))
+		return
+	}
+	if err := a.Catalog.Put(p); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.Prices.View(p))
+}
+
+// handleProducts — /products: GET — каталог, POST — новый товар (админ)
+func (a *App) handleProducts(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		a.handleCreateProduct(w, r)
+		return
+	}
+	a.handleListProducts(w, r)
+}
+
+// handleCreateProduct — POST /products {Product}: завести новый товар
+func (a *App) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
+	if !a.requireAdmin(w, r, http.MethodPost) {
+		return
+	}
+	var p Product
+	if err := decodeJSON(r, &p); err != nil {
+		writeError(w, errInvalidJSON)
+		return
+	}
+	if p.ID == "" {
+		writeError(w, fieldError(codeMissingField, "id", "product id required"))
+		return
+	}
+	if err := a.Catalog.Create(p); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, a.Prices.View(p))
+}
+
+// handleListProducts — GET /products: каталог с действующими ценами
+func (a *App) handleListProducts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+	products := a.Catalog.List()
+	views := make([]ProductView, 0, len(products))
+	for _, p := range products {
+		views = append(views, a.Prices.View(p))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// handleGetProduct — GET /products/get?id=<productID>
+func (a *App) handleGetProduct(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	p, ok := a.Catalog.Get(r.URL.Query().Get("id"))
+	if !ok {
+		writeError(w, errProductNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.Prices.View(p))
+}
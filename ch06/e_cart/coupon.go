@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ---------- COUPONS ----------
+
+// Купон — скидка на сумму товаров корзины: процент (percent_off) или
+// фиксированная сумма (amount_off), с необязательными минимальной суммой
+// товаров (min_subtotal) и сроком действия (expires_at). Купоны заводит
+// админ в CouponStore (POST /admin/coupons), покупатель применяет код:
+//
+//	POST /cart/coupon        {code} — применить купон к корзине
+//	POST /cart/coupon/remove        — снять купон
+//
+// У корзины один купон: второй заменяет первый, прежний код приходит в
+// ответе в replaced_coupon. Неизвестный или истёкший код и сумма товаров
+// меньше min_subtotal — 422 с reason (unknown, expired, min_subtotal), купон
+// корзины при этом не меняется.
+//
+// Скидка считается от суммы товаров при каждом чтении корзины и не больше
+// неё. Если после применения корзина стала меньше min_subtotal или купон
+// истёк, скидка 0, пока условие снова не выполнится; checkout берёт скидку
+// на момент оформления. Подарочная карта покрывает сумму уже после скидки.
+
+// Причины отказа в купоне (reason в ответе 422)
+const (
+	couponUnknown     = "unknown"
+	couponExpired     = "expired"
+	couponMinSubtotal = "min_subtotal"
+)
+
+// Coupon — условия скидки; задан ровно один из PercentOff и AmountOff
+type Coupon struct {
+	Code        string     `json:"code"`
+	PercentOff  int        `json:"percent_off,omitempty"` // 1–100
+	AmountOff   Money      `json:"amount_off,omitempty"`
+	MinSubtotal Money      `json:"min_subtotal,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // не включая
+}
+
+// errCouponRejected — купон не применяется; подробности — в *CouponError
+var errCouponRejected = errors.New("coupon rejected")
+
+// CouponError — купон не применяется: Reason — одна из причин выше
+type CouponError struct {
+	Code        string
+	Reason      string
+	MinSubtotal Money
+}
+
+func (e *CouponError) Error() string {
+	switch e.Reason {
+	case couponExpired:
+		return "coupon expired"
+	case couponMinSubtotal:
+		return fmt.Sprintf("coupon requires a subtotal of at least %v", e.MinSubtotal)
+	}
+	return "coupon not found"
+}
+
+func (e *CouponError) Unwrap() error { return errCouponRejected }
+
+// isCouponError — errors.As для CouponError
+func isCouponError(err error) (*CouponError, bool) {
+	var ce *CouponError
+	ok := errors.As(err, &ce)
+	return ce, ok
+}
+
+// validateCoupon — код есть, скидка задана одним способом, суммы не отрицательные
+func validateCoupon(c Coupon) error {
+	switch {
+	case c.Code == "":
+		return errors.New("code required")
+	case (c.PercentOff != 0) == (c.AmountOff != 0):
+		return errors.New("set exactly one of percent_off and amount_off")
+	case c.PercentOff < 0 || c.PercentOff > 100:
+		return errors.New("percent_off must be within 1..100")
+	case c.AmountOff < 0:
+		return errors.New("amount_off must be > 0")
+	case c.MinSubtotal < 0:
+		return errors.New("min_subtotal must be >= 0")
+	}
+	return nil
+}
+
+// Discount — скидка с суммы товаров subtotal: процент округляется до цента
+// (половина — вверх), результат не больше subtotal
+func (c Coupon) Discount(subtotal Money) Money {
+	d := c.AmountOff
+	if c.PercentOff > 0 {
+		d = (subtotal*Money(c.PercentOff) + 50) / 100
+	}
+	return min(max(d, 0), max(subtotal, 0))
+}
+
+// check — применим ли купон к сумме subtotal в момент at
+func (c Coupon) check(subtotal Money, at time.Time) error {
+	if c.ExpiresAt != nil && !at.Before(*c.ExpiresAt) {
+		return &CouponError{Code: c.Code, Reason: couponExpired}
+	}
+	if subtotal < c.MinSubtotal {
+		return &CouponError{Code: c.Code, Reason: couponMinSubtotal, MinSubtotal: c.MinSubtotal}
+	}
+	return nil
+}
+
+// CouponStore — купоны по коду (без учёта регистра и пробелов, как карты)
+type CouponStore struct {
+	mu      sync.Mutex
+	coupons map[string]Coupon
+}
+
+func NewCouponStore() *CouponStore {
+	return &CouponStore{coupons: make(map[string]Coupon)}
+}
+
+// Put заводит или заменяет купон; корзины, где он уже применён, держат
+// прежнюю версию
+func (cs *CouponStore) Put(c Coupon) (Coupon, error) {
+	c.Code = normalizeCode(c.Code)
+	if err := validateCoupon(c); err != nil {
+		return Coupon{}, err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.coupons[c.Code] = c
+	return c, nil
+}
+
+func (cs *CouponStore) Get(code string) (Coupon, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	c, ok := cs.coupons[normalizeCode(code)]
+	return c, ok
+}
+
+// ---------- COUPON HANDLERS ----------
+
+// CouponRequest : применить купон к корзине
+type CouponRequest struct {
+	Code            string `json:"code"`
+	ExpectedVersion *int64 `json:"expected_version,omitempty"`
+}
+
+// CouponResponse — корзина после применения; ReplacedCoupon — код купона,
+// который был у корзины до этого
+type CouponResponse struct {
+	Cart
+	ReplacedCoupon string `json:"replaced_coupon,omitempty"`
+}
+
+// handlePutCoupon — POST /admin/coupons (requireAdmin): завести или заменить купон
+func (a *App) handlePutCoupon(w http.ResponseWriter, r *http.Request) {
+	if !a.requireAdmin(w, r, http.MethodPost) {
+		return
+	}
+	var c Coupon
+	if err := decodeJSON(r, &c); err != nil {
+		writeError(w, errInvalidJSON)
+		return
+	}
+	c, err := a.Coupons.Put(c)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// handleApplyCoupon — POST /cart/coupon {"code": "..."}
+func (a *App) handleApplyCoupon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	cart := a.cartFor(r)
+	var req CouponRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, errInvalidJSON)
+		return
+	}
+	r, err := expectVersion(r, req.ExpectedVersion)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	coupon, ok := a.Coupons.Get(req.Code)
+	if !ok {
+		writeError(w, &CouponError{Code: normalizeCode(req.Code), Reason: couponUnknown})
+		return
+	}
+	replaced, err := cart.ApplyCoupon(r.Context(), coupon)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	a.writeCart(w, cart, CouponResponse{Cart: cart.ToCart(), ReplacedCoupon: replaced})
+}
+
+// handleRemoveCoupon — POST /cart/coupon/remove
+func (a *App) handleRemoveCoupon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	cart := a.cartFor(r)
+	r, err := expectVersion(r, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := cart.RemoveCoupon(r.Context()); err != nil {
+		writeError(w, err)
+		return
+	}
+	a.writeCart(w, cart, cart.ToCart())
+}
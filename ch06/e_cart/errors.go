@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ---------- ERRORS ----------
+
+// Любая ошибка API — один конверт:
+//
+//	{"error": "текст", "code": "INSUFFICIENT_STOCK", "field": "quantity", ...}
+//
+// error — текст для человека (как и раньше), code — стабильный код для
+// программы: тексты могут меняться, коды — нет. field — поле запроса, к
+// которому относится ошибка (если есть). Подробности конкретной ошибки
+// (остаток, версия корзины, причина отказа купона) лежат рядом, request_id
+// добавляет writeJSON.
+//
+// Ошибки сервиса — сигнальные значения (errors.New) и типы, которые к ним
+// разворачиваются (Unwrap). Статус и код по ним выбирает errorTable;
+// обработчику остаётся writeError(w, err).
+
+// Коды ошибок
+const (
+	codeInvalidJSON       = "INVALID_JSON"
+	codeInvalidRequest    = "INVALID_REQUEST" // прочие ошибки проверки запроса
+	codeMissingField      = "MISSING_FIELD"
+	codeInvalidParam      = "INVALID_PARAM"
+	codeInvalidQuantity   = "INVALID_QUANTITY"
+	codeInvalidAmount     = "INVALID_AMOUNT"
+	codeInvalidVersion    = "INVALID_VERSION"
+	codeProductNotFound   = "PRODUCT_NOT_FOUND"
+	codeProductExists     = "PRODUCT_EXISTS"
+	codeNotInCart         = "NOT_IN_CART"
+	codeCartEmpty         = "CART_EMPTY"
+	codeItemsRejected     = "ITEMS_REJECTED"
+	codeInsufficientStock = "INSUFFICIENT_STOCK"
+	codeQuantityLimit     = "QUANTITY_LIMIT"
+	codeCartFull          = "CART_FULL"
+	codeVersionConflict   = "VERSION_CONFLICT"
+	codePriceChanged      = "PRICE_CHANGED"
+	codeCouponRejected    = "COUPON_REJECTED"
+	codeGiftCardNotFound  = "GIFT_CARD_NOT_FOUND"
+	codeGiftCardExists    = "GIFT_CARD_EXISTS"
+	codeGiftCardEmpty     = "GIFT_CARD_EMPTY"
+	codeOrderNotFound     = "ORDER_NOT_FOUND"
+	codeNotFound          = "NOT_FOUND"
+	codeMethodNotAllowed  = "METHOD_NOT_ALLOWED"
+	codeUnauthorized      = "UNAUTHORIZED"
+	codeRateLimited       = "RATE_LIMITED"
+)
+
+// Ошибки уровня HTTP, без сервиса за ними
+var (
+	errInvalidJSON      = errors.New("invalid json")
+	errNotFound         = errors.New("not found")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errUnauthorized     = errors.New("unauthorized")
+	errRateLimited      = errors.New("rate limit exceeded")
+	errOrderNotFound    = errors.New("order not found")
+)
+
+// APIError — ошибка в ответе; Status в тело не идёт
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Field   string
+	Details map[string]interface{} // дополнительные поля конверта
+}
+
+func (e *APIError) Error() string { return e.Message }
+
+// fieldError — 400 про конкретное поле запроса
+func fieldError(code, field, msg string) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: code, Message: msg, Field: field}
+}
+
+// errorTable — статус и код для каждой сигнальной ошибки. toAPIError идёт
+// по ней сверху вниз (errors.Is), так что обёртки fmt.Errorf("...: %w")
+// находят свою строку. Новая сигнальная ошибка добавляется сюда — тест
+// проверяет, что без статуса не осталось ни одной.
+var errorTable = []struct {
+	err    error
+	status int
+	code   string
+	field  string
+}{
+	{errInvalidJSON, http.StatusBadRequest, codeInvalidJSON, ""},
+	{errQuantityMin1, http.StatusBadRequest, codeInvalidQuantity, "quantity"},
+	{errQuantityMin0, http.StatusBadRequest, codeInvalidQuantity, "quantity"},
+	{errMoneyFormat, http.StatusBadRequest, codeInvalidAmount, ""},
+	{errIfMatchFormat, http.StatusBadRequest, codeInvalidVersion, "If-Match"},
+	{errVersionMismatch, http.StatusBadRequest, codeInvalidVersion, "expected_version"},
+	{errProductNotFound, http.StatusNotFound, codeProductNotFound, "product_id"},
+	{errProductExists, http.StatusConflict, codeProductExists, "id"},
+	{errNotInCart, http.StatusNotFound, codeNotInCart, ""},
+	{errEmptyCart, http.StatusConflict, codeCartEmpty, ""},
+	{errBulkRejected, http.StatusUnprocessableEntity, codeItemsRejected, "items"},
+	{ErrInsufficientStock, http.StatusConflict, codeInsufficientStock, "quantity"},
+	{errQuantityLimit, http.StatusUnprocessableEntity, codeQuantityLimit, "quantity"},
+	{errCartFull, http.StatusUnprocessableEntity, codeCartFull, "product_id"},
+	{errVersionConflict, http.StatusConflict, codeVersionConflict, ""},
+	{errPriceChanged, http.StatusConflict, codePriceChanged, ""},
+	{errCouponRejected, http.StatusUnprocessableEntity, codeCouponRejected, "code"},
+	{errGiftCardNotFound, http.StatusNotFound, codeGiftCardNotFound, "code"},
+	{errGiftCardExists, http.StatusConflict, codeGiftCardExists, "code"},
+	{errGiftCardEmpty, http.StatusBadRequest, codeGiftCardEmpty, "code"},
+	{errOrderNotFound, http.StatusNotFound, codeOrderNotFound, "id"},
+	{errNotFound, http.StatusNotFound, codeNotFound, ""},
+	{errMethodNotAllowed, http.StatusMethodNotAllowed, codeMethodNotAllowed, ""},
+	{errUnauthorized, http.StatusUnauthorized, codeUnauthorized, ""},
+	{errRateLimited, http.StatusTooManyRequests, codeRateLimited, ""},
+}
+
+// toAPIError — ошибка для ответа: *APIError как есть, сигнальная — по
+// errorTable с подробностями её типа, любая другая — 400 INVALID_REQUEST
+// (это ошибки проверки: купон, товар, остаток).
+func toAPIError(err error) *APIError {
+	var ae *APIError
+	if errors.As(err, &ae) {
+		return ae
+	}
+	ae = &APIError{Status: http.StatusBadRequest, Code: codeInvalidRequest, Message: err.Error()}
+	for _, row := range errorTable {
+		if errors.Is(err, row.err) {
+			ae.Status, ae.Code, ae.Field = row.status, row.code, row.field
+			break
+		}
+	}
+	ae.Details = errorDetails(err)
+	return ae
+}
+
+// errorDetails — поля конверта из типизированной ошибки
+func errorDetails(err error) map[string]interface{} {
+	if se, ok := isInsufficientStock(err); ok {
+		return map[string]interface{}{"product_id": se.ProductID, "requested": se.Requested, "available": se.Available}
+	}
+	if le, ok := isQuantityLimit(err); ok {
+		return map[string]interface{}{"product_id": le.ProductID, "requested": le.Requested, "limit": le.Limit}
+	}
+	if fe, ok := isCartFull(err); ok {
+		return map[string]interface{}{"limit": fe.Limit}
+	}
+	if ve, ok := isVersionConflict(err); ok {
+		return map[string]interface{}{"version": ve.Current}
+	}
+	if ce, ok := isCouponError(err); ok {
+		d := map[string]interface{}{"reason": ce.Reason, "coupon": ce.Code}
+		if ce.Reason == couponMinSubtotal {
+			d["min_subtotal"] = ce.MinSubtotal
+		}
+		return d
+	}
+	if pc, ok := isPriceChanged(err); ok {
+		return map[string]interface{}{"changes": pc.Changes}
+	}
+	if be, ok := isBulkAdd(err); ok {
+		rejected := make([]map[string]interface{}, 0, len(be.Lines))
+		for _, l := range be.Lines {
+			rejected = append(rejected, lineError(l))
+		}
+		return map[string]interface{}{"rejected": rejected}
+	}
+	return nil
+}
+
+// lineError — ошибка строки массового запроса: конверт её ошибки и индекс
+func lineError(l LineError) map[string]interface{} {
+	ae := toAPIError(l.Err)
+	entry := map[string]interface{}{"index": l.Index, "error": ae.Message, "code": ae.Code}
+	if ae.Field != "" {
+		entry["field"] = ae.Field
+	}
+	for k, v := range ae.Details {
+		entry[k] = v
+	}
+	return entry
+}
+
+// writeError — конверт ошибки со статусом из toAPIError
+func writeError(w http.ResponseWriter, err error) {
+	ae := toAPIError(err)
+	body := make(map[string]interface{}, len(ae.Details)+3)
+	for k, v := range ae.Details {
+		body[k] = v
+	}
+	body["error"] = ae.Message
+	body["code"] = ae.Code
+	if ae.Field != "" {
+		body["field"] = ae.Field
+	}
+	writeJSON(w, ae.Status, body)
+}
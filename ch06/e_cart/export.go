@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ---------- EXPORT (сырые данные для аналитики) ----------
+
+// Админские выгрузки (тот же Bearer-токен, только GET):
+//
+//	GET /admin/export/orders?from=&to=&format=csv|jsonl
+//	GET /admin/export/carts?status=active|abandoned&format=csv|jsonl
+//
+// Одна строка — одна позиция заказа или корзины, с данными товара рядом
+// (денормализовано). Деньги — десятичные строки ("12.50"), время — RFC 3339
+// в UTC. from включительно, to не включая; дата или RFC 3339. Строки пишутся
+// в ответ по одной, без сборки всей выгрузки в памяти; отмена запроса
+// (клиент ушёл) останавливает выгрузку. Сколько строк отдано — в трейлере
+// X-Export-Rows.
+
+// maxAbandonedCarts — сколько брошенных корзин помнит CartStore
+const maxAbandonedCarts = 10000
+
+// exportFlushEvery — через сколько строк сбрасывать ответ клиенту
+const exportFlushEvery = 100
+
+// exportRowsTrailer — трейлер с числом отданных строк
+const exportRowsTrailer = "X-Export-Rows"
+
+// ---------- корзины ----------
+
+// AbandonedCart — корзина, истёкшая с товарами
+type AbandonedCart struct {
+	ID       string
+	Items    []Item
+	LastUsed time.Time
+}
+
+// cartRecord — корзина в выгрузке
+type cartRecord struct {
+	ID       string
+	Status   string
+	LastUsed time.Time
+	cart     *CartService // активная: позиции читаются при выгрузке
+	items    []Item       // брошенная
+}
+
+const (
+	cartActive    = "active"
+	cartAbandoned = "abandoned"
+)
+
+// exportCarts — активные и/или брошенные корзины (status пустой — все)
+func (cs *CartStore) exportCarts(status string) []cartRecord {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	var out []cartRecord
+	if status == "" || status == cartActive {
+		for id, c := range cs.carts {
+			out = append(out, cartRecord{ID: id, Status: cartActive, LastUsed: c.Modified(), cart: c})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	}
+	if status == "" || status == cartAbandoned {
+		for _, ab := range cs.abandoned {
+			out = append(out, cartRecord{ID: ab.ID, Status: cartAbandoned, LastUsed: ab.LastUsed, items: ab.Items})
+		}
+	}
+	return out
+}
+
+// ---------- строки выгрузки ----------
+
+// exportRow — позиция заказа или корзины; поля в порядке колонок CSV
+type exportRow []exportField
+
+type exportField struct {
+	name  string
+	value string
+}
+
+func (row exportRow) header() []string {
+	out := make([]string, len(row))
+	for i, f := range row {
+		out[i] = f.name
+	}
+	return out
+}
+
+func (row exportRow) record() []string {
+	out := make([]string, len(row))
+	for i, f := range row {
+		out[i] = f.value
+	}
+	return out
+}
+
+// MarshalJSON — объект с полями в порядке колонок
+func (row exportRow) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, f := range row {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(f.name)
+		value, _ := json.Marshal(f.value)
+		buf = append(append(append(buf, name...), ':'), value...)
+	}
+	return append(buf, '}'), nil
+}
+
+func isoTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// itemFields — товар и позиция: общие колонки обеих выгрузок
+func itemFields(it Item) exportRow {
+	return exportRow{
+		{"product_id", it.Product.ID},
+		{"product_name", it.Product.Name},
+		{"product_price", it.Product.Price.String()},
+		{"quantity", strconv.Itoa(it.Quantity)},
+		{"base_unit_price", it.BasePrice.String()},
+		{"unit_price", it.UnitPrice.String()},
+		{"line_total", it.lineTotal().String()},
+	}
+}
+
+func orderRow(o Order, it Item) exportRow {
+	row := exportRow{
+		{"order_id", o.ID},
+		{"created_at", isoTime(o.CreatedAt)},
+		{"cart_id", o.CartID},
+	}
+	row = append(row, itemFields(it)...)
+	return append(row,
+		exportField{"order_subtotal", o.Subtotal.String()},
+		exportField{"order_coupon", o.Coupon},
+		exportField{"order_discount", o.Discount.String()},
+		exportField{"order_gift_card", o.GiftCard.String()},
+		exportField{"order_total", o.Total.String()},
+	)
+}
+
+func cartRow(c cartRecord, it Item) exportRow {
+	row := exportRow{
+		{"cart_id", c.ID},
+		{"status", c.Status},
+		{"last_used", isoTime(c.LastUsed)},
+	}
+	return append(row, itemFields(it)...)
+}
+
+// orderColumns и cartColumns — заголовок CSV, даже если строк нет
+var (
+	orderColumns = orderRow(Order{}, Item{}).header()
+	cartColumns  = cartRow(cartRecord{}, Item{}).header()
+)
+
+// ---------- запись ----------
+
+// rowWriter пишет строки в ответ в формате csv или jsonl
+type rowWriter struct {
+	w     http.ResponseWriter
+	csv   *csv.Writer
+	jsonl *json.Encoder
+	rows  int
+	flush func()
+}
+
+func newRowWriter(w http.ResponseWriter, format string, columns []string) *rowWriter {
+	rw := &rowWriter{w: w, flush: func() {}}
+	w.Header().Set("Trailer", exportRowsTrailer)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		rw.csv = csv.NewWriter(w)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		rw.jsonl = json.NewEncoder(w)
+	}
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		rw.flush = f.Flush
+	}
+	if rw.csv != nil {
+		_ = rw.csv.Write(columns)
+	}
+	return rw
+}
+
+func (rw *rowWriter) write(row exportRow) error {
+	var err error
+	if rw.csv != nil {
+		err = rw.csv.Write(row.record())
+	} else {
+		err = rw.jsonl.Encode(row)
+	}
+	if err != nil {
+		return err
+	}
+	rw.rows++
+	if rw.rows%exportFlushEvery == 0 {
+		rw.sync()
+	}
+	return nil
+}
+
+func (rw *rowWriter) sync() {
+	if rw.csv != nil {
+		rw.csv.Flush()
+	}
+	rw.flush()
+}
+
+// finish сбрасывает остаток и ставит трейлер с числом строк
+func (rw *rowWriter) finish() {
+	if rw.csv != nil {
+		rw.csv.Flush()
+	}
+	rw.w.Header().Set(exportRowsTrailer, strconv.Itoa(rw.rows))
+}
+
+// exportFormat — format из запроса: csv (по умолчанию) или jsonl
+func exportFormat(r *http.Request) (string, bool) {
+	switch f := r.URL.Query().Get("format"); f {
+	case "", "csv":
+		return "csv", true
+	case "jsonl":
+		return f, true
+	}
+	return "", false
+}
+
+// parseBound — граница периода: дата (2006-01-02, полночь UTC) или RFC 3339
+func parseBound(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// ---------- обработчики ----------
+
+// handleExportOrders — GET /admin/export/orders?from=&to=&format=
+func (a *App) handleExportOrders(w http.ResponseWriter, r *http.Request) {
+	if !a.requireAdmin(w, r, http.MethodGet) {
+		return
+	}
+	format, ok := exportFormat(r)
+	if !ok {
+		writeError(w, fieldError(codeInvalidParam, "format", "format must be csv or jsonl"))
+		return
+	}
+	var from, to time.Time
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.URL.Query().Get(name); v != "" {
+			t, err := parseBound(v)
+			if err != nil {
+				writeError(w, fieldError(codeInvalidParam, name, name+": want YYYY-MM-DD or RFC 3339"))
+				return
+			}
+			*dst = t
+		}
+	}
+
+	rw := newRowWriter(w, format, orderColumns)
+	defer rw.finish()
+	ctx := r.Context()
+	for _, o := range a.Orders.Snapshot() {
+		if (!from.IsZero() && o.CreatedAt.Before(from)) || (!to.IsZero() && !o.CreatedAt.Before(to)) {
+			continue
+		}
+		for _, it := range o.Items {
+			if ctx.Err() != nil {
+				return
+			}
+			if rw.write(orderRow(o, it)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleExportCarts — GET /admin/export/carts?status=&format=
+func (a *App) handleExportCarts(w http.ResponseWriter, r *http.Request) {
+	if !a.requireAdmin(w, r, http.MethodGet) {
+		return
+	}
+	format, ok := exportFormat(r)
+	if !ok {
+		writeError(w, fieldError(codeInvalidParam, "format", "format must be csv or jsonl"))
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != cartActive && status != cartAbandoned {
+		writeError(w, fieldError(codeInvalidParam, "status", "status must be active or abandoned"))
+		return
+	}
+
+	rw := newRowWriter(w, format, cartColumns)
+	defer rw.finish()
+	ctx := r.Context()
+	for _, c := range a.Carts.exportCarts(status) {
+		items := c.items
+		if c.cart != nil {
+			items = c.cart.Items()
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Product.ID < items[j].Product.ID })
+		for _, it := range items {
+			if ctx.Err() != nil {
+				return
+			}
+			if rw.write(cartRow(c, it)) != nil {
+				return
+			}
+		}
+	}
+}
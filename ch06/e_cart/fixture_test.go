@@ -0,0 +1,1687 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ---------- FIXTURE ----------
+//
+// fixture поднимает весь стек (App: корзины, маршруты, rate limit) на
+// httptest.NewServer. Каждый newFixture — новый App, поэтому состояние между
+// тестами не протекает. Время идёт только через f.clock.Advance.
+// Новые эндпоинты должны получать здесь хелпер и сценарный тест.
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type fixture struct {
+	t     *testing.T
+	app   *App
+	srv   *httptest.Server
+	clock *fakeClock
+}
+
+const testAdminToken = "test-admin"
+
+// apiError — конверт ошибки {"error": "..."}
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+	Field string `json:"field"`
+}
+
+// newFixture — сервер с щедрыми лимитами, чтобы сценарии в них не упирались;
+// tune может поменять конфиг лимитов до запуска.
+func newFixture(t *testing.T, tune ...func(*RateLimitConfig)) *fixture {
+	t.Helper()
+	cfg := DefaultRateLimitConfig()
+	cfg.Read = Budget{Burst: 10000, PerSecond: 1000}
+	cfg.Write = Budget{Burst: 10000, PerSecond: 1000}
+	cfg.Checkout = Budget{Burst: 10000, PerSecond: 1000} // все сессии фикстуры — с одного IP
+	for _, fn := range tune {
+		fn(&cfg)
+	}
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	app := NewApp(cfg, clock.Now)
+	app.AdminToken = testAdminToken
+	app.Logger = log.New(io.Discard, "", 0)
+	srv := httptest.NewServer(app.Handler())
+	t.Cleanup(srv.Close)
+	return &fixture{t: t, app: app, srv: srv, clock: clock}
+}
+
+// do отправляет запрос от имени сессии; body — значение для JSON, готовая
+// строка/[]byte (для кривого JSON) или nil.
+func (f *fixture) do(session, method, path string, body interface{}) (int, []byte) {
+	f.t.Helper()
+	var rd io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		rd = bytes.NewReader([]byte(b))
+	case []byte:
+		rd = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, f.srv.URL+path, rd)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if session != "" {
+		req.Header.Set("X-Cart-ID", session)
+	}
+	resp, err := f.srv.Client().Do(req)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// cartCall ожидает 200 и декодирует Cart
+func (f *fixture) cartCall(session, method, path string, body interface{}) Cart {
+	f.t.Helper()
+	code, data := f.do(session, method, path, body)
+	if code != http.StatusOK {
+		f.t.Fatalf("%s %s: status %d: %s", method, path, code, data)
+	}
+	var c Cart
+	if err := json.Unmarshal(data, &c); err != nil {
+		f.t.Fatalf("%s %s: decode cart: %v (%s)", method, path, err, data)
+	}
+	return c
+}
+
+// expectError ожидает статус status и JSON-конверт ошибки
+func (f *fixture) expectError(status int, session, method, path string, body interface{}) apiError {
+	f.t.Helper()
+	code, data := f.do(session, method, path, body)
+	if code != status {
+		f.t.Fatalf("%s %s: want %d, got %d: %s", method, path, status, code, data)
+	}
+	var e apiError
+	if err := json.Unmarshal(data, &e); err != nil || e.Error == "" {
+		f.t.Fatalf("%s %s: response is not an error envelope: %s", method, path, data)
+	}
+	return e
+}
+
+// addItem кладёт p в корзину по ID. Товара ещё нет в каталоге — сначала
+// заводит его как есть; уже заведённый не трогает, так что цена строки —
+// всегда каталожная.
+func (f *fixture) addItem(session string, p Product, qty int) Cart {
+	f.t.Helper()
+	f.stock(p)
+	return f.cartCall(session, http.MethodPost, "/cart/items", AddRequest{ProductID: p.ID, Quantity: qty})
+}
+
+// stock заводит p в каталоге, если товара с таким ID там нет
+func (f *fixture) stock(p Product) {
+	f.t.Helper()
+	if _, ok := f.app.Catalog.Get(p.ID); ok {
+		return
+	}
+	if err := f.app.Catalog.Put(p); err != nil {
+		f.t.Fatalf("stock %s: %v", p.ID, err)
+	}
+}
+
+func (f *fixture) updateItem(session, id string, qty int) Cart {
+	f.t.Helper()
+	return f.cartCall(session, http.MethodPatch, cartItemsPrefix+id, UpdateRequest{Quantity: qty})
+}
+
+func (f *fixture) removeItem(session, id string) Cart {
+	f.t.Helper()
+	return f.cartCall(session, http.MethodDelete, cartItemsPrefix+id, nil)
+}
+
+func (f *fixture) clearCart(session string) Cart {
+	f.t.Helper()
+	return f.cartCall(session, http.MethodDelete, "/cart", nil)
+}
+
+func (f *fixture) getCart(session string) Cart {
+	f.t.Helper()
+	return f.cartCall(session, http.MethodGet, "/cart", nil)
+}
+
+// admin — POST на админский эндпоинт с токеном
+func (f *fixture) admin(path string, body interface{}) (int, []byte) {
+	f.t.Helper()
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, f.srv.URL+path, bytes.NewReader(data))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp, err := f.srv.Client().Do(req)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return resp.StatusCode, out
+}
+
+// mintGiftCard выпускает карту через админский эндпоинт
+func (f *fixture) mintGiftCard(code string, balance Money) GiftCardInfo {
+	f.t.Helper()
+	status, data := f.admin("/admin/giftcards", MintGiftCardRequest{Code: code, Balance: balance})
+	var info GiftCardInfo
+	if status != http.StatusCreated || json.Unmarshal(data, &info) != nil {
+		f.t.Fatalf("mint gift card: status %d: %s", status, data)
+	}
+	return info
+}
+
+// putProduct заводит или меняет товар каталога
+func (f *fixture) putProduct(p Product) ProductView {
+	f.t.Helper()
+	status, data := f.admin("/admin/products", p)
+	var v ProductView
+	if status != http.StatusOK || json.Unmarshal(data, &v) != nil {
+		f.t.Fatalf("put product %s: status %d: %s", p.ID, status, data)
+	}
+	return v
+}
+
+// getProduct — товар каталога с действующей ценой
+func (f *fixture) getProduct(id string) ProductView {
+	f.t.Helper()
+	code, data := f.do("", http.MethodGet, "/products/get?id="+id, nil)
+	var v ProductView
+	if code != http.StatusOK || json.Unmarshal(data, &v) != nil {
+		f.t.Fatalf("get product %s: status %d: %s", id, code, data)
+	}
+	return v
+}
+
+func (f *fixture) applyGiftCard(session, code string) Cart {
+	f.t.Helper()
+	return f.cartCall(session, http.MethodPost, "/cart/giftcard", GiftCardRequest{Code: code})
+}
+
+func (f *fixture) removeGiftCard(session string) Cart {
+	f.t.Helper()
+	return f.cartCall(session, http.MethodPost, "/cart/giftcard/remove", nil)
+}
+
+// putCoupon заводит купон через админский эндпоинт
+func (f *fixture) putCoupon(c Coupon) Coupon {
+	f.t.Helper()
+	status, data := f.admin("/admin/coupons", c)
+	var out Coupon
+	if status != http.StatusOK || json.Unmarshal(data, &out) != nil {
+		f.t.Fatalf("put coupon %s: status %d: %s", c.Code, status, data)
+	}
+	return out
+}
+
+// applyCoupon ожидает 200 и декодирует корзину с replaced_coupon
+func (f *fixture) applyCoupon(session, code string) CouponResponse {
+	f.t.Helper()
+	status, data := f.do(session, http.MethodPost, "/cart/coupon", CouponRequest{Code: code})
+	var resp CouponResponse
+	if status != http.StatusOK || json.Unmarshal(data, &resp) != nil {
+		f.t.Fatalf("apply coupon %s: status %d: %s", code, status, data)
+	}
+	return resp
+}
+
+// couponRejected ожидает 422 и возвращает reason
+func (f *fixture) couponRejected(session, code string) string {
+	f.t.Helper()
+	status, data := f.do(session, http.MethodPost, "/cart/coupon", CouponRequest{Code: code})
+	var e struct {
+		Error  string `json:"error"`
+		Reason string `json:"reason"`
+	}
+	if status != http.StatusUnprocessableEntity || json.Unmarshal(data, &e) != nil || e.Error == "" {
+		f.t.Fatalf("coupon %s: want 422 with reason, got %d: %s", code, status, data)
+	}
+	return e.Reason
+}
+
+// checkout ожидает 201 и декодирует Order
+func (f *fixture) checkout(session string) Order {
+	f.t.Helper()
+	code, data := f.do(session, http.MethodPost, "/cart/checkout", nil)
+	if code != http.StatusCreated {
+		f.t.Fatalf("checkout: status %d: %s", code, data)
+	}
+	var o Order
+	if err := json.Unmarshal(data, &o); err != nil {
+		f.t.Fatalf("checkout: decode order: %v (%s)", err, data)
+	}
+	return o
+}
+
+// orders — GET /orders сессии
+func (f *fixture) orders(session string) []Order {
+	f.t.Helper()
+	code, data := f.do(session, http.MethodGet, "/orders", nil)
+	var list []Order
+	if code != http.StatusOK || json.Unmarshal(data, &list) != nil {
+		f.t.Fatalf("orders: status %d: %s", code, data)
+	}
+	return list
+}
+
+// order — GET /orders?id= сессии
+func (f *fixture) order(session, id string) Order {
+	f.t.Helper()
+	code, data := f.do(session, http.MethodGet, "/orders?id="+id, nil)
+	var o Order
+	if code != http.StatusOK || json.Unmarshal(data, &o) != nil {
+		f.t.Fatalf("order %s: status %d: %s", id, code, data)
+	}
+	return o
+}
+
+// priceConflict — ответ 409 на checkout после смены цен
+type priceConflict struct {
+	Error   string        `json:"error"`
+	Changes []PriceChange `json:"changes"`
+	Cart    Cart          `json:"cart"`
+}
+
+// checkoutConflict ожидает 409 и декодирует изменения цен
+func (f *fixture) checkoutConflict(session string) priceConflict {
+	f.t.Helper()
+	code, data := f.do(session, http.MethodPost, "/cart/checkout", nil)
+	var pc priceConflict
+	if code != http.StatusConflict || json.Unmarshal(data, &pc) != nil || pc.Error == "" {
+		f.t.Fatalf("checkout: want 409 with changes, got %d: %s", code, data)
+	}
+	return pc
+}
+
+// giftCardHeld — сумма строки подарочной карты (положительная) или 0
+func giftCardHeld(c Cart) Money {
+	for _, adj := range c.Adjustments {
+		if adj.Kind == "gift_card" {
+			return -adj.Amount
+		}
+	}
+	return 0
+}
+
+// quantityOf — количество товара id в корзине (порядок Items не гарантирован)
+func quantityOf(c Cart, id string) int {
+	for _, it := range c.Items {
+		if it.Product.ID == id {
+			return it.Quantity
+		}
+	}
+	return 0
+}
+
+var (
+	soap    = Product{ID: "p1", Name: "Мыло", Price: 250, Stock: 1000}
+	shampoo = Product{ID: "p2", Name: "Шампунь", Price: 1000, Stock: 1000}
+)
+
+// ---------- SCENARIOS ----------
+
+func TestScenarioShoppingFlow(t *testing.T) {
+	f := newFixture(t)
+
+	if c := f.getCart("alice"); len(c.Items) != 0 || c.Total != 0 {
+		t.Fatalf("new session should start empty: %+v", c)
+	}
+	f.addItem("alice", soap, 2)
+	c := f.addItem("alice", shampoo, 1)
+	if len(c.Items) != 2 || c.Total != 1500 {
+		t.Fatalf("after adds: %+v", c)
+	}
+	c = f.addItem("alice", soap, 1)
+	if quantityOf(c, "p1") != 3 || c.Total != 1750 {
+		t.Fatalf("repeated add should merge: %+v", c)
+	}
+	c = f.updateItem("alice", "p2", 4)
+	if quantityOf(c, "p2") != 4 || c.Total != 4750 {
+		t.Fatalf("after update: %+v", c)
+	}
+	c = f.updateItem("alice", "p2", 0)
+	if quantityOf(c, "p2") != 0 || len(c.Items) != 1 {
+		t.Fatalf("update to 0 should remove the line: %+v", c)
+	}
+	c = f.removeItem("alice", "p1")
+	if len(c.Items) != 0 || c.Total != 0 {
+		t.Fatalf("after remove: %+v", c)
+	}
+	f.addItem("alice", soap, 1)
+	if c := f.clearCart("alice"); len(c.Items) != 0 {
+		t.Fatalf("after clear: %+v", c)
+	}
+	if got := f.getCart("alice"); len(got.Items) != 0 {
+		t.Fatalf("clear must persist: %+v", got)
+	}
+
+	if e := f.expectError(http.StatusBadRequest, "alice", http.MethodPost, "/cart/update?id=nope", UpdateRequest{Quantity: 1}); e.Error != "product not found in cart" {
+		t.Fatalf("unexpected error %q", e.Error)
+	}
+}
+
+func TestScenarioConcurrentSessions(t *testing.T) {
+	f := newFixture(t)
+	f.stock(soap)
+	const perSession = 40
+
+	var wg sync.WaitGroup
+	for _, session := range []string{"alice", "bob"} {
+		for i := 0; i < perSession; i++ {
+			wg.Add(1)
+			go func(session string) {
+				defer wg.Done()
+				code, data := f.do(session, http.MethodPost, "/cart/add", AddRequest{ProductID: soap.ID, Quantity: 1})
+				if code != http.StatusOK {
+					t.Errorf("%s add: %d %s", session, code, data)
+				}
+			}(session)
+		}
+	}
+	f.addItem("bob", shampoo, 2)
+	wg.Wait()
+
+	alice, bob := f.getCart("alice"), f.getCart("bob")
+	if quantityOf(alice, "p1") != perSession || len(alice.Items) != 1 {
+		t.Fatalf("alice: lost or leaked updates: %+v", alice)
+	}
+	if quantityOf(bob, "p1") != perSession || quantityOf(bob, "p2") != 2 {
+		t.Fatalf("bob: %+v", bob)
+	}
+	if c := f.getCart(""); len(c.Items) != 0 {
+		t.Fatalf("anonymous cart must not see session items: %+v", c)
+	}
+}
+
+// add → checkout → заказы: снимок строк и суммы, корзина пуста, заказы
+// видны только своей сессии
+func TestScenarioCheckoutAndOrders(t *testing.T) {
+	f := newFixture(t)
+	if e := f.expectError(http.StatusConflict, "alice", http.MethodPost, "/cart/checkout", nil); e.Error != "cart is empty" {
+		t.Fatalf("empty cart: %q", e.Error)
+	}
+	if list := f.orders("alice"); len(list) != 0 {
+		t.Fatalf("no orders yet: %+v", list)
+	}
+
+	f.addItem("alice", soap, 2)
+	f.addItem("alice", shampoo, 1)
+	first := f.checkout("alice")
+	if first.ID == "" || first.Status != orderPlaced || !first.CreatedAt.Equal(f.clock.Now()) ||
+		len(first.Items) != 2 || first.Items[0].Product.ID != "p1" || first.Items[0].Quantity != 2 || first.Total != 1500 {
+		t.Fatalf("order: %+v", first)
+	}
+	if c := f.getCart("alice"); len(c.Items) != 0 {
+		t.Fatalf("checkout must clear the cart: %+v", c)
+	}
+
+	// Снимок: смена цены в каталоге заказ не трогает
+	dearer := shampoo
+	dearer.Price = 2000
+	f.putProduct(dearer)
+	f.clock.Advance(time.Minute)
+	f.addItem("alice", soap, 1)
+	second := f.checkout("alice")
+	f.addItem("bob", shampoo, 1)
+	f.checkout("bob")
+
+	list := f.orders("alice")
+	if len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID || list[0].Total != 1500 || list[1].Total != 250 {
+		t.Fatalf("alice's orders: %+v", list)
+	}
+	if got := f.order("alice", first.ID); got.ID != first.ID || got.Items[1].UnitPrice != 1000 || got.Status != orderPlaced {
+		t.Fatalf("order by id: %+v", got)
+	}
+	f.expectError(http.StatusNotFound, "bob", http.MethodGet, "/orders?id="+first.ID, nil)
+	f.expectError(http.StatusNotFound, "alice", http.MethodGet, "/orders?id=o-999999", nil)
+	if list := f.orders("bob"); len(list) != 1 || list[0].Total != 2000 {
+		t.Fatalf("bob's orders: %+v", list)
+	}
+	f.expectError(http.StatusMethodNotAllowed, "alice", http.MethodPost, "/orders", nil)
+}
+
+func TestScenarioCouponApplication(t *testing.T) {
+	f := newFixture(t)
+	ends := f.clock.Now().Add(time.Hour)
+	if c := f.putCoupon(Coupon{Code: "spring10", PercentOff: 10, ExpiresAt: &ends}); c.Code != "SPRING10" {
+		t.Fatalf("code should be normalized: %+v", c)
+	}
+	f.putCoupon(Coupon{Code: "BIG5", AmountOff: 500, MinSubtotal: 3000})
+
+	f.addItem("alice", shampoo, 2) // 20.00
+	if reason := f.couponRejected("alice", "nope"); reason != couponUnknown {
+		t.Fatalf("unknown code: %s", reason)
+	}
+	if reason := f.couponRejected("alice", "BIG5"); reason != couponMinSubtotal {
+		t.Fatalf("below minimum: %s", reason)
+	}
+
+	resp := f.applyCoupon("alice", "spring10")
+	if c := resp.Cart; c.Subtotal != 2000 || c.Discount != 200 || c.Total != 1800 || resp.ReplacedCoupon != "" {
+		t.Fatalf("10%% off: %+v", resp)
+	}
+
+	// Второй купон заменяет первый
+	f.addItem("alice", shampoo, 1) // 30.00
+	resp = f.applyCoupon("alice", "big5")
+	if c := resp.Cart; c.Discount != 500 || c.Total != 2500 || resp.ReplacedCoupon != "SPRING10" {
+		t.Fatalf("replacement: %+v", resp)
+	}
+	// Отказ не снимает действующий купон
+	if reason := f.couponRejected("alice", "nope"); reason != couponUnknown {
+		t.Fatalf("unknown code: %s", reason)
+	}
+	// Корзина стала меньше минимума — скидка 0, купон остаётся
+	if c := f.updateItem("alice", "p2", 2); c.Discount != 0 || c.Total != 2000 || len(c.Adjustments) != 1 {
+		t.Fatalf("below minimum after apply: %+v", c)
+	}
+	f.updateItem("alice", "p2", 3)
+
+	// Подарочная карта покрывает сумму после скидки
+	f.mintGiftCard("GIFT-10", 1000)
+	c := f.applyGiftCard("alice", "GIFT-10")
+	if c.Discount != 500 || giftCardHeld(c) != 1000 || c.Total != 1500 {
+		t.Fatalf("coupon and card: %+v", c)
+	}
+	o := f.checkout("alice")
+	if o.Subtotal != 3000 || o.Coupon != "BIG5" || o.Discount != 500 || o.GiftCard != 1000 || o.Total != 1500 {
+		t.Fatalf("order: %+v", o)
+	}
+	if c := f.getCart("alice"); len(c.Adjustments) != 0 || c.Discount != 0 {
+		t.Fatalf("checkout must drop the coupon: %+v", c)
+	}
+
+	// Истёкший купон: не применяется, а применённый раньше перестаёт давать скидку
+	f.addItem("bob", soap, 4) // 10.00
+	if c := f.applyCoupon("bob", "SPRING10").Cart; c.Discount != 100 {
+		t.Fatalf("bob: %+v", c)
+	}
+	f.clock.Advance(time.Hour)
+	if c := f.getCart("bob"); c.Discount != 0 || c.Total != 1000 {
+		t.Fatalf("expired coupon still discounts: %+v", c)
+	}
+	if reason := f.couponRejected("carol", "SPRING10"); reason != couponExpired {
+		t.Fatalf("expired: %s", reason)
+	}
+	if c := f.cartCall("bob", http.MethodPost, "/cart/coupon/remove", nil); len(c.Adjustments) != 0 {
+		t.Fatalf("removed coupon: %+v", c)
+	}
+
+	status, _ := f.admin("/admin/coupons", Coupon{Code: "BAD", PercentOff: 10, AmountOff: 100})
+	if status != http.StatusBadRequest {
+		t.Fatalf("invalid coupon: %d", status)
+	}
+}
+
+func TestScenarioCheckoutWithPriceChange(t *testing.T) {
+	f := newFixture(t)
+	f.putProduct(shampoo)
+	if c := f.addItem("alice", shampoo, 2); c.Total != 2000 {
+		t.Fatalf("catalog product must be priced by the server: %+v", c)
+	}
+
+	dearer := shampoo
+	dearer.Price = 1200
+	f.putProduct(dearer)
+	c := f.getCart("alice")
+	if it := c.Items[0]; it.UnitPrice != 1200 || !it.PriceChanged || c.Total != 2400 {
+		t.Fatalf("cart should show the new price: %+v", c)
+	}
+	pc := f.checkoutConflict("alice")
+	if len(pc.Changes) != 1 || pc.Changes[0] != (PriceChange{ProductID: "p2", OldPrice: 1000, NewPrice: 1200}) {
+		t.Fatalf("changes: %+v", pc.Changes)
+	}
+	if it := pc.Cart.Items[0]; it.PriceChanged || pc.Cart.Total != 2400 {
+		t.Fatalf("new prices should be accepted: %+v", pc.Cart)
+	}
+	if o := f.checkout("alice"); o.Subtotal != 2400 {
+		t.Fatalf("second checkout: %+v", o)
+	}
+}
+
+// Цена от клиента в корзину не попадает: тело с ценой или снимком товара
+// отклоняется, товар по ID стоит по каталогу, неизвестный ID — 404.
+func TestScenarioClientPriceIgnored(t *testing.T) {
+	f := newFixture(t)
+	f.putProduct(shampoo)
+	for _, body := range []string{
+		`{"product_id":"p2","quantity":2,"price":0.01}`,
+		`{"product":{"id":"p2","name":"Шампунь","price":0.01},"quantity":2}`,
+	} {
+		f.expectError(http.StatusBadRequest, "mallory", http.MethodPost, "/cart/add", body)
+	}
+	if c := f.getCart("mallory"); len(c.Items) != 0 {
+		t.Fatalf("rejected requests must not change the cart: %+v", c)
+	}
+	c := f.cartCall("mallory", http.MethodPost, "/cart/add", `{"product_id":"p2","quantity":2}`)
+	if it := c.Items[0]; it.Product.Price != 1000 || it.UnitPrice != 1000 || c.Total != 2000 {
+		t.Fatalf("catalog price expected: %+v", c)
+	}
+	if e := f.expectError(http.StatusNotFound, "mallory", http.MethodPost, "/cart/add", AddRequest{ProductID: "p9", Quantity: 1}); e.Error != "product not found" {
+		t.Fatalf("unexpected error %q", e.Error)
+	}
+	if o := f.checkout("mallory"); o.Total != 2000 {
+		t.Fatalf("order: %+v", o)
+	}
+}
+
+// POST /products заводит только новый товар; занятый ID — 409
+func TestScenarioCreateProduct(t *testing.T) {
+	f := newFixture(t)
+	f.expectError(http.StatusUnauthorized, "", http.MethodPost, "/products", soap)
+	if status, data := f.admin("/products", soap); status != http.StatusCreated {
+		t.Fatalf("create: %d %s", status, data)
+	}
+	dearer := soap
+	dearer.Price = 900
+	if status, data := f.admin("/products", dearer); status != http.StatusConflict {
+		t.Fatalf("duplicate id: %d %s", status, data)
+	}
+	if v := f.getProduct("p1"); v.Price != 250 {
+		t.Fatalf("duplicate must not replace the product: %+v", v)
+	}
+	if c := f.addItem("alice", dearer, 1); c.Total != 250 {
+		t.Fatalf("cart: %+v", c)
+	}
+}
+
+// Распродажа 13:00–14:00 по фальшивым часам: товар лежит в корзине до,
+// во время и после окна.
+func TestScenarioSaleWindow(t *testing.T) {
+	f := newFixture(t)
+	start := f.clock.Now().Add(time.Hour)
+	end := start.Add(time.Hour)
+	saleSoap := soap
+	saleSoap.SaleWindows = []SaleWindow{{Start: start, End: end, Price: 200}}
+	f.putProduct(saleSoap)
+
+	// До окна — обычная цена, без полей распродажи
+	if v := f.getProduct("p1"); v.Price != 250 || v.OriginalPrice != 0 || v.SaleEndsAt != nil {
+		t.Fatalf("before sale: %+v", v)
+	}
+	f.mintGiftCard("SALE", 10000)
+	f.addItem("alice", soap, 4)
+	f.applyGiftCard("alice", "SALE")
+
+	// Вход в окно: цена падает и в каталоге, и в корзине
+	f.clock.Advance(time.Hour)
+	if v := f.getProduct("p1"); v.Price != 200 || v.OriginalPrice != 250 || v.SaleEndsAt == nil || !v.SaleEndsAt.Equal(end) {
+		t.Fatalf("on sale: %+v", v)
+	}
+	code, data := f.do("", http.MethodGet, "/products", nil)
+	var list []ProductView
+	if code != http.StatusOK || json.Unmarshal(data, &list) != nil || len(list) != 1 || list[0].Price != 200 {
+		t.Fatalf("product list: %d %s", code, data)
+	}
+	c := f.getCart("alice")
+	it := c.Items[0]
+	if it.UnitPrice != 200 || it.OriginalPrice != 250 || it.SaleEndsAt == nil || !it.PriceChanged || giftCardHeld(c) != 800 {
+		t.Fatalf("cart entering the sale: %+v", c)
+	}
+	pc := f.checkoutConflict("alice")
+	if len(pc.Changes) != 1 || pc.Changes[0].OldPrice != 250 || pc.Changes[0].NewPrice != 200 {
+		t.Fatalf("changes entering the sale: %+v", pc.Changes)
+	}
+	if o := f.checkout("alice"); o.Subtotal != 800 || o.GiftCard != 800 {
+		t.Fatalf("order on sale: %+v", o)
+	}
+
+	// Во время окна: положенное сейчас оформляется без конфликта
+	f.clock.Advance(30 * time.Minute)
+	if c := f.addItem("bob", soap, 2); c.Items[0].PriceChanged || c.Total != 400 {
+		t.Fatalf("added during the sale: %+v", c)
+	}
+	if o := f.checkout("bob"); o.Subtotal != 400 {
+		t.Fatalf("order during the sale: %+v", o)
+	}
+
+	// Выход из окна (ровно на End): старая цена возвращается через 409
+	f.addItem("carol", soap, 2)
+	f.applyGiftCard("carol", "SALE")
+	f.clock.Advance(30 * time.Minute)
+	if v := f.getProduct("p1"); v.Price != 250 || v.SaleEndsAt != nil {
+		t.Fatalf("after sale: %+v", v)
+	}
+	pc = f.checkoutConflict("carol")
+	if len(pc.Changes) != 1 || pc.Changes[0].NewPrice != 250 || pc.Cart.Items[0].SaleEndsAt != nil || giftCardHeld(pc.Cart) != 500 {
+		t.Fatalf("exiting the sale: %+v", pc)
+	}
+	if o := f.checkout("carol"); o.Subtotal != 500 || o.GiftCard != 500 {
+		t.Fatalf("order after sale: %+v", o)
+	}
+
+	overlap := soap
+	overlap.SaleWindows = []SaleWindow{{Start: start, End: end, Price: 200}, {Start: end.Add(-time.Minute), End: end.Add(time.Hour), Price: 100}}
+	if status, data := f.admin("/admin/products", overlap); status != http.StatusBadRequest {
+		t.Fatalf("overlapping windows: %d %s", status, data)
+	}
+}
+
+func TestScenarioGiftCard(t *testing.T) {
+	f := newFixture(t)
+	card := f.mintGiftCard("gift-20", 2000)
+	if card.Code != "GIFT-20" || card.Balance != 2000 {
+		t.Fatalf("minted %+v", card)
+	}
+
+	f.addItem("alice", soap, 2) // 5.00
+	c := f.applyGiftCard("alice", "gift-20")
+	if giftCardHeld(c) != 500 || c.Total != 0 {
+		t.Fatalf("card should cover the whole cart: %+v", c)
+	}
+	c = f.addItem("alice", shampoo, 2) // 25.00
+	if giftCardHeld(c) != 2000 || c.Total != 500 {
+		t.Fatalf("reservation should grow up to the balance: %+v", c)
+	}
+
+	// Пока alice держит весь баланс, bob получает ноль
+	f.addItem("bob", shampoo, 1)
+	if c := f.applyGiftCard("bob", "GIFT-20"); giftCardHeld(c) != 0 || c.Total != 1000 {
+		t.Fatalf("bob must not get alice's reservation: %+v", c)
+	}
+	c = f.updateItem("alice", "p2", 1) // 15.00
+	if giftCardHeld(c) != 1500 {
+		t.Fatalf("reservation should shrink with the cart: %+v", c)
+	}
+	if c := f.getCart("bob"); giftCardHeld(c) != 500 || c.Total != 500 {
+		t.Fatalf("bob should get what alice released: %+v", c)
+	}
+
+	o := f.checkout("alice")
+	if o.Subtotal != 1500 || o.GiftCard != 1500 || o.Total != 0 {
+		t.Fatalf("alice order: %+v", o)
+	}
+	if c := f.getCart("alice"); len(c.Items) != 0 || len(c.Adjustments) != 0 {
+		t.Fatalf("checkout must empty the cart and drop the card: %+v", c)
+	}
+	gc, _ := f.app.GiftCards.Get("GIFT-20")
+	if balance, reserved := gc.Balance(); balance != 500 || reserved != 500 {
+		t.Fatalf("after alice: balance %d reserved %d", balance, reserved)
+	}
+
+	c = f.removeGiftCard("bob")
+	if len(c.Adjustments) != 0 || c.Total != 1000 {
+		t.Fatalf("removed card: %+v", c)
+	}
+	if _, reserved := gc.Balance(); reserved != 0 {
+		t.Fatalf("removing the card must release the reservation, still %d", reserved)
+	}
+
+	f.expectError(http.StatusNotFound, "bob", http.MethodPost, "/cart/giftcard", GiftCardRequest{Code: "nope"})
+	f.expectError(http.StatusConflict, "carol", http.MethodPost, "/cart/checkout", nil)
+}
+
+func TestScenarioPriceTiers(t *testing.T) {
+	f := newFixture(t)
+	f.app.Carts.Limits.MaxQuantityPerItem = 0 // ступень от 100 — сверх предела по умолчанию
+	bulkSoap := soap
+	bulkSoap.PriceTiers = []PriceTier{{MinQty: 10, UnitPrice: 200}, {MinQty: 100, UnitPrice: 150}}
+
+	c := f.addItem("alice", bulkSoap, 9)
+	if it := c.Items[0]; it.BasePrice != 250 || it.UnitPrice != 250 || c.Total != 2250 {
+		t.Fatalf("below the first tier: %+v", c)
+	}
+	c = f.addItem("alice", bulkSoap, 1)
+	if it := c.Items[0]; it.BasePrice != 250 || it.UnitPrice != 200 || c.Total != 2000 {
+		t.Fatalf("merged line at the threshold: %+v", c)
+	}
+
+	// Подарочная карта резервирует от оптовой суммы
+	f.mintGiftCard("bulk", 5000)
+	c = f.applyGiftCard("alice", "bulk")
+	if giftCardHeld(c) != 2000 || c.Total != 0 {
+		t.Fatalf("card vs tiered total: %+v", c)
+	}
+	c = f.updateItem("alice", "p1", 100)
+	if giftCardHeld(c) != 5000 || c.Total != 10000 {
+		t.Fatalf("top tier: %+v", c)
+	}
+	if o := f.checkout("alice"); o.Subtotal != 15000 || o.GiftCard != 5000 || o.Total != 10000 {
+		t.Fatalf("order: %+v", o)
+	}
+
+	bad := bulkSoap
+	bad.PriceTiers = []PriceTier{{MinQty: 10, UnitPrice: 300}}
+	if status, data := f.admin("/admin/products", bad); status != http.StatusBadRequest || !strings.Contains(string(data), "price tier 0: unit_price must be lower than 2.50") {
+		t.Fatalf("bad tiers: %d %s", status, data)
+	}
+}
+
+func TestScenarioGiftCardAdminAuth(t *testing.T) {
+	f := newFixture(t)
+	f.expectError(http.StatusUnauthorized, "", http.MethodPost, "/admin/giftcards", MintGiftCardRequest{Balance: 100})
+	f.mintGiftCard("ONCE", 100)
+	if _, err := f.app.GiftCards.Mint("once", 100); !errors.Is(err, errGiftCardExists) {
+		t.Fatalf("duplicate code: %v", err)
+	}
+	f.app.AdminToken = ""
+	f.expectError(http.StatusNotFound, "", http.MethodPost, "/admin/giftcards", MintGiftCardRequest{Balance: 100})
+}
+
+func TestScenarioGiftCardReleasedOnCartExpiry(t *testing.T) {
+	f := newFixture(t)
+	f.mintGiftCard("TTL", 1000)
+	f.addItem("alice", shampoo, 1)
+	f.applyGiftCard("alice", "TTL")
+	gc, _ := f.app.GiftCards.Get("TTL")
+	if _, reserved := gc.Balance(); reserved != 1000 {
+		t.Fatalf("reserved %d", reserved)
+	}
+
+	f.clock.Advance(defaultCartTTL / 2)
+	f.addItem("bob", soap, 1) // bob свежий, alice простаивает
+	f.clock.Advance(defaultCartTTL/2 + time.Second)
+	if n := f.app.Carts.Expire(); n != 1 {
+		t.Fatalf("expired %d carts, want 1", n)
+	}
+	if balance, reserved := gc.Balance(); balance != 1000 || reserved != 0 {
+		t.Fatalf("expiry must release without spending: balance %d reserved %d", balance, reserved)
+	}
+	if c := f.getCart("alice"); len(c.Items) != 0 {
+		t.Fatalf("expired cart should come back empty: %+v", c)
+	}
+}
+
+// Срок корзины считается от последнего изменения: чтение его не продлевает,
+// а обращение к истёкшей корзине отдаёт новую пустую.
+func TestScenarioCartExpiresAfterLastChange(t *testing.T) {
+	f := newFixture(t)
+	expiresAt := func(session string) (string, Cart) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, f.srv.URL+"/cart", nil)
+		req.Header.Set("X-Cart-ID", session)
+		resp, err := f.srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var c Cart
+		json.NewDecoder(resp.Body).Decode(&c)
+		return resp.Header.Get(cartExpiresHeader), c
+	}
+
+	start := f.clock.Now()
+	f.addItem("alice", soap, 1)
+	f.clock.Advance(time.Hour)
+	f.addItem("alice", soap, 1)
+	want := isoTime(start.Add(time.Hour + defaultCartTTL))
+	if got, _ := expiresAt("alice"); got != want {
+		t.Fatalf("%s %q, want %q", cartExpiresHeader, got, want)
+	}
+
+	f.clock.Advance(defaultCartTTL - time.Second)
+	if got, c := expiresAt("alice"); got != want || len(c.Items) != 1 {
+		t.Fatalf("a read must not extend the cart: %q, %+v", got, c)
+	}
+
+	f.clock.Advance(time.Second)
+	got, c := expiresAt("alice")
+	if len(c.Items) != 0 || c.Version != 0 || got != isoTime(f.clock.Now().Add(defaultCartTTL)) {
+		t.Fatalf("expired cart on access: %q, %+v", got, c)
+	}
+	if abandoned := f.exportCSV("/admin/export/carts?status=abandoned"); len(abandoned) != 2 || abandoned[1][0] != "alice" {
+		t.Fatalf("abandoned: %v", abandoned)
+	}
+	if n := f.app.Carts.Expire(); n != 0 {
+		t.Fatalf("janitor expired %d carts after the fresh one replaced alice's", n)
+	}
+}
+
+// Одна карта, много корзин, оформление параллельно: списано ровно столько,
+// сколько было на карте, и ни центом больше.
+func TestScenarioGiftCardParallelCheckouts(t *testing.T) {
+	f := newFixture(t)
+	const carts = 30
+	f.mintGiftCard("SHARED", 10000) // 100.00, спрос — 300.00
+
+	var wg sync.WaitGroup
+	for i := 0; i < carts; i++ {
+		wg.Add(1)
+		go func(session string) {
+			defer wg.Done()
+			f.addItem(session, shampoo, 1)
+			f.applyGiftCard(session, "SHARED")
+		}(fmt.Sprintf("c%d", i))
+	}
+	wg.Wait()
+
+	var (
+		mu    sync.Mutex
+		spent Money
+	)
+	for i := 0; i < carts; i++ {
+		wg.Add(1)
+		go func(session string) {
+			defer wg.Done()
+			o := f.checkout(session)
+			if o.GiftCard < 0 || o.GiftCard > 1000 || o.Total+o.GiftCard != 1000 {
+				t.Errorf("%s: inconsistent order %+v", session, o)
+			}
+			mu.Lock()
+			spent += o.GiftCard
+			mu.Unlock()
+		}(fmt.Sprintf("c%d", i))
+	}
+	wg.Wait()
+
+	gc, _ := f.app.GiftCards.Get("SHARED")
+	balance, reserved := gc.Balance()
+	if spent != 10000 || balance != 0 || reserved != 0 {
+		t.Fatalf("spent %d, balance %d, reserved %d", spent, balance, reserved)
+	}
+}
+
+// Последнюю штуку кладут в корзины параллельно все, но оформить её
+// удаётся ровно одному; остальные получают 409 и остаток 0
+func TestScenarioLastUnitRace(t *testing.T) {
+	f := newFixture(t)
+	const carts = 20
+	towel := Product{ID: "p4", Name: "Полотенце", Price: 1500, Stock: 1}
+	f.putProduct(towel)
+
+	e := f.expectError(http.StatusConflict, "early", http.MethodPost, "/cart/add", AddRequest{ProductID: "p4", Quantity: 2})
+	if !strings.Contains(e.Error, "available 1") {
+		t.Fatalf("add over stock: %q", e.Error)
+	}
+	f.addItem("early", towel, 1)
+	f.expectError(http.StatusConflict, "early", http.MethodPost, "/cart/update?id=p4", UpdateRequest{Quantity: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < carts; i++ {
+		wg.Add(1)
+		go func(session string) {
+			defer wg.Done()
+			f.addItem(session, towel, 1)
+		}(fmt.Sprintf("c%d", i))
+	}
+	wg.Wait()
+
+	var (
+		mu                 sync.Mutex
+		placed, outOfStock int
+	)
+	for i := 0; i < carts; i++ {
+		wg.Add(1)
+		go func(session string) {
+			defer wg.Done()
+			code, data := f.do(session, http.MethodPost, "/cart/checkout", nil)
+			mu.Lock()
+			defer mu.Unlock()
+			switch code {
+			case http.StatusCreated:
+				placed++
+			case http.StatusConflict:
+				if !strings.Contains(string(data), `"available":0`) {
+					t.Errorf("%s: %s", session, data)
+				}
+				outOfStock++
+			default:
+				t.Errorf("%s: status %d: %s", session, code, data)
+			}
+		}(fmt.Sprintf("c%d", i))
+	}
+	wg.Wait()
+
+	if placed != 1 || outOfStock != carts-1 {
+		t.Fatalf("placed %d, out of stock %d", placed, outOfStock)
+	}
+	if p := f.getProduct("p4"); p.Stock != 0 {
+		t.Fatalf("stock after the race: %d", p.Stock)
+	}
+	code, data := f.do("", http.MethodGet, "/products", nil)
+	if code != http.StatusOK || !strings.Contains(string(data), `"id":"p4","name":"Полотенце","stock":0`) {
+		t.Fatalf("/products should show the stock: %s", data)
+	}
+	f.expectError(http.StatusConflict, "early", http.MethodPost, "/cart/checkout", nil)
+}
+
+// Каждая ошибка приходит со стабильным кодом; сценарий проходит по
+// ошибкам разных слоёв: разбор тела, проверка, сервис, маршрутизация
+func TestScenarioErrorCodes(t *testing.T) {
+	f := newFixture(t)
+	f.putProduct(Product{ID: "p4", Name: "Полотенце", Price: 1500, Stock: 1})
+
+	for _, c := range []struct {
+		status       int
+		method, path string
+		body         interface{}
+		code, field  string
+	}{
+		{http.StatusBadRequest, http.MethodPost, "/cart/add", "{", codeInvalidJSON, ""},
+		{http.StatusBadRequest, http.MethodPost, "/cart/add", AddRequest{ProductID: "p1"}, codeInvalidQuantity, "quantity"},
+		{http.StatusBadRequest, http.MethodPost, "/cart/add", AddRequest{Quantity: 1}, codeMissingField, "product_id"},
+		{http.StatusNotFound, http.MethodPost, "/cart/add", AddRequest{ProductID: "p9", Quantity: 1}, codeProductNotFound, "product_id"},
+		{http.StatusConflict, http.MethodPost, "/cart/add", AddRequest{ProductID: "p4", Quantity: 2}, codeInsufficientStock, "quantity"},
+		{http.StatusConflict, http.MethodPost, "/cart/checkout", nil, codeCartEmpty, ""},
+		{http.StatusNotFound, http.MethodPatch, "/cart/items/p1", UpdateRequest{Quantity: 1}, codeNotInCart, ""},
+		{http.StatusBadRequest, http.MethodPost, "/cart/update?id=p1", UpdateRequest{Quantity: 1}, codeNotInCart, ""},
+		{http.StatusNotFound, http.MethodPost, "/cart/giftcard", GiftCardRequest{Code: "NOPE"}, codeGiftCardNotFound, "code"},
+		{http.StatusNotFound, http.MethodGet, "/orders?id=o-1", nil, codeOrderNotFound, "id"},
+		{http.StatusMethodNotAllowed, http.MethodPut, "/cart/checkout", nil, codeMethodNotAllowed, ""},
+		{http.StatusNotFound, http.MethodGet, "/nowhere", nil, codeNotFound, ""},
+	} {
+		if e := f.expectError(c.status, "alice", c.method, c.path, c.body); e.Code != c.code || e.Field != c.field {
+			t.Errorf("%s %s: code %q field %q, want %q %q (%s)", c.method, c.path, e.Code, e.Field, c.code, c.field, e.Error)
+		}
+	}
+
+	// Отказ купона: код ошибки в code, код купона — в coupon
+	_, data := f.do("alice", http.MethodPost, "/cart/coupon", CouponRequest{Code: "nope"})
+	var ce struct {
+		Code   string `json:"code"`
+		Coupon string `json:"coupon"`
+		Reason string `json:"reason"`
+	}
+	if json.Unmarshal(data, &ce) != nil || ce.Code != codeCouponRejected || ce.Coupon != "NOPE" || ce.Reason != couponUnknown {
+		t.Fatalf("coupon rejection: %s", data)
+	}
+}
+
+// Восстановление сохранённой корзины одним запросом: хоть одна плохая
+// строка — не добавлено ничего, ошибки строк приходят с индексами
+func TestScenarioBulkAdd(t *testing.T) {
+	f := newFixture(t)
+	f.stock(shampoo)
+	f.putProduct(Product{ID: "p4", Name: "Полотенце", Price: 1500, Stock: 2})
+	before := f.addItem("alice", soap, 1)
+
+	code, data := f.do("alice", http.MethodPost, "/cart/items/bulk", BulkAddRequest{Items: []BulkLine{
+		{ProductID: "p2", Quantity: 2},
+		{ProductID: "p9", Quantity: 1},
+		{ProductID: "p4", Quantity: 3},
+		{ProductID: "p1", Quantity: -1},
+	}})
+	var e struct {
+		Code     string `json:"code"`
+		Rejected []struct {
+			Index     int    `json:"index"`
+			Code      string `json:"code"`
+			Available *int   `json:"available"`
+		} `json:"rejected"`
+	}
+	if code != http.StatusUnprocessableEntity || json.Unmarshal(data, &e) != nil || e.Code != codeItemsRejected || len(e.Rejected) != 3 {
+		t.Fatalf("mixed payload: %d %s", code, data)
+	}
+	for i, want := range []struct {
+		index int
+		code  string
+	}{{1, codeProductNotFound}, {2, codeInsufficientStock}, {3, codeInvalidQuantity}} {
+		if r := e.Rejected[i]; r.Index != want.index || r.Code != want.code {
+			t.Fatalf("rejected[%d] = %+v, want %d %s (%s)", i, r, want.index, want.code, data)
+		}
+	}
+	if a := e.Rejected[1].Available; a == nil || *a != 2 {
+		t.Fatalf("stock error without available: %s", data)
+	}
+	if c := f.getCart("alice"); len(c.Items) != 1 || quantityOf(c, "p1") != 1 || c.Version != before.Version {
+		t.Fatalf("rejected bulk add changed the cart: %+v", c)
+	}
+
+	c := f.cartCall("alice", http.MethodPost, "/cart/items/bulk", BulkAddRequest{Items: []BulkLine{
+		{ProductID: "p2", Quantity: 2},
+		{ProductID: "p4", Quantity: 2},
+		{ProductID: "p1", Quantity: 4},
+	}})
+	if quantityOf(c, "p1") != 5 || quantityOf(c, "p2") != 2 || quantityOf(c, "p4") != 2 || c.Version != before.Version+1 {
+		t.Fatalf("bulk add: %+v", c)
+	}
+	f.expectError(http.StatusBadRequest, "alice", http.MethodPost, "/cart/items/bulk", BulkAddRequest{})
+	f.expectError(http.StatusMethodNotAllowed, "alice", http.MethodGet, "/cart/items/bulk", nil)
+}
+
+func TestScenarioCartLimits(t *testing.T) {
+	f := newFixture(t)
+	f.app.Carts.Limits = CartLimits{MaxQuantityPerItem: 99, MaxDistinctItems: 1}
+	f.addItem("alice", soap, 50)
+
+	code, data := f.do("alice", http.MethodPost, "/cart/items", AddRequest{ProductID: "p1", Quantity: 60})
+	var e struct {
+		Code      string `json:"code"`
+		Field     string `json:"field"`
+		ProductID string `json:"product_id"`
+		Requested int    `json:"requested"`
+		Limit     int    `json:"limit"`
+	}
+	if code != http.StatusUnprocessableEntity || json.Unmarshal(data, &e) != nil ||
+		e.Code != codeQuantityLimit || e.Field != "quantity" || e.ProductID != "p1" || e.Requested != 110 || e.Limit != 99 {
+		t.Fatalf("50 + 60: %d %s", code, data)
+	}
+	if c := f.addItem("alice", soap, 49); quantityOf(c, "p1") != 99 {
+		t.Fatalf("up to the limit: %+v", c)
+	}
+	if e := f.expectError(http.StatusUnprocessableEntity, "alice", http.MethodPatch, cartItemsPrefix+"p1", UpdateRequest{Quantity: 100}); e.Code != codeQuantityLimit {
+		t.Fatalf("update over the limit: %+v", e)
+	}
+
+	f.stock(shampoo)
+	code, data = f.do("alice", http.MethodPost, "/cart/items", AddRequest{ProductID: "p2", Quantity: 1})
+	if code != http.StatusUnprocessableEntity || !strings.Contains(string(data), `"code":"CART_FULL"`) || !strings.Contains(string(data), `"limit":1`) {
+		t.Fatalf("second line: %d %s", code, data)
+	}
+	if c := f.getCart("alice"); len(c.Items) != 1 || quantityOf(c, "p1") != 99 {
+		t.Fatalf("rejected adds changed the cart: %+v", c)
+	}
+}
+
+func TestScenarioMalformedJSON(t *testing.T) {
+	f := newFixture(t)
+	f.addItem("alice", soap, 1)
+
+	bodies := []string{"", "{", `{"quantity":"два"}`, `[1,2]`, `{"product":"p1"}`, "null garbage"}
+	endpoints := []string{"/cart/add", "/cart/update?id=p1"}
+	for _, path := range endpoints {
+		for _, body := range bodies {
+			t.Run(fmt.Sprintf("%s %q", path, body), func(t *testing.T) {
+				code, data := f.do("alice", http.MethodPost, path, body)
+				var e apiError
+				if code != http.StatusBadRequest || json.Unmarshal(data, &e) != nil || e.Error == "" {
+					t.Fatalf("want 400 envelope, got %d %s", code, data)
+				}
+			})
+		}
+	}
+	// Эндпоинты без тела игнорируют его
+	if c := f.cartCall("alice", http.MethodGet, "/cart/get", "{"); quantityOf(c, "p1") != 1 {
+		t.Fatalf("malformed requests must not change the cart: %+v", c)
+	}
+	f.cartCall("alice", http.MethodPost, "/cart/remove?id=zzz", "{")
+	f.cartCall("bob", http.MethodPost, "/cart/clear", "{")
+}
+
+func TestScenarioErrorEnvelope(t *testing.T) {
+	f := newFixture(t)
+	f.expectError(http.StatusMethodNotAllowed, "", http.MethodGet, "/cart/add", nil)
+	f.expectError(http.StatusMethodNotAllowed, "", http.MethodPost, "/cart/get", nil)
+	f.expectError(http.StatusNotFound, "", http.MethodGet, "/cart/nope", nil)
+	f.expectError(http.StatusBadRequest, "", http.MethodPost, "/cart/add", AddRequest{ProductID: soap.ID, Quantity: 0})
+	f.expectError(http.StatusBadRequest, "", http.MethodPost, "/cart/add", AddRequest{Quantity: 1})
+	f.expectError(http.StatusNotFound, "", http.MethodPost, "/cart/add", AddRequest{ProductID: "nope", Quantity: 1})
+	f.expectError(http.StatusBadRequest, "", http.MethodPost, "/cart/update", UpdateRequest{Quantity: 1})
+	f.expectError(http.StatusBadRequest, "", http.MethodPost, "/cart/remove", nil)
+}
+
+func TestScenarioRESTRoutes(t *testing.T) {
+	f := newFixture(t)
+	f.stock(soap)
+	f.stock(shampoo)
+	item := cartItemsPrefix + soap.ID
+	add := AddRequest{ProductID: soap.ID, Quantity: 1}
+	set := UpdateRequest{Quantity: 2}
+
+	for _, c := range []struct {
+		method, path string
+		body         interface{}
+		status       int
+		allow        string // Allow при 405
+	}{
+		{http.MethodGet, "/cart", nil, http.StatusOK, ""},
+		{http.MethodPost, "/cart/items", add, http.StatusOK, ""},
+		{http.MethodPatch, item, set, http.StatusOK, ""},
+		{http.MethodDelete, item, nil, http.StatusOK, ""},
+		{http.MethodDelete, "/cart", nil, http.StatusOK, ""},
+
+		{http.MethodPost, "/cart", nil, http.StatusMethodNotAllowed, "DELETE, GET"},
+		{http.MethodPut, "/cart", nil, http.StatusMethodNotAllowed, "DELETE, GET"},
+		{http.MethodPatch, "/cart", nil, http.StatusMethodNotAllowed, "DELETE, GET"},
+		{http.MethodGet, "/cart/items", nil, http.StatusMethodNotAllowed, "POST"},
+		{http.MethodDelete, "/cart/items", nil, http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPatch, "/cart/items", set, http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, item, nil, http.StatusMethodNotAllowed, "DELETE, PATCH"},
+		{http.MethodPost, item, add, http.StatusMethodNotAllowed, "DELETE, PATCH"},
+		{http.MethodPut, item, set, http.StatusMethodNotAllowed, "DELETE, PATCH"},
+
+		// Неизвестные товары и пути
+		{http.MethodPost, "/cart/items", AddRequest{ProductID: "nope", Quantity: 1}, http.StatusNotFound, ""},
+		{http.MethodPatch, cartItemsPrefix + "nope", set, http.StatusNotFound, ""},
+		{http.MethodDelete, cartItemsPrefix + "nope", nil, http.StatusNotFound, ""},
+		{http.MethodPatch, cartItemsPrefix + shampoo.ID, set, http.StatusNotFound, ""}, // в каталоге, но не в корзине
+		{http.MethodDelete, cartItemsPrefix + shampoo.ID, nil, http.StatusNotFound, ""},
+		{http.MethodPatch, cartItemsPrefix, set, http.StatusNotFound, ""},
+		{http.MethodDelete, item + "/extra", nil, http.StatusNotFound, ""},
+		{http.MethodPatch, item, `{"quantity":-1}`, http.StatusBadRequest, ""},
+		{http.MethodPatch, item, "{", http.StatusBadRequest, ""},
+
+		// Устаревшие псевдонимы
+		{http.MethodGet, "/cart/get", nil, http.StatusOK, ""},
+		{http.MethodPost, "/cart/add", add, http.StatusOK, ""},
+		{http.MethodPost, "/cart/update?id=" + soap.ID, set, http.StatusOK, ""},
+		{http.MethodPost, "/cart/remove?id=" + soap.ID, nil, http.StatusOK, ""},
+		{http.MethodPost, "/cart/remove?id=nope", nil, http.StatusOK, ""},
+		{http.MethodPost, "/cart/update?id=nope", set, http.StatusBadRequest, ""},
+		{http.MethodPost, "/cart/clear", nil, http.StatusOK, ""},
+		{http.MethodGet, "/cart/add", nil, http.StatusMethodNotAllowed, "POST"},
+		{http.MethodDelete, "/cart/clear", nil, http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPost, "/cart/get", nil, http.StatusMethodNotAllowed, "GET"},
+	} {
+		// Перед каждым запросом в корзине одна штука мыла
+		f.cartCall("rest", http.MethodDelete, "/cart", nil)
+		f.addItem("rest", soap, 1)
+
+		var rd io.Reader
+		if b, ok := c.body.(string); ok {
+			rd = strings.NewReader(b)
+		} else if c.body != nil {
+			data, _ := json.Marshal(c.body)
+			rd = bytes.NewReader(data)
+		}
+		req, _ := http.NewRequest(c.method, f.srv.URL+c.path, rd)
+		req.Header.Set("X-Cart-ID", "rest")
+		resp, err := f.srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e apiError
+		json.NewDecoder(resp.Body).Decode(&e)
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s %s: %d, want %d (%s)", c.method, c.path, resp.StatusCode, c.status, e.Error)
+			continue
+		}
+		if c.status >= 400 && e.Error == "" {
+			t.Errorf("%s %s: no error envelope", c.method, c.path)
+		}
+		if got := resp.Header.Get("Allow"); got != c.allow {
+			t.Errorf("%s %s: Allow %q, want %q", c.method, c.path, got, c.allow)
+		}
+		legacy := !strings.HasPrefix(c.path, cartItemsPrefix) && c.path != "/cart" && c.path != "/cart/items"
+		if got := resp.Header.Get("Deprecation"); (got == "true") != legacy {
+			t.Errorf("%s %s: Deprecation %q", c.method, c.path, got)
+		}
+	}
+
+	// PATCH меняет количество, DELETE удаляет строку
+	f.clearCart("rest")
+	f.addItem("rest", soap, 1)
+	if c := f.updateItem("rest", soap.ID, 5); quantityOf(c, soap.ID) != 5 {
+		t.Fatalf("PATCH: %+v", c)
+	}
+	if c := f.removeItem("rest", soap.ID); len(c.Items) != 0 {
+		t.Fatalf("DELETE: %+v", c)
+	}
+}
+
+func TestScenarioStaleVersion(t *testing.T) {
+	f := newFixture(t)
+	f.stock(shampoo)
+	seen := f.addItem("v", soap, 1)
+	if seen.Version != 1 {
+		t.Fatalf("version after the first add: %d", seen.Version)
+	}
+	// ifMatch — запрос с If-Match; version "" — без заголовка
+	ifMatch := func(method, path, version string, body interface{}) (int, []byte) {
+		var rd io.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			rd = bytes.NewReader(data)
+		}
+		req, _ := http.NewRequest(method, f.srv.URL+path, rd)
+		req.Header.Set("X-Cart-ID", "v")
+		if version != "" {
+			req.Header.Set("If-Match", version)
+		}
+		resp, err := f.srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	// Первый клиент меняет корзину по той версии, что видел
+	code, data := ifMatch(http.MethodPatch, cartItemsPrefix+soap.ID, `"1"`, UpdateRequest{Quantity: 3})
+	if code != http.StatusOK {
+		t.Fatalf("PATCH with the current version: %d %s", code, data)
+	}
+
+	// Второй видел версию 1 — все его изменения получают 409
+	v1 := int64(1)
+	for _, c := range []struct {
+		method, path, version string
+		body                  interface{}
+	}{
+		{http.MethodPatch, cartItemsPrefix + soap.ID, "1", UpdateRequest{Quantity: 5}},
+		{http.MethodPatch, cartItemsPrefix + soap.ID, "", UpdateRequest{Quantity: 5, ExpectedVersion: &v1}},
+		{http.MethodPost, "/cart/items", "", AddRequest{ProductID: shampoo.ID, Quantity: 1, ExpectedVersion: &v1}},
+		{http.MethodDelete, cartItemsPrefix + soap.ID, `"1"`, nil},
+		{http.MethodDelete, "/cart", "1", nil},
+		{http.MethodPost, "/cart/checkout", "1", nil},
+	} {
+		code, data := ifMatch(c.method, c.path, c.version, c.body)
+		var body struct {
+			Error   string `json:"error"`
+			Version int64  `json:"version"`
+		}
+		json.Unmarshal(data, &body)
+		if code != http.StatusConflict || body.Error == "" || body.Version != 2 {
+			t.Fatalf("%s %s with version 1: %d %s", c.method, c.path, code, data)
+		}
+	}
+	if got := f.getCart("v"); got.Version != 2 || len(got.Items) != 1 || quantityOf(got, soap.ID) != 3 {
+		t.Fatalf("stale requests changed the cart: %+v", got)
+	}
+
+	// Кривой If-Match и расхождение с телом — 400
+	if code, _ := ifMatch(http.MethodDelete, "/cart", "W/abc", nil); code != http.StatusBadRequest {
+		t.Fatalf("bad If-Match: %d", code)
+	}
+	v2 := int64(2)
+	if code, _ := ifMatch(http.MethodPost, "/cart/items", "1", AddRequest{ProductID: shampoo.ID, Quantity: 1, ExpectedVersion: &v2}); code != http.StatusBadRequest {
+		t.Fatalf("If-Match and expected_version disagree: %d", code)
+	}
+	if code, data := ifMatch(http.MethodPost, "/cart/checkout", "2", nil); code != http.StatusCreated {
+		t.Fatalf("checkout with the current version: %d %s", code, data)
+	}
+}
+
+func TestScenarioRateLimitRecoversWithClock(t *testing.T) {
+	f := newFixture(t, func(cfg *RateLimitConfig) {
+		cfg.Write = Budget{Burst: 2, PerSecond: 0.5}
+	})
+	f.addItem("alice", soap, 1)
+	f.addItem("alice", soap, 1)
+	f.expectError(http.StatusTooManyRequests, "alice", http.MethodPost, "/cart/add", AddRequest{ProductID: soap.ID, Quantity: 1})
+	// Бюджет — на IP: другая сессия с того же адреса его не обходит
+	f.expectError(http.StatusTooManyRequests, "bob", http.MethodPost, "/cart/add", AddRequest{ProductID: soap.ID, Quantity: 1})
+
+	f.clock.Advance(2 * time.Second)
+	if c := f.addItem("alice", soap, 1); quantityOf(c, "p1") != 3 {
+		t.Fatalf("budget should refill after the fake clock moves: %+v", c)
+	}
+}
+
+// adminGet — GET админского эндпоинта с токеном; тело читается целиком,
+// после чего доступны трейлеры ответа
+func (f *fixture) adminGet(path string) (*http.Response, []byte) {
+	f.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, f.srv.URL+path, nil)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp, err := f.srv.Client().Do(req)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return resp, data
+}
+
+// exportCSV ожидает 200 и разбирает CSV; проверяет, что трейлер совпадает
+// с числом строк без заголовка
+func (f *fixture) exportCSV(path string) [][]string {
+	f.t.Helper()
+	resp, data := f.adminGet(path)
+	if resp.StatusCode != http.StatusOK {
+		f.t.Fatalf("%s: status %d: %s", path, resp.StatusCode, data)
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		f.t.Fatalf("%s: bad csv: %v\n%s", path, err, data)
+	}
+	if got := resp.Trailer.Get(exportRowsTrailer); got != strconv.Itoa(len(records)-1) {
+		f.t.Fatalf("%s: trailer %q, %d rows", path, got, len(records)-1)
+	}
+	return records
+}
+
+// placeOrders оформляет n заказов с интервалом в минуту: чётные — мыло с
+// запятой и кавычками в названии, нечётные — ещё и шампунь. Возвращает
+// время первого заказа и число позиций.
+func (f *fixture) placeOrders(n int) (time.Time, int) {
+	f.t.Helper()
+	f.putProduct(fancySoap)
+	first, lines := f.clock.Now(), 0
+	for i := 0; i < n; i++ {
+		session := fmt.Sprintf("buyer-%d", i)
+		f.addItem(session, fancySoap, 1+i%3)
+		lines++
+		if i%2 == 1 {
+			f.addItem(session, shampoo, 1)
+			lines++
+		}
+		f.checkout(session)
+		f.clock.Advance(time.Minute)
+	}
+	return first, lines
+}
+
+var fancySoap = Product{ID: "p3", Name: `Мыло "Лаванда", 100 г`, Price: 310, Stock: 1000}
+
+func TestScenarioExportOrders(t *testing.T) {
+	f := newFixture(t)
+	first, lines := f.placeOrders(300)
+
+	records := f.exportCSV("/admin/export/orders")
+	if len(records) != lines+1 || strings.Join(records[0], ",") != strings.Join(orderColumns, ",") {
+		t.Fatalf("%d records, header %v", len(records), records[0])
+	}
+	col := map[string]int{}
+	for i, name := range records[0] {
+		col[name] = i
+	}
+	row := records[1]
+	if row[col["order_id"]] != "o-000001" || row[col["product_name"]] != fancySoap.Name ||
+		row[col["product_price"]] != "3.10" || row[col["line_total"]] != "3.10" ||
+		row[col["created_at"]] != "2024-05-01T12:00:00Z" || row[col["cart_id"]] != "buyer-0" {
+		t.Fatalf("first row: %v", row)
+	}
+
+	// JSONL: объект на строку, те же данные
+	resp, data := f.adminGet("/admin/export/orders?format=jsonl")
+	jsonLines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" || len(jsonLines) != lines || resp.Trailer.Get(exportRowsTrailer) != strconv.Itoa(lines) {
+		t.Fatalf("jsonl: %d lines, trailer %q", len(jsonLines), resp.Trailer.Get(exportRowsTrailer))
+	}
+	var obj map[string]string
+	if err := json.Unmarshal([]byte(jsonLines[0]), &obj); err != nil || obj["product_name"] != fancySoap.Name || obj["unit_price"] != "3.10" {
+		t.Fatalf("jsonl row %s: %v", jsonLines[0], err)
+	}
+
+	// Период [from, to): заказ ровно на from входит, ровно на to — нет
+	from, to := first.Add(100*time.Minute), first.Add(200*time.Minute)
+	records = f.exportCSV("/admin/export/orders?from=" + from.Format(time.RFC3339) + "&to=" + to.Format(time.RFC3339))
+	orders := map[string]bool{}
+	for _, r := range records[1:] {
+		orders[r[col["order_id"]]] = true
+	}
+	if len(orders) != 100 || !orders["o-000101"] || orders["o-000201"] || orders["o-000100"] {
+		t.Fatalf("period: %d orders", len(orders))
+	}
+	if records := f.exportCSV("/admin/export/orders?from=2024-05-02"); len(records) != 1 {
+		t.Fatalf("future period: %d records", len(records))
+	}
+	if records := f.exportCSV("/admin/export/orders?to=2024-05-02"); len(records) != lines+1 {
+		t.Fatalf("date-only to: %d records", len(records))
+	}
+
+	if resp, _ := f.adminGet("/admin/export/orders?format=xml"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad format: %d", resp.StatusCode)
+	}
+	if resp, _ := f.adminGet("/admin/export/orders?from=yesterday"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad from: %d", resp.StatusCode)
+	}
+	f.expectError(http.StatusUnauthorized, "", http.MethodGet, "/admin/export/orders", nil)
+}
+
+func TestScenarioExportCarts(t *testing.T) {
+	f := newFixture(t)
+	f.addItem("alice", soap, 2)
+	f.addItem("alice", shampoo, 1)
+	f.clock.Advance(defaultCartTTL + time.Second)
+	f.addItem("bob", fancySoap, 1)
+	f.app.Carts.Expire()
+
+	active := f.exportCSV("/admin/export/carts?status=active")
+	if len(active) != 2 || active[1][0] != "bob" || active[1][1] != "active" || active[1][4] != fancySoap.Name {
+		t.Fatalf("active: %v", active)
+	}
+	abandoned := f.exportCSV("/admin/export/carts?status=abandoned")
+	if len(abandoned) != 3 || abandoned[1][0] != "alice" || abandoned[1][1] != "abandoned" ||
+		abandoned[1][2] != "2024-05-01T12:00:00Z" || abandoned[1][3] != "p1" || abandoned[2][3] != "p2" {
+		t.Fatalf("abandoned: %v", abandoned)
+	}
+	if all := f.exportCSV("/admin/export/carts"); len(all) != 4 {
+		t.Fatalf("all carts: %v", all)
+	}
+	if resp, _ := f.adminGet("/admin/export/carts?status=gone"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad status: %d", resp.StatusCode)
+	}
+}
+
+// Запрос, отменённый до начала, не отдаёт ни одной строки
+func TestExportStopsOnCancelledRequest(t *testing.T) {
+	f := newFixture(t)
+	f.placeOrders(5)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/admin/export/orders", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	f.app.Handler().ServeHTTP(rec, req)
+	if got := rec.Header().Get(exportRowsTrailer); got != "0" || strings.Count(rec.Body.String(), "\n") != 1 {
+		t.Fatalf("cancelled export: trailer %q, body %q", got, rec.Body.String())
+	}
+}
+
+func TestScenarioRequestTracing(t *testing.T) {
+	f := newFixture(t)
+	var (
+		mu     sync.Mutex
+		events []Event
+		logBuf bytes.Buffer
+	)
+	f.app.Logger = log.New(&logBuf, "", 0)
+	f.app.Events = func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}
+	checkout := func(session, requestID string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodPost, f.srv.URL+"/cart/checkout", nil)
+		req.Header.Set("X-Cart-ID", session)
+		if requestID != "" {
+			req.Header.Set(requestIDHeader, requestID)
+		}
+		resp, err := f.srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, data
+	}
+
+	// Неудачный checkout: один ID в заголовке, теле ошибки, событии и логе
+	resp, data := checkout("empty", "trace-42")
+	var e struct {
+		Error     string `json:"error"`
+		RequestID string `json:"request_id"`
+	}
+	json.Unmarshal(data, &e)
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get(requestIDHeader) != "trace-42" || e.RequestID != "trace-42" {
+		t.Fatalf("%d, header %q, body %s", resp.StatusCode, resp.Header.Get(requestIDHeader), data)
+	}
+	mu.Lock()
+	payload, _ := json.Marshal(events)
+	mu.Unlock()
+	var got []map[string]string
+	json.Unmarshal(payload, &got)
+	if len(got) != 1 || got[0]["type"] != eventCheckoutFailed || got[0]["request_id"] != "trace-42" || got[0]["error"] != errEmptyCart.Error() {
+		t.Fatalf("events: %s", payload)
+	}
+	for _, want := range []string{"req=trace-42 event checkout.failed cart=empty", "req=trace-42 POST /cart/checkout -> 409"} {
+		if !strings.Contains(logBuf.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logBuf.String())
+		}
+	}
+
+	// Без входящего (или с негодным) ID — новый; он же в заказе и событии
+	f.addItem("alice", soap, 1)
+	resp, data = checkout("alice", "bad id")
+	generated := resp.Header.Get(requestIDHeader)
+	var o Order
+	json.Unmarshal(data, &o)
+	if resp.StatusCode != http.StatusCreated || !validRequestID(generated) || generated == "bad id" || o.RequestID != generated {
+		t.Fatalf("%d, header %q, order request_id %q", resp.StatusCode, generated, o.RequestID)
+	}
+	mu.Lock()
+	last := events[len(events)-1]
+	mu.Unlock()
+	if last.Type != eventOrderPlaced || last.OrderID != o.ID || last.RequestID != generated {
+		t.Fatalf("order event: %+v", last)
+	}
+
+	// Каждая строка лога — с ID запроса
+	for _, line := range strings.Split(strings.TrimSpace(logBuf.String()), "\n") {
+		if !strings.HasPrefix(line, "req=") || strings.HasPrefix(line, "req=- ") {
+			t.Errorf("log line without request id: %q", line)
+		}
+	}
+	// И в ошибках вне обработчиков корзины
+	if _, data := f.do("", http.MethodGet, "/nope", nil); !strings.Contains(string(data), `"request_id"`) {
+		t.Fatalf("404 without request_id: %s", data)
+	}
+}
+
+// restart — новый сервер с тем же каталогом и часами, корзины — из dir
+func (f *fixture) restart(dir string) *fixture {
+	f.t.Helper()
+	next := newFixture(f.t)
+	next.clock = f.clock
+	next.app.Now = f.clock.Now
+	for _, p := range f.app.Catalog.List() {
+		next.stock(p)
+	}
+	f.persist(next, dir)
+	return next
+}
+
+// persist подключает к серверу next корзины из dir (запись — через 10ms)
+func (f *fixture) persist(next *fixture, dir string) {
+	f.t.Helper()
+	st, err := NewJSONFileStorage(dir, next.app.Logger)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if err := next.app.Carts.Persist(st, 10*time.Millisecond, next.app.Logger); err != nil {
+		f.t.Fatal(err)
+	}
+	f.t.Cleanup(func() { next.app.Carts.Close() })
+}
+
+// waitSaved ждёт, пока фоновая запись дойдёт до файлов в dir
+func waitSaved(t *testing.T, dir string, files int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		names, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		if len(names) == files {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d cart files, want %d", len(names), files)
+		}
+	}
+}
+
+func TestScenarioCartsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	f := newFixture(t)
+	f.persist(f, dir)
+
+	f.addItem("alice", soap, 3)
+	f.addItem("alice", shampoo, 1)
+	f.putCoupon(Coupon{Code: "TEN", PercentOff: 10})
+	f.applyCoupon("alice", "TEN")
+	f.addItem("bob", soap, 1)
+	f.addItem("carol", soap, 1)
+	f.clearCart("carol") // пустая корзина не хранится
+	f.addItem("dave", soap, 1)
+	f.checkout("dave") // и оформленная тоже
+	// Цена поменялась после добавления — после перезапуска checkout всё так же
+	// должен сообщить об этом
+	raised := shampoo
+	raised.Price = 1200
+	f.putProduct(raised)
+
+	// Порядок строк в ответе не задан — сравниваем по ID товара
+	sorted := func(c Cart) Cart {
+		sort.Slice(c.Items, func(i, j int) bool { return c.Items[i].Product.ID < c.Items[j].Product.ID })
+		return c
+	}
+	before := map[string]Cart{"alice": sorted(f.getCart("alice")), "bob": sorted(f.getCart("bob"))}
+	waitSaved(t, dir, 2)
+
+	// «Убиваем» сервер без Close и поднимаем новый из тех же файлов
+	next := f.restart(dir)
+	for session, want := range before {
+		if got := sorted(next.getCart(session)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s after restart:\n got %+v\nwant %+v", session, got, want)
+		}
+	}
+	if c := next.getCart("carol"); len(c.Items) != 0 {
+		t.Fatalf("carol: %+v", c)
+	}
+	if pc := next.checkoutConflict("alice"); len(pc.Changes) != 1 || pc.Changes[0].ProductID != shampoo.ID {
+		t.Fatalf("price change lost on restart: %+v", pc)
+	}
+}
+
+func TestScenarioBrokenCartFileSkipped(t *testing.T) {
+	dir := t.TempDir()
+	f := newFixture(t)
+	f.persist(f, dir)
+	f.addItem("alice", soap, 2)
+	waitSaved(t, dir, 1)
+	os.WriteFile(filepath.Join(dir, "626f62.json"), []byte(`{"id":"bob","items":[`), 0o644)
+	os.WriteFile(filepath.Join(dir, "00.json"), []byte(`{"id":"mallory","items":[]}`), 0o644)
+
+	var logBuf bytes.Buffer
+	next := newFixture(t)
+	next.app.Logger = log.New(&logBuf, "", 0)
+	next.stock(soap)
+	f.persist(next, dir)
+	if c := next.getCart("alice"); quantityOf(c, soap.ID) != 2 {
+		t.Fatalf("alice not restored: %+v", c)
+	}
+	if c := next.getCart("bob"); len(c.Items) != 0 {
+		t.Fatalf("bob: %+v", c)
+	}
+	for _, name := range []string{"626f62.json", "00.json"} {
+		if !strings.Contains(logBuf.String(), name) {
+			t.Errorf("%s not logged:\n%s", name, logBuf.String())
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ---------- GIFT CARDS ----------
+
+// Подарочная карта работает как частичная оплата: применённая к корзине,
+// она резервирует под корзину до её суммы из своего баланса (строка
+// adjustments с отрицательной суммой в ToCart), резерв пересчитывается при
+// каждом изменении корзины. Checkout списывает резерв с баланса, снятие
+// карты или истечение корзины резерв освобождают.
+//
+// Инвариант: сумма резервов карты никогда не больше её баланса, поэтому
+// параллельные корзины не могут потратить больше, чем лежит на карте.
+// Баланс и резервы каждой карты под своим мьютексом; порядок блокировок —
+// корзина, затем карта (карта корзин не трогает).
+
+var (
+	errGiftCardNotFound = errors.New("gift card not found")
+	errGiftCardExists   = errors.New("gift card code already exists")
+	errGiftCardEmpty    = errors.New("gift card has no balance")
+)
+
+// GiftCard — баланс и резервы корзин (ключ — ID корзины)
+type GiftCard struct {
+	Code string
+
+	mu       sync.Mutex
+	balance  Money
+	reserved map[string]Money
+}
+
+// availableLocked — свободный остаток без учёта резерва корзины except
+func (g *GiftCard) availableLocked(except string) Money {
+	free := g.balance
+	for id, amount := range g.reserved {
+		if id != except {
+			free -= amount
+		}
+	}
+	return max(free, 0)
+}
+
+// Reserve ставит резерв корзины на min(want, свободный остаток) и
+// возвращает его (прежний резерв этой корзины заменяется).
+func (g *GiftCard) Reserve(cartID string, want Money) Money {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	amount := min(max(want, 0), g.availableLocked(cartID))
+	if amount == 0 {
+		delete(g.reserved, cartID)
+	} else {
+		g.reserved[cartID] = amount
+	}
+	return amount
+}
+
+// Release снимает резерв корзины
+func (g *GiftCard) Release(cartID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.reserved, cartID)
+}
+
+// Redeem атомарно списывает с баланса до want (сколько доступно корзине)
+// и снимает её резерв. Возвращает списанную сумму.
+func (g *GiftCard) Redeem(cartID string, want Money) Money {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	amount := min(max(want, 0), g.availableLocked(cartID))
+	g.balance -= amount
+	delete(g.reserved, cartID)
+	return amount
+}
+
+// Balance — баланс и сумма всех резервов
+func (g *GiftCard) Balance() (balance, reserved Money) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, amount := range g.reserved {
+		reserved += amount
+	}
+	return g.balance, reserved
+}
+
+// GiftCardStore — выпущенные карты по коду
+type GiftCardStore struct {
+	mu    sync.Mutex
+	cards map[string]*GiftCard
+}
+
+func NewGiftCardStore() *GiftCardStore {
+	return &GiftCardStore{cards: make(map[string]*GiftCard)}
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Mint выпускает карту с балансом balance; пустой code — сгенерировать
+func (gs *GiftCardStore) Mint(code string, balance Money) (*GiftCard, error) {
+	if balance <= 0 {
+		return nil, errors.New("balance must be > 0")
+	}
+	code = normalizeCode(code)
+	if code == "" {
+		buf := make([]byte, 6)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code = "GC-" + strings.ToUpper(hex.EncodeToString(buf))
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if _, ok := gs.cards[code]; ok {
+		return nil, errGiftCardExists
+	}
+	card := &GiftCard{Code: code, balance: balance, reserved: make(map[string]Money)}
+	gs.cards[code] = card
+	return card, nil
+}
+
+// Get ищет карту по коду (без учёта регистра и пробелов)
+func (gs *GiftCardStore) Get(code string) (*GiftCard, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	card, ok := gs.cards[normalizeCode(code)]
+	return card, ok
+}
+
+// ---------- GIFT CARD HANDLERS ----------
+
+// MintGiftCardRequest : выпуск карты (code можно не указывать)
+type MintGiftCardRequest struct {
+	Code    string `json:"code"`
+	Balance Money  `json:"balance"`
+}
+
+// GiftCardInfo — карта в ответе админского эндпоинта
+type GiftCardInfo struct {
+	Code     string `json:"code"`
+	Balance  Money  `json:"balance"`
+	Reserved Money  `json:"reserved"`
+}
+
+// requireAdmin пускает к /admin/* только запросы method с
+// Authorization: Bearer <AdminToken>. Пустой токен = эндпоинты выключены.
+// false — ответ с ошибкой уже записан.
+func (a *App) requireAdmin(w http.ResponseWriter, r *http.Request, method string) bool {
+	if a.AdminToken == "" {
+		writeError(w, errNotFound)
+		return false
+	}
+	if r.Method != method {
+		methodNotAllowed(w, method)
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(a.AdminToken)) != 1 {
+		writeError(w, errUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleMintGiftCard — POST /admin/giftcards (requireAdmin)
+func (a *App) handleMintGiftCard(w http.ResponseWriter, r *http.Request) {
+	if !a.requireAdmin(w, r, http.MethodPost) {
+		return
+	}
+	var req MintGiftCardRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, errInvalidJSON)
+		return
+	}
+	card, err := a.GiftCards.Mint(req.Code, req.Balance)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	balance, reserved := card.Balance()
+	writeJSON(w, http.StatusCreated, GiftCardInfo{Code: card.Code, Balance: balance, Reserved: reserved})
+}
+
+// GiftCardRequest : применить карту к корзине
+type GiftCardRequest struct {
+	Code            string `json:"code"`
+	ExpectedVersion *int64 `json:"expected_version,omitempty"`
+}
+
+// handleApplyGiftCard — POST /cart/giftcard {"code": "..."}
+func (a *App) handleApplyGiftCard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	cart := a.cartFor(r)
+	var req GiftCardRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, errInvalidJSON)
+		return
+	}
+	r, err := expectVersion(r, req.ExpectedVersion)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	card, ok := a.GiftCards.Get(req.Code)
+	if !ok {
+		writeError(w, errGiftCardNotFound)
+		return
+	}
+	if err := cart.ApplyGiftCard(r.Context(), card); err != nil {
+		writeError(w, err)
+		return
+	}
+	a.writeCart(w, cart, cart.ToCart())
+}
+
+// handleRemoveGiftCard — POST /cart/giftcard/remove: снять карту и резерв
+func (a *App) handleRemoveGiftCard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	cart := a.cartFor(r)
+	r, err := expectVersion(r, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := cart.RemoveGiftCard(r.Context()); err != nil {
+		writeError(w, err)
+		return
+	}
+	a.writeCart(w, cart, cart.ToCart())
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ---------- LIMITS ----------
+
+// Пределы корзины: не больше MaxQuantityPerItem штук одного товара и не
+// больше MaxDistinctItems разных товаров — миллиард штук или десять тысяч
+// строк ломают всё, что стоит после checkout. Add считает вместе с тем, что
+// уже лежит в корзине (50 + 60 при пределе 99 — отказ), Update — новое
+// количество, AddAll — каждую строку вместе с предыдущими. Ровно предел
+// можно. Превышение — 422, предел — в ответе (limit).
+//
+// Пределы задаёт CartStore.Limits (флаги -max-item-qty и -max-items, 0 —
+// без предела). Корзины из хранилища при загрузке не проверяются: пределы
+// действуют на изменения.
+
+const (
+	defaultMaxQuantityPerItem = 99
+	defaultMaxDistinctItems   = 100
+)
+
+// CartLimits — пределы одной корзины; 0 — без предела
+type CartLimits struct {
+	MaxQuantityPerItem int // штук одного товара
+	MaxDistinctItems   int // разных товаров (строк)
+}
+
+// DefaultCartLimits — пределы по умолчанию
+func DefaultCartLimits() CartLimits {
+	return CartLimits{MaxQuantityPerItem: defaultMaxQuantityPerItem, MaxDistinctItems: defaultMaxDistinctItems}
+}
+
+// Превышен предел; подробности — в *QuantityLimitError и *CartFullError
+var (
+	errQuantityLimit = errors.New("quantity limit exceeded")
+	errCartFull      = errors.New("cart item limit exceeded")
+)
+
+// QuantityLimitError — товара ProductID просят Requested штук, а можно Limit
+type QuantityLimitError struct {
+	ProductID string
+	Requested int
+	Limit     int
+}
+
+func (e *QuantityLimitError) Error() string {
+	return fmt.Sprintf("at most %d of %s per cart: requested %d", e.Limit, e.ProductID, e.Requested)
+}
+
+func (e *QuantityLimitError) Unwrap() error { return errQuantityLimit }
+
+// isQuantityLimit — errors.As для QuantityLimitError
+func isQuantityLimit(err error) (*QuantityLimitError, bool) {
+	var le *QuantityLimitError
+	ok := errors.As(err, &le)
+	return le, ok
+}
+
+// CartFullError — в корзине уже Limit разных товаров, новый не поместится
+type CartFullError struct {
+	Limit int
+}
+
+func (e *CartFullError) Error() string {
+	return fmt.Sprintf("at most %d distinct items per cart", e.Limit)
+}
+
+func (e *CartFullError) Unwrap() error { return errCartFull }
+
+// isCartFull — errors.As для CartFullError
+func isCartFull(err error) (*CartFullError, bool) {
+	var fe *CartFullError
+	ok := errors.As(err, &fe)
+	return fe, ok
+}
+
+// checkQuantity — можно ли к have штукам товара productID добавить ещё add
+// (как и checkStock, без вычисления суммы, которая может переполниться)
+func (l CartLimits) checkQuantity(productID string, have, add int) error {
+	if l.MaxQuantityPerItem > 0 && add > l.MaxQuantityPerItem-have {
+		return &QuantityLimitError{ProductID: productID, Requested: addQuantity(have, add), Limit: l.MaxQuantityPerItem}
+	}
+	return nil
+}
+
+// addQuantity — have + add для ошибок; больше math.MaxInt — math.MaxInt
+func addQuantity(have, add int) int {
+	if add > math.MaxInt-have {
+		return math.MaxInt
+	}
+	return have + add
+}
+
+// checkLines — можно ли держать lines разных товаров
+func (l CartLimits) checkLines(lines int) error {
+	if l.MaxDistinctItems > 0 && lines > l.MaxDistinctItems {
+		return &CartFullError{Limit: l.MaxDistinctItems}
+	}
+	return nil
+}
@@ -1,245 +1,714 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // ---------- MODELS ----------
 
 type Product struct {
-	ID    string  `json:"id"`
-	Name  string  `json:"name"`
-	Price float64 `json:"price"`
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Price       Money        `json:"price"`
+	Stock       int          `json:"stock"`                  // остаток на складе (stock.go)
+	PriceTiers  []PriceTier  `json:"price_tiers,omitempty"`  // оптовые цены (pricetiers.go)
+	SaleWindows []SaleWindow `json:"sale_windows,omitempty"` // распродажи (sales.go)
 }
 
+// Item — строка корзины: BasePrice — цена товара за штуку, UnitPrice — с
+// учётом оптовой ступени для Quantity. Во время распродажи OriginalPrice —
+// обычная цена, SaleEndsAt — конец распродажи; PriceChanged — цена не та,
+// по которой товар положили (checkout ответит 409).
 type Item struct {
-	Product  Product `json:"product"`
-	Quantity int     `json:"quantity"`
+	Product       Product    `json:"product"`
+	Quantity      int        `json:"quantity"`
+	BasePrice     Money      `json:"base_unit_price"`
+	UnitPrice     Money      `json:"unit_price"`
+	OriginalPrice Money      `json:"original_unit_price,omitempty"`
+	SaleEndsAt    *time.Time `json:"sale_ends_at,omitempty"`
+	PriceChanged  bool       `json:"price_changed,omitempty"`
+
+	accepted Money // UnitPrice, с которой согласился покупатель
 }
 
+// Cart — корзина в ответе: Items в порядке добавления, Subtotal — сумма
+// товаров, Discount — скидка купона (coupon.go), Total — к оплате после
+// скидки и подарочной карты, Version — номер изменения (version.go)
 type Cart struct {
-	Items []Item  `json:"items"`
-	Total float64 `json:"total"`
+	Version     int64        `json:"version"`
+	Items       []Item       `json:"items"`
+	Adjustments []Adjustment `json:"adjustments,omitempty"`
+	Subtotal    Money        `json:"subtotal"`
+	Discount    Money        `json:"discount"`
+	Total       Money        `json:"total"`
+}
+
+// Adjustment — строка корзины, меняющая сумму к оплате (купон и подарочная
+// карта — отрицательная сумма)
+type Adjustment struct {
+	Kind   string `json:"kind"`
+	Code   string `json:"code,omitempty"`
+	Amount Money  `json:"amount"`
+}
+
+// Order — оформленный заказ: Total — сколько осталось оплатить после скидки
+// и карты.
+// ID, CartID, CreatedAt и Status проставляет журнал заказов (orders.go).
+type Order struct {
+	ID        string    `json:"id,omitempty"`
+	CartID    string    `json:"cart_id,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	Status    string    `json:"status,omitempty"`
+	Items     []Item    `json:"items"`
+	Subtotal  Money     `json:"subtotal"`
+	Coupon    string    `json:"coupon,omitempty"`
+	Discount  Money     `json:"discount,omitempty"`
+	GiftCard  Money     `json:"gift_card,omitempty"`
+	Total     Money     `json:"total"`
+	RequestID string    `json:"request_id,omitempty"` // запрос, оформивший заказ (trace.go)
 }
 
 // ---------- SERVICE (CartService) ----------
 
+// Методы, меняющие корзину, принимают context запроса: отменённый запрос
+// ничего не меняет, а ID запроса (trace.go) доходит до склада и заказа.
+
 type CartService struct {
-	mu    sync.Mutex
-	items map[string]Item // key = Product.ID
+	mu       sync.Mutex
+	id       string          // ID корзины — ключ резерва на подарочной карте
+	items    map[string]Item // key = Product.ID
+	order    []string        // ID товаров в порядке добавления
+	version  int64           // растёт с каждым изменением (version.go)
+	giftCard *GiftCard       // применённая карта или nil
+	coupon   *Coupon         // применённый купон или nil
+	catalog  *Catalog        // товары и цены; корзина берёт их только отсюда
+	prices   *PriceResolver  // часы для распродаж (nil — системные)
+	onChange func(id string) // после каждого изменения (storage.go)
+	limits   CartLimits      // пределы количества и строк (limits.go)
+	now      func() time.Time
+	modified time.Time // последнее изменение (или создание), от него считается истечение (app.go)
 }
 
 // NewCartService создаёт CartService
 func NewCartService() *CartService {
 	return &CartService{
-		items: make(map[string]Item),
+		items:  make(map[string]Item),
+		limits: DefaultCartLimits(),
+		now:    time.Now,
 	}
 }
 
-// Add добавляет товар или увеличивает количество (количество должно быть >=1)
-func (s *CartService) Add(p Product, qty int) error {
+// errProductNotFound — товара с таким ID нет в каталоге (handleAdd отвечает 404)
+var errProductNotFound = errors.New("product not found")
+
+// Недопустимое количество: Add ждёт >= 1, Update — >= 0 (0 удаляет строку)
+var (
+	errQuantityMin1 = errors.New("quantity must be >= 1")
+	errQuantityMin0 = errors.New("quantity must be >= 0")
+)
+
+// Add добавляет товар каталога или увеличивает количество (количество должно
+// быть >=1); цена строки пересчитывается по ступеням для нового количества.
+// Больше предела (limits.go) или чем есть на складе
+// (*InsufficientStockError), положить нельзя.
+func (s *CartService) Add(ctx context.Context, productID string, qty int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if qty <= 0 {
-		return errors.New("quantity must be >= 1")
+		return errQuantityMin1
+	}
+	p, ok := s.catalog.Get(productID)
+	if !ok {
+		return errProductNotFound
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return err
+	}
 
-	if it, ok := s.items[p.ID]; ok {
-		it.Quantity += qty
-		s.items[p.ID] = it
-	} else {
-		s.items[p.ID] = Item{Product: p, Quantity: qty}
+	it, ok := s.items[p.ID]
+	if !ok {
+		if err := s.limits.checkLines(len(s.items) + 1); err != nil {
+			return err
+		}
+		it = Item{Product: p}
+	}
+	if err := s.limits.checkQuantity(p.ID, it.Quantity, qty); err != nil {
+		return err
+	}
+	if err := checkStock(p, it.Quantity, qty); err != nil {
+		return err
 	}
+	s.acceptLocked(it.withQuantity(it.Quantity + qty))
+	s.repriceLocked()
+	s.bumpLocked()
 	return nil
 }
 
-// Update устанавливает количество (если qty == 0 — удаляет)
-func (s *CartService) Update(productID string, qty int) error {
+// Update устанавливает количество (если qty == 0 — удаляет); не больше
+// предела и не больше, чем есть на складе
+func (s *CartService) Update(ctx context.Context, productID string, qty int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if qty < 0 {
-		return errors.New("quantity must be >= 0")
+		return errQuantityMin0
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return err
+	}
 
 	if qty == 0 {
-		delete(s.items, productID)
+		if s.deleteLocked(productID) {
+			s.repriceLocked()
+			s.bumpLocked()
+		}
 		return nil
 	}
 	if it, ok := s.items[productID]; ok {
-		it.Quantity = qty
-		s.items[productID] = it
+		if err := s.limits.checkQuantity(productID, 0, qty); err != nil {
+			return err
+		}
+		if p, ok := s.catalog.Get(productID); ok {
+			if err := checkStock(p, 0, qty); err != nil {
+				return err
+			}
+		}
+		s.acceptLocked(it.withQuantity(qty))
+		s.repriceLocked()
+		s.bumpLocked()
 		return nil
 	}
-	return errors.New("product not found in cart")
+	return errNotInCart
 }
 
-// Remove удаляет товар
-func (s *CartService) Remove(productID string) {
+// errNotInCart — товара нет в корзине (PATCH/DELETE /cart/items/{id} — 404,
+// устаревший /cart/update — 400)
+var errNotInCart = errors.New("product not found in cart")
+
+// Remove удаляет товар; errNotInCart — его не было в корзине
+func (s *CartService) Remove(ctx context.Context, productID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.items, productID)
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return err
+	}
+	if !s.deleteLocked(productID) {
+		return errNotInCart
+	}
+	s.repriceLocked()
+	s.bumpLocked()
+	return nil
 }
 
 // Clear очищает корзину
-func (s *CartService) Clear() {
+func (s *CartService) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.items = make(map[string]Item)
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return err
+	}
+	s.resetLocked()
+	s.repriceLocked()
+	s.bumpLocked()
+	return nil
 }
 
 // Items возвращает срез Item
 func (s *CartService) Items() []Item {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	out := make([]Item, 0, len(s.items))
-	for _, it := range s.items {
-		out = append(out, it)
+	return s.itemsLocked()
+}
+
+func (s *CartService) itemsLocked() []Item {
+	out := make([]Item, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, s.currentLocked(s.items[id]))
 	}
 	return out
 }
 
-// Total считает итоговую сумму
-func (s *CartService) Total() float64 {
+// deleteLocked убирает строку; false — её не было
+func (s *CartService) deleteLocked(productID string) bool {
+	if _, ok := s.items[productID]; !ok {
+		return false
+	}
+	delete(s.items, productID)
+	s.order = slices.DeleteFunc(s.order, func(id string) bool { return id == productID })
+	return true
+}
+
+// resetLocked — пустая корзина
+func (s *CartService) resetLocked() {
+	s.items = make(map[string]Item)
+	s.order = nil
+}
+
+// currentLocked — строка по текущей версии товара из каталога и ценам на
+// сейчас
+func (s *CartService) currentLocked(it Item) Item {
+	if p, ok := s.catalog.Get(it.Product.ID); ok {
+		it.Product = p
+	}
+	return s.prices.priced(it)
+}
+
+// acceptLocked кладёт строку в корзину с её текущей ценой как принятой
+func (s *CartService) acceptLocked(it Item) {
+	it = s.currentLocked(it)
+	it.accepted, it.PriceChanged = it.UnitPrice, false
+	if _, ok := s.items[it.Product.ID]; !ok {
+		s.order = append(s.order, it.Product.ID)
+	}
+	s.items[it.Product.ID] = it
+}
+
+// Total считает сумму товаров по ценам строк (без скидки и подарочной карты)
+func (s *CartService) Total() Money {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	var total float64
+	return s.totalLocked()
+}
+
+func (s *CartService) totalLocked() Money {
+	var total Money
 	for _, it := range s.items {
-		total += float64(it.Quantity) * it.Product.Price
+		total += s.currentLocked(it).lineTotal()
 	}
 	return total
 }
 
-// ToCart возвращает структуру Cart (Items + Total)
+// lineTotal — сумма строки: цена за штуку × количество
+func (it Item) lineTotal() Money {
+	return it.UnitPrice * Money(it.Quantity)
+}
+
+// discountLocked — скидка купона с суммы товаров subtotal сейчас; купона
+// нет или он не применим — 0
+func (s *CartService) discountLocked(subtotal Money) Money {
+	if s.coupon == nil || s.coupon.check(subtotal, s.prices.Now()) != nil {
+		return 0
+	}
+	return s.coupon.Discount(subtotal)
+}
+
+// repriceLocked подгоняет резерв на карте под текущую сумму корзины после скидки
+func (s *CartService) repriceLocked() Money {
+	if s.giftCard == nil {
+		return 0
+	}
+	subtotal := s.totalLocked()
+	return s.giftCard.Reserve(s.id, subtotal-s.discountLocked(subtotal))
+}
+
+// ApplyGiftCard применяет карту (прежняя карта снимается) и резервирует на
+// ней до суммы корзины. Карта без свободного остатка не применяется.
+func (s *CartService) ApplyGiftCard(ctx context.Context, card *GiftCard) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return err
+	}
+	if balance, _ := card.Balance(); balance <= 0 {
+		return errGiftCardEmpty
+	}
+	if s.giftCard != nil && s.giftCard != card {
+		s.giftCard.Release(s.id)
+	}
+	s.giftCard = card
+	s.repriceLocked()
+	s.bumpLocked()
+	return nil
+}
+
+// RemoveGiftCard снимает карту и освобождает резерв
+func (s *CartService) RemoveGiftCard(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return err
+	}
+	if s.giftCard != nil {
+		s.giftCard.Release(s.id)
+		s.giftCard = nil
+		s.bumpLocked()
+	}
+	return nil
+}
+
+// ApplyCoupon применяет купон, если он действует для текущей суммы товаров
+// (*CouponError — нет), и возвращает код купона, который он заменил.
+func (s *CartService) ApplyCoupon(ctx context.Context, c Coupon) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return "", err
+	}
+	if err := c.check(s.totalLocked(), s.prices.Now()); err != nil {
+		return "", err
+	}
+	var replaced string
+	if s.coupon != nil && s.coupon.Code != c.Code {
+		replaced = s.coupon.Code
+	}
+	s.coupon = &c
+	s.repriceLocked()
+	s.bumpLocked()
+	return replaced, nil
+}
+
+// RemoveCoupon снимает купон
+func (s *CartService) RemoveCoupon(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return err
+	}
+	s.coupon = nil
+	s.repriceLocked()
+	s.bumpLocked()
+	return nil
+}
+
+// ToCart возвращает структуру Cart (Items, купон, карта и Total к оплате).
+// Резерв карты при этом пересчитывается: другая корзина могла освободить
+// остаток.
 func (s *CartService) ToCart() Cart {
-	return Cart{
-		Items: s.Items(),
-		Total: s.Total(),
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subtotal := s.totalLocked()
+	c := Cart{Version: s.version, Items: s.itemsLocked(), Subtotal: subtotal, Discount: s.discountLocked(subtotal)}
+	c.Total = subtotal - c.Discount
+	if s.coupon != nil {
+		c.Adjustments = append(c.Adjustments, Adjustment{Kind: "coupon", Code: s.coupon.Code, Amount: -c.Discount})
 	}
+	if s.giftCard != nil {
+		held := s.repriceLocked()
+		c.Adjustments = append(c.Adjustments, Adjustment{Kind: "gift_card", Code: s.giftCard.Code, Amount: -held})
+		c.Total -= held
+	}
+	return c
 }
 
-// ---------- HTTP HANDLERS ----------
+var errEmptyCart = errors.New("cart is empty")
+
+// Checkout оформляет заказ: считает скидку купона, списывает с карты
+// (атомарно, в пределах её баланса) и очищает корзину. Купон и карта после
+// заказа снимаются. Если цена
+// какой-то строки изменилась с момента добавления, заказ не оформляется:
+// новые цены принимаются, резерв карты пересчитывается, а ошибка
+// *PriceChangedError перечисляет изменения. Товар списывается со склада
+// всеми строками разом; если чего-то не хватает — *InsufficientStockError,
+// корзина не меняется.
+func (s *CartService) Checkout(ctx context.Context) (Order, error) {
+	if err := ctx.Err(); err != nil {
+		return Order{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkVersionLocked(ctx); err != nil {
+		return Order{}, err
+	}
+	if len(s.items) == 0 {
+		return Order{}, errEmptyCart
+	}
+	var changes []PriceChange
+	for _, it := range s.itemsLocked() {
+		if it.PriceChanged {
+			changes = append(changes, PriceChange{ProductID: it.Product.ID, OldPrice: it.accepted, NewPrice: it.UnitPrice})
+			s.acceptLocked(it)
+		}
+	}
+	if len(changes) > 0 {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].ProductID < changes[j].ProductID })
+		s.repriceLocked()
+		s.bumpLocked()
+		return Order{}, &PriceChangedError{Changes: changes}
+	}
+	lines := make(map[string]int, len(s.items))
+	for id, it := range s.items {
+		lines[id] = it.Quantity
+	}
+	if err := s.catalog.Take(ctx, lines); err != nil {
+		return Order{}, err
+	}
+	subtotal := s.totalLocked()
+	o := Order{Items: s.itemsLocked(), Subtotal: subtotal, Discount: s.discountLocked(subtotal)}
+	if o.Discount > 0 {
+		o.Coupon = s.coupon.Code
+	}
+	o.Total = subtotal - o.Discount
+	if s.giftCard != nil {
+		o.GiftCard = s.giftCard.Redeem(s.id, o.Total)
+		o.Total -= o.GiftCard
+		s.giftCard = nil
+	}
+	s.coupon = nil
+	s.resetLocked()
+	s.bumpLocked()
+	return o, nil
+}
 
-var cart = NewCartService()
+// ---------- HTTP HANDLERS ----------
 
-// helper: write JSON response
+// helper: write JSON response. В конверт ошибки (статус >= 400) добавляется
+// request_id — его ставит в заголовок ответа Trace (trace.go).
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if id := w.Header().Get(requestIDHeader); id != "" && status >= 400 {
+		switch body := v.(type) {
+		case map[string]string:
+			body["request_id"] = id
+		case map[string]interface{}:
+			body["request_id"] = id
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(v)
 }
 
-// AddRequest : добавить товар в корзину
+// maxBodyBytes — предел тела JSON-запроса
+const maxBodyBytes = 1 << 20
+
+// decodeJSON строго разбирает тело: только JSON-объект, без неизвестных полей
+// и мусора после него. Иначе `null` или `{"product":"p1"}` в /cart/update
+// молча превращались бы в quantity 0 и удаляли товар.
+func decodeJSON(r *http.Request, v interface{}) error {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return errors.New("expected JSON object")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after JSON object")
+	}
+	return nil
+}
+
+// AddRequest : добавить товар каталога в корзину. Цену клиент не присылает:
+// её знает только каталог. ExpectedVersion — как If-Match (version.go).
 type AddRequest struct {
-	Product  Product `json:"product"`
-	Quantity int     `json:"quantity"`
+	ProductID       string `json:"product_id"`
+	Quantity        int    `json:"quantity"`
+	ExpectedVersion *int64 `json:"expected_version,omitempty"`
 }
 
-func handleAdd(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
-		return
-	}
+// handleAdd — POST /cart/items (и устаревший /cart/add)
+func (a *App) handleAdd(w http.ResponseWriter, r *http.Request) {
+	cart := a.cartFor(r)
 	var req AddRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, errInvalidJSON)
 		return
 	}
 	if req.Quantity <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "quantity must be >= 1"})
+		writeError(w, errQuantityMin1)
+		return
+	}
+	if req.ProductID == "" {
+		writeError(w, fieldError(codeMissingField, "product_id", "product_id required"))
 		return
 	}
-	if req.Product.ID == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "product id required"})
+	r, err := expectVersion(r, req.ExpectedVersion)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	if err := cart.Add(req.Product, req.Quantity); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	if err := cart.Add(r.Context(), req.ProductID, req.Quantity); err != nil {
+		writeError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, cart.ToCart())
+	a.writeCart(w, cart, cart.ToCart())
 }
 
-// UpdateRequest : обновить количество для productID (путь /cart/{id})
+// UpdateRequest : обновить количество для productID (путь /cart/items/{id})
 type UpdateRequest struct {
-	Quantity int `json:"quantity"`
+	Quantity        int    `json:"quantity"`
+	ExpectedVersion *int64 `json:"expected_version,omitempty"`
 }
 
-func handleUpdate(w http.ResponseWriter, r *http.Request) {
-	// ожидаем путь /cart/update?id=<productID> и JSON {quantity: N}
-	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
-		return
-	}
+// handleUpdate — устаревший POST /cart/update?id=<productID>
+func (a *App) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("id")
 	if q == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id query param required"})
+		writeError(w, fieldError(codeMissingField, "id", "id query param required"))
 		return
 	}
+	a.updateItem(w, r, q, http.StatusBadRequest)
+}
 
+// updateItem — JSON {quantity: N} для товара q; missing — статус, если его
+// нет в корзине (старый /cart/update отвечал 400)
+func (a *App) updateItem(w http.ResponseWriter, r *http.Request, q string, missing int) {
+	cart := a.cartFor(r)
 	var req UpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, errInvalidJSON)
 		return
 	}
 	if req.Quantity < 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "quantity must be >= 0"})
+		writeError(w, errQuantityMin0)
 		return
 	}
-
-	if err := cart.Update(q, req.Quantity); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	r, err := expectVersion(r, req.ExpectedVersion)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, cart.ToCart())
-}
 
-func handleGet(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	if err := cart.Update(r.Context(), q, req.Quantity); err != nil {
+		ae := toAPIError(err)
+		if ae.Code == codeNotInCart {
+			ae.Status = missing
+		}
+		writeError(w, ae)
 		return
 	}
-	writeJSON(w, http.StatusOK, cart.ToCart())
+	a.writeCart(w, cart, cart.ToCart())
 }
 
-func handleRemove(w http.ResponseWriter, r *http.Request) {
-	// ожидание /cart/remove?id=<productID>
-	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
-		return
-	}
+// handleGet — GET /cart (и устаревший /cart/get)
+func (a *App) handleGet(w http.ResponseWriter, r *http.Request) {
+	cart := a.cartFor(r)
+	a.writeCart(w, cart, cart.ToCart())
+}
+
+// handleRemove — устаревший POST /cart/remove?id=<productID>; товара нет
+// в корзине — всё равно 200
+func (a *App) handleRemove(w http.ResponseWriter, r *http.Request) {
+	cart := a.cartFor(r)
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id required"})
+		writeError(w, fieldError(codeMissingField, "id", "id required"))
+		return
+	}
+	r, err := expectVersion(r, nil)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	cart.Remove(id)
-	writeJSON(w, http.StatusOK, cart.ToCart())
+	if err := cart.Remove(r.Context(), id); err != nil && !errors.Is(err, errNotInCart) {
+		writeError(w, err)
+		return
+	}
+	a.writeCart(w, cart, cart.ToCart())
 }
 
-func handleClear(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+// handleClear — DELETE /cart (и устаревший POST /cart/clear)
+func (a *App) handleClear(w http.ResponseWriter, r *http.Request) {
+	cart := a.cartFor(r)
+	r, err := expectVersion(r, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := cart.Clear(r.Context()); err != nil {
+		writeError(w, err)
 		return
 	}
-	cart.Clear()
-	writeJSON(w, http.StatusOK, cart.ToCart())
+	a.writeCart(w, cart, cart.ToCart())
 }
 
 // ---------- MAIN ----------
 
 func main() {
-	http.HandleFunc("/cart/add", handleAdd)
-	http.HandleFunc("/cart/update", handleUpdate)
-	http.HandleFunc("/cart/get", handleGet)
-	http.HandleFunc("/cart/remove", handleRemove)
-	http.HandleFunc("/cart/clear", handleClear)
+	rlCfg := DefaultRateLimitConfig()
+	flag.IntVar(&rlCfg.Read.Burst, "rl-read-burst", rlCfg.Read.Burst, "read requests burst per client IP")
+	flag.Float64Var(&rlCfg.Read.PerSecond, "rl-read-rate", rlCfg.Read.PerSecond, "read requests refill per second per client IP")
+	flag.IntVar(&rlCfg.Write.Burst, "rl-write-burst", rlCfg.Write.Burst, "write requests burst per client IP")
+	flag.Float64Var(&rlCfg.Write.PerSecond, "rl-write-rate", rlCfg.Write.PerSecond, "write requests refill per second per client IP")
+	flag.IntVar(&rlCfg.Checkout.Burst, "rl-checkout-burst", rlCfg.Checkout.Burst, "checkout requests burst per client IP")
+	flag.Float64Var(&rlCfg.Checkout.PerSecond, "rl-checkout-rate", rlCfg.Checkout.PerSecond, "checkout requests refill per second per client IP")
+	cartTTL := flag.Duration("cart-ttl", defaultCartTTL, "carts expire this long after their last change (their gift card reservations are released); 0 keeps them forever")
+	adminToken := flag.String("admin-token", os.Getenv("ECART_ADMIN_TOKEN"), "bearer token for /admin/* (empty disables them)")
+	cartsDir := flag.String("carts-dir", defaultCartsDir, "directory to persist carts in (empty keeps them in memory only)")
+	saveDelay := flag.Duration("save-delay", defaultSaveDelay, "how long changed carts wait before being written")
+	limits := DefaultCartLimits()
+	flag.IntVar(&limits.MaxQuantityPerItem, "max-item-qty", limits.MaxQuantityPerItem, "most units of one product per cart (0 = no limit)")
+	flag.IntVar(&limits.MaxDistinctItems, "max-items", limits.MaxDistinctItems, "most distinct products per cart (0 = no limit)")
+	flag.Parse()
+
+	app := NewApp(rlCfg, time.Now)
+	app.AdminToken = *adminToken
+	app.Carts.TTL = *cartTTL
+	app.Carts.Limits = limits
+	for _, p := range seedProducts {
+		if err := app.Catalog.Put(p); err != nil {
+			log.Fatalf("seed product %s: %v", p.ID, err)
+		}
+	}
+	if *cartsDir != "" {
+		st, err := NewJSONFileStorage(*cartsDir, app.Logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := app.Carts.Persist(st, *saveDelay, app.Logger); err != nil {
+			log.Fatalf("load carts: %v", err)
+		}
+	}
+	// По Ctrl+C / SIGTERM дописываем отложенные изменения корзин
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	app.Limiter.StartJanitor(time.Minute, nil)
+	app.Carts.StartJanitor(ctx, cartJanitorEvery)
+	srv := &http.Server{Addr: ":8080", Handler: app.Handler()}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
 
 	fmt.Println("Server listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	if err := app.Carts.Close(); err != nil {
+		log.Printf("save carts: %v", err)
+	}
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ---------- MONEY ----------
+
+// Money — деньги в центах: цены, суммы строк и корзины, подарочные карты.
+// Считается только целыми, поэтому 3 × 0.10 — ровно 0.30. В JSON — число
+// с двумя знаками после точки (0.30); на входе — число или строка
+// ("12.5", "12.50"), но не больше двух знаков после точки и без экспоненты.
+type Money int64
+
+// maxMoneyDigits — сколько цифр до точки принимает ParseMoney (без
+// переполнения int64 в центах)
+const maxMoneyDigits = 15
+
+var errMoneyFormat = errors.New("want a decimal amount with at most 2 fraction digits")
+
+// ParseMoney разбирает десятичную запись: "12", "12.5", "-0.05"
+func ParseMoney(s string) (Money, error) {
+	digits, neg := strings.CutPrefix(s, "-")
+	whole, frac, dot := strings.Cut(digits, ".")
+	if whole == "" || len(whole) > maxMoneyDigits || (dot && frac == "") || len(frac) > 2 ||
+		!allDigits(whole) || !allDigits(frac) {
+		return 0, fmt.Errorf("amount %q: %w", s, errMoneyFormat)
+	}
+	units, _ := strconv.ParseInt(whole, 10, 64)
+	cents := int64(0)
+	if frac != "" {
+		cents, _ = strconv.ParseInt(frac, 10, 64)
+		if len(frac) == 1 {
+			cents *= 10
+		}
+	}
+	m := Money(units*100 + cents)
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String — десятичная запись: 1250 → "12.50"
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign, m = "-", -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/100, m%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
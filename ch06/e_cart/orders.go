@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ---------- ORDERS ----------
+
+// Заказы:
+//
+//	POST /cart/checkout — оформить корзину сессии: 201 и заказ; пустая
+//	                      корзина — 409, изменились цены — 409 с changes,
+//	                      не хватает товара — 409 с available (stock.go)
+//	GET  /orders        — заказы сессии по порядку оформления
+//	GET  /orders?id=    — один заказ сессии (чужой или неизвестный — 404)
+//
+// Заказ — снимок строк и сумм на момент оформления; после этого он не
+// меняется. Хранятся в памяти (OrderLog), их же читает выгрузка export.go.
+
+// orderPlaced — статус только что оформленного заказа
+const orderPlaced = "placed"
+
+// OrderLog — оформленные заказы по порядку создания. Заказы не меняются,
+// поэтому выгрузка читает снимок среза без блокировки.
+type OrderLog struct {
+	mu     sync.Mutex
+	orders []Order
+	byID   map[string]int // ID → индекс в orders
+	now    func() time.Time
+}
+
+func NewOrderLog(now func() time.Time) *OrderLog {
+	return &OrderLog{byID: make(map[string]int), now: now}
+}
+
+// Add присваивает заказу ID, корзину, время, статус и ID запроса из ctx и
+// сохраняет его
+func (l *OrderLog) Add(ctx context.Context, cartID string, o Order) Order {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	o.ID = fmt.Sprintf("o-%06d", len(l.orders)+1)
+	o.CartID = cartID
+	o.CreatedAt = l.now().UTC()
+	o.Status = orderPlaced
+	o.RequestID = RequestID(ctx)
+	sort.Slice(o.Items, func(i, j int) bool { return o.Items[i].Product.ID < o.Items[j].Product.ID })
+	l.byID[o.ID] = len(l.orders)
+	l.orders = append(l.orders, o)
+	return o
+}
+
+// Get — заказ по ID
+func (l *OrderLog) Get(id string) (Order, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i, ok := l.byID[id]
+	if !ok {
+		return Order{}, false
+	}
+	return l.orders[i], true
+}
+
+// ForCart — заказы корзины cartID по порядку создания
+func (l *OrderLog) ForCart(cartID string) []Order {
+	out := []Order{}
+	for _, o := range l.Snapshot() {
+		if o.CartID == cartID {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// Snapshot — заказы на текущий момент
+func (l *OrderLog) Snapshot() []Order {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.orders[:len(l.orders):len(l.orders)]
+}
+
+// ---------- обработчики ----------
+
+func (a *App) handleCheckout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	cart := a.cartFor(r)
+	r, err := expectVersion(r, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	order, err := cart.Checkout(r.Context())
+	if err != nil {
+		a.emit(r.Context(), Event{Type: eventCheckoutFailed, CartID: cartID(r), Error: err.Error()})
+		ae := toAPIError(err)
+		if ae.Code == codePriceChanged {
+			ae.Details["cart"] = cart.ToCart() // уже по новым ценам
+		}
+		writeError(w, ae)
+		return
+	}
+	order = a.Orders.Add(r.Context(), cartID(r), order)
+	a.emit(r.Context(), Event{Type: eventOrderPlaced, CartID: order.CartID, OrderID: order.ID})
+	writeJSON(w, http.StatusCreated, order)
+}
+
+// handleOrders — GET /orders и GET /orders?id=<orderID>, только свои
+func (a *App) handleOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	session := cartID(r)
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeJSON(w, http.StatusOK, a.Orders.ForCart(session))
+		return
+	}
+	o, ok := a.Orders.Get(id)
+	if !ok || o.CartID != session {
+		writeError(w, errOrderNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
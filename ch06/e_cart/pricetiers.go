@@ -0,0 +1,58 @@
+package main
+
+import "fmt"
+
+// ---------- PRICE TIERS ----------
+
+// Оптовые цены: у товара может быть лестница PriceTiers — «от MinQty штук
+// по UnitPrice». Цена строки корзины берётся из ступени, подходящей под её
+// количество (ровно на пороге — уже эта ступень), и пересчитывается при
+// каждом изменении количества: Add (в том числе при слиянии с уже лежащей
+// строкой) и Update. Сумма корзины, резерв подарочной карты и заказ
+// считаются от этой цены; во время распродажи — от меньшей из неё и цены
+// распродажи (sales.go).
+
+// PriceTier — ступень: начиная с MinQty штук цена за штуку UnitPrice
+type PriceTier struct {
+	MinQty    int   `json:"min_qty"`
+	UnitPrice Money `json:"unit_price"`
+}
+
+// validateTiers: пороги строго растут и больше 1 (одна штука — базовая
+// цена), цены положительны и строго убывают, начиная ниже базовой.
+func validateTiers(p Product) error {
+	prevQty, prevPrice := 1, p.Price
+	for i, t := range p.PriceTiers {
+		switch {
+		case t.MinQty <= prevQty:
+			return fmt.Errorf("price tier %d: min_qty must be greater than %d", i, prevQty)
+		case t.UnitPrice <= 0:
+			return fmt.Errorf("price tier %d: unit_price must be positive", i)
+		case t.UnitPrice >= prevPrice:
+			return fmt.Errorf("price tier %d: unit_price must be lower than %v", i, prevPrice)
+		}
+		prevQty, prevPrice = t.MinQty, t.UnitPrice
+	}
+	return nil
+}
+
+// unitPrice — цена за штуку при количестве qty: последняя ступень, чей
+// порог не больше qty, иначе базовая цена
+func (p Product) unitPrice(qty int) Money {
+	price := p.Price
+	for _, t := range p.PriceTiers {
+		if qty < t.MinQty {
+			break
+		}
+		price = t.UnitPrice
+	}
+	return price
+}
+
+// withQuantity — строка с новым количеством и пересчитанной ценой
+func (it Item) withQuantity(qty int) Item {
+	it.Quantity = qty
+	it.BasePrice = it.Product.Price
+	it.UnitPrice = it.Product.unitPrice(qty)
+	return it
+}
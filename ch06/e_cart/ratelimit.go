@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- RATE LIMITING ----------
+
+// Budget — параметры token bucket: Burst токенов, пополнение PerSecond в секунду
+type Budget struct {
+	Burst     int
+	PerSecond float64
+}
+
+// RateLimitConfig — лимиты по классам запросов
+type RateLimitConfig struct {
+	Read     Budget        // GET
+	Write    Budget        // POST/PUT/PATCH/DELETE
+	Checkout Budget        // оформление заказа — строже остальных
+	IdleTTL  time.Duration // через сколько простоя bucket удаляется janitor'ом
+}
+
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Read:     Budget{Burst: 60, PerSecond: 5},
+		Write:    Budget{Burst: 20, PerSecond: 1},
+		Checkout: Budget{Burst: 3, PerSecond: 0.05},
+		IdleTTL:  10 * time.Minute,
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter — in-memory трекер bucket'ов по ключу "класс|идентичность"
+type RateLimiter struct {
+	mu      sync.Mutex
+	cfg     RateLimitConfig
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		cfg:     cfg,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow списывает токен; при отказе возвращает, через сколько появится следующий
+func (rl *RateLimiter) Allow(key string, b Budget) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	bk, ok := rl.buckets[key]
+	if !ok {
+		bk = &bucket{tokens: float64(b.Burst), last: now}
+		rl.buckets[key] = bk
+	}
+	bk.tokens = math.Min(float64(b.Burst), bk.tokens+now.Sub(bk.last).Seconds()*b.PerSecond)
+	bk.last = now
+
+	if bk.tokens >= 1 {
+		bk.tokens--
+		return true, 0
+	}
+	if b.PerSecond <= 0 {
+		return false, rl.cfg.IdleTTL
+	}
+	wait := time.Duration((1 - bk.tokens) / b.PerSecond * float64(time.Second))
+	return false, wait
+}
+
+// cleanup удаляет bucket'ы, не использовавшиеся дольше IdleTTL
+func (rl *RateLimiter) cleanup() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	cutoff := rl.now().Add(-rl.cfg.IdleTTL)
+	for k, bk := range rl.buckets {
+		if bk.last.Before(cutoff) {
+			delete(rl.buckets, k)
+		}
+	}
+}
+
+// StartJanitor периодически чистит трекер, пока не закрыт stop
+func (rl *RateLimiter) StartJanitor(every time.Duration, stop <-chan struct{}) {
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				rl.cleanup()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// requestIdentity — IP клиента. ID корзины (X-Cart-ID, cookie cart_id)
+// выбирает сам клиент: ключ по нему даёт новый бюджет на каждый новый ID.
+func requestIdentity(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// classify выбирает бюджет для запроса
+func (rl *RateLimiter) classify(r *http.Request) (string, Budget) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/checkout"):
+		return "checkout", rl.cfg.Checkout
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return "read", rl.cfg.Read
+	default:
+		return "write", rl.cfg.Write
+	}
+}
+
+// RateLimit — middleware для HTTP-эндпоинтов. Внутренние фоновые операции
+// сервиса через него не проходят и лимит не расходуют.
+func RateLimit(rl *RateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class, budget := rl.classify(r)
+		ok, wait := rl.Allow(class+"|"+requestIdentity(r), budget)
+		if !ok {
+			secs := int(math.Ceil(wait.Seconds()))
+			if secs < 1 {
+				secs = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeError(w, errRateLimited)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ---------- ROUTES ----------
+
+// REST-маршруты корзины:
+//
+//	GET    /cart                   — корзина
+//	DELETE /cart                   — очистить
+//	POST   /cart/items             {product_id, quantity} — добавить товар
+//	POST   /cart/items/bulk        {items: [{product_id, quantity}]} — много строк разом (bulk.go)
+//	PATCH  /cart/items/{productID} {quantity} — количество (0 — удалить)
+//	DELETE /cart/items/{productID} — удалить товар
+//
+// Товара нет в корзине — PATCH и DELETE отвечают 404. Неподходящий метод —
+// 405 с заголовком Allow. Все меняющие маршруты понимают If-Match с версией
+// корзины (version.go).
+//
+// Старые /cart/get, /cart/add, /cart/update?id=, /cart/remove?id= и
+// /cart/clear — устаревшие псевдонимы на один релиз: отвечают как раньше
+// (товара нет в корзине: update — 400, remove — 200), плюс заголовки
+// Deprecation и Link на новый маршрут.
+
+// cartItemsPrefix — путь строк корзины; дальше идёт ID товара
+const cartItemsPrefix = "/cart/items/"
+
+// methods — обработчики одного пути по HTTP-методу
+type methods map[string]http.HandlerFunc
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := m[r.Method]
+	if !ok {
+		allow := make([]string, 0, len(m))
+		for method := range m {
+			allow = append(allow, method)
+		}
+		methodNotAllowed(w, allow...)
+		return
+	}
+	h(w, r)
+}
+
+// methodNotAllowed — 405 с перечнем допустимых методов в Allow
+func methodNotAllowed(w http.ResponseWriter, allow ...string) {
+	sort.Strings(allow)
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	writeError(w, errMethodNotAllowed)
+}
+
+// deprecated помечает старый маршрут: Deprecation и Link на замену
+func deprecated(successor string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handleCartItem — /cart/items/{productID}
+func (a *App) handleCartItem(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, cartItemsPrefix)
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, errNotFound)
+		return
+	}
+	methods{
+		http.MethodPatch: func(w http.ResponseWriter, r *http.Request) {
+			a.updateItem(w, r, id, http.StatusNotFound)
+		},
+		http.MethodDelete: func(w http.ResponseWriter, r *http.Request) {
+			cart := a.cartFor(r)
+			r, err := expectVersion(r, nil)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if err := cart.Remove(r.Context(), id); err != nil {
+				writeError(w, err)
+				return
+			}
+			a.writeCart(w, cart, cart.ToCart())
+		},
+	}.ServeHTTP(w, r)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ---------- SALES ----------
+
+// Распродажи: у товара могут быть окна SaleWindows — с Start (включительно)
+// до End (не включая) он стоит Price. Окна одного товара не пересекаются.
+// Цена не хранится в корзине, а считается при каждом чтении через
+// PriceResolver с часами приложения: корзина, оптовые ступени (во время
+// распродажи строка стоит меньшую из цены ступени и цены распродажи),
+// резерв подарочной карты и заказ видят одно и то же число.
+//
+// Корзина помнит цену, по которой покупатель положил товар (accepted). Если
+// к оформлению цена стала другой — началась или кончилась распродажа,
+// поменялась цена в каталоге, — checkout отвечает 409 со списком изменений
+// и принимает новые цены; повторный checkout проходит по ним.
+
+// SaleWindow — окно распродажи [Start, End) с ценой Price за штуку
+type SaleWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Price Money     `json:"sale_price"`
+}
+
+// validateSales: окна непустые, цена положительна и ниже обычной, окна не
+// пересекаются (конец одного может совпадать с началом следующего)
+func validateSales(p Product) error {
+	windows := append([]SaleWindow(nil), p.SaleWindows...)
+	for i, w := range windows {
+		switch {
+		case w.Start.IsZero() || !w.End.After(w.Start):
+			return fmt.Errorf("sale window %d: end must be after start", i)
+		case w.Price <= 0:
+			return fmt.Errorf("sale window %d: sale_price must be positive", i)
+		case w.Price >= p.Price:
+			return fmt.Errorf("sale window %d: sale_price must be lower than %v", i, p.Price)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	for i := 1; i < len(windows); i++ {
+		if windows[i].Start.Before(windows[i-1].End) {
+			return fmt.Errorf("sale windows overlap at %s", windows[i].Start.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// validateProduct — остаток, ступени и распродажи товара
+func validateProduct(p Product) error {
+	if err := validateStock(p); err != nil {
+		return err
+	}
+	if err := validateTiers(p); err != nil {
+		return err
+	}
+	return validateSales(p)
+}
+
+// activeSale — окно, в которое попадает at, или nil
+func (p Product) activeSale(at time.Time) *SaleWindow {
+	for i, w := range p.SaleWindows {
+		if !at.Before(w.Start) && at.Before(w.End) {
+			return &p.SaleWindows[i]
+		}
+	}
+	return nil
+}
+
+// PriceResolver считает действующую цену на момент now(). nil — по
+// системным часам (корзины вне App).
+type PriceResolver struct {
+	now func() time.Time
+}
+
+func NewPriceResolver(now func() time.Time) *PriceResolver {
+	return &PriceResolver{now: now}
+}
+
+func (r *PriceResolver) Now() time.Time {
+	if r == nil || r.now == nil {
+		return time.Now()
+	}
+	return r.now()
+}
+
+// Quote — цена товара при количестве qty: Base — за штуку без ступеней,
+// Unit — с ними; Original и SaleEndsAt — только во время распродажи.
+type Quote struct {
+	Base       Money
+	Unit       Money
+	Original   Money
+	SaleEndsAt *time.Time
+}
+
+func (r *PriceResolver) Quote(p Product, qty int) Quote {
+	q := Quote{Base: p.Price, Unit: p.unitPrice(qty)}
+	if w := p.activeSale(r.Now()); w != nil {
+		end := w.End
+		q.Original, q.SaleEndsAt = p.Price, &end
+		q.Base, q.Unit = w.Price, min(q.Unit, w.Price)
+	}
+	return q
+}
+
+// priced — строка с ценами на текущий момент
+func (r *PriceResolver) priced(it Item) Item {
+	q := r.Quote(it.Product, it.Quantity)
+	it.BasePrice, it.UnitPrice = q.Base, q.Unit
+	it.OriginalPrice, it.SaleEndsAt = q.Original, q.SaleEndsAt
+	it.PriceChanged = it.UnitPrice != it.accepted
+	return it
+}
+
+// PriceChange — строка, чья цена изменилась с момента добавления
+type PriceChange struct {
+	ProductID string `json:"product_id"`
+	OldPrice  Money  `json:"old_unit_price"`
+	NewPrice  Money  `json:"new_unit_price"`
+}
+
+// errPriceChanged — checkout остановлен из-за цен; подробности — в
+// *PriceChangedError
+var errPriceChanged = errors.New("prices changed")
+
+// PriceChangedError — checkout остановлен: цены изменились и уже приняты
+type PriceChangedError struct {
+	Changes []PriceChange
+}
+
+func (e *PriceChangedError) Error() string {
+	return "prices changed, review the cart and check out again"
+}
+
+func (e *PriceChangedError) Unwrap() error { return errPriceChanged }
+
+// isPriceChanged — errors.As для PriceChangedError
+func isPriceChanged(err error) (*PriceChangedError, bool) {
+	var pc *PriceChangedError
+	ok := errors.As(err, &pc)
+	return pc, ok
+}
+
+// ProductView — товар в ответе API: Price — действующая цена; во время
+// распродажи ещё обычная цена и конец распродажи
+type ProductView struct {
+	Product
+	Price         Money      `json:"price"`
+	OriginalPrice Money      `json:"original_price,omitempty"`
+	SaleEndsAt    *time.Time `json:"sale_ends_at,omitempty"`
+}
+
+func (r *PriceResolver) View(p Product) ProductView {
+	q := r.Quote(p, 1)
+	return ProductView{Product: p, Price: q.Base, OriginalPrice: q.Original, SaleEndsAt: q.SaleEndsAt}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ---------- STOCK ----------
+
+// Остатки: у товара каталога есть Stock — сколько штук можно продать.
+// Add и Update не дают положить в корзину больше, чем сейчас на складе, но
+// ничего не резервируют: две корзины могут держать последнюю штуку. Решает
+// checkout — Catalog.Take списывает все строки заказа разом под блокировкой
+// каталога; если хоть одной не хватает, не списывается ничего, и заказ не
+// оформляется (409). Так проданных штук никогда не больше, чем было.
+//
+// Остаток виден в /products (stock). Админ задаёт его вместе с товаром
+// (POST /admin/products заменяет и остаток).
+
+// ErrInsufficientStock — на складе меньше, чем просят; подробности — в
+// *InsufficientStockError (errors.As)
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// InsufficientStockError — товара ProductID просят Requested, а есть Available
+type InsufficientStockError struct {
+	ProductID string
+	Requested int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for %s: requested %d, available %d", e.ProductID, e.Requested, e.Available)
+}
+
+func (e *InsufficientStockError) Unwrap() error { return ErrInsufficientStock }
+
+// isInsufficientStock — errors.As для InsufficientStockError
+func isInsufficientStock(err error) (*InsufficientStockError, bool) {
+	var se *InsufficientStockError
+	ok := errors.As(err, &se)
+	return se, ok
+}
+
+// validateStock — остаток не отрицательный
+func validateStock(p Product) error {
+	if p.Stock < 0 {
+		return errors.New("stock must be >= 0")
+	}
+	return nil
+}
+
+// checkStock — хватит ли товара p, если к have штукам добавить ещё add.
+// Сравнивается add с остатком за вычетом have, а не сумма: сумма может
+// переполниться и пройти проверку отрицательной. add <= 0 — ошибка, иначе
+// списание прибавило бы к остатку.
+func checkStock(p Product, have, add int) error {
+	if add <= 0 {
+		return fmt.Errorf("%s: %w", p.ID, errQuantityMin1)
+	}
+	if add > p.Stock-have {
+		return &InsufficientStockError{ProductID: p.ID, Requested: addQuantity(have, add), Available: p.Stock}
+	}
+	return nil
+}
+
+// Take списывает со склада количества из lines (ID товара → штук): все
+// сразу или, если чего-то не хватает, ничего. Ошибка — про первый по ID
+// товар, которого не хватило; пропавший из каталога товар — errProductNotFound,
+// количество <= 0 — errQuantityMin1. Запрос отменён до списания — ctx.Err(),
+// склад не меняется.
+func (c *Catalog) Take(ctx context.Context, lines map[string]int) error {
+	ids := make([]string, 0, len(lines))
+	for id := range lines {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		if lines[id] <= 0 {
+			return fmt.Errorf("%s: %w", id, errQuantityMin1)
+		}
+		p, ok := c.products[id]
+		if !ok {
+			return fmt.Errorf("%s: %w", id, errProductNotFound)
+		}
+		if err := checkStock(p, 0, lines[id]); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		p := c.products[id]
+		p.Stock -= lines[id]
+		c.products[id] = p
+	}
+	return nil
+}
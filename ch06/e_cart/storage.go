@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ---------- STORAGE ----------
+
+// Корзины переживают перезапуск: CartStore.Persist загружает их из Storage
+// и дальше сохраняет каждую изменённую корзину. Изменение (Add, Update,
+// Remove, Clear, купон, checkout, истечение) только помечает корзину —
+// пишет фоновый cartWriter не чаще раза в delay, по последнему состоянию,
+// так что серия правок одной корзины — одна запись. Падение теряет не
+// больше delay последних правок; Close дописывает всё сразу.
+//
+// Сохраняются строки в порядке добавления (товар, количество, принятая
+// цена), купон, версия и время последнего изменения. Подарочные карты живут
+// в памяти, поэтому их резерв не сохраняется: после перезапуска карту
+// применяют заново. Пустая корзина из хранилища удаляется.
+
+const (
+	defaultCartsDir  = "./carts"
+	defaultSaveDelay = 200 * time.Millisecond // задержка фоновой записи
+)
+
+// Storage — хранилище корзин
+type Storage interface {
+	// Load — все сохранённые корзины; повреждённые пропускаются
+	Load() ([]CartSnapshot, error)
+	Save(CartSnapshot) error
+	Delete(id string) error
+}
+
+// CartSnapshot — корзина в хранилище
+type CartSnapshot struct {
+	ID       string      `json:"id"`
+	Version  int64       `json:"version"`
+	Items    []SavedItem `json:"items"`
+	Coupon   *Coupon     `json:"coupon,omitempty"`
+	LastUsed time.Time   `json:"last_used"` // последнее изменение
+}
+
+// SavedItem — строка корзины: товар на момент записи (каталог при загрузке
+// важнее) и цена, с которой согласился покупатель
+type SavedItem struct {
+	Product  Product `json:"product"`
+	Quantity int     `json:"quantity"`
+	Accepted Money   `json:"accepted_unit_price"`
+}
+
+func (cs CartSnapshot) empty() bool {
+	return len(cs.Items) == 0 && cs.Coupon == nil
+}
+
+// snapshot — состояние корзины для хранилища
+func (s *CartService) snapshot() CartSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := CartSnapshot{ID: s.id, Version: s.version, Items: make([]SavedItem, 0, len(s.order)), LastUsed: s.modified}
+	for _, id := range s.order {
+		it := s.items[id]
+		snap.Items = append(snap.Items, SavedItem{Product: it.Product, Quantity: it.Quantity, Accepted: it.accepted})
+	}
+	if s.coupon != nil {
+		c := *s.coupon
+		snap.Coupon = &c
+	}
+	return snap
+}
+
+// restore заполняет новую корзину из снимка
+func (s *CartService) restore(snap CartSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, si := range snap.Items {
+		if _, dup := s.items[si.Product.ID]; !dup {
+			s.order = append(s.order, si.Product.ID)
+		}
+		s.items[si.Product.ID] = Item{Product: si.Product, Quantity: si.Quantity, accepted: si.Accepted}
+	}
+	s.coupon = snap.Coupon
+	s.version = snap.Version
+	s.modified = snap.LastUsed
+}
+
+// changed сообщает хранилищу, что корзина изменилась
+func (s *CartService) changed() {
+	if s.onChange != nil {
+		s.onChange(s.id)
+	}
+}
+
+// ---------- CartStore ----------
+
+// Persist загружает корзины из st и начинает сохранять изменения с
+// задержкой delay. Вызывается до начала обслуживания запросов.
+func (cs *CartStore) Persist(st Storage, delay time.Duration, logger *log.Logger) error {
+	snaps, err := st.Load()
+	if err != nil {
+		return err
+	}
+	w := &cartWriter{
+		store:  st,
+		delay:  delay,
+		logger: logger,
+		dirty:  make(map[string]bool),
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	w.snapshot = cs.snapshot
+	cs.mu.Lock()
+	for _, snap := range snaps {
+		c := cs.newCartLocked(snap.ID)
+		c.restore(snap)
+	}
+	cs.writer = w
+	cs.mu.Unlock()
+	go w.run()
+	return nil
+}
+
+// snapshot — снимок корзины id; false — корзины больше нет
+func (cs *CartStore) snapshot(id string) (CartSnapshot, bool) {
+	cs.mu.Lock()
+	c, ok := cs.carts[id]
+	cs.mu.Unlock()
+	if !ok {
+		return CartSnapshot{}, false
+	}
+	return c.snapshot(), true
+}
+
+// markChanged — onChange корзин
+func (cs *CartStore) markChanged(id string) {
+	if cs.writer != nil {
+		cs.writer.mark(id)
+	}
+}
+
+// Flush сразу записывает все помеченные корзины
+func (cs *CartStore) Flush() error {
+	if cs.writer == nil {
+		return nil
+	}
+	return cs.writer.flush()
+}
+
+// Close останавливает фоновую запись и дописывает изменения
+func (cs *CartStore) Close() error {
+	if cs.writer == nil {
+		return nil
+	}
+	return cs.writer.close()
+}
+
+// ---------- cartWriter ----------
+
+// cartWriter — отложенная запись помеченных корзин
+type cartWriter struct {
+	store    Storage
+	delay    time.Duration
+	logger   *log.Logger
+	snapshot func(id string) (CartSnapshot, bool)
+
+	mu    sync.Mutex
+	dirty map[string]bool
+
+	flushMu sync.Mutex // одна запись за раз
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (w *cartWriter) mark(id string) {
+	w.mu.Lock()
+	w.dirty[id] = true
+	w.mu.Unlock()
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (w *cartWriter) run() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.kick:
+		case <-w.stop:
+			return
+		}
+		select {
+		case <-time.After(w.delay):
+		case <-w.stop:
+			return
+		}
+		if err := w.flush(); err != nil {
+			w.logger.Printf("save carts: %v", err)
+		}
+	}
+}
+
+// flush пишет помеченные корзины; не записанные из-за ошибки остаются
+// помеченными до следующего раза
+func (w *cartWriter) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	ids := make([]string, 0, len(w.dirty))
+	for id := range w.dirty {
+		ids = append(ids, id)
+	}
+	w.dirty = make(map[string]bool)
+	w.mu.Unlock()
+
+	var errs []error
+	for _, id := range ids {
+		snap, ok := w.snapshot(id)
+		var err error
+		if ok && !snap.empty() {
+			err = w.store.Save(snap)
+		} else {
+			err = w.store.Delete(id)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cart %s: %w", id, err))
+			w.mu.Lock()
+			w.dirty[id] = true
+			w.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w *cartWriter) close() error {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+	return w.flush()
+}
+
+// ---------- JSON-файлы ----------
+
+// JSONFileStorage — корзина на файл <dir>/<hex(id)>.json, запись атомарная
+// (временный файл и rename)
+type JSONFileStorage struct {
+	dir    string
+	logger *log.Logger
+}
+
+func NewJSONFileStorage(dir string, logger *log.Logger) (*JSONFileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &JSONFileStorage{dir: dir, logger: logger}, nil
+}
+
+// path — файл корзины; ID в hex, чтобы "/" и ".." в X-Cart-ID не стали путём
+func (fs *JSONFileStorage) path(id string) string {
+	return filepath.Join(fs.dir, hex.EncodeToString([]byte(id))+".json")
+}
+
+// Load читает все корзины; повреждённый файл попадает в лог и пропускается
+func (fs *JSONFileStorage) Load() ([]CartSnapshot, error) {
+	files, err := filepath.Glob(filepath.Join(fs.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []CartSnapshot
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var snap CartSnapshot
+		err = json.Unmarshal(data, &snap)
+		if err == nil && fs.path(snap.ID) != name {
+			err = errors.New("cart id does not match the file name")
+		}
+		if err != nil {
+			fs.logger.Printf("skip broken cart file %s: %v", name, err)
+			continue
+		}
+		out = append(out, snap)
+	}
+	return out, nil
+}
+
+func (fs *JSONFileStorage) Save(snap CartSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(fs.dir, ".cart-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path(snap.ID))
+}
+
+func (fs *JSONFileStorage) Delete(id string) error {
+	if err := os.Remove(fs.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
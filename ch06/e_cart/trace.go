@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// ---------- TRACING ----------
+
+// У каждого запроса есть ID: входящий X-Request-ID, если он разумный
+// (печатный ASCII без пробелов, не длиннее maxRequestIDLen), иначе новый.
+// Trace кладёт его в context запроса, и дальше он идёт через CartService,
+// Catalog.Take и OrderLog.Add. ID виден везде, где запрос оставляет след:
+//
+//   - в заголовке ответа X-Request-ID;
+//   - в конверте ошибки — поле request_id (writeJSON, статус >= 400);
+//   - в каждой строке лога — префикс req=<id>;
+//   - в событиях (Event.RequestID) и в заказе (Order.RequestID).
+
+const (
+	requestIDHeader = "X-Request-ID"
+	maxRequestIDLen = 128
+)
+
+type ctxKey int
+
+const (
+	requestIDKey       ctxKey = iota
+	expectedVersionKey        // ожидаемая версия корзины (version.go)
+)
+
+// withRequestID — ctx с ID запроса
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID — ID запроса из ctx ("" — вне запроса, например janitor)
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// validRequestID — входящий ID можно повторить в заголовке и логе как есть
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// logf — строка лога с ID запроса из ctx
+func (a *App) logf(ctx context.Context, format string, args ...interface{}) {
+	id := RequestID(ctx)
+	if id == "" {
+		id = "-"
+	}
+	a.Logger.Printf("req=%s "+format, append([]interface{}{id}, args...)...)
+}
+
+// statusRecorder запоминает статус ответа для строки лога
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(p)
+}
+
+// Flush — выгрузка (export.go) сбрасывает строки по мере записи
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter { return sr.ResponseWriter }
+
+// Trace — внешний middleware: ID запроса в context и заголовок ответа,
+// строка лога на каждый запрос
+func (a *App) Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(withRequestID(r.Context(), id))
+		sr := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(sr, r)
+		a.logf(r.Context(), "%s %s -> %d (%v)", r.Method, r.URL.Path, sr.status, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+// ---------- EVENTS ----------
+
+// Типы событий
+const (
+	eventOrderPlaced    = "order.placed"
+	eventCheckoutFailed = "checkout.failed"
+)
+
+// Event — что произошло с корзиной; App.Events получает его синхронно
+// (вебхук или очередь — на стороне подписчика)
+type Event struct {
+	Type      string    `json:"type"`
+	RequestID string    `json:"request_id,omitempty"`
+	CartID    string    `json:"cart_id"`
+	OrderID   string    `json:"order_id,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// emit дополняет событие ID запроса и временем, пишет в лог и отдаёт App.Events
+func (a *App) emit(ctx context.Context, e Event) {
+	e.RequestID = RequestID(ctx)
+	e.At = a.Now().UTC()
+	if e.Error != "" {
+		a.logf(ctx, "event %s cart=%s: %s", e.Type, e.CartID, e.Error)
+	} else {
+		a.logf(ctx, "event %s cart=%s order=%s", e.Type, e.CartID, e.OrderID)
+	}
+	if a.Events != nil {
+		a.Events(e)
+	}
+}
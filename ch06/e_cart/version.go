@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- VERSION ----------
+
+// У корзины есть Version: растёт на единицу с каждым изменением и приходит
+// в ответе (Cart.Version). Меняющий запрос может передать версию, которую
+// видел клиент, — заголовком If-Match или полем expected_version в теле.
+// Если корзину за это время изменили, ответ 409 с текущей версией, и запрос
+// ничего не меняет. Без версии запрос выполняется как раньше.
+//
+// Ожидаемая версия идёт в context, как ID запроса, и сверяется под
+// блокировкой корзины — между проверкой и изменением никто не вклинится.
+
+// Ошибки версии: errVersionConflict — корзину изменили (подробности в
+// *VersionConflictError), остальные — версия в запросе записана неверно
+var (
+	errVersionConflict = errors.New("cart version conflict")
+	errIfMatchFormat   = errors.New("If-Match must be a cart version")
+	errVersionMismatch = errors.New("If-Match and expected_version differ")
+)
+
+// VersionConflictError — корзину изменили после того, как клиент её видел
+type VersionConflictError struct {
+	Expected int64
+	Current  int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("cart version is %d, expected %d", e.Current, e.Expected)
+}
+
+func (e *VersionConflictError) Unwrap() error { return errVersionConflict }
+
+func isVersionConflict(err error) (*VersionConflictError, bool) {
+	var ve *VersionConflictError
+	ok := errors.As(err, &ve)
+	return ve, ok
+}
+
+func withExpectedVersion(ctx context.Context, v int64) context.Context {
+	return context.WithValue(ctx, expectedVersionKey, v)
+}
+
+// checkVersionLocked — версия корзины та, что ожидает запрос (или он
+// ничего не ожидает)
+func (s *CartService) checkVersionLocked(ctx context.Context) error {
+	if want, ok := ctx.Value(expectedVersionKey).(int64); ok && want != s.version {
+		return &VersionConflictError{Expected: want, Current: s.version}
+	}
+	return nil
+}
+
+// bumpLocked — корзина изменилась: новая версия, время изменения (от него
+// считается истечение) и отметка для хранилища
+func (s *CartService) bumpLocked() {
+	s.version++
+	s.modified = s.now()
+	s.changed()
+}
+
+// Modified — время последнего изменения корзины
+func (s *CartService) Modified() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modified
+}
+
+// expectVersion кладёт в context запроса ожидаемую версию из If-Match или
+// из тела (body, nil — поля не было). Оба сразу должны совпадать.
+func expectVersion(r *http.Request, body *int64) (*http.Request, error) {
+	header, ok, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		return r, err
+	}
+	switch {
+	case ok && body != nil && *body != header:
+		return r, errVersionMismatch
+	case ok:
+		return r.WithContext(withExpectedVersion(r.Context(), header)), nil
+	case body != nil:
+		return r.WithContext(withExpectedVersion(r.Context(), *body)), nil
+	}
+	return r, nil
+}
+
+// parseIfMatch — версия из If-Match: 5 или "5"; пусто и * — без проверки
+func parseIfMatch(h string) (int64, bool, error) {
+	h = strings.TrimSpace(h)
+	if h == "" || h == "*" {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseInt(strings.Trim(h, `"`), 10, 64)
+	if err != nil || v < 0 {
+		return 0, false, errIfMatchFormat
+	}
+	return v, true, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialAs подключается к тестовому серверу с cookie браузера и читает welcome
+func dialAs(t *testing.T, srv *httptest.Server, browserID string) (*websocket.Conn, User) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	h := http.Header{}
+	h.Set("Cookie", browserCookie+"="+browserID)
+	conn, _, err := websocket.DefaultDialer.Dial(url, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var welcome struct {
+		Kind string `json:"kind"`
+		User User   `json:"user"`
+	}
+	if err := conn.ReadJSON(&welcome); err != nil || welcome.Kind != "welcome" {
+		t.Fatalf("expected welcome, got %+v (%v)", welcome, err)
+	}
+	return conn, welcome.User
+}
+
+// readUntil читает кадры, пока не встретится подходящее сообщение
+func readUntil(t *testing.T, conn *websocket.Conn, match func(Message) bool) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var m Message
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if match(m) {
+			return m
+		}
+	}
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(NewChatService(100).ServeWS))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGuestStableAcrossReconnects(t *testing.T) {
+	srv := newTestServer(t)
+
+	conn, first := dialAs(t, srv, "browser-a")
+	if !first.Guest || !strings.HasPrefix(first.Name, "Гость-") || first.Color == "" {
+		t.Fatalf("unexpected guest identity %+v", first)
+	}
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, second := dialAs(t, srv, "browser-a")
+	defer conn.Close()
+	if second != first {
+		t.Fatalf("guest identity changed across reconnect: %+v vs %+v", first, second)
+	}
+}
+
+func TestGuestNameCollision(t *testing.T) {
+	srv := newTestServer(t)
+
+	// Ищем другой браузер с тем же базовым именем
+	other := ""
+	for i := 0; other == ""; i++ {
+		id := fmt.Sprintf("b%d", i)
+		if guestName(id) == guestName("browser-a") {
+			other = id
+		}
+	}
+
+	c1, u1 := dialAs(t, srv, "browser-a")
+	defer c1.Close()
+	c2, u2 := dialAs(t, srv, other)
+	defer c2.Close()
+	if u2.Name != u1.Name+"-2" {
+		t.Fatalf("expected numeric suffix, got %q and %q", u1.Name, u2.Name)
+	}
+}
+
+func TestGuestUpgradeWithHello(t *testing.T) {
+	srv := newTestServer(t)
+	conn, guest := dialAs(t, srv, "browser-a")
+	defer conn.Close()
+
+	if err := conn.WriteJSON(map[string]interface{}{
+		"kind": "hello",
+		"user": User{ID: "vlad", Name: "Vlad"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sys := readUntil(t, conn, func(m Message) bool { return m.System })
+	if !strings.Contains(sys.Text, guest.Name) || !strings.Contains(sys.Text, "Vlad") {
+		t.Fatalf("rename message should mention both names: %q", sys.Text)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"text": "привет"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := readUntil(t, conn, func(m Message) bool { return m.Text == "привет" })
+	if msg.User.ID != "vlad" || msg.User.Guest || msg.User.Color == "" {
+		t.Fatalf("message should come from the upgraded user, got %+v", msg.User)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"time"
+)
+
+// ---------- GUEST IDENTITIES ----------
+
+// Гость получает имя и цвет, вычисленные из ID браузера (cookie), поэтому
+// после переподключения он остаётся тем же "Гость-краснолис".
+
+const browserCookie = "mc_browser"
+
+var guestAdjectives = []string{"красно", "бело", "сине", "зелено", "черно", "золото", "серебро", "рыже", "пёстро", "сизо"}
+var guestAnimals = []string{"лис", "волк", "ёж", "кот", "сыч", "бобр", "заяц", "рысь", "барсук", "филин"}
+
+func browserHash(browserID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(browserID))
+	return h.Sum32()
+}
+
+// guestName — базовое имя гостя (без суффикса для коллизий)
+func guestName(browserID string) string {
+	h := browserHash(browserID)
+	adj := guestAdjectives[h%uint32(len(guestAdjectives))]
+	animal := guestAnimals[(h/uint32(len(guestAdjectives)))%uint32(len(guestAnimals))]
+	return "Гость-" + adj + animal
+}
+
+// colorFor — стабильный цвет для отображения (hue из хэша ID)
+func colorFor(id string) string {
+	hue := browserHash("color:"+id) % 360
+	return fmt.Sprintf("hsl(%d, 65%%, 45%%)", hue)
+}
+
+// newBrowserID — случайный ID для нового браузера
+func newBrowserID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// browserIDFromRequest возвращает ID браузера из cookie; если его нет —
+// создаёт новый и готовит Set-Cookie для ответа на апгрейд.
+func browserIDFromRequest(r *http.Request) (string, http.Header) {
+	if c, err := r.Cookie(browserCookie); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+	id := newBrowserID()
+	h := http.Header{}
+	h.Add("Set-Cookie", (&http.Cookie{
+		Name:     browserCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   365 * 24 * 3600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}).String())
+	return id, h
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ---------- HISTORY ----------
+
+// История пишется журналом JSON-строк: {"op":"add|edit|delete","message":{...}}.
+// При старте (-history) журнал проигрывается в буфер последних сообщений,
+// по нему заново строится индекс веток, а сам файл переписывается одними
+// add живого буфера — так он не растёт бесконечно.
+
+const defaultHistoryPath = "./history.jsonl"
+
+type historyRecord struct {
+	Op      string  `json:"op"`
+	Message Message `json:"message"`
+}
+
+// LoadHistory проигрывает журнал path и дальше дописывает в него все
+// изменения. Отсутствующий файл — пустая история.
+func (s *ChatService) LoadHistory(path string) error {
+	msgs, err := replayHistory(path, s.capacity)
+	if err != nil {
+		return err
+	}
+	if err := writeHistory(path, msgs); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = msgs
+	s.threads = rebuildThreads(msgs)
+	s.history = f
+	return nil
+}
+
+func replayHistory(path string, capacity int) ([]Message, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make([]Message, 0, capacity), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	msgs := make([]Message, 0, capacity)
+	find := func(id string) int {
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if msgs[i].ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 4*maxTextBytes+64<<10)
+	for line := 1; sc.Scan(); line++ {
+		var rec historyRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		switch rec.Op {
+		case "add":
+			if len(msgs) >= capacity {
+				msgs = msgs[1:]
+			}
+			msgs = append(msgs, rec.Message)
+		case "edit", "delete":
+			if i := find(rec.Message.ID); i >= 0 {
+				msgs[i] = rec.Message
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown op %q", path, line, rec.Op)
+		}
+	}
+	return msgs, sc.Err()
+}
+
+// writeHistory атомарно заменяет журнал записями add для msgs
+func writeHistory(path string, msgs []Message) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(tmp)
+	for _, m := range msgs {
+		if err := enc.Encode(historyRecord{Op: "add", Message: m}); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// appendHistoryLocked дописывает изменение в журнал (если он включён)
+func (s *ChatService) appendHistoryLocked(op string, m Message) {
+	if s.history == nil {
+		return
+	}
+	if err := json.NewEncoder(s.history).Encode(historyRecord{Op: op, Message: m}); err != nil {
+		log.Println("history write:", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ---------- LOCALIZATION ----------
+
+// Все тексты, которые генерирует сервер (служебные сообщения, ошибки в
+// кадрах), берутся из каталога по ключу. В кадре едут ключ и параметры —
+// умный клиент переводит сам, — и уже готовый текст для простых клиентов.
+// Язык по умолчанию задаёт сервер (-lang), клиент может выбрать свой полем
+// lang в hello (в формате Accept-Language: "en-US,en;q=0.9,ru;q=0.8").
+//
+// Каждый вызов notice — место, где сервер что-то пишет пользователю;
+// i18n_test проверяет, что ключ каждого такого вызова есть во всех каталогах.
+
+// Ключи каталога
+const (
+	MsgUserRenamed = "user_renamed"  // {old}, {new}
+	MsgTextTooLong = "text_too_long" // {limit}
+
+	MsgNoSuchMessage = "no_such_message"
+	MsgNotAuthor     = "not_author"
+	MsgRejected      = "rejected" // {reason}
+
+	MsgMailboxDropped = "mailbox_dropped" // {count}
+)
+
+// fallbackLang — откуда берётся перевод, которого нет в нужном каталоге
+const fallbackLang = "en"
+
+// defaultServerLang — язык сервера, если не задан -lang
+const defaultServerLang = "ru"
+
+var catalogs = map[string]map[string]string{
+	"ru": {
+		MsgUserRenamed: "{old} теперь {new}",
+		MsgTextTooLong: "сообщение длиннее {limit} байт — отправьте его как вставку (POST /pastes)",
+
+		MsgNoSuchMessage: "сообщение не найдено или удалено",
+		MsgNotAuthor:     "менять и удалять можно только свои сообщения",
+		MsgRejected:      "сообщение отклонено модерацией: {reason}",
+
+		MsgMailboxDropped: "ещё {count} сообщений не поместились в почтовый ящик и удалены",
+	},
+	"en": {
+		MsgUserRenamed: "{old} is now {new}",
+		MsgTextTooLong: "message is longer than {limit} bytes — send it as a paste (POST /pastes)",
+
+		MsgNoSuchMessage: "message not found or deleted",
+		MsgNotAuthor:     "you can only edit or delete your own messages",
+		MsgRejected:      "message rejected by moderation: {reason}",
+
+		MsgMailboxDropped: "{count} older messages did not fit in the mailbox and were dropped",
+	},
+}
+
+// Notice — локализуемый текст: ключ каталога и параметры
+type Notice struct {
+	Key    string
+	Params map[string]string
+}
+
+// notice собирает Notice из ключа и пар параметров "имя", "значение"
+func notice(key string, kv ...string) Notice {
+	n := Notice{Key: key}
+	if len(kv) > 0 {
+		n.Params = make(map[string]string, len(kv)/2)
+		for i := 0; i+1 < len(kv); i += 2 {
+			n.Params[kv[i]] = kv[i+1]
+		}
+	}
+	return n
+}
+
+// translate берёт шаблон из каталога lang; нет перевода — из fallbackLang
+// с предупреждением в лог, нет и там — сам ключ.
+func translate(lang, key string) string {
+	if t, ok := catalogs[lang][key]; ok {
+		return t
+	}
+	if t, ok := catalogs[fallbackLang][key]; ok {
+		log.Printf("i18n: no %q translation for %q, using %s", lang, key, fallbackLang)
+		return t
+	}
+	log.Printf("i18n: unknown message key %q", key)
+	return key
+}
+
+// Render — готовый текст на языке lang
+func (n Notice) Render(lang string) string {
+	text := translate(lang, n.Key)
+	for k, v := range n.Params {
+		text = strings.ReplaceAll(text, "{"+k+"}", v)
+	}
+	return text
+}
+
+// pickLang выбирает поддерживаемый язык из строки в стиле Accept-Language
+// (по убыванию q, "en-US" подходит под "en"); ничего не подошло — def.
+func pickLang(accept, def string) string {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		base, _, _ := strings.Cut(tag, "-")
+		choices = append(choices, choice{base, q})
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, c := range choices {
+		if _, ok := catalogs[c.lang]; ok && c.q > 0 {
+			return c.lang
+		}
+	}
+	return def
+}
+
+// localized — копия сообщения с текстом на языке lang (если оно из каталога)
+func localized(m Message, lang string) Message {
+	if m.Key != "" {
+		m.Text = Notice{Key: m.Key, Params: m.Params}.Render(lang)
+	}
+	return m
+}
+
+// errorFrame — кадр ошибки: ключ, параметры и готовый текст
+func errorFrame(n Notice, lang string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":   "error",
+		"key":    n.Key,
+		"params": n.Params,
+		"error":  n.Render(lang),
+	}
+}
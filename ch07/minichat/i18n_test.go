@@ -0,0 +1,148 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestEveryNoticeHasTranslations находит в исходниках все вызовы notice(...)
+// — места, где сервер пишет пользователю, — и проверяет, что ключ есть в
+// каждом каталоге.
+func TestEveryNoticeHasTranslations(t *testing.T) {
+	files, _ := filepath.Glob("*.go")
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	consts := map[string]string{}
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, f)
+		ast.Inspect(f, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.ValueSpec); ok {
+				for i, id := range spec.Names {
+					if i < len(spec.Values) {
+						if lit, ok := spec.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+							consts[id.Name], _ = strconv.Unquote(lit.Value)
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	sites := 0
+	for _, f := range parsed {
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if fn, ok := call.Fun.(*ast.Ident); !ok || fn.Name != "notice" {
+				return true
+			}
+			pos := fset.Position(call.Pos())
+			id, ok := call.Args[0].(*ast.Ident)
+			if !ok {
+				t.Errorf("%s: notice key must be a catalog constant", pos)
+				return true
+			}
+			key, ok := consts[id.Name]
+			if !ok {
+				t.Errorf("%s: %s is not a string constant", pos, id.Name)
+				return true
+			}
+			sites++
+			for lang, cat := range catalogs {
+				if _, ok := cat[key]; !ok {
+					t.Errorf("%s: key %q missing in %q catalog", pos, key, lang)
+				}
+			}
+			return true
+		})
+	}
+	if sites < 2 {
+		t.Fatalf("found only %d notice call sites", sites)
+	}
+}
+
+func TestCatalogsHaveSameKeys(t *testing.T) {
+	for lang, cat := range catalogs {
+		for key := range catalogs[fallbackLang] {
+			if _, ok := cat[key]; !ok {
+				t.Errorf("%s: missing %q", lang, key)
+			}
+		}
+		for key := range cat {
+			if _, ok := catalogs[fallbackLang][key]; !ok {
+				t.Errorf("%s: extra key %q not in %s", lang, key, fallbackLang)
+			}
+		}
+	}
+}
+
+func TestNoticeFallsBackToEnglish(t *testing.T) {
+	catalogs["xx"] = map[string]string{}
+	defer delete(catalogs, "xx")
+	n := notice(MsgUserRenamed, "old", "a", "new", "b")
+	if got := n.Render("xx"); got != "a is now b" {
+		t.Fatalf("fallback: %q", got)
+	}
+	if got := n.Render("ru"); got != "a теперь b" {
+		t.Fatalf("ru: %q", got)
+	}
+}
+
+func TestPickLang(t *testing.T) {
+	cases := map[string]string{
+		"":                           "ru",
+		"en":                         "en",
+		"en-US,en;q=0.9":             "en",
+		"de-DE,de;q=0.9,en;q=0.5":    "en",
+		"fr;q=0.9,ru;q=0.8,en;q=0.7": "ru",
+		"ru;q=0.2,en;q=0.8":          "en",
+		"en;q=0,ru;q=0.1":            "ru",
+		"zh":                         "ru",
+	}
+	for accept, want := range cases {
+		if got := pickLang(accept, "ru"); got != want {
+			t.Errorf("pickLang(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func TestRenameNoticeLocalizedPerConnection(t *testing.T) {
+	srv := newTestServer(t)
+	ruConn, guest := dialAs(t, srv, "browser-ru")
+	defer ruConn.Close()
+	enConn, _ := dialAs(t, srv, "browser-en")
+	defer enConn.Close()
+	// hello с новым именем отвечает welcome — так язык точно уже выбран
+	if err := enConn.WriteJSON(map[string]interface{}{"kind": "hello", "user": User{ID: "ann", Name: "Ann"}, "lang": "en-GB,en;q=0.9"}); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, enConn, func(m Message) bool { return m.User.ID == "ann" })
+
+	if err := ruConn.WriteJSON(map[string]interface{}{"kind": "hello", "user": User{ID: "vlad", Name: "Vlad"}}); err != nil {
+		t.Fatal(err)
+	}
+	renamed := func(m Message) bool { return m.System && m.Params["new"] == "Vlad" }
+	ru := readUntil(t, ruConn, renamed)
+	en := readUntil(t, enConn, renamed)
+	if ru.Text != guest.Name+" теперь Vlad" || en.Text != guest.Name+" is now Vlad" {
+		t.Fatalf("texts: ru %q, en %q", ru.Text, en.Text)
+	}
+	if en.Key != MsgUserRenamed || en.Params["old"] != guest.Name || en.Params["new"] != "Vlad" {
+		t.Fatalf("key/params for smart clients: %q %v", en.Key, en.Params)
+	}
+}
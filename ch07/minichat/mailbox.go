@@ -0,0 +1,370 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ---------- MAILBOX ----------
+
+// Почтовый ящик — сообщения, адресованные пользователю, пока у него нет ни
+// одного подключения: личные сообщения (Message.To) и, с -mailbox-mentions,
+// упоминания @<id> в общем чате. В ящике не больше limit сообщений, самые
+// старые вытесняются, а при доставке первым идёт служебное сообщение о том,
+// сколько пропало.
+//
+// Доставка в два шага, чтобы падение посреди неё ничего не потеряло:
+//
+//  1. Begin переносит накопленное в пакет (batch) с новым ID и сохраняет
+//     ящик — «помечено к доставке»;
+//  2. после успешной отправки Ack(batch) удаляет пакет.
+//
+// Пакет без Ack (оборвалось соединение, упал сервер) при следующем Begin
+// отдаётся снова — с тем же ID и теми же ID сообщений, так что клиент
+// отбрасывает уже виденные. Новые сообщения, пришедшие за это время,
+// добавляются к нему под новым ID; Ack старого пакета тогда ничего не
+// удаляет. Два устройства, подключившихся разом, получают один и тот же
+// пакет, удаляет его первый Ack.
+//
+// По WebSocket пакет приходит кадром offline_messages: до initial_messages,
+// если пользователь назван при подключении (/ws?user=<id>&name=), иначе
+// сразу после hello. HTTP-клиенты забирают ящик через GET /mailbox?user=.
+//
+// С -mailbox каждый ящик — JSON-файл в каталоге, переписываемый атомарно;
+// без него ящики живут в памяти.
+
+const (
+	defaultMailboxDir   = "./mailbox"
+	defaultMailboxLimit = 200
+)
+
+// mentionRe — упоминание пользователя по ID: @vlad, @guest-abc
+var mentionRe = regexp.MustCompile(`@([\p{L}\p{N}_.-]+)`)
+
+// mailboxBatch — сообщения, помеченные к доставке, и сколько вытеснено до них
+type mailboxBatch struct {
+	ID       string    `json:"id"`
+	Messages []Message `json:"messages"`
+	Dropped  int       `json:"dropped,omitempty"`
+}
+
+// mailbox — ящик одного пользователя; так же лежит в файле
+type mailbox struct {
+	User     string        `json:"user"`
+	Pending  []Message     `json:"pending,omitempty"`
+	Dropped  int           `json:"dropped,omitempty"`  // вытеснено из Pending
+	Inflight *mailboxBatch `json:"inflight,omitempty"` // отдан, но без Ack
+}
+
+func (b *mailbox) empty() bool {
+	return len(b.Pending) == 0 && b.Dropped == 0 && b.Inflight == nil
+}
+
+// MailboxStore — ящики по ID пользователя
+type MailboxStore struct {
+	dir   string // "" — только в памяти
+	limit int
+
+	mu    sync.Mutex
+	boxes map[string]*mailbox
+}
+
+func NewMailboxStore(limit int) *MailboxStore {
+	return &MailboxStore{limit: limit, boxes: make(map[string]*mailbox)}
+}
+
+// Open загружает ящики из каталога dir и дальше сохраняет их туда
+func (ms *MailboxStore) Open(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	boxes := make(map[string]*mailbox, len(files))
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var b mailbox
+		if err := json.Unmarshal(data, &b); err != nil || b.User == "" {
+			return fmt.Errorf("%s: broken mailbox (%v)", name, err)
+		}
+		boxes[b.User] = &b
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.dir, ms.boxes = dir, boxes
+	return nil
+}
+
+// Has — есть ли у пользователя ящик (хоть что-то недоставленное)
+func (ms *MailboxStore) Has(user string) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.boxes[user] != nil
+}
+
+// Put кладёт сообщение в ящик; сверх limit вытесняется самое старое
+func (ms *MailboxStore) Put(user string, m Message) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	b := ms.boxes[user]
+	if b == nil {
+		b = &mailbox{User: user}
+		ms.boxes[user] = b
+	}
+	b.Pending = append(b.Pending, m)
+	if over := len(b.Pending) - ms.limit; over > 0 {
+		b.Pending = append([]Message(nil), b.Pending[over:]...)
+		b.Dropped += over
+	}
+	return ms.saveLocked(b)
+}
+
+// Begin помечает недоставленное к доставке и возвращает пакет; nil — ящик
+// пуст. Пакет без Ack отдаётся снова.
+func (ms *MailboxStore) Begin(user string) (*mailboxBatch, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	b := ms.boxes[user]
+	if b == nil {
+		return nil, nil
+	}
+	if len(b.Pending) > 0 || b.Dropped > 0 {
+		id, err := newBatchID()
+		if err != nil {
+			return nil, err
+		}
+		batch := &mailboxBatch{ID: id}
+		if b.Inflight != nil {
+			batch.Messages, batch.Dropped = b.Inflight.Messages, b.Inflight.Dropped
+		}
+		batch.Messages = append(append([]Message(nil), batch.Messages...), b.Pending...)
+		batch.Dropped += b.Dropped
+		if over := len(batch.Messages) - ms.limit; over > 0 {
+			batch.Messages = batch.Messages[over:]
+			batch.Dropped += over
+		}
+		b.Inflight, b.Pending, b.Dropped = batch, nil, 0
+		if err := ms.saveLocked(b); err != nil {
+			return nil, err
+		}
+	}
+	return b.Inflight, nil
+}
+
+// Ack удаляет доставленный пакет; чужой или уже удалённый — ничего не делает
+func (ms *MailboxStore) Ack(user, batchID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	b := ms.boxes[user]
+	if b == nil || b.Inflight == nil || b.Inflight.ID != batchID {
+		return nil
+	}
+	b.Inflight = nil
+	return ms.saveLocked(b)
+}
+
+func newBatchID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// mailboxPath — файл ящика; ID пользователя в hex, чтобы не думать о "/" и ".."
+func (ms *MailboxStore) mailboxPath(user string) string {
+	return filepath.Join(ms.dir, hex.EncodeToString([]byte(user))+".json")
+}
+
+// saveLocked атомарно переписывает файл ящика; пустой ящик удаляется
+func (ms *MailboxStore) saveLocked(b *mailbox) error {
+	if b.empty() {
+		delete(ms.boxes, b.User)
+	}
+	if ms.dir == "" {
+		return nil
+	}
+	path := ms.mailboxPath(b.User)
+	if b.empty() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(ms.dir, ".mailbox-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ---------- доставка ----------
+
+// offlineFrame — кадр offline_messages на языке lang; если что-то вытеснено,
+// первым идёт служебное сообщение об этом (ID от пакета — повтор тот же)
+func offlineFrame(batch *mailboxBatch, lang string) map[string]interface{} {
+	msgs := make([]Message, 0, len(batch.Messages)+1)
+	if batch.Dropped > 0 {
+		n := notice(MsgMailboxDropped, "count", strconv.Itoa(batch.Dropped))
+		msgs = append(msgs, Message{ID: "mailbox-dropped-" + batch.ID, User: systemUser, System: true, Key: n.Key, Params: n.Params})
+	}
+	msgs = append(msgs, batch.Messages...)
+	for i := range msgs {
+		msgs[i] = localized(msgs[i], lang)
+	}
+	return map[string]interface{}{
+		"kind":     "offline_messages",
+		"batch":    batch.ID,
+		"dropped":  batch.Dropped,
+		"messages": msgs,
+	}
+}
+
+// deliverMailbox отдаёт клиенту ящик его пользователя; false — отправка не
+// удалась (пакет останется до следующего подключения)
+func (s *ChatService) deliverMailbox(c *client) bool {
+	user := s.UserOf(c).ID
+	batch, err := s.mailbox.Begin(user)
+	if err != nil {
+		log.Println("mailbox begin:", err)
+		return true
+	}
+	if batch == nil {
+		return true
+	}
+	if err := c.send(offlineFrame(batch, s.LangOf(c))); err != nil {
+		log.Println("write offline messages:", err)
+		return false
+	}
+	if err := s.mailbox.Ack(user, batch.ID); err != nil {
+		log.Println("mailbox ack:", err)
+	}
+	return true
+}
+
+// onlineLocked — есть ли у пользователя хоть одно подключение
+func (s *ChatService) onlineLocked(userID string) bool {
+	for _, c := range s.clients {
+		if c.user.ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// SendDirect доставляет личное сообщение m.To на все его подключения (и
+// копию — подключениям автора); получателя нет в сети — в его ящик.
+// Личные сообщения не попадают в общий буфер и историю. Ящик пишется на
+// диск уже без s.mu, чтобы fsync не держал весь чат.
+func (s *ChatService) SendDirect(m Message) (Message, error) {
+	if err := s.moderate(&m); err != nil {
+		return m, err
+	}
+	s.mu.Lock()
+	s.seen[m.To] = true
+	var online bool
+	for _, c := range s.clients {
+		if c.user.ID == m.To {
+			online = true
+		}
+		if c.user.ID == m.To || c.user.ID == m.User.ID {
+			go func(c *client, m Message) {
+				_ = c.send(m)
+			}(c, localized(m, c.lang))
+		}
+	}
+	s.mu.Unlock()
+	if !online {
+		if err := s.mailbox.Put(m.To, m); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// mailMentionsLocked кладёт копию сообщения в ящики упомянутых, кого нет в
+// сети. Упоминание работает только для известных серверу ID — тех, кто
+// подключался, или у кого уже есть ящик, — иначе любое @слово заводило бы
+// новый ящик.
+func (s *ChatService) mailMentionsLocked(m Message) {
+	if !s.mailboxMentions || m.System || m.To != "" {
+		return
+	}
+	done := map[string]bool{m.User.ID: true}
+	for _, match := range mentionRe.FindAllStringSubmatch(m.Text, -1) {
+		id := match[1]
+		if done[id] {
+			continue
+		}
+		done[id] = true
+		if (!s.seen[id] && !s.mailbox.Has(id)) || s.onlineLocked(id) {
+			continue
+		}
+		if err := s.mailbox.Put(id, m); err != nil {
+			log.Println("mailbox put:", err)
+		}
+	}
+}
+
+// MailboxHandler — GET /mailbox?user=: забрать ящик целиком. Ответ —
+// {"batch","dropped","messages"} (пустой ящик — messages пуст); пакет
+// удаляется, только если ответ записан.
+func (s *ChatService) MailboxHandler(w http.ResponseWriter, r *http.Request) {
+	user := strings.TrimSpace(r.URL.Query().Get("user"))
+	if user == "" {
+		http.Error(w, "user required", http.StatusBadRequest)
+		return
+	}
+	batch, err := s.mailbox.Begin(user)
+	if err != nil {
+		log.Println("mailbox begin:", err)
+		http.Error(w, "mailbox unavailable", http.StatusInternalServerError)
+		return
+	}
+	if batch == nil {
+		batch = &mailboxBatch{Messages: []Message{}}
+	}
+	frame := offlineFrame(batch, pickLang(r.Header.Get("Accept-Language"), s.lang))
+	delete(frame, "kind")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(frame); err != nil {
+		log.Println("write mailbox:", err)
+		return
+	}
+	if batch.ID != "" {
+		if err := s.mailbox.Ack(user, batch.ID); err != nil {
+			log.Println("mailbox ack:", err)
+		}
+	}
+}
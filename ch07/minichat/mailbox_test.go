@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dm(id, from, to, text string) Message {
+	return Message{ID: id, User: User{ID: from, Name: from}, To: to, Text: text, CreatedAt: time.Now().UTC()}
+}
+
+func TestMailboxBound(t *testing.T) {
+	ms := NewMailboxStore(3)
+	for i := 1; i <= 5; i++ {
+		if err := ms.Put("bob", dm(strconv.Itoa(i), "alice", "bob", "m"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch, err := ms.Begin("bob")
+	if err != nil || batch == nil {
+		t.Fatalf("begin: %v %v", batch, err)
+	}
+	if batch.Dropped != 2 || len(batch.Messages) != 3 || batch.Messages[0].ID != "3" {
+		t.Fatalf("batch: dropped %d, %+v", batch.Dropped, batch.Messages)
+	}
+
+	msgs := offlineFrame(batch, "en")["messages"].([]Message)
+	if len(msgs) != 4 || msgs[0].Key != MsgMailboxDropped || !strings.Contains(msgs[0].Text, "2") {
+		t.Fatalf("no dropped marker first: %+v", msgs[0])
+	}
+}
+
+func TestMailboxCrashSafeDelivery(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *MailboxStore {
+		ms := NewMailboxStore(10)
+		if err := ms.Open(dir); err != nil {
+			t.Fatal(err)
+		}
+		return ms
+	}
+
+	ms := open()
+	ms.Put("bob", dm("1", "alice", "bob", "раз"))
+	first, _ := ms.Begin("bob")
+
+	// «Упали» до Ack — после перезапуска тот же пакет
+	ms = open()
+	again, err := ms.Begin("bob")
+	if err != nil || again == nil || again.ID != first.ID || len(again.Messages) != 1 {
+		t.Fatalf("replay: %+v (%v), want batch %s", again, err, first.ID)
+	}
+	ms.Ack("bob", "stale")
+	if !ms.Has("bob") {
+		t.Fatal("stale ack removed the batch")
+	}
+
+	// Новое сообщение присоединяется к пакету под новым ID
+	ms.Put("bob", dm("2", "alice", "bob", "два"))
+	merged, _ := ms.Begin("bob")
+	if merged.ID == first.ID || len(merged.Messages) != 2 || merged.Messages[0].ID != "1" {
+		t.Fatalf("merged: %+v", merged)
+	}
+	ms.Ack("bob", first.ID)
+	if again, _ := open().Begin("bob"); again == nil || again.ID != merged.ID {
+		t.Fatalf("ack of the old batch deleted the new one: %+v", again)
+	}
+
+	ms = open()
+	ms.Begin("bob")
+	if err := ms.Ack("bob", merged.ID); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Fatalf("files left after ack: %v", files)
+	}
+	if batch, _ := open().Begin("bob"); batch != nil {
+		t.Fatalf("delivered mailbox came back: %+v", batch)
+	}
+}
+
+func TestMailboxOpenRejectsBrokenFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "626f62.json"), []byte("{"), 0o644)
+	if err := NewMailboxStore(10).Open(dir); err == nil {
+		t.Fatal("broken mailbox accepted")
+	}
+}
+
+// newMailboxServer — ServeWS и /mailbox на одном сервисе
+func newMailboxServer(t *testing.T) (*ChatService, *httptest.Server) {
+	s := NewChatService(100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.ServeWS)
+	mux.HandleFunc("/mailbox", s.MailboxHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+// dialUser подключается как /ws?user=<id> и возвращает кадры до
+// initial_messages включительно
+func dialUser(t *testing.T, srv *httptest.Server, user string) (*websocket.Conn, []map[string]json.RawMessage) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?user=" + user + "&name=" + user
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var frames []map[string]json.RawMessage
+	for {
+		var f map[string]json.RawMessage
+		if err := conn.ReadJSON(&f); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		frames = append(frames, f)
+		if string(f["kind"]) == `"initial_messages"` {
+			return conn, frames
+		}
+	}
+}
+
+func kinds(frames []map[string]json.RawMessage) string {
+	var out []string
+	for _, f := range frames {
+		var k string
+		json.Unmarshal(f["kind"], &k)
+		out = append(out, k)
+	}
+	return strings.Join(out, ",")
+}
+
+func getMailbox(t *testing.T, srv *httptest.Server, user string) (batch string, messages []Message) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/mailbox?user=" + user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Batch    string    `json:"batch"`
+		Messages []Message `json:"messages"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&body) != nil {
+		t.Fatalf("GET /mailbox: %d", resp.StatusCode)
+	}
+	return body.Batch, body.Messages
+}
+
+func TestMailboxOnConnect(t *testing.T) {
+	s, srv := newMailboxServer(t)
+	if _, err := s.SendDirect(dm("d1", "alice", "bob", "привет")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, frames := dialUser(t, srv, "bob")
+	if got := kinds(frames); got != "welcome,offline_messages,initial_messages" {
+		t.Fatalf("frames: %s", got)
+	}
+	var msgs []Message
+	json.Unmarshal(frames[1]["messages"], &msgs)
+	if len(msgs) != 1 || msgs[0].ID != "d1" || msgs[0].To != "bob" {
+		t.Fatalf("offline: %+v", msgs)
+	}
+	// Личное сообщение не попало в общий чат
+	if got := s.GetMessages(); len(got) != 0 {
+		t.Fatalf("dm in the room buffer: %+v", got)
+	}
+
+	// Доставлено — второе подключение ящика уже не получает
+	if _, frames := dialUser(t, srv, "bob"); kinds(frames) != "welcome,initial_messages" {
+		t.Fatalf("second connection: %s", kinds(frames))
+	}
+}
+
+func TestMailboxMultiDevice(t *testing.T) {
+	s, srv := newMailboxServer(t)
+	s.SendDirect(dm("d1", "alice", "bob", "раз"))
+
+	// Два устройства разом: оба получают один пакет, удаляет первый Ack
+	a, _ := s.mailbox.Begin("bob")
+	b, _ := s.mailbox.Begin("bob")
+	if a == nil || b == nil || a.ID != b.ID {
+		t.Fatalf("devices got different batches: %+v %+v", a, b)
+	}
+	s.mailbox.Ack("bob", a.ID)
+	s.mailbox.Ack("bob", b.ID)
+	if s.mailbox.Has("bob") {
+		t.Fatal("mailbox not drained")
+	}
+
+	// HTTP-клиент: первый GET забирает, второй пуст
+	s.SendDirect(dm("d2", "alice", "bob", "два"))
+	s.SendDirect(dm("d3", "alice", "bob", "три"))
+	if id, msgs := getMailbox(t, srv, "bob"); id == "" || len(msgs) != 2 || msgs[0].ID != "d2" {
+		t.Fatalf("GET /mailbox: %s %+v", id, msgs)
+	}
+	if id, msgs := getMailbox(t, srv, "bob"); id != "" || msgs == nil || len(msgs) != 0 {
+		t.Fatalf("second GET /mailbox: %s %+v", id, msgs)
+	}
+}
+
+func TestDirectToOnlineUser(t *testing.T) {
+	s, srv := newMailboxServer(t)
+	bob, _ := dialUser(t, srv, "bob")
+	alice, _ := dialUser(t, srv, "alice")
+
+	alice.WriteJSON(map[string]string{"kind": "dm", "to": "bob", "text": "ты тут?"})
+	got := readUntil(t, bob, func(m Message) bool { return m.To == "bob" })
+	if got.Text != "ты тут?" || got.User.ID != "alice" {
+		t.Fatalf("live dm: %+v", got)
+	}
+	readUntil(t, alice, func(m Message) bool { return m.ID == got.ID }) // копия автору
+	if s.mailbox.Has("bob") {
+		t.Fatal("dm to an online user went to the mailbox")
+	}
+}
+
+func TestPostDirectMailboxFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mailbox")
+	s := NewChatService(100)
+	if err := s.mailbox.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	prev := chat
+	chat = s
+	t.Cleanup(func() { chat = prev })
+	os.RemoveAll(dir) // Ящик не сохранить
+
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		PostMessageHandler(rec, httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := post(`{"user": {"id": "alice"}, "to": "bob", "text": "привет"}`); code != http.StatusInternalServerError {
+		t.Fatalf("mailbox write failure: %d, want 500", code)
+	}
+	if code := post(`{"user": {"id": "alice"}, "replyTo": "nope", "text": "ответ"}`); code != http.StatusBadRequest {
+		t.Fatalf("reply to a missing message: %d, want 400", code)
+	}
+}
+
+func TestMentionsToMailbox(t *testing.T) {
+	s, srv := newMailboxServer(t)
+	s.mailboxMentions = true
+	bob, _ := dialUser(t, srv, "bob")
+	bob.Close()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s.mu.Lock()
+		online := s.onlineLocked("bob")
+		s.mu.Unlock()
+		if !online {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("bob still online")
+		}
+	}
+
+	s.AddMessage(Message{ID: "m1", User: User{ID: "alice", Name: "alice"}, Text: "@bob и @nobody, гляньте"})
+	batch, _ := s.mailbox.Begin("bob")
+	if batch == nil || len(batch.Messages) != 1 || batch.Messages[0].ID != "m1" {
+		t.Fatalf("mention not mailed: %+v", batch)
+	}
+	if s.mailbox.Has("nobody") {
+		t.Fatal("mailbox created for an unknown user")
+	}
+}
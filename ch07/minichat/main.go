@@ -2,9 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -14,8 +18,10 @@ import (
 // ---------- MODELS ----------
 
 type User struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"`
+	Guest bool   `json:"guest,omitempty"`
 }
 
 type Message struct {
@@ -23,6 +29,37 @@ type Message struct {
 	User      User      `json:"user"`
 	Text      string    `json:"text"`
 	CreatedAt time.Time `json:"createdAt"`
+	System    bool      `json:"system,omitempty"` // служебное сообщение сервера (переименование и т.п.)
+	Paste     *PasteRef `json:"paste,omitempty"`  // Text — превью, полный текст в GET /pastes/{id}
+	// Ответ в ветке (threads.go): ID корневого сообщения
+	ReplyTo  string    `json:"replyTo,omitempty"`
+	EditedAt time.Time `json:"editedAt,omitzero"`
+	Deleted  bool      `json:"deleted,omitempty"` // удалено автором: текст пуст
+	// Служебное сообщение из каталога (i18n.go): Text — уже на языке получателя
+	Key    string            `json:"key,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+	// Метки модерации (moderation.go), например "link"
+	Flags []string `json:"flags,omitempty"`
+	// Личное сообщение (mailbox.go): ID получателя; в общий чат не попадает
+	To string `json:"to,omitempty"`
+}
+
+// systemUser — автор служебных сообщений
+var systemUser = User{ID: "system", Name: "system"}
+
+// client — одно WebSocket-подключение и привязанная к нему личность
+type client struct {
+	conn      *websocket.Conn
+	browserID string
+	writeMu   sync.Mutex // gorilla/websocket: один писатель за раз
+	user      User       // под ChatService.mu
+	lang      string     // язык служебных текстов; под ChatService.mu
+}
+
+func (c *client) send(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(v)
 }
 
 // ---------- CHAT SERVICE ----------
@@ -33,17 +70,38 @@ type ChatService struct {
 	messages []Message
 	capacity int
 	// websocket clients
-	clients map[*websocket.Conn]bool
+	clients map[*websocket.Conn]*client
 	// broadcast channel for new messages
 	broadcast chan Message
+	// длинные тексты, см. paste.go
+	pastes *PasteStore
+	// язык служебных текстов по умолчанию (-lang)
+	lang string
+	// ветки и отметки прочтения, см. threads.go
+	threads *threadIndex
+	reads   map[string]*readMarks
+	// журнал истории (-history), nil — не пишется
+	history *os.File
+	// конвейер модерации (-moderation), nil — выключен
+	moderation *Moderator
+	// почтовые ящики тех, кого нет в сети, см. mailbox.go
+	mailbox         *MailboxStore
+	mailboxMentions bool            // упоминания тоже кладутся в ящик (-mailbox-mentions)
+	seen            map[string]bool // ID пользователей, подключавшихся с запуска
 }
 
 func NewChatService(capacity int) *ChatService {
 	cs := &ChatService{
 		messages:  make([]Message, 0, capacity),
 		capacity:  capacity,
-		clients:   make(map[*websocket.Conn]bool),
+		clients:   make(map[*websocket.Conn]*client),
 		broadcast: make(chan Message, 32),
+		pastes:    NewPasteStore(defaultPasteDir, defaultPasteRetention),
+		lang:      defaultServerLang,
+		threads:   newThreadIndex(),
+		reads:     make(map[string]*readMarks),
+		mailbox:   NewMailboxStore(defaultMailboxLimit),
+		seen:      make(map[string]bool),
 	}
 	// Запускаем горутину, которая разошлёт сообщения подключённым клиентам
 	go cs.run()
@@ -53,29 +111,88 @@ func NewChatService(capacity int) *ChatService {
 // run читает из broadcast и отправляет всем подключённым WebSocket клиентам
 func (s *ChatService) run() {
 	for msg := range s.broadcast {
-		s.mu.Lock()
-		for c := range s.clients {
-			// отправляем асинхронно — чтобы один проблемный клиент не блокировал остальных
-			go func(conn *websocket.Conn, m Message) {
-				_ = conn.WriteJSON(m) // если ошибка — клиент будет закрыт при Read loop на стороне сервера
-			}(c, msg)
-		}
-		s.mu.Unlock()
+		s.broadcastMessage(msg)
 	}
 }
 
-// AddMessage добавляет сообщение в буфер и публикует его
-func (s *ChatService) AddMessage(m Message) {
+// broadcastMessage рассылает сообщение, переводя служебный текст на язык
+// каждого клиента
+func (s *ChatService) broadcastMessage(m Message) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		go func(c *client, m Message) {
+			_ = c.send(m)
+		}(c, localized(m, c.lang))
+	}
+}
+
+// broadcastFrame отправляет произвольный кадр всем клиентам
+func (s *ChatService) broadcastFrame(v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		// отправляем асинхронно — чтобы один проблемный клиент не блокировал остальных
+		go func(c *client) {
+			_ = c.send(v) // если ошибка — клиент будет закрыт при Read loop на стороне сервера
+		}(c)
+	}
+}
 
+// AddMessage пропускает сообщение через модерацию, добавляет в буфер и
+// публикует. Отказ модерации — *RejectedError, сообщение не сохраняется.
+func (s *ChatService) AddMessage(m Message) (Message, error) {
+	if err := s.moderate(&m); err != nil {
+		return m, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addLocked(m)
+	return m, nil
+}
+
+// moderate — конвейер модерации для всего, кроме служебных сообщений.
+// Вызывается до s.mu: правило может быть медленным.
+func (s *ChatService) moderate(m *Message) error {
+	if m.System {
+		return nil
+	}
+	return s.moderation.Moderate(globalRoom, m)
+}
+
+// AddReply добавляет ответ: replyTo переносится на корень ветки.
+// Ответить на удалённое или уже вытесненное сообщение нельзя.
+func (s *ChatService) AddReply(m Message) (Message, error) {
+	if err := s.moderate(&m); err != nil {
+		return m, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root, ok := s.threads.replyRoot(m.ReplyTo)
+	if !ok {
+		return m, errNoMessage
+	}
+	m.ReplyTo = root
+	s.addLocked(m)
+	return m, nil
+}
+
+func (s *ChatService) addLocked(m Message) {
 	// Добавляем в срез, поддерживаем capacity (FIFO)
 	if len(s.messages) >= s.capacity {
 		// сдвиг: выбрасываем старое
+		s.threads.evict(s.messages[0])
 		s.messages = append(s.messages[1:], m)
 	} else {
 		s.messages = append(s.messages, m)
 	}
+	s.threads.add(m)
+	if !m.System {
+		// своё сообщение прочитано
+		s.markReadLocked(m.User.ID, m.ReplyTo, m.CreatedAt)
+	}
+	s.appendHistoryLocked("add", m)
+	s.mailMentionsLocked(m)
 
 	// Отправляем в broadcast (не под мута)
 	// Note: send outside lock — but здесь мы уже в блоке lock; чтобы не блокировать run, отправим в отдельной горутине
@@ -84,6 +201,66 @@ func (s *ChatService) AddMessage(m Message) {
 	}(m)
 }
 
+// EditMessage меняет текст своего сообщения; новый текст проходит модерацию
+func (s *ChatService) EditMessage(userID, id, text string) (Message, error) {
+	draft := Message{ID: id, User: User{ID: userID}, Text: text}
+	if err := s.moderate(&draft); err != nil {
+		return Message{}, err
+	}
+	return s.changeMessage(userID, id, "edit", "edited", func(m *Message) {
+		m.Text = draft.Text
+		m.Flags = draft.Flags
+		m.EditedAt = time.Now().UTC()
+	})
+}
+
+// DeleteMessage удаляет своё сообщение: в истории остаётся пустая запись,
+// чтобы ответы не повисли в воздухе
+func (s *ChatService) DeleteMessage(userID, id string) (Message, error) {
+	return s.changeMessage(userID, id, "delete", "deleted", func(m *Message) {
+		m.Text = ""
+		m.Paste = nil
+		m.Deleted = true
+	})
+}
+
+// changeMessage применяет правку к сообщению автора, обновляет индекс веток
+// и журнал и рассылает кадр kind
+func (s *ChatService) changeMessage(userID, id, op, kind string, apply func(*Message)) (Message, error) {
+	s.mu.Lock()
+	i := s.findLocked(id)
+	if i < 0 || s.messages[i].Deleted || s.messages[i].System {
+		s.mu.Unlock()
+		return Message{}, errNoMessage
+	}
+	if s.messages[i].User.ID != userID {
+		s.mu.Unlock()
+		return Message{}, errNotAuthor
+	}
+	apply(&s.messages[i])
+	m := s.messages[i]
+	if op == "delete" {
+		s.threads.remove(m)
+	} else {
+		s.threads.edit(m)
+	}
+	s.appendHistoryLocked(op, m)
+	s.mu.Unlock()
+
+	s.broadcastFrame(map[string]interface{}{"kind": kind, "message": m})
+	return m, nil
+}
+
+// findLocked — индекс сообщения id в буфере или -1
+func (s *ChatService) findLocked(id string) int {
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
 // GetMessages возвращает копию текущих сообщений
 func (s *ChatService) GetMessages() []Message {
 	s.mu.Lock()
@@ -93,11 +270,122 @@ func (s *ChatService) GetMessages() []Message {
 	return out
 }
 
-// RegisterClient добавляет WebSocket клиент
-func (s *ChatService) RegisterClient(conn *websocket.Conn) {
+// RegisterClient добавляет WebSocket клиент с гостевой личностью,
+// вычисленной из ID браузера.
+func (s *ChatService) RegisterClient(conn *websocket.Conn, browserID string) *client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &client{conn: conn, browserID: browserID, lang: s.lang}
+	c.user = User{
+		ID:    "guest-" + browserID,
+		Name:  s.uniqueNameLocked(guestName(browserID), browserID),
+		Color: colorFor(browserID),
+		Guest: true,
+	}
+	s.clients[conn] = c
+	s.seen[c.user.ID] = true
+	return c
+}
+
+// uniqueNameLocked добавляет числовой суффикс, если имя уже занято другим браузером
+func (s *ChatService) uniqueNameLocked(base, browserID string) string {
+	taken := func(name string) bool {
+		for _, other := range s.clients {
+			if other.browserID != browserID && other.user.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	name := base
+	for i := 2; taken(name); i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	return name
+}
+
+// LangOf возвращает язык служебных текстов клиента
+func (s *ChatService) LangOf(c *client) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.lang
+}
+
+// SetLang выбирает язык клиента по строке в стиле Accept-Language
+func (s *ChatService) SetLang(c *client, accept string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.lang = pickLang(accept, s.lang)
+}
+
+// systemMessage — служебное сообщение из каталога; Text — на языке сервера
+// (для истории и клиентов по HTTP)
+func (s *ChatService) systemMessage(n Notice) Message {
+	return Message{
+		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+		User:      systemUser,
+		Text:      n.Render(s.lang),
+		CreatedAt: time.Now().UTC(),
+		System:    true,
+		Key:       n.Key,
+		Params:    n.Params,
+	}
+}
+
+// UserOf возвращает текущую личность клиента
+func (s *ChatService) UserOf(c *client) User {
 	s.mu.Lock()
-	s.clients[conn] = true
+	defer s.mu.Unlock()
+	return c.user
+}
+
+// Rebind привязывает подключение к настоящему пользователю (hello/auth).
+// Возвращает прежнюю личность и признак того, что она изменилась.
+func (s *ChatService) Rebind(c *client, u User) (User, bool) {
+	s.mu.Lock()
+	old, changed := s.bindLocked(c, u)
 	s.mu.Unlock()
+	if !changed {
+		return old, false
+	}
+
+	s.AddMessage(s.systemMessage(notice(MsgUserRenamed, "old", old.Name, "new", u.Name)))
+	s.broadcastPresence()
+	return old, true
+}
+
+// bindLocked меняет личность подключения без служебного сообщения
+func (s *ChatService) bindLocked(c *client, u User) (User, bool) {
+	old := c.user
+	if u.ID == "" || (u.ID == old.ID && u.Name == old.Name) {
+		return old, false
+	}
+	if u.Name == "" {
+		u.Name = u.ID
+	}
+	u.Color = colorFor(u.ID)
+	u.Guest = false
+	c.user = u
+	s.seen[u.ID] = true
+	return old, true
+}
+
+// Presence — список подключённых пользователей
+func (s *ChatService) Presence() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]User, 0, len(s.clients))
+	for _, c := range s.clients {
+		users = append(users, c.user)
+	}
+	return users
+}
+
+func (s *ChatService) broadcastPresence() {
+	s.broadcastFrame(map[string]interface{}{
+		"kind":  "presence",
+		"users": s.Presence(),
+	})
 }
 
 // UnregisterClient удаляет WebSocket клиент и закрывает соединение
@@ -121,42 +409,169 @@ var upgrader = websocket.Upgrader{
 
 var chat = NewChatService(100) // храним последние 100 сообщений
 
-// WSHandler — апгрейдит соединение и читает сообщения от клиента.
-// Ожидаем, что клиент отправляет JSON вида { "id":"...", "user":{...}, "text":"..." }.
-// На сервере мы добавляем CreatedAt, и пушим всем.
+// inFrame — кадр от клиента: { "kind":"hello", "user":{...}, "lang":"en" }
+// или сообщение { "user":{...}, "text":"...", "replyTo":"..." } (kind пустой).
+// Свои сообщения правятся и удаляются кадрами { "kind":"edit", "id", "text" }
+// и { "kind":"delete", "id" }, прочтение — { "kind":"read", "thread", "id" },
+// личное сообщение — { "kind":"dm", "to", "text" }.
+type inFrame struct {
+	Kind    string `json:"kind"`
+	User    User   `json:"user"`
+	Text    string `json:"text"`
+	Lang    string `json:"lang"` // как Accept-Language: "en-US,en;q=0.9"
+	ID      string `json:"id"`
+	ReplyTo string `json:"replyTo"`
+	Thread  string `json:"thread"`
+	To      string `json:"to"`
+}
+
+// changeErrorFrame — кадр ошибки правки, удаления, ответа или отказа модерации
+func changeErrorFrame(err error, lang string) map[string]interface{} {
+	var rej *RejectedError
+	switch {
+	case errors.As(err, &rej):
+		return errorFrame(notice(MsgRejected, "reason", rej.Reason), lang)
+	case errors.Is(err, errNotAuthor):
+		return errorFrame(notice(MsgNotAuthor), lang)
+	}
+	return errorFrame(notice(MsgNoSuchMessage), lang)
+}
+
+// WSHandler — WebSocket-эндпоинт глобального чата.
 func WSHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	chat.ServeWS(w, r)
+}
+
+// ServeWS — апгрейдит соединение и читает сообщения от клиента.
+// Без hello клиент становится гостем; hello/auth (или сообщение с другим
+// user.id) переименовывает подключение на месте. /ws?user=<id>&name= сразу
+// подключает как этого пользователя — тогда его почтовый ящик приходит до
+// initial_messages.
+// На сервере мы добавляем CreatedAt, и пушим всем.
+func (s *ChatService) ServeWS(w http.ResponseWriter, r *http.Request) {
+	browserID, header := browserIDFromRequest(r)
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
 		log.Println("ws upgrade:", err)
 		return
 	}
-	chat.RegisterClient(conn)
-	defer chat.UnregisterClient(conn)
+	// Кадр побольше лимита текста — на JSON-обёртку и экранирование
+	conn.SetReadLimit(2*maxTextBytes + 1024)
+	c := s.RegisterClient(conn, browserID)
+	if id := r.URL.Query().Get("user"); id != "" {
+		s.mu.Lock()
+		s.bindLocked(c, User{ID: id, Name: r.URL.Query().Get("name")})
+		s.mu.Unlock()
+	}
+	defer func() {
+		s.UnregisterClient(conn)
+		s.broadcastPresence()
+	}()
 
-	// При подключении: отправим последние сообщения
-	if err := conn.WriteJSON(map[string]interface{}{
+	// При подключении: отправим личность и последние сообщения
+	if err := c.send(map[string]interface{}{
+		"kind":    "welcome",
+		"user":    s.UserOf(c),
+		"maxText": maxTextBytes,
+	}); err != nil {
+		log.Println("write welcome:", err)
+		return
+	}
+	if !s.deliverMailbox(c) {
+		return
+	}
+	history := s.GetMessages()
+	for i := range history {
+		history[i] = localized(history[i], s.LangOf(c))
+	}
+	if err := c.send(map[string]interface{}{
 		"kind":     "initial_messages",
-		"messages": chat.GetMessages(),
+		"messages": history,
 	}); err != nil {
 		log.Println("write initial:", err)
 		return
 	}
+	s.broadcastPresence()
 
 	// Читаем сообщения от клиента
 	for {
-		var incoming Message
-		if err := conn.ReadJSON(&incoming); err != nil {
+		var in inFrame
+		if err := conn.ReadJSON(&in); err != nil {
 			// Обычно client disconnects — выход
 			log.Println("ws read error (client may disconnect):", err)
 			return
 		}
 
+		switch in.Kind {
+		case "hello", "auth":
+			if in.Lang != "" {
+				s.SetLang(c, in.Lang)
+			}
+			if _, changed := s.Rebind(c, in.User); changed {
+				_ = c.send(map[string]interface{}{"kind": "welcome", "user": s.UserOf(c)})
+				s.deliverMailbox(c)
+			}
+			continue
+		case "edit":
+			if in.Text == "" {
+				continue
+			}
+			if len(in.Text) > maxTextBytes {
+				_ = c.send(errorFrame(notice(MsgTextTooLong, "limit", strconv.Itoa(maxTextBytes)), s.LangOf(c)))
+				continue
+			}
+			if _, err := s.EditMessage(s.UserOf(c).ID, in.ID, in.Text); err != nil {
+				_ = c.send(changeErrorFrame(err, s.LangOf(c)))
+			}
+			continue
+		case "delete":
+			if _, err := s.DeleteMessage(s.UserOf(c).ID, in.ID); err != nil {
+				_ = c.send(changeErrorFrame(err, s.LangOf(c)))
+			}
+			continue
+		case "read":
+			if err := s.MarkRead(s.UserOf(c).ID, in.Thread, in.ID); err != nil {
+				_ = c.send(changeErrorFrame(err, s.LangOf(c)))
+			}
+			continue
+		}
+		if in.Text == "" {
+			continue
+		}
+		if len(in.Text) > maxTextBytes {
+			_ = c.send(errorFrame(notice(MsgTextTooLong, "limit", strconv.Itoa(maxTextBytes)), s.LangOf(c)))
+			continue
+		}
+		// Старые клиенты присылают user в каждом сообщении — считаем это hello
+		if in.User.ID != "" && in.User.ID != s.UserOf(c).ID {
+			s.Rebind(c, in.User)
+		}
+		if in.Kind == "dm" && in.To == "" {
+			continue
+		}
+
 		// Сформируем сообщение серверной стороны: назначим ID и CreatedAt
-		incoming.ID = fmt.Sprintf("%d", time.Now().UnixNano())
-		incoming.CreatedAt = time.Now().UTC()
+		msg := Message{
+			ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+			User:      s.UserOf(c),
+			Text:      in.Text,
+			CreatedAt: time.Now().UTC(),
+			ReplyTo:   in.ReplyTo,
+		}
 
 		// Добавляем в сервис (автоматически разошлёт другим)
-		chat.AddMessage(incoming)
+		switch {
+		case in.Kind == "dm":
+			msg.To, msg.ReplyTo = in.To, ""
+			_, err = s.SendDirect(msg)
+		case msg.ReplyTo == "":
+			_, err = s.AddMessage(msg)
+		default:
+			_, err = s.AddReply(msg)
+		}
+		if err != nil {
+			_ = c.send(changeErrorFrame(err, s.LangOf(c)))
+		}
 	}
 }
 
@@ -168,15 +583,18 @@ func GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // PostMessageHandler HTTP: отправить сообщение через POST (полезно для curl)
-// JSON: { "user": { "id":"u1","name":"Vlad" }, "text": "Hello" }
+// JSON: { "user": { "id":"u1","name":"Vlad" }, "text": "Hello", "replyTo": "<id>" };
+// с "to": "<id>" — личное сообщение (mailbox.go)
 func PostMessageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	var in struct {
-		User User   `json:"user"`
-		Text string `json:"text"`
+		User    User   `json:"user"`
+		Text    string `json:"text"`
+		ReplyTo string `json:"replyTo"`
+		To      string `json:"to"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		http.Error(w, "invalid json", http.StatusBadRequest)
@@ -186,19 +604,83 @@ func PostMessageHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "text required", http.StatusBadRequest)
 		return
 	}
+	if len(in.Text) > maxTextBytes {
+		http.Error(w, "text too long, use POST /pastes", http.StatusRequestEntityTooLarge)
+		return
+	}
 	m := Message{
 		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
 		User:      in.User,
 		Text:      in.Text,
 		CreatedAt: time.Now().UTC(),
+		ReplyTo:   in.ReplyTo,
+	}
+	var err error
+	switch {
+	case in.To != "":
+		m.To, m.ReplyTo = in.To, ""
+		m, err = chat.SendDirect(m)
+	case m.ReplyTo == "":
+		m, err = chat.AddMessage(m)
+	default:
+		m, err = chat.AddReply(m)
+	}
+	var rej *RejectedError
+	switch {
+	case errors.As(err, &rej):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, errNoMessage):
+		http.Error(w, "replyTo: "+err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Println("post message:", err)
+		http.Error(w, "could not deliver message", http.StatusInternalServerError)
+		return
 	}
-	chat.AddMessage(m)
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(m)
 }
 
 func main() {
+	flag.DurationVar(&chat.pastes.retention, "paste-retention", defaultPasteRetention, "how long pastes are kept")
+	flag.StringVar(&chat.pastes.dir, "paste-dir", defaultPasteDir, "directory for paste files")
+	flag.StringVar(&chat.lang, "lang", defaultServerLang, "language of server messages (ru, en)")
+	historyPath := flag.String("history", defaultHistoryPath, "message history journal (empty — keep history in memory only)")
+	moderationPath := flag.String("moderation", "", "moderation pipeline config (JSON); empty — no moderation")
+	mailboxDir := flag.String("mailbox", defaultMailboxDir, "directory for offline users' mailboxes (empty — keep them in memory only)")
+	flag.IntVar(&chat.mailbox.limit, "mailbox-limit", defaultMailboxLimit, "messages kept per mailbox; older ones are dropped")
+	flag.BoolVar(&chat.mailboxMentions, "mailbox-mentions", false, "also put @<id> mentions of offline users into their mailbox")
+	flag.Parse()
+	if _, ok := catalogs[chat.lang]; !ok {
+		log.Fatalf("unknown -lang %q", chat.lang)
+	}
+	if *moderationPath != "" {
+		md, err := LoadModeration(*moderationPath)
+		if err != nil {
+			log.Fatalf("moderation: %v", err)
+		}
+		chat.moderation = md
+	}
+	if *historyPath != "" {
+		if err := chat.LoadHistory(*historyPath); err != nil {
+			log.Fatalf("history: %v", err)
+		}
+	}
+	if *mailboxDir != "" {
+		if err := chat.mailbox.Open(*mailboxDir); err != nil {
+			log.Fatalf("mailbox: %v", err)
+		}
+	}
+	go chat.pastes.sweepLoop(10 * time.Minute)
+
 	http.HandleFunc("/ws", WSHandler)
+	http.HandleFunc("POST /pastes", chat.CreatePasteHandler)
+	http.HandleFunc("GET /pastes/{id}", chat.GetPasteHandler)
+	http.HandleFunc("GET /threads", chat.ThreadsHandler)
+	http.HandleFunc("GET /unread", chat.UnreadHandler)
+	http.HandleFunc("POST /read", chat.ReadHandler)
+	http.HandleFunc("GET /mailbox", chat.MailboxHandler)
 	http.HandleFunc("/messages", GetMessagesHandler)        // GET
 	http.HandleFunc("/message", PostMessageHandler)         // POST
 	http.Handle("/", http.FileServer(http.Dir("./static"))) // serve client
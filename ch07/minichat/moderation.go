@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ---------- MODERATION ----------
+
+// Каждое пользовательское сообщение (новое, ответ, правка, вставка) до
+// сохранения и рассылки проходит конвейер правил. Правило может поправить
+// текст (замаскировать, вырезать), пометить сообщение флагом или отказать —
+// тогда ничего не сохраняется, а автор получает кадр ошибки. Служебные
+// сообщения сервера конвейер не проходят.
+//
+// Порядок правил и включение по комнатам задаёт файл -moderation (JSON):
+//
+//	{
+//	  "pipeline": ["profanity", "links"],
+//	  "words": "badwords.txt",
+//	  "links": "strip",
+//	  "rooms": {"global": {"links": false}}
+//	}
+//
+// Встроенные правила — profanity (словарь words, маскировка звёздочками) и
+// links (strip — вырезать ссылки, flag — пометить сообщение). Свои правила
+// регистрируются RegisterRule и подключаются по имени в pipeline. Правило,
+// не упомянутое в rooms, включено во всех комнатах.
+//
+// Маскируется сам текст сообщения, поэтому в журнале истории, GET
+// /messages и вставках (GET /pastes/{id}) он одинаковый.
+
+// ModerationRule — звено конвейера. Apply правит m на месте; ошибка —
+// отказ. У правки Message содержит только ID автора и новый текст.
+type ModerationRule interface {
+	Apply(m *Message) error
+}
+
+// RuleFunc — правило из функции
+type RuleFunc func(m *Message) error
+
+func (f RuleFunc) Apply(m *Message) error { return f(m) }
+
+// ModerationConfig — файл -moderation
+type ModerationConfig struct {
+	Pipeline []string                   `json:"pipeline"`
+	Words    string                     `json:"words,omitempty"` // словарь profanity: слово на строку, # — комментарий
+	Links    string                     `json:"links,omitempty"` // strip | flag
+	Rooms    map[string]map[string]bool `json:"rooms,omitempty"` // комната → правило → включено
+
+	dir string // каталог файла конфигурации: от него считается words
+}
+
+// RuleFactory строит правило по конфигурации
+type RuleFactory func(cfg ModerationConfig) (ModerationRule, error)
+
+var ruleFactories = map[string]RuleFactory{
+	"profanity": newProfanityRule,
+	"links":     newLinkRule,
+}
+
+// RegisterRule добавляет правило, которое можно назвать в pipeline
+func RegisterRule(name string, f RuleFactory) {
+	ruleFactories[name] = f
+}
+
+// RejectedError — отказ правила
+type RejectedError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected by %s: %s", e.Rule, e.Reason)
+}
+
+type namedRule struct {
+	name string
+	rule ModerationRule
+}
+
+// Moderator — собранный конвейер; nil пропускает всё как есть
+type Moderator struct {
+	steps []namedRule
+	rooms map[string]map[string]bool
+}
+
+// LoadModeration читает конфигурацию из файла и собирает конвейер
+func LoadModeration(path string) (*Moderator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg ModerationConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg.dir = filepath.Dir(path)
+	return NewModerator(cfg)
+}
+
+// NewModerator собирает конвейер в порядке cfg.Pipeline
+func NewModerator(cfg ModerationConfig) (*Moderator, error) {
+	md := &Moderator{rooms: cfg.Rooms}
+	for _, name := range cfg.Pipeline {
+		factory, ok := ruleFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("moderation: unknown rule %q", name)
+		}
+		rule, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("moderation: %s: %w", name, err)
+		}
+		md.steps = append(md.steps, namedRule{name, rule})
+	}
+	return md, nil
+}
+
+// Moderate прогоняет сообщение комнаты room через включённые правила.
+// Ошибка — *RejectedError.
+func (md *Moderator) Moderate(room string, m *Message) error {
+	if md == nil {
+		return nil
+	}
+	for _, step := range md.steps {
+		if on, ok := md.rooms[room][step.name]; ok && !on {
+			continue
+		}
+		if err := step.rule.Apply(m); err != nil {
+			var rej *RejectedError
+			if errors.As(err, &rej) {
+				return rej
+			}
+			return &RejectedError{Rule: step.name, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// ---------- profanity ----------
+
+// Замены leet-написания. Слово с кириллицей приводится к кириллице (цифры
+// и похожие латинские буквы), остальные — к латинице.
+var (
+	leetLatin = map[rune]rune{
+		'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's',
+	}
+	leetCyrillic = map[rune]rune{
+		'0': 'о', '3': 'з', '4': 'ч', '6': 'б', '@': 'а',
+		'a': 'а', 'b': 'в', 'c': 'с', 'e': 'е', 'h': 'н', 'k': 'к', 'm': 'м',
+		'o': 'о', 'p': 'р', 't': 'т', 'x': 'х', 'y': 'у',
+	}
+)
+
+// wordRune — часть слова: буквы, цифры и leet-символы
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '@' || r == '$'
+}
+
+// normalizeWord — нижний регистр, ё как е и leet-замены
+func normalizeWord(word string) string {
+	word = strings.ReplaceAll(strings.ToLower(word), "ё", "е")
+	table := leetLatin
+	for _, r := range word {
+		if unicode.Is(unicode.Cyrillic, r) {
+			table = leetCyrillic
+			break
+		}
+	}
+	return strings.Map(func(r rune) rune {
+		if to, ok := table[r]; ok {
+			return to
+		}
+		return r
+	}, word)
+}
+
+// profanityRule маскирует слова из словаря звёздочками по числу символов
+type profanityRule struct {
+	words map[string]bool // нормализованные
+}
+
+func newProfanityRule(cfg ModerationConfig) (ModerationRule, error) {
+	if cfg.Words == "" {
+		return nil, errors.New("no words file")
+	}
+	path := cfg.Words
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfg.dir, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &profanityRule{words: map[string]bool{}}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r.words[normalizeWord(line)] = true
+	}
+	return r, sc.Err()
+}
+
+func (p *profanityRule) Apply(m *Message) error {
+	m.Text = p.mask(m.Text)
+	return nil
+}
+
+// mask заменяет каждое слово словаря звёздочками той же длины в рунах
+func (p *profanityRule) mask(text string) string {
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !wordRune(runes[i]) {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && wordRune(runes[j]) {
+			j++
+		}
+		word := string(runes[i:j])
+		if p.words[normalizeWord(word)] {
+			word = strings.Repeat("*", j-i)
+		}
+		b.WriteString(word)
+		i = j
+	}
+	return b.String()
+}
+
+// ---------- links ----------
+
+const (
+	linksStrip = "strip"
+	linksFlag  = "flag"
+
+	// FlagLink — метка сообщения со ссылкой (links: flag)
+	FlagLink = "link"
+	// linkPlaceholder — что остаётся на месте вырезанной ссылки
+	linkPlaceholder = "[…]"
+)
+
+var linkRe = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+type linkRule struct {
+	policy string
+}
+
+func newLinkRule(cfg ModerationConfig) (ModerationRule, error) {
+	switch cfg.Links {
+	case linksStrip, linksFlag:
+		return linkRule{cfg.Links}, nil
+	}
+	return nil, fmt.Errorf("links must be %q or %q, got %q", linksStrip, linksFlag, cfg.Links)
+}
+
+func (l linkRule) Apply(m *Message) error {
+	if !linkRe.MatchString(m.Text) {
+		return nil
+	}
+	if l.policy == linksStrip {
+		m.Text = linkRe.ReplaceAllString(m.Text, linkPlaceholder)
+		return nil
+	}
+	for _, f := range m.Flags {
+		if f == FlagLink {
+			return nil
+		}
+	}
+	m.Flags = append(m.Flags, FlagLink)
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testModerator собирает конвейер со словарём words во временном каталоге
+func testModerator(t *testing.T, cfg ModerationConfig, words ...string) *Moderator {
+	t.Helper()
+	cfg.dir = t.TempDir()
+	cfg.Words = "words.txt"
+	list := "# тестовый словарь\n" + strings.Join(words, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(cfg.dir, cfg.Words), []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	md, err := NewModerator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return md
+}
+
+func TestNormalizeWordLeet(t *testing.T) {
+	for in, want := range map[string]string{
+		"HELL":  "hell",
+		"h3ll":  "hell",
+		"$h1t":  "shit",
+		"Дур@к": "дурак",
+		"3ЛО":   "зло",
+		"дypak": "дурак", // латинские y, p, a, k среди кириллицы
+		"ёжик":  "ежик",
+	} {
+		if got := normalizeWord(in); got != want {
+			t.Errorf("normalizeWord(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestProfanityMasking(t *testing.T) {
+	md := testModerator(t, ModerationConfig{Pipeline: []string{"profanity"}}, "hell", "Дурак")
+	for in, want := range map[string]string{
+		"go to hell!":          "go to ****!",
+		"H3LL, no":             "****, no",
+		"сам ты дур@к.":        "сам ты *****.",
+		"ДУРАК и дурачок":      "***** и дурачок",
+		"shell и hello — мимо": "shell и hello — мимо",
+	} {
+		m := Message{Text: in}
+		if err := md.Moderate(globalRoom, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Text != want {
+			t.Errorf("%q masked as %q, want %q", in, m.Text, want)
+		}
+	}
+}
+
+func TestLinkPolicy(t *testing.T) {
+	strip := testModerator(t, ModerationConfig{Pipeline: []string{"links"}, Links: linksStrip})
+	m := Message{Text: "смотри https://example.com/x?y=1 и www.example.org"}
+	strip.Moderate(globalRoom, &m)
+	if m.Text != "смотри "+linkPlaceholder+" и "+linkPlaceholder {
+		t.Fatalf("strip: %q", m.Text)
+	}
+
+	flag := testModerator(t, ModerationConfig{Pipeline: []string{"links"}, Links: linksFlag})
+	m = Message{Text: "http://example.com"}
+	flag.Moderate(globalRoom, &m)
+	flag.Moderate(globalRoom, &m)
+	if m.Text != "http://example.com" || len(m.Flags) != 1 || m.Flags[0] != FlagLink {
+		t.Fatalf("flag: %+v", m)
+	}
+
+	if _, err := NewModerator(ModerationConfig{Pipeline: []string{"links"}, Links: "drop"}); err == nil {
+		t.Fatal("unknown link policy accepted")
+	}
+}
+
+func TestModerationRoomFlags(t *testing.T) {
+	md := testModerator(t, ModerationConfig{
+		Pipeline: []string{"profanity", "links"},
+		Links:    linksStrip,
+		Rooms:    map[string]map[string]bool{globalRoom: {"links": false}},
+	}, "hell")
+	m := Message{Text: "hell: https://example.com"}
+	md.Moderate(globalRoom, &m)
+	if m.Text != "****: https://example.com" {
+		t.Fatalf("links should be off in %s: %q", globalRoom, m.Text)
+	}
+	m = Message{Text: "hell: https://example.com"}
+	md.Moderate("other", &m)
+	if m.Text != "****: "+linkPlaceholder {
+		t.Fatalf("other room: %q", m.Text)
+	}
+}
+
+func TestRejectingRule(t *testing.T) {
+	RegisterRule("no-caps", func(ModerationConfig) (ModerationRule, error) {
+		return RuleFunc(func(m *Message) error {
+			if m.Text != "" && m.Text == strings.ToUpper(m.Text) {
+				return errors.New("не кричите")
+			}
+			return nil
+		}), nil
+	})
+	t.Cleanup(func() { delete(ruleFactories, "no-caps") })
+
+	s := NewChatService(100)
+	s.moderation = testModerator(t, ModerationConfig{Pipeline: []string{"profanity", "no-caps"}}, "hell")
+	srv := httptest.NewServer(http.HandlerFunc(s.ServeWS))
+	t.Cleanup(srv.Close)
+	conn, _ := dialAs(t, srv, "browser-a")
+	defer conn.Close()
+
+	if err := conn.WriteJSON(map[string]string{"text": "ЭЙ ВЫ"}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var frame struct {
+		Kind  string `json:"kind"`
+		Key   string `json:"key"`
+		Error string `json:"error"`
+	}
+	for frame.Kind != "error" {
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if frame.Kind != "error" || frame.Key != MsgRejected || !strings.Contains(frame.Error, "не кричите") {
+		t.Fatalf("expected rejection frame, got %+v", frame)
+	}
+	if got := s.GetMessages(); len(got) != 0 {
+		t.Fatalf("rejected message stored: %+v", got)
+	}
+
+	// Пропущенное сообщение приходит уже замаскированным
+	if err := conn.WriteJSON(map[string]string{"text": "what the hell"}); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, conn, func(m Message) bool { return m.Text == "what the ****" })
+}
+
+func TestMaskedTextInHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s := NewChatService(100)
+	s.moderation = testModerator(t, ModerationConfig{Pipeline: []string{"profanity"}}, "hell")
+	if err := s.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	s.AddMessage(Message{ID: "m1", User: User{ID: "ann"}, Text: "hell"})
+	if _, err := s.EditMessage("ann", "m1", "h3ll yes"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hell") || strings.Contains(string(data), "h3ll") {
+		t.Fatalf("unmasked word in history:\n%s", data)
+	}
+	again := NewChatService(100)
+	if err := again.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := again.GetMessages(); len(got) != 1 || got[0].Text != "**** yes" {
+		t.Fatalf("reloaded: %+v", got)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ---------- PASTES ----------
+
+// Длинный текст (логи и т.п.) не идёт через WebSocket: клиент загружает его
+// через POST /pastes, а в чат уходит обычное сообщение с превью и ссылкой.
+// Полный текст клиент забирает лениво через GET /pastes/{id}.
+
+const (
+	maxTextBytes          = 4 << 10   // лимит текста обычного сообщения
+	maxPasteBytes         = 256 << 10 // лимит одной вставки
+	pastePreviewLines     = 10
+	pastePreviewRunes     = 1000 // превью не должно само упереться в maxTextBytes
+	defaultPasteDir       = "./pastes"
+	defaultPasteRetention = 24 * time.Hour
+	globalRoom            = "global" // пока чат один — все вставки в общей комнате
+)
+
+var (
+	errPasteNotFound = errors.New("paste not found")
+	errPasteTooLarge = errors.New("paste too large")
+	errPasteEncoding = errors.New("paste must be UTF-8 text")
+)
+
+// PasteRef — ссылка на вставку внутри сообщения
+type PasteRef struct {
+	ID        string `json:"id"`
+	Lines     int    `json:"lines"`
+	Size      int    `json:"size"`
+	Truncated bool   `json:"truncated"` // в превью не весь текст
+}
+
+// Paste — метаданные вставки; сам текст лежит файлом в каталоге хранилища
+type Paste struct {
+	PasteRef
+	Room      string
+	Author    User
+	CreatedAt time.Time
+}
+
+// PasteStore хранит вставки на диске, индекс — в памяти.
+// Вставки старше retention считаются удалёнными.
+type PasteStore struct {
+	dir       string
+	retention time.Duration
+	now       func() time.Time
+
+	mu     sync.Mutex
+	pastes map[string]Paste
+}
+
+func NewPasteStore(dir string, retention time.Duration) *PasteStore {
+	return &PasteStore{
+		dir:       dir,
+		retention: retention,
+		now:       time.Now,
+		pastes:    make(map[string]Paste),
+	}
+}
+
+// pastePreview возвращает первые pastePreviewLines строк (не больше
+// pastePreviewRunes рун), число строк всего текста и признак обрезки.
+// Считаются руны, а не байты — многобайтный текст не режется посреди символа.
+func pastePreview(text string) (preview string, lines int, truncated bool) {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return "", 0, false
+	}
+	lines = strings.Count(text, "\n") + 1
+
+	end, n, runes := len(text), 0, 0
+	for i, r := range text {
+		if runes == pastePreviewRunes {
+			end = i
+			break
+		}
+		runes++
+		if r == '\n' {
+			n++
+			if n == pastePreviewLines {
+				end = i
+				break
+			}
+		}
+	}
+	return text[:end], lines, end < len(text)
+}
+
+func newPasteID() (string, error) {
+	b := make([]byte, 9)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Save сохраняет текст и возвращает вставку с превью для сообщения
+func (ps *PasteStore) Save(room string, author User, text string) (Paste, string, error) {
+	if len(text) > maxPasteBytes {
+		return Paste{}, "", errPasteTooLarge
+	}
+	if !utf8.ValidString(text) {
+		return Paste{}, "", errPasteEncoding
+	}
+	id, err := newPasteID()
+	if err != nil {
+		return Paste{}, "", err
+	}
+	if err := os.MkdirAll(ps.dir, 0o755); err != nil {
+		return Paste{}, "", err
+	}
+	if err := os.WriteFile(filepath.Join(ps.dir, id+".txt"), []byte(text), 0o644); err != nil {
+		return Paste{}, "", err
+	}
+	preview, lines, truncated := pastePreview(text)
+	p := Paste{
+		PasteRef:  PasteRef{ID: id, Lines: lines, Size: len(text), Truncated: truncated},
+		Room:      room,
+		Author:    author,
+		CreatedAt: ps.now().UTC(),
+	}
+	ps.mu.Lock()
+	ps.pastes[id] = p
+	ps.mu.Unlock()
+	return p, preview, nil
+}
+
+// Get возвращает вставку и её текст; просроченная удаляется на месте
+func (ps *PasteStore) Get(id string) (Paste, string, error) {
+	ps.mu.Lock()
+	p, ok := ps.pastes[id]
+	if ok && ps.expired(p) {
+		ps.removeLocked(id)
+		ok = false
+	}
+	ps.mu.Unlock()
+	if !ok {
+		return Paste{}, "", errPasteNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(ps.dir, id+".txt"))
+	if err != nil {
+		return Paste{}, "", errPasteNotFound
+	}
+	return p, string(data), nil
+}
+
+func (ps *PasteStore) expired(p Paste) bool {
+	return ps.now().Sub(p.CreatedAt) > ps.retention
+}
+
+func (ps *PasteStore) removeLocked(id string) {
+	delete(ps.pastes, id)
+	if err := os.Remove(filepath.Join(ps.dir, id+".txt")); err != nil && !os.IsNotExist(err) {
+		log.Println("paste remove:", err)
+	}
+}
+
+// Sweep удаляет все просроченные вставки, возвращает их число
+func (ps *PasteStore) Sweep() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	n := 0
+	for id, p := range ps.pastes {
+		if ps.expired(p) {
+			ps.removeLocked(id)
+			n++
+		}
+	}
+	return n
+}
+
+// sweepLoop периодически чистит хранилище
+func (ps *PasteStore) sweepLoop(every time.Duration) {
+	for range time.Tick(every) {
+		if n := ps.Sweep(); n > 0 {
+			log.Printf("pastes: removed %d expired", n)
+		}
+	}
+}
+
+// ---------- HTTP ----------
+
+// memberOf — подключён ли браузер к комнате. Вставки доступны только
+// участникам комнаты, в которую они отправлены.
+func (s *ChatService) memberOf(browserID, room string) (*client, bool) {
+	if room != globalRoom {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		if c.browserID == browserID {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// requestMember находит подключение по cookie браузера или отвечает 403
+func (s *ChatService) requestMember(w http.ResponseWriter, r *http.Request, room string) (*client, bool) {
+	ck, err := r.Cookie(browserCookie)
+	if err != nil || ck.Value == "" {
+		http.Error(w, "not a room member", http.StatusForbidden)
+		return nil, false
+	}
+	c, ok := s.memberOf(ck.Value, room)
+	if !ok {
+		http.Error(w, "not a room member", http.StatusForbidden)
+		return nil, false
+	}
+	return c, true
+}
+
+// CreatePasteHandler — POST /pastes, тело — сам текст.
+// Сохраняет вставку и рассылает сообщение с превью.
+func (s *ChatService) CreatePasteHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.requestMember(w, r, globalRoom)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPasteBytes))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, fmt.Sprintf("paste exceeds %d bytes", maxPasteBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "read error", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(string(body)) == "" {
+		http.Error(w, "text required", http.StatusBadRequest)
+		return
+	}
+
+	// Вставка проходит ту же модерацию, что и сообщение: в файле
+	// хранится уже замаскированный текст
+	author := s.UserOf(c)
+	draft := Message{User: author, Text: string(body)}
+	if err := s.moderate(&draft); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	p, preview, err := s.pastes.Save(globalRoom, author, draft.Text)
+	switch {
+	case errors.Is(err, errPasteEncoding):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Println("paste save:", err)
+		http.Error(w, "could not store paste", http.StatusInternalServerError)
+		return
+	}
+
+	ref := p.PasteRef
+	msg := Message{
+		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+		User:      author,
+		Text:      preview,
+		CreatedAt: time.Now().UTC(),
+		Paste:     &ref,
+	}
+	if msg, err = s.AddMessage(msg); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+// GetPasteHandler — GET /pastes/{id}: полный текст вставки
+func (s *ChatService) GetPasteHandler(w http.ResponseWriter, r *http.Request) {
+	p, text, err := s.pastes.Get(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if _, ok := s.requestMember(w, r, p.Room); !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	_, _ = io.WriteString(w, text)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestPastePreviewTruncation(t *testing.T) {
+	var long []string
+	for i := 1; i <= 15; i++ {
+		long = append(long, "строка №"+strings.Repeat("ё", i))
+	}
+	cases := []struct {
+		name      string
+		text      string
+		wantLines int
+		wantTrunc bool
+		wantEnd   string
+	}{
+		{"short", "один\nдва", 2, false, "два"},
+		{"trailing newline", "один\nдва\n\n", 2, false, "два"},
+		{"fifteen lines", strings.Join(long, "\n"), 15, true, long[9]},
+		{"exactly ten", strings.Join(long[:10], "\n") + "\n", 10, false, long[9]},
+	}
+	for _, tc := range cases {
+		preview, lines, trunc := pastePreview(tc.text)
+		if lines != tc.wantLines || trunc != tc.wantTrunc || !strings.HasSuffix(preview, tc.wantEnd) {
+			t.Errorf("%s: lines=%d trunc=%v preview ends %q", tc.name, lines, trunc, preview[max(0, len(preview)-20):])
+		}
+		if strings.Count(preview, "\n") >= pastePreviewLines {
+			t.Errorf("%s: preview has too many lines", tc.name)
+		}
+	}
+
+	// Одна длинная строка из многобайтных символов режется по рунам
+	preview, lines, trunc := pastePreview(strings.Repeat("日本", 2000))
+	if !utf8.ValidString(preview) || utf8.RuneCountInString(preview) != pastePreviewRunes || lines != 1 || !trunc {
+		t.Fatalf("rune cap: valid=%v runes=%d lines=%d trunc=%v",
+			utf8.ValidString(preview), utf8.RuneCountInString(preview), lines, trunc)
+	}
+}
+
+func TestPasteExpiry(t *testing.T) {
+	ps := NewPasteStore(t.TempDir(), time.Hour)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ps.now = func() time.Time { return now }
+
+	old, _, err := ps.Save(globalRoom, User{ID: "u"}, "старый лог")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(45 * time.Minute)
+	fresh, _, _ := ps.Save(globalRoom, User{ID: "u"}, "свежий лог")
+	if _, text, err := ps.Get(old.ID); err != nil || text != "старый лог" {
+		t.Fatalf("paste within retention: %q %v", text, err)
+	}
+
+	now = now.Add(30 * time.Minute) // old: 75 мин, fresh: 30 мин
+	if _, _, err := ps.Get(old.ID); err != errPasteNotFound {
+		t.Fatalf("expired paste should be gone, got %v", err)
+	}
+	now = now.Add(time.Hour)
+	if n := ps.Sweep(); n != 1 {
+		t.Fatalf("sweep removed %d, want 1", n)
+	}
+	if _, _, err := ps.Get(fresh.ID); err != errPasteNotFound {
+		t.Fatal("swept paste should be gone")
+	}
+}
+
+func TestPasteRequiresMembershipAndBroadcasts(t *testing.T) {
+	s := NewChatService(100)
+	s.pastes = NewPasteStore(t.TempDir(), time.Hour)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.ServeWS)
+	mux.HandleFunc("POST /pastes", s.CreatePasteHandler)
+	mux.HandleFunc("GET /pastes/{id}", s.GetPasteHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	text := strings.Repeat("лог строка\n", 50)
+	post := func(browser string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/pastes", strings.NewReader(text))
+		req.Header.Set("Cookie", browserCookie+"="+browser)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := post("stranger"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("non-member upload: %d", resp.StatusCode)
+	}
+
+	conn, _ := dialAs(t, srv, "browser-a")
+	defer conn.Close()
+	if resp := post("browser-a"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("member upload: %d", resp.StatusCode)
+	}
+	msg := readUntil(t, conn, func(m Message) bool { return m.Paste != nil })
+	if msg.Paste.Lines != 50 || !msg.Paste.Truncated || strings.Count(msg.Text, "\n") != pastePreviewLines-1 {
+		t.Fatalf("unexpected paste message %+v", msg)
+	}
+
+	get := func(browser string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/pastes/"+msg.Paste.ID, nil)
+		req.Header.Set("Cookie", browserCookie+"="+browser)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if code, _ := get("stranger"); code != http.StatusForbidden {
+		t.Fatalf("non-member fetch: %d", code)
+	}
+	if code, body := get("browser-a"); code != http.StatusOK || body != text {
+		t.Fatalf("member fetch: %d, %d bytes", code, len(body))
+	}
+}
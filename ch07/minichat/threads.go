@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ---------- THREADS ----------
+
+// Ответ — сообщение с replyTo: ветка всегда висит на корневом сообщении,
+// ответ на ответ переносится к его корню. Список веток (GET /threads) и
+// непрочитанное по веткам (GET /unread) не сканируют историю: threadIndex
+// обновляется на каждое добавление, правку, удаление и вытеснение сообщения
+// из буфера, а при старте строится заново из сохранённой истории (history.go).
+//
+// Удалённое сообщение остаётся в истории пустым (Deleted): удалённый корень
+// не убирает ветку, пока в ней есть ответы, а удалённый ответ больше не
+// считается. Корень, вытесненный из буфера, уносит ветку с собой.
+
+const (
+	threadPreviewRunes = 140
+	defaultThreadLimit = 20
+	maxThreadLimit     = 100
+)
+
+var (
+	errNoMessage = errors.New("no such message")
+	errNotAuthor = errors.New("not the author")
+)
+
+// msgRef — что индекс знает о каждом сообщении в буфере
+type msgRef struct {
+	root      string // ID корня ветки; "" — само сообщение корневое
+	author    string
+	createdAt time.Time
+	deleted   bool
+}
+
+// threadRoot — превью корневого сообщения
+type threadRoot struct {
+	ID        string    `json:"id"`
+	User      User      `json:"user"`
+	Preview   string    `json:"preview"`
+	CreatedAt time.Time `json:"createdAt"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+type threadReply struct {
+	id        string
+	user      User
+	createdAt time.Time
+}
+
+// threadEntry — ветка с хотя бы одним ответом. replies идут по времени.
+type threadEntry struct {
+	root         threadRoot
+	replies      []threadReply
+	participants map[string]*participant // по ID пользователя
+}
+
+type participant struct {
+	user    User
+	replies int
+}
+
+// ThreadSummary — строка списка веток
+type ThreadSummary struct {
+	Root         threadRoot `json:"root"`
+	ReplyCount   int        `json:"replyCount"`
+	LastReplyAt  time.Time  `json:"lastReplyAt"`
+	Participants []User     `json:"participants"` // по имени
+}
+
+// threadIndex — ветки сообщений из буфера; под ChatService.mu
+type threadIndex struct {
+	refs    map[string]msgRef
+	roots   map[string]threadRoot // превью всех корневых сообщений буфера
+	threads map[string]*threadEntry
+}
+
+func newThreadIndex() *threadIndex {
+	return &threadIndex{
+		refs:    make(map[string]msgRef),
+		roots:   make(map[string]threadRoot),
+		threads: make(map[string]*threadEntry),
+	}
+}
+
+// rebuildThreads строит индекс по истории с нуля
+func rebuildThreads(history []Message) *threadIndex {
+	ix := newThreadIndex()
+	for _, m := range history {
+		ix.add(m)
+	}
+	return ix
+}
+
+func rootPreview(m Message) threadRoot {
+	text := m.Text
+	if utf8.RuneCountInString(text) > threadPreviewRunes {
+		text = string([]rune(text)[:threadPreviewRunes]) + "…"
+	}
+	return threadRoot{ID: m.ID, User: m.User, Preview: text, CreatedAt: m.CreatedAt, Deleted: m.Deleted}
+}
+
+// replyRoot — корень ветки для ответа на parentID; ok=false, если такого
+// сообщения в буфере нет или оно (или его корень) удалено.
+func (ix *threadIndex) replyRoot(parentID string) (string, bool) {
+	ref, ok := ix.refs[parentID]
+	if !ok || ref.deleted {
+		return "", false
+	}
+	root := parentID
+	if ref.root != "" {
+		root = ref.root
+	}
+	r, ok := ix.roots[root]
+	return root, ok && !r.Deleted
+}
+
+// add учитывает сообщение; ReplyTo ответа уже указывает на корень
+func (ix *threadIndex) add(m Message) {
+	ix.refs[m.ID] = msgRef{root: m.ReplyTo, author: m.User.ID, createdAt: m.CreatedAt, deleted: m.Deleted}
+	if m.ReplyTo == "" {
+		ix.roots[m.ID] = rootPreview(m)
+		return
+	}
+	root, ok := ix.roots[m.ReplyTo]
+	if !ok || m.Deleted {
+		return
+	}
+	t := ix.threads[m.ReplyTo]
+	if t == nil {
+		t = &threadEntry{root: root, participants: make(map[string]*participant)}
+		ix.threads[m.ReplyTo] = t
+	}
+	t.replies = append(t.replies, threadReply{id: m.ID, user: m.User, createdAt: m.CreatedAt})
+	p := t.participants[m.User.ID]
+	if p == nil {
+		p = &participant{user: m.User}
+		t.participants[m.User.ID] = p
+	}
+	p.replies++
+}
+
+// edit обновляет превью изменённого корня
+func (ix *threadIndex) edit(m Message) {
+	if m.ReplyTo != "" {
+		return
+	}
+	if _, ok := ix.roots[m.ID]; !ok {
+		return
+	}
+	ix.roots[m.ID] = rootPreview(m)
+	if t := ix.threads[m.ID]; t != nil {
+		t.root = ix.roots[m.ID]
+	}
+}
+
+// remove — сообщение удалено: корень остаётся пустым превью, ответ
+// выпадает из ветки
+func (ix *threadIndex) remove(m Message) {
+	ref, ok := ix.refs[m.ID]
+	if !ok {
+		return
+	}
+	ref.deleted = true
+	ix.refs[m.ID] = ref
+	if ref.root == "" {
+		ix.edit(m)
+		return
+	}
+	ix.dropReply(ref.root, m.ID)
+}
+
+// evict — сообщение вытеснено из буфера
+func (ix *threadIndex) evict(m Message) {
+	ref, ok := ix.refs[m.ID]
+	if !ok {
+		return
+	}
+	delete(ix.refs, m.ID)
+	if ref.root == "" {
+		delete(ix.roots, m.ID)
+		delete(ix.threads, m.ID)
+		return
+	}
+	ix.dropReply(ref.root, m.ID)
+}
+
+func (ix *threadIndex) dropReply(rootID, id string) {
+	t := ix.threads[rootID]
+	if t == nil {
+		return
+	}
+	for i, r := range t.replies {
+		if r.id != id {
+			continue
+		}
+		t.replies = append(t.replies[:i], t.replies[i+1:]...)
+		if p := t.participants[r.user.ID]; p != nil {
+			if p.replies--; p.replies == 0 {
+				delete(t.participants, r.user.ID)
+			}
+		}
+		break
+	}
+	if len(t.replies) == 0 {
+		delete(ix.threads, rootID)
+	}
+}
+
+func (t *threadEntry) summary() ThreadSummary {
+	users := make([]User, 0, len(t.participants))
+	for _, p := range t.participants {
+		users = append(users, p.user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name != users[j].Name {
+			return users[i].Name < users[j].Name
+		}
+		return users[i].ID < users[j].ID
+	})
+	return ThreadSummary{
+		Root:         t.root,
+		ReplyCount:   len(t.replies),
+		LastReplyAt:  t.replies[len(t.replies)-1].createdAt,
+		Participants: users,
+	}
+}
+
+// page — ветки по последней активности (новые первыми), offset/limit
+func (ix *threadIndex) page(offset, limit int) ([]ThreadSummary, int) {
+	entries := make([]*threadEntry, 0, len(ix.threads))
+	for _, t := range ix.threads {
+		entries = append(entries, t)
+	}
+	last := func(t *threadEntry) time.Time { return t.replies[len(t.replies)-1].createdAt }
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := last(entries[i]), last(entries[j])
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return entries[i].root.ID > entries[j].root.ID
+	})
+	total := len(entries)
+	entries = entries[min(offset, total):min(offset+limit, total)]
+	out := make([]ThreadSummary, len(entries))
+	for i, t := range entries {
+		out[i] = t.summary()
+	}
+	return out, total
+}
+
+// unreadIn — ответов в ветке новее at
+func (t *threadEntry) unreadIn(at time.Time) int {
+	i := sort.Search(len(t.replies), func(i int) bool { return t.replies[i].createdAt.After(at) })
+	return len(t.replies) - i
+}
+
+// ---------- READ MARKS ----------
+
+// readMarks — до какого момента пользователь прочитал комнату и каждую
+// ветку. Отметки только растут; своё сообщение сдвигает отметку автора.
+type readMarks struct {
+	room    time.Time
+	threads map[string]time.Time
+}
+
+func (s *ChatService) marksLocked(userID string) *readMarks {
+	rm := s.reads[userID]
+	if rm == nil {
+		rm = &readMarks{threads: make(map[string]time.Time)}
+		s.reads[userID] = rm
+	}
+	return rm
+}
+
+// markReadLocked сдвигает отметку комнаты (thread == "") или ветки
+func (s *ChatService) markReadLocked(userID, thread string, at time.Time) {
+	rm := s.marksLocked(userID)
+	if thread == "" {
+		if at.After(rm.room) {
+			rm.room = at
+		}
+		return
+	}
+	if at.After(rm.threads[thread]) {
+		rm.threads[thread] = at
+	}
+}
+
+// MarkRead отмечает прочитанным всё до сообщения id (пустой — до текущего
+// момента) в комнате или ветке thread
+func (s *ChatService) MarkRead(userID, thread, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at := time.Now().UTC()
+	if id != "" {
+		ref, ok := s.threads.refs[id]
+		if !ok || ref.root != thread {
+			return errNoMessage
+		}
+		at = ref.createdAt
+	} else if _, ok := s.threads.roots[thread]; thread != "" && !ok {
+		return errNoMessage
+	}
+	s.markReadLocked(userID, thread, at)
+	return nil
+}
+
+// Unread — непрочитанные корневые сообщения комнаты и ответы по веткам.
+// Считаются ветки, за которыми пользователь следит: свои, с его ответами
+// или уже открытые им.
+type Unread struct {
+	Room     string         `json:"room"`
+	Messages int            `json:"messages"`
+	Threads  map[string]int `json:"threads"`
+}
+
+func (s *ChatService) Unread(userID string) Unread {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rm := s.marksLocked(userID)
+	out := Unread{Room: globalRoom, Threads: make(map[string]int)}
+	for i := len(s.messages) - 1; i >= 0 && s.messages[i].CreatedAt.After(rm.room); i-- {
+		if m := s.messages[i]; m.ReplyTo == "" && !m.Deleted {
+			out.Messages++
+		}
+	}
+	for id, t := range s.threads.threads {
+		mark, opened := rm.threads[id]
+		if !opened && t.root.User.ID != userID && t.participants[userID] == nil {
+			continue
+		}
+		if n := t.unreadIn(mark); n > 0 {
+			out.Threads[id] = n
+		}
+	}
+	return out
+}
+
+// Threads — страница списка веток и их общее число
+func (s *ChatService) Threads(offset, limit int) ([]ThreadSummary, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.threads.page(offset, limit)
+}
+
+// ---------- HTTP ----------
+
+// roomParam — пока комната одна: пустой room или globalRoom, иначе 404
+func roomParam(w http.ResponseWriter, r *http.Request) bool {
+	if room := r.URL.Query().Get("room"); room != "" && room != globalRoom {
+		http.Error(w, "unknown room", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func intParam(r *http.Request, name string, def int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n >= 0
+}
+
+// ThreadsHandler — GET /threads?room=&offset=&limit=
+func (s *ChatService) ThreadsHandler(w http.ResponseWriter, r *http.Request) {
+	if !roomParam(w, r) {
+		return
+	}
+	offset, ok1 := intParam(r, "offset", 0)
+	limit, ok2 := intParam(r, "limit", defaultThreadLimit)
+	if !ok1 || !ok2 || limit == 0 {
+		http.Error(w, "bad offset or limit", http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, maxThreadLimit)
+	threads, total := s.Threads(offset, limit)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"room":    globalRoom,
+		"total":   total,
+		"offset":  offset,
+		"threads": threads,
+	})
+}
+
+// UnreadHandler — GET /unread?room=&user=
+func (s *ChatService) UnreadHandler(w http.ResponseWriter, r *http.Request) {
+	if !roomParam(w, r) {
+		return
+	}
+	user := r.URL.Query().Get("user")
+	if user == "" {
+		http.Error(w, "user required", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Unread(user))
+}
+
+// ReadHandler — POST /read {"user":"u1","thread":"<id корня>","id":"<id сообщения>"};
+// без thread — отметка комнаты, без id — всё до текущего момента
+func (s *ChatService) ReadHandler(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		User   string `json:"user"`
+		Thread string `json:"thread"`
+		ID     string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || strings.TrimSpace(in.User) == "" {
+		http.Error(w, "invalid json or user", http.StatusBadRequest)
+		return
+	}
+	if err := s.MarkRead(in.User, in.Thread, in.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
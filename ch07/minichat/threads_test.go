@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var threadT0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// post добавляет сообщение (ответ, если replyTo задан) с временем t0+minute минут
+func post(t *testing.T, s *ChatService, id, user, replyTo string, minute int) {
+	t.Helper()
+	m := Message{ID: id, User: User{ID: user, Name: user}, Text: "текст " + id, CreatedAt: threadT0.Add(time.Duration(minute) * time.Minute), ReplyTo: replyTo}
+	if replyTo == "" {
+		s.AddMessage(m)
+		return
+	}
+	if _, err := s.AddReply(m); err != nil {
+		t.Fatalf("reply %s -> %s: %v", id, replyTo, err)
+	}
+}
+
+// checkIndex сверяет инкрементальный индекс с построенным заново по истории
+func checkIndex(t *testing.T, s *ChatService, step string) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rebuilt := rebuildThreads(s.messages); !reflect.DeepEqual(s.threads, rebuilt) {
+		got, _ := s.threads.page(0, 100)
+		want, _ := rebuilt.page(0, 100)
+		t.Fatalf("%s: index diverged from history\ngot  %+v\nwant %+v", step, got, want)
+	}
+}
+
+func threadByRoot(s *ChatService, id string) (ThreadSummary, bool) {
+	threads, _ := s.Threads(0, 100)
+	for _, th := range threads {
+		if th.Root.ID == id {
+			return th, true
+		}
+	}
+	return ThreadSummary{}, false
+}
+
+func TestThreadIndexAfterDeletes(t *testing.T) {
+	s := NewChatService(100)
+	post(t, s, "r1", "ann", "", 0)
+	post(t, s, "r2", "bob", "", 1)
+	post(t, s, "a1", "bob", "r1", 2)
+	post(t, s, "a2", "cat", "a1", 3) // ответ на ответ — в ту же ветку
+	post(t, s, "a3", "bob", "r1", 4)
+	post(t, s, "b1", "ann", "r2", 5)
+	checkIndex(t, s, "initial")
+
+	th, _ := threadByRoot(s, "r1")
+	if th.ReplyCount != 3 || !th.LastReplyAt.Equal(threadT0.Add(4*time.Minute)) || len(th.Participants) != 2 {
+		t.Fatalf("r1 thread: %+v", th)
+	}
+
+	// Удаление последнего ответа: счётчик и последняя активность назад
+	if _, err := s.DeleteMessage("bob", "a3"); err != nil {
+		t.Fatal(err)
+	}
+	checkIndex(t, s, "reply deleted")
+	th, _ = threadByRoot(s, "r1")
+	if th.ReplyCount != 2 || !th.LastReplyAt.Equal(threadT0.Add(3*time.Minute)) {
+		t.Fatalf("after reply delete: %+v", th)
+	}
+
+	// Удалённый корень оставляет ветку, пока в ней есть ответы
+	if _, err := s.DeleteMessage("bob", "r1"); err != errNotAuthor {
+		t.Fatalf("foreign delete: %v", err)
+	}
+	if _, err := s.DeleteMessage("ann", "r1"); err != nil {
+		t.Fatal(err)
+	}
+	checkIndex(t, s, "root deleted")
+	th, ok := threadByRoot(s, "r1")
+	if !ok || !th.Root.Deleted || th.Root.Preview != "" || th.ReplyCount != 2 {
+		t.Fatalf("after root delete: %+v", th)
+	}
+	if _, err := s.AddReply(Message{ID: "x", User: User{ID: "bob"}, Text: "ещё", ReplyTo: "a1"}); err != errNoMessage {
+		t.Fatalf("reply into deleted root: %v", err)
+	}
+
+	// Последний ответ удалён — ветки больше нет; участник ушёл вместе с ним
+	s.DeleteMessage("bob", "a1")
+	th, _ = threadByRoot(s, "r1")
+	if len(th.Participants) != 1 || th.Participants[0].ID != "cat" {
+		t.Fatalf("participants after delete: %+v", th.Participants)
+	}
+	s.DeleteMessage("cat", "a2")
+	checkIndex(t, s, "all replies deleted")
+	if _, ok := threadByRoot(s, "r1"); ok {
+		t.Fatal("thread without replies should be gone")
+	}
+	if _, total := s.Threads(0, 100); total != 1 {
+		t.Fatalf("total threads = %d, want 1", total)
+	}
+}
+
+func TestThreadIndexEvictionAndEdit(t *testing.T) {
+	s := NewChatService(4)
+	post(t, s, "r1", "ann", "", 0)
+	post(t, s, "a1", "bob", "r1", 1)
+	if _, err := s.EditMessage("ann", "r1", "новый текст"); err != nil {
+		t.Fatal(err)
+	}
+	checkIndex(t, s, "edited")
+	if th, _ := threadByRoot(s, "r1"); th.Root.Preview != "новый текст" {
+		t.Fatalf("preview after edit: %q", th.Root.Preview)
+	}
+
+	post(t, s, "r2", "bob", "", 2)
+	post(t, s, "b1", "ann", "r2", 3)
+	post(t, s, "r3", "cat", "", 4) // вытесняет r1
+	checkIndex(t, s, "root evicted")
+	if _, ok := threadByRoot(s, "r1"); ok {
+		t.Fatal("evicted root should take its thread along")
+	}
+	post(t, s, "r4", "cat", "", 5) // вытесняет a1
+	checkIndex(t, s, "orphan reply evicted")
+}
+
+func TestThreadsPaginationAndUnread(t *testing.T) {
+	s := NewChatService(100)
+	post(t, s, "r1", "ann", "", 0)
+	post(t, s, "r2", "ann", "", 1)
+	post(t, s, "r3", "bob", "", 2)
+	post(t, s, "a1", "bob", "r1", 3)
+	post(t, s, "a2", "bob", "r2", 4)
+	post(t, s, "a3", "cat", "r3", 5)
+	post(t, s, "a4", "bob", "r1", 6)
+
+	srv := httptest.NewServer(http.HandlerFunc(s.ThreadsHandler))
+	defer srv.Close()
+	var page struct {
+		Total   int             `json:"total"`
+		Threads []ThreadSummary `json:"threads"`
+	}
+	resp, err := http.Get(srv.URL + "?room=global&offset=1&limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	// По последней активности: r1 (6), r3 (5), r2 (4)
+	if page.Total != 3 || len(page.Threads) != 1 || page.Threads[0].Root.ID != "r3" {
+		t.Fatalf("page: %+v", page)
+	}
+	if resp, _ := http.Get(srv.URL + "?room=other"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown room: %d", resp.StatusCode)
+	}
+
+	// ann — автор r1 и r2: все ответы в них не прочитаны; r3 её не касается
+	u := s.Unread("ann")
+	if u.Messages != 1 || !reflect.DeepEqual(u.Threads, map[string]int{"r1": 2, "r2": 1}) {
+		t.Fatalf("ann unread: %+v", u)
+	}
+	if err := s.MarkRead("ann", "r1", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkRead("ann", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	u = s.Unread("ann")
+	if u.Messages != 0 || !reflect.DeepEqual(u.Threads, map[string]int{"r1": 1, "r2": 1}) {
+		t.Fatalf("ann unread after marks: %+v", u)
+	}
+	// Свой ответ сдвигает отметку ветки
+	post(t, s, "a5", "ann", "r2", 7)
+	if u = s.Unread("ann"); u.Threads["r2"] != 0 {
+		t.Fatalf("own reply should mark r2 read: %+v", u)
+	}
+	if err := s.MarkRead("ann", "r2", "a1"); err != errNoMessage {
+		t.Fatalf("mark with a reply from another thread: %v", err)
+	}
+}
+
+func TestThreadsRebuiltFromHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s := NewChatService(100)
+	if err := s.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	post(t, s, "r1", "ann", "", 0)
+	post(t, s, "a1", "bob", "r1", 1)
+	post(t, s, "a2", "cat", "r1", 2)
+	post(t, s, "r2", "bob", "", 3)
+	post(t, s, "b1", "ann", "r2", 4)
+	s.EditMessage("ann", "r1", "правка")
+	s.DeleteMessage("cat", "a2")
+	s.DeleteMessage("bob", "r2")
+
+	again := NewChatService(100)
+	if err := again.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.GetMessages(), s.GetMessages()) {
+		t.Fatalf("history differs after reload:\n%+v\n%+v", again.GetMessages(), s.GetMessages())
+	}
+	if !reflect.DeepEqual(again.threads, s.threads) {
+		t.Fatal("thread index differs after reload")
+	}
+	if th, _ := threadByRoot(again, "r1"); th.Root.Preview != "правка" || th.ReplyCount != 1 {
+		t.Fatalf("reloaded r1: %+v", th)
+	}
+}